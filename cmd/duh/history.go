@@ -0,0 +1,249 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"shellserver/internal/persist"
+	"shellserver/internal/styles"
+)
+
+// scan is one cached run of duh over a root: its total and the size of
+// each top-level entry.
+type scan struct {
+	Time  time.Time        `json:"time"`
+	Total int64            `json:"total"`
+	Sizes map[string]int64 `json:"sizes"`
+}
+
+// scanHistory is the -cache file of a root, oldest scan first.
+type scanHistory struct {
+	Root  string `json:"root"`
+	Scans []scan `json:"scans"`
+}
+
+// newScan records root's sizes as of now. Callers skip interrupted walks,
+// whose sizes are only lower bounds.
+func newScan(root *dirEntry, now time.Time) scan {
+	s := scan{Time: now, Total: root.size, Sizes: make(map[string]int64, len(root.children))}
+	for _, child := range root.children {
+		s.Sizes[child.name] = child.size
+	}
+	return s
+}
+
+// historyPath is where the scans of absDir are cached: one file per root
+// under the user's cache directory, named by a hash of the path.
+func historyPath(absDir string) (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(absDir))
+	return filepath.Join(dir, "goshell", "duh", hex.EncodeToString(sum[:8])+".json"), nil
+}
+
+// loadHistory reads the history at path; a missing file is an empty
+// history of absDir.
+func loadHistory(path, absDir string) (*scanHistory, error) {
+	h := &scanHistory{Root: absDir}
+	if err := persist.Load(path, h); errors.Is(err, fs.ErrNotExist) {
+		return h, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if h.Root != absDir {
+		// A hash collision; start over rather than mix two roots
+		return &scanHistory{Root: absDir}, nil
+	}
+	return h, nil
+}
+
+// save writes h to path, replacing the file atomically so a concurrent
+// duh never reads half of it, nor a crash leaves half of it behind.
+func (h *scanHistory) save(path string) error {
+	return persist.Save(path, h)
+}
+
+// record appends s, dropping the oldest scans beyond keep.
+func (h *scanHistory) record(s scan, keep int) {
+	h.Scans = append(h.Scans, s)
+	if keep > 0 && len(h.Scans) > keep {
+		h.Scans = append([]scan(nil), h.Scans[len(h.Scans)-keep:]...)
+	}
+}
+
+// recordScan adds root's sizes to absDir's cached history, keeping keep
+// scans, and returns the history. Cache errors are reported and leave the
+// widget without trends.
+func recordScan(root *dirEntry, absDir string, keep int) *scanHistory {
+	path, err := historyPath(absDir)
+	if err == nil {
+		var h *scanHistory
+		if h, err = loadHistory(path, absDir); err == nil {
+			if root.interrupted {
+				return h
+			}
+			h.record(newScan(root, time.Now()), keep)
+			if err = h.save(path); err == nil {
+				return h
+			}
+		}
+	}
+	fmt.Fprintf(os.Stderr, "duh: cache: %v\n", err)
+	return nil
+}
+
+// showHistory emits the -history widget for absDir and returns the exit
+// status.
+func showHistory(absDir string, units styles.SizeUnits, key string) int {
+	path, err := historyPath(absDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "duh: cache: %v\n", err)
+		return 1
+	}
+	h, err := loadHistory(path, absDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "duh: cache: %v\n", err)
+		return 1
+	}
+	if len(h.Scans) == 0 {
+		fmt.Fprintf(os.Stderr, "duh: no cached scans of '%s'; run duh -cache first\n", absDir)
+		return 1
+	}
+	if key == "" {
+		key = styles.NewWidgetKey("duh")
+	}
+	fmt.Print(styles.HTMLStartWithKey(key))
+	fmt.Print(renderHistoryHTML(h, units))
+	fmt.Println(styles.HTMLEnd)
+	return 0
+}
+
+// hasTrend reports whether there are enough scans to show a trend.
+func (h *scanHistory) hasTrend() bool {
+	return h != nil && len(h.Scans) >= 2
+}
+
+// series is the size of the top-level entry name in each scan, oldest
+// first; scans from before it existed count it as 0.
+func (h *scanHistory) series(name string) []int64 {
+	values := make([]int64, len(h.Scans))
+	for i, s := range h.Scans {
+		values[i] = s.Sizes[name]
+	}
+	return values
+}
+
+// totals is the root's total size in each scan, oldest first.
+func (h *scanHistory) totals() []int64 {
+	values := make([]int64, len(h.Scans))
+	for i, s := range h.Scans {
+		values[i] = s.Total
+	}
+	return values
+}
+
+// formatDelta formats a change in size with its sign.
+func formatDelta(delta int64, units styles.SizeUnits) string {
+	switch {
+	case delta > 0:
+		return "+" + styles.FormatSizeIn(delta, units)
+	case delta < 0:
+		return "−" + styles.FormatSizeIn(-delta, units)
+	}
+	return "±0"
+}
+
+// deltaClass colors a delta: growth red, shrinkage green.
+func deltaClass(delta int64) string {
+	switch {
+	case delta > 0:
+		return "duh-grew"
+	case delta < 0:
+		return "duh-shrank"
+	}
+	return "duh-same"
+}
+
+// trendCell is the sparkline and delta-since-last-scan cell of the
+// top-level entry name.
+func trendCell(h *scanHistory, name string, units styles.SizeUnits) string {
+	values := h.series(name)
+	delta := values[len(values)-1] - values[len(values)-2]
+	label := fmt.Sprintf("%s over the last %d scans", name, len(values))
+	return styles.Sparkline(values, label) +
+		fmt.Sprintf(` <span class="duh-delta %s">%s</span>`, deltaClass(delta), formatDelta(delta, units))
+}
+
+// historyCSS styles the trend column and the -history view.
+func historyCSS() string {
+	return `
+.duh-delta {
+	display: inline-block;
+	min-width: 72px;
+	text-align: right;
+	font-size: 11px;
+}
+.duh-grew {
+	color: ` + styles.Colors.Red + `;
+}
+.duh-shrank {
+	color: ` + styles.Colors.Green + `;
+}
+.duh-same {
+	color: ` + styles.Colors.TextGray + `;
+}
+.duh-history {
+	border-collapse: collapse;
+	font-size: 12px;
+}
+.duh-history th, .duh-history td {
+	padding: 1px 10px 1px 0;
+	text-align: right;
+}
+.duh-history th:first-child, .duh-history td:first-child {
+	text-align: left;
+}
+`
+}
+
+// renderHistoryHTML is the duh -history view: the root's total size over
+// the cached scans, newest first.
+func renderHistoryHTML(h *scanHistory, units styles.SizeUnits) string {
+	var html strings.Builder
+	totals := h.totals()
+
+	html.WriteString(`<style>`)
+	html.WriteString(styles.BaseCSS())
+	html.WriteString(historyCSS())
+	html.WriteString(`</style>
+<div class="shell-container">
+<div class="shell-header">
+<div class="shell-title">` + styles.HTMLEscape(h.Root) + `</div>
+<div>` + styles.Sparkline(totals, fmt.Sprintf("total size over the last %d scans", len(totals))) + `</div>
+</div>
+<table class="duh-history">
+<thead><tr><th scope="col">Scanned</th><th scope="col">Total</th><th scope="col">Change</th></tr></thead>
+<tbody>
+`)
+	for i := len(h.Scans) - 1; i >= 0; i-- {
+		s := h.Scans[i]
+		change := ""
+		if i > 0 {
+			delta := s.Total - h.Scans[i-1].Total
+			change = fmt.Sprintf(`<span class="duh-delta %s">%s</span>`, deltaClass(delta), formatDelta(delta, units))
+		}
+		html.WriteString(fmt.Sprintf("<tr><td>%s</td><td>%s</td><td>%s</td></tr>\n",
+			s.Time.Local().Format("2006-01-02 15:04:05"), styles.FormatSizeIn(s.Total, units), change))
+	}
+	html.WriteString("</tbody>\n</table>\n</div>\n")
+	return html.String()
+}
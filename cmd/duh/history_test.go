@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"shellserver/internal/styles"
+)
+
+// seedHistory caches synthetic scans of root, oldest first, an hour apart.
+func seedHistory(t *testing.T, root string, scans ...map[string]int64) string {
+	t.Helper()
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	path, err := historyPath(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := &scanHistory{Root: root}
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, sizes := range scans {
+		var total int64
+		for _, size := range sizes {
+			total += size
+		}
+		h.record(scan{Time: start.Add(time.Duration(i) * time.Hour), Total: total, Sizes: sizes}, 0)
+	}
+	if err := h.save(path); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestRecordScanTrends(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]int{"a/x": 300, "b/y": 100, "c/z": 50})
+	seedHistory(t, root,
+		map[string]int64{"a": 100, "b": 200},
+		map[string]int64{"a": 200, "b": 200},
+	)
+
+	tree := buildTree(context.Background(), root, -1, false, nil, 0)
+	h := recordScan(tree, root, 30)
+	if h == nil || len(h.Scans) != 3 {
+		t.Fatalf("history after recording = %+v, want 3 scans", h)
+	}
+	for name, want := range map[string][]int64{
+		"a": {100, 200, 300},
+		"b": {200, 200, 100},
+		"c": {0, 0, 50},
+	} {
+		if got := h.series(name); !reflect.DeepEqual(got, want) {
+			t.Errorf("series(%q) = %v, want %v", name, got, want)
+		}
+	}
+	if got, want := h.totals(), []int64{300, 400, 450}; !reflect.DeepEqual(got, want) {
+		t.Errorf("totals = %v, want %v", got, want)
+	}
+
+	html := renderHTML(tree, root, styles.BinaryUnits, h, nil)
+	for _, want := range []string{
+		`tree-cell trend`,
+		`aria-label="a over the last 3 scans">▁▄█<`,
+		`<span class="duh-delta duh-grew">+100 B</span>`,
+		`<span class="duh-delta duh-shrank">−100 B</span>`,
+		`<span class="duh-delta duh-grew">+50 B</span>`,
+	} {
+		if !strings.Contains(html, want) {
+			t.Errorf("rendered tree missing %q", want)
+		}
+	}
+
+	// The recording was saved for the next run
+	path, _ := historyPath(root)
+	saved, err := loadHistory(path, root)
+	if err != nil || len(saved.Scans) != 3 {
+		t.Errorf("saved history = %+v, %v; want 3 scans", saved, err)
+	}
+}
+
+func TestRecordScanNeedsTwoScans(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]int{"a/x": 10})
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+
+	tree := buildTree(context.Background(), root, -1, false, nil, 0)
+	h := recordScan(tree, root, 30)
+	if h.hasTrend() {
+		t.Error("one scan has a trend")
+	}
+	if html := renderHTML(tree, root, styles.BinaryUnits, h, nil); strings.Contains(html, "trend") {
+		t.Error("trend column shown for a single scan")
+	}
+}
+
+func TestRecordScanSkipsInterrupted(t *testing.T) {
+	root := t.TempDir()
+	seedHistory(t, root, map[string]int64{"a": 1})
+	h := recordScan(&dirEntry{path: root, interrupted: true}, root, 30)
+	if len(h.Scans) != 1 {
+		t.Errorf("interrupted walk recorded: %d scans", len(h.Scans))
+	}
+}
+
+func TestHistoryRetention(t *testing.T) {
+	h := &scanHistory{}
+	for i := int64(1); i <= 5; i++ {
+		h.record(scan{Total: i}, 3)
+	}
+	if got, want := h.totals(), []int64{3, 4, 5}; !reflect.DeepEqual(got, want) {
+		t.Errorf("totals after retention = %v, want %v", got, want)
+	}
+}
+
+func TestRenderHistoryHTML(t *testing.T) {
+	root := t.TempDir()
+	path := seedHistory(t, root,
+		map[string]int64{"a": 1000},
+		map[string]int64{"a": 3000},
+		map[string]int64{"a": 2000},
+	)
+	h, err := loadHistory(path, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	html := renderHistoryHTML(h, styles.BinaryUnits)
+	for _, want := range []string{
+		`aria-label="total size over the last 3 scans">▁█▄<`,
+		time.Date(2026, 1, 1, 2, 0, 0, 0, time.UTC).Local().Format("2006-01-02 15:04:05"),
+		`<span class="duh-delta duh-shrank">−1000 B</span>`,
+		`<span class="duh-delta duh-grew">+2.0 KiB</span>`,
+	} {
+		if !strings.Contains(html, want) {
+			t.Errorf("history view missing %q", want)
+		}
+	}
+	// Newest scan first
+	if strings.Index(html, "2.0 KiB</td>") > strings.Index(html, "1000 B</td>") {
+		t.Error("history view is not newest first")
+	}
+}
@@ -1,28 +1,72 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
+	"time"
 
+	"shellserver/internal/freshness"
+	"shellserver/internal/ignore"
 	"shellserver/internal/styles"
+	"shellserver/pkg/widgetcli"
 )
 
 type dirEntry struct {
-	path     string
-	name     string
-	size     int64
-	isDir    bool
-	children []*dirEntry
+	path        string
+	name        string
+	size        int64
+	isDir       bool
+	interrupted bool // walk stopped early; size is a lower bound
+	truncated   bool // a directory at the depth limit, with entries not listed
+	children    []*dirEntry
+
+	// With -gitignore, entries git ignores are left out of size. ignored
+	// holds the direct children that were; ignoredSize and ignoredCount
+	// total them over the whole subtree.
+	ignored      []*dirEntry
+	ignoredSize  int64
+	ignoredCount int
+}
+
+var tool = widgetcli.Tool{
+	Name:    "duh",
+	Summary: "show disk usage as an expandable HTML tree",
+	Usage:   "[directory]",
+	Examples: []widgetcli.Example{
+		{Args: []string{"-d", "1"}, Description: "sizes of the top-level entries only"},
+		{Args: []string{"-a", "-si"}, Description: "include hidden files, sizes in kB and MB"},
+		{Args: []string{"-watch"}, Description: "keep the widget updated as the directory changes"},
+		{Args: []string{"-gitignore"}, Description: "leave build output and other ignored files out of the totals"},
+		{Args: []string{"-cache"}, Description: "remember this scan and show each directory's size trend"},
+		{Args: []string{"-history"}, Description: "total size over the cached scans"},
+	},
 }
 
 func main() {
 	maxDepth := flag.Int("d", -1, "max depth to traverse (-1 for unlimited)")
 	showAll := flag.Bool("a", false, "include hidden files")
-	flag.Parse()
+	gitignore := flag.Bool("gitignore", false, "exclude files git ignores from the sizes, totalling them in one \"ignored\" row")
+	watch := flag.Bool("watch", false, "keep running and update the widget in place as the directory changes")
+	watchInterval := flag.Duration("watch-interval", time.Second, "minimum time between widget updates in watch mode")
+	watchMax := flag.Duration("watch-max", time.Hour, "stop watching after this long (0 for no limit)")
+	cache := flag.Bool("cache", false, "record this scan and, once there are two, show each top-level entry's size trend and change since the last scan")
+	cacheKeep := flag.Int("cache-keep", 30, "scans -cache keeps per directory")
+	history := flag.Bool("history", false, "show the directory's total size over the scans -cache recorded, without scanning")
+	key := flag.String("key", "", "replace the widget previously emitted with this key instead of adding one")
+	si := flag.Bool("si", styles.SizeUnitsFromEnv() == styles.SIUnits, "show sizes in powers of 1000 (kB, MB) instead of 1024 (KiB, MiB); default from GOSHELL_SI")
+	tool.Parse(os.Args[1:])
+
+	units := styles.BinaryUnits
+	if *si {
+		units = styles.SIUnits
+	}
 
 	dir := "."
 	if flag.NArg() > 0 {
@@ -34,22 +78,80 @@ func main() {
 		absDir = dir
 	}
 
+	if *history {
+		os.Exit(showHistory(absDir, units, *key))
+	}
+
+	var ign *ignore.Matcher
+	if *gitignore {
+		if ign, err = ignore.ForDir(absDir); err != nil {
+			fmt.Fprintf(os.Stderr, "duh: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	// Ctrl-C stops the walk; whatever was gathered is still rendered
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
 	// Build the tree and calculate sizes
-	root := buildTree(absDir, *maxDepth, *showAll, 0)
+	root := buildTree(ctx, absDir, *maxDepth, *showAll, ign, 0)
 	if root == nil {
 		fmt.Fprintf(os.Stderr, "duh: cannot access '%s'\n", dir)
 		os.Exit(1)
 	}
 
+	// Output is keyed so the stale-listing banner can refresh it in place
+	if *key == "" {
+		*key = styles.NewWidgetKey("duh")
+	}
+	refresh := refreshCommand(*maxDepth, *showAll, *gitignore, *cache, units, *key, absDir)
+	levels := max(*maxDepth, 1)
+	more := func(path string) *styles.TreeNode {
+		return styles.MoreLevelsNode(levels, moreCommand(levels, *showAll, *gitignore, *cache, units, path))
+	}
+
+	if *watch {
+		if *watchMax > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, *watchMax)
+			defer cancel()
+		}
+		runWatch(ctx, root, absDir, watchOptions{
+			maxDepth: *maxDepth,
+			showAll:  *showAll,
+			ignore:   ign,
+			interval: *watchInterval,
+			units:    units,
+			key:      *key,
+			refresh:  refresh,
+			more:     more,
+		})
+		return
+	}
+
+	var hist *scanHistory
+	if *cache {
+		hist = recordScan(root, absDir, *cacheKeep)
+	}
+
 	// Render HTML
-	fmt.Print(styles.HTMLStart)
-	renderHTML(root, absDir)
+	fmt.Print(styles.HTMLStartWithKey(*key))
+	fmt.Print(freshnessMarker(absDir, refresh))
+	fmt.Print(renderHTML(root, absDir, units, hist, more))
 	os.Stdout.Sync()
 	fmt.Println(styles.HTMLEnd)
 	os.Stdout.Sync()
+
+	if root.interrupted {
+		fmt.Fprintln(os.Stderr, "duh: interrupted, sizes are partial")
+		os.Exit(130)
+	}
 }
 
-func buildTree(path string, maxDepth int, showAll bool, currentDepth int) *dirEntry {
+// buildTree sizes path, keeping per-entry sizes down to maxDepth. Entries
+// ign matches are totalled separately rather than counted; ign may be nil.
+func buildTree(ctx context.Context, path string, maxDepth int, showAll bool, ign *ignore.Matcher, currentDepth int) *dirEntry {
 	info, err := os.Stat(path)
 	if err != nil {
 		return nil
@@ -66,6 +168,11 @@ func buildTree(path string, maxDepth int, showAll bool, currentDepth int) *dirEn
 		return entry
 	}
 
+	if ctx.Err() != nil {
+		entry.interrupted = true
+		return entry
+	}
+
 	// It's a directory - read contents
 	entries, err := os.ReadDir(path)
 	if err != nil {
@@ -75,7 +182,13 @@ func buildTree(path string, maxDepth int, showAll bool, currentDepth int) *dirEn
 	// Check depth limit
 	if maxDepth >= 0 && currentDepth >= maxDepth {
 		// Just calculate size without building children
-		entry.size = calcDirSize(path, showAll)
+		calcDirSize(ctx, entry, showAll, ign)
+		for _, e := range entries {
+			if showAll || !strings.HasPrefix(e.Name(), ".") {
+				entry.truncated = true
+				break
+			}
+		}
 		return entry
 	}
 
@@ -85,50 +198,166 @@ func buildTree(path string, maxDepth int, showAll bool, currentDepth int) *dirEn
 		if !showAll && strings.HasPrefix(name, ".") {
 			continue
 		}
+		if ctx.Err() != nil {
+			entry.interrupted = true
+			break
+		}
 
 		childPath := filepath.Join(path, name)
-		child := buildTree(childPath, maxDepth, showAll, currentDepth+1)
+		if ign.Ignored(childPath, e.IsDir()) {
+			if child := ignoredEntry(ctx, childPath, showAll); child != nil {
+				entry.ignored = append(entry.ignored, child)
+				entry.ignoredSize += child.size
+				entry.ignoredCount++
+				if child.interrupted {
+					entry.interrupted = true
+				}
+			}
+			continue
+		}
+		child := buildTree(ctx, childPath, maxDepth, showAll, ign, currentDepth+1)
 		if child != nil {
 			entry.children = append(entry.children, child)
 			totalSize += child.size
+			entry.ignoredSize += child.ignoredSize
+			entry.ignoredCount += child.ignoredCount
+			if child.interrupted {
+				entry.interrupted = true
+			}
 		}
 	}
 
-	// Sort children by size (largest first)
-	sort.Slice(entry.children, func(i, j int) bool {
-		return entry.children[i].size > entry.children[j].size
-	})
+	sortBySize(entry.children)
 
 	entry.size = totalSize
 	return entry
 }
 
-func calcDirSize(path string, showAll bool) int64 {
-	var size int64
+// sortBySize orders entries largest first.
+func sortBySize(entries []*dirEntry) {
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].size > entries[j].size
+	})
+}
+
+// ignoredEntry sizes an entry git ignores, ignoring nothing below it.
+func ignoredEntry(ctx context.Context, path string, showAll bool) *dirEntry {
+	return buildTree(ctx, path, 0, showAll, nil, 0)
+}
+
+// calcDirSize sums the file sizes under entry's path into its size,
+// marking it interrupted if ctx cut the walk short, which makes the size a
+// lower bound. Entries ign matches go to the ignored totals instead.
+func calcDirSize(ctx context.Context, entry *dirEntry, showAll bool, ign *ignore.Matcher) {
+	path := entry.path
 	filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
-		if err != nil {
+		if ctx.Err() != nil {
+			entry.interrupted = true
+			return filepath.SkipAll
+		}
+		if err != nil || p == path {
 			return nil
 		}
-		if !showAll && strings.HasPrefix(info.Name(), ".") && p != path {
+		if !showAll && strings.HasPrefix(info.Name(), ".") {
 			if info.IsDir() {
 				return filepath.SkipDir
 			}
 			return nil
 		}
+		if ign.Ignored(p, info.IsDir()) {
+			entry.ignoredCount++
+			if !info.IsDir() {
+				entry.ignoredSize += info.Size()
+				return nil
+			}
+			sub := &dirEntry{path: p}
+			calcDirSize(ctx, sub, showAll, nil)
+			entry.ignoredSize += sub.size
+			if sub.interrupted {
+				entry.interrupted = true
+			}
+			return filepath.SkipDir
+		}
 		if !info.IsDir() {
-			size += info.Size()
+			entry.size += info.Size()
 		}
 		return nil
 	})
-	return size
 }
 
-func renderHTML(root *dirEntry, absDir string) {
+// refreshCommand re-runs duh on absDir with the given options, replacing
+// the widget emitted under key.
+func refreshCommand(maxDepth int, showAll, gitignore, cache bool, units styles.SizeUnits, key, absDir string) string {
+	return duhCommand(maxDepth, showAll, gitignore, cache, units) + " -key " + styles.ShellQuote(key) + " " + styles.ShellQuote(absDir)
+}
+
+// moreCommand lists path, a directory at the depth limit, levels deeper
+// with the same options. Its widget is a new one: -watch isn't carried
+// over, as a second watch couldn't start while this one holds the shell.
+func moreCommand(levels int, showAll, gitignore, cache bool, units styles.SizeUnits, path string) string {
+	return duhCommand(levels, showAll, gitignore, cache, units) + " " + styles.ShellQuote(path)
+}
+
+// duhCommand is duh with the flags that reproduce the given options.
+func duhCommand(maxDepth int, showAll, gitignore, cache bool, units styles.SizeUnits) string {
+	exePath, err := os.Executable()
+	if err != nil {
+		exePath = "duh"
+	}
+	cmd := styles.ShellQuote(exePath)
+	if maxDepth >= 0 {
+		cmd += " -d " + styles.ShellQuote(strconv.Itoa(maxDepth))
+	}
+	if showAll {
+		cmd += " -a"
+	}
+	if gitignore {
+		cmd += " -gitignore"
+	}
+	if cache {
+		cmd += " -cache"
+	}
+	// Binary units only need a flag when they override GOSHELL_SI
+	switch {
+	case units == styles.SIUnits:
+		cmd += " -si"
+	case styles.SizeUnitsFromEnv() == styles.SIUnits:
+		cmd += " -si=false"
+	}
+	return cmd
+}
+
+// freshnessMarker records absDir's fingerprint in the widget, so goshell
+// can flag the listing once the directory changes.
+func freshnessMarker(absDir, refresh string) string {
+	marker, err := freshness.DirMarker(absDir, refresh)
+	if err != nil {
+		return ""
+	}
+	return marker
+}
+
+// formatEntrySize formats an entry's size, marking partial sizes as lower bounds.
+func formatEntrySize(entry *dirEntry, units styles.SizeUnits) string {
+	if entry.interrupted {
+		return "≥ " + styles.FormatSizeIn(entry.size, units)
+	}
+	return styles.FormatSizeIn(entry.size, units)
+}
+
+// renderHTML renders the tree. With two or more scans in hist, top-level
+// rows also show their size trend; hist may be nil. Directories at the
+// depth limit get more(path) as their one child, a row to list them
+// deeper; with more nil they get none.
+func renderHTML(root *dirEntry, absDir string, units styles.SizeUnits, hist *scanHistory, more func(path string) *styles.TreeNode) string {
 	var html strings.Builder
 
 	html.WriteString(`<style>`)
 	html.WriteString(styles.BaseCSS())
 	html.WriteString(styles.TreeTableCSS())
+	if hist.hasTrend() {
+		html.WriteString(historyCSS())
+	}
 	html.WriteString(`
 .duh-total {
 	font-size: 14px;
@@ -140,16 +369,29 @@ func renderHTML(root *dirEntry, absDir string) {
 	color: ` + styles.Colors.TextGray + `;
 	font-weight: 400;
 }
+.duh-badge {
+	margin-left: 6px;
+	font-size: 10px;
+	color: ` + styles.Colors.Yellow + `;
+}
 </style>
 <div class="shell-container">
 <div class="shell-header">
 <div class="shell-title">` + styles.HTMLEscape(absDir) + `</div>
-<div class="duh-total">` + styles.FormatSize(root.size) + ` <span class="duh-total-label">total</span></div>
+<div class="duh-total">` + formatEntrySize(root, units) + ` <span class="duh-total-label">total` + interruptedBadge(root) + `</span></div>
 </div>
 `)
 
 	// Build tree nodes from directory entries
-	nodes := buildTreeNodes(root.children, root.size)
+	nodes := buildTreeNodes(root.children, root.size, units, more)
+	if hist.hasTrend() {
+		for i, child := range root.children {
+			nodes[i].Cells = append(nodes[i].Cells, trendCell(hist, child.name, units))
+		}
+	}
+	if root.ignoredCount > 0 {
+		nodes = append(nodes, ignoredNode(root, units))
+	}
 
 	config := styles.TreeTableConfig{
 		Columns: []styles.Column{
@@ -160,6 +402,10 @@ func renderHTML(root *dirEntry, absDir string) {
 		BarAfterCell: 0, // Insert bar after name
 		TogglePrefix: "duh",
 		TreeID:       "duh",
+		Label:        absDir,
+	}
+	if hist.hasTrend() {
+		config.Columns = append(config.Columns, styles.Column{Class: "trend"})
 	}
 
 	styles.ResetTreeNodeCounter()
@@ -169,19 +415,44 @@ func renderHTML(root *dirEntry, absDir string) {
 </div>
 `)
 
-	fmt.Print(html.String())
+	return html.String()
 }
 
-func buildTreeNodes(entries []*dirEntry, parentSize int64) []*styles.TreeNode {
+// interruptedBadge returns the badge shown next to entries the walk didn't finish.
+func interruptedBadge(entry *dirEntry) string {
+	if !entry.interrupted {
+		return ""
+	}
+	return `<span class="duh-badge">(interrupted)</span>`
+}
+
+// ignoredNode is the row totalling what -gitignore left out of root's
+// size. It has no bar, as it isn't part of the total.
+func ignoredNode(root *dirEntry, units styles.SizeUnits) *styles.TreeNode {
+	noun := "entries"
+	if root.ignoredCount == 1 {
+		noun = "entry"
+	}
+	return &styles.TreeNode{
+		Icon:  "🙈",
+		Class: "ignored",
+		Cells: []string{
+			fmt.Sprintf("%d %s git ignores", root.ignoredCount, noun) + styles.IgnoredBadge,
+			styles.FormatSizeIn(root.ignoredSize, units),
+		},
+	}
+}
+
+func buildTreeNodes(entries []*dirEntry, parentSize int64, units styles.SizeUnits, more func(string) *styles.TreeNode) []*styles.TreeNode {
 	var nodes []*styles.TreeNode
 	for _, entry := range entries {
-		node := buildTreeNode(entry, parentSize)
+		node := buildTreeNode(entry, parentSize, units, more)
 		nodes = append(nodes, node)
 	}
 	return nodes
 }
 
-func buildTreeNode(entry *dirEntry, parentSize int64) *styles.TreeNode {
+func buildTreeNode(entry *dirEntry, parentSize int64, units styles.SizeUnits, more func(string) *styles.TreeNode) *styles.TreeNode {
 	// Calculate percentage of parent
 	var pct float64
 	if parentSize > 0 {
@@ -200,14 +471,18 @@ func buildTreeNode(entry *dirEntry, parentSize int64) *styles.TreeNode {
 		BarPercent: pct,
 		Value:      styles.HTMLEscape(styles.ShellQuote(entry.name)),
 		Cells: []string{
-			styles.HTMLEscape(entry.name),
-			styles.FormatSize(entry.size),
+			styles.HTMLEscape(entry.name) + interruptedBadge(entry),
+			formatEntrySize(entry, units),
 		},
 	}
 
 	// Recursively build children
 	if len(entry.children) > 0 {
-		node.Children = buildTreeNodes(entry.children, entry.size)
+		node.Children = buildTreeNodes(entry.children, entry.size, units, more)
+	}
+	if entry.truncated && more != nil {
+		node.Children = []*styles.TreeNode{more(entry.path)}
+		node.Expandable = true
 	}
 
 	return node
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"shellserver/internal/ignore"
+	"shellserver/internal/styles"
+)
+
+// countdownCtx reports cancellation after its Err method has been
+// consulted a fixed number of times, cancelling a walk at a
+// deterministic point.
+type countdownCtx struct {
+	context.Context
+	remaining atomic.Int64
+}
+
+func newCountdownCtx(n int64) *countdownCtx {
+	c := &countdownCtx{Context: context.Background()}
+	c.remaining.Store(n)
+	return c
+}
+
+func (c *countdownCtx) Err() error {
+	if c.remaining.Add(-1) < 0 {
+		return context.Canceled
+	}
+	return nil
+}
+
+func writeTree(t *testing.T, root string, files map[string]int) {
+	t.Helper()
+	for name, size := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, make([]byte, size), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestBuildTreeComplete(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]int{"a/x": 100, "b/y": 200, "c": 50})
+
+	tree := buildTree(context.Background(), root, -1, false, nil, 0)
+	if tree.interrupted {
+		t.Error("complete walk marked interrupted")
+	}
+	if tree.size != 350 {
+		t.Errorf("size = %d, want 350", tree.size)
+	}
+}
+
+func TestBuildTreeInterrupted(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]int{
+		"a/x":   100,
+		"a/y":   100,
+		"b/z":   200,
+		"c/d/w": 400,
+	})
+
+	// Enough checks to finish "a" and then stop.
+	tree := buildTree(newCountdownCtx(5), root, -1, false, nil, 0)
+
+	if !tree.interrupted {
+		t.Fatal("root not marked interrupted")
+	}
+	if tree.size >= 800 {
+		t.Errorf("partial size = %d, want less than the full 800", tree.size)
+	}
+
+	var a *dirEntry
+	for _, child := range tree.children {
+		if child.name == "a" {
+			a = child
+		}
+	}
+	if a == nil || a.interrupted || a.size != 200 {
+		t.Fatalf("directory a = %+v, want complete with size 200", a)
+	}
+
+	html := renderHTML(tree, root, styles.BinaryUnits, nil, nil)
+	if !strings.Contains(html, "(interrupted)") {
+		t.Error("rendered HTML missing interrupted badge")
+	}
+	if !strings.Contains(html, "≥ "+"200 B") {
+		t.Errorf("rendered HTML missing lower-bound total:\n%s", html)
+	}
+}
+
+func TestCalcDirSizeInterrupted(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]int{"a": 10, "b": 10, "c": 10})
+
+	partial := &dirEntry{path: root}
+	calcDirSize(newCountdownCtx(2), partial, false, nil)
+	if !partial.interrupted {
+		t.Error("walk not reported as interrupted")
+	}
+	if partial.size >= 30 {
+		t.Errorf("size = %d, want a partial sum below 30", partial.size)
+	}
+
+	complete := &dirEntry{path: root}
+	calcDirSize(context.Background(), complete, false, nil)
+	if complete.interrupted || complete.size != 30 {
+		t.Errorf("complete walk = (%d, %v), want (30, false)", complete.size, complete.interrupted)
+	}
+}
+
+func TestRenderHTMLAccessibility(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]int{"a/x": 10, "b": 20})
+
+	html := renderHTML(buildTree(context.Background(), root, -1, false, nil, 0), root, styles.BinaryUnits, nil, nil)
+	for _, want := range []string{
+		`role="tree" aria-label="` + root + `"`,
+		`role="treeitem" aria-level="1" aria-expanded="false"`,
+		`role="treeitem" aria-level="2"`,
+		`role="group"`,
+	} {
+		if !strings.Contains(html, want) {
+			t.Errorf("duh output missing %q", want)
+		}
+	}
+}
+
+func TestRenderHTMLUnits(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]int{"a": 1500, "b": 500})
+	tree := buildTree(context.Background(), root, -1, false, nil, 0)
+
+	tests := []struct {
+		units       styles.SizeUnits
+		total, file string
+	}{
+		{styles.BinaryUnits, "2.0 KiB", "1.5 KiB"},
+		{styles.SIUnits, "2.0 kB", "1.5 kB"},
+	}
+	for _, tt := range tests {
+		html := renderHTML(tree, root, tt.units, nil, nil)
+		if !strings.Contains(html, `<div class="duh-total">`+tt.total+" ") {
+			t.Errorf("units %v: total not %q:\n%s", tt.units, tt.total, html)
+		}
+		if !strings.Contains(html, tt.file) {
+			t.Errorf("units %v: file size %q missing", tt.units, tt.file)
+		}
+	}
+}
+
+func TestBuildTreeGitignore(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]int{
+		".gitignore":      0,
+		"src/main.go":     100,
+		"src/main.o":      40,
+		"build/out":       500,
+		"docs/notes.txt":  20,
+		"docs/keep.o":     7,
+		"docs/.gitignore": 0,
+	})
+	os.Mkdir(filepath.Join(root, ".git"), 0o755)
+	os.WriteFile(filepath.Join(root, ".gitignore"), []byte("*.o\nbuild/\n"), 0o644)
+	os.WriteFile(filepath.Join(root, "docs", ".gitignore"), []byte("!keep.o\n"), 0o644)
+	ign, err := ignore.ForDir(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, depth := range []int{-1, 0, 1} {
+		tree := buildTree(context.Background(), root, depth, false, ign, 0)
+		if tree.size != 127 || tree.ignoredSize != 540 || tree.ignoredCount != 2 {
+			t.Errorf("depth %d: size %d, ignored %d in %d entries; want 127, 540 in 2",
+				depth, tree.size, tree.ignoredSize, tree.ignoredCount)
+		}
+	}
+
+	tree := buildTree(context.Background(), root, -1, false, ign, 0)
+	html := renderHTML(tree, root, styles.BinaryUnits, nil, nil)
+	if !strings.Contains(html, `class="tree-row ignored"`) || !strings.Contains(html, "2 entries git ignores"+styles.IgnoredBadge) {
+		t.Errorf("no ignored row in:\n%s", html)
+	}
+	if strings.Contains(html, ">build<") {
+		t.Error("ignored directory listed as an entry")
+	}
+
+	// Without a matcher nothing is left out
+	if tree := buildTree(context.Background(), root, -1, false, nil, 0); tree.size != 667 || tree.ignoredCount != 0 {
+		t.Errorf("unfiltered size %d, %d ignored; want 667, 0", tree.size, tree.ignoredCount)
+	}
+}
+
+func TestRenderHTMLMoreLevels(t *testing.T) {
+	t.Setenv("GOSHELL_SI", "")
+	root := t.TempDir()
+	writeTree(t, root, map[string]int{"a/b/x": 10, "c": 20, "dots/.hidden": 5})
+
+	more := func(path string) *styles.TreeNode {
+		return styles.MoreLevelsNode(1, moreCommand(1, false, true, false, styles.SIUnits, path))
+	}
+	tree := buildTree(context.Background(), root, 1, false, nil, 0)
+	html := renderHTML(tree, root, styles.BinaryUnits, nil, more)
+	if n := strings.Count(html, "show 1 more level here"); n != 1 {
+		t.Errorf("%d cutoff rows, want 1 for a (dots holds only hidden files):\n%s", n, html)
+	}
+	want := styles.HTMLEscape(" -d '1' -gitignore -si " + styles.ShellQuote(filepath.Join(root, "a")))
+	if !strings.Contains(html, want+`&quot;)">show 1 more level here`) {
+		t.Errorf("cutoff row doesn't run %q:\n%s", want, html)
+	}
+
+	// Unlimited depth, or no more func, has nothing to cut off
+	if html := renderHTML(buildTree(context.Background(), root, -1, false, nil, 0), root, styles.BinaryUnits, nil, more); strings.Contains(html, "more-levels") {
+		t.Error("cutoff row in an unlimited tree")
+	}
+	if html := renderHTML(tree, root, styles.BinaryUnits, nil, nil); strings.Contains(html, "more-levels") {
+		t.Error("cutoff row without a more func")
+	}
+}
+
+func TestMoreCommandFlags(t *testing.T) {
+	t.Setenv("GOSHELL_SI", "1")
+	got := moreCommand(2, true, true, true, styles.BinaryUnits, "/tmp/a b")
+	if want := " -d '2' -a -gitignore -cache -si=false '/tmp/a b'"; !strings.HasSuffix(got, want) {
+		t.Errorf("moreCommand = %q, want it to end %q", got, want)
+	}
+	if strings.Contains(got, "-key") || strings.Contains(got, "-watch") {
+		t.Errorf("moreCommand = %q replaces this widget or watches", got)
+	}
+}
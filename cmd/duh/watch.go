@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"shellserver/internal/ignore"
+	"shellserver/internal/styles"
+)
+
+// watchOptions configures duh --watch.
+type watchOptions struct {
+	maxDepth int
+	showAll  bool
+	ignore   *ignore.Matcher // -gitignore; nil to count everything
+	interval time.Duration   // debounce between re-emitted widgets
+	units    styles.SizeUnits
+	key      string                             // replaces-widget key every snapshot is emitted under
+	refresh  string                             // command that re-renders the widget once watching stops
+	more     func(path string) *styles.TreeNode // row listing a directory at the depth limit deeper
+}
+
+// runWatch emits the tree as a keyed widget, then keeps re-emitting it
+// under the same key as the directory changes, so the open panel updates
+// in place. It returns when ctx is done, after a final snapshot.
+func runWatch(ctx context.Context, root *dirEntry, absDir string, opts watchOptions) {
+	changes, err := watchChanges(ctx, absDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "duh: %v; polling every %v\n", err, opts.interval)
+		changes = pollChanges(ctx, absDir, opts.interval)
+	}
+
+	emit := func(root *dirEntry) {
+		fmt.Print(styles.HTMLStartWithKey(opts.key))
+		fmt.Print(freshnessMarker(absDir, opts.refresh))
+		fmt.Print(renderHTML(root, absDir, opts.units, nil, opts.more))
+		fmt.Print(styles.HTMLEnd)
+		os.Stdout.Sync()
+	}
+
+	emit(root)
+	watchTree(ctx, root, changes, opts, emit)
+	fmt.Println()
+}
+
+// watchTree collects changed paths and, at most once per interval,
+// recomputes the affected top-level subtrees and emits the tree. When ctx
+// is done it emits a final snapshot and returns.
+func watchTree(ctx context.Context, root *dirEntry, changes <-chan string, opts watchOptions, emit func(*dirEntry)) {
+	ticker := time.NewTicker(opts.interval)
+	defer ticker.Stop()
+
+	dirty := make(map[string]bool)
+	for {
+		select {
+		case <-ctx.Done():
+			emit(root)
+			return
+		case path, ok := <-changes:
+			if !ok {
+				changes = nil
+				continue
+			}
+			if name, ok := topLevelChild(root.path, path, opts.showAll); ok {
+				dirty[name] = true
+			}
+		case <-ticker.C:
+			if len(dirty) == 0 {
+				continue
+			}
+			refreshTree(ctx, root, dirty, opts.maxDepth, opts.showAll, opts.ignore)
+			dirty = make(map[string]bool)
+			emit(root)
+		}
+	}
+}
+
+// topLevelChild maps a changed path to the name of the child of root it
+// lies under. Changes to root itself, outside it, or (without showAll)
+// under hidden entries are ignored.
+func topLevelChild(root, path string, showAll bool) (string, bool) {
+	rel, err := filepath.Rel(root, path)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	name, _, _ := strings.Cut(rel, string(filepath.Separator))
+	if !showAll && strings.HasPrefix(name, ".") {
+		return "", false
+	}
+	return name, true
+}
+
+// refreshTree recomputes only the dirty children of root, dropping the
+// ones that no longer exist, and updates root's total. Untouched
+// children keep their previously computed sizes.
+func refreshTree(ctx context.Context, root *dirEntry, dirty map[string]bool, maxDepth int, showAll bool, ign *ignore.Matcher) {
+	if maxDepth == 0 {
+		// No children are kept at depth 0; only the total is shown
+		*root = dirEntry{path: root.path, name: root.name, isDir: root.isDir}
+		calcDirSize(ctx, root, showAll, ign)
+		return
+	}
+
+	root.children = dropDirty(root.children, dirty)
+	root.ignored = dropDirty(root.ignored, dirty)
+
+	for name := range dirty {
+		path := filepath.Join(root.path, name)
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		if ign.Ignored(path, info.IsDir()) {
+			if child := ignoredEntry(ctx, path, showAll); child != nil {
+				root.ignored = append(root.ignored, child)
+			}
+		} else if child := buildTree(ctx, path, maxDepth, showAll, ign, 1); child != nil {
+			root.children = append(root.children, child)
+		}
+	}
+
+	root.size = 0
+	root.interrupted = false
+	root.ignoredSize = 0
+	root.ignoredCount = len(root.ignored)
+	for _, child := range root.children {
+		root.size += child.size
+		root.ignoredSize += child.ignoredSize
+		root.ignoredCount += child.ignoredCount
+		if child.interrupted {
+			root.interrupted = true
+		}
+	}
+	for _, child := range root.ignored {
+		root.ignoredSize += child.size
+		if child.interrupted {
+			root.interrupted = true
+		}
+	}
+	sortBySize(root.children)
+}
+
+// dropDirty removes the entries named in dirty, in place.
+func dropDirty(entries []*dirEntry, dirty map[string]bool) []*dirEntry {
+	kept := entries[:0]
+	for _, e := range entries {
+		if !dirty[e.name] {
+			kept = append(kept, e)
+		}
+	}
+	return kept
+}
+
+// fileSig is what the polling fallback compares between scans.
+type fileSig struct {
+	size    int64
+	modTime time.Time
+}
+
+// snapshotLevels records the size and modification time of everything on
+// the top two levels below root.
+func snapshotLevels(root string) map[string]fileSig {
+	snap := make(map[string]fileSig)
+	var scan func(dir string, level int)
+	scan = func(dir string, level int) {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return
+		}
+		for _, e := range entries {
+			info, err := e.Info()
+			if err != nil {
+				continue
+			}
+			path := filepath.Join(dir, e.Name())
+			snap[path] = fileSig{size: info.Size(), modTime: info.ModTime()}
+			if e.IsDir() && level < 2 {
+				scan(path, level+1)
+			}
+		}
+	}
+	scan(root, 1)
+	return snap
+}
+
+// pollChanges reports paths on the top two levels of root that appeared,
+// disappeared, or changed size or modification time, scanning every
+// interval. It is the fallback when inotify is unavailable.
+func pollChanges(ctx context.Context, root string, interval time.Duration) <-chan string {
+	changes := make(chan string)
+	prev := snapshotLevels(root)
+	go func() {
+		defer close(changes)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			cur := snapshotLevels(root)
+			var changed []string
+			for path, sig := range cur {
+				if old, ok := prev[path]; !ok || old != sig {
+					changed = append(changed, path)
+				}
+			}
+			for path := range prev {
+				if _, ok := cur[path]; !ok {
+					changed = append(changed, path)
+				}
+			}
+			prev = cur
+
+			for _, path := range changed {
+				select {
+				case changes <- path:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return changes
+}
@@ -0,0 +1,109 @@
+//go:build linux
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"syscall"
+	"unsafe"
+)
+
+const inotifyMask = syscall.IN_CREATE | syscall.IN_DELETE | syscall.IN_MODIFY |
+	syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO | syscall.IN_ATTRIB
+
+// inotifyWatcher watches root and its immediate subdirectories.
+type inotifyWatcher struct {
+	file *os.File
+	root string
+	dirs map[int32]string // watch descriptor -> directory
+}
+
+// watchChanges reports changed paths on the top two levels of root using
+// inotify. The channel is closed once ctx is done.
+func watchChanges(ctx context.Context, root string) (<-chan string, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, os.NewSyscallError("inotify_init1", err)
+	}
+	w := &inotifyWatcher{
+		file: os.NewFile(uintptr(fd), "inotify"),
+		root: root,
+		dirs: make(map[int32]string),
+	}
+	if err := w.add(root); err != nil {
+		w.file.Close()
+		return nil, err
+	}
+	entries, _ := os.ReadDir(root)
+	for _, e := range entries {
+		if e.IsDir() {
+			w.add(filepath.Join(root, e.Name()))
+		}
+	}
+
+	changes := make(chan string)
+	go func() {
+		<-ctx.Done()
+		w.file.Close()
+	}()
+	go w.run(ctx, changes)
+	return changes, nil
+}
+
+func (w *inotifyWatcher) add(dir string) error {
+	rc, err := w.file.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var addErr error
+	err = rc.Control(func(fd uintptr) {
+		wd, err := syscall.InotifyAddWatch(int(fd), dir, inotifyMask)
+		if err != nil {
+			addErr = os.NewSyscallError("inotify_add_watch", err)
+			return
+		}
+		w.dirs[int32(wd)] = dir
+	})
+	if err != nil {
+		return err
+	}
+	return addErr
+}
+
+func (w *inotifyWatcher) run(ctx context.Context, changes chan<- string) {
+	defer close(changes)
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := w.file.Read(buf)
+		if err != nil {
+			return
+		}
+		for off := 0; off+syscall.SizeofInotifyEvent <= n; {
+			ev := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[off]))
+			nameStart := off + syscall.SizeofInotifyEvent
+			name := string(bytes.TrimRight(buf[nameStart:nameStart+int(ev.Len)], "\x00"))
+			off = nameStart + int(ev.Len)
+
+			dir, ok := w.dirs[ev.Wd]
+			if !ok || name == "" {
+				continue
+			}
+			path := filepath.Join(dir, name)
+
+			// New top-level directories are watched too
+			if dir == w.root && ev.Mask&syscall.IN_ISDIR != 0 &&
+				ev.Mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0 {
+				w.add(path)
+			}
+
+			select {
+			case changes <- path:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// waitChange waits for a change under the root's child named want.
+func waitChange(t *testing.T, changes <-chan string, root, want string) {
+	t.Helper()
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case path := <-changes:
+			if name, _ := topLevelChild(root, path, false); name == want {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("no change reported under %q", want)
+		}
+	}
+}
+
+func TestWatchChangesInotify(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]int{"a/x": 10})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	changes, err := watchChanges(ctx, root)
+	if err != nil {
+		t.Skipf("inotify unavailable: %v", err)
+	}
+
+	writeTree(t, root, map[string]int{"a/x": 20})
+	waitChange(t, changes, root, "a")
+
+	// Directories created after the watch started are watched as well.
+	if err := os.Mkdir(filepath.Join(root, "new"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	waitChange(t, changes, root, "new")
+	writeTree(t, root, map[string]int{"new/y": 5})
+	waitChange(t, changes, root, "new")
+
+	cancel()
+	for range changes {
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+
+package main
+
+import (
+	"context"
+	"errors"
+)
+
+// watchChanges is only implemented with inotify; elsewhere duh --watch
+// falls back to polling.
+func watchChanges(ctx context.Context, root string) (<-chan string, error) {
+	return nil, errors.New("filesystem notifications not supported")
+}
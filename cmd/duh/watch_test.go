@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"shellserver/internal/ignore"
+)
+
+func TestTopLevelChild(t *testing.T) {
+	root := filepath.Join("/", "data")
+	tests := []struct {
+		path    string
+		showAll bool
+		want    string
+		wantOK  bool
+	}{
+		{"/data/a", false, "a", true},
+		{"/data/a/b/c", false, "a", true},
+		{"/data", false, "", false},
+		{"/other/a", false, "", false},
+		{"/data/.cache/x", false, "", false},
+		{"/data/.cache/x", true, ".cache", true},
+		{"/data/..hidden", true, "..hidden", true},
+	}
+	for _, tt := range tests {
+		got, ok := topLevelChild(root, filepath.FromSlash(tt.path), tt.showAll)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("topLevelChild(%q, showAll=%v) = (%q, %v), want (%q, %v)",
+				tt.path, tt.showAll, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestRefreshTreeGitignore(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]int{".git/HEAD": 0, "src/a.go": 10, "src/a.o": 20, "out": 100})
+	os.WriteFile(filepath.Join(root, ".gitignore"), []byte("*.o\nout\n"), 0o644)
+	ign, _ := ignore.ForDir(root)
+	tree := buildTree(context.Background(), root, -1, false, ign, 0)
+
+	writeTree(t, root, map[string]int{"out": 300, "src/b.o": 5})
+	refreshTree(context.Background(), tree, map[string]bool{"out": true, "src": true}, -1, false, ign)
+	if tree.size != 10 || tree.ignoredSize != 325 || tree.ignoredCount != 3 {
+		t.Errorf("size %d, ignored %d in %d entries; want 10, 325 in 3", tree.size, tree.ignoredSize, tree.ignoredCount)
+	}
+
+	os.Remove(filepath.Join(root, "out"))
+	refreshTree(context.Background(), tree, map[string]bool{"out": true}, -1, false, ign)
+	if tree.ignoredSize != 25 || tree.ignoredCount != 2 || len(tree.ignored) != 0 {
+		t.Errorf("after removing out: ignored %d in %d entries, want 25 in 2", tree.ignoredSize, tree.ignoredCount)
+	}
+}
+
+func TestRefreshTreeOnlyDirtyChildren(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]int{"a/x": 100, "b/y": 200, "c": 50})
+	tree := buildTree(context.Background(), root, -1, false, nil, 0)
+
+	var b *dirEntry
+	for _, child := range tree.children {
+		if child.name == "b" {
+			b = child
+		}
+	}
+
+	writeTree(t, root, map[string]int{"a/x": 1000, "d/z": 10})
+	os.Remove(filepath.Join(root, "c"))
+	// b changes on disk too, but no event names it
+	writeTree(t, root, map[string]int{"b/y": 5})
+
+	refreshTree(context.Background(), tree, map[string]bool{"a": true, "c": true, "d": true}, -1, false, nil)
+
+	sizes := map[string]int64{}
+	for _, child := range tree.children {
+		sizes[child.name] = child.size
+	}
+	want := map[string]int64{"a": 1000, "b": 200, "d": 10}
+	if len(sizes) != len(want) {
+		t.Fatalf("children = %v, want %v", sizes, want)
+	}
+	for name, size := range want {
+		if sizes[name] != size {
+			t.Errorf("%s size = %d, want %d", name, sizes[name], size)
+		}
+	}
+	if tree.size != 1210 {
+		t.Errorf("total = %d, want 1210", tree.size)
+	}
+	if tree.children[0].name != "a" {
+		t.Errorf("largest child = %q, want a first", tree.children[0].name)
+	}
+	for _, child := range tree.children {
+		if child.name == "b" && child != b {
+			t.Error("untouched child b was recomputed")
+		}
+	}
+}
+
+func TestWatchTreeSyntheticEvents(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]int{"a/x": 100})
+	tree := buildTree(context.Background(), root, -1, false, nil, 0)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	changes := make(chan string)
+	emitted := make(chan int64, 16)
+	done := make(chan struct{})
+	go func() {
+		watchTree(ctx, tree, changes, watchOptions{maxDepth: -1, interval: 10 * time.Millisecond},
+			func(root *dirEntry) { emitted <- root.size })
+		close(done)
+	}()
+
+	writeTree(t, root, map[string]int{"a/x": 300, "b": 20})
+	changes <- filepath.Join(root, "a", "x")
+	changes <- filepath.Join(root, "a", "x") // coalesced with the first
+	changes <- filepath.Join(root, "b")
+
+	select {
+	case size := <-emitted:
+		if size != 320 {
+			t.Errorf("emitted total = %d, want 320", size)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no widget emitted after changes")
+	}
+
+	select {
+	case size := <-emitted:
+		t.Errorf("emitted again (%d) without new changes", size)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	cancel()
+	<-done
+	select {
+	case size := <-emitted:
+		if size != 320 {
+			t.Errorf("final snapshot total = %d, want 320", size)
+		}
+	default:
+		t.Error("no final snapshot emitted on cancel")
+	}
+}
+
+func TestPollChanges(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]int{"a/x": 10})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changes := pollChanges(ctx, root, 10*time.Millisecond)
+
+	writeTree(t, root, map[string]int{"a/y": 20})
+	select {
+	case path := <-changes:
+		if name, _ := topLevelChild(root, path, false); name != "a" {
+			t.Errorf("change at %q, want one under a", path)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("new file not reported")
+	}
+}
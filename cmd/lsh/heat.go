@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"time"
+
+	"shellserver/internal/styles"
+)
+
+// heatMode is what -heat tints rows by.
+type heatMode string
+
+const (
+	heatNone heatMode = "none"
+	heatSize heatMode = "size" // larger is hotter
+	heatAge  heatMode = "age"  // older is hotter
+)
+
+func parseHeatMode(s string) (heatMode, error) {
+	switch m := heatMode(s); m {
+	case heatNone, heatSize, heatAge:
+		return m, nil
+	}
+	return "", fmt.Errorf("-heat must be size, age or none, not %q", s)
+}
+
+// listingHeat tints the entries of one listing by their size or age
+// relative to each other.
+type listingHeat struct {
+	mode heatMode
+	now  time.Time
+	heat styles.Heat
+}
+
+// newListingHeat returns the heat of a listing of infos, or nil for
+// heatNone.
+func newListingHeat(mode heatMode, infos []os.FileInfo, now time.Time) *listingHeat {
+	if mode == heatNone {
+		return nil
+	}
+	h := &listingHeat{mode: mode, now: now}
+	color := styles.Colors.Red
+	if mode == heatAge {
+		color = styles.Colors.Yellow
+	}
+	values := make([]float64, len(infos))
+	for i, info := range infos {
+		values[i] = h.value(info)
+	}
+	h.heat = styles.NewHeat(values, color)
+	return h
+}
+
+// value is what info's heat is measured on. Sizes are on a log scale so
+// one huge file doesn't leave the rest of the listing cold.
+func (h *listingHeat) value(info os.FileInfo) float64 {
+	if h.mode == heatAge {
+		return math.Max(0, h.now.Sub(info.ModTime()).Seconds())
+	}
+	return math.Log1p(float64(info.Size()))
+}
+
+// style is the inline style tinting info's row, or "".
+func (h *listingHeat) style(info os.FileInfo) string {
+	if h == nil {
+		return ""
+	}
+	if bg := h.heat.Background(h.value(info)); bg != "" {
+		return "background-color: " + bg
+	}
+	return ""
+}
+
+// legend is the header's key to the tints.
+func (h *listingHeat) legend() string {
+	if h == nil {
+		return ""
+	}
+	if h.mode == heatAge {
+		return styles.HeatLegend("newer", "older", h.heat.Hot)
+	}
+	return styles.HeatLegend("smaller", "larger", h.heat.Hot)
+}
+
+// flags returns the flag that reproduces the heat mode.
+func (h *listingHeat) flags() string {
+	if h == nil {
+		return ""
+	}
+	return " -heat " + string(h.mode)
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestListingHeat(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	write := func(name string, size int, age time.Duration) os.FileInfo {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, make([]byte, size), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, now.Add(-age), now.Add(-age)); err != nil {
+			t.Fatal(err)
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return info
+	}
+	small := write("small", 0, time.Hour)
+	big := write("big", 1<<20, time.Minute)
+	infos := []os.FileInfo{small, big}
+
+	if h := newListingHeat(heatNone, infos, now); h != nil || h.style(big) != "" || h.legend() != "" || h.flags() != "" {
+		t.Error("-heat none tints")
+	}
+
+	size := newListingHeat(heatSize, infos, now)
+	if size.style(small) != "" || !strings.HasPrefix(size.style(big), "background-color: #") {
+		t.Errorf("size heat: small %q, big %q", size.style(small), size.style(big))
+	}
+	if !strings.Contains(size.legend(), ">larger<") || size.flags() != " -heat size" {
+		t.Errorf("size legend %q flags %q", size.legend(), size.flags())
+	}
+
+	age := newListingHeat(heatAge, infos, now)
+	if age.style(big) != "" || age.style(small) == "" {
+		t.Errorf("age heat: newer %q, older %q", age.style(big), age.style(small))
+	}
+
+	// A listing of one has nothing to compare against
+	if one := newListingHeat(heatSize, []os.FileInfo{big}, now); one.style(big) != "" {
+		t.Errorf("single entry tinted %q", one.style(big))
+	}
+
+	if _, err := parseHeatMode("bogus"); err == nil {
+		t.Error("parseHeatMode accepted bogus")
+	}
+}
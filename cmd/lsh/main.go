@@ -1,16 +1,37 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
+	"net/url"
 	"os"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
+	"time"
 
+	"shellserver/internal/freshness"
+	"shellserver/internal/ignore"
 	"shellserver/internal/styles"
+	"shellserver/pkg/widgetcli"
 )
 
+var tool = widgetcli.Tool{
+	Name:    "lsh",
+	Summary: "list directory contents as an interactive HTML widget",
+	Usage:   "[directory]",
+	Examples: []widgetcli.Example{
+		{Args: []string{"-t"}, Description: "newest first"},
+		{Args: []string{"-l", "-S"}, Description: "long format, largest first"},
+		{Args: []string{"-l", "-xattr", "/etc"}, Description: "long format with extended attributes"},
+		{Args: []string{"-gitignore"}, Description: "gray out the files git ignores"},
+		{Args: []string{"-l", "-heat", "age"}, Description: "long format, older entries tinted warmer"},
+		{Args: []string{"-R", "-d", "2"}, Description: "two levels of subdirectories as a tree, with buttons to go deeper"},
+	},
+}
+
 func main() {
 	// Parse flags (matching ls command line options)
 	showAll := flag.Bool("a", false, "include directory entries whose names begin with a dot (.)")
@@ -19,7 +40,27 @@ func main() {
 	sortTime := flag.Bool("t", false, "sort by modification time")
 	sortSize := flag.Bool("S", false, "sort by size")
 	sortReverse := flag.Bool("r", false, "reverse sort order")
-	flag.Parse()
+	showInode := flag.Bool("i", false, "print the index number of each file (long format)")
+	showBlocks := flag.Bool("s", false, "print the allocated size of each file, in 1K blocks (long format)")
+	recursive := flag.Bool("R", false, "list subdirectories recursively, as an expandable tree")
+	maxDepth := flag.Int("d", -1, "with -R, levels to list (-1 for unlimited); directories at the limit get a button that lists them deeper")
+	showXattr := flag.Bool("xattr", false, "show extended attributes; expand a row to see their values (long format)")
+	gitignore := flag.Bool("gitignore", false, "gray out entries git ignores and mark them with an \"ignored\" badge")
+	heatFlag := flag.String("heat", "none", "tint each row by its size or age relative to the rest of the listing: size, age or none")
+	key := flag.String("key", "", "replace the widget previously emitted with this key instead of adding one")
+	si := flag.Bool("si", styles.SizeUnitsFromEnv() == styles.SIUnits, "show sizes in powers of 1000 (kB, MB) instead of 1024 (KiB, MiB); default from GOSHELL_SI")
+	tool.Parse(os.Args[1:])
+
+	mode, err := parseHeatMode(*heatFlag)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "lsh: %v\n", err)
+		os.Exit(2)
+	}
+
+	opts := longOptions{inode: *showInode, blocks: *showBlocks, xattr: *showXattr}
+	if *si {
+		opts.units = styles.SIUnits
+	}
 
 	dir := "."
 	if flag.NArg() > 0 {
@@ -44,57 +85,24 @@ func main() {
 		os.Exit(1)
 	}
 
-	// Filter entries based on -a and -A flags
-	var filteredEntries []os.DirEntry
-	for _, entry := range entries {
-		name := entry.Name()
-		if strings.HasPrefix(name, ".") {
-			if *showAll {
-				// -a: show all entries including . and ..
-				filteredEntries = append(filteredEntries, entry)
-			} else if *showAlmostAll {
-				// -A: show dot files except . and ..
-				if name != "." && name != ".." {
-					filteredEntries = append(filteredEntries, entry)
-				}
-			}
-			// Otherwise skip hidden files
-		} else {
-			filteredEntries = append(filteredEntries, entry)
+	var ign *ignore.Matcher
+	if *gitignore {
+		if ign, err = ignore.ForDir(absDir); err != nil {
+			fmt.Fprintf(os.Stderr, "lsh: %v\n", err)
+			os.Exit(1)
 		}
 	}
 
-	// Sort entries based on flags
-	sortedEntries := make([]os.DirEntry, len(filteredEntries))
-	copy(sortedEntries, filteredEntries)
+	sortedEntries := filterEntries(entries, *showAll, *showAlmostAll)
+	sortEntries(sortedEntries, *sortTime, *sortSize, *sortReverse)
 
-	if *sortTime {
-		sort.Slice(sortedEntries, func(i, j int) bool {
-			infoI, _ := sortedEntries[i].Info()
-			infoJ, _ := sortedEntries[j].Info()
-			if *sortReverse {
-				return infoI.ModTime().Before(infoJ.ModTime())
-			}
-			return infoI.ModTime().After(infoJ.ModTime())
-		})
-	} else if *sortSize {
-		sort.Slice(sortedEntries, func(i, j int) bool {
-			infoI, _ := sortedEntries[i].Info()
-			infoJ, _ := sortedEntries[j].Info()
-			if *sortReverse {
-				return infoI.Size() < infoJ.Size()
-			}
-			return infoI.Size() > infoJ.Size()
-		})
-	} else {
-		// Sort by name
-		sort.Slice(sortedEntries, func(i, j int) bool {
-			if *sortReverse {
-				return sortedEntries[i].Name() > sortedEntries[j].Name()
-			}
-			return sortedEntries[i].Name() < sortedEntries[j].Name()
-		})
+	var infos []os.FileInfo
+	for _, entry := range sortedEntries {
+		if info, err := entry.Info(); err == nil {
+			infos = append(infos, info)
+		}
 	}
+	heat := newListingHeat(mode, infos, time.Now())
 
 	// Build command line representation
 	cmdLine := "lsh"
@@ -102,7 +110,7 @@ func main() {
 		cmdLine = strings.Join(os.Args, " ")
 	}
 
-	// Build base flags for commands (preserve -a/-A and -l flags)
+	// Build base flags for commands (preserve -a/-A, -l, column and unit flags)
 	baseFlags := ""
 	if *showAll {
 		baseFlags += " -a"
@@ -112,16 +120,39 @@ func main() {
 	if *longFormat {
 		baseFlags += " -l"
 	}
+	baseFlags += opts.flags()
+	if *gitignore {
+		baseFlags += " -gitignore"
+	}
+	baseFlags += heat.flags()
+	listFlags := baseFlags + sortFlags(*sortTime, *sortSize, *sortReverse)
+	if *recursive {
+		baseFlags += " -R"
+		if *maxDepth >= 0 {
+			baseFlags += " -d " + styles.ShellQuote(strconv.Itoa(*maxDepth))
+		}
+	}
 
-	// Start HTML mode
-	fmt.Print(styles.HTMLStart)
+	// Start HTML mode, keyed so the stale-listing banner can refresh it in place
+	if *key == "" {
+		*key = styles.NewWidgetKey("lsh")
+	}
+	fmt.Print(styles.HTMLStartWithKey(*key))
 
 	// Build HTML output
 	var html strings.Builder
 
+	refresh := refreshCommand(exePath, baseFlags+sortFlags(*sortTime, *sortSize, *sortReverse), *key, absDir)
+	if marker, err := freshness.DirMarker(absDir, refresh); err == nil {
+		html.WriteString(marker)
+	}
+
 	// Shared styles + lsh-specific
 	html.WriteString(`<style>`)
 	html.WriteString(styles.BaseCSS())
+	if heat != nil {
+		html.WriteString(styles.HeatCSS())
+	}
 	html.WriteString(`
 .lsh-grid {
 	display: flex;
@@ -150,6 +181,15 @@ func main() {
 	color: ` + styles.Colors.TextGray + `;
 	margin-left: 4px;
 }
+.shell-download {
+	margin-left: 4px;
+	font-size: 10px;
+	color: ` + styles.Colors.TextGray + `;
+	text-decoration: none;
+}
+.shell-download:hover {
+	color: ` + styles.Colors.Blue + `;
+}
 </style>
 <div class="shell-container">
 <div class="shell-header">
@@ -157,66 +197,53 @@ func main() {
 <div class="shell-meta">
 <span class="shell-meta-label">$</span>` + styles.HTMLEscape(cmdLine) + `
 </div>
-<div class="shell-sort-buttons">
-<a href="#" class="shell-sort-btn` + getActiveClass(!*sortSize && !*sortTime) + `" onclick="runCommand(&quot;` + styles.HTMLEscape(styles.ShellQuote(exePath)+baseFlags+" "+styles.ShellQuote(absDir)) + `&quot;); return false;">Name</a>
-<a href="#" class="shell-sort-btn` + getActiveClass(*sortTime) + `" onclick="runCommand(&quot;` + styles.HTMLEscape(styles.ShellQuote(exePath)+baseFlags+" -t "+styles.ShellQuote(absDir)) + `&quot;); return false;">Date</a>
-<a href="#" class="shell-sort-btn` + getActiveClass(*sortSize) + `" onclick="runCommand(&quot;` + styles.HTMLEscape(styles.ShellQuote(exePath)+baseFlags+" -S "+styles.ShellQuote(absDir)) + `&quot;); return false;">Size</a>
-<a href="#" class="shell-sort-btn" onclick="runCommand(&quot;` + styles.HTMLEscape(styles.ShellQuote(exePath)+baseFlags+" -r "+styles.ShellQuote(absDir)) + `&quot;); return false;">↕</a>
-</div>
-</div>
+<div class="shell-sort-buttons" role="toolbar" aria-label="Sort">` +
+		renderSortButtons(exePath, baseFlags, absDir, *sortTime, *sortSize) + `</div>
+` + heat.legend() + `</div>
 `)
 
-	if *longFormat {
-		// Long format: use TreeTable component
+	if *longFormat || *recursive {
+		// Long format and -R: use TreeTable component
 		html.WriteString(`<style>`)
 		html.WriteString(styles.TreeTableCSS())
+		html.WriteString(longFormatCSS)
 		html.WriteString(`</style>`)
 
-		// Build tree nodes from entries
-		var nodes []*styles.TreeNode
-		for _, entry := range sortedEntries {
-			info, err := entry.Info()
-			if err != nil {
-				continue
-			}
-
-			icon := "📄"
-			if entry.IsDir() {
-				icon = "📁"
+		tree := &treeListing{
+			opts:          opts,
+			columns:       []styles.Column{{Class: "name"}, {Class: "size"}},
+			ign:           ign,
+			heat:          heat,
+			showAll:       *showAll,
+			showAlmostAll: *showAlmostAll,
+			sortTime:      *sortTime,
+			sortSize:      *sortSize,
+			sortReverse:   *sortReverse,
+		}
+		if *longFormat {
+			tree.columns = longFormatColumns(opts)
+		}
+		if *recursive {
+			tree.recursive, tree.maxDepth = true, *maxDepth
+			levels := max(*maxDepth, 1)
+			tree.more = func(path string) *styles.TreeNode {
+				return styles.MoreLevelsNode(levels, moreCommand(exePath, listFlags, levels, path))
 			}
-
-			mode := info.Mode().String()
-			modTime := info.ModTime().Format("Jan _2 15:04")
-
-			nodes = append(nodes, &styles.TreeNode{
-				Icon:  icon,
-				IsDir: entry.IsDir(),
-				Value: styles.HTMLEscape(styles.ShellQuote(entry.Name())),
-				Cells: []string{
-					styles.HTMLEscape(entry.Name()),
-					styles.HTMLEscape(mode),
-					styles.HTMLEscape(modTime),
-					styles.FormatSize(info.Size()),
-				},
-			})
 		}
+		nodes := tree.nodes(absDir, sortedEntries, 1)
 
 		config := styles.TreeTableConfig{
-			Columns: []styles.Column{
-				{Class: "name"},
-				{Class: "mode"},
-				{Class: "date"},
-				{Class: "size"},
-			},
+			Columns:      tree.columns,
 			TogglePrefix: "lsh",
 			TreeID:       "lsh",
+			Label:        absDir,
 		}
 
 		styles.ResetTreeNodeCounter()
 		html.WriteString(styles.RenderTreeTable(nodes, config))
 	} else {
 		// Default: compact wrapped grid with TokenGrid data attributes
-		html.WriteString(`<div class="lsh-grid token-grid" data-grid-id="lsh" tabindex="0">`)
+		html.WriteString(`<div class="lsh-grid token-grid" role="listbox" aria-multiselectable="true" aria-label="` + styles.HTMLEscape(absDir) + `" data-grid-id="lsh" tabindex="0">`)
 		for i, entry := range sortedEntries {
 			info, err := entry.Info()
 			if err != nil {
@@ -232,14 +259,30 @@ func main() {
 				itemType = "dir"
 			}
 
+			itemClass := "lsh-item token-item"
+			badge := ""
+			if ign.Ignored(filepath.Join(absDir, entry.Name()), entry.IsDir()) {
+				itemClass += " ignored"
+				badge = styles.IgnoredBadge
+			}
+
 			// Shell-quoted value for clipboard/insert operations
 			quotedValue := styles.ShellQuote(entry.Name())
 
-			html.WriteString(fmt.Sprintf(`<span class="lsh-item token-item" data-id="%d" data-value="%s" data-type="%s">`,
-				i, styles.HTMLEscape(quotedValue), itemType))
-			html.WriteString(`<span class="shell-icon">` + icon + `</span>`)
+			style := ""
+			if s := heat.style(info); s != "" {
+				style = ` style="` + styles.HTMLEscape(s) + `"`
+			}
+
+			html.WriteString(fmt.Sprintf(`<span class="%s" role="option" aria-selected="false" data-id="%d" data-value="%s" data-type="%s"%s>`,
+				itemClass, i, styles.HTMLEscape(quotedValue), itemType, style))
+			html.WriteString(`<span class="shell-icon" aria-hidden="true">` + icon + `</span>`)
 			html.WriteString(fmt.Sprintf(`<span class="%s">%s</span>`, nameClass, styles.HTMLEscape(entry.Name())))
-			html.WriteString(fmt.Sprintf(`<span class="lsh-size">%s</span>`, styles.FormatSize(info.Size())))
+			html.WriteString(fmt.Sprintf(`<span class="lsh-size">%s</span>`, styles.FormatSizeIn(info.Size(), opts.units)))
+			if info.Mode().IsRegular() {
+				html.WriteString(downloadLink(filepath.Join(absDir, entry.Name())))
+			}
+			html.WriteString(badge)
 			html.WriteString(`</span>`)
 		}
 		html.WriteString(`</div>`)
@@ -257,9 +300,300 @@ func main() {
 	os.Stdout.Sync()
 }
 
-func getActiveClass(isActive bool) string {
-	if isActive {
-		return " active"
+// downloadLink renders a link that downloads the file at path through
+// goshell's /download. It is relative, to work wherever the server is
+// mounted; the web UI's panel adds the session token when it is clicked.
+func downloadLink(path string) string {
+	href := "download?path=" + url.QueryEscape(path)
+	return `<a class="shell-download" href="` + styles.HTMLEscape(href) + `" download title="Download" aria-label="Download ` +
+		styles.HTMLEscape(filepath.Base(path)) + `">⬇</a>`
+}
+
+// renderSortButtons renders the Name/Date/Size/reverse buttons, each
+// re-running lsh on absDir with baseFlags plus its sort flag.
+func renderSortButtons(exePath, baseFlags, absDir string, sortTime, sortSize bool) string {
+	cmd := func(sortFlag string) string {
+		return styles.ShellQuote(exePath) + baseFlags + sortFlag + " " + styles.ShellQuote(absDir)
+	}
+	return styles.SortButton("Name", "Sort by name", cmd(""), !sortSize && !sortTime) +
+		styles.SortButton("Date", "Sort by date", cmd(" -t"), sortTime) +
+		styles.SortButton("Size", "Sort by size", cmd(" -S"), sortSize) +
+		styles.SortButton("↕", "Reverse sort order", cmd(" -r"), false)
+}
+
+// filterEntries drops hidden entries unless -a or -A asks for them. The
+// result is a new slice.
+func filterEntries(entries []os.DirEntry, showAll, showAlmostAll bool) []os.DirEntry {
+	var filtered []os.DirEntry
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasPrefix(name, ".") {
+			if showAll {
+				// -a: show all entries including . and ..
+				filtered = append(filtered, entry)
+			} else if showAlmostAll {
+				// -A: show dot files except . and ..
+				if name != "." && name != ".." {
+					filtered = append(filtered, entry)
+				}
+			}
+			// Otherwise skip hidden files
+		} else {
+			filtered = append(filtered, entry)
+		}
+	}
+	return filtered
+}
+
+// sortEntries orders entries by name, or by time with -t or size with -S,
+// reversed with -r.
+func sortEntries(entries []os.DirEntry, sortTime, sortSize, sortReverse bool) {
+	if sortTime {
+		sort.Slice(entries, func(i, j int) bool {
+			infoI, _ := entries[i].Info()
+			infoJ, _ := entries[j].Info()
+			if sortReverse {
+				return infoI.ModTime().Before(infoJ.ModTime())
+			}
+			return infoI.ModTime().After(infoJ.ModTime())
+		})
+	} else if sortSize {
+		sort.Slice(entries, func(i, j int) bool {
+			infoI, _ := entries[i].Info()
+			infoJ, _ := entries[j].Info()
+			if sortReverse {
+				return infoI.Size() < infoJ.Size()
+			}
+			return infoI.Size() > infoJ.Size()
+		})
+	} else {
+		// Sort by name
+		sort.Slice(entries, func(i, j int) bool {
+			if sortReverse {
+				return entries[i].Name() > entries[j].Name()
+			}
+			return entries[i].Name() < entries[j].Name()
+		})
+	}
+}
+
+// sortFlags returns the flags that reproduce the sort order.
+func sortFlags(sortTime, sortSize, sortReverse bool) string {
+	var f string
+	if sortTime {
+		f += " -t"
+	} else if sortSize {
+		f += " -S"
+	}
+	if sortReverse {
+		f += " -r"
+	}
+	return f
+}
+
+// refreshCommand re-runs lsh on absDir with flags, replacing the widget
+// emitted under key.
+func refreshCommand(exePath, flags, key, absDir string) string {
+	return styles.ShellQuote(exePath) + flags + " -key " + styles.ShellQuote(key) + " " + styles.ShellQuote(absDir)
+}
+
+// moreCommand lists path, a directory at -R's depth limit, levels deeper
+// with flags, the listing's other flags. Its widget is a new one.
+func moreCommand(exePath, flags string, levels int, path string) string {
+	return styles.ShellQuote(exePath) + flags + " -R -d " + styles.ShellQuote(strconv.Itoa(levels)) + " " + styles.ShellQuote(path)
+}
+
+// treeListing builds the rows of a long-format or -R listing.
+type treeListing struct {
+	opts    longOptions
+	columns []styles.Column
+	ign     *ignore.Matcher
+	heat    *listingHeat
+
+	// -R: subdirectories are listed below their rows down to maxDepth (-1
+	// for no limit), filtered and sorted like the top level; those at the
+	// limit get more(path) instead, a row to list them deeper
+	recursive                       bool
+	maxDepth                        int
+	more                            func(path string) *styles.TreeNode
+	showAll, showAlmostAll          bool
+	sortTime, sortSize, sortReverse bool
+}
+
+// nodes returns the rows for entries of dir, which are depth levels below
+// the listed directory, its own entries being at 1.
+func (l *treeListing) nodes(dir string, entries []os.DirEntry, depth int) []*styles.TreeNode {
+	var nodes []*styles.TreeNode
+	for _, entry := range entries {
+		node, err := longFormatNode(dir, entry, l.opts, l.columns)
+		if err != nil {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		if l.ign.Ignored(path, entry.IsDir()) {
+			node.Class = "ignored"
+			node.Cells[0] += styles.IgnoredBadge
+		}
+		if info, err := entry.Info(); err == nil {
+			node.Style = l.heat.style(info)
+		}
+		if l.recursive && entry.IsDir() {
+			node.Children = append(node.Children, l.subdir(path, depth)...)
+			node.Expandable = len(node.Children) > 0
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes
+}
+
+// subdir returns the rows below the directory at path, at depth.
+func (l *treeListing) subdir(path string, depth int) []*styles.TreeNode {
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil
+	}
+	entries = filterEntries(entries, l.showAll, l.showAlmostAll)
+	if len(entries) == 0 {
+		return nil
+	}
+	if l.maxDepth >= 0 && depth >= l.maxDepth {
+		if l.more == nil {
+			return nil
+		}
+		return []*styles.TreeNode{l.more(path)}
+	}
+	sortEntries(entries, l.sortTime, l.sortSize, l.sortReverse)
+	return l.nodes(path, entries, depth+1)
+}
+
+// longOptions selects the optional long-format columns and the units
+// sizes are shown in.
+type longOptions struct {
+	inode  bool // -i
+	blocks bool // -s
+	xattr  bool // -xattr
+	units  styles.SizeUnits
+}
+
+// flags returns the command-line flags that reproduce o, for the sort
+// buttons to carry over.
+func (o longOptions) flags() string {
+	var f string
+	if o.inode {
+		f += " -i"
+	}
+	if o.blocks {
+		f += " -s"
+	}
+	if o.xattr {
+		f += " -xattr"
+	}
+	// Binary units only need a flag when they override GOSHELL_SI
+	switch {
+	case o.units == styles.SIUnits:
+		f += " -si"
+	case styles.SizeUnitsFromEnv() == styles.SIUnits:
+		f += " -si=false"
+	}
+	return f
+}
+
+var longFormatCSS = `
+.tree-cell.inode,
+.tree-cell.blocks {
+	margin-left: 8px;
+	color: ` + styles.Colors.TextGray + `;
+	white-space: nowrap;
+	flex-shrink: 0;
+	min-width: 40px;
+	text-align: right;
+}
+.tree-cell.xattr {
+	margin-left: 8px;
+	color: ` + styles.Colors.TextGray + `;
+	white-space: nowrap;
+	flex-shrink: 0;
+	min-width: 80px;
+}
+`
+
+// longFormatColumns returns the long-format columns: inode and blocks
+// lead the metadata like ls -is, the xattr summary comes last.
+func longFormatColumns(o longOptions) []styles.Column {
+	columns := []styles.Column{{Class: "name"}}
+	if o.inode {
+		columns = append(columns, styles.Column{Class: "inode"})
+	}
+	if o.blocks {
+		columns = append(columns, styles.Column{Class: "blocks"})
+	}
+	columns = append(columns,
+		styles.Column{Class: "mode"},
+		styles.Column{Class: "date"},
+		styles.Column{Class: "size"},
+	)
+	if o.xattr {
+		columns = append(columns, styles.Column{Class: "xattr"})
+	}
+	return columns
+}
+
+// longFormatNode builds the tree-table row for one entry of dir. With
+// --xattr, files carrying extended attributes expand to one row each.
+func longFormatNode(dir string, entry os.DirEntry, o longOptions, columns []styles.Column) (*styles.TreeNode, error) {
+	info, err := entry.Info()
+	if err != nil {
+		return nil, err
+	}
+
+	icon := "📄"
+	if entry.IsDir() {
+		icon = "📁"
+	}
+
+	node := &styles.TreeNode{
+		Icon:  icon,
+		IsDir: entry.IsDir(),
+		Value: styles.HTMLEscape(styles.ShellQuote(entry.Name())),
+	}
+
+	ino, blocks, statOK := statBlocks(info)
+	for _, col := range columns {
+		var cell string
+		switch col.Class {
+		case "name":
+			cell = styles.HTMLEscape(entry.Name())
+			if info.Mode().IsRegular() {
+				cell += downloadLink(filepath.Join(dir, entry.Name()))
+			}
+		case "inode":
+			if statOK {
+				cell = fmt.Sprint(ino)
+			}
+		case "blocks":
+			if statOK {
+				cell = fmt.Sprint(blocks)
+			}
+		case "mode":
+			cell = styles.HTMLEscape(info.Mode().String())
+		case "date":
+			cell = styles.HTMLEscape(info.ModTime().Format("Jan _2 15:04"))
+		case "size":
+			cell = styles.FormatSizeIn(info.Size(), o.units)
+		case "xattr":
+			attrs, err := readXattrs(filepath.Join(dir, entry.Name()))
+			switch {
+			case errors.Is(err, errXattrUnsupported):
+				cell = "–"
+			case err != nil:
+				cell = "?"
+			default:
+				cell = styles.HTMLEscape(xattrSummary(attrs, o.units))
+				node.Children = xattrNodes(attrs, columns, o.units)
+				node.Expandable = len(node.Children) > 0
+			}
+		}
+		node.Cells = append(node.Cells, cell)
 	}
-	return ""
+	return node, nil
 }
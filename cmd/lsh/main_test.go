@@ -0,0 +1,156 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"shellserver/internal/htmlsanitize"
+	"shellserver/internal/styles"
+)
+
+func TestRenderSortButtons(t *testing.T) {
+	html := renderSortButtons("/opt/bin/lsh", " -a", "/tmp/x", true, false)
+
+	if strings.Contains(html, "<a ") {
+		t.Error("sort controls rendered as anchors, want buttons")
+	}
+	if n := strings.Count(html, `<button type="button"`); n != 4 {
+		t.Errorf("rendered %d buttons, want 4", n)
+	}
+	for _, want := range []string{
+		`aria-label="Sort by date"`,
+		`class="shell-sort-btn active" aria-pressed="true" aria-label="Sort by date"`,
+		`aria-label="Reverse sort order"`,
+		`runCommand(&quot;&#39;/opt/bin/lsh&#39; -a -t &#39;/tmp/x&#39;&quot;)`,
+	} {
+		if !strings.Contains(html, want) {
+			t.Errorf("sort buttons missing %q:\n%s", want, html)
+		}
+	}
+}
+
+func TestLongFormatColumns(t *testing.T) {
+	var classes []string
+	for _, col := range longFormatColumns(longOptions{inode: true, blocks: true, xattr: true}) {
+		classes = append(classes, col.Class)
+	}
+	if got, want := strings.Join(classes, " "), "name inode blocks mode date size xattr"; got != want {
+		t.Errorf("columns = %q, want %q", got, want)
+	}
+	if got, want := (longOptions{inode: true, xattr: true}).flags(), " -i -xattr"; got != want {
+		t.Errorf("flags = %q, want %q", got, want)
+	}
+}
+
+func TestLongOptionsUnitFlags(t *testing.T) {
+	tests := []struct {
+		env   string
+		units styles.SizeUnits
+		want  string
+	}{
+		{"", styles.BinaryUnits, ""},
+		{"", styles.SIUnits, " -si"},
+		{"1", styles.SIUnits, " -si"},
+		{"1", styles.BinaryUnits, " -si=false"},
+	}
+	for _, tt := range tests {
+		t.Setenv("GOSHELL_SI", tt.env)
+		if got := (longOptions{units: tt.units}).flags(); got != tt.want {
+			t.Errorf("GOSHELL_SI=%q units %v: flags = %q, want %q", tt.env, tt.units, got, tt.want)
+		}
+	}
+}
+
+func TestFormatXattrValue(t *testing.T) {
+	if got, want := formatXattrValue([]byte("text/plain\x00")), `"text/plain"`; got != want {
+		t.Errorf("printable value = %s, want %s", got, want)
+	}
+	if got, want := formatXattrValue([]byte{0x01, 0x02, 0xff}), "01 02 ff"; got != want {
+		t.Errorf("binary value = %s, want %s", got, want)
+	}
+	long := formatXattrValue(make([]byte, maxXattrDump+10))
+	if !strings.HasSuffix(long, "… (10 more bytes)") || strings.Count(long, "00") != maxXattrDump {
+		t.Errorf("long binary value not capped: %s", long)
+	}
+}
+
+func TestTreeListingMoreLevels(t *testing.T) {
+	root := t.TempDir()
+	for _, dir := range []string{"a/b/c", "a/.hidden/x", "dots/.only", "empty"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	os.WriteFile(filepath.Join(root, "a", "b", "c", "f"), []byte("x"), 0o644)
+
+	const flags = " -A -l -gitignore -t"
+	tree := &treeListing{
+		columns:       longFormatColumns(longOptions{}),
+		recursive:     true,
+		maxDepth:      2,
+		showAlmostAll: true,
+		more: func(path string) *styles.TreeNode {
+			return styles.MoreLevelsNode(2, moreCommand("/opt/bin/lsh", flags, 2, path))
+		},
+	}
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	styles.ResetTreeNodeCounter()
+	html := styles.RenderTreeTable(tree.nodes(root, filterEntries(entries, false, true), 1), styles.TreeTableConfig{Columns: tree.columns})
+
+	// Depth 2 lists a/b and a/.hidden (-A) and dots/.only; b/c, .hidden/x
+	// and nothing else are cut off
+	for _, want := range []string{">b<", ">.hidden<", ">.only<"} {
+		if !strings.Contains(html, want) {
+			t.Errorf("listing missing %s", want)
+		}
+	}
+	if strings.Contains(html, ">c<") || strings.Contains(html, ">x<") {
+		t.Error("listing goes past -d 2")
+	}
+	if n := strings.Count(html, "show 2 more levels here"); n != 2 {
+		t.Errorf("%d cutoff rows, want 2 (a/b and a/.hidden):\n%s", n, html)
+	}
+	cmd := moreCommand("/opt/bin/lsh", flags, 2, filepath.Join(root, "a", "b"))
+	if !strings.Contains(cmd, "-A -l -gitignore -t -R -d '2' ") || !strings.Contains(html, styles.HTMLEscape(cmd)) {
+		t.Errorf("no cutoff row running %s:\n%s", cmd, html)
+	}
+
+	// Without -a or -A, directories holding only dot files aren't cut off
+	tree.showAlmostAll = false
+	if html := styles.RenderTreeTable(tree.nodes(root, filterEntries(entries, false, false), 1), styles.TreeTableConfig{}); strings.Count(html, "more-levels") != 1 {
+		t.Errorf("want one cutoff row, for a/b, without -A:\n%s", html)
+	}
+}
+
+func TestDownloadLinks(t *testing.T) {
+	root := t.TempDir()
+	os.WriteFile(filepath.Join(root, "a b&c.txt"), []byte("x"), 0o644)
+	os.Mkdir(filepath.Join(root, "sub"), 0o755)
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	link := downloadLink(filepath.Join(root, "a b&c.txt"))
+	if want := `href="download?path=` + styles.HTMLEscape(strings.ReplaceAll(filepath.Join(root, "a+b%26c.txt"), "/", "%2F")) + `"`; !strings.Contains(link, want) {
+		t.Errorf("link %s, want %s", link, want)
+	}
+	if got := string(htmlsanitize.Sanitize([]byte(link))); got != link {
+		t.Errorf("sanitizing the link changed it to %s", got)
+	}
+
+	for _, entry := range entries {
+		node, err := longFormatNode(root, entry, longOptions{}, longFormatColumns(longOptions{}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if has := strings.Contains(node.Cells[0], `class="shell-download"`); has != !entry.IsDir() {
+			t.Errorf("%s: name cell %s, want a download link only for files", entry.Name(), node.Cells[0])
+		}
+	}
+}
@@ -0,0 +1,114 @@
+//go:build linux
+
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// statBlocks returns the inode number and the allocated size in 1K
+// blocks (as ls -s prints them) for info.
+func statBlocks(info fs.FileInfo) (ino uint64, blocks int64, ok bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, false
+	}
+	return st.Ino, st.Blocks / 2, true
+}
+
+// listXattrs returns the names of path's extended attributes. Symlinks
+// are not followed, matching the rest of the long listing.
+func listXattrs(path string) ([]string, error) {
+	buf, err := xattrCall(func(dest []byte) (int, error) {
+		return llistxattr(path, dest)
+	})
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, name := range strings.Split(string(buf), "\x00") {
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+// getXattr returns the value of path's extended attribute name.
+func getXattr(path, name string) ([]byte, error) {
+	return xattrCall(func(dest []byte) (int, error) {
+		return lgetxattr(path, name, dest)
+	})
+}
+
+// xattrCall sizes the buffer with a zero-length call first, retrying if
+// the attribute grows in between.
+func xattrCall(call func(dest []byte) (int, error)) ([]byte, error) {
+	for {
+		size, err := call(nil)
+		if err != nil {
+			return nil, xattrError(err)
+		}
+		if size == 0 {
+			return nil, nil
+		}
+		buf := make([]byte, size)
+		n, err := call(buf)
+		if errors.Is(err, syscall.ERANGE) {
+			continue
+		}
+		if err != nil {
+			return nil, xattrError(err)
+		}
+		return buf[:n], nil
+	}
+}
+
+func xattrError(err error) error {
+	if errors.Is(err, syscall.ENOTSUP) {
+		return errXattrUnsupported
+	}
+	return err
+}
+
+// The syscall package only wraps the symlink-following variants.
+
+func llistxattr(path string, dest []byte) (int, error) {
+	p, err := syscall.BytePtrFromString(path)
+	if err != nil {
+		return 0, err
+	}
+	var d unsafe.Pointer
+	if len(dest) > 0 {
+		d = unsafe.Pointer(&dest[0])
+	}
+	n, _, errno := syscall.Syscall(syscall.SYS_LLISTXATTR, uintptr(unsafe.Pointer(p)), uintptr(d), uintptr(len(dest)))
+	if errno != 0 {
+		return 0, errno
+	}
+	return int(n), nil
+}
+
+func lgetxattr(path, name string, dest []byte) (int, error) {
+	p, err := syscall.BytePtrFromString(path)
+	if err != nil {
+		return 0, err
+	}
+	a, err := syscall.BytePtrFromString(name)
+	if err != nil {
+		return 0, err
+	}
+	var d unsafe.Pointer
+	if len(dest) > 0 {
+		d = unsafe.Pointer(&dest[0])
+	}
+	n, _, errno := syscall.Syscall6(syscall.SYS_LGETXATTR, uintptr(unsafe.Pointer(p)), uintptr(unsafe.Pointer(a)), uintptr(d), uintptr(len(dest)), 0, 0)
+	if errno != 0 {
+		return 0, errno
+	}
+	return int(n), nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+
+	"shellserver/internal/styles"
+)
+
+func TestLongFormatNodeStat(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "f"), make([]byte, 8192), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var st syscall.Stat_t
+	if err := syscall.Lstat(filepath.Join(dir, "f"), &st); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := longOptions{inode: true, blocks: true}
+	node, err := longFormatNode(dir, entries[0], opts, longFormatColumns(opts))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := node.Cells[1]; got != strconv.FormatUint(st.Ino, 10) {
+		t.Errorf("inode cell = %q, want %d", got, st.Ino)
+	}
+	if got := node.Cells[2]; got != strconv.FormatInt(st.Blocks/2, 10) {
+		t.Errorf("blocks cell = %q, want %d", got, st.Blocks/2)
+	}
+}
+
+func TestLongFormatNodeXattr(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "tagged")
+	if err := os.WriteFile(path, []byte("x"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := syscall.Setxattr(path, "user.comment", []byte("hello <world>"), 0); err != nil {
+		t.Skipf("filesystem refused xattr: %v", err)
+	}
+	if err := syscall.Setxattr(path, "user.blob", []byte{0x00, 0xde, 0xad}, 0); err != nil {
+		t.Skipf("filesystem refused xattr: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "plain"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := longOptions{xattr: true}
+	columns := longFormatColumns(opts)
+	var nodes []*styles.TreeNode
+	entries, _ := os.ReadDir(dir)
+	for _, entry := range entries {
+		node, err := longFormatNode(dir, entry, opts, columns)
+		if err != nil {
+			t.Fatal(err)
+		}
+		nodes = append(nodes, node)
+	}
+
+	plain, tagged := nodes[0], nodes[1]
+	if plain.Expandable || plain.Cells[len(columns)-1] != "" {
+		t.Errorf("file without xattrs rendered as %+v", plain)
+	}
+	if !tagged.Expandable || len(tagged.Children) != 2 {
+		t.Fatalf("tagged file has %d xattr rows, want 2", len(tagged.Children))
+	}
+	if got, want := tagged.Cells[len(columns)-1], "2 attrs, 16 B"; got != want {
+		t.Errorf("xattr summary = %q, want %q", got, want)
+	}
+
+	styles.ResetTreeNodeCounter()
+	html := styles.RenderTreeTable(nodes, styles.TreeTableConfig{Columns: columns, TogglePrefix: "lsh", TreeID: "lsh"})
+	for _, want := range []string{
+		`user.comment = &quot;hello &lt;world&gt;&quot;`,
+		`user.blob = 00 de ad`,
+		`aria-expanded="false"`,
+	} {
+		if !strings.Contains(html, want) {
+			t.Errorf("rendered listing missing %q:\n%s", want, html)
+		}
+	}
+}
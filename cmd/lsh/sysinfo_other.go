@@ -0,0 +1,19 @@
+//go:build !linux
+
+package main
+
+import "io/fs"
+
+// statBlocks is not implemented on this platform; the inode and block
+// columns render as blanks.
+func statBlocks(info fs.FileInfo) (ino uint64, blocks int64, ok bool) {
+	return 0, 0, false
+}
+
+func listXattrs(path string) ([]string, error) {
+	return nil, errXattrUnsupported
+}
+
+func getXattr(path, name string) ([]byte, error) {
+	return nil, errXattrUnsupported
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"shellserver/internal/styles"
+)
+
+// maxXattrDump caps how many bytes of a binary attribute value are
+// hex-dumped in the expanded row.
+const maxXattrDump = 64
+
+var errXattrUnsupported = errors.New("extended attributes not supported")
+
+// xattr is one extended attribute of a file.
+type xattr struct {
+	name  string
+	value []byte
+}
+
+// readXattrs returns path's extended attributes. Attributes that vanish
+// or can't be read between listing and reading are skipped.
+func readXattrs(path string) ([]xattr, error) {
+	names, err := listXattrs(path)
+	if err != nil {
+		return nil, err
+	}
+	var attrs []xattr
+	for _, name := range names {
+		value, err := getXattr(path, name)
+		if err != nil {
+			continue
+		}
+		attrs = append(attrs, xattr{name: name, value: value})
+	}
+	return attrs, nil
+}
+
+// xattrSummary is the xattr column text: the attribute count and total
+// value size.
+func xattrSummary(attrs []xattr, units styles.SizeUnits) string {
+	if len(attrs) == 0 {
+		return ""
+	}
+	var total int64
+	for _, a := range attrs {
+		total += int64(len(a.value))
+	}
+	noun := "attrs"
+	if len(attrs) == 1 {
+		noun = "attr"
+	}
+	return fmt.Sprintf("%d %s, %s", len(attrs), noun, styles.FormatSizeIn(total, units))
+}
+
+// formatXattrValue renders a value as quoted text when it is printable,
+// otherwise as a hex dump of at most maxXattrDump bytes.
+func formatXattrValue(value []byte) string {
+	// Many tools store C strings with their terminating NUL.
+	text := strings.TrimSuffix(string(value), "\x00")
+	if utf8.ValidString(text) && strings.IndexFunc(text, func(r rune) bool {
+		return !unicode.IsPrint(r) && r != ' '
+	}) < 0 {
+		return fmt.Sprintf("%q", text)
+	}
+
+	dump := value
+	if len(dump) > maxXattrDump {
+		dump = dump[:maxXattrDump]
+	}
+	hex := fmt.Sprintf("% x", dump)
+	if len(value) > len(dump) {
+		hex += fmt.Sprintf(" … (%d more bytes)", len(value)-len(dump))
+	}
+	return hex
+}
+
+// xattrNodes builds one child row per attribute, laid out for the
+// given long-format columns.
+func xattrNodes(attrs []xattr, columns []styles.Column, units styles.SizeUnits) []*styles.TreeNode {
+	var nodes []*styles.TreeNode
+	for _, a := range attrs {
+		cells := make([]string, len(columns))
+		for i, col := range columns {
+			switch col.Class {
+			case "name":
+				cells[i] = styles.HTMLEscape(a.name + " = " + formatXattrValue(a.value))
+			case "size":
+				cells[i] = styles.FormatSizeIn(int64(len(a.value)), units)
+			}
+		}
+		nodes = append(nodes, &styles.TreeNode{
+			Icon:  "🏷",
+			Cells: cells,
+		})
+	}
+	return nodes
+}
@@ -0,0 +1,166 @@
+// Command serveh serves a directory read-only over goshell's HTTP server,
+// for fetching build output and the like from another machine.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"shellserver/internal/styles"
+	"shellserver/pkg/protocol"
+	"shellserver/pkg/widgetcli"
+)
+
+var tool = widgetcli.Tool{
+	Name:    "serveh",
+	Summary: "serve a directory read-only through the goshell server",
+	Usage:   "[directory]",
+	Examples: []widgetcli.Example{
+		{Args: []string{"dist"}, Description: "share ./dist for the default time"},
+		{Args: []string{"-ttl", "10m", "."}, Description: "share the current directory for ten minutes"},
+	},
+}
+
+// mount is a directory the server serves, as POST /files reports it.
+type mount struct {
+	Token   string    `json:"token"`
+	Dir     string    `json:"dir"`
+	Expires time.Time `json:"expires"`
+	URL     string    `json:"url"`
+}
+
+func main() {
+	stop := flag.String("stop", "", "stop serving the mount with this token")
+	ttl := flag.Duration("ttl", 0, "how long to serve the directory (0 for the server's -files-ttl)")
+	tool.Parse(os.Args[1:])
+
+	server := os.Getenv("GOSHELL_URL")
+	if server == "" {
+		fail(errors.New("GOSHELL_URL is not set; run serveh inside goshell"))
+	}
+
+	if *stop != "" {
+		if err := unmount(server, *stop); err != nil {
+			fail(err)
+		}
+		fmt.Printf("stopped serving %s\n", *stop)
+		return
+	}
+
+	dir := "."
+	if flag.NArg() > 0 {
+		dir = flag.Arg(0)
+	}
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		fail(err)
+	}
+	m, err := mountDir(server, absDir, *ttl)
+	if err != nil {
+		fail(err)
+	}
+	if widgetcli.InGoshell() {
+		fmt.Print(styles.HTMLStart)
+		fmt.Print(renderHTML(m))
+		fmt.Println(styles.HTMLEnd)
+		return
+	}
+	fmt.Println(m.URL)
+	fmt.Printf("serving %s until %s; stop with: serveh -stop %s\n", m.Dir, m.Expires.Format("15:04"), m.Token)
+}
+
+func fail(err error) {
+	fmt.Fprintf(os.Stderr, "serveh: %v\n", err)
+	os.Exit(1)
+}
+
+// mountDir asks the server at base to serve dir for ttl.
+func mountDir(base, dir string, ttl time.Duration) (*mount, error) {
+	req := map[string]string{"dir": dir}
+	if ttl > 0 {
+		req["ttl"] = ttl.String()
+	}
+	body, _ := json.Marshal(req)
+	httpReq, err := http.NewRequest(http.MethodPost, base+"/files", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	resp, err := do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, apiError(resp)
+	}
+	var m mount
+	if err := json.NewDecoder(resp.Body).Decode(&m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// unmount asks the server at base to stop serving token.
+func unmount(base, token string) error {
+	req, err := http.NewRequest(http.MethodDelete, base+"/files/"+token, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		return apiError(resp)
+	}
+	return nil
+}
+
+// do sends req with the session's token, which mounting and unmounting
+// need.
+func do(req *http.Request) (*http.Response, error) {
+	if token := os.Getenv("GOSHELL_TOKEN"); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return http.DefaultClient.Do(req)
+}
+
+// apiError turns an error response's envelope into an error.
+func apiError(resp *http.Response) error {
+	var env protocol.ErrorResponse
+	if err := json.NewDecoder(resp.Body).Decode(&env); err != nil || env.Error.Message == "" {
+		return fmt.Errorf("server: %s", resp.Status)
+	}
+	return errors.New(env.Error.Message)
+}
+
+// renderHTML is the widget card: the link, the expiry and how to stop.
+func renderHTML(m *mount) string {
+	return `<style>` + styles.BaseCSS() + `
+.serveh-url a {
+	color: ` + styles.Colors.Blue + `;
+}
+.serveh-stop {
+	color: ` + styles.Colors.TextGray + `;
+	font-size: 11px;
+}
+</style>
+<div class="shell-container">
+<div class="shell-header">
+<div class="shell-title">serveh</div>
+<div class="shell-meta">` + styles.HTMLEscape(m.Dir) + ` until ` + styles.HTMLEscape(m.Expires.Format("15:04")) + `</div>
+</div>
+<p class="serveh-url"><a href="` + styles.HTMLEscape(m.URL) + `" target="_blank" rel="noopener">` + styles.HTMLEscape(m.URL) + `</a></p>
+<p class="serveh-stop">stop with <code>serveh -stop ` + styles.HTMLEscape(m.Token) + `</code></p>
+</div>
+`
+}
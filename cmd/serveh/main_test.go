@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMountAndUnmount(t *testing.T) {
+	var got map[string]string
+	var deleted string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/files":
+			json.NewDecoder(r.Body).Decode(&got)
+			json.NewEncoder(w).Encode(mount{Token: "abc", Dir: got["dir"], URL: "http://host:7777/files/abc/"})
+		case r.Method == http.MethodDelete && r.URL.Path == "/files/abc":
+			deleted = "abc"
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error":{"code":"mount_not_found","message":"no mount nope"}}`))
+		}
+	}))
+	defer ts.Close()
+
+	m, err := mountDir(ts.URL, "/srv/dist", 10*time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got["dir"] != "/srv/dist" || got["ttl"] != "10m0s" || m.Token != "abc" || m.URL != "http://host:7777/files/abc/" {
+		t.Errorf("request %v, mount %+v", got, m)
+	}
+	if html := renderHTML(m); !strings.Contains(html, `href="http://host:7777/files/abc/"`) || !strings.Contains(html, "serveh -stop abc") {
+		t.Errorf("widget:\n%s", html)
+	}
+
+	if err := unmount(ts.URL, "abc"); err != nil || deleted != "abc" {
+		t.Errorf("unmount: %v, deleted %q", err, deleted)
+	}
+	if err := unmount(ts.URL, "nope"); err == nil || err.Error() != "no mount nope" {
+		t.Errorf("unmount unknown token: %v", err)
+	}
+}
@@ -5,6 +5,8 @@ go 1.21
 require (
 	github.com/creack/pty v1.1.21
 	github.com/gorilla/websocket v1.5.1
+	go.etcd.io/bbolt v1.3.10
+	golang.org/x/net v0.17.0
 )
 
-require golang.org/x/net v0.17.0 // indirect
+require golang.org/x/sys v0.13.0 // indirect
@@ -0,0 +1,171 @@
+// Package ansi removes terminal escape sequences from output, leaving the
+// text a reader would see, and finds where output can be cut without
+// splitting one.
+package ansi
+
+import (
+	"bytes"
+	"unicode/utf8"
+)
+
+// state is where a Stripper is within an escape sequence.
+type state int
+
+const (
+	ground   state = iota
+	escape         // after ESC
+	csi            // ESC [ ... final byte
+	str            // OSC, DCS, SOS, PM or APC: until BEL or ST
+	strEsc         // ESC inside a string, possibly starting ST
+	charset        // ESC ( and friends: one more byte
+	escInter       // ESC followed by intermediate bytes
+)
+
+// Stripper strips escape sequences from a stream. Sequences may be split
+// across calls to Strip. The zero value is ready to use.
+type Stripper struct {
+	state state
+}
+
+// Strip appends the text of data to dst, without escape sequences,
+// carriage returns or other C0 controls besides newline and tab, and
+// returns the extended slice.
+func (s *Stripper) Strip(dst, data []byte) []byte {
+	for _, c := range data {
+		if s.step(c) {
+			dst = append(dst, c)
+		}
+	}
+	return dst
+}
+
+// step advances s over c, reporting whether c is text.
+func (s *Stripper) step(c byte) (text bool) {
+	switch s.state {
+	case ground:
+		switch {
+		case c == 0x1b:
+			s.state = escape
+		case c == '\n' || c == '\t':
+			return true
+		case c < 0x20 || c == 0x7f:
+			// other controls (CR, BS, BEL...) carry no text
+		default:
+			return true
+		}
+	case escape:
+		switch {
+		case c == '[':
+			s.state = csi
+		case c == ']' || c == 'P' || c == 'X' || c == '^' || c == '_':
+			s.state = str
+		case c == '(' || c == ')' || c == '*' || c == '+':
+			s.state = charset
+		case c >= 0x20 && c <= 0x2f:
+			s.state = escInter
+		default:
+			s.state = ground // a two-byte sequence such as ESC 7
+		}
+	case csi:
+		if c >= 0x40 && c <= 0x7e {
+			s.state = ground
+		}
+	case str:
+		switch c {
+		case 0x07:
+			s.state = ground
+		case 0x1b:
+			s.state = strEsc
+		}
+	case strEsc:
+		if c == '\\' {
+			s.state = ground
+		} else {
+			s.state = str
+		}
+	case charset:
+		s.state = ground
+	case escInter:
+		if c >= 0x30 && c <= 0x7e {
+			s.state = ground
+		}
+	}
+	return false
+}
+
+// Strip returns s without escape sequences.
+func Strip(s string) string {
+	var st Stripper
+	return string(st.Strip(nil, []byte(s)))
+}
+
+// lineSlack is how much more than it must TrimFront drops to cut at the
+// start of a line.
+const lineSlack = 4096
+
+// TrimFront returns the tail of data, at most max bytes long, cut where
+// no escape sequence or UTF-8 character is split, so that it can be
+// replayed to a terminal on its own. It cuts at the start of a line when
+// one begins within lineSlack bytes of the earliest cut, and otherwise at
+// the first byte after that which starts a character outside any escape
+// sequence. data must begin outside an escape sequence.
+func TrimFront(data []byte, max int) []byte {
+	if len(data) <= max {
+		return data
+	}
+	cut := len(data) - max
+	var st Stripper
+	safe := -1
+	for i, c := range data {
+		if i >= cut && st.state == ground {
+			if i > 0 && data[i-1] == '\n' {
+				return data[i:]
+			}
+			if safe < 0 && utf8.RuneStart(c) {
+				safe = i
+			}
+		}
+		if safe >= 0 && i >= cut+lineSlack {
+			break
+		}
+		st.step(c)
+	}
+	if safe < 0 {
+		// the rest is one unfinished sequence
+		return data[len(data):]
+	}
+	return data[safe:]
+}
+
+// TrimLines returns the tail of data holding at most maxLines line breaks
+// and maxBytes bytes, starting at the beginning of a line outside any
+// escape sequence. Either limit may be 0 for none. If no line begins
+// within the last maxBytes, as when a program redraws one line with
+// carriage returns, it falls back to TrimFront. data must begin outside
+// an escape sequence.
+func TrimLines(data []byte, maxBytes, maxLines int) []byte {
+	cut := 0
+	if maxBytes > 0 && len(data) > maxBytes {
+		cut = len(data) - maxBytes
+	}
+	if maxLines > 0 {
+		for n := bytes.Count(data[cut:], []byte("\n")) - maxLines; n > 0; n-- {
+			i := bytes.IndexByte(data[cut:], '\n')
+			cut += i + 1
+		}
+	}
+	if cut == 0 {
+		return data
+	}
+	var st Stripper
+	for i, c := range data {
+		if i >= cut && st.state == ground && data[i-1] == '\n' {
+			return data[i:]
+		}
+		st.step(c)
+	}
+	if len(data) > 0 && st.state == ground && data[len(data)-1] == '\n' {
+		return data[len(data):]
+	}
+	return TrimFront(data, len(data)-cut)
+}
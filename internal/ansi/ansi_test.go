@@ -0,0 +1,97 @@
+package ansi
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestStrip(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"plain text\n", "plain text\n"},
+		{"\x1b[1;31merror\x1b[0m: boom\r\n", "error: boom\n"},
+		{"\x1b]0;title\x07prompt$ ", "prompt$ "},
+		{"\x1b]8;;http://x\x1b\\link\x1b]8;;\x1b\\", "link"},
+		{"\x1b[?1049h\x1b[Hvim\x1b[?1049l", "vim"},
+		{"\x1b(Bascii\x1b7saved\x1b8", "asciisaved"},
+		{"a\bb\x07c\td", "abc\td"},
+		{"héllo ✓ Û", "héllo ✓ Û"}, // Û's UTF-8 encoding contains 0x9b, the 8-bit CSI
+	}
+	for _, tt := range tests {
+		if got := Strip(tt.in); got != tt.want {
+			t.Errorf("Strip(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestStripperSplitSequences(t *testing.T) {
+	in := "\x1b[38;5;208mhot\x1b[0m \x1b]2;a title\x1b\\done\n"
+	for split := 0; split <= len(in); split++ {
+		var s Stripper
+		out := s.Strip(nil, []byte(in[:split]))
+		out = s.Strip(out, []byte(in[split:]))
+		if got := string(out); got != "hot done\n" {
+			t.Errorf("split at %d: %q", split, got)
+		}
+	}
+}
+
+func TestTrimFront(t *testing.T) {
+	long := strings.Repeat("x", lineSlack+10)
+	tests := []struct {
+		name string
+		in   string
+		max  int
+		want string
+	}{
+		{"short enough", "abc", 5, "abc"},
+		{"plain", "abcdef", 3, "def"},
+		{"mid CSI", "ab\x1b[38;5;208mred", 8, "red"},
+		{"at CSI", "ab\x1b[1mbold", 8, "\x1b[1mbold"},
+		{"mid OSC", "\x1b]0;a title\x07$ ", 8, "$ "},
+		{"mid OSC with ST", "\x1b]8;;http://x\x1b\\link", 6, "link"},
+		{"mid rune", "a✓b", 3, "b"},
+		{"next line", "one\ntwo\nthree", 7, "three"},
+		{"at line", "one\ntwo", 3, "two"},
+		{"newline inside OSC", "\x1b]1337;a\nb\x07" + long, lineSlack + 12, long},
+		{"line too far", long + "\nend", lineSlack + 4, long[10:] + "\nend"},
+		{"unfinished", "ab\x1b]0;never ends", 5, ""},
+	}
+	for _, tt := range tests {
+		got := string(TrimFront([]byte(tt.in), tt.max))
+		if got != tt.want {
+			t.Errorf("%s: TrimFront(%q, %d) = %q, want %q", tt.name, tt.in, tt.max, got, tt.want)
+		}
+		if len(got) > tt.max {
+			t.Errorf("%s: kept %d bytes, more than %d", tt.name, len(got), tt.max)
+		}
+	}
+}
+
+func TestTrimLines(t *testing.T) {
+	tests := []struct {
+		name               string
+		in                 string
+		maxBytes, maxLines int
+		want               string
+	}{
+		{"within limits", "one\ntwo\n", 100, 5, "one\ntwo\n"},
+		{"no limits", "one\ntwo\n", 0, 0, "one\ntwo\n"},
+		{"line count", "one\ntwo\nthree\n$ ", 0, 2, "two\nthree\n$ "},
+		{"bytes cut to next line", "one\ntwo\nthree\n", 9, 0, "three\n"},
+		{"bytes at line start", "one\ntwo\n", 4, 0, "two\n"},
+		{"both, lines tighter", "a\nb\nc\nd\n", 100, 1, "d\n"},
+		{"both, bytes tighter", "one\ntwo\nthree\n", 7, 2, "three\n"},
+		{"newline inside OSC", "\x1b]1337;a\nb\x07x\ny\n", 0, 2, "y\n"},
+		{"colored lines", "\x1b[31mred\x1b[0m\r\nplain\r\n", 0, 1, "plain\r\n"},
+		{"one long line", "ab\ncdefghij", 4, 0, "ghij"},
+		{"ends at cut line", "abcdef\n", 3, 0, ""},
+	}
+	for _, tt := range tests {
+		got := string(TrimLines([]byte(tt.in), tt.maxBytes, tt.maxLines))
+		if got != tt.want {
+			t.Errorf("%s: TrimLines(%q, %d, %d) = %q, want %q", tt.name, tt.in, tt.maxBytes, tt.maxLines, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,259 @@
+// Package cast reads and writes asciinema cast files (format version 2):
+// a JSON header line followed by one [time, type, data] event per line,
+// time being seconds since the start of the recording.
+//
+// Readers stream events one line at a time, so recordings of any length
+// can be scanned without loading them.
+package cast
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Version is the cast format version read and written.
+const Version = 2
+
+// Event types.
+const (
+	Output = "o" // bytes written to the terminal
+	Input  = "i" // bytes typed by the user
+	Resize = "r" // terminal resized, data "COLSxROWS"
+	Marker = "m" // a bookmark
+)
+
+// Header is the first line of a cast file.
+type Header struct {
+	Version   int               `json:"version"`
+	Width     int               `json:"width"`
+	Height    int               `json:"height"`
+	Timestamp int64             `json:"timestamp,omitempty"` // Unix seconds the recording started
+	Title     string            `json:"title,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+}
+
+// Start is when the recording began, or the zero time if unrecorded.
+func (h Header) Start() time.Time {
+	if h.Timestamp == 0 {
+		return time.Time{}
+	}
+	return time.Unix(h.Timestamp, 0)
+}
+
+// Event is one line after the header.
+type Event struct {
+	Time float64 // seconds since the start of the recording
+	Type string
+	Data string
+}
+
+// MarshalJSON encodes e as a [time, type, data] array. Output is left
+// unescaped for HTML, as asciinema writes it.
+func (e Event) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode([]any{json.Number(strconv.FormatFloat(e.Time, 'f', 6, 64)), e.Type, e.Data}); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// UnmarshalJSON decodes a [time, type, data] array.
+func (e *Event) UnmarshalJSON(data []byte) error {
+	var fields []json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	if len(fields) != 3 {
+		return fmt.Errorf("cast event has %d fields, want 3", len(fields))
+	}
+	if err := json.Unmarshal(fields[0], &e.Time); err != nil {
+		return fmt.Errorf("cast event time: %w", err)
+	}
+	if err := json.Unmarshal(fields[1], &e.Type); err != nil {
+		return fmt.Errorf("cast event type: %w", err)
+	}
+	if err := json.Unmarshal(fields[2], &e.Data); err != nil {
+		return fmt.Errorf("cast event data: %w", err)
+	}
+	return nil
+}
+
+// Writer appends events to a cast file.
+type Writer struct {
+	w   *bufio.Writer
+	enc *json.Encoder
+}
+
+// NewWriter writes h (with Version filled in) to w and returns a Writer
+// for the events that follow.
+func NewWriter(w io.Writer, h Header) (*Writer, error) {
+	bw := bufio.NewWriter(w)
+	cw := &Writer{w: bw, enc: json.NewEncoder(bw)}
+	cw.enc.SetEscapeHTML(false)
+	h.Version = Version
+	if err := cw.enc.Encode(h); err != nil {
+		return nil, err
+	}
+	return cw, nil
+}
+
+// WriteEvent appends one event. Events are buffered until Flush.
+func (cw *Writer) WriteEvent(e Event) error {
+	return cw.enc.Encode(e)
+}
+
+// Flush writes buffered events to the underlying writer.
+func (cw *Writer) Flush() error {
+	return cw.w.Flush()
+}
+
+// ErrFormat is returned for input that isn't a version 2 cast file.
+var ErrFormat = errors.New("not an asciinema v2 cast file")
+
+// Reader streams the events of a cast file.
+type Reader struct {
+	Header Header
+
+	r    *bufio.Reader
+	line int
+}
+
+// NewReader reads the header from r.
+func NewReader(r io.Reader) (*Reader, error) {
+	cr := &Reader{r: bufio.NewReaderSize(r, 64<<10)}
+	line, err := cr.readLine()
+	if err != nil {
+		if err == io.EOF {
+			return nil, ErrFormat
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(line, &cr.Header); err != nil || cr.Header.Version != Version {
+		return nil, ErrFormat
+	}
+	return cr, nil
+}
+
+// Next returns the next event, or io.EOF after the last one. Blank lines
+// are skipped; a truncated final line (a recording cut off mid-write) ends
+// the stream like EOF.
+func (cr *Reader) Next() (Event, error) {
+	for {
+		line, err := cr.readLine()
+		if err != nil {
+			return Event{}, err
+		}
+		if len(line) == 0 {
+			continue
+		}
+		var e Event
+		if err := json.Unmarshal(line, &e); err != nil {
+			if _, peekErr := cr.r.Peek(1); peekErr == io.EOF {
+				return Event{}, io.EOF
+			}
+			return Event{}, fmt.Errorf("cast line %d: %w", cr.line, err)
+		}
+		return e, nil
+	}
+}
+
+// readLine returns the next line without its newline.
+func (cr *Reader) readLine() ([]byte, error) {
+	line, err := cr.r.ReadBytes('\n')
+	if err != nil && (err != io.EOF || len(line) == 0) {
+		return nil, err
+	}
+	cr.line++
+	if n := len(line); n > 0 && line[n-1] == '\n' {
+		line = line[:n-1]
+	}
+	return line, nil
+}
+
+// Info summarises a cast file.
+type Info struct {
+	Header   Header
+	Duration float64 // time of the last event, in seconds
+	Size     int64   // file size in bytes
+}
+
+// tailSize is how much of the end of a file Stat reads to find the last
+// event; longer final lines are found by scanning the whole file.
+const tailSize = 64 << 10
+
+// Stat reads the header of the cast file at path and the time of its last
+// event, reading only the file's head and tail.
+func Stat(path string) (Info, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Info{}, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return Info{}, err
+	}
+	cr, err := NewReader(f)
+	if err != nil {
+		return Info{}, err
+	}
+	info := Info{Header: cr.Header, Size: fi.Size()}
+
+	if t, ok := lastEventTime(f, fi.Size()); ok {
+		info.Duration = t
+		return info, nil
+	}
+	// The tail held no complete event: scan from the start
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return Info{}, err
+	}
+	if cr, err = NewReader(f); err != nil {
+		return Info{}, err
+	}
+	for {
+		e, err := cr.Next()
+		if err != nil {
+			break
+		}
+		info.Duration = e.Time
+	}
+	return info, nil
+}
+
+// lastEventTime parses the last complete event line in the final tailSize
+// bytes of f.
+func lastEventTime(f *os.File, size int64) (float64, bool) {
+	off := size - tailSize
+	if off < 0 {
+		off = 0
+	}
+	buf := make([]byte, size-off)
+	if _, err := f.ReadAt(buf, off); err != nil && err != io.EOF {
+		return 0, false
+	}
+	for end := len(buf); end > 0; {
+		if buf[end-1] == '\n' {
+			end--
+			continue
+		}
+		start := bytes.LastIndexByte(buf[:end], '\n') + 1
+		if start == 0 && off > 0 {
+			return 0, false // the line began before the tail
+		}
+		var e Event
+		if json.Unmarshal(buf[start:end], &e) == nil {
+			return e.Time, true
+		}
+		end = start
+	}
+	return 0, false
+}
@@ -0,0 +1,112 @@
+package cast
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeCast(t *testing.T, h Header, events []Event) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range events {
+		if err := w.WriteEvent(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestRoundTrip(t *testing.T) {
+	events := []Event{
+		{0.25, Output, "\x1b[1mhello\x1b[0m <b>&</b>\r\n"},
+		{1.5, Input, "ls\r"},
+		{2, Resize, "100x30"},
+	}
+	data := writeCast(t, Header{Width: 80, Height: 24, Timestamp: 1700000000}, events)
+	if first, _, _ := strings.Cut(string(data), "\n"); first != `{"version":2,"width":80,"height":24,"timestamp":1700000000}` {
+		t.Errorf("header line = %s", first)
+	}
+	if !strings.Contains(string(data), `[0.250000,"o","\u001b[1mhello\u001b[0m <b>&</b>\r\n"]`) {
+		t.Errorf("event not written asciinema-style:\n%s", data)
+	}
+
+	r, err := NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Header.Width != 80 || r.Header.Start().Unix() != 1700000000 {
+		t.Errorf("header = %+v", r.Header)
+	}
+	for i, want := range events {
+		got, err := r.Next()
+		if err != nil || got != want {
+			t.Fatalf("event %d = %+v, %v; want %+v", i, got, err, want)
+		}
+	}
+	if _, err := r.Next(); err != io.EOF {
+		t.Errorf("after last event: %v, want EOF", err)
+	}
+}
+
+func TestReaderTruncatedAndInvalid(t *testing.T) {
+	data := writeCast(t, Header{Width: 80, Height: 24}, []Event{{1, Output, "a"}})
+	r, _ := NewReader(bytes.NewReader(append(data, `[2.0, "o", "cut of`...)))
+	r.Next()
+	if _, err := r.Next(); err != io.EOF {
+		t.Errorf("truncated final line: %v, want EOF", err)
+	}
+
+	r, _ = NewReader(bytes.NewReader(append(data, "garbage\n[3, \"o\", \"x\"]\n"...)))
+	r.Next()
+	if _, err := r.Next(); err == nil || err == io.EOF {
+		t.Errorf("corrupt line: %v, want an error", err)
+	}
+
+	for _, bad := range []string{"", "not json\n", `{"version":1}` + "\n"} {
+		if _, err := NewReader(strings.NewReader(bad)); !errors.Is(err, ErrFormat) {
+			t.Errorf("NewReader(%q) = %v, want ErrFormat", bad, err)
+		}
+	}
+}
+
+func TestStat(t *testing.T) {
+	dir := t.TempDir()
+	long := strings.Repeat("x", tailSize+10)
+	tests := []struct {
+		name     string
+		events   []Event
+		duration float64
+	}{
+		{"empty", nil, 0},
+		{"short", []Event{{0.5, Output, "a"}, {7.25, Output, "b"}}, 7.25},
+		// the final line doesn't fit in the tail: found by a full scan
+		{"long-last-line", []Event{{1, Output, "a"}, {9.5, Output, long}}, 9.5},
+		{"long-earlier-line", []Event{{1, Output, long}, {3, Output, "b"}}, 3},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(dir, tt.name+".cast")
+		data := writeCast(t, Header{Width: 120, Height: 40, Timestamp: 1700000000}, tt.events)
+		if err := os.WriteFile(path, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		info, err := Stat(path)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if info.Duration != tt.duration || info.Size != int64(len(data)) || info.Header.Width != 120 {
+			t.Errorf("%s: Stat = %+v, want duration %v, size %d", tt.name, info, tt.duration, len(data))
+		}
+	}
+}
@@ -0,0 +1,212 @@
+// Package diff computes and applies line-based patches between two
+// versions of a text.
+package diff
+
+import (
+	"errors"
+	"strings"
+)
+
+// ErrMismatch means a patch doesn't fit the text it was applied to.
+var ErrMismatch = errors.New("diff: patch does not apply")
+
+// Edit is one step of a patch. Applied in order to the old text, each
+// edit keeps or deletes its next Keep or Delete lines, or inserts Insert.
+// Exactly one field is set. Lines keep their trailing newlines.
+type Edit struct {
+	Keep   int      `json:"keep,omitempty"`
+	Delete int      `json:"del,omitempty"`
+	Insert []string `json:"ins,omitempty"`
+}
+
+// SplitLines splits s after each newline. The last line has no newline
+// if s doesn't end with one.
+func SplitLines(s string) []string {
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// Lines returns a patch turning old into new. It gives up, returning
+// false, when the texts differ by more than maxEdits inserted or deleted
+// lines, since the search grows with the square of that number.
+func Lines(old, new string, maxEdits int) ([]Edit, bool) {
+	a, b := SplitLines(old), SplitLines(new)
+
+	// Common prefix and suffix are kept without searching
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	var p patchBuilder
+	p.keep(prefix)
+	if !shortestEdit(&p, a[prefix:len(a)-suffix], b[prefix:len(b)-suffix], maxEdits) {
+		return nil, false
+	}
+	p.keep(suffix)
+	return p.edits, true
+}
+
+// Apply applies patch to old.
+func Apply(old string, patch []Edit) (string, error) {
+	lines := SplitLines(old)
+	var b strings.Builder
+	i := 0
+	for _, e := range patch {
+		switch {
+		case e.Keep > 0:
+			if i+e.Keep > len(lines) {
+				return "", ErrMismatch
+			}
+			for _, line := range lines[i : i+e.Keep] {
+				b.WriteString(line)
+			}
+			i += e.Keep
+		case e.Delete > 0:
+			if i+e.Delete > len(lines) {
+				return "", ErrMismatch
+			}
+			i += e.Delete
+		default:
+			for _, line := range e.Insert {
+				b.WriteString(line)
+			}
+		}
+	}
+	if i != len(lines) {
+		return "", ErrMismatch
+	}
+	return b.String(), nil
+}
+
+// patchBuilder appends edits, merging runs of the same kind.
+type patchBuilder struct {
+	edits []Edit
+}
+
+func (p *patchBuilder) last() *Edit {
+	if len(p.edits) == 0 {
+		return nil
+	}
+	return &p.edits[len(p.edits)-1]
+}
+
+func (p *patchBuilder) keep(n int) {
+	if n == 0 {
+		return
+	}
+	if e := p.last(); e != nil && e.Keep > 0 {
+		e.Keep += n
+		return
+	}
+	p.edits = append(p.edits, Edit{Keep: n})
+}
+
+func (p *patchBuilder) delete(n int) {
+	if e := p.last(); e != nil && e.Delete > 0 {
+		e.Delete += n
+		return
+	}
+	p.edits = append(p.edits, Edit{Delete: n})
+}
+
+func (p *patchBuilder) insert(line string) {
+	if e := p.last(); e != nil && e.Insert != nil {
+		e.Insert = append(e.Insert, line)
+		return
+	}
+	p.edits = append(p.edits, Edit{Insert: []string{line}})
+}
+
+// shortestEdit appends a minimal edit script from a to b to p, using
+// Myers' O(ND) algorithm. It reports false if more than max edits are
+// needed.
+func shortestEdit(p *patchBuilder, a, b []string, max int) bool {
+	n, m := len(a), len(b)
+	if max > n+m {
+		max = n + m
+	}
+
+	// v[offset+k] is the furthest x reached on diagonal k = x-y; trace
+	// keeps v's live range after each round for backtracking.
+	offset := max + 1
+	v := make([]int, 2*max+3)
+	var trace [][]int
+	found := false
+	for d := 0; d <= max && !found; d++ {
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1] // step down: insert b[y]
+			} else {
+				x = v[offset+k-1] + 1 // step right: delete a[x]
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				found = true
+				break
+			}
+		}
+		trace = append(trace, append([]int(nil), v[offset-d:offset+d+1]...))
+	}
+	if !found {
+		return false
+	}
+
+	// Walk back from (n, m), recording edits in reverse
+	type step struct {
+		op   byte // '=', '-', '+'
+		line string
+	}
+	var steps []step
+	x, y := n, m
+	for d := len(trace) - 1; d > 0; d-- {
+		prev := trace[d-1]
+		at := func(k int) int { return prev[k+d-1] }
+		k := x - y
+		prevK := k - 1
+		if k == -d || (k != d && at(k-1) < at(k+1)) {
+			prevK = k + 1
+		}
+		prevX := at(prevK)
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			x--
+			y--
+			steps = append(steps, step{'=', ""})
+		}
+		if prevK == k+1 {
+			steps = append(steps, step{'+', b[prevY]})
+		} else {
+			steps = append(steps, step{'-', ""})
+		}
+		x, y = prevX, prevY
+	}
+	for ; x > 0; x-- {
+		steps = append(steps, step{'=', ""})
+	}
+
+	for i := len(steps) - 1; i >= 0; i-- {
+		switch steps[i].op {
+		case '=':
+			p.keep(1)
+		case '-':
+			p.delete(1)
+		case '+':
+			p.insert(steps[i].line)
+		}
+	}
+	return true
+}
@@ -0,0 +1,106 @@
+package diff
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLines(t *testing.T) {
+	tests := []struct {
+		name     string
+		old, new string
+		want     []Edit
+	}{
+		{"identical", "a\nb\n", "a\nb\n", []Edit{{Keep: 2}}},
+		{"both empty", "", "", nil},
+		{"from empty", "", "a\nb\n", []Edit{{Insert: []string{"a\n", "b\n"}}}},
+		{"to empty", "a\nb\n", "", []Edit{{Delete: 2}}},
+		{"one row changed", "a\nb\nc\n", "a\nB\nc\n", []Edit{{Keep: 1}, {Delete: 1}, {Insert: []string{"B\n"}}, {Keep: 1}}},
+		{"row appended", "a\nb\n", "a\nb\nc\n", []Edit{{Keep: 2}, {Insert: []string{"c\n"}}}},
+		{"row removed", "a\nb\nc\n", "a\nc\n", []Edit{{Keep: 1}, {Delete: 1}, {Keep: 1}}},
+		{"no final newline", "a\nb", "a\nc", []Edit{{Keep: 1}, {Delete: 1}, {Insert: []string{"c"}}}},
+		{"interleaved", "a\nx\nb\ny\nc\n", "a\nb\nc\n", []Edit{{Keep: 1}, {Delete: 1}, {Keep: 1}, {Delete: 1}, {Keep: 1}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := Lines(tt.old, tt.new, 100)
+			if !ok {
+				t.Fatal("gave up")
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Lines = %+v, want %+v", got, tt.want)
+			}
+			if applied, err := Apply(tt.old, got); err != nil || applied != tt.new {
+				t.Errorf("Apply = %q, %v; want %q", applied, err, tt.new)
+			}
+		})
+	}
+}
+
+func TestLinesMaxEdits(t *testing.T) {
+	old := "a\nb\nc\nd\n"
+	if _, ok := Lines(old, "a\nB\nC\nd\n", 4); !ok {
+		t.Error("4 edits refused with maxEdits 4")
+	}
+	if _, ok := Lines(old, "a\nB\nC\nd\n", 3); ok {
+		t.Error("4 edits accepted with maxEdits 3")
+	}
+}
+
+// revise changes roughly fraction of lines at random: replacing,
+// deleting, or inserting next to them.
+func revise(rng *rand.Rand, lines []string, fraction float64) []string {
+	var out []string
+	for i, line := range lines {
+		if rng.Float64() >= fraction {
+			out = append(out, line)
+			continue
+		}
+		switch rng.Intn(3) {
+		case 0:
+			out = append(out, fmt.Sprintf("<tr><td>changed %d</td></tr>\n", i))
+		case 1:
+			// deleted
+		case 2:
+			out = append(out, line, fmt.Sprintf("<tr><td>inserted %d</td></tr>\n", i))
+		}
+	}
+	return out
+}
+
+func TestLinesRoundTrip(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	var base []string
+	for i := 0; i < 300; i++ {
+		base = append(base, fmt.Sprintf("<tr><td>row %d</td><td>%d</td></tr>\n", i, rng.Intn(1000)))
+	}
+	old := strings.Join(base, "")
+
+	for _, fraction := range []float64{0, 0.01, 0.1, 0.5, 1} {
+		for trial := 0; trial < 5; trial++ {
+			new := strings.Join(revise(rng, base, fraction), "")
+			patch, ok := Lines(old, new, len(base)*2)
+			if !ok {
+				t.Fatalf("fraction %v: gave up", fraction)
+			}
+			if got, err := Apply(old, patch); err != nil || got != new {
+				t.Fatalf("fraction %v: round trip failed: %v", fraction, err)
+			}
+		}
+	}
+}
+
+func TestApplyMismatch(t *testing.T) {
+	for _, patch := range [][]Edit{
+		{{Keep: 3}},
+		{{Delete: 3}},
+		{{Keep: 1}},
+	} {
+		if _, err := Apply("a\nb\n", patch); err != ErrMismatch {
+			t.Errorf("Apply(%+v) = %v, want ErrMismatch", patch, err)
+		}
+	}
+}
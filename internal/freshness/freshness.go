@@ -0,0 +1,95 @@
+// Package freshness lets a widget record what its content was built from,
+// so the server can later tell whether the widget has gone stale.
+//
+// A widget embeds a marker naming a hook and the hook's parameters. The
+// server looks the hook up in its widget handler registry and asks it
+// whether the parameters still describe the world; the "fs" hook compares
+// a directory's current fingerprint with the one the widget was built at.
+package freshness
+
+import (
+	"fmt"
+	"hash/fnv"
+	"html"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// MarkerName is the name of the <meta> element carrying the marker.
+const MarkerName = "goshell-freshness"
+
+// Marker renders a freshness marker for hook with attrs, which become
+// data-* attributes. Attribute names must be lowercase letters and dashes.
+func Marker(hook string, attrs map[string]string) string {
+	names := make([]string, 0, len(attrs))
+	for name := range attrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `<meta name="%s" content="%s"`, MarkerName, html.EscapeString(hook))
+	for _, name := range names {
+		fmt.Fprintf(&b, ` data-%s="%s"`, name, html.EscapeString(attrs[name]))
+	}
+	b.WriteString(">")
+	return b.String()
+}
+
+var (
+	markerRE = regexp.MustCompile(`<meta name="` + MarkerName + `" content="([^"]*)"([^>]*)>`)
+	attrRE   = regexp.MustCompile(` data-([a-z-]+)="([^"]*)"`)
+)
+
+// Find returns the hook and attributes of the first marker in content.
+func Find(content string) (hook string, attrs map[string]string, ok bool) {
+	m := markerRE.FindStringSubmatch(content)
+	if m == nil {
+		return "", nil, false
+	}
+	attrs = make(map[string]string)
+	for _, a := range attrRE.FindAllStringSubmatch(m[2], -1) {
+		attrs[a[1]] = html.UnescapeString(a[2])
+	}
+	return html.UnescapeString(m[1]), attrs, true
+}
+
+// DirFingerprint summarises a directory's listing: its modification time,
+// which moves whenever an entry is added, removed or renamed, plus a hash
+// of the entry count and names to catch changes that keep the mtime.
+func DirFingerprint(dir string) (string, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return "", err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", err
+	}
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%d", len(entries))
+	for _, e := range entries {
+		fmt.Fprintf(h, "\x00%s", e.Name())
+	}
+	return fmt.Sprintf("%x-%d-%x", info.ModTime().UnixNano(), len(entries), h.Sum64()), nil
+}
+
+// DirMarker renders an "fs" marker for dir at its current fingerprint.
+// refresh is the shell command that re-renders the widget in place.
+func DirMarker(dir, refresh string) (string, error) {
+	fp, err := DirFingerprint(dir)
+	if err != nil {
+		return "", err
+	}
+	return Marker("fs", map[string]string{"dir": dir, "fingerprint": fp, "refresh": refresh}), nil
+}
+
+// DirFresh is the "fs" hook: it reports whether the directory in attrs
+// still has the fingerprint recorded there. A directory that can no longer
+// be read is stale.
+func DirFresh(attrs map[string]string) bool {
+	fp, err := DirFingerprint(attrs["dir"])
+	return err == nil && fp == attrs["fingerprint"]
+}
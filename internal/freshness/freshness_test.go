@@ -0,0 +1,64 @@
+package freshness
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestMarkerRoundTrip(t *testing.T) {
+	attrs := map[string]string{"dir": `/tmp/a "quoted" <dir>`, "refresh": `'/bin/lsh' -key 'k' '/tmp/it'"'"'s'`}
+	content := "<style>x</style>\n" + Marker("fs", attrs) + "\n<div>body</div>"
+
+	hook, got, ok := Find(content)
+	if !ok || hook != "fs" || !reflect.DeepEqual(got, attrs) {
+		t.Errorf("Find = %q, %v, %v; want fs, %v", hook, got, ok, attrs)
+	}
+	if _, _, ok := Find("<div>no marker</div>"); ok {
+		t.Error("Find reported a marker in content without one")
+	}
+}
+
+func TestDirFresh(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "a"), []byte("a"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	steps := []struct {
+		name   string
+		mutate func() error
+		fresh  bool
+	}{
+		{"unchanged", func() error { return nil }, true},
+		{"file contents changed", func() error {
+			return os.WriteFile(filepath.Join(dir, "a"), []byte("longer"), 0o644)
+		}, true},
+		{"entry added", func() error {
+			return os.WriteFile(filepath.Join(dir, "b"), nil, 0o644)
+		}, false},
+		{"entry renamed", func() error {
+			return os.Rename(filepath.Join(dir, "a"), filepath.Join(dir, "c"))
+		}, false},
+		{"entry removed", func() error {
+			return os.Remove(filepath.Join(dir, "b"))
+		}, false},
+		{"directory removed", func() error {
+			return os.RemoveAll(dir)
+		}, false},
+	}
+	for _, step := range steps {
+		marker, err := DirMarker(dir, "lsh")
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, attrs, _ := Find(marker)
+		if err := step.mutate(); err != nil {
+			t.Fatal(err)
+		}
+		if got := DirFresh(attrs); got != step.fresh {
+			t.Errorf("%s: fresh = %v, want %v", step.name, got, step.fresh)
+		}
+	}
+}
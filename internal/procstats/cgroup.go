@@ -0,0 +1,180 @@
+package procstats
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Limits are the resources a process may use, which its CPU and memory
+// percentages are measured against.
+type Limits struct {
+	CPUs   float64 // cores: the cgroup CPU quota, or the host's CPU count
+	Memory int64   // bytes: the cgroup memory limit, or the host's total
+	Cgroup int     // cgroup version whose limits apply (1 or 2), 0 if none do
+}
+
+// MemoryPercent is p's memory use as a percentage of the memory limit.
+func (l Limits) MemoryPercent(p *Process) float64 {
+	if l.Memory <= 0 {
+		return 0
+	}
+	return float64(p.Memory()) / float64(l.Memory) * 100
+}
+
+// CPUPercent is the CPU a process used between two samples taken elapsed
+// apart, as a percentage of the CPU limit: 100 means it used all the CPU
+// it is allowed, across every core.
+func (l Limits) CPUPercent(before, after *Process, elapsed time.Duration) float64 {
+	if l.CPUs <= 0 || elapsed <= 0 || after.CPU < before.CPU {
+		return 0
+	}
+	used := float64(after.CPU-before.CPU) / ClockTicks
+	return used / elapsed.Seconds() / l.CPUs * 100
+}
+
+// Limits returns the limits that apply to pid: the tightest of its cgroup
+// and every ancestor cgroup, capped at what the host has.
+func (fs FS) Limits(pid int) (Limits, error) {
+	l, err := fs.hostLimits()
+	if err != nil {
+		return Limits{}, err
+	}
+	data, err := os.ReadFile(fs.path("proc", strconv.Itoa(pid), "cgroup"))
+	if err != nil {
+		// no cgroup support; the host is all there is
+		return l, nil
+	}
+
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		// hierarchy-ID:controller-list:path
+		parts := strings.SplitN(sc.Text(), ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		if parts[0] == "0" && parts[1] == "" {
+			fs.applyV2(&l, parts[2])
+			continue
+		}
+		for _, controller := range strings.Split(parts[1], ",") {
+			switch controller {
+			case "memory":
+				fs.applyV1Memory(&l, parts[2])
+			case "cpu":
+				fs.applyV1CPU(&l, parts[1], parts[2])
+			}
+		}
+	}
+	return l, nil
+}
+
+// hostLimits reads the host's CPU count and total memory.
+func (fs FS) hostLimits() (Limits, error) {
+	meminfo, err := os.ReadFile(fs.path("proc", "meminfo"))
+	if err != nil {
+		return Limits{}, err
+	}
+	stat, err := os.ReadFile(fs.path("proc", "stat"))
+	if err != nil {
+		return Limits{}, err
+	}
+	var cpus int
+	sc := bufio.NewScanner(bytes.NewReader(stat))
+	for sc.Scan() {
+		line := sc.Text()
+		if strings.HasPrefix(line, "cpu") && len(line) > 3 && line[3] >= '0' && line[3] <= '9' {
+			cpus++
+		}
+	}
+	return Limits{CPUs: float64(cpus), Memory: kbField(meminfo, "MemTotal:")}, nil
+}
+
+// ancestors returns the directories of cgroup and each of its parents,
+// up to the root of the hierarchy mounted at mount.
+func (fs FS) ancestors(mount, cgroup string) []string {
+	var dirs []string
+	for p := path.Clean("/" + cgroup); ; p = path.Dir(p) {
+		dirs = append(dirs, fs.path(mount, p))
+		if p == "/" {
+			return dirs
+		}
+	}
+}
+
+// readControl returns a cgroup control file's trimmed contents.
+func readControl(dir, file string) (string, bool) {
+	data, err := os.ReadFile(dir + "/" + file)
+	if err != nil {
+		return "", false
+	}
+	return strings.TrimSpace(string(data)), true
+}
+
+func (l *Limits) capMemory(limit int64, version int) {
+	if limit > 0 && limit < l.Memory {
+		l.Memory = limit
+		l.Cgroup = version
+	}
+}
+
+func (l *Limits) capCPU(quota, period float64, version int) {
+	if quota > 0 && period > 0 && quota/period < l.CPUs {
+		l.CPUs = quota / period
+		l.Cgroup = version
+	}
+}
+
+// applyV2 applies the memory.max and cpu.max limits of a cgroup v2
+// hierarchy, where "max" means unlimited.
+func (fs FS) applyV2(l *Limits, cgroup string) {
+	for _, dir := range fs.ancestors("sys/fs/cgroup", cgroup) {
+		if s, ok := readControl(dir, "memory.max"); ok && s != "max" {
+			n, _ := strconv.ParseInt(s, 10, 64)
+			l.capMemory(n, 2)
+		}
+		if s, ok := readControl(dir, "cpu.max"); ok {
+			f := strings.Fields(s)
+			if len(f) == 2 && f[0] != "max" {
+				quota, _ := strconv.ParseFloat(f[0], 64)
+				period, _ := strconv.ParseFloat(f[1], 64)
+				l.capCPU(quota, period, 2)
+			}
+		}
+	}
+}
+
+// applyV1Memory applies memory.limit_in_bytes from the v1 memory
+// hierarchy. An unlimited cgroup reports a huge number, which the host
+// cap discards.
+func (fs FS) applyV1Memory(l *Limits, cgroup string) {
+	for _, dir := range fs.ancestors("sys/fs/cgroup/memory", cgroup) {
+		if s, ok := readControl(dir, "memory.limit_in_bytes"); ok {
+			n, _ := strconv.ParseInt(s, 10, 64)
+			l.capMemory(n, 1)
+		}
+	}
+}
+
+// applyV1CPU applies the CFS quota from the v1 cpu hierarchy, which is
+// mounted under its full controller list (often "cpu,cpuacct") or, with a
+// symlink, under "cpu". A quota of -1 means unlimited.
+func (fs FS) applyV1CPU(l *Limits, controllers, cgroup string) {
+	mount := "sys/fs/cgroup/" + controllers
+	if _, err := os.Stat(fs.path(mount)); err != nil {
+		mount = "sys/fs/cgroup/cpu"
+	}
+	for _, dir := range fs.ancestors(mount, cgroup) {
+		q, okQ := readControl(dir, "cpu.cfs_quota_us")
+		p, okP := readControl(dir, "cpu.cfs_period_us")
+		if okQ && okP {
+			quota, _ := strconv.ParseFloat(q, 64)
+			period, _ := strconv.ParseFloat(p, 64)
+			l.capCPU(quota, period, 1)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package procstats
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ErrNoCgroupV2 is returned by NewGroup when the caller isn't in a
+// cgroup v2 hierarchy mounted at /sys/fs/cgroup.
+var ErrNoCgroupV2 = errors.New("procstats: no cgroup v2 hierarchy")
+
+// groupControllers are the controllers a Group enables, where its parent
+// offers them.
+var groupControllers = []string{"cpu", "memory"}
+
+// Group is a cgroup v2 group created to hold a set of processes, whose
+// CPU and memory use it then reports and may limit.
+type Group struct {
+	Dir string // the group's directory in cgroupfs
+}
+
+// Usage is what a group of processes has used.
+type Usage struct {
+	CPU    time.Duration // user + system time, cumulative
+	Memory int64         // bytes currently charged
+}
+
+// NewGroup creates the group name below the calling process's cgroup, or
+// returns it if it already exists, with the cpu and memory controllers
+// enabled where the parent offers them.
+//
+// Cgroup v2 only delegates controllers from groups without processes of
+// their own, so if the caller's cgroup can't enable them the caller moves
+// itself into a sibling leaf, leaf, and tries again. NewGroup fails if the
+// hierarchy isn't v2 or isn't delegated to the caller.
+func (fs FS) NewGroup(name, leaf string) (*Group, error) {
+	cgroup, err := fs.ownCgroup()
+	if err != nil {
+		return nil, err
+	}
+	parent := fs.path("sys/fs/cgroup", cgroup)
+	offered, ok := readControl(parent, "cgroup.controllers")
+	if !ok {
+		return nil, ErrNoCgroupV2
+	}
+
+	var enable []string
+	for _, c := range groupControllers {
+		for _, o := range strings.Fields(offered) {
+			if c == o {
+				enable = append(enable, "+"+c)
+			}
+		}
+	}
+	if len(enable) > 0 {
+		control := strings.Join(enable, " ")
+		err := writeControl(parent, "cgroup.subtree_control", control)
+		if errors.Is(err, syscall.EBUSY) {
+			if err := fs.moveSelf(filepath.Join(parent, leaf)); err != nil {
+				return nil, err
+			}
+			err = writeControl(parent, "cgroup.subtree_control", control)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("procstats: enable controllers in %s: %w", parent, err)
+		}
+	}
+
+	g := &Group{Dir: filepath.Join(parent, name)}
+	if err := os.Mkdir(g.Dir, 0o755); err != nil && !errors.Is(err, os.ErrExist) {
+		return nil, fmt.Errorf("procstats: %w", err)
+	}
+	return g, nil
+}
+
+// ownCgroup returns the calling process's cgroup v2 path.
+func (fs FS) ownCgroup() (string, error) {
+	data, err := os.ReadFile(fs.path("proc/self/cgroup"))
+	if err != nil {
+		return "", ErrNoCgroupV2
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if cgroup, ok := strings.CutPrefix(line, "0::"); ok {
+			return cgroup, nil
+		}
+	}
+	return "", ErrNoCgroupV2
+}
+
+// moveSelf moves the calling process into the group at dir, creating it.
+func (fs FS) moveSelf(dir string) error {
+	if err := os.Mkdir(dir, 0o755); err != nil && !errors.Is(err, os.ErrExist) {
+		return fmt.Errorf("procstats: %w", err)
+	}
+	if err := writeControl(dir, "cgroup.procs", strconv.Itoa(os.Getpid())); err != nil {
+		return fmt.Errorf("procstats: move to %s: %w", dir, err)
+	}
+	return nil
+}
+
+// writeControl writes a cgroup control file.
+func writeControl(dir, file, value string) error {
+	return os.WriteFile(filepath.Join(dir, file), []byte(value), 0o644)
+}
+
+// Add moves the process pid into g.
+func (g *Group) Add(pid int) error {
+	return writeControl(g.Dir, "cgroup.procs", strconv.Itoa(pid))
+}
+
+// SetLimits writes g's memory limit, in bytes, and CPU limit, in cores.
+// Zero removes a limit.
+func (g *Group) SetLimits(memory int64, cpus float64) error {
+	memMax := "max"
+	if memory > 0 {
+		memMax = strconv.FormatInt(memory, 10)
+	}
+	if err := writeControl(g.Dir, "memory.max", memMax); err != nil {
+		return err
+	}
+	const period = 100000 // µs, the kernel default
+	cpuMax := fmt.Sprintf("max %d", period)
+	if cpus > 0 {
+		cpuMax = fmt.Sprintf("%d %d", int64(cpus*period), period)
+	}
+	return writeControl(g.Dir, "cpu.max", cpuMax)
+}
+
+// Usage reads the CPU time g's processes have used, including processes
+// that have since exited, and the memory charged to it now. Memory is 0
+// when the memory controller isn't enabled.
+func (g *Group) Usage() (Usage, error) {
+	stat, err := os.ReadFile(filepath.Join(g.Dir, "cpu.stat"))
+	if err != nil {
+		return Usage{}, err
+	}
+	var u Usage
+	for _, line := range strings.Split(string(stat), "\n") {
+		if v, ok := strings.CutPrefix(line, "usage_usec "); ok {
+			usec, _ := strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+			u.CPU = time.Duration(usec) * time.Microsecond
+		}
+	}
+	if s, ok := readControl(g.Dir, "memory.current"); ok {
+		u.Memory, _ = strconv.ParseInt(s, 10, 64)
+	}
+	return u, nil
+}
+
+// Remove deletes g, which fails while processes remain in it.
+func (g *Group) Remove() error {
+	return os.Remove(g.Dir)
+}
+
+// TreeUsage adds up the use of pid and its descendants: the memory of
+// each, and the CPU time of each and of the children each has waited
+// for. It is the fallback for processes outside a Group of their own;
+// the CPU of orphans that exit after being reparented is lost.
+func (fs FS) TreeUsage(pid int) (Usage, error) {
+	procs, err := fs.Processes()
+	if err != nil {
+		return Usage{}, err
+	}
+	var u Usage
+	var ticks uint64
+	for _, root := range Tree(procs) {
+		root.Walk(func(n *Node, _ int) {
+			if n.PID != pid {
+				return
+			}
+			n.Walk(func(n *Node, _ int) {
+				ticks += n.CPU + n.ChildCPU
+				u.Memory += n.Memory()
+			})
+		})
+	}
+	u.CPU = time.Duration(ticks) * time.Second / ClockTicks
+	return u, nil
+}
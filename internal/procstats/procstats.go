@@ -0,0 +1,181 @@
+// Package procstats reads process statistics from procfs and reports CPU
+// and memory use relative to the limits that actually apply: the
+// process's cgroup (v1 or v2) when it has one, the host otherwise.
+//
+// All paths are resolved under FS.Root, so tests can point a reader at a
+// fixture tree instead of the live system.
+package procstats
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ClockTicks is USER_HZ, the unit of the CPU times in /proc/<pid>/stat.
+// It is 100 on every Linux architecture goshell runs on.
+const ClockTicks = 100
+
+// FS reads procfs and cgroupfs below Root.
+type FS struct {
+	Root string // "/" for the live system
+}
+
+// Host is the live system.
+var Host = FS{Root: "/"}
+
+func (fs FS) path(elem ...string) string {
+	return filepath.Join(append([]string{fs.Root}, elem...)...)
+}
+
+// Process is a snapshot of one process.
+type Process struct {
+	PID      int
+	PPID     int
+	PGID     int
+	Session  int    // session ID: the PID of the session leader
+	Comm     string // executable name, as in stat
+	Cmdline  []string
+	State    byte   // R, S, D, Z, T, ...
+	CPU      uint64 // user + system time, in ClockTicks
+	ChildCPU uint64 // CPU time of children it has waited for, in ClockTicks
+	RSS      int64  // resident set size, bytes
+	PSS      int64  // proportional set size, bytes; 0 if smaps_rollup is unreadable
+	Threads  int
+	UID      int
+}
+
+// Memory is the best available measure of a process's memory use: PSS,
+// which splits shared pages among their users, or RSS without it.
+func (p *Process) Memory() int64 {
+	if p.PSS > 0 {
+		return p.PSS
+	}
+	return p.RSS
+}
+
+// Process reads the process with the given PID.
+func (fs FS) Process(pid int) (*Process, error) {
+	dir := fs.path("proc", strconv.Itoa(pid))
+	stat, err := os.ReadFile(filepath.Join(dir, "stat"))
+	if err != nil {
+		return nil, err
+	}
+	p, err := parseStat(stat)
+	if err != nil {
+		return nil, fmt.Errorf("procstats: %s/stat: %w", dir, err)
+	}
+
+	if status, err := os.ReadFile(filepath.Join(dir, "status")); err == nil {
+		parseStatus(p, status)
+	}
+	if rollup, err := os.ReadFile(filepath.Join(dir, "smaps_rollup")); err == nil {
+		p.PSS = kbField(rollup, "Pss:")
+	}
+	if cmdline, err := os.ReadFile(filepath.Join(dir, "cmdline")); err == nil {
+		cmdline = bytes.TrimRight(cmdline, "\x00")
+		if len(cmdline) > 0 {
+			p.Cmdline = strings.Split(string(cmdline), "\x00")
+		}
+	}
+	return p, nil
+}
+
+// Processes reads every process, ordered by PID. Processes that exit
+// while being read are skipped.
+func (fs FS) Processes() ([]*Process, error) {
+	entries, err := os.ReadDir(fs.path("proc"))
+	if err != nil {
+		return nil, err
+	}
+	var procs []*Process
+	for _, e := range entries {
+		pid, err := strconv.Atoi(e.Name())
+		if err != nil {
+			continue
+		}
+		p, err := fs.Process(pid)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		procs = append(procs, p)
+	}
+	sort.Slice(procs, func(i, j int) bool { return procs[i].PID < procs[j].PID })
+	return procs, nil
+}
+
+// parseStat parses /proc/<pid>/stat. The command name is parenthesised
+// and may itself contain spaces and parentheses, so fields are counted
+// from the last ')'.
+func parseStat(stat []byte) (*Process, error) {
+	lparen := bytes.IndexByte(stat, '(')
+	rparen := bytes.LastIndexByte(stat, ')')
+	if lparen < 0 || rparen < lparen {
+		return nil, errors.New("malformed stat")
+	}
+	pid, err := strconv.Atoi(string(bytes.TrimSpace(stat[:lparen])))
+	if err != nil {
+		return nil, err
+	}
+	// fields[0] is field 3 (state) in proc(5) numbering
+	fields := strings.Fields(string(stat[rparen+1:]))
+	if len(fields) < 22 {
+		return nil, errors.New("short stat")
+	}
+	num := func(field int) uint64 {
+		n, _ := strconv.ParseUint(fields[field-3], 10, 64)
+		return n
+	}
+	return &Process{
+		PID:      pid,
+		Comm:     string(stat[lparen+1 : rparen]),
+		State:    fields[0][0],
+		PPID:     int(num(4)),
+		PGID:     int(num(5)),
+		Session:  int(num(6)),
+		CPU:      num(14) + num(15),
+		ChildCPU: num(16) + num(17),
+		Threads:  int(num(20)),
+		RSS:      int64(num(24)) * int64(os.Getpagesize()),
+	}, nil
+}
+
+// parseStatus fills in what status reports more directly than stat.
+func parseStatus(p *Process, status []byte) {
+	if rss := kbField(status, "VmRSS:"); rss > 0 {
+		p.RSS = rss
+	}
+	sc := bufio.NewScanner(bytes.NewReader(status))
+	for sc.Scan() {
+		if rest, ok := strings.CutPrefix(sc.Text(), "Uid:"); ok {
+			if f := strings.Fields(rest); len(f) > 0 {
+				p.UID, _ = strconv.Atoi(f[0])
+			}
+		}
+	}
+}
+
+// kbField returns the "<key> <n> kB" value from a procfs file, in bytes.
+func kbField(data []byte, key string) int64 {
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		if rest, ok := strings.CutPrefix(sc.Text(), key); ok {
+			f := strings.Fields(rest)
+			if len(f) == 0 {
+				return 0
+			}
+			n, _ := strconv.ParseInt(f[0], 10, 64)
+			return n * 1024
+		}
+	}
+	return 0
+}
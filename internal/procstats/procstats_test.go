@@ -0,0 +1,292 @@
+package procstats
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// writeFixture creates files (path -> content) under a new root.
+func writeFixture(t *testing.T, files map[string]string) FS {
+	t.Helper()
+	root := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return FS{Root: root}
+}
+
+// hostFiles describe a 4-CPU host with 8 GiB of memory and two processes:
+// a shell (PID 10) and a child with an awkward name (PID 20).
+func hostFiles() map[string]string {
+	return map[string]string{
+		"proc/meminfo": "MemTotal:        8388608 kB\nMemFree:         1000000 kB\n",
+		"proc/stat":    "cpu  1 2 3 4\ncpu0 1 2 3 4\ncpu1 1 2 3 4\ncpu2 1 2 3 4\ncpu3 1 2 3 4\nintr 0\n",
+
+		"proc/10/stat":    "10 (zsh) S 1 10 10 34816 20 4194304 0 0 0 0 150 50 0 0 20 0 1 0 100 1000 10 0\n",
+		"proc/10/status":  "Name:\tzsh\nUid:\t1000\t1000\t1000\t1000\nVmRSS:\t    4096 kB\n",
+		"proc/10/cmdline": "-zsh\x00",
+
+		"proc/20/stat":         "20 (my (odd) prog) R 10 20 10 34816 20 4194304 0 0 0 0 400 100 0 0 20 0 4 0 200 1000 10 0\n",
+		"proc/20/status":       "Name:\tmy (odd) prog\nUid:\t1000\t1000\t1000\t1000\nVmRSS:\t  524288 kB\n",
+		"proc/20/smaps_rollup": "Rss:              524288 kB\nPss:              262144 kB\n",
+		"proc/20/cmdline":      "prog\x00--flag\x00value\x00",
+	}
+}
+
+func TestProcess(t *testing.T) {
+	fs := writeFixture(t, hostFiles())
+
+	p, err := fs.Process(20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Process{
+		PID:     20,
+		PPID:    10,
+		PGID:    20,
+		Session: 10,
+		Comm:    "my (odd) prog",
+		Cmdline: []string{"prog", "--flag", "value"},
+		State:   'R',
+		CPU:     500,
+		RSS:     512 << 20,
+		PSS:     256 << 20,
+		Threads: 4,
+		UID:     1000,
+	}
+	if !reflect.DeepEqual(p, want) {
+		t.Errorf("Process(20) = %+v, want %+v", p, want)
+	}
+	if p.Memory() != 256<<20 {
+		t.Errorf("Memory = %d, want PSS", p.Memory())
+	}
+
+	shell, _ := fs.Process(10)
+	if shell.PSS != 0 || shell.Memory() != 4<<20 {
+		t.Errorf("without smaps_rollup Memory = %d, want RSS", shell.Memory())
+	}
+
+	if _, err := fs.Process(99); !os.IsNotExist(err) {
+		t.Errorf("missing process: %v, want not-exist", err)
+	}
+}
+
+func TestLimits(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		want  Limits
+	}{
+		{
+			name:  "no cgroup",
+			files: map[string]string{},
+			want:  Limits{CPUs: 4, Memory: 8 << 30},
+		},
+		{
+			name: "v2 with limits on an ancestor",
+			files: map[string]string{
+				"proc/20/cgroup":                                    "0::/user.slice/session.scope\n",
+				"sys/fs/cgroup/user.slice/memory.max":               "2147483648\n",
+				"sys/fs/cgroup/user.slice/cpu.max":                  "150000 100000\n",
+				"sys/fs/cgroup/user.slice/session.scope/memory.max": "max\n",
+				"sys/fs/cgroup/user.slice/session.scope/cpu.max":    "max 100000\n",
+			},
+			want: Limits{CPUs: 1.5, Memory: 2 << 30, Cgroup: 2},
+		},
+		{
+			name: "v2 unlimited",
+			files: map[string]string{
+				"proc/20/cgroup":           "0::/\n",
+				"sys/fs/cgroup/memory.max": "max\n",
+				"sys/fs/cgroup/cpu.max":    "max 100000\n",
+			},
+			want: Limits{CPUs: 4, Memory: 8 << 30},
+		},
+		{
+			name: "v1",
+			files: map[string]string{
+				"proc/20/cgroup": "12:memory:/docker/abc\n4:cpu,cpuacct:/docker/abc\n1:name=systemd:/docker/abc\n",
+				"sys/fs/cgroup/memory/docker/abc/memory.limit_in_bytes":  "1073741824\n",
+				"sys/fs/cgroup/memory/memory.limit_in_bytes":             "9223372036854771712\n",
+				"sys/fs/cgroup/cpu,cpuacct/docker/abc/cpu.cfs_quota_us":  "50000\n",
+				"sys/fs/cgroup/cpu,cpuacct/docker/abc/cpu.cfs_period_us": "100000\n",
+				"sys/fs/cgroup/cpu,cpuacct/cpu.cfs_quota_us":             "-1\n",
+				"sys/fs/cgroup/cpu,cpuacct/cpu.cfs_period_us":            "100000\n",
+			},
+			want: Limits{CPUs: 0.5, Memory: 1 << 30, Cgroup: 1},
+		},
+		{
+			name: "v1 limit above the host",
+			files: map[string]string{
+				"proc/20/cgroup": "12:memory:/\n",
+				"sys/fs/cgroup/memory/memory.limit_in_bytes": "17179869184\n",
+			},
+			want: Limits{CPUs: 4, Memory: 8 << 30},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files := hostFiles()
+			for name, content := range tt.files {
+				files[name] = content
+			}
+			got, err := writeFixture(t, files).Limits(20)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("Limits = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPercentages(t *testing.T) {
+	limited := Limits{CPUs: 0.5, Memory: 1 << 30}
+	host := Limits{CPUs: 4, Memory: 8 << 30}
+	p := &Process{RSS: 512 << 20}
+	before, after := &Process{CPU: 100}, &Process{CPU: 150} // 0.5s of CPU
+
+	if got := limited.MemoryPercent(p); got != 50 {
+		t.Errorf("limited memory = %v%%, want 50", got)
+	}
+	if got := host.MemoryPercent(p); got != 6.25 {
+		t.Errorf("host memory = %v%%, want 6.25", got)
+	}
+	if got := limited.CPUPercent(before, after, time.Second); got != 100 {
+		t.Errorf("limited CPU = %v%%, want 100", got)
+	}
+	if got := host.CPUPercent(before, after, time.Second); got != 12.5 {
+		t.Errorf("host CPU = %v%%, want 12.5", got)
+	}
+	if got := host.CPUPercent(after, before, time.Second); got != 0 {
+		t.Errorf("CPU with a reused PID = %v%%, want 0", got)
+	}
+}
+
+func TestTree(t *testing.T) {
+	procs := []*Process{
+		{PID: 1, PPID: 0},
+		{PID: 2, PPID: 0},
+		{PID: 30, PPID: 10},
+		{PID: 10, PPID: 1},
+		{PID: 20, PPID: 10},
+		{PID: 40, PPID: 99}, // parent gone
+	}
+	var got []string
+	for _, root := range Tree(procs) {
+		root.Walk(func(n *Node, depth int) {
+			got = append(got, fmt.Sprintf("%d:%d", depth, n.PID))
+		})
+	}
+	want := []string{"0:1", "1:10", "2:20", "2:30", "0:2", "0:40"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("walk = %v, want %v", got, want)
+	}
+}
+
+func TestHostProcesses(t *testing.T) {
+	procs, err := Host.Processes()
+	if err != nil {
+		t.Skipf("no procfs: %v", err)
+	}
+	self := os.Getpid()
+	for _, p := range procs {
+		if p.PID == self {
+			if p.PPID != os.Getppid() || p.RSS == 0 {
+				t.Errorf("own process = %+v", p)
+			}
+			return
+		}
+	}
+	t.Errorf("own PID %d not listed", self)
+}
+
+func TestTreeUsage(t *testing.T) {
+	files := hostFiles()
+	// the shell has waited for 100 ticks of finished commands
+	files["proc/10/stat"] = "10 (zsh) S 1 10 10 34816 20 4194304 0 0 0 0 150 50 60 40 20 0 1 0 100 1000 10 0\n"
+	files["proc/30/stat"] = "30 (other) S 1 30 30 0 -1 4194304 0 0 0 0 900 0 0 0 20 0 1 0 100 1000 10 0\n"
+	fs := writeFixture(t, files)
+
+	u, err := fs.TreeUsage(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Usage{CPU: 8 * time.Second, Memory: 4<<20 + 256<<20}
+	if u != want {
+		t.Errorf("TreeUsage(10) = %+v, want %+v", u, want)
+	}
+	if u, _ := fs.TreeUsage(99); u != (Usage{}) {
+		t.Errorf("TreeUsage of a missing process = %+v", u)
+	}
+}
+
+func TestNewGroup(t *testing.T) {
+	fs := writeFixture(t, map[string]string{
+		"proc/self/cgroup": "0::/user.slice/term.scope\n",
+		"sys/fs/cgroup/user.slice/term.scope/cgroup.controllers":     "cpuset cpu io memory pids\n",
+		"sys/fs/cgroup/user.slice/term.scope/cgroup.subtree_control": "",
+	})
+	g, err := fs.NewGroup("session", "server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	parent := fs.path("sys/fs/cgroup/user.slice/term.scope")
+	if g.Dir != filepath.Join(parent, "session") {
+		t.Errorf("Dir = %s", g.Dir)
+	}
+	if s, _ := readControl(parent, "cgroup.subtree_control"); s != "+cpu +memory" {
+		t.Errorf("subtree_control = %q", s)
+	}
+	if _, err := fs.NewGroup("session", "server"); err != nil {
+		t.Errorf("existing group: %v", err)
+	}
+
+	if err := g.SetLimits(512<<20, 1.5); err != nil {
+		t.Fatal(err)
+	}
+	mem, _ := readControl(g.Dir, "memory.max")
+	cpu, _ := readControl(g.Dir, "cpu.max")
+	if mem != "536870912" || cpu != "150000 100000" {
+		t.Errorf("limits: memory.max %q, cpu.max %q", mem, cpu)
+	}
+	g.SetLimits(0, 0)
+	mem, _ = readControl(g.Dir, "memory.max")
+	cpu, _ = readControl(g.Dir, "cpu.max")
+	if mem != "max" || cpu != "max 100000" {
+		t.Errorf("no limits: memory.max %q, cpu.max %q", mem, cpu)
+	}
+
+	os.WriteFile(filepath.Join(g.Dir, "cpu.stat"), []byte("usage_usec 2500000\nuser_usec 2000000\nsystem_usec 500000\n"), 0o644)
+	os.WriteFile(filepath.Join(g.Dir, "memory.current"), []byte("1048576\n"), 0o644)
+	u, err := g.Usage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u != (Usage{CPU: 2500 * time.Millisecond, Memory: 1 << 20}) {
+		t.Errorf("Usage = %+v", u)
+	}
+}
+
+func TestNewGroupWithoutV2(t *testing.T) {
+	for name, files := range map[string]map[string]string{
+		"no cgroup file": {},
+		"v1 only":        {"proc/self/cgroup": "4:memory:/docker/abc\n"},
+		"hybrid":         {"proc/self/cgroup": "0::/\n", "sys/fs/cgroup/unified/cgroup.controllers": "cpu\n"},
+	} {
+		if _, err := writeFixture(t, files).NewGroup("session", "server"); err != ErrNoCgroupV2 {
+			t.Errorf("%s: err = %v, want ErrNoCgroupV2", name, err)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package procstats
+
+import "sort"
+
+// Node is a process in a process tree.
+type Node struct {
+	*Process
+	Children []*Node // ordered by PID
+}
+
+// Tree arranges procs by parent. Processes whose parent isn't among procs
+// (PID 1, kernel threads, or a parent that exited) are roots. Roots and
+// children are ordered by PID.
+func Tree(procs []*Process) []*Node {
+	nodes := make(map[int]*Node, len(procs))
+	for _, p := range procs {
+		nodes[p.PID] = &Node{Process: p}
+	}
+
+	var roots []*Node
+	for _, p := range procs {
+		n := nodes[p.PID]
+		if parent, ok := nodes[p.PPID]; ok && p.PPID != p.PID {
+			parent.Children = append(parent.Children, n)
+		} else {
+			roots = append(roots, n)
+		}
+	}
+
+	byPID := func(ns []*Node) {
+		sort.Slice(ns, func(i, j int) bool { return ns[i].PID < ns[j].PID })
+	}
+	byPID(roots)
+	for _, n := range nodes {
+		byPID(n.Children)
+	}
+	return roots
+}
+
+// Walk calls fn for n and each of its descendants, depth first, with the
+// depth below n.
+func (n *Node) Walk(fn func(n *Node, depth int)) {
+	n.walk(fn, 0)
+}
+
+func (n *Node) walk(fn func(*Node, int), depth int) {
+	fn(n, depth)
+	for _, c := range n.Children {
+		c.walk(fn, depth+1)
+	}
+}
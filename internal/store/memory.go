@@ -0,0 +1,61 @@
+package store
+
+import (
+	"sort"
+	"sync"
+)
+
+// Memory is the default Store, keeping everything in RAM.
+type Memory struct {
+	mu   sync.RWMutex
+	data map[string]map[string][]byte // namespace -> key -> value
+}
+
+// NewMemory returns an empty in-memory store.
+func NewMemory() *Memory {
+	return &Memory{data: make(map[string]map[string][]byte)}
+}
+
+func (m *Memory) Put(ns, key string, value []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	bucket, ok := m.data[ns]
+	if !ok {
+		bucket = make(map[string][]byte)
+		m.data[ns] = bucket
+	}
+	bucket[key] = append([]byte(nil), value...)
+	return nil
+}
+
+func (m *Memory) Get(ns, key string) ([]byte, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	value, ok := m.data[ns][key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return append([]byte(nil), value...), nil
+}
+
+func (m *Memory) Delete(ns, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.data[ns], key)
+	return nil
+}
+
+func (m *Memory) List(ns string) ([]string, error) {
+	m.mu.RLock()
+	keys := make([]string, 0, len(m.data[ns]))
+	for key := range m.data[ns] {
+		keys = append(keys, key)
+	}
+	m.mu.RUnlock()
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (m *Memory) Close() error {
+	return nil
+}
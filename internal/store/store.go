@@ -0,0 +1,39 @@
+// Package store holds goshell's server-side state behind a small
+// key-value interface, so it can live in memory or spill to disk.
+package store
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrNotFound is returned by Get for a missing key.
+var ErrNotFound = errors.New("store: not found")
+
+// Store is a namespaced key-value store. Keys are listed in byte order,
+// so callers that need numeric order should zero-pad numeric keys.
+type Store interface {
+	Put(ns, key string, value []byte) error
+	Get(ns, key string) ([]byte, error)
+	Delete(ns, key string) error
+	List(ns string) ([]string, error)
+	Close() error
+}
+
+// Open returns the store described by spec: "memory" (or empty) for the
+// in-memory store, or "bolt:/path/to/file.db" for a bbolt database.
+func Open(spec string) (Store, error) {
+	kind, arg, _ := strings.Cut(spec, ":")
+	switch kind {
+	case "", "memory":
+		return NewMemory(), nil
+	case "bolt":
+		if arg == "" {
+			return nil, errors.New("store: bolt needs a path, as bolt:/path/to/file.db")
+		}
+		return OpenBolt(arg)
+	default:
+		return nil, fmt.Errorf("store: unknown backend %q", kind)
+	}
+}
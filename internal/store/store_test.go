@@ -0,0 +1,144 @@
+package store
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// testConformance runs the behavior every Store must share.
+func testConformance(t *testing.T, open func(t *testing.T) Store) {
+	t.Run("GetMissing", func(t *testing.T) {
+		s := open(t)
+		if _, err := s.Get("ns", "nope"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Get missing = %v, want ErrNotFound", err)
+		}
+	})
+
+	t.Run("PutGetOverwrite", func(t *testing.T) {
+		s := open(t)
+		if err := s.Put("ns", "k", []byte("v1")); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Put("ns", "k", []byte("v2")); err != nil {
+			t.Fatal(err)
+		}
+		got, err := s.Get("ns", "k")
+		if err != nil || string(got) != "v2" {
+			t.Errorf("Get = %q, %v; want v2", got, err)
+		}
+	})
+
+	t.Run("ValuesAreCopied", func(t *testing.T) {
+		s := open(t)
+		value := []byte("abc")
+		s.Put("ns", "k", value)
+		value[0] = 'X'
+		got, _ := s.Get("ns", "k")
+		got[1] = 'Y'
+		again, _ := s.Get("ns", "k")
+		if string(again) != "abc" {
+			t.Errorf("stored value = %q, want it unaffected by caller mutations", again)
+		}
+	})
+
+	t.Run("EmptyValue", func(t *testing.T) {
+		s := open(t)
+		s.Put("ns", "k", nil)
+		got, err := s.Get("ns", "k")
+		if err != nil || len(got) != 0 {
+			t.Errorf("Get empty = %q, %v; want empty value", got, err)
+		}
+	})
+
+	t.Run("NamespacesAreSeparate", func(t *testing.T) {
+		s := open(t)
+		s.Put("a", "k", []byte("in a"))
+		s.Put("b", "k", []byte("in b"))
+		s.Delete("a", "k")
+		if _, err := s.Get("a", "k"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("deleted key still in a: %v", err)
+		}
+		if got, _ := s.Get("b", "k"); string(got) != "in b" {
+			t.Errorf("b/k = %q, want untouched", got)
+		}
+	})
+
+	t.Run("DeleteMissing", func(t *testing.T) {
+		s := open(t)
+		if err := s.Delete("never", "k"); err != nil {
+			t.Errorf("Delete missing = %v, want nil", err)
+		}
+	})
+
+	t.Run("ListSorted", func(t *testing.T) {
+		s := open(t)
+		for _, k := range []string{"0002", "0010", "0001"} {
+			s.Put("ns", k, []byte(k))
+		}
+		s.Put("other", "zzz", nil)
+		keys, err := s.List("ns")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := []string{"0001", "0002", "0010"}; !reflect.DeepEqual(keys, want) {
+			t.Errorf("List = %v, want %v", keys, want)
+		}
+		if keys, _ := s.List("empty"); len(keys) != 0 {
+			t.Errorf("List of unused namespace = %v, want none", keys)
+		}
+	})
+}
+
+func TestMemoryConformance(t *testing.T) {
+	testConformance(t, func(t *testing.T) Store { return NewMemory() })
+}
+
+func TestBoltConformance(t *testing.T) {
+	testConformance(t, func(t *testing.T) Store {
+		s, err := OpenBolt(filepath.Join(t.TempDir(), "goshell.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { s.Close() })
+		return s
+	})
+}
+
+func TestBoltPersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "goshell.db")
+	s, err := Open("bolt:" + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Put("widgets", "1", []byte("<b>kept</b>"))
+	s.Close()
+
+	s, err = Open("bolt:" + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if got, err := s.Get("widgets", "1"); err != nil || string(got) != "<b>kept</b>" {
+		t.Errorf("after reopen Get = %q, %v", got, err)
+	}
+}
+
+func TestOpenSpecs(t *testing.T) {
+	for _, spec := range []string{"", "memory"} {
+		s, err := Open(spec)
+		if err != nil {
+			t.Errorf("Open(%q) = %v", spec, err)
+			continue
+		}
+		if _, ok := s.(*Memory); !ok {
+			t.Errorf("Open(%q) = %T, want *Memory", spec, s)
+		}
+	}
+	for _, spec := range []string{"bolt", "bolt:", "redis:localhost"} {
+		if _, err := Open(spec); err == nil {
+			t.Errorf("Open(%q) succeeded, want error", spec)
+		}
+	}
+}
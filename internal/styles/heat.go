@@ -0,0 +1,144 @@
+package styles
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// heatMaxMix is how far the hottest row's background moves from
+// Colors.BgDark toward its heat color, before contrast clamping.
+const heatMaxMix = 0.55
+
+// heatMinContrast is the WCAG contrast ratio Colors.TextLight keeps
+// against a heated background.
+const heatMinContrast = 4.5
+
+// Heat tints rows by where their values fall between the smallest and
+// largest in a listing: the smallest get no tint and the largest the most,
+// toward Hot. Values can be pre-scaled, e.g. logarithmically for sizes.
+type Heat struct {
+	Hot      string // palette color, e.g. Colors.Red
+	min, max float64
+}
+
+// NewHeat returns the heat of a listing with values.
+func NewHeat(values []float64, hot string) Heat {
+	h := Heat{Hot: hot}
+	for i, v := range values {
+		if i == 0 || v < h.min {
+			h.min = v
+		}
+		if i == 0 || v > h.max {
+			h.max = v
+		}
+	}
+	return h
+}
+
+// Level is v's position between the listing's smallest and largest value,
+// from 0 to 1. A listing whose values are all equal has no heat.
+func (h Heat) Level(v float64) float64 {
+	if h.max <= h.min {
+		return 0
+	}
+	return math.Min(1, math.Max(0, (v-h.min)/(h.max-h.min)))
+}
+
+// Background returns the CSS background color of a row with value v, or
+// "" for a row that gets no tint.
+func (h Heat) Background(v float64) string {
+	level := h.Level(v)
+	if level == 0 {
+		return ""
+	}
+	return HeatColor(level, h.Hot)
+}
+
+// HeatColor blends Colors.BgDark toward hot by level (0 to 1), backing
+// off as far as needed for Colors.TextLight to stay readable on it.
+func HeatColor(level float64, hot string) string {
+	base, hotRGB, text := parseHexColor(Colors.BgDark), parseHexColor(hot), parseHexColor(Colors.TextLight)
+	mix := math.Min(1, math.Max(0, level)) * heatMaxMix
+	for {
+		c := blend(base, hotRGB, mix)
+		if mix <= 0 || contrastRatio(text, c) >= heatMinContrast {
+			return c.hex()
+		}
+		mix = math.Max(0, mix-0.01)
+	}
+}
+
+// HeatLegend renders a small gradient key from a row with no heat,
+// labeled cold, to the hottest row, labeled hot.
+func HeatLegend(cold, hot, color string) string {
+	return fmt.Sprintf(`<div class="shell-heat-legend"><span>%s</span><span class="shell-heat-bar" aria-hidden="true" style="background: linear-gradient(to right, %s, %s)"></span><span>%s</span></div>`,
+		HTMLEscape(cold), Colors.BgDark, HeatColor(1, color), HTMLEscape(hot))
+}
+
+// HeatCSS styles the heat legend.
+func HeatCSS() string {
+	return fmt.Sprintf(`
+.shell-heat-legend {
+	display: inline-flex;
+	align-items: center;
+	gap: 4px;
+	font-size: 10px;
+	color: %s;
+	margin-top: 2px;
+}
+.shell-heat-bar {
+	display: inline-block;
+	width: 60px;
+	height: 8px;
+	border: 1px solid %s;
+	border-radius: 2px;
+}
+`, Colors.TextGray, Colors.Border)
+}
+
+// rgb is a color with channels from 0 to 255.
+type rgb struct{ r, g, b float64 }
+
+// parseHexColor parses #rgb or #rrggbb; anything else is black.
+func parseHexColor(s string) rgb {
+	s = strings.TrimPrefix(s, "#")
+	if len(s) == 3 {
+		s = string([]byte{s[0], s[0], s[1], s[1], s[2], s[2]})
+	}
+	n, err := strconv.ParseUint(s, 16, 32)
+	if len(s) != 6 || err != nil {
+		return rgb{}
+	}
+	return rgb{float64(n >> 16 & 0xff), float64(n >> 8 & 0xff), float64(n & 0xff)}
+}
+
+func (c rgb) hex() string {
+	return fmt.Sprintf("#%02x%02x%02x", int(math.Round(c.r)), int(math.Round(c.g)), int(math.Round(c.b)))
+}
+
+func blend(a, b rgb, t float64) rgb {
+	return rgb{a.r + (b.r-a.r)*t, a.g + (b.g-a.g)*t, a.b + (b.b-a.b)*t}
+}
+
+// luminance is the WCAG relative luminance of c.
+func luminance(c rgb) float64 {
+	channel := func(v float64) float64 {
+		v /= 255
+		if v <= 0.03928 {
+			return v / 12.92
+		}
+		return math.Pow((v+0.055)/1.055, 2.4)
+	}
+	return 0.2126*channel(c.r) + 0.7152*channel(c.g) + 0.0722*channel(c.b)
+}
+
+// contrastRatio is the WCAG contrast ratio between a and b, from 1 to 21.
+func contrastRatio(a, b rgb) float64 {
+	la, lb := luminance(a), luminance(b)
+	if la < lb {
+		la, lb = lb, la
+	}
+	return (la + 0.05) / (lb + 0.05)
+}
@@ -0,0 +1,80 @@
+package styles
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestHeatLevels(t *testing.T) {
+	tests := []struct {
+		name   string
+		values []float64
+		want   []float64
+	}{
+		{"empty", nil, nil},
+		{"single entry", []float64{42}, []float64{0}},
+		{"all equal", []float64{7, 7, 7}, []float64{0, 0, 0}},
+		{"zero sizes", []float64{0, 0}, []float64{0, 0}},
+		{"spread", []float64{0, 5, 10}, []float64{0, 0.5, 1}},
+		{"negative", []float64{-10, 0, 10}, []float64{0, 0.5, 1}},
+	}
+	for _, tt := range tests {
+		h := NewHeat(tt.values, Colors.Red)
+		for i, v := range tt.values {
+			if got := h.Level(v); got != tt.want[i] {
+				t.Errorf("%s: Level(%v) = %v, want %v", tt.name, v, got, tt.want[i])
+			}
+		}
+	}
+
+	// Values outside the listing clamp to its ends
+	h := NewHeat([]float64{1, 2}, Colors.Red)
+	if h.Level(0) != 0 || h.Level(3) != 1 {
+		t.Errorf("out-of-range levels = %v, %v", h.Level(0), h.Level(3))
+	}
+}
+
+func TestHeatBackground(t *testing.T) {
+	h := NewHeat([]float64{0, 50, 100}, Colors.Red)
+	if got := h.Background(0); got != "" {
+		t.Errorf("coolest row tinted %q", got)
+	}
+	mid, hot := h.Background(50), h.Background(100)
+	if mid == "" || hot == "" || mid == hot {
+		t.Errorf("backgrounds = %q, %q; want two distinct tints", mid, hot)
+	}
+	if all := NewHeat([]float64{3, 3}, Colors.Red); all.Background(3) != "" {
+		t.Error("all-equal listing tinted")
+	}
+}
+
+func TestHeatColorContrast(t *testing.T) {
+	text := parseHexColor(Colors.TextLight)
+	for _, hot := range []string{Colors.Red, Colors.Yellow, Colors.Blue, Colors.Green, "#fff", "#ffff00"} {
+		for _, level := range []float64{0.1, 0.5, 1, 2} {
+			c := HeatColor(level, hot)
+			if r := contrastRatio(text, parseHexColor(c)); r < heatMinContrast {
+				t.Errorf("HeatColor(%v, %s) = %s: contrast %.2f below %v", level, hot, c, r, heatMinContrast)
+			}
+		}
+	}
+	// White would wash out the text at full mix; clamping backs off
+	if c := HeatColor(1, "#fff"); c == blend(parseHexColor(Colors.BgDark), parseHexColor("#fff"), heatMaxMix).hex() {
+		t.Errorf("HeatColor(1, #fff) = %s was not clamped", c)
+	}
+	if got := HeatColor(0, Colors.Red); got != Colors.BgDark {
+		t.Errorf("HeatColor(0) = %s, want the background %s", got, Colors.BgDark)
+	}
+}
+
+func TestHeatLegend(t *testing.T) {
+	legend := HeatLegend("newer", "older", Colors.Yellow)
+	for _, want := range []string{">newer<", ">older<", "linear-gradient(to right, " + Colors.BgDark + ", " + HeatColor(1, Colors.Yellow) + ")"} {
+		if !strings.Contains(legend, want) {
+			t.Errorf("legend %q missing %q", legend, want)
+		}
+	}
+	if strings.Contains(HeatCSS(), "%!") {
+		t.Error("HeatCSS has a formatting error")
+	}
+}
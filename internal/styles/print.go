@@ -0,0 +1,87 @@
+package styles
+
+import "strings"
+
+// PrintCSS returns the stylesheet for printing a widget: dark text on
+// white, every tree and list expanded, no interactive controls, and page
+// breaks kept out of rows and away from headers. Load it after the
+// widget's own styles so it wins.
+func PrintCSS() string {
+	return `
+body {
+	margin: 16px;
+	background: #fff;
+}
+body, body * {
+	color: #111 !important;
+	background-color: transparent !important;
+	box-shadow: none !important;
+	text-shadow: none !important;
+}
+.tree-children, .shell-children {
+	display: block !important;
+}
+.tree-toggle, .shell-toggle, .shell-sort-buttons, .shell-sort-btn, .shell-download, .confirm-form, .shell-print-button {
+	display: none !important;
+}
+.tree-row, .shell-row, .token-item {
+	outline: none !important;
+	break-inside: avoid;
+	page-break-inside: avoid;
+}
+.tree-bar-container, .shell-bar-container {
+	border: 1px solid #999;
+}
+.tree-bar, .shell-bar {
+	background: #666 !important;
+	-webkit-print-color-adjust: exact;
+	print-color-adjust: exact;
+}
+.tree-cell.name, .shell-name {
+	white-space: normal !important;
+	overflow: visible !important;
+}
+.shell-header, .shell-print-header {
+	border-bottom: 1px solid #999;
+	break-after: avoid;
+	page-break-after: avoid;
+}
+.tree-table > li, .shell-list > li {
+	break-inside: avoid-page;
+}
+.shell-container + .shell-container, hr {
+	break-before: page;
+	page-break-before: always;
+}
+.shell-print-header {
+	display: flex;
+	align-items: center;
+	justify-content: space-between;
+	font-family: monospace;
+	margin-bottom: 8px;
+	padding-bottom: 4px;
+}
+@media print {
+	body {
+		margin: 0;
+	}
+	.shell-print-header {
+		display: none;
+	}
+}
+`
+}
+
+// treeExpansion rewrites the collapsed state RenderTreeTable emits into
+// the expanded one, without the toggle script.
+var treeExpansion = strings.NewReplacer(
+	`class="tree-children"`, `class="tree-children expanded"`,
+	`aria-expanded="false"`, `aria-expanded="true"`,
+	`">▶</button>`, `">▼</button>`,
+)
+
+// ExpandTreeTables returns html, holding tree tables from RenderTreeTable,
+// with every node expanded.
+func ExpandTreeTables(html string) string {
+	return treeExpansion.Replace(html)
+}
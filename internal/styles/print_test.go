@@ -0,0 +1,30 @@
+package styles
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestExpandTreeTables(t *testing.T) {
+	ResetTreeNodeCounter()
+	child := &TreeNode{Cells: []string{"child"}}
+	nodes := []*TreeNode{
+		{Cells: []string{"closed"}, Expandable: true, Children: []*TreeNode{child}},
+		{Cells: []string{"open"}, Expandable: true, Expanded: true, Children: []*TreeNode{{Cells: []string{"other"}}}},
+	}
+	got := ExpandTreeTables(RenderTreeTable(nodes, TreeTableConfig{TogglePrefix: "t"}))
+
+	if strings.Contains(got, `class="tree-children"`) || strings.Count(got, `class="tree-children expanded"`) != 2 {
+		t.Errorf("children not all expanded:\n%s", got)
+	}
+	if strings.Contains(got, `aria-expanded="false"`) || strings.Contains(got, `">▶</button>`) {
+		t.Errorf("rows still marked collapsed:\n%s", got)
+	}
+	if !strings.Contains(got, ">child<") {
+		t.Errorf("child row lost:\n%s", got)
+	}
+	// Already expanded markup is left alone
+	if again := ExpandTreeTables(got); again != got {
+		t.Error("expanding twice changed the markup")
+	}
+}
@@ -3,7 +3,10 @@ package styles
 
 import (
 	"fmt"
+	"os"
+	"strconv"
 	"strings"
+	"time"
 )
 
 // Colors defines the shared color palette (One Dark theme)
@@ -12,6 +15,7 @@ var Colors = struct {
 	Purple    string // Directories
 	Green     string // Sizes, success
 	Yellow    string // Dates
+	Red       string // Denials, errors
 	TextLight string // Regular text
 	TextGray  string // Secondary/labels
 	BgDark    string // Backgrounds
@@ -22,6 +26,7 @@ var Colors = struct {
 	Purple:    "#c678dd",
 	Green:     "#98c379",
 	Yellow:    "#e5c07b",
+	Red:       "#e06c75",
 	TextLight: "#abb2bf",
 	TextGray:  "#888",
 	BgDark:    "#2d2d2d",
@@ -160,10 +165,15 @@ func BaseCSS() string {
 	transition: background-color 0.15s;
 	display: inline-block;
 	font-family: monospace;
+	line-height: inherit;
 }
 .shell-sort-btn:hover {
 	background-color: %s;
 }
+.shell-sort-btn:focus-visible {
+	outline: 2px solid %s;
+	outline-offset: 1px;
+}
 .shell-sort-btn.active {
 	color: %s;
 	border-color: %s;
@@ -173,6 +183,10 @@ func BaseCSS() string {
 .token-grid:focus {
 	outline: none;
 }
+.token-grid:focus-visible {
+	outline: 1px dashed %s;
+	outline-offset: 2px;
+}
 .token-item {
 	cursor: pointer;
 }
@@ -189,25 +203,167 @@ func BaseCSS() string {
 .token-item.copy-flash {
 	background-color: rgba(152, 195, 121, 0.4);
 }
+
+/* Entries git ignores (lsh/duh -gitignore) */
+.token-item.ignored {
+	opacity: 0.5;
+}
+.shell-badge {
+	margin-left: 6px;
+	padding: 0 4px;
+	border: 1px solid currentColor;
+	border-radius: 3px;
+	font-size: 9px;
+	color: inherit;
+	opacity: 0.8;
+}
+.shell-sparkline {
+	font-family: monospace;
+	white-space: pre;
+}
+
+@media (prefers-reduced-motion: reduce) {
+	.shell-container *,
+	.shell-container *::before,
+	.shell-container *::after {
+		transition: none !important;
+		animation: none !important;
+		transform: none !important;
+	}
+}
 `, Colors.Border, Colors.Blue, Colors.TextGray, Colors.BgHover,
 		Colors.Blue, Colors.Purple, Colors.Green, Colors.Yellow, Colors.TextGray,
 		Colors.TextGray, Colors.Blue, Colors.BgDark, Colors.Green, Colors.Blue,
-		Colors.BgDark, Colors.Blue, Colors.Border, Colors.BgHover, Colors.Green, Colors.Green,
-		Colors.Blue)
+		Colors.BgDark, Colors.Blue, Colors.Border, Colors.BgHover, Colors.Blue, Colors.Green, Colors.Green,
+		Colors.Blue, Colors.Blue)
 }
 
-// FormatSize converts bytes to human-readable format
+// IgnoredBadge marks an entry git ignores.
+const IgnoredBadge = `<span class="shell-badge">ignored</span>`
+
+// ConfirmCSS styles the server's confirmation widgets, whose buttons
+// are .shell-sort-btn buttons in a .confirm-form.
+func ConfirmCSS() string {
+	return fmt.Sprintf(`
+.confirm-form {
+	display: flex;
+	align-items: center;
+	gap: 6px;
+}
+.confirm-approve {
+	color: %s;
+	border-color: %s;
+}
+.confirm-deny {
+	color: %s;
+}
+.confirm-note {
+	font-size: 11px;
+	color: %s;
+}
+.confirm-outcome.approved {
+	color: %s;
+}
+.confirm-outcome.rejected {
+	color: %s;
+}
+`, Colors.Green, Colors.Green, Colors.Red, Colors.TextGray, Colors.Green, Colors.Red)
+}
+
+// SortButton renders a toolbar button that runs cmd in the shell when
+// clicked. ariaLabel names buttons whose label is only a symbol; pass ""
+// to use the visible label.
+func SortButton(label, ariaLabel, cmd string, active bool) string {
+	class := "shell-sort-btn"
+	if active {
+		class += " active"
+	}
+	attrs := fmt.Sprintf(` aria-pressed="%t"`, active)
+	if ariaLabel != "" {
+		attrs += fmt.Sprintf(` aria-label="%s"`, HTMLEscape(ariaLabel))
+	}
+	return fmt.Sprintf(`<button type="button" class="%s"%s onclick="runCommand(&quot;%s&quot;)">%s</button>`,
+		class, attrs, HTMLEscape(cmd), label)
+}
+
+// MoreLevelsNode is the tree-table row that stands in for the contents of
+// a directory at a listing's depth limit: a button that runs cmd, which
+// should list that directory another levels deep.
+func MoreLevelsNode(levels int, cmd string) *TreeNode {
+	noun := "levels"
+	if levels == 1 {
+		noun = "level"
+	}
+	return &TreeNode{
+		Icon:  "⋯",
+		Class: "more-levels",
+		Cells: []string{fmt.Sprintf(`<button type="button" class="shell-sort-btn" onclick="runCommand(&quot;%s&quot;)">show %d more %s here</button>`,
+			HTMLEscape(cmd), levels, noun)},
+	}
+}
+
+// SizeUnits selects how sizes are scaled and labeled.
+type SizeUnits int
+
+const (
+	BinaryUnits SizeUnits = iota // powers of 1024: KiB, MiB, ...
+	SIUnits                      // powers of 1000: kB, MB, ...
+)
+
+// SizeUnitsFromEnv returns the default unit system: SIUnits when
+// GOSHELL_SI is set to a true value such as "1", BinaryUnits otherwise.
+func SizeUnitsFromEnv() SizeUnits {
+	if si, err := strconv.ParseBool(os.Getenv("GOSHELL_SI")); err == nil && si {
+		return SIUnits
+	}
+	return BinaryUnits
+}
+
+// FormatSize converts bytes to human-readable format in binary units
 func FormatSize(size int64) string {
-	const unit = 1024
+	return FormatSizeIn(size, BinaryUnits)
+}
+
+// FormatSizeIn converts bytes to human-readable format in the given units
+func FormatSizeIn(size int64, units SizeUnits) string {
+	unit, labels := int64(1024), []string{"KiB", "MiB", "GiB", "TiB", "PiB", "EiB"}
+	if units == SIUnits {
+		unit, labels = 1000, []string{"kB", "MB", "GB", "TB", "PB", "EB"}
+	}
 	if size < unit {
 		return fmt.Sprintf("%d B", size)
 	}
-	div, exp := int64(unit), 0
+	div, exp := unit, 0
 	for n := size / unit; n >= unit; n /= unit {
 		div *= unit
 		exp++
 	}
-	return fmt.Sprintf("%.1f %cB", float64(size)/float64(div), "KMGTPE"[exp])
+	return fmt.Sprintf("%.1f %s", float64(size)/float64(div), labels[exp])
+}
+
+// sparkBlocks are the glyphs of a sparkline, lowest to highest.
+var sparkBlocks = []rune("▁▂▃▄▅▆▇█")
+
+// Sparkline renders values, oldest first, as a row of block glyphs scaled
+// between their minimum and maximum. label is the accessible name, as the
+// glyphs themselves mean nothing to a screen reader.
+func Sparkline(values []int64, label string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	lo, hi := values[0], values[0]
+	for _, v := range values {
+		lo, hi = min(lo, v), max(hi, v)
+	}
+	glyphs := make([]rune, len(values))
+	for i, v := range values {
+		level := len(sparkBlocks) / 2
+		if hi > lo {
+			level = int(float64(v-lo) / float64(hi-lo) * float64(len(sparkBlocks)-1))
+		}
+		glyphs[i] = sparkBlocks[level]
+	}
+	return fmt.Sprintf(`<span class="shell-sparkline" role="img" aria-label="%s">%s</span>`, HTMLEscape(label), string(glyphs))
 }
 
 // HTMLEscape escapes HTML special characters
@@ -230,3 +386,16 @@ const (
 	HTMLStart = "\x1b]9001;HTML_START\x07"
 	HTMLEnd   = "\x1b]9001;HTML_END\x07"
 )
+
+// NewWidgetKey returns a replaces-widget key unique to this invocation of
+// tool, for output that may later be refreshed in place.
+func NewWidgetKey(tool string) string {
+	return fmt.Sprintf("%s-%d-%d", tool, os.Getpid(), time.Now().UnixNano())
+}
+
+// HTMLStartWithKey starts an HTML block that replaces the widget
+// previously emitted with the same key, updating it in place instead of
+// adding a new one. The key must not contain control characters.
+func HTMLStartWithKey(key string) string {
+	return "\x1b]9001;HTML_START;key=" + key + "\x07"
+}
@@ -0,0 +1,169 @@
+package styles
+
+import (
+	"strings"
+	"testing"
+)
+
+func sampleTree() []*TreeNode {
+	return []*TreeNode{
+		{
+			Icon:       "📁",
+			IsDir:      true,
+			Expandable: true,
+			Cells:      []string{"src", "4.0 KB"},
+			Children: []*TreeNode{
+				{Icon: "📄", Cells: []string{"main.go", "1.0 KB"}},
+			},
+		},
+		{Icon: "📄", Cells: []string{"README.md", "512 B"}},
+	}
+}
+
+func TestRenderTreeTableAccessibility(t *testing.T) {
+	ResetTreeNodeCounter()
+	html := RenderTreeTable(sampleTree(), TreeTableConfig{
+		Columns:      []Column{{Class: "name"}, {Class: "size"}},
+		ShowBar:      true,
+		TogglePrefix: "t",
+		TreeID:       "t",
+		Label:        "/home/<me>",
+	})
+
+	required := []string{
+		`<ul class="tree-table" role="tree" aria-label="/home/&lt;me&gt;"`,
+		`<li role="none">`,
+		`role="treeitem" aria-level="1" aria-expanded="false"`,
+		`role="treeitem" aria-level="2" data-row-id`,
+		`<ul id="t-children-1" class="tree-children" role="group">`,
+		`<button type="button" id="t-toggle-1" class="tree-toggle"`,
+		`setAttribute('aria-expanded',open)`,
+		`<span class="tree-icon" aria-hidden="true">`,
+		`<div class="tree-bar-container" aria-hidden="true">`,
+	}
+	for _, want := range required {
+		if !strings.Contains(html, want) {
+			t.Errorf("rendered tree missing %q", want)
+		}
+	}
+
+	// Leaf rows must not claim to be expandable.
+	if strings.Count(html, "aria-expanded=") != 1 {
+		t.Errorf("aria-expanded on %d rows, want only the expandable one", strings.Count(html, "aria-expanded="))
+	}
+	if strings.Contains(html, `href="#"`) {
+		t.Error("tree uses anchor pseudo-buttons")
+	}
+}
+
+func TestSortButton(t *testing.T) {
+	got := SortButton("↕", "Reverse sort order", `'/bin/lsh' -r '/tmp/a"b'`, false)
+	want := `<button type="button" class="shell-sort-btn" aria-pressed="false" aria-label="Reverse sort order" onclick="runCommand(&quot;&#39;/bin/lsh&#39; -r &#39;/tmp/a&quot;b&#39;&quot;)">↕</button>`
+	if got != want {
+		t.Errorf("SortButton =\n%s\nwant\n%s", got, want)
+	}
+
+	active := SortButton("Name", "", "lsh", true)
+	if !strings.Contains(active, `class="shell-sort-btn active" aria-pressed="true"`) || strings.Contains(active, "aria-label") {
+		t.Errorf("active button = %s", active)
+	}
+}
+
+func TestMoreLevelsNode(t *testing.T) {
+	node := MoreLevelsNode(2, `'/bin/duh' -d 2 '/tmp/a'`)
+	want := `<button type="button" class="shell-sort-btn" onclick="runCommand(&quot;&#39;/bin/duh&#39; -d 2 &#39;/tmp/a&#39;&quot;)">show 2 more levels here</button>`
+	if node.Class != "more-levels" || len(node.Cells) != 1 || node.Cells[0] != want {
+		t.Errorf("MoreLevelsNode = %+v, want one cell\n%s", node, want)
+	}
+	if one := MoreLevelsNode(1, "duh"); !strings.Contains(one.Cells[0], "show 1 more level here") {
+		t.Errorf("one level = %s", one.Cells[0])
+	}
+}
+
+func TestCSSAccessibility(t *testing.T) {
+	base := BaseCSS()
+	for _, want := range []string{"@media (prefers-reduced-motion: reduce)", "transition: none", ".shell-sort-btn:focus-visible", ".token-grid:focus-visible"} {
+		if !strings.Contains(base, want) {
+			t.Errorf("BaseCSS missing %q", want)
+		}
+	}
+	if !strings.Contains(TreeTableCSS(), ".tree-toggle:focus-visible") {
+		t.Error("TreeTableCSS missing toggle focus outline")
+	}
+	for name, css := range map[string]string{"BaseCSS": base, "TreeTableCSS": TreeTableCSS(), "ConfirmCSS": ConfirmCSS(), "TabsCSS": TabsCSS()} {
+		if strings.Contains(css, "%!") {
+			t.Errorf("%s has a formatting error", name)
+		}
+	}
+}
+
+func TestFormatSizeIn(t *testing.T) {
+	tests := []struct {
+		size       int64
+		binary, si string
+	}{
+		{0, "0 B", "0 B"},
+		{999, "999 B", "999 B"},
+		{1000, "1000 B", "1.0 kB"},
+		{1024, "1.0 KiB", "1.0 kB"},
+		{1536, "1.5 KiB", "1.5 kB"},
+		{1 << 20, "1.0 MiB", "1.0 MB"},
+		{5_000_000_000, "4.7 GiB", "5.0 GB"},
+		{1 << 62, "4.0 EiB", "4.6 EB"},
+	}
+	for _, tt := range tests {
+		if got := FormatSizeIn(tt.size, BinaryUnits); got != tt.binary {
+			t.Errorf("FormatSizeIn(%d, BinaryUnits) = %q, want %q", tt.size, got, tt.binary)
+		}
+		if got := FormatSizeIn(tt.size, SIUnits); got != tt.si {
+			t.Errorf("FormatSizeIn(%d, SIUnits) = %q, want %q", tt.size, got, tt.si)
+		}
+	}
+}
+
+func TestSparkline(t *testing.T) {
+	tests := []struct {
+		values []int64
+		want   string
+	}{
+		{nil, ""},
+		{[]int64{5}, "▅"},
+		{[]int64{3, 3, 3}, "▅▅▅"},
+		{[]int64{0, 50, 100}, "▁▄█"},
+		{[]int64{100, 0}, "█▁"},
+	}
+	for _, tt := range tests {
+		got := Sparkline(tt.values, "size")
+		if tt.want == "" {
+			if got != "" {
+				t.Errorf("Sparkline(%v) = %q, want empty", tt.values, got)
+			}
+			continue
+		}
+		if !strings.Contains(got, ">"+tt.want+"<") {
+			t.Errorf("Sparkline(%v) = %q, want glyphs %q", tt.values, got, tt.want)
+		}
+		if !strings.Contains(got, `role="img" aria-label="size"`) {
+			t.Errorf("Sparkline(%v) = %q, missing accessible name", tt.values, got)
+		}
+	}
+}
+
+func TestSizeUnitsFromEnv(t *testing.T) {
+	tests := []struct {
+		value string
+		want  SizeUnits
+	}{
+		{"", BinaryUnits},
+		{"0", BinaryUnits},
+		{"nonsense", BinaryUnits},
+		{"1", SIUnits},
+		{"true", SIUnits},
+	}
+	for _, tt := range tests {
+		t.Setenv("GOSHELL_SI", tt.value)
+		if got := SizeUnitsFromEnv(); got != tt.want {
+			t.Errorf("GOSHELL_SI=%q: units = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
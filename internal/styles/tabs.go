@@ -0,0 +1,80 @@
+package styles
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Tab is one page of a Tabs component.
+type Tab struct {
+	Label string // plain text
+	Body  string // HTML
+}
+
+// MaxTabs is how many tabs TabsCSS can switch between.
+const MaxTabs = 10
+
+// Tabs renders tabs as a row of labels above the selected tab's page. The
+// labels are radio buttons, so switching works without script and from the
+// keyboard. name must be unique within the page: it names the radio group
+// and prefixes the inputs' IDs. The first tab starts selected; tabs past
+// MaxTabs can't be shown.
+func Tabs(name string, tabs []Tab) string {
+	var b strings.Builder
+	b.WriteString(`<div class="shell-tabs">`)
+	for i := range tabs {
+		checked := ""
+		if i == 0 {
+			checked = " checked"
+		}
+		fmt.Fprintf(&b, `<input type="radio" class="shell-tab-radio" name="%s" id="%s-%d"%s>`,
+			HTMLEscape(name), HTMLEscape(name), i+1, checked)
+	}
+	b.WriteString(`<div class="shell-tab-labels">`)
+	for i, tab := range tabs {
+		fmt.Fprintf(&b, `<label class="shell-tab" for="%s-%d">%s</label>`, HTMLEscape(name), i+1, HTMLEscape(tab.Label))
+	}
+	b.WriteString(`</div>`)
+	for _, tab := range tabs {
+		fmt.Fprintf(&b, `<section class="shell-tab-panel">%s</section>`, tab.Body)
+	}
+	b.WriteString(`</div>`)
+	return b.String()
+}
+
+// TabsCSS styles Tabs.
+func TabsCSS() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, `
+.shell-tab-radio {
+	position: absolute;
+	opacity: 0;
+	pointer-events: none;
+}
+.shell-tab-labels {
+	display: flex;
+	gap: 2px;
+	border-bottom: 1px solid %s;
+	margin-bottom: 8px;
+}
+.shell-tab {
+	padding: 4px 10px;
+	color: %s;
+	cursor: pointer;
+	border-bottom: 2px solid transparent;
+}
+.shell-tab:hover {
+	background: %s;
+}
+.shell-tab-panel {
+	display: none;
+}
+`, Colors.Border, Colors.TextGray, Colors.BgHover)
+	for i := 1; i <= MaxTabs; i++ {
+		fmt.Fprintf(&b, `.shell-tab-radio:nth-of-type(%d):checked ~ .shell-tab-panel:nth-of-type(%d) { display: block; }
+.shell-tab-radio:nth-of-type(%d):checked ~ .shell-tab-labels .shell-tab:nth-of-type(%d) { color: %s; border-bottom-color: %s; }
+.shell-tab-radio:nth-of-type(%d):focus-visible ~ .shell-tab-labels .shell-tab:nth-of-type(%d) { outline: 1px solid %s; }
+`, i, i, i, i, Colors.Blue, Colors.Blue, i, i, Colors.Blue)
+	}
+	return b.String()
+}
@@ -0,0 +1,38 @@
+package styles
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTabs(t *testing.T) {
+	html := Tabs("t<1>", []Tab{
+		{Label: "One & only", Body: "<p>first</p>"},
+		{Label: "Two", Body: "<p>second</p>"},
+	})
+	for _, want := range []string{
+		`<input type="radio" class="shell-tab-radio" name="t&lt;1&gt;" id="t&lt;1&gt;-1" checked>`,
+		`<input type="radio" class="shell-tab-radio" name="t&lt;1&gt;" id="t&lt;1&gt;-2">`,
+		`<label class="shell-tab" for="t&lt;1&gt;-1">One &amp; only</label>`,
+		`<section class="shell-tab-panel"><p>first</p></section><section class="shell-tab-panel"><p>second</p></section>`,
+	} {
+		if !strings.Contains(html, want) {
+			t.Errorf("Tabs missing %s in:\n%s", want, html)
+		}
+	}
+	// The radios come before the labels and pages, which TabsCSS selects
+	// as their siblings
+	if strings.Index(html, "shell-tab-radio") > strings.Index(html, "shell-tab-labels") {
+		t.Error("radio inputs must precede the labels")
+	}
+}
+
+func TestTabsCSSCoversMaxTabs(t *testing.T) {
+	css := TabsCSS()
+	if !strings.Contains(css, ".shell-tab-radio:nth-of-type(10):checked ~ .shell-tab-panel:nth-of-type(10)") {
+		t.Errorf("TabsCSS doesn't switch to tab %d", MaxTabs)
+	}
+	if strings.Contains(css, "nth-of-type(11)") {
+		t.Errorf("TabsCSS has rules past MaxTabs")
+	}
+}
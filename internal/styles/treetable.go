@@ -38,6 +38,8 @@ type TreeNode struct {
 	OnClick     string            // Optional onclick handler for the row
 	BarPercent  float64           // Percentage for bar visualization (0-100)
 	Value       string            // Value to insert (shell-escaped) for keyboard navigation
+	Class       string            // Extra class for the row (e.g. "ignored")
+	Style       string            // Inline style for the row (e.g. a heat tint)
 }
 
 // TreeTableConfig configures the tree table component
@@ -47,6 +49,7 @@ type TreeTableConfig struct {
 	BarAfterCell  int         // Insert bar after this cell index (-1 to disable)
 	TogglePrefix  string      // ID prefix for toggle elements (e.g., "duh", "lsh")
 	TreeID        string      // ID for the tree container (enables keyboard navigation)
+	Label         string      // Accessible name for the tree (aria-label)
 }
 
 // TreeTableCSS returns CSS for the tree table component
@@ -85,9 +88,16 @@ func TreeTableCSS() string {
 .tree-row.copy-flash {
 	background-color: rgba(152, 195, 121, 0.4);
 }
+.tree-row.ignored {
+	opacity: 0.5;
+}
 .tree-table:focus {
 	outline: none;
 }
+.tree-table:focus-visible {
+	outline: 1px dashed %s;
+	outline-offset: 2px;
+}
 .tree-toggle {
 	width: 14px;
 	display: inline-block;
@@ -97,10 +107,19 @@ func TreeTableCSS() string {
 	font-size: 10px;
 	user-select: none;
 	flex-shrink: 0;
+	background: none;
+	border: 0;
+	padding: 0;
+	font-family: inherit;
+	line-height: inherit;
 }
 .tree-toggle:hover {
 	color: %s;
 }
+.tree-toggle:focus-visible {
+	outline: 2px solid %s;
+	outline-offset: 1px;
+}
 .tree-toggle.empty {
 	visibility: hidden;
 }
@@ -163,8 +182,8 @@ func TreeTableCSS() string {
 .tree-children.expanded {
 	display: block;
 }
-`, Colors.BgHover, Colors.Blue, Colors.TextGray, Colors.Blue, Colors.Blue,
-		Colors.Purple, Colors.Green, Colors.Yellow, Colors.TextGray,
+`, Colors.BgHover, Colors.Blue, Colors.Blue, Colors.TextGray, Colors.Blue, Colors.Blue,
+		Colors.Blue, Colors.Purple, Colors.Green, Colors.Yellow, Colors.TextGray,
 		Colors.BgDark, Colors.Green, Colors.Blue)
 }
 
@@ -172,11 +191,16 @@ func TreeTableCSS() string {
 func RenderTreeTable(nodes []*TreeNode, config TreeTableConfig) string {
 	var html strings.Builder
 
+	label := ""
+	if config.Label != "" {
+		label = fmt.Sprintf(` aria-label="%s"`, HTMLEscape(config.Label))
+	}
+
 	// Add data-tree-id and tabindex for keyboard navigation
 	if config.TreeID != "" {
-		html.WriteString(fmt.Sprintf(`<ul class="tree-table" data-tree-id="%s" tabindex="0">`, config.TreeID))
+		html.WriteString(fmt.Sprintf(`<ul class="tree-table" role="tree"%s data-tree-id="%s" tabindex="0">`, label, config.TreeID))
 	} else {
-		html.WriteString(`<ul class="tree-table">`)
+		html.WriteString(fmt.Sprintf(`<ul class="tree-table" role="tree"%s>`, label))
 	}
 	for _, node := range nodes {
 		renderTreeNode(&html, node, config, 0)
@@ -200,10 +224,20 @@ func renderTreeNode(html *strings.Builder, node *TreeNode, config TreeTableConfi
 		prefix = "tree"
 	}
 
-	html.WriteString(`<li>`)
+	expandable := node.Expandable && len(node.Children) > 0
+
+	html.WriteString(`<li role="none">`)
 
 	// Build tree-row with data attributes for keyboard navigation
-	html.WriteString(`<div class="tree-row"`)
+	if node.Class != "" {
+		html.WriteString(fmt.Sprintf(`<div class="tree-row %s" role="treeitem"`, node.Class))
+	} else {
+		html.WriteString(`<div class="tree-row" role="treeitem"`)
+	}
+	html.WriteString(fmt.Sprintf(` aria-level="%d"`, depth+1))
+	if expandable {
+		html.WriteString(fmt.Sprintf(` aria-expanded="%t"`, node.Expanded))
+	}
 	html.WriteString(fmt.Sprintf(` data-row-id="%d"`, id))
 	if node.Value != "" {
 		html.WriteString(fmt.Sprintf(` data-value="%s"`, node.Value))
@@ -216,24 +250,28 @@ func renderTreeNode(html *strings.Builder, node *TreeNode, config TreeTableConfi
 	if node.OnClick != "" {
 		html.WriteString(fmt.Sprintf(` onclick="%s"`, node.OnClick))
 	}
+	if node.Style != "" {
+		html.WriteString(fmt.Sprintf(` style="%s"`, HTMLEscape(node.Style)))
+	}
 	html.WriteString(`>`)
 
-	// Toggle button
-	if node.Expandable && len(node.Children) > 0 {
+	// Toggle button (keyboard users expand rows with the arrow keys, so it
+	// stays out of the tab order)
+	if expandable {
 		expandedChar := "▶"
 		if node.Expanded {
 			expandedChar = "▼"
 		}
 		html.WriteString(fmt.Sprintf(
-			`<span id="%s-toggle-%d" class="tree-toggle" onclick="var c=document.getElementById('%s-children-%d');var t=this;if(c.classList.contains('expanded')){c.classList.remove('expanded');t.textContent='▶';}else{c.classList.add('expanded');t.textContent='▼';}">%s</span>`,
-			prefix, id, prefix, id, expandedChar))
+			`<button type="button" id="%s-toggle-%d" class="tree-toggle" tabindex="-1" aria-label="Toggle children" onclick="%s">%s</button>`,
+			prefix, id, TreeToggleOnClick(prefix, id), expandedChar))
 	} else {
-		html.WriteString(`<span class="tree-toggle empty"></span>`)
+		html.WriteString(`<span class="tree-toggle empty" aria-hidden="true"></span>`)
 	}
 
 	// Icon
 	if node.Icon != "" {
-		html.WriteString(fmt.Sprintf(`<span class="tree-icon">%s</span>`, node.Icon))
+		html.WriteString(fmt.Sprintf(`<span class="tree-icon" aria-hidden="true">%s</span>`, node.Icon))
 	}
 
 	// Cells
@@ -252,7 +290,7 @@ func renderTreeNode(html *strings.Builder, node *TreeNode, config TreeTableConfi
 
 		// Insert bar after the specified cell if configured
 		if config.ShowBar && i == config.BarAfterCell {
-			html.WriteString(fmt.Sprintf(`<div class="tree-bar-container"><div class="tree-bar" style="width: %.1f%%"></div></div>`, node.BarPercent))
+			html.WriteString(fmt.Sprintf(`<div class="tree-bar-container" aria-hidden="true"><div class="tree-bar" style="width: %.1f%%"></div></div>`, node.BarPercent))
 		}
 	}
 
@@ -264,7 +302,7 @@ func renderTreeNode(html *strings.Builder, node *TreeNode, config TreeTableConfi
 		if node.Expanded {
 			expandedClass = " expanded"
 		}
-		html.WriteString(fmt.Sprintf(`<ul id="%s-children-%d" class="tree-children%s">`, prefix, id, expandedClass))
+		html.WriteString(fmt.Sprintf(`<ul id="%s-children-%d" class="tree-children%s" role="group">`, prefix, id, expandedClass))
 		for _, child := range node.Children {
 			renderTreeNode(html, child, config, depth+1)
 		}
@@ -274,6 +312,14 @@ func renderTreeNode(html *strings.Builder, node *TreeNode, config TreeTableConfi
 	html.WriteString(`</li>`)
 }
 
+// TreeToggleOnClick is the onclick handler of the toggle button with id
+// "{prefix}-toggle-{id}", which opens or closes the subtree
+// "{prefix}-children-{id}".
+func TreeToggleOnClick(prefix string, id int) string {
+	return fmt.Sprintf(`var c=document.getElementById('%s-children-%d');var t=this;var open=!c.classList.contains('expanded');c.classList.toggle('expanded',open);t.textContent=open?'▼':'▶';t.parentNode.setAttribute('aria-expanded',open);`,
+		prefix, id)
+}
+
 // ResetTreeNodeCounter resets the node counter (call before rendering a new tree)
 func ResetTreeNodeCounter() {
 	treeNodeCounter = 0
@@ -0,0 +1,140 @@
+package testshell
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// DefaultTimeout bounds each expectation unless a test passes its own.
+const DefaultTimeout = 5 * time.Second
+
+// Event is a JSON text frame from the server, decoded loosely.
+type Event map[string]any
+
+// Kind returns the event's "kind" field.
+func (e Event) Kind() string {
+	kind, _ := e["kind"].(string)
+	return kind
+}
+
+type frame struct {
+	binary bool
+	data   []byte
+}
+
+// Client is a websocket client of a goshell server under test. Terminal
+// output and JSON events are consumed in order by the Expect methods.
+type Client struct {
+	t      testing.TB
+	conn   *websocket.Conn
+	frames chan frame
+	output string  // terminal output not yet matched by ExpectOutput
+	events []Event // events not yet matched by ExpectEvent
+	Ready  Event   // the server's ready message
+}
+
+// Dial connects to the websocket at serverURL + "/ws/shell" + query and
+// waits for the ready message. The connection is closed when the test ends.
+func Dial(t testing.TB, serverURL, query string) *Client {
+	t.Helper()
+	url := "ws://" + strings.TrimPrefix(serverURL, "http://") + "/ws/shell" + query
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("dial %s: %v", url, err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	c := &Client{t: t, conn: conn, frames: make(chan frame, 1024)}
+	go func() {
+		defer close(c.frames)
+		for {
+			msgType, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			c.frames <- frame{binary: msgType == websocket.BinaryMessage, data: data}
+		}
+	}()
+	c.Ready = c.ExpectEvent("ready", DefaultTimeout)
+	return c
+}
+
+// Close closes the connection, as a client going away would.
+func (c *Client) Close() {
+	c.conn.Close()
+}
+
+// Send writes a line of input to the shell, adding the newline.
+func (c *Client) Send(line string) {
+	c.t.Helper()
+	if err := c.conn.WriteMessage(websocket.TextMessage, []byte(line+"\n")); err != nil {
+		c.t.Fatalf("send %q: %v", line, err)
+	}
+}
+
+// SendJSON writes v as a JSON text frame, e.g. a control message.
+func (c *Client) SendJSON(v any) {
+	c.t.Helper()
+	if err := c.conn.WriteJSON(v); err != nil {
+		c.t.Fatalf("send %v: %v", v, err)
+	}
+}
+
+// receive consumes one frame, failing the test at the deadline.
+func (c *Client) receive(deadline *time.Timer, want string) {
+	c.t.Helper()
+	select {
+	case f, ok := <-c.frames:
+		if !ok {
+			c.t.Fatalf("connection closed waiting for %s; unmatched output %q", want, c.output)
+		}
+		if f.binary {
+			c.output += string(f.data)
+			return
+		}
+		var ev Event
+		if json.Unmarshal(f.data, &ev) == nil {
+			c.events = append(c.events, ev)
+		}
+	case <-deadline.C:
+		c.t.Fatalf("timed out waiting for %s; unmatched output %q", want, c.output)
+	}
+}
+
+// ExpectOutput waits for substr in the terminal output and returns the
+// output up to and including it. Later expectations only see what follows.
+func (c *Client) ExpectOutput(substr string, timeout time.Duration) string {
+	c.t.Helper()
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	for {
+		if i := strings.Index(c.output, substr); i >= 0 {
+			got := c.output[:i+len(substr)]
+			c.output = c.output[i+len(substr):]
+			return got
+		}
+		c.receive(deadline, "output "+strconv.Quote(substr))
+	}
+}
+
+// ExpectEvent waits for the next event of the given kind and returns it.
+// Events of other kinds stay queued for later expectations.
+func (c *Client) ExpectEvent(kind string, timeout time.Duration) Event {
+	c.t.Helper()
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	for {
+		for i, ev := range c.events {
+			if ev.Kind() == kind {
+				c.events = append(c.events[:i], c.events[i+1:]...)
+				return ev
+			}
+		}
+		c.receive(deadline, kind+" event")
+	}
+}
@@ -0,0 +1,52 @@
+package testshell
+
+import (
+	"io"
+	"sync"
+)
+
+// Read is one scripted result from a Reader: Data is returned first,
+// then Err alongside it.
+type Read struct {
+	Data string
+	Err  error
+}
+
+// Reader stands in for a PTY master when a test needs reads to fail in
+// particular ways, such as EINTR or EIO, that a real PTY won't produce
+// on demand. It replays its script one Read per call, then returns
+// io.EOF.
+type Reader struct {
+	mu    sync.Mutex
+	reads []Read
+}
+
+// NewReader returns a Reader that replays reads in order.
+func NewReader(reads ...Read) *Reader {
+	return &Reader{reads: reads}
+}
+
+func (r *Reader) Read(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.reads) == 0 {
+		return 0, io.EOF
+	}
+	next := &r.reads[0]
+	n := copy(p, next.Data)
+	next.Data = next.Data[n:]
+	if next.Data != "" {
+		// the rest of the data comes on the next call, error after it
+		return n, nil
+	}
+	err := next.Err
+	r.reads = r.reads[1:]
+	return n, err
+}
+
+// Remaining reports how many scripted reads have not been consumed.
+func (r *Reader) Remaining() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.reads)
+}
@@ -0,0 +1,11 @@
+package testshell
+
+import "syscall"
+
+// tcgetattr's and tcsetattr's ioctls, and the type of Termios.Lflag.
+const (
+	ioctlGetTermios = syscall.TCGETS
+	ioctlSetTermios = syscall.TCSETS
+)
+
+type lflag = uint32
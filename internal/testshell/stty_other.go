@@ -0,0 +1,14 @@
+//go:build !linux
+
+package testshell
+
+import "syscall"
+
+// tcgetattr's and tcsetattr's ioctls on the BSDs and macOS, and the type
+// of Termios.Lflag.
+const (
+	ioctlGetTermios = syscall.TIOCGETA
+	ioctlSetTermios = syscall.TIOCSETA
+)
+
+type lflag = uint64
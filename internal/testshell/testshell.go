@@ -0,0 +1,287 @@
+// Package testshell is a scripted stand-in for an interactive shell, for
+// end-to-end tests that need realistic PTY behavior without zsh.
+//
+// The fake shell runs inside the test binary itself: a test package calls
+// MaybeRun from TestMain, and the server under test starts Command() on
+// its PTY. The shell prints a "$ " prompt and then executes one directive
+// per input line:
+//
+//	echo <text>          print text and a newline
+//	env <name>           print an environment variable and a newline
+//	env -0               print the whole environment, NUL-terminated, as env -0 does
+//	pwd                  print the working directory and a newline
+//	cd <dir>             change the working directory
+//	print <n>            print n bytes of 'x' and a newline
+//	raw <quoted>         write a Go-quoted string as-is, escapes included
+//	mark <name> [arg]    write a goshell marker (see Markers)
+//	sleep <duration>     pause, e.g. "sleep 200ms"
+//	fg <duration>        run a child in the foreground process group
+//	bg <duration>        start a child in a background process group, holding the terminal open
+//	stty <setting>       switch the terminal's echo or icanon, e.g. "stty -echo", or set its EOF character, e.g. "stty eof=1"
+//	wrap <q1> <q2> <d>   write Go-quoted q1, run directive d, then write q2
+//	nohup                ignore SIGHUP from then on
+//	exit <code>          exit with the given status
+//
+// Empty lines just print a new prompt; unknown directives print an error.
+package testshell
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// sentinel marks a test binary invocation as the fake shell.
+const sentinel = "-goshell.testshell"
+
+// Prompt is printed before each directive is read.
+const Prompt = "$ "
+
+// Markers are the escape sequences written by "mark <name>".
+var Markers = map[string]string{
+	"html-start": "\x1b]9001;HTML_START\x07",
+	"html-end":   "\x1b]9001;HTML_END\x07",
+	"prompt":     "\x1b]133;A\x07",
+	"exec":       "\x1b]133;C\x07",
+}
+
+// Command returns the argv that starts the fake shell: the running test
+// binary, which must call MaybeRun from TestMain.
+func Command() []string {
+	exe, err := os.Executable()
+	if err != nil {
+		exe = os.Args[0]
+	}
+	return []string{exe, sentinel}
+}
+
+// MaybeRun turns the process into the fake shell (or one of its
+// foreground children) when started through Command, and never returns
+// in that case. Call it first thing in TestMain.
+func MaybeRun() {
+	if len(os.Args) < 2 || os.Args[1] != sentinel {
+		return
+	}
+	if len(os.Args) == 4 && os.Args[2] == "child" {
+		d, _ := time.ParseDuration(os.Args[3])
+		time.Sleep(d)
+		os.Exit(0)
+	}
+	os.Exit(run())
+}
+
+func run() int {
+	// Taking the terminal back from a foreground child happens from
+	// what is briefly a background process group.
+	signal.Ignore(syscall.SIGTTOU)
+
+	in := bufio.NewReader(os.Stdin)
+	for {
+		fmt.Print(Prompt)
+		line, err := in.ReadString('\n')
+		if err != nil {
+			return 0
+		}
+		if code, exit := directive(strings.TrimRight(line, "\r\n")); exit {
+			return code
+		}
+	}
+}
+
+// directive executes one input line, reporting whether the shell should
+// exit and with which code.
+func directive(line string) (code int, exit bool) {
+	name, arg, _ := strings.Cut(strings.TrimSpace(line), " ")
+	switch name {
+	case "":
+	case "echo":
+		fmt.Println(arg)
+	case "env":
+		if arg == "-0" {
+			for _, kv := range os.Environ() {
+				fmt.Print(kv + "\x00")
+			}
+			break
+		}
+		fmt.Println(os.Getenv(arg))
+	case "pwd":
+		dir, err := os.Getwd()
+		if err != nil {
+			return fail(line, err)
+		}
+		fmt.Println(dir)
+	case "cd":
+		if err := os.Chdir(arg); err != nil {
+			return fail(line, err)
+		}
+	case "print":
+		n, err := strconv.Atoi(arg)
+		if err != nil {
+			return fail(line, err)
+		}
+		fmt.Println(strings.Repeat("x", n))
+	case "raw":
+		s, err := strconv.Unquote(arg)
+		if err != nil {
+			return fail(line, err)
+		}
+		fmt.Print(s)
+	case "mark":
+		return mark(line, arg)
+	case "wrap":
+		return wrap(line, arg)
+	case "sleep":
+		d, err := time.ParseDuration(arg)
+		if err != nil {
+			return fail(line, err)
+		}
+		time.Sleep(d)
+	case "fg":
+		if err := foreground(arg); err != nil {
+			return fail(line, err)
+		}
+	case "bg":
+		if err := background(arg); err != nil {
+			return fail(line, err)
+		}
+	case "stty":
+		if err := stty(arg); err != nil {
+			return fail(line, err)
+		}
+	case "nohup":
+		signal.Ignore(syscall.SIGHUP)
+	case "exit":
+		code, err := strconv.Atoi(arg)
+		if err != nil {
+			return fail(line, err)
+		}
+		return code, true
+	default:
+		return fail(line, fmt.Errorf("unknown directive %q", name))
+	}
+	return 0, false
+}
+
+func mark(line, arg string) (int, bool) {
+	name, param, _ := strings.Cut(arg, " ")
+	switch name {
+	case "command":
+		fmt.Printf("\x1b]9001;CMD;%s\x07", base64.StdEncoding.EncodeToString([]byte(param)))
+	case "finished":
+		fmt.Printf("\x1b]133;D;%s\x07", param)
+	default:
+		seq, ok := Markers[name]
+		if !ok {
+			return fail(line, fmt.Errorf("unknown marker %q", name))
+		}
+		fmt.Print(seq)
+	}
+	return 0, false
+}
+
+// Wrap returns the wrap directive that runs directive between before and
+// after.
+func Wrap(before, after, directive string) string {
+	return "wrap " + strconv.Quote(before) + " " + strconv.Quote(after) + " " + directive
+}
+
+func wrap(line, arg string) (int, bool) {
+	var parts [2]string
+	for i := range parts {
+		q, err := strconv.QuotedPrefix(arg)
+		if err != nil {
+			return fail(line, err)
+		}
+		parts[i], _ = strconv.Unquote(q)
+		arg = strings.TrimPrefix(arg[len(q):], " ")
+	}
+	fmt.Print(parts[0])
+	code, exit := directive(arg)
+	fmt.Print(parts[1])
+	return code, exit
+}
+
+func fail(line string, err error) (int, bool) {
+	fmt.Fprintf(os.Stderr, "testshell: %s: %v\n", line, err)
+	return 0, false
+}
+
+// stty turns one local mode flag of the terminal on ("echo") or off
+// ("-echo"), or sets its EOF character to a byte value ("eof=1").
+func stty(setting string) error {
+	name, on := strings.TrimPrefix(setting, "-"), !strings.HasPrefix(setting, "-")
+	var flag uint64
+	var eof byte
+	switch {
+	case name == "echo":
+		flag = syscall.ECHO
+	case name == "icanon":
+		flag = syscall.ICANON
+	case strings.HasPrefix(setting, "eof="):
+		n, err := strconv.ParseUint(strings.TrimPrefix(setting, "eof="), 0, 8)
+		if err != nil {
+			return err
+		}
+		eof = byte(n)
+	default:
+		return fmt.Errorf("unsupported setting %q", setting)
+	}
+
+	var t syscall.Termios
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, os.Stdin.Fd(), ioctlGetTermios, uintptr(unsafe.Pointer(&t))); errno != 0 {
+		return errno
+	}
+	switch {
+	case flag == 0:
+		t.Cc[syscall.VEOF] = eof
+	case on:
+		t.Lflag |= lflag(flag)
+	default:
+		t.Lflag &^= lflag(flag)
+	}
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, os.Stdin.Fd(), ioctlSetTermios, uintptr(unsafe.Pointer(&t))); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// background starts a child in a process group of its own and leaves
+// it running for d, with the terminal as its stdio, after the shell has
+// exited if need be.
+func background(d string) error {
+	if _, err := time.ParseDuration(d); err != nil {
+		return err
+	}
+	cmd := exec.Command(Command()[0], sentinel, "child", d)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	return cmd.Start()
+}
+
+// foreground runs a child that sleeps for d in its own process group,
+// hands it the terminal the way a job-control shell does, and takes the
+// terminal back once the child exits.
+func foreground(d string) error {
+	if _, err := time.ParseDuration(d); err != nil {
+		return err
+	}
+	cmd := exec.Command(Command()[0], sentinel, "child", d)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true, Foreground: true, Ctty: 0}
+	runErr := cmd.Run()
+
+	pgrp := syscall.Getpgrp()
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, os.Stdin.Fd(), syscall.TIOCSPGRP, uintptr(unsafe.Pointer(&pgrp)))
+	if errno != 0 {
+		return errno
+	}
+	return runErr
+}
@@ -0,0 +1,151 @@
+package vt
+
+import (
+	"fmt"
+	"html"
+	"strings"
+)
+
+// Default colors for HTML, xterm's.
+const (
+	DefaultForeground = "#e5e5e5"
+	DefaultBackground = "#000000"
+)
+
+// palette is xterm's first 16 colors.
+var palette = [16]string{
+	"#000000", "#cd0000", "#00cd00", "#cdcd00", "#0000ee", "#cd00cd", "#00cdcd", "#e5e5e5",
+	"#7f7f7f", "#ff0000", "#00ff00", "#ffff00", "#5c5cff", "#ff00ff", "#00ffff", "#ffffff",
+}
+
+// scrollback is the history and the main screen, without the screen's
+// trailing blank lines. The alternate screen isn't part of it.
+func (t *Terminal) scrollback() [][]Cell {
+	lines := make([][]Cell, 0, len(t.history)+t.rows)
+	lines = append(lines, t.history...)
+	lines = append(lines, t.main...)
+	for len(lines) > 0 && trimmedLen(lines[len(lines)-1]) == 0 {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// Text returns the history and the main screen as plain text, a line
+// per row with trailing blanks trimmed: what the output left on the
+// screen, with colors, links and overwritten text gone.
+func (t *Terminal) Text() string {
+	var b strings.Builder
+	for _, line := range t.scrollback() {
+		b.WriteString(lineText(line))
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+// HTML returns the history and the main screen as a <pre> element, with
+// colors and attributes as inline styles and http and https links as
+// anchors.
+func (t *Terminal) HTML() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, `<pre style="color:%s;background:%s">`, DefaultForeground, DefaultBackground)
+	for _, line := range t.scrollback() {
+		line = line[:trimmedLen(line)]
+		for i := 0; i < len(line); {
+			j := i + 1
+			for j < len(line) && line[j].pen == line[i].pen {
+				j++
+			}
+			t.writeHTMLRun(&b, line[i].pen, line[i:j])
+			i = j
+		}
+		b.WriteByte('\n')
+	}
+	b.WriteString(`</pre>`)
+	return b.String()
+}
+
+func (t *Terminal) writeHTMLRun(b *strings.Builder, p pen, cells []Cell) {
+	text := html.EscapeString(cellsText(cells))
+	href := ""
+	if p.link > 0 {
+		if uri := t.links[p.link-1]; strings.HasPrefix(uri, "http://") || strings.HasPrefix(uri, "https://") {
+			href = uri
+		}
+	}
+	style := cssStyle(p)
+	if href != "" {
+		fmt.Fprintf(b, `<a href="%s">`, html.EscapeString(href))
+	}
+	if style != "" {
+		fmt.Fprintf(b, `<span style="%s">%s</span>`, style, text)
+	} else {
+		b.WriteString(text)
+	}
+	if href != "" {
+		b.WriteString(`</a>`)
+	}
+}
+
+// cssStyle is the inline style for p; "" for the default pen.
+func cssStyle(p pen) string {
+	fgCSS, bgCSS := cssColor(p.fg), cssColor(p.bg)
+	if p.attrs&attrInverse != 0 {
+		fgCSS, bgCSS = bgCSS, fgCSS
+		if fgCSS == "" {
+			fgCSS = DefaultBackground
+		}
+		if bgCSS == "" {
+			bgCSS = DefaultForeground
+		}
+	}
+	var rules []string
+	if fgCSS != "" {
+		rules = append(rules, "color:"+fgCSS)
+	}
+	if bgCSS != "" {
+		rules = append(rules, "background:"+bgCSS)
+	}
+	if p.attrs&attrBold != 0 {
+		rules = append(rules, "font-weight:bold")
+	}
+	if p.attrs&attrFaint != 0 {
+		rules = append(rules, "opacity:0.6")
+	}
+	if p.attrs&attrItalic != 0 {
+		rules = append(rules, "font-style:italic")
+	}
+	var decorations []string
+	if p.attrs&attrUnderline != 0 {
+		decorations = append(decorations, "underline")
+	}
+	if p.attrs&attrStrike != 0 {
+		decorations = append(decorations, "line-through")
+	}
+	if len(decorations) > 0 {
+		rules = append(rules, "text-decoration:"+strings.Join(decorations, " "))
+	}
+	if p.attrs&attrHidden != 0 {
+		rules = append(rules, "visibility:hidden")
+	}
+	return strings.Join(rules, ";")
+}
+
+// cssColor is c as a CSS color; "" for the default.
+func cssColor(c Color) string {
+	switch {
+	case c == 0:
+		return ""
+	case c.isRGB():
+		r, g, b := c.rgb()
+		return fmt.Sprintf("#%02x%02x%02x", r, g, b)
+	case c.index() < 16:
+		return palette[c.index()]
+	case c.index() < 232:
+		// the 6x6x6 cube
+		levels := [6]int{0, 95, 135, 175, 215, 255}
+		i := c.index() - 16
+		return fmt.Sprintf("#%02x%02x%02x", levels[i/36], levels[i/6%6], levels[i%6])
+	}
+	gray := 8 + 10*(c.index()-232)
+	return fmt.Sprintf("#%02x%02x%02x", gray, gray, gray)
+}
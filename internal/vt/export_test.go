@@ -0,0 +1,51 @@
+package vt
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestText(t *testing.T) {
+	tests := []struct {
+		name, in, want string
+	}{
+		{"colors", "\x1b[1;31merror:\x1b[0m bad\r\n", "error: bad\n"},
+		{"cursor movement", "progress 10%\rprogress 99%\x1b[3D100%\r\n", "progress 100%\n"},
+		{"erase and rewrite", "abc\x1b[2K\rxyz\r\n", "xyz\n"},
+		{"widget link", "\x1b]8;;htmlwidget:4\x07\x1b[34;4mView HTML Output #4\x1b[0m\x1b]8;;\x07\r\n$ ", "View HTML Output #4\n$\n"},
+		{"history", "1\r\n2\r\n3\r\n4\r\n", "1\n2\n3\n4\n"},
+	}
+	for _, tt := range tests {
+		term := feed(New(3, 20, 0), tt.in)
+		if got := term.Text(); got != tt.want {
+			t.Errorf("%s: Text() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	// The alternate screen isn't scrollback
+	term := feed(New(3, 20, 0), "$ vim\r\n\x1b[?1049h\x1b[Hbuffer")
+	if got := term.Text(); got != "$ vim\n" {
+		t.Errorf("with the alternate screen showing, Text() = %q", got)
+	}
+}
+
+func TestHTML(t *testing.T) {
+	term := feed(New(3, 40, 0), "\x1b[1;31mred\x1b[0m <plain> \x1b[38;5;196;48;2;0;0;128mcube\x1b[0m\r\n"+
+		"\x1b]8;;https://example.com/?a=1&b=2\x1b\\site\x1b]8;;\x1b\\ \x1b]8;;htmlwidget:2\x07w2\x1b]8;;\x07 \x1b[7minv\x1b[0m")
+	got := term.HTML()
+	for _, want := range []string{
+		`<pre style="color:#e5e5e5;background:#000000">`,
+		`<span style="color:#cd0000;font-weight:bold">red</span> &lt;plain&gt; `,
+		`<span style="color:#ff0000;background:#000080">cube</span>`,
+		`<a href="https://example.com/?a=1&amp;b=2">site</a>`,
+		` w2 `,
+		`<span style="color:#000000;background:#e5e5e5">inv</span>`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("HTML() missing %s\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, "htmlwidget") {
+		t.Errorf("HTML() links a widget: %s", got)
+	}
+}
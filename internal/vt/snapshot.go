@@ -0,0 +1,198 @@
+package vt
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"unicode/utf8"
+)
+
+// Snapshot returns output that brings a terminal of the same size to
+// t's state: it resets the terminal, prints the history and the main
+// screen, switches to the alternate screen and draws it if that is
+// showing, and then restores the scroll region, cursor, modes, character
+// sets and pen. It is usually much shorter than the output that got t
+// there.
+func (t *Terminal) Snapshot() []byte {
+	var b bytes.Buffer
+	b.WriteString("\x1bc")
+	w := snapshotWriter{b: &b, links: t.links}
+
+	for _, line := range t.history {
+		w.line(line)
+		w.endLine()
+		b.WriteString("\r\n")
+	}
+	for i, line := range t.main {
+		if i == t.cur.row && !t.altActive {
+			// Up to the cursor, so a prompt keeps its trailing space
+			w.cells(line[:max(trimmedLen(line), t.cur.col)])
+		} else {
+			w.line(line)
+		}
+		w.endLine()
+		if i < len(t.main)-1 {
+			b.WriteString("\r\n")
+		}
+	}
+
+	// The cursor DECSC saved, which with ?1049 is the main screen's
+	if t.saved != (cursor{}) || t.altActive && t.altMode == 1049 {
+		fmt.Fprintf(&b, "\x1b[%d;%dH", t.saved.row+1, t.saved.col+1)
+		w.setPen(t.saved.pen)
+		b.WriteString("\x1b7")
+		w.setPen(pen{})
+	}
+	if t.altActive {
+		fmt.Fprintf(&b, "\x1b[?%dh", t.altMode)
+		for i, line := range t.alt {
+			if trimmedLen(line) == 0 {
+				continue
+			}
+			fmt.Fprintf(&b, "\x1b[%d;1H", i+1)
+			w.line(line)
+			w.endLine()
+		}
+	}
+
+	if t.top != 0 || t.bot != t.rows-1 {
+		fmt.Fprintf(&b, "\x1b[%d;%dr", t.top+1, t.bot+1)
+	}
+	if t.cur.origin {
+		b.WriteString("\x1b[?6h")
+	}
+	row, col := t.cur.row, t.cur.col
+	if t.cur.origin {
+		row -= t.top
+	}
+	if t.cur.wrapNext {
+		// Print the last cell again to leave the cursor waiting to wrap.
+		line := t.screen()[t.cur.row]
+		if line[col].R == wideTail && col > 0 {
+			col--
+		}
+		fmt.Fprintf(&b, "\x1b[%d;%dH", row+1, col+1)
+		w.cells(line[col:])
+		w.endLine()
+	} else {
+		fmt.Fprintf(&b, "\x1b[%d;%dH", row+1, col+1)
+	}
+
+	if t.insert {
+		b.WriteString("\x1b[4h")
+	}
+	if t.keypadApp {
+		b.WriteString("\x1b=")
+	}
+	modes := make([]int, 0, len(t.modes))
+	for m, on := range t.modes {
+		if on != defaultModes[m] {
+			modes = append(modes, m)
+		}
+	}
+	sort.Ints(modes)
+	for _, m := range modes {
+		if t.modes[m] {
+			fmt.Fprintf(&b, "\x1b[?%dh", m)
+		} else {
+			fmt.Fprintf(&b, "\x1b[?%dl", m)
+		}
+	}
+	if t.cur.charsets[0] {
+		b.WriteString("\x1b(0")
+	}
+	if t.cur.charsets[1] {
+		b.WriteString("\x1b)0")
+	}
+	if t.cur.shift == 1 {
+		b.WriteString("\x0e")
+	}
+	w.setPen(t.cur.pen)
+	return b.Bytes()
+}
+
+// trimmedLen is the length of line without its trailing blank cells.
+func trimmedLen(line []Cell) int {
+	n := len(line)
+	for n > 0 && line[n-1].blank() {
+		n--
+	}
+	return n
+}
+
+// snapshotWriter renders cells, writing SGR and OSC 8 sequences only
+// where the pen changes.
+type snapshotWriter struct {
+	b     *bytes.Buffer
+	links []string
+	pen   pen
+}
+
+// line writes line without its trailing blanks.
+func (w *snapshotWriter) line(line []Cell) {
+	w.cells(line[:trimmedLen(line)])
+}
+
+func (w *snapshotWriter) cells(cells []Cell) {
+	var buf [utf8.UTFMax]byte
+	for _, c := range cells {
+		if c.R == wideTail {
+			continue
+		}
+		w.setPen(c.pen)
+		r := c.R
+		if r == 0 {
+			r = ' '
+		}
+		w.b.Write(buf[:utf8.EncodeRune(buf[:], r)])
+	}
+}
+
+// endLine goes back to the default pen, so that line feeds and erases
+// don't paint the background.
+func (w *snapshotWriter) endLine() { w.setPen(pen{}) }
+
+func (w *snapshotWriter) setPen(p pen) {
+	if p.link != w.pen.link {
+		uri := ""
+		if p.link > 0 {
+			uri = w.links[p.link-1]
+		}
+		fmt.Fprintf(w.b, "\x1b]8;;%s\x1b\\", uri)
+	}
+	if p.fg != w.pen.fg || p.bg != w.pen.bg || p.attrs != w.pen.attrs {
+		w.b.WriteString(sgr(p))
+	}
+	w.pen = p
+}
+
+// sgrAttrs are the SGR parameters for each attribute flag, in bit order.
+var sgrAttrs = [...]int{1, 2, 3, 4, 5, 7, 8, 9}
+
+// sgr is the SGR sequence that sets p's colors and attributes from any pen.
+func sgr(p pen) string {
+	b := []byte("\x1b[0")
+	for i, n := range sgrAttrs {
+		if p.attrs&(1<<i) != 0 {
+			b = fmt.Appendf(b, ";%d", n)
+		}
+	}
+	b = appendColor(b, p.fg, 30, 90, 38)
+	b = appendColor(b, p.bg, 40, 100, 48)
+	return string(append(b, 'm'))
+}
+
+func appendColor(b []byte, c Color, base, bright, extended int) []byte {
+	switch {
+	case c == 0:
+		return b
+	case c.isRGB():
+		r, g, bl := c.rgb()
+		return fmt.Appendf(b, ";%d;2;%d;%d;%d", extended, r, g, bl)
+	case c.index() < 8:
+		return fmt.Appendf(b, ";%d", base+c.index())
+	case c.index() < 16:
+		return fmt.Appendf(b, ";%d", bright+c.index()-8)
+	}
+	return fmt.Appendf(b, ";%d;5;%d", extended, c.index())
+}
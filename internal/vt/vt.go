@@ -0,0 +1,1035 @@
+// Package vt models the screen of an xterm-compatible terminal: the cells
+// on it with their colors and hyperlinks, the cursor, the alternate
+// screen and the lines scrolled off the top. It is fed a program's output
+// and can produce a snapshot, output that brings a fresh terminal of the
+// same size to the same state, for replaying a session to a client that
+// joins partway through, and render its lines as plain text or HTML.
+//
+// It covers what shells, full-screen programs and colored output use:
+// cursor movement, erasing, insertion and deletion, scroll regions, SGR
+// attributes in 16, 256 and 24-bit color, the alternate screen, DEC line
+// drawing, OSC 8 hyperlinks and the modes that change what a terminal
+// sends (cursor keys, mouse reporting, bracketed paste). Combining marks
+// are dropped, and other OSC, DCS and similar strings are ignored.
+package vt
+
+import (
+	"sort"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Color is a cell's foreground or background: 0 for the default, an
+// indexed color as its index plus one, or a 24-bit color with colorRGB set.
+type Color uint32
+
+const colorRGB Color = 1 << 24
+
+func indexed(i int) Color          { return Color(i + 1) }
+func rgb(r, g, b int) Color        { return colorRGB | Color(r&0xff)<<16 | Color(g&0xff)<<8 | Color(b&0xff) }
+func (c Color) isRGB() bool        { return c&colorRGB != 0 }
+func (c Color) index() int         { return int(c) - 1 }
+func (c Color) rgb() (r, g, b int) { return int(c>>16) & 0xff, int(c>>8) & 0xff, int(c) & 0xff }
+
+// Attribute flags.
+const (
+	attrBold uint8 = 1 << iota
+	attrFaint
+	attrItalic
+	attrUnderline
+	attrBlink
+	attrInverse
+	attrHidden
+	attrStrike
+)
+
+// pen is how a cell is drawn.
+type pen struct {
+	fg, bg Color
+	attrs  uint8
+	link   int32 // index into Terminal.links plus one; 0 for none
+}
+
+// Cell is one character position on the screen.
+type Cell struct {
+	R   rune // 0 for never written, wideTail for the right half of a wide rune
+	pen pen
+}
+
+// wideTail marks the cell covered by the right half of a wide rune.
+const wideTail rune = -1
+
+func (c Cell) blank() bool {
+	return (c.R == 0 || c.R == ' ') && c.pen.bg == 0 && c.pen.attrs&(attrUnderline|attrInverse|attrStrike) == 0 && c.pen.link == 0
+}
+
+// cursor is the cursor and the state DECSC saves with it.
+type cursor struct {
+	row, col int
+	pen      pen
+	wrapNext bool // the last column was written; the next rune wraps
+	origin   bool // DECOM: rows count from the scroll region's top
+	charsets [2]bool
+	shift    int // 0 for G0, 1 for G1
+}
+
+// parser states.
+const (
+	stGround = iota
+	stEscape
+	stEscInter
+	stCharset
+	stCSI
+	stOSC
+	stOSCEsc
+	stString
+	stStringEsc
+)
+
+// maxOSC is the longest OSC string kept; longer ones are ignored.
+const maxOSC = 4096
+
+// Terminal is a terminal's screen and state. Write feeds it output;
+// Snapshot renders it back. A Terminal is not safe for concurrent use.
+type Terminal struct {
+	rows, cols int
+	main, alt  [][]Cell
+	altActive  bool
+	altMode    int      // the mode that switched to the alternate screen: 47, 1047 or 1049
+	history    [][]Cell // lines scrolled off the top of the main screen, oldest first
+	maxHistory int      // 0 for no limit
+
+	cur       cursor
+	saved     cursor // DECSC, and the main screen's cursor while ?1049 is set
+	top, bot  int    // scroll region, inclusive
+	modes     map[int]bool
+	insert    bool
+	keypadApp bool
+	lastRune  rune
+
+	links     []string
+	linkIndex map[string]int32
+
+	// parser
+	state     int
+	utf       []byte
+	params    []int
+	param     int
+	hasParam  bool
+	private   byte
+	inter     []byte
+	charsetG  int
+	osc       []byte
+	oscTooBig bool
+}
+
+// defaultModes are the DEC private modes on in a fresh terminal.
+var defaultModes = map[int]bool{7: true, 25: true}
+
+// New returns a blank terminal of rows by cols that keeps up to
+// maxHistory lines scrolled off the top (0 for no limit).
+func New(rows, cols, maxHistory int) *Terminal {
+	rows, cols = max(rows, 1), max(cols, 1)
+	t := &Terminal{rows: rows, cols: cols, maxHistory: maxHistory, linkIndex: make(map[string]int32)}
+	t.reset()
+	return t
+}
+
+// reset is RIS: everything but the size goes back to how it starts.
+func (t *Terminal) reset() {
+	t.history = nil
+	t.main = blankLines(t.rows, t.cols)
+	t.alt = nil
+	t.altActive = false
+	t.cur = cursor{}
+	t.saved = cursor{}
+	t.top, t.bot = 0, t.rows-1
+	t.modes = make(map[int]bool)
+	for m, on := range defaultModes {
+		t.modes[m] = on
+	}
+	t.insert = false
+	t.keypadApp = false
+	t.state = stGround
+}
+
+func blankLines(n, cols int) [][]Cell {
+	lines := make([][]Cell, n)
+	for i := range lines {
+		lines[i] = make([]Cell, cols)
+	}
+	return lines
+}
+
+// Size returns the terminal's rows and columns.
+func (t *Terminal) Size() (rows, cols int) { return t.rows, t.cols }
+
+// Cursor returns the cursor's row and column, counting from zero.
+func (t *Terminal) Cursor() (row, col int) { return t.cur.row, t.cur.col }
+
+// AltScreen reports whether the alternate screen is showing.
+func (t *Terminal) AltScreen() bool { return t.altActive }
+
+// Mode reports whether DEC private mode m, such as 2004 for bracketed
+// paste, is set.
+func (t *Terminal) Mode(m int) bool { return t.modes[m] }
+
+// HistoryLen is how many lines have scrolled off the main screen.
+func (t *Terminal) HistoryLen() int { return len(t.history) }
+
+// screen is the screen showing.
+func (t *Terminal) screen() [][]Cell {
+	if t.altActive {
+		return t.alt
+	}
+	return t.main
+}
+
+// Line returns row's text, history rows first: row -HistoryLen() is the
+// oldest line kept and row 0 the top of the screen showing. Trailing
+// blanks are trimmed.
+func (t *Terminal) Line(row int) string {
+	var line []Cell
+	if row < 0 {
+		line = t.history[len(t.history)+row]
+	} else {
+		line = t.screen()[row]
+	}
+	return lineText(line)
+}
+
+// lineText is line's text without its trailing blanks.
+func lineText(line []Cell) string {
+	return cellsText(line[:trimmedLen(line)])
+}
+
+// cellsText is the text of cells, never-written cells as spaces.
+func cellsText(cells []Cell) string {
+	var b []rune
+	for _, c := range cells {
+		switch c.R {
+		case wideTail:
+		case 0:
+			b = append(b, ' ')
+		default:
+			b = append(b, c.R)
+		}
+	}
+	return string(b)
+}
+
+// Resize changes the screen to rows by cols. Lines are cut or padded on
+// the right rather than rewrapped. When the screen gets shorter, lines
+// above the cursor go into the history first, so the cursor's line stays
+// on screen.
+func (t *Terminal) Resize(rows, cols int) {
+	rows, cols = max(rows, 1), max(cols, 1)
+	if rows == t.rows && cols == t.cols {
+		return
+	}
+	if drop := t.cur.row - (rows - 1); drop > 0 {
+		if !t.altActive {
+			t.pushHistory(t.main[:drop])
+		}
+		t.main = t.main[drop:]
+		if t.alt != nil {
+			t.alt = t.alt[drop:]
+		}
+		t.cur.row -= drop
+		t.saved.row = max(t.saved.row-drop, 0)
+	}
+	t.main = resizeLines(t.main, rows, cols)
+	if t.alt != nil {
+		t.alt = resizeLines(t.alt, rows, cols)
+	}
+	t.rows, t.cols = rows, cols
+	t.top, t.bot = 0, rows-1
+	t.cur.row, t.cur.col = min(t.cur.row, rows-1), min(t.cur.col, cols-1)
+	t.saved.row, t.saved.col = min(t.saved.row, rows-1), min(t.saved.col, cols-1)
+	t.cur.wrapNext = false
+}
+
+func resizeLines(lines [][]Cell, rows, cols int) [][]Cell {
+	if len(lines) > rows {
+		lines = lines[:rows]
+	}
+	for i, line := range lines {
+		if len(line) > cols {
+			line = line[:cols]
+			if line[cols-1].R != wideTail && isWide(line[cols-1].R) {
+				line[cols-1] = Cell{}
+			}
+		} else if len(line) < cols {
+			line = append(line, make([]Cell, cols-len(line))...)
+		}
+		lines[i] = line
+	}
+	for len(lines) < rows {
+		lines = append(lines, make([]Cell, cols))
+	}
+	return lines
+}
+
+// pushHistory appends lines scrolled off the main screen to the history.
+func (t *Terminal) pushHistory(lines [][]Cell) {
+	for _, line := range lines {
+		kept := make([]Cell, trimmedLen(line))
+		copy(kept, line)
+		t.history = append(t.history, kept)
+	}
+	if t.maxHistory > 0 && len(t.history) > t.maxHistory {
+		n := len(t.history) - t.maxHistory
+		copy(t.history, t.history[n:])
+		clear(t.history[len(t.history)-n:])
+		t.history = t.history[:len(t.history)-n]
+	}
+}
+
+// Write feeds output to the terminal. It never fails.
+func (t *Terminal) Write(p []byte) (int, error) {
+	for _, c := range p {
+		t.step(c)
+	}
+	return len(p), nil
+}
+
+func (t *Terminal) step(c byte) {
+	switch t.state {
+	case stGround:
+		t.ground(c)
+	case stEscape:
+		t.escape(c)
+	case stEscInter:
+		if c >= 0x30 && c <= 0x7e {
+			t.state = stGround
+		} else if c == 0x1b {
+			t.state = stEscape
+		}
+	case stCharset:
+		t.cur.charsets[t.charsetG] = c == '0'
+		t.state = stGround
+	case stCSI:
+		t.csiByte(c)
+	case stOSC:
+		switch c {
+		case 0x07:
+			t.endOSC()
+		case 0x1b:
+			t.state = stOSCEsc
+		default:
+			if len(t.osc) < maxOSC {
+				t.osc = append(t.osc, c)
+			} else {
+				t.oscTooBig = true
+			}
+		}
+	case stOSCEsc:
+		t.endOSC()
+		if c != '\\' {
+			t.escape(c)
+		}
+	case stString:
+		switch c {
+		case 0x07:
+			t.state = stGround
+		case 0x1b:
+			t.state = stStringEsc
+		}
+	case stStringEsc:
+		t.state = stGround
+		if c != '\\' {
+			t.escape(c)
+		}
+	}
+}
+
+func (t *Terminal) ground(c byte) {
+	if len(t.utf) > 0 {
+		if c&0xc0 == 0x80 {
+			t.utf = append(t.utf, c)
+			if utf8.FullRune(t.utf) {
+				r, _ := utf8.DecodeRune(t.utf)
+				t.utf = t.utf[:0]
+				t.print(r)
+			}
+			return
+		}
+		// a sequence cut short
+		t.utf = t.utf[:0]
+		t.print(utf8.RuneError)
+	}
+	switch {
+	case c == 0x1b:
+		t.state = stEscape
+	case c < 0x20 || c == 0x7f:
+		t.control(c)
+	case c < 0x80:
+		t.print(rune(c))
+	case c >= 0xc0 && c < 0xf8:
+		t.utf = append(t.utf, c)
+	default:
+		t.print(utf8.RuneError)
+	}
+}
+
+// control executes a C0 control.
+func (t *Terminal) control(c byte) {
+	switch c {
+	case '\b':
+		if t.cur.col > 0 {
+			t.cur.col--
+		}
+		t.cur.wrapNext = false
+	case '\t':
+		t.tab(1)
+	case '\n', '\v', '\f':
+		t.lineFeed()
+	case '\r':
+		t.cur.col = 0
+		t.cur.wrapNext = false
+	case 0x0e:
+		t.cur.shift = 1
+	case 0x0f:
+		t.cur.shift = 0
+	}
+}
+
+func (t *Terminal) escape(c byte) {
+	t.state = stGround
+	switch c {
+	case '[':
+		t.state = stCSI
+		t.params, t.param, t.hasParam, t.private, t.inter = t.params[:0], 0, false, 0, t.inter[:0]
+	case ']':
+		t.state = stOSC
+		t.osc, t.oscTooBig = t.osc[:0], false
+	case 'P', 'X', '^', '_':
+		t.state = stString
+	case '(', ')', '*', '+':
+		t.state = stCharset
+		t.charsetG = min(int(c-'('), 1)
+	case '7':
+		t.saveCursor()
+	case '8':
+		t.restoreCursor()
+	case 'D':
+		t.lineFeed()
+	case 'E':
+		t.cur.col = 0
+		t.lineFeed()
+	case 'M':
+		t.reverseIndex()
+	case 'c':
+		t.reset()
+	case '=':
+		t.keypadApp = true
+	case '>':
+		t.keypadApp = false
+	case 0x1b:
+		t.state = stEscape
+	default:
+		if c >= 0x20 && c <= 0x2f {
+			t.state = stEscInter
+		}
+	}
+}
+
+func (t *Terminal) csiByte(c byte) {
+	switch {
+	case c >= '0' && c <= '9':
+		t.param = min(t.param*10+int(c-'0'), 1<<16)
+		t.hasParam = true
+	case c == ';' || c == ':':
+		t.params = append(t.params, t.paramOr(-1))
+		t.param, t.hasParam = 0, false
+	case c >= '<' && c <= '?':
+		if len(t.params) == 0 && !t.hasParam {
+			t.private = c
+		}
+	case c >= 0x20 && c <= 0x2f:
+		t.inter = append(t.inter, c)
+	case c >= 0x40 && c <= 0x7e:
+		if t.hasParam || len(t.params) > 0 {
+			t.params = append(t.params, t.paramOr(-1))
+		}
+		t.state = stGround
+		t.csi(c)
+	case c == 0x1b:
+		t.state = stEscape
+	case c == 0x18 || c == 0x1a:
+		t.state = stGround
+	case c < 0x20:
+		t.control(c)
+	}
+}
+
+func (t *Terminal) paramOr(def int) int {
+	if t.hasParam {
+		return t.param
+	}
+	return def
+}
+
+// arg returns parameter i, or def if it is missing or zero.
+func (t *Terminal) arg(i, def int) int {
+	if i < len(t.params) && t.params[i] > 0 {
+		return t.params[i]
+	}
+	return def
+}
+
+func (t *Terminal) csi(final byte) {
+	if len(t.inter) > 0 {
+		if string(t.inter) == "!" && final == 'p' {
+			t.softReset()
+		}
+		return
+	}
+	switch t.private {
+	case '?':
+		switch final {
+		case 'h', 'l':
+			for _, m := range t.params {
+				t.setMode(m, final == 'h')
+			}
+		case 'J':
+			t.eraseDisplay(t.arg(0, 0))
+		case 'K':
+			t.eraseLine(t.arg(0, 0))
+		}
+		return
+	case 0:
+	default:
+		return
+	}
+
+	n := t.arg(0, 1)
+	switch final {
+	case '@':
+		t.insertChars(n)
+	case 'A':
+		t.cursorUp(n)
+	case 'B', 'e':
+		t.cursorDown(n)
+	case 'C', 'a':
+		t.moveTo(t.cur.row, t.cur.col+n)
+	case 'D':
+		t.moveTo(t.cur.row, t.cur.col-n)
+	case 'E':
+		t.cursorDown(n)
+		t.cur.col = 0
+	case 'F':
+		t.cursorUp(n)
+		t.cur.col = 0
+	case 'G', '`':
+		t.moveTo(t.cur.row, n-1)
+	case 'H', 'f':
+		t.cursorPosition(t.arg(0, 1)-1, t.arg(1, 1)-1)
+	case 'I':
+		t.tab(n)
+	case 'J':
+		t.eraseDisplay(t.arg(0, 0))
+	case 'K':
+		t.eraseLine(t.arg(0, 0))
+	case 'L':
+		t.insertLines(n)
+	case 'M':
+		t.deleteLines(n)
+	case 'P':
+		t.deleteChars(n)
+	case 'S':
+		t.scrollUp(t.top, t.bot, n)
+	case 'T':
+		t.scrollDown(t.top, t.bot, n)
+	case 'X':
+		t.eraseChars(n)
+	case 'Z':
+		t.backTab(n)
+	case 'b':
+		if t.lastRune != 0 {
+			for i := 0; i < min(n, t.rows*t.cols); i++ {
+				t.print(t.lastRune)
+			}
+		}
+	case 'd':
+		t.cursorPosition(n-1, t.cur.col)
+	case 'h', 'l':
+		for _, m := range t.params {
+			if m == 4 {
+				t.insert = final == 'h'
+			}
+		}
+	case 'm':
+		t.sgr()
+	case 'r':
+		top, bot := t.arg(0, 1)-1, t.arg(1, t.rows)-1
+		if bot >= t.rows {
+			bot = t.rows - 1
+		}
+		if top < bot {
+			t.top, t.bot = top, bot
+			t.cursorPosition(0, 0)
+		}
+	case 's':
+		t.saveCursor()
+	case 'u':
+		t.restoreCursor()
+	}
+}
+
+// setMode sets or resets DEC private mode m.
+func (t *Terminal) setMode(m int, on bool) {
+	switch m {
+	case 47, 1047, 1049:
+		if on == t.altActive {
+			return
+		}
+		if on {
+			if m == 1049 {
+				t.saveCursor()
+			}
+			t.alt = blankLines(t.rows, t.cols)
+			t.altActive, t.altMode = true, m
+		} else {
+			t.altActive = false
+			t.alt = nil
+			if m == 1049 {
+				t.restoreCursor()
+			}
+		}
+	case 1048:
+		if on {
+			t.saveCursor()
+		} else {
+			t.restoreCursor()
+		}
+	case 6:
+		t.cur.origin = on
+		t.cursorPosition(0, 0)
+	default:
+		t.modes[m] = on
+		if m == 7 && !on {
+			t.cur.wrapNext = false
+		}
+	}
+}
+
+func (t *Terminal) softReset() {
+	t.cur.pen = pen{}
+	t.cur.origin = false
+	t.cur.charsets = [2]bool{}
+	t.cur.shift = 0
+	t.top, t.bot = 0, t.rows-1
+	t.insert = false
+	t.keypadApp = false
+	t.modes[7], t.modes[25], t.modes[1] = true, true, false
+}
+
+func (t *Terminal) saveCursor() { t.saved = t.cur }
+
+func (t *Terminal) restoreCursor() {
+	t.cur = t.saved
+	t.cur.row, t.cur.col = min(t.cur.row, t.rows-1), min(t.cur.col, t.cols-1)
+}
+
+func (t *Terminal) endOSC() {
+	t.state = stGround
+	if t.oscTooBig {
+		return
+	}
+	// OSC 8 ; params ; URI
+	s := string(t.osc)
+	if len(s) < 2 || s[:2] != "8;" {
+		return
+	}
+	rest := s[2:]
+	for i := 0; i < len(rest); i++ {
+		if rest[i] == ';' {
+			t.cur.pen.link = t.intern(rest[i+1:])
+			return
+		}
+	}
+}
+
+// intern returns uri's link index; "" is no link.
+func (t *Terminal) intern(uri string) int32 {
+	if uri == "" {
+		return 0
+	}
+	if i, ok := t.linkIndex[uri]; ok {
+		return i
+	}
+	t.links = append(t.links, uri)
+	i := int32(len(t.links))
+	t.linkIndex[uri] = i
+	return i
+}
+
+// decGraphics maps '`' through '~' in the DEC special graphics set.
+var decGraphics = []rune("◆▒␉␌␍␊°±␤␋┘┐┌└┼⎺⎻─⎼⎽├┤┴┬│≤≥π≠£·")
+
+func (t *Terminal) print(r rune) {
+	if t.cur.charsets[t.cur.shift] && r >= '`' && r <= '~' {
+		r = decGraphics[r-'`']
+	}
+	width := runeWidth(r)
+	if width == 0 {
+		return
+	}
+	t.lastRune = r
+	if t.cur.wrapNext {
+		t.cur.col = 0
+		t.lineFeed()
+	}
+	if width == 2 && t.cur.col == t.cols-1 {
+		if !t.modes[7] || t.cols < 2 {
+			return
+		}
+		t.screen()[t.cur.row][t.cur.col] = Cell{pen: t.cur.pen}
+		t.cur.col = 0
+		t.lineFeed()
+	}
+	line := t.screen()[t.cur.row]
+	if t.insert {
+		copy(line[t.cur.col+width:], line[t.cur.col:])
+	}
+	t.clearWide(line, t.cur.col)
+	if width == 2 {
+		t.clearWide(line, t.cur.col+1)
+		line[t.cur.col+1] = Cell{R: wideTail, pen: t.cur.pen}
+	}
+	line[t.cur.col] = Cell{R: r, pen: t.cur.pen}
+	t.cur.col += width
+	if t.cur.col >= t.cols {
+		t.cur.col = t.cols - 1
+		t.cur.wrapNext = t.modes[7]
+	} else {
+		t.cur.wrapNext = false
+	}
+}
+
+// clearWide blanks the other half of a wide rune overlapping col.
+func (t *Terminal) clearWide(line []Cell, col int) {
+	if line[col].R == wideTail && col > 0 {
+		line[col-1] = Cell{pen: line[col-1].pen}
+	} else if col+1 < len(line) && line[col+1].R == wideTail {
+		line[col+1] = Cell{pen: line[col+1].pen}
+	}
+}
+
+func (t *Terminal) lineFeed() {
+	t.cur.wrapNext = false
+	switch {
+	case t.cur.row == t.bot:
+		t.scrollUp(t.top, t.bot, 1)
+	case t.cur.row < t.rows-1:
+		t.cur.row++
+	}
+}
+
+func (t *Terminal) reverseIndex() {
+	t.cur.wrapNext = false
+	switch {
+	case t.cur.row == t.top:
+		t.scrollDown(t.top, t.bot, 1)
+	case t.cur.row > 0:
+		t.cur.row--
+	}
+}
+
+// erased is a blank cell in the current background.
+func (t *Terminal) erased() Cell { return Cell{pen: pen{bg: t.cur.pen.bg}} }
+
+func (t *Terminal) blankLine() []Cell {
+	line := make([]Cell, t.cols)
+	if e := t.erased(); e.pen.bg != 0 {
+		for i := range line {
+			line[i] = e
+		}
+	}
+	return line
+}
+
+// scrollUp moves lines top through bot up n, blank lines coming in at the
+// bottom. Lines leaving the top of the main screen go into the history.
+func (t *Terminal) scrollUp(top, bot, n int) {
+	n = min(n, bot-top+1)
+	screen := t.screen()
+	if top == 0 && !t.altActive {
+		t.pushHistory(screen[:n])
+	}
+	copy(screen[top:], screen[top+n:bot+1])
+	for i := bot - n + 1; i <= bot; i++ {
+		screen[i] = t.blankLine()
+	}
+}
+
+func (t *Terminal) scrollDown(top, bot, n int) {
+	n = min(n, bot-top+1)
+	screen := t.screen()
+	copy(screen[top+n:bot+1], screen[top:])
+	for i := top; i < top+n; i++ {
+		screen[i] = t.blankLine()
+	}
+}
+
+func (t *Terminal) moveTo(row, col int) {
+	t.cur.row = min(max(row, 0), t.rows-1)
+	t.cur.col = min(max(col, 0), t.cols-1)
+	t.cur.wrapNext = false
+}
+
+// cursorPosition is CUP, whose row counts from the scroll region's top in
+// origin mode.
+func (t *Terminal) cursorPosition(row, col int) {
+	if t.cur.origin {
+		t.moveTo(min(row+t.top, t.bot), col)
+		return
+	}
+	t.moveTo(row, col)
+}
+
+func (t *Terminal) cursorUp(n int) {
+	limit := 0
+	if t.cur.row >= t.top {
+		limit = t.top
+	}
+	t.moveTo(max(t.cur.row-n, limit), t.cur.col)
+}
+
+func (t *Terminal) cursorDown(n int) {
+	limit := t.rows - 1
+	if t.cur.row <= t.bot {
+		limit = t.bot
+	}
+	t.moveTo(min(t.cur.row+n, limit), t.cur.col)
+}
+
+func (t *Terminal) tab(n int) {
+	col := t.cur.col
+	for ; n > 0 && col < t.cols-1; n-- {
+		col = min((col/8+1)*8, t.cols-1)
+	}
+	t.cur.col = col
+	t.cur.wrapNext = false
+}
+
+func (t *Terminal) backTab(n int) {
+	col := t.cur.col
+	for ; n > 0 && col > 0; n-- {
+		col = (col - 1) / 8 * 8
+	}
+	t.cur.col = col
+	t.cur.wrapNext = false
+}
+
+func (t *Terminal) eraseCells(line []Cell, from, to int) {
+	e := t.erased()
+	for i := max(from, 0); i < min(to, len(line)); i++ {
+		line[i] = e
+	}
+}
+
+func (t *Terminal) eraseLine(mode int) {
+	line := t.screen()[t.cur.row]
+	switch mode {
+	case 0:
+		t.eraseCells(line, t.cur.col, t.cols)
+	case 1:
+		t.eraseCells(line, 0, t.cur.col+1)
+	case 2:
+		t.eraseCells(line, 0, t.cols)
+	}
+}
+
+func (t *Terminal) eraseDisplay(mode int) {
+	screen := t.screen()
+	switch mode {
+	case 0:
+		t.eraseLine(0)
+		for i := t.cur.row + 1; i < t.rows; i++ {
+			screen[i] = t.blankLine()
+		}
+	case 1:
+		t.eraseLine(1)
+		for i := 0; i < t.cur.row; i++ {
+			screen[i] = t.blankLine()
+		}
+	case 2:
+		for i := range screen {
+			screen[i] = t.blankLine()
+		}
+	case 3:
+		t.history = nil
+	}
+}
+
+func (t *Terminal) eraseChars(n int) {
+	t.eraseCells(t.screen()[t.cur.row], t.cur.col, t.cur.col+n)
+	t.cur.wrapNext = false
+}
+
+func (t *Terminal) insertChars(n int) {
+	line := t.screen()[t.cur.row]
+	n = min(n, t.cols-t.cur.col)
+	copy(line[t.cur.col+n:], line[t.cur.col:])
+	t.eraseCells(line, t.cur.col, t.cur.col+n)
+	t.cur.wrapNext = false
+}
+
+func (t *Terminal) deleteChars(n int) {
+	line := t.screen()[t.cur.row]
+	n = min(n, t.cols-t.cur.col)
+	copy(line[t.cur.col:], line[t.cur.col+n:])
+	t.eraseCells(line, t.cols-n, t.cols)
+	t.cur.wrapNext = false
+}
+
+func (t *Terminal) insertLines(n int) {
+	if t.cur.row < t.top || t.cur.row > t.bot {
+		return
+	}
+	t.scrollDown(t.cur.row, t.bot, n)
+	t.cur.col = 0
+	t.cur.wrapNext = false
+}
+
+func (t *Terminal) deleteLines(n int) {
+	if t.cur.row < t.top || t.cur.row > t.bot {
+		return
+	}
+	// lines deleted inside the screen don't go into the history
+	n = min(n, t.bot-t.cur.row+1)
+	screen := t.screen()
+	copy(screen[t.cur.row:], screen[t.cur.row+n:t.bot+1])
+	for i := t.bot - n + 1; i <= t.bot; i++ {
+		screen[i] = t.blankLine()
+	}
+	t.cur.col = 0
+	t.cur.wrapNext = false
+}
+
+func (t *Terminal) sgr() {
+	p := &t.cur.pen
+	params := t.params
+	if len(params) == 0 {
+		params = []int{0}
+	}
+	for i := 0; i < len(params); i++ {
+		switch n := params[i]; {
+		case n <= 0:
+			p.fg, p.bg, p.attrs = 0, 0, 0
+		case n == 1:
+			p.attrs |= attrBold
+		case n == 2:
+			p.attrs |= attrFaint
+		case n == 3:
+			p.attrs |= attrItalic
+		case n == 4 || n == 21:
+			p.attrs |= attrUnderline
+		case n == 5 || n == 6:
+			p.attrs |= attrBlink
+		case n == 7:
+			p.attrs |= attrInverse
+		case n == 8:
+			p.attrs |= attrHidden
+		case n == 9:
+			p.attrs |= attrStrike
+		case n == 22:
+			p.attrs &^= attrBold | attrFaint
+		case n == 23:
+			p.attrs &^= attrItalic
+		case n == 24:
+			p.attrs &^= attrUnderline
+		case n == 25:
+			p.attrs &^= attrBlink
+		case n == 27:
+			p.attrs &^= attrInverse
+		case n == 28:
+			p.attrs &^= attrHidden
+		case n == 29:
+			p.attrs &^= attrStrike
+		case n >= 30 && n <= 37:
+			p.fg = indexed(n - 30)
+		case n == 38 || n == 48:
+			c, used := extendedColor(params[i+1:])
+			i += used
+			if n == 38 {
+				p.fg = c
+			} else {
+				p.bg = c
+			}
+		case n == 39:
+			p.fg = 0
+		case n >= 40 && n <= 47:
+			p.bg = indexed(n - 40)
+		case n == 49:
+			p.bg = 0
+		case n >= 90 && n <= 97:
+			p.fg = indexed(n - 90 + 8)
+		case n >= 100 && n <= 107:
+			p.bg = indexed(n - 100 + 8)
+		}
+	}
+}
+
+// extendedColor parses the rest of a 38 or 48 SGR, 5;n or 2;r;g;b,
+// returning the color and how many parameters it took.
+func extendedColor(params []int) (Color, int) {
+	if len(params) == 0 {
+		return 0, 0
+	}
+	switch params[0] {
+	case 5:
+		if len(params) >= 2 && params[1] >= 0 && params[1] < 256 {
+			return indexed(params[1]), 2
+		}
+		return 0, min(len(params), 2)
+	case 2:
+		if len(params) >= 4 {
+			return rgb(max(params[1], 0), max(params[2], 0), max(params[3], 0)), 4
+		}
+		return 0, len(params)
+	}
+	return 0, 1
+}
+
+// runeWidth is how many cells r takes: 0 for combining marks and other
+// zero-width runes, 2 for East Asian wide and fullwidth runes and emoji.
+func runeWidth(r rune) int {
+	switch {
+	case r < 0x300:
+		return 1
+	case unicode.In(r, unicode.Mn, unicode.Me, unicode.Cf):
+		return 0
+	case isWide(r):
+		return 2
+	}
+	return 1
+}
+
+// wideRanges are the East Asian wide and fullwidth blocks and the emoji
+// that terminals draw two cells wide.
+var wideRanges = [][2]rune{
+	{0x1100, 0x115f}, {0x231a, 0x231b}, {0x2329, 0x232a}, {0x23e9, 0x23ec},
+	{0x23f0, 0x23f0}, {0x23f3, 0x23f3}, {0x25fd, 0x25fe}, {0x2614, 0x2615},
+	{0x2648, 0x2653}, {0x267f, 0x267f}, {0x2693, 0x2693}, {0x26a1, 0x26a1},
+	{0x26aa, 0x26ab}, {0x26bd, 0x26be}, {0x26c4, 0x26c5}, {0x26ce, 0x26ce},
+	{0x26d4, 0x26d4}, {0x26ea, 0x26ea}, {0x26f2, 0x26f3}, {0x26f5, 0x26f5},
+	{0x26fa, 0x26fa}, {0x26fd, 0x26fd}, {0x2705, 0x2705}, {0x270a, 0x270b},
+	{0x2728, 0x2728}, {0x274c, 0x274c}, {0x274e, 0x274e}, {0x2753, 0x2755},
+	{0x2757, 0x2757}, {0x2795, 0x2797}, {0x27b0, 0x27b0}, {0x27bf, 0x27bf},
+	{0x2b1b, 0x2b1c}, {0x2b50, 0x2b50}, {0x2b55, 0x2b55}, {0x2e80, 0x303e},
+	{0x3041, 0x33ff}, {0x3400, 0x4dbf}, {0x4e00, 0x9fff}, {0xa000, 0xa4cf},
+	{0xa960, 0xa97f}, {0xac00, 0xd7a3}, {0xf900, 0xfaff}, {0xfe10, 0xfe19},
+	{0xfe30, 0xfe6f}, {0xff00, 0xff60}, {0xffe0, 0xffe6}, {0x16fe0, 0x16fe4},
+	{0x17000, 0x18cff}, {0x1b000, 0x1b2ff}, {0x1f004, 0x1f004}, {0x1f0cf, 0x1f0cf},
+	{0x1f18e, 0x1f18e}, {0x1f191, 0x1f19a}, {0x1f200, 0x1f251}, {0x1f300, 0x1f64f},
+	{0x1f680, 0x1f6ff}, {0x1f7e0, 0x1f7eb}, {0x1f90c, 0x1f9ff}, {0x1fa70, 0x1faff},
+	{0x20000, 0x2fffd}, {0x30000, 0x3fffd},
+}
+
+func isWide(r rune) bool {
+	i := sort.Search(len(wideRanges), func(i int) bool { return wideRanges[i][1] >= r })
+	return i < len(wideRanges) && wideRanges[i][0] <= r
+}
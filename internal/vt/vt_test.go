@@ -0,0 +1,266 @@
+package vt
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func feed(t *Terminal, s string) *Terminal {
+	t.Write([]byte(s))
+	return t
+}
+
+// screenText is the screen showing, a line per row, trailing blanks trimmed.
+func screenText(t *Terminal) string {
+	lines := make([]string, t.rows)
+	for i := range lines {
+		lines[i] = t.Line(i)
+	}
+	return strings.Join(lines, "\n")
+}
+
+func TestPrintAndMove(t *testing.T) {
+	term := feed(New(3, 10, 0), "hello\r\nworld\x1b[1;3HX\x1b[2;8Hend")
+	if got, want := screenText(term), "heXlo\nworld  end\n"; got != want {
+		t.Errorf("screen = %q, want %q", got, want)
+	}
+	if r, c := term.Cursor(); r != 1 || c != 9 || !term.cur.wrapNext {
+		t.Errorf("cursor = %d,%d wrapNext=%v, want 1,9 waiting to wrap", r, c, term.cur.wrapNext)
+	}
+	feed(term, "!")
+	if got := term.Line(2); got != "!" {
+		t.Errorf("wrapped line = %q, want !", got)
+	}
+}
+
+func TestEraseAndEdit(t *testing.T) {
+	tests := []struct {
+		name, in, want string
+	}{
+		{"erase to end of line", "abcdef\x1b[1;3H\x1b[K", "ab"},
+		{"erase to start of line", "abcdef\x1b[1;3H\x1b[1K", "   def"},
+		{"erase chars", "abcdef\x1b[1;2H\x1b[2X", "a  def"},
+		{"insert chars", "abcdef\x1b[1;2H\x1b[2@", "a  bcdef"},
+		{"delete chars", "abcdef\x1b[1;2H\x1b[2P", "adef"},
+		{"insert mode", "abc\x1b[1;2H\x1b[4hX", "aXbc"},
+		{"backspace overwrite", "abc\b\bX", "aXc"},
+		{"tab", "a\tb", "a       b"},
+		{"repeat", "a\x1b[3b", "aaaa"},
+		{"line drawing", "\x1b(0lqk\x1b(Bx", "┌─┐x"},
+		{"shift out", "\x1b)0a\x0eq\x0fq", "a─q"},
+		{"combining mark dropped", "éx", "ex"},
+		{"utf-8 across writes", "\xe2\x94", ""},
+	}
+	for _, tt := range tests {
+		term := feed(New(2, 12, 0), tt.in)
+		if got := term.Line(0); got != tt.want {
+			t.Errorf("%s: line = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	term := feed(New(2, 12, 0), "\xe2\x94")
+	feed(term, "\x80")
+	if got := term.Line(0); got != "─" {
+		t.Errorf("rune split across writes = %q", got)
+	}
+}
+
+func TestScrollIntoHistory(t *testing.T) {
+	term := New(3, 10, 4)
+	for i := 1; i <= 8; i++ {
+		fmt.Fprintf(term, "line %d\r\n", i)
+	}
+	if term.HistoryLen() != 4 {
+		t.Fatalf("history = %d lines, want the 4 kept", term.HistoryLen())
+	}
+	if got := term.Line(-4); got != "line 3" {
+		t.Errorf("oldest history line = %q, want line 3", got)
+	}
+	if got, want := screenText(term), "line 7\nline 8\n"; got != want {
+		t.Errorf("screen = %q, want %q", got, want)
+	}
+
+	// Lines scrolled out of a region that doesn't start at the top, or
+	// off the alternate screen, aren't history.
+	term = feed(New(4, 10, 0), "a\r\nb\r\nc\r\nd\x1b[2;4r\x1b[4;1H\n\n")
+	if term.HistoryLen() != 0 || screenText(term) != "a\nd\n\n" {
+		t.Errorf("region scroll: history %d, screen %q", term.HistoryLen(), screenText(term))
+	}
+	term = feed(New(2, 10, 0), "\x1b[?1049hx\r\ny\r\nz\r\n")
+	if term.HistoryLen() != 0 {
+		t.Errorf("alternate screen scrolled %d lines into the history", term.HistoryLen())
+	}
+}
+
+func TestSGR(t *testing.T) {
+	term := feed(New(1, 20, 0), "\x1b[1;31mA\x1b[38;5;200;48;2;1;2;3mB\x1b[0;4;94mC\x1b[mD")
+	want := []pen{
+		{fg: indexed(1), attrs: attrBold},
+		{fg: indexed(200), bg: rgb(1, 2, 3), attrs: attrBold},
+		{fg: indexed(12), attrs: attrUnderline},
+		{},
+	}
+	for i, p := range want {
+		if got := term.main[0][i].pen; got != p {
+			t.Errorf("cell %d pen = %+v, want %+v", i, got, p)
+		}
+	}
+}
+
+func TestAltScreen(t *testing.T) {
+	term := feed(New(3, 10, 0), "shell$ \x1b[?1049h\x1b[Hfull screen")
+	if !term.AltScreen() || term.Line(0) != "full scree" {
+		t.Fatalf("alt screen = %v, line %q", term.AltScreen(), term.Line(0))
+	}
+	feed(term, "\x1b[?1049l")
+	if term.AltScreen() || term.Line(0) != "shell$" {
+		t.Errorf("after leaving: alt %v, line %q", term.AltScreen(), term.Line(0))
+	}
+	if r, c := term.Cursor(); r != 0 || c != 7 {
+		t.Errorf("cursor = %d,%d, want it back at 0,7", r, c)
+	}
+}
+
+func TestWideRunes(t *testing.T) {
+	term := feed(New(2, 5, 0), "a世界b")
+	if got := term.Line(0); got != "a世界" {
+		t.Errorf("line = %q", got)
+	}
+	if got := term.Line(1); got != "b" {
+		t.Errorf("wrapped = %q", got)
+	}
+	// Overwriting half a wide rune blanks the other half.
+	feed(term, "\x1b[1;3Hx")
+	if got := term.Line(0); got != "a x界" {
+		t.Errorf("after overwrite = %q", got)
+	}
+}
+
+func TestResize(t *testing.T) {
+	term := feed(New(4, 10, 0), "1\r\n2\r\n3\r\n4")
+	term.Resize(2, 5)
+	if got := screenText(term); got != "3\n4" {
+		t.Errorf("screen = %q, want the cursor's line kept", got)
+	}
+	if term.HistoryLen() != 2 {
+		t.Errorf("history = %d, want the 2 lines pushed off", term.HistoryLen())
+	}
+	term.Resize(3, 8)
+	if r, c := term.Cursor(); r != 1 || c != 1 {
+		t.Errorf("cursor = %d,%d", r, c)
+	}
+}
+
+// TestSnapshotReplays checks that replaying a snapshot into a new
+// terminal of the same size reproduces the original.
+func TestSnapshotReplays(t *testing.T) {
+	tests := []struct{ name, in string }{
+		{"plain", "$ ls\r\na b c\r\n$ "},
+		{"history", strings.Repeat("some output\r\n", 20) + "$ "},
+		{"colors", "\x1b[1;31mred\x1b[0m \x1b[48;5;22mbg \x1b[38;2;9;8;7mrgb\x1b[0m\r\n\x1b[44m\x1b[K\x1b[0m"},
+		{"links", "see \x1b]8;;htmlwidget:3\x07\x1b[34;4mwidget 3\x1b[0m\x1b]8;;\x07 here"},
+		{"pending wrap", "0123456789ab\x1b[1;12H\x1b[31mZ"},
+		{"wide at the edge", "\x1b[1;11H界"},
+		{"alt screen", "shell$ \x1b7\x1b[?1049h\x1b[2;3Hvim\x1b[5;1H\x1b[7m-- INSERT --"},
+		{"alt 47", "shell\x1b[?47h\x1b[Hless"},
+		{"scroll region and origin", "\x1b[2;4r\x1b[?6h\x1b[2;2Hin region"},
+		{"modes", "\x1b[?1h\x1b=\x1b[?25l\x1b[?2004h\x1b[?1000;1006h\x1b[?7l\x1b[4h"},
+		{"charsets", "\x1b(0\x1b)0\x0e"},
+		{"saved cursor", "\x1b[3;4H\x1b[32m\x1b7\x1b[1;1H\x1b[0m"},
+		{"open link and pen", "\x1b]8;;http://x\x1b\\\x1b[1;35m"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			orig := feed(New(5, 12, 100), tt.in)
+			replayed := feed(New(5, 12, 100), string(orig.Snapshot()))
+			assertSameTerminal(t, orig, replayed)
+
+			// Output after the snapshot lands the same way on both.
+			const more = "more\r\n\x1b[Anext\x1b]8;;\x07"
+			feed(orig, more)
+			feed(replayed, more)
+			assertSameTerminal(t, orig, replayed)
+		})
+	}
+}
+
+func assertSameTerminal(t *testing.T, want, got *Terminal) {
+	t.Helper()
+	if got.HistoryLen() != want.HistoryLen() {
+		t.Fatalf("history = %d lines, want %d", got.HistoryLen(), want.HistoryLen())
+	}
+	for i := -want.HistoryLen(); i < want.rows; i++ {
+		if g, w := got.Line(i), want.Line(i); g != w {
+			t.Errorf("line %d = %q, want %q", i, g, w)
+		}
+	}
+	if got.AltScreen() != want.AltScreen() {
+		t.Errorf("alt screen = %v, want %v", got.AltScreen(), want.AltScreen())
+	}
+	for _, screens := range [][2][][]Cell{{want.main, got.main}, {want.alt, got.alt}} {
+		for r, line := range screens[0] {
+			for c, cell := range line {
+				if g := screens[1][r][c]; !sameCell(cell, g, want, got) {
+					t.Errorf("cell %d,%d = %+v, want %+v", r, c, g, cell)
+				}
+			}
+		}
+	}
+	if got.cur != want.cur {
+		t.Errorf("cursor = %+v, want %+v", got.cur, want.cur)
+	}
+	if got.saved.row != want.saved.row || got.saved.col != want.saved.col || got.saved.pen != want.saved.pen {
+		t.Errorf("saved cursor = %+v, want %+v", got.saved, want.saved)
+	}
+	if got.top != want.top || got.bot != want.bot {
+		t.Errorf("scroll region = %d-%d, want %d-%d", got.top, got.bot, want.top, want.bot)
+	}
+	if got.insert != want.insert || got.keypadApp != want.keypadApp {
+		t.Errorf("insert, keypad = %v, %v, want %v, %v", got.insert, got.keypadApp, want.insert, want.keypadApp)
+	}
+	for m, on := range want.modes {
+		if got.modes[m] != on {
+			t.Errorf("mode %d = %v, want %v", m, got.modes[m], on)
+		}
+	}
+}
+
+// sameCell compares cells across terminals, which number links
+// differently; a never-written cell matches a space.
+func sameCell(a, b Cell, ta, tb *Terminal) bool {
+	if a.blank() && b.blank() {
+		return true
+	}
+	if a.R == 0 {
+		a.R = ' '
+	}
+	if b.R == 0 {
+		b.R = ' '
+	}
+	link := func(t *Terminal, c Cell) string {
+		if c.pen.link == 0 {
+			return ""
+		}
+		return t.links[c.pen.link-1]
+	}
+	if link(ta, a) != link(tb, b) {
+		return false
+	}
+	a.pen.link, b.pen.link = 0, 0
+	return a == b
+}
+
+func TestSnapshotIsCompact(t *testing.T) {
+	term := New(24, 80, 0)
+	for i := 0; i < 200; i++ {
+		fmt.Fprintf(term, "\x1b[H\x1b[2J\x1b[32mframe %d\x1b[0m", i)
+	}
+	snap := term.Snapshot()
+	if len(snap) > 200 {
+		t.Errorf("snapshot of one redrawn line is %d bytes: %q", len(snap), snap)
+	}
+	if !strings.Contains(string(snap), "frame 199") || strings.Contains(string(snap), "frame 198") {
+		t.Errorf("snapshot = %q, want only the last frame", snap)
+	}
+}
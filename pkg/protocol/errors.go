@@ -0,0 +1,77 @@
+// Package protocol defines wire formats shared by the goshell server and
+// its clients.
+package protocol
+
+// ErrorCode is a stable, machine-readable error identifier. Clients branch
+// on the code; the accompanying message is for people and may change.
+type ErrorCode string
+
+// Error codes returned by the HTTP API.
+const (
+	// Any route
+	ErrMethodNotAllowed ErrorCode = "method_not_allowed" // wrong HTTP method; see the Allow header
+	ErrNotFound         ErrorCode = "not_found"          // no such route or resource
+	ErrInvalidJSON      ErrorCode = "invalid_json"       // the request body isn't the expected JSON
+	ErrBodyTooLarge     ErrorCode = "body_too_large"     // the request body is over -max-body
+	ErrInvalidRequest   ErrorCode = "invalid_request"    // a parameter is missing or malformed
+	ErrRateLimited      ErrorCode = "rate_limited"       // try again after Retry-After seconds
+	ErrUnauthorized     ErrorCode = "unauthorized"       // the session token is missing or wrong
+	ErrForbidden        ErrorCode = "forbidden"          // the request must come from the server's own machine
+	ErrOriginNotAllowed ErrorCode = "origin_not_allowed" // a websocket upgrade from a page on another origin; see -allow-origin
+	ErrServerBusy       ErrorCode = "server_busy"        // an expensive route's queue timed out; try again after Retry-After seconds
+	ErrConfigInvalid    ErrorCode = "config_invalid"     // POST /reload found -config unreadable or invalid; nothing changed
+	ErrInternal         ErrorCode = "internal_error"
+
+	// Shell session
+	ErrRestartFailed    ErrorCode = "restart_failed"     // POST /restart couldn't start a new shell
+	ErrResizeFailed     ErrorCode = "resize_failed"      // POST /resize couldn't resize the PTY
+	ErrPTYWriteFailed   ErrorCode = "pty_write_failed"   // input couldn't be written to the shell
+	ErrSignalFailed     ErrorCode = "signal_failed"      // POST /signal couldn't signal the foreground job
+	ErrShellNotRunning  ErrorCode = "shell_not_running"  // there is no shell on the PTY, as after a failed restart
+	ErrUpgradeRequired  ErrorCode = "upgrade_required"   // /ws/shell requested without a websocket upgrade
+	ErrProfileNotFound  ErrorCode = "profile_not_found"  // POST /restart named a profile the config doesn't define
+	ErrShellBusy        ErrorCode = "shell_busy"         // the shell isn't at a waiting prompt, so it can't be asked or typed a widget command
+	ErrShellQueryFailed ErrorCode = "shell_query_failed" // the shell didn't answer a hidden query in time
+	ErrMacroNotFound    ErrorCode = "macro_not_found"
+	ErrHistoryNotFound  ErrorCode = "history_not_found"
+	ErrBlockNotFound    ErrorCode = "block_not_found"
+	ErrMacroPlaying     ErrorCode = "macro_playing" // another macro is still being written to the shell
+	ErrExecTimeout      ErrorCode = "exec_timeout"  // POST /exec killed the command at its timeout
+	ErrClientNotFound   ErrorCode = "client_not_found"
+	ErrReadOnlyClient   ErrorCode = "read_only_client" // an observer can't take input control
+	ErrUnhealthy        ErrorCode = "unhealthy"        // GET /healthz: no working shell right now; the message says why
+
+	// Widgets
+	ErrWidgetNotFound        ErrorCode = "widget_not_found"        // no HTML widget with that ID
+	ErrWidgetExpired         ErrorCode = "widget_expired"          // 410: the widget was evicted under the widget limits
+	ErrUnsupportedWidgetType ErrorCode = "unsupported_widget_type" // widget action "type" not shell or internal
+	ErrFreshnessNotTracked   ErrorCode = "freshness_not_tracked"   // the widget has no registered freshness marker
+	ErrConfirmNotFound       ErrorCode = "confirm_not_found"       // no held confirmation with that token, or it was answered
+	ErrConfirmExpired        ErrorCode = "confirm_expired"         // the confirmation timed out before the answer arrived
+	ErrCommandNotAllowed     ErrorCode = "command_not_allowed"     // a widget shell command -widget-cmd-allow or -widget-cmd-signed refuses
+	ErrNotQueued             ErrorCode = "not_queued"              // DELETE /queue/{id}: the command already ran or was cancelled
+
+	// File sharing
+	ErrMountNotFound  ErrorCode = "mount_not_found"  // no directory mounted at /files/<token>/, or it expired
+	ErrPathNotAllowed ErrorCode = "path_not_allowed" // GET /download outside the shell's home and cwd without -allow-any-path, or unreadable
+
+	// Recordings
+	ErrRecordingsDisabled ErrorCode = "recordings_disabled" // the server runs without -record-dir
+	ErrRecordingNotFound  ErrorCode = "recording_not_found"
+	ErrInvalidRecording   ErrorCode = "invalid_recording" // the file isn't an asciinema v2 cast
+	ErrRecordFailed       ErrorCode = "record_failed"     // POST /record/start couldn't create the cast file
+	ErrNotRecording       ErrorCode = "not_recording"     // POST /record/stop with no recording running
+)
+
+// ErrorResponse is the JSON body of every API error:
+//
+//	{"error":{"code":"widget_not_found","message":"no widget 12"}}
+type ErrorResponse struct {
+	Error ErrorBody `json:"error"`
+}
+
+// ErrorBody is the error inside an ErrorResponse.
+type ErrorBody struct {
+	Code    ErrorCode `json:"code"`
+	Message string    `json:"message"`
+}
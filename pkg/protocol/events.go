@@ -0,0 +1,37 @@
+package protocol
+
+// WidgetEventKind is the "kind" of widget lifecycle events on /ws/shell.
+const WidgetEventKind = "widget"
+
+// WidgetAction is the widget store mutation a WidgetEvent reports.
+type WidgetAction string
+
+// Widget store mutations.
+const (
+	WidgetCreated  WidgetAction = "created"  // a new widget was stored
+	WidgetReplaced WidgetAction = "replaced" // a keyed block or the server swapped in new content; version went up
+	WidgetEvicted  WidgetAction = "evicted"  // dropped under -widget-limit, -widget-max-bytes or -widget-ttl
+)
+
+// WidgetEvent reports one widget store mutation. Seq counts up from 1 for
+// each mutation since the server started; GET /htmlwidget/?since=<seq>
+// returns the events after seq, so a client that reconnects can catch up
+// on what it missed instead of reloading the whole list.
+type WidgetEvent struct {
+	Kind     string       `json:"kind"` // WidgetEventKind
+	Seq      int64        `json:"seq"`
+	Action   WidgetAction `json:"action"`
+	WidgetID int          `json:"widget_id"`
+	Title    string       `json:"title,omitempty"`
+	Version  int          `json:"version"`
+}
+
+// WidgetEvents is GET /htmlwidget/?since=<seq>.
+type WidgetEvents struct {
+	Seq    int64         `json:"seq"`    // the latest event; pass it as since next time
+	Events []WidgetEvent `json:"events"` // after since, oldest first
+
+	// Truncated is set when events after since are no longer kept, or
+	// since is from before a server restart: reload the widget list.
+	Truncated bool `json:"truncated"`
+}
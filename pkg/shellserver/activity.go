@@ -0,0 +1,163 @@
+package shellserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// defaultSessionID names the server's single shell session.
+const defaultSessionID = "main"
+
+// activityQuietGap is how long the PTY must be silent before new output
+// counts as a fresh burst of activity.
+const activityQuietGap = 500 * time.Millisecond
+
+// bellEventInterval is the least time between bell events, so cat-ing a
+// binary full of BELs doesn't flood clients; the bells in between are
+// only counted.
+const bellEventInterval = 500 * time.Millisecond
+
+// bellScanner counts BEL characters in PTY output while skipping the BELs
+// that terminate OSC strings. It keeps state between calls so sequences
+// split across reads are handled.
+type bellScanner struct {
+	state int
+}
+
+const (
+	bellGround    = iota
+	bellEscape    // saw ESC
+	bellString    // inside OSC/DCS/APC/PM/SOS
+	bellStringEsc // saw ESC inside a string (possible ST)
+)
+
+// Scan returns the number of bells in data.
+func (b *bellScanner) Scan(data []byte) int {
+	bells := 0
+	for _, c := range data {
+		switch b.state {
+		case bellGround:
+			switch c {
+			case '\x07':
+				bells++
+			case '\x1b':
+				b.state = bellEscape
+			}
+		case bellEscape:
+			switch c {
+			case ']', 'P', '_', '^', 'X':
+				b.state = bellString
+			case '\x1b':
+				// ESC ESC: still waiting for the introducer
+			case '\x07':
+				bells++
+				b.state = bellGround
+			default:
+				b.state = bellGround
+			}
+		case bellString:
+			switch c {
+			case '\x07':
+				// BEL terminates the string; it isn't a bell
+				b.state = bellGround
+			case '\x1b':
+				b.state = bellStringEsc
+			case '\x18', '\x1a':
+				// CAN/SUB abort the string
+				b.state = bellGround
+			}
+		case bellStringEsc:
+			switch c {
+			case '\\':
+				b.state = bellGround
+			case '\x1b':
+				// stay: another ESC inside the string
+			default:
+				b.state = bellString
+			}
+		}
+	}
+	return bells
+}
+
+// sessionActivity is what /sessions and activity events report.
+type sessionActivity struct {
+	ID       string `json:"id"`
+	Bells    int    `json:"bells"`
+	Activity int    `json:"activity"`
+	Clients  int    `json:"clients"`
+}
+
+// recordOutput updates the unread counters for a chunk of PTY output that
+// contained bells BEL characters, broadcasting an activity event when a
+// counter changes and a bell event at most every bellEventInterval.
+func (s *ShellServer) recordOutput(bells int, now time.Time) {
+	s.activityMu.Lock()
+	changed := bells > 0
+	s.bellCount += bells
+	if s.lastOutput.IsZero() || now.Sub(s.lastOutput) >= activityQuietGap {
+		s.activityCount++
+		changed = true
+	}
+	s.lastOutput = now
+	ring := bells > 0 && now.Sub(s.lastBell) >= bellEventInterval
+	if ring {
+		s.lastBell = now
+	}
+	s.activityMu.Unlock()
+
+	s.stats.bells.Add(int64(bells))
+	if ring {
+		data, _ := json.Marshal(map[string]any{"kind": "bell", "ts": now})
+		s.broadcastMessage(websocket.TextMessage, data)
+	}
+	if changed {
+		s.broadcastActivity()
+	}
+}
+
+// markSeen resets the unread counters after a client has looked at the session.
+func (s *ShellServer) markSeen() {
+	s.activityMu.Lock()
+	s.bellCount = 0
+	s.activityCount = 0
+	s.activityMu.Unlock()
+	s.broadcastActivity()
+}
+
+func (s *ShellServer) sessionActivity() sessionActivity {
+	s.clientsMu.RLock()
+	clients := len(s.clients)
+	s.clientsMu.RUnlock()
+
+	s.activityMu.Lock()
+	defer s.activityMu.Unlock()
+	return sessionActivity{
+		ID:       defaultSessionID,
+		Bells:    s.bellCount,
+		Activity: s.activityCount,
+		Clients:  clients,
+	}
+}
+
+func (s *ShellServer) broadcastActivity() {
+	msg := struct {
+		Kind string `json:"kind"`
+		sessionActivity
+	}{"activity", s.sessionActivity()}
+	data, _ := json.Marshal(msg)
+	s.broadcastMessage(websocket.TextMessage, data)
+}
+
+func (s *ShellServer) handleSessions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r, http.MethodGet)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode([]sessionActivity{s.sessionActivity()})
+}
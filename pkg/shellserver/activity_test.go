@@ -0,0 +1,118 @@
+package shellserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"shellserver/internal/testshell"
+)
+
+func TestBellScanner(t *testing.T) {
+	tests := []struct {
+		name   string
+		chunks []string
+		want   int
+	}{
+		{"plain bell", []string{"done\a"}, 1},
+		{"several bells", []string{"\a\a", "x\a"}, 3},
+		{"osc terminated by bel", []string{"\x1b]0;title\a"}, 0},
+		{"osc terminated by st", []string{"\x1b]0;title\x1b\\\a"}, 1},
+		{"bel after osc", []string{"\x1b]7;file:///tmp\a\a"}, 1},
+		{"html marker", []string{"\x1b]9001;HTML_START\a<b>x</b>\x1b]9001;HTML_END\a"}, 0},
+		{"osc split before bel", []string{"\x1b]2;long ti", "tle", "\a"}, 0},
+		{"osc split after esc", []string{"\x1b", "]0;t\a"}, 0},
+		{"st split across reads", []string{"\x1b]0;t\x1b", "\\", "\a"}, 1},
+		{"dcs string", []string{"\x1bPq#0;2\a\x1b\\"}, 0},
+		{"csi is not a string", []string{"\x1b[1m\a"}, 1},
+		{"can aborts osc", []string{"\x1b]0;t\x18\a"}, 1},
+		{"esc inside osc", []string{"\x1b]0;a\x1bb\a"}, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b bellScanner
+			got := 0
+			for _, chunk := range tt.chunks {
+				got += b.Scan([]byte(chunk))
+			}
+			if got != tt.want {
+				t.Errorf("bells = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestActivityCounters(t *testing.T) {
+	s, _ := newPipeServer(t)
+	start := time.Now()
+
+	s.recordOutput(0, start)
+	s.recordOutput(0, start.Add(100*time.Millisecond)) // same burst
+	s.recordOutput(2, start.Add(200*time.Millisecond))
+	s.recordOutput(0, start.Add(200*time.Millisecond+activityQuietGap)) // new burst
+
+	got := s.sessionActivity()
+	if got.Activity != 2 || got.Bells != 2 {
+		t.Errorf("activity = %+v, want 2 bursts and 2 bells", got)
+	}
+
+	msg, ok := parseControlFrame(websocket.TextMessage, []byte(`{"kind":"seen"}`))
+	if !ok {
+		t.Fatal("seen not recognized as a control frame")
+	}
+	s.handleControlMessage(nil, msg)
+
+	if got := s.sessionActivity(); got.Activity != 0 || got.Bells != 0 {
+		t.Errorf("after seen: %+v, want zero counters", got)
+	}
+}
+
+func TestHandleSessions(t *testing.T) {
+	s, _ := newPipeServer(t)
+	s.recordOutput(1, time.Now())
+
+	rec := httptest.NewRecorder()
+	s.handleSessions(rec, httptest.NewRequest(http.MethodGet, "/sessions", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	var sessions []sessionActivity
+	if err := json.NewDecoder(rec.Body).Decode(&sessions); err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 1 || sessions[0].ID != defaultSessionID || sessions[0].Bells != 1 || sessions[0].Activity != 1 {
+		t.Errorf("sessions = %+v", sessions)
+	}
+}
+
+func TestBellEvents(t *testing.T) {
+	s, ts := startFakeShellServer(t)
+	c := testshell.Dial(t, ts.URL, "")
+
+	// The BEL ending the title is no bell
+	c.Send("raw " + strconv.Quote("\x1b]0;title\x07\a"))
+	c.ExpectEvent("bell", testshell.DefaultTimeout)
+	c.ExpectEvent("title", testshell.DefaultTimeout)
+
+	// Bells close together make one event
+	start := time.Now().Add(time.Minute)
+	s.recordOutput(3, start)
+	s.recordOutput(5, start.Add(bellEventInterval/2))
+	s.recordOutput(1, start.Add(bellEventInterval))
+	for _, want := range []time.Time{start, start.Add(bellEventInterval)} {
+		ev := c.ExpectEvent("bell", testshell.DefaultTimeout)
+		if got, err := time.Parse(time.RFC3339Nano, ev["ts"].(string)); err != nil || !got.Equal(want) {
+			t.Errorf("bell at %v, want %v", ev["ts"], want)
+		}
+	}
+	if st := getStatus(t, ts.URL); st.Bells != 10 {
+		t.Errorf("/status bells = %d, want 10", st.Bells)
+	}
+}
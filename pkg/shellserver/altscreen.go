@@ -0,0 +1,81 @@
+package shellserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"syscall"
+
+	"github.com/gorilla/websocket"
+)
+
+// altScreenEnter are the sequences that switch to the alternate screen,
+// which full-screen programs such as vim draw on: xterm's, the older
+// one, and its variant, as containsAltScreenExit has them.
+var altScreenEnter = [][]byte{
+	[]byte("\x1b[?1049h"),
+	[]byte("\x1b[?47h"),
+	[]byte("\x1b[?1047h"),
+}
+
+// altScreenEntry returns the offset in data of the last sequence
+// switching to the alternate screen, or -1 if it has none, as when the
+// sequence was split across reads.
+func altScreenEntry(data []byte) int {
+	last := -1
+	for _, seq := range altScreenEnter {
+		last = max(last, bytes.LastIndex(data, seq))
+	}
+	return last
+}
+
+// noteAltScreen follows the screen model onto the alternate screen as
+// data, starting at output offset at, is drawn, recording where in the
+// output the program took it. bufferMu must be held, and was reports
+// whether the alternate screen showed before data.
+func (s *ShellServer) noteAltScreen(data []byte, at int64, was bool) {
+	if was || !s.screen.AltScreen() {
+		return
+	}
+	if i := altScreenEntry(data); i >= 0 {
+		at += int64(i)
+	}
+	s.altScreenStart = at
+}
+
+// altScreenActive reports whether a full-screen program holds the
+// alternate screen.
+func (s *ShellServer) altScreenActive() bool {
+	s.bufferMu.Lock()
+	defer s.bufferMu.Unlock()
+	return s.screen != nil && s.screen.AltScreen()
+}
+
+// broadcastAltScreen tells clients, with {"kind":"altscreen","active"},
+// when the alternate screen has come or gone since they were last told.
+func (s *ShellServer) broadcastAltScreen() {
+	active := s.altScreenActive()
+	if s.altScreenSent.Swap(active) == active {
+		return
+	}
+	data, _ := json.Marshal(map[string]any{"kind": "altscreen", "active": active})
+	s.broadcastMessage(websocket.TextMessage, data)
+}
+
+// requestRedraw asks the foreground program to draw its screen again, as
+// it does after its terminal is resized, for a client that joined while
+// it held the alternate screen.
+func (s *ShellServer) requestRedraw() {
+	s.ptyMu.Lock()
+	f := s.ptyFile
+	s.ptyMu.Unlock()
+	if f == nil {
+		return
+	}
+	pgid, err := getForegroundPGID(f)
+	if err != nil {
+		log.Printf("redraw: %v", err)
+		return
+	}
+	syscall.Kill(-pgid, syscall.SIGWINCH)
+}
@@ -0,0 +1,54 @@
+package shellserver
+
+import (
+	"strings"
+	"testing"
+
+	"shellserver/internal/testshell"
+)
+
+func TestAltScreenTracking(t *testing.T) {
+	_, ts := startFakeShellServer(t)
+	c := testshell.Dial(t, ts.URL, "")
+	if c.Ready["alt_screen"] != false {
+		t.Errorf("ready alt_screen = %v, want false", c.Ready["alt_screen"])
+	}
+
+	c.Send(`raw "before-vim\x21\n"`)
+	c.ExpectOutput("before-vim!", testshell.DefaultTimeout)
+	c.Send(`raw "\x1b[?1049hin-vim\x21"`)
+	if ev := c.ExpectEvent("altscreen", testshell.DefaultTimeout); ev["active"] != true {
+		t.Fatalf("altscreen = %v, want active", ev)
+	}
+	if !getStatus(t, ts.URL).AltScreen {
+		t.Error("/status alt_screen = false while the alternate screen shows")
+	}
+
+	// The snapshot draws the program's screen
+	snap := testshell.Dial(t, ts.URL, "")
+	if snap.Ready["alt_screen"] != true {
+		t.Errorf("ready alt_screen = %v, want true", snap.Ready["alt_screen"])
+	}
+	snap.ExpectOutput("in-vim!", testshell.DefaultTimeout)
+
+	// A raw replay stops where the program took the screen, and switches
+	// to the alternate screen for the program to redraw
+	raw := testshell.Dial(t, ts.URL, "?replay=raw")
+	c.Send(`raw "redraw-done\x21"`)
+	out := raw.ExpectOutput("redraw-done!", testshell.DefaultTimeout)
+	replay, _, _ := strings.Cut(out, "\x1b[?1049h")
+	if !strings.Contains(replay, "before-vim!") || strings.Contains(replay, "in-vim!") {
+		t.Errorf("raw replay = %q, want the normal screen only", out)
+	}
+	if !strings.Contains(out, "\x1b[?1049h") {
+		t.Errorf("raw replay = %q, want it to switch to the alternate screen", out)
+	}
+
+	c.Send(`raw "\x1b[?1049lout\x21"`)
+	if ev := c.ExpectEvent("altscreen", testshell.DefaultTimeout); ev["active"] != false {
+		t.Fatalf("altscreen = %v, want inactive", ev)
+	}
+	if getStatus(t, ts.URL).AltScreen {
+		t.Error("/status alt_screen = true after the program left the alternate screen")
+	}
+}
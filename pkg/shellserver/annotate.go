@@ -0,0 +1,263 @@
+package shellserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+var (
+	flagAnnotateMin    = Flags.Duration("annotate-min-duration", 10*time.Second, "annotate commands that run at least this long (0 disables)")
+	flagAnnotateInject = Flags.Bool("annotate-inject", true, "write duration annotations into the terminal; when false only the websocket event is sent")
+)
+
+// maxTrackedSequence bounds how much of one escape sequence the command
+// tracker buffers; longer sequences are skipped, not parsed.
+const maxTrackedSequence = 64 * 1024
+
+// Escape parser states for commandTracker.
+const (
+	trackGround = iota
+	trackEscape
+	trackCSI
+	trackOSC
+	trackOSCEscape
+)
+
+// commandTracker times commands from the integration markers in PTY
+// output. It parses escape sequences across reads, so it also knows
+// whether the cursor is at the start of a line and whether a full-screen
+// program holds the alternate screen.
+type commandTracker struct {
+	state     int
+	seq       []byte
+	overflow  bool // seq exceeded maxTrackedSequence
+	atBOL     bool // last printed byte was a newline
+	altScreen bool
+
+	running bool
+	command string
+	started time.Time
+
+	cwd string // from the last OSC 7
+
+	title  string // from the last OSC 0, 1 or 2; see takeTitle
+	titled bool
+}
+
+// finishedCommand is a timed command whose finished marker ends just
+// before offset End of the data passed to Scan.
+type finishedCommand struct {
+	Command    string
+	ExitCode   int
+	Duration   time.Duration
+	FinishedAt time.Time
+	End        int
+	AtBOL      bool // output before the marker ended with a newline
+	AltScreen  bool // finished while the alternate screen was active
+}
+
+// Scan advances the tracker over data, received at now, and returns the
+// commands whose finished markers it contains.
+func (t *commandTracker) Scan(data []byte, now time.Time) []finishedCommand {
+	var finished []finishedCommand
+	for i := 0; i < len(data); i++ {
+		b := data[i]
+		switch t.state {
+		case trackGround:
+			switch {
+			case b == 0x1b:
+				t.state = trackEscape
+			case b == '\n':
+				t.atBOL = true
+			case b >= 0x20:
+				t.atBOL = false
+			}
+		case trackEscape:
+			t.seq, t.overflow = t.seq[:0], false
+			switch b {
+			case '[':
+				t.state = trackCSI
+			case ']':
+				t.state = trackOSC
+			default:
+				t.state = trackGround
+			}
+		case trackCSI:
+			if b >= 0x40 && b <= 0x7e {
+				t.handleCSI(string(t.seq), b)
+				t.state = trackGround
+			} else {
+				t.push(b)
+			}
+		case trackOSC:
+			switch b {
+			case 0x07:
+				if f, ok := t.handleOSC(now, i+1); ok {
+					finished = append(finished, f)
+				}
+				t.state = trackGround
+			case 0x1b:
+				t.state = trackOSCEscape
+			default:
+				t.push(b)
+			}
+		case trackOSCEscape:
+			if b == '\\' {
+				if f, ok := t.handleOSC(now, i+1); ok {
+					finished = append(finished, f)
+				}
+				t.state = trackGround
+			} else {
+				// An ESC that isn't ST aborts the OSC and starts a new sequence
+				t.state = trackEscape
+				i--
+			}
+		}
+	}
+	return finished
+}
+
+func (t *commandTracker) push(b byte) {
+	if len(t.seq) >= maxTrackedSequence {
+		t.overflow = true
+		return
+	}
+	t.seq = append(t.seq, b)
+}
+
+func (t *commandTracker) handleCSI(params string, final byte) {
+	switch params {
+	case "?1049", "?1047", "?47":
+		if final == 'h' {
+			t.altScreen = true
+		} else if final == 'l' {
+			t.altScreen = false
+		}
+	}
+}
+
+func (t *commandTracker) handleOSC(now time.Time, end int) (finishedCommand, bool) {
+	if t.overflow {
+		return finishedCommand{}, false
+	}
+	payload := string(t.seq)
+	if title, ok := titlePayload(payload); ok {
+		t.title, t.titled = title, true
+		return finishedCommand{}, false
+	}
+	if payload == "133;C" {
+		// zsh and bash send the command line first; this only starts
+		// the clock for shells that don't
+		if !t.running {
+			t.running, t.command, t.started = true, "", now
+		}
+		return finishedCommand{}, false
+	}
+
+	ev, ok := parseShellEvent(payload)
+	if !ok {
+		return finishedCommand{}, false
+	}
+	switch ev.Kind {
+	case "cwd":
+		t.cwd = ev.Path
+	case "command":
+		t.running, t.command, t.started = true, ev.Command, now
+	case "finished":
+		if !t.running {
+			return finishedCommand{}, false
+		}
+		t.running = false
+		return finishedCommand{
+			Command:    t.command,
+			ExitCode:   ev.ExitCode,
+			Duration:   now.Sub(t.started),
+			FinishedAt: now,
+			End:        end,
+			AtBOL:      t.atBOL,
+			AltScreen:  t.altScreen,
+		}, true
+	}
+	return finishedCommand{}, false
+}
+
+// formatCommandDuration rounds d to a precision that suits its size.
+func formatCommandDuration(d time.Duration) string {
+	switch {
+	case d < time.Second:
+		return d.Round(time.Millisecond).String()
+	case d < time.Minute:
+		return d.Round(100 * time.Millisecond).String()
+	default:
+		return d.Round(time.Second).String()
+	}
+}
+
+// commandAnnotation is the dim line written after a slow command.
+func commandAnnotation(f finishedCommand) string {
+	var b strings.Builder
+	if !f.AtBOL {
+		b.WriteString("\r\n")
+	}
+	fmt.Fprintf(&b, "\x1b[2mtook %s, exit %d, finished %s\x1b[0m\r\n",
+		formatCommandDuration(f.Duration), f.ExitCode, f.FinishedAt.Format("15:04:05"))
+	return b.String()
+}
+
+// annotateCommands runs the tracker over processed PTY output and returns
+// it with annotations inserted after slow commands, plus the commands
+// that finished in it. Every finished command goes into the history.
+// Annotations go right after the finished marker, which the shell sends
+// before drawing the next prompt, and never into an alternate-screen
+// session.
+func (s *ShellServer) annotateCommands(t *commandTracker, data []byte, now time.Time) ([]byte, []finishedCommand) {
+	finished := t.Scan(data, now)
+	var slow []finishedCommand
+	for _, f := range finished {
+		s.history.add(f)
+		if s.slowCommand(f) {
+			slow = append(slow, f)
+		}
+	}
+	if !s.annotateInject || len(slow) == 0 {
+		return data, finished
+	}
+
+	var out []byte
+	last := 0
+	for _, f := range slow {
+		if f.AltScreen {
+			continue
+		}
+		out = append(out, data[last:f.End]...)
+		out = append(out, commandAnnotation(f)...)
+		last = f.End
+	}
+	if out == nil {
+		return data, finished
+	}
+	return append(out, data[last:]...), finished
+}
+
+// slowCommand says whether f ran at least -annotate-min-duration.
+func (s *ShellServer) slowCommand(f finishedCommand) bool {
+	return s.annotateMin > 0 && f.Duration >= s.annotateMin
+}
+
+// broadcastCommandDuration tells clients about a command that ran longer
+// than -annotate-min-duration.
+func (s *ShellServer) broadcastCommandDuration(f finishedCommand) {
+	msg := map[string]any{
+		"kind":        "command-duration",
+		"command":     f.Command,
+		"exit_code":   f.ExitCode,
+		"duration_ms": f.Duration.Milliseconds(),
+		"finished_at": f.FinishedAt,
+	}
+	data, _ := json.Marshal(msg)
+	s.broadcastMessage(websocket.TextMessage, data)
+}
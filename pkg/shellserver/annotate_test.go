@@ -0,0 +1,124 @@
+package shellserver
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"shellserver/internal/testshell"
+)
+
+func TestCommandTrackerSplitMarkers(t *testing.T) {
+	var tr commandTracker
+	t0 := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	stream := "\x1b]9001;CMD;bWFrZQ==\x07\x1b]133;C\x07building\n\x1b]133;D;1\x07\x1b]133;A\x07"
+	split := strings.Index(stream, "133;D") + 2
+
+	if got := tr.Scan([]byte(stream[:split]), t0); len(got) != 0 {
+		t.Fatalf("finished before the marker completed: %+v", got)
+	}
+	rest := []byte(stream[split:])
+	got := tr.Scan(rest, t0.Add(90*time.Second))
+	if len(got) != 1 {
+		t.Fatalf("finished commands = %+v, want one", got)
+	}
+	f := got[0]
+	if f.Command != "make" || f.ExitCode != 1 || f.Duration != 90*time.Second || !f.AtBOL {
+		t.Errorf("finished = %+v", f)
+	}
+	if end := string(rest[:f.End]); !strings.HasSuffix(end, "1\x07") {
+		t.Errorf("End points after %q, want just past the finished marker", end)
+	}
+}
+
+func TestCommandTrackerAltScreenAndLineStart(t *testing.T) {
+	var tr commandTracker
+	now := time.Now()
+
+	tr.Scan([]byte("\x1b]133;C\x07\x1b[?1049hfull screen"), now)
+	got := tr.Scan([]byte("\x1b]133;D;0\x07"), now)
+	if len(got) != 1 || !got[0].AltScreen {
+		t.Errorf("finished inside alternate screen = %+v, want AltScreen", got)
+	}
+
+	tr.Scan([]byte("\x1b[?1049l\x1b]133;C\x07no newline"), now)
+	got = tr.Scan([]byte("\x1b]133;D;0\x07"), now)
+	if len(got) != 1 || got[0].AltScreen || got[0].AtBOL {
+		t.Errorf("finished after partial line = %+v, want neither AltScreen nor AtBOL", got)
+	}
+
+	// A finished marker with no command running (the first prompt) is ignored.
+	if got := tr.Scan([]byte("\x1b]133;D;0\x07"), now); len(got) != 0 {
+		t.Errorf("untimed finished marker reported: %+v", got)
+	}
+}
+
+func TestAnnotateCommands(t *testing.T) {
+	s := &ShellServer{annotateMin: time.Second, annotateInject: true}
+	var tr commandTracker
+	t0 := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	s.annotateCommands(&tr, []byte("\x1b]133;C\x07"), t0)
+	out, finished := s.annotateCommands(&tr, []byte("partial\x1b]133;D;0\x07$ "), t0.Add(4*time.Minute+12*time.Second))
+	want := "partial\x1b]133;D;0\x07\r\n\x1b[2mtook 4m12s, exit 0, finished 03:08:17\x1b[0m\r\n$ "
+	if string(out) != want || len(finished) != 1 {
+		t.Errorf("annotated = %q, want %q", out, want)
+	}
+
+	s.annotateInject = false
+	s.annotateCommands(&tr, []byte("\x1b]133;C\x07"), t0)
+	out, finished = s.annotateCommands(&tr, []byte("\x1b]133;D;0\x07"), t0.Add(time.Minute))
+	if string(out) != "\x1b]133;D;0\x07" || len(finished) != 1 {
+		t.Errorf("with injection off got %q (%d commands), want output untouched and one command", out, len(finished))
+	}
+}
+
+func withAnnotateFlags(t *testing.T, min time.Duration, inject bool) {
+	t.Helper()
+	oldMin, oldInject := *flagAnnotateMin, *flagAnnotateInject
+	*flagAnnotateMin, *flagAnnotateInject = min, inject
+	t.Cleanup(func() { *flagAnnotateMin, *flagAnnotateInject = oldMin, oldInject })
+}
+
+func TestSlowCommandAnnotated(t *testing.T) {
+	withAnnotateFlags(t, 200*time.Millisecond, true)
+	_, ts := startFakeShellServer(t)
+	c := testshell.Dial(t, ts.URL, "")
+
+	c.Send("mark command make all")
+	c.Send("mark exec")
+	c.Send("sleep 300ms")
+	c.Send(`raw "build output\n\x1b]133;D;2\x07\x1b]133;A\x07"`)
+
+	// The markers are taken out, the annotation left where they were
+	c.ExpectOutput("build output\r\n\x1b[2mtook ", testshell.DefaultTimeout)
+	line := c.ExpectOutput("\x1b[0m\r\n", testshell.DefaultTimeout)
+	if !strings.Contains(line, ", exit 2, finished ") {
+		t.Errorf("annotation = %q, want exit code and finish time", line)
+	}
+
+	ev := c.ExpectEvent("command-duration", testshell.DefaultTimeout)
+	if ev["command"] != "make all" || ev["exit_code"] != float64(2) {
+		t.Errorf("command-duration event = %v", ev)
+	}
+	if ms, _ := ev["duration_ms"].(float64); ms < 300 {
+		t.Errorf("duration_ms = %v, want at least 300", ev["duration_ms"])
+	}
+}
+
+func TestFastCommandNotAnnotated(t *testing.T) {
+	withAnnotateFlags(t, time.Minute, true)
+	_, ts := startFakeShellServer(t)
+	c := testshell.Dial(t, ts.URL, "")
+
+	c.Send("mark exec")
+	c.Send(`raw "quick\n\x1b]133;D;0\x07\x1b]133;A\x07"`)
+
+	// An annotation would come right after the command's output
+	c.ExpectOutput("quick\r\n", testshell.DefaultTimeout)
+	c.Send("echo after-quick")
+	if out := c.ExpectOutput("after-quick\r\n", testshell.DefaultTimeout); strings.Contains(out, "took ") {
+		t.Errorf("fast command annotated: %q", out)
+	}
+}
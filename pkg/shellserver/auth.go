@@ -0,0 +1,86 @@
+package shellserver
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"strings"
+
+	"shellserver/pkg/protocol"
+)
+
+var (
+	flagAuth  = Flags.Bool("auth", true, "require a token on the API and websocket; -auth=false lets anything that can reach -addr drive the shell")
+	flagToken = Flags.String("token", "", "token clients present as Authorization: Bearer or ?token= (default: generated at startup and printed)")
+)
+
+// setupAuthToken settles -token before the server starts: cleared without
+// -auth, generated when -auth has none. It reports whether it generated
+// one, which then has to be shown to the user.
+func setupAuthToken() (generated bool) {
+	if !*flagAuth {
+		*flagToken = ""
+		return false
+	}
+	if *flagToken != "" {
+		return false
+	}
+	b := make([]byte, 16)
+	rand.Read(b)
+	*flagToken = hex.EncodeToString(b)
+	return true
+}
+
+// logAuth tells the user how to reach a server that wants a token. The
+// token goes in the URL's fragment, which browsers don't send, for the web
+// UI to pick up.
+func logAuth(generated bool) {
+	switch {
+	case *flagToken == "":
+		log.Printf("authentication is off (-auth=false)")
+	case generated:
+		log.Printf("open %s/#token=%s", localURL(*flagAddr), *flagToken)
+	default:
+		log.Printf("clients must present -token; open %s/#token=<token>", localURL(*flagAddr))
+	}
+}
+
+// requestToken is the token r presents, as an Authorization: Bearer header
+// or, for browsers' websockets, which can't set headers, ?token=.
+func requestToken(r *http.Request) string {
+	if scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " "); ok && strings.EqualFold(scheme, "Bearer") {
+		return strings.TrimSpace(token)
+	}
+	return r.URL.Query().Get("token")
+}
+
+// authed wraps h to refuse requests without s's token with a 401. A server
+// without a token admits everything. The websocket is wrapped too, so an
+// unauthenticated upgrade is refused before any output is replayed. With
+// -csrf-check, a request that changes state from a page on another origin
+// is refused with a 403, token or not.
+func (s *ShellServer) authed(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.authToken != "" && subtle.ConstantTimeCompare([]byte(requestToken(r)), []byte(s.authToken)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="goshell"`)
+			respondError(w, r, http.StatusUnauthorized, protocol.ErrUnauthorized, "missing or invalid token: send Authorization: Bearer <token> or ?token=<token>")
+			return
+		}
+		if s.csrfCheck && stateChanging(r) && !originListed(r) {
+			log.Printf("%s %s from origin %q refused", r.Method, r.URL.Path, r.Header.Get("Origin"))
+			respondError(w, r, http.StatusForbidden, protocol.ErrOriginNotAllowed, r.Method+" requests from "+r.Header.Get("Origin")+" aren't allowed; see -allow-origin")
+			return
+		}
+		h(w, r)
+	}
+}
+
+// authMode is the "auth" capability.
+func authMode(s *ShellServer) string {
+	if s.authToken == "" {
+		return "none"
+	}
+	return "token"
+}
@@ -0,0 +1,119 @@
+package shellserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+
+	"shellserver/internal/testshell"
+	"shellserver/pkg/protocol"
+)
+
+func TestTokenAuth(t *testing.T) {
+	s, ts := startFakeShellServer(t)
+	s.authToken = "s3cret"
+
+	get := func(path, authorization string) *http.Response {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodGet, ts.URL+path, nil)
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	for _, path := range []string{"/status", "/htmlwidget/", "/restart", "/resize", "/widget/1/error", "/version?token=wrong", "/debug/vars"} {
+		req, _ := http.NewRequest(http.MethodGet, ts.URL+path, nil)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var body protocol.ErrorResponse
+		json.NewDecoder(resp.Body).Decode(&body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusUnauthorized || body.Error.Code != protocol.ErrUnauthorized {
+			t.Errorf("GET %s without the token: %d %s, want 401 unauthorized", path, resp.StatusCode, body.Error.Code)
+		}
+		if !strings.HasPrefix(resp.Header.Get("WWW-Authenticate"), "Bearer") {
+			t.Errorf("GET %s: WWW-Authenticate = %q", path, resp.Header.Get("WWW-Authenticate"))
+		}
+	}
+	if resp := get("/status", "Bearer wrong"); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("wrong bearer token: %d", resp.StatusCode)
+	}
+	if resp := get("/status", "Bearer s3cret"); resp.StatusCode != http.StatusOK {
+		t.Errorf("bearer token: %d", resp.StatusCode)
+	}
+	if resp := get("/status?token=s3cret", ""); resp.StatusCode != http.StatusOK {
+		t.Errorf("?token=: %d", resp.StatusCode)
+	}
+	// Shared files carry their own token
+	if resp := get("/files/nope/", ""); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("GET /files/nope/ without the token: %d, want 404", resp.StatusCode)
+	}
+
+	// The upgrade is refused outright, so nothing is replayed
+	wsURL := "ws://" + strings.TrimPrefix(ts.URL, "http://") + "/ws/shell"
+	conn, resp, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err == nil {
+		conn.Close()
+		t.Fatal("websocket without the token connected")
+	}
+	if resp == nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("websocket without the token: %v, want 401", resp)
+	}
+	c := testshell.Dial(t, ts.URL, "?token=s3cret")
+	c.Send("echo authed")
+	c.ExpectOutput("\r\nauthed\r\n", testshell.DefaultTimeout)
+}
+
+func TestSetupAuthToken(t *testing.T) {
+	oldAuth, oldToken := *flagAuth, *flagToken
+	t.Cleanup(func() { *flagAuth, *flagToken = oldAuth, oldToken })
+
+	*flagAuth, *flagToken = true, ""
+	if !setupAuthToken() || len(*flagToken) != 32 {
+		t.Errorf("no -token: generated %q", *flagToken)
+	}
+	*flagToken = "given"
+	if setupAuthToken() || *flagToken != "given" {
+		t.Errorf("-token given: replaced with %q", *flagToken)
+	}
+	*flagAuth = false
+	if setupAuthToken() || *flagToken != "" {
+		t.Errorf("-auth=false: token %q", *flagToken)
+	}
+}
+
+func TestDebugVars(t *testing.T) {
+	s, ts := startFakeShellServer(t)
+	s.authToken = "s3cret"
+
+	resp, err := http.Get(ts.URL + "/debug/vars?token=s3cret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var vars map[string]json.RawMessage
+	if err := json.NewDecoder(resp.Body).Decode(&vars); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := vars["server_stats"]; !ok {
+		t.Errorf("vars = %v, want server_stats", vars)
+	}
+	if _, ok := vars["cmdline"]; ok {
+		t.Error("vars publish the command line")
+	}
+	if _, pattern := http.DefaultServeMux.Handler(httptest.NewRequest(http.MethodGet, "/debug/vars", nil)); pattern != "" {
+		t.Errorf("/debug/vars registered on DefaultServeMux as %q", pattern)
+	}
+}
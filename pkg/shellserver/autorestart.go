@@ -0,0 +1,159 @@
+package shellserver
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"os/exec"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+var flagNoAutorestart = Flags.Bool("no-autorestart", false, "leave the session without a shell when it exits, until POST /restart, instead of starting a new one")
+
+// Pacing for automatic restarts; see restartBackoff.
+const (
+	autoRestartMinDelay = 250 * time.Millisecond
+	autoRestartMaxDelay = 30 * time.Second
+	autoRestartStable   = 10 * time.Second // a shell that ran this long resets the delay
+)
+
+// restartedNote marks in the scrollback where an exited shell was replaced.
+const restartedNote = "\r\n\x1b[33m--- shell exited, restarted ---\x1b[0m\r\n"
+
+// shellReapTimeout bounds how long a restart waits for the shell it
+// hung up to be reaped before killing it.
+const shellReapTimeout = time.Second
+
+// shellProcess is a shell being waited for in the background, so it
+// doesn't linger as a zombie once it exits.
+type shellProcess struct {
+	started time.Time
+	done    chan struct{} // closed once the shell has been reaped
+	exit    shellExit     // set before done is closed
+}
+
+// shellExit is how a shell ended, as /status reports it.
+type shellExit struct {
+	Code   int    `json:"code"`             // 128+n when killed by signal n, as a shell reports it
+	Signal string `json:"signal,omitempty"` // the signal that killed it, e.g. "hangup"
+}
+
+// reapShell starts waiting for cmd.
+func reapShell(cmd *exec.Cmd) *shellProcess {
+	p := &shellProcess{started: time.Now(), done: make(chan struct{})}
+	go func() {
+		cmd.Wait()
+		p.exit.Code = exitStatus(cmd.ProcessState)
+		if ws, ok := cmd.ProcessState.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+			p.exit.Signal = ws.Signal().String()
+		}
+		close(p.done)
+	}()
+	return p
+}
+
+// wait returns the shell's exit status once it has been reaped, or false
+// if that takes longer than timeout. A nil p has nothing to wait for.
+func (p *shellProcess) wait(timeout time.Duration) (int, bool) {
+	if p == nil {
+		return -1, true
+	}
+	select {
+	case <-p.done:
+		return p.exit.Code, true
+	case <-time.After(timeout):
+		return -1, false
+	}
+}
+
+// exitStatus is how a shell would report ps's exit: its code, or 128 plus
+// the signal that killed it.
+func exitStatus(ps *os.ProcessState) int {
+	if ws, ok := ps.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		return 128 + int(ws.Signal())
+	}
+	return ps.ExitCode()
+}
+
+// restartBackoff paces automatic restarts so a shell that exits as soon as
+// it starts, such as one with a broken rc file, doesn't spin: the first
+// restart waits autoRestartMinDelay and each quick exit after it twice as
+// long as the last, up to autoRestartMaxDelay.
+type restartBackoff struct {
+	mu    sync.Mutex
+	start time.Time // when the current shell started
+	delay time.Duration
+}
+
+// started records that a new shell started at t. A nil b ignores it.
+func (b *restartBackoff) started(t time.Time) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.start = t
+}
+
+// next returns how long to wait before replacing a shell that exited at t.
+func (b *restartBackoff) next(t time.Time) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.delay == 0 || t.Sub(b.start) >= autoRestartStable {
+		b.delay = autoRestartMinDelay
+	} else {
+		b.delay = min(2*b.delay, autoRestartMaxDelay)
+	}
+	return b.delay
+}
+
+// shellExited records how the shell ended, tells clients and, unless
+// -no-autorestart or the server is stopping, starts a new one after the
+// backoff, which a restart by hand, cancelling ctx, cuts short. The
+// scrollback is kept, with restartedNote where the new shell begins.
+func (s *ShellServer) shellExited(ctx context.Context, shell *shellProcess) {
+	code, ok := shell.wait(shellReapTimeout)
+	if ok {
+		exit := shell.exit
+		s.lastExit.Store(&exit)
+	} else {
+		log.Printf("shell exited but wasn't reaped within %v", shellReapTimeout)
+	}
+	s.broadcastShellExit(code)
+
+	if s.autoRestart == nil || s.stopping.Load() {
+		return
+	}
+	delay := s.autoRestart.next(time.Now())
+	log.Printf("shell exited with status %d; restarting in %v", code, delay)
+	select {
+	case <-time.After(delay):
+	case <-ctx.Done():
+		// restarted by hand meanwhile, or the server is stopping
+		return
+	}
+	if s.stopping.Load() {
+		return
+	}
+	s.output([]byte(restartedNote), false, nil)
+	if err := s.relaunch(false); err != nil {
+		log.Printf("auto-restart: %v", err)
+	}
+}
+
+// broadcastShellExit sends {"kind":"status","state":"exited"} with the
+// shell's exit code, which is left out if it isn't known.
+func (s *ShellServer) broadcastShellExit(code int) {
+	s.setStatus("exited", "", code)
+	msg := map[string]any{"kind": "status", "state": "exited"}
+	if code >= 0 {
+		msg["code"] = code
+	}
+	data, _ := json.Marshal(msg)
+	s.broadcastMessage(websocket.TextMessage, data)
+}
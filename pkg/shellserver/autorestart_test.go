@@ -0,0 +1,143 @@
+package shellserver
+
+import (
+	"net/http"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"shellserver/internal/testshell"
+)
+
+func TestShellAutoRestarts(t *testing.T) {
+	s, ts := startFakeShellServer(t)
+	c := testshell.Dial(t, ts.URL, "")
+
+	c.Send("echo before")
+	c.ExpectOutput("before\r\n", testshell.DefaultTimeout)
+	c.Send("exit 3")
+	ev := c.ExpectEvent("status", testshell.DefaultTimeout)
+	if ev["state"] != "exited" || ev["code"] != float64(3) {
+		t.Errorf("status = %v, want exited with code 3", ev)
+	}
+	c.ExpectOutput("--- shell exited, restarted ---", testshell.DefaultTimeout)
+	c.ExpectOutput("$ ", testshell.DefaultTimeout)
+
+	// The new shell answers, and the old one's output is still there
+	c.Send("echo after")
+	c.ExpectOutput("after\r\n", testshell.DefaultTimeout)
+	s.bufferMu.Lock()
+	buffer := string(s.buffer)
+	s.bufferMu.Unlock()
+	if !strings.Contains(buffer, "before") {
+		t.Errorf("replay buffer %q lost the exited shell's output", buffer)
+	}
+}
+
+func TestNoAutorestart(t *testing.T) {
+	old := *flagNoAutorestart
+	*flagNoAutorestart = true
+	t.Cleanup(func() { *flagNoAutorestart = old })
+
+	s, ts := startFakeShellServer(t)
+	c := testshell.Dial(t, ts.URL, "")
+	s.ptyMu.Lock()
+	ptyFile := s.ptyFile
+	s.ptyMu.Unlock()
+
+	c.Send("exit 0")
+	if ev := c.ExpectEvent("status", testshell.DefaultTimeout); ev["state"] != "exited" || ev["code"] != float64(0) {
+		t.Errorf("status = %v, want exited with code 0", ev)
+	}
+	time.Sleep(2 * autoRestartMinDelay)
+	s.ptyMu.Lock()
+	defer s.ptyMu.Unlock()
+	if s.ptyFile != ptyFile {
+		t.Error("shell restarted under -no-autorestart")
+	}
+}
+
+func TestRestartBackoff(t *testing.T) {
+	start := time.Now()
+	b := &restartBackoff{start: start}
+	var got []time.Duration
+	for i := 0; i < 10; i++ {
+		at := start.Add(time.Duration(i) * time.Second)
+		got = append(got, b.next(at))
+		b.started(at)
+	}
+	want := []time.Duration{250 * time.Millisecond, 500 * time.Millisecond, time.Second, 2 * time.Second, 4 * time.Second,
+		8 * time.Second, 16 * time.Second, 30 * time.Second, 30 * time.Second, 30 * time.Second}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("delays for quick exits = %v, want %v", got, want)
+		}
+	}
+
+	// A shell that ran a while starts the backoff over
+	b.started(start)
+	if d := b.next(start.Add(autoRestartStable)); d != autoRestartMinDelay {
+		t.Errorf("delay after a stable shell = %v, want %v", d, autoRestartMinDelay)
+	}
+}
+
+func TestStatusReportsLastExit(t *testing.T) {
+	_, ts := startFakeShellServer(t)
+	c := testshell.Dial(t, ts.URL, "")
+
+	if st := getStatus(t, ts.URL); st.Shell.LastExit != nil {
+		t.Errorf("last_exit = %+v before any shell exited", st.Shell.LastExit)
+	}
+	c.Send("exit 3")
+	c.ExpectEvent("status", testshell.DefaultTimeout)
+	if st := getStatus(t, ts.URL); st.Shell.LastExit == nil || *st.Shell.LastExit != (shellExit{Code: 3}) {
+		t.Errorf("last_exit = %+v, want code 3", st.Shell.LastExit)
+	}
+}
+
+func TestRestartReapsOldShell(t *testing.T) {
+	s, ts := startFakeShellServer(t)
+	c := testshell.Dial(t, ts.URL, "")
+
+	// A shell that ignores the hangup has to be killed
+	c.Send("nohup")
+	c.ExpectOutput("$ ", testshell.DefaultTimeout)
+	s.ptyMu.Lock()
+	oldPGID, oldShell := s.shellPGID, s.shell
+	s.ptyMu.Unlock()
+
+	resp, err := http.Post(ts.URL+"/restart", "application/json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	select {
+	case <-oldShell.done:
+	default:
+		t.Fatal("restart returned before the old shell was reaped")
+	}
+	if err := syscall.Kill(oldPGID, 0); err != syscall.ESRCH {
+		t.Errorf("old shell %d still exists after restart: %v", oldPGID, err)
+	}
+}
+
+func TestRestartHangsUpOldShell(t *testing.T) {
+	s, ts := startFakeShellServer(t)
+	c := testshell.Dial(t, ts.URL, "")
+	c.ExpectOutput("$ ", testshell.DefaultTimeout)
+	s.ptyMu.Lock()
+	oldShell := s.shell
+	s.ptyMu.Unlock()
+
+	start := time.Now()
+	if err := s.restart(); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d > shellReapTimeout/2 {
+		t.Errorf("restart took %v, want well under %v", d, shellReapTimeout)
+	}
+	if oldShell.exit.Signal == syscall.SIGKILL.String() {
+		t.Error("restart fell back to SIGKILL")
+	}
+}
@@ -0,0 +1,212 @@
+package shellserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"shellserver/internal/ansi"
+	"shellserver/pkg/protocol"
+)
+
+// commandBlock is one command's output: from the integration's
+// command-start mark (OSC 133;C) to its finished mark (OSC 133;D), as
+// output offsets like the ?since= ones.
+type commandBlock struct {
+	ID       int       `json:"id"`
+	Command  string    `json:"command,omitempty"` // "" when the shell didn't say
+	Start    int64     `json:"start"`
+	End      int64     `json:"end,omitempty"` // 0 while running
+	ExitCode *int      `json:"exit_code,omitempty"`
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished,omitempty"`
+}
+
+// blockLog is the blocks whose output the scrollback still holds, oldest
+// first. It is guarded by bufferMu, as it follows the buffer's offsets.
+type blockLog struct {
+	last    int // ID of the newest block
+	blocks  []*commandBlock
+	open    *commandBlock // the running command's block
+	command string        // from the last command-line mark, for the next block
+}
+
+// blockEvent is a block starting or ending, for the clients.
+type blockEvent struct {
+	block *commandBlock
+	start bool
+}
+
+// mark advances the log over integration mark m, found at output offset
+// at, and returns the event it causes, if any.
+func (l *blockLog) mark(m integrationMark, at int64, now time.Time) (blockEvent, bool) {
+	if m.Payload == "133;C" {
+		if l.open != nil {
+			return blockEvent{}, false
+		}
+		l.last++
+		l.open = &commandBlock{ID: l.last, Command: l.command, Start: at, Started: now}
+		l.blocks = append(l.blocks, l.open)
+		l.command = ""
+		return blockEvent{block: l.open, start: true}, true
+	}
+	ev, ok := parseShellEvent(m.Payload)
+	switch {
+	case !ok:
+	case ev.Kind == "command":
+		l.command = ev.Command
+	case ev.Kind == "finished" && l.open != nil:
+		b := l.open
+		l.open = nil
+		code := ev.ExitCode
+		b.End, b.ExitCode, b.Finished = at, &code, now
+		return blockEvent{block: b}, true
+	}
+	return blockEvent{}, false
+}
+
+// abandon ends the running command's block without an exit code, as
+// when the shell it ran in is gone.
+func (l *blockLog) abandon(at int64, now time.Time) {
+	if l.open != nil {
+		l.open.End, l.open.Finished = at, now
+		l.open = nil
+	}
+}
+
+// trim moves a cut of the buffer at offset start that would split a
+// finished block to the end of that block, so a block is kept whole or
+// dropped whole, and forgets the blocks before it. It returns the new
+// cut. The running command's block can't wait, and loses its front.
+func (l *blockLog) trim(start int64) int64 {
+	for _, b := range l.blocks {
+		if b.End > 0 && b.Start < start && start < b.End {
+			start = b.End
+		}
+	}
+	i := 0
+	for i < len(l.blocks) && l.blocks[i].End > 0 && l.blocks[i].End <= start {
+		i++
+	}
+	l.blocks = l.blocks[i:]
+	return start
+}
+
+// get returns block id, and whether its output starts before start, the
+// buffer's first offset.
+func (l *blockLog) get(id int, start int64) (b commandBlock, truncated, ok bool) {
+	for _, b := range l.blocks {
+		if b.ID == id {
+			return *b, b.Start < start, true
+		}
+	}
+	return commandBlock{}, false, false
+}
+
+// appendBlockMarks records the blocks marks start and end, data having
+// just been appended to the buffer. bufferMu must be held.
+func (s *ShellServer) appendBlockMarks(data []byte, marks []integrationMark) []blockEvent {
+	var events []blockEvent
+	base := s.outputEnd - int64(len(data))
+	now := time.Now()
+	for _, m := range marks {
+		if ev, ok := s.blocks.mark(m, base+int64(m.Pos), now); ok {
+			events = append(events, ev)
+		}
+	}
+	return events
+}
+
+// broadcastBlockEvents tells clients about blocks starting and ending:
+// {"kind":"block","id":N,"event":"start","command"} and
+// {"kind":"block","id":N,"event":"end","exit":code}.
+func (s *ShellServer) broadcastBlockEvents(events []blockEvent) {
+	for _, ev := range events {
+		msg := map[string]any{"kind": "block", "id": ev.block.ID}
+		if ev.start {
+			msg["event"] = "start"
+			msg["command"] = ev.block.Command
+		} else {
+			msg["event"] = "end"
+			msg["exit"] = *ev.block.ExitCode
+		}
+		data, _ := json.Marshal(msg)
+		s.broadcastMessage(websocket.TextMessage, data)
+	}
+}
+
+// handleBlock serves GET /block/, the blocks the scrollback holds, and
+// GET /block/{id}, that command's output: ?format=raw (the default) as
+// the shell wrote it, or ?format=text without escape sequences. Output
+// from before the buffer's front, which only the running command's block
+// can lose, is missing, marked by X-Block-Truncated.
+func (s *ShellServer) handleBlock(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r, http.MethodGet)
+		return
+	}
+	rest := strings.TrimPrefix(r.URL.Path, "/block/")
+	if rest == "" {
+		s.bufferMu.Lock()
+		list := make([]commandBlock, 0, len(s.blocks.blocks))
+		for _, b := range s.blocks.blocks {
+			list = append(list, *b)
+		}
+		s.bufferMu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+		return
+	}
+	id, err := strconv.Atoi(rest)
+	if err != nil {
+		notFound(w, r)
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "raw" && format != "text" {
+		respondError(w, r, http.StatusBadRequest, protocol.ErrInvalidRequest, fmt.Sprintf("unknown format %q; use raw or text", format))
+		return
+	}
+
+	s.bufferMu.Lock()
+	start := s.outputEnd - int64(len(s.buffer))
+	b, truncated, ok := s.blocks.get(id, start)
+	var out []byte
+	if ok {
+		end := b.End
+		if end == 0 {
+			end = s.outputEnd
+		}
+		// Stripped HTML mode sequences can leave the buffer short of
+		// outputEnd; keep within it
+		lo := min(max(b.Start-start, 0), int64(len(s.buffer)))
+		hi := min(max(end-start, lo), int64(len(s.buffer)))
+		out = bytes.Clone(s.buffer[lo:hi])
+	}
+	s.bufferMu.Unlock()
+	if !ok {
+		respondError(w, r, http.StatusNotFound, protocol.ErrBlockNotFound, fmt.Sprintf("no block %d in the scrollback", id))
+		return
+	}
+
+	w.Header().Set("X-Block-Command", b.Command)
+	if b.ExitCode != nil {
+		w.Header().Set("X-Block-Exit-Code", strconv.Itoa(*b.ExitCode))
+	}
+	if truncated {
+		w.Header().Set("X-Block-Truncated", "1")
+	}
+	if format == "text" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write([]byte(strings.ReplaceAll(ansi.Strip(string(out)), "\r\n", "\n")))
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Write(out)
+}
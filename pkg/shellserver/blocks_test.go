@@ -0,0 +1,108 @@
+package shellserver
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"shellserver/internal/testshell"
+	"shellserver/pkg/protocol"
+)
+
+func getBlock(t *testing.T, url string) (*http.Response, string) {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return resp, string(body)
+}
+
+func TestBlocks(t *testing.T) {
+	_, ts := startFakeShellServer(t)
+	c := testshell.Dial(t, ts.URL, "")
+	c.Send("mark command ls -l")
+	c.Send("mark exec")
+	if ev := c.ExpectEvent("block", testshell.DefaultTimeout); ev["event"] != "start" || ev["command"] != "ls -l" || ev["id"] != 1.0 {
+		t.Errorf("start event = %v", ev)
+	}
+	c.Send(`raw "\x1b[1mfile-a\x1b[0m\n"`)
+	c.Send("mark finished 2")
+	if ev := c.ExpectEvent("block", testshell.DefaultTimeout); ev["event"] != "end" || ev["exit"] != 2.0 {
+		t.Errorf("end event = %v", ev)
+	}
+
+	resp, body := getBlock(t, ts.URL+"/block/")
+	var list []commandBlock
+	if err := json.Unmarshal([]byte(body), &list); err != nil {
+		t.Fatalf("GET /block/: %v: %s", err, body)
+	}
+	if len(list) != 1 || list[0].Command != "ls -l" || list[0].ExitCode == nil || *list[0].ExitCode != 2 || list[0].End <= list[0].Start {
+		t.Errorf("blocks = %s", body)
+	}
+
+	resp, body = getBlock(t, ts.URL+"/block/1")
+	if resp.StatusCode != http.StatusOK || !strings.Contains(body, "\x1b[1mfile-a\x1b[0m\r\n") {
+		t.Errorf("raw block: status %d %q", resp.StatusCode, body)
+	}
+	if strings.Contains(body, "\x1b]133;") {
+		t.Errorf("raw block has integration markers: %q", body)
+	}
+	if got := resp.Header.Get("X-Block-Exit-Code"); got != "2" {
+		t.Errorf("X-Block-Exit-Code = %q", got)
+	}
+	resp, body = getBlock(t, ts.URL+"/block/1?format=text")
+	if !strings.Contains(body, "file-a\n") || strings.Contains(body, "\x1b") {
+		t.Errorf("text block: %q", body)
+	}
+
+	for _, path := range []string{"/block/2", "/block/nope"} {
+		resp, err := http.Get(ts.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusNotFound {
+			t.Errorf("%s: status %d, want 404", path, resp.StatusCode)
+		}
+		if path == "/block/2" && errorCode(t, resp) != protocol.ErrBlockNotFound {
+			t.Errorf("%s: want %s", path, protocol.ErrBlockNotFound)
+		}
+		resp.Body.Close()
+	}
+}
+
+func TestBlocksTrimmedWhole(t *testing.T) {
+	s := newPumpTestServer()
+	s.scrollbackBytes = 0
+	s.scrollbackLines = 3
+	run := func(out, code string) {
+		s.appendToBuffer([]byte(out), []integrationMark{{Pos: 0, Payload: "133;C"}, {Pos: len(out), Payload: "133;D;" + code}}, true)
+		s.appendToBuffer([]byte("$ \r\n"), nil, true)
+	}
+	run("a1\r\na2\r\n", "0")
+	run("b1\r\n", "1")
+	// Keeping three lines would split the first block, so it goes whole
+	if got := string(s.buffer); got != "$ \r\nb1\r\n$ \r\n" {
+		t.Errorf("buffer = %q", got)
+	}
+	if len(s.blocks.blocks) != 1 || s.blocks.blocks[0].ID != 2 {
+		t.Errorf("blocks = %+v, want only the second", s.blocks.blocks)
+	}
+	if u := s.scrollbackUsage(); u.DroppedBytes != int64(len("a1\r\na2\r\n")) {
+		t.Errorf("dropped %d bytes", u.DroppedBytes)
+	}
+
+	// A block trimmed away is forgotten
+	s.scrollbackLines = 2
+	s.appendToBuffer([]byte("x\r\n"), nil, true)
+	if got := string(s.buffer); got != "$ \r\nx\r\n" {
+		t.Errorf("buffer = %q, want the second block gone", got)
+	}
+	if len(s.blocks.blocks) != 0 {
+		t.Errorf("blocks = %+v, want none", s.blocks.blocks)
+	}
+}
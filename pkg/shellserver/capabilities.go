@@ -0,0 +1,127 @@
+package shellserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strings"
+)
+
+// capabilities is the registry of optional features clients can rely on,
+// each read from the server's effective configuration. Values are bools,
+// ints or strings. To advertise a new feature, add one line here.
+var capabilities = map[string]func(s *ShellServer) any{
+	"widgets":           func(s *ShellServer) any { return !s.noWidgets },
+	"widget-store":      func(s *ShellServer) any { return storeKind(s.options.Store) },
+	"widget-limit":      func(s *ShellServer) any { return s.widgetLimit },
+	"widget-max-bytes":  func(s *ShellServer) any { return s.widgetMaxBytes },
+	"widget-patches":    func(s *ShellServer) any { return !s.noWidgets && s.widgetDiffRatio > 0 },
+	"widget-freshness":  func(s *ShellServer) any { return !s.noWidgets },
+	"widget-rate":       func(s *ShellServer) any { return widgetRate(s) },
+	"confirm-commands":  func(s *ShellServer) any { return s.confirmWidgetCmds },
+	"detached-commands": func(s *ShellServer) any { return true },
+	"exec":              func(s *ShellServer) any { return true },
+	"cwd":               func(s *ShellServer) any { return true },
+	"annotations":       func(s *ShellServer) any { return annotationMode(s) },
+	"resume":            func(s *ShellServer) any { return s.resumeGrace > 0 },
+	"observers":         func(s *ShellServer) any { return true },
+	"input-lock":        func(s *ShellServer) any { return !*flagNoInputLock },
+	"sessions":          func(s *ShellServer) any { return 1 },
+	"auth":              func(s *ShellServer) any { return authMode(s) },
+	"tls":               func(s *ShellServer) any { return *flagTLSCert != "" },
+	"tmpdir":            func(s *ShellServer) any { return s.sessionTmp != nil },
+	"rawmode":           func(s *ShellServer) any { return true },
+	"recordings":        func(s *ShellServer) any { return s.recordDir != "" },
+	"persist-session":   func(s *ShellServer) any { return s.persistDir != "" },
+	"record":            func(s *ShellServer) any { return true },
+	"session-usage":     func(s *ShellServer) any { return s.cgroup.source() },
+	"predictive-echo":   func(s *ShellServer) any { return true },
+	"profiles":          func(s *ShellServer) any { return len(s.settings().config.profiles()) },
+	"files":             func(s *ShellServer) any { return s.fileShares != nil },
+	"download":          func(s *ShellServer) any { return true },
+	"clipboard":         func(s *ShellServer) any { return s.clipboard.limit },
+	"trace":             func(s *ShellServer) any { return true },
+}
+
+// capabilities returns the capabilities as of startup or the last reload.
+func (s *ShellServer) capabilities() map[string]any {
+	if caps := s.caps.Load(); caps != nil {
+		return *caps
+	}
+	return s.collectCapabilities()
+}
+
+// collectCapabilities evaluates the registry against s.
+func (s *ShellServer) collectCapabilities() map[string]any {
+	caps := make(map[string]any, len(capabilities))
+	for name, value := range capabilities {
+		caps[name] = value(s)
+	}
+	return caps
+}
+
+// storeKind is the backend named by a -store value.
+func storeKind(spec string) string {
+	kind, _, _ := strings.Cut(spec, ":")
+	return kind
+}
+
+// annotationMode summarises the slow-command annotation flags.
+func annotationMode(s *ShellServer) string {
+	switch {
+	case s.annotateMin <= 0:
+		return "off"
+	case s.annotateInject:
+		return "inject"
+	default:
+		return "events"
+	}
+}
+
+// capabilitySummary renders caps as sorted name=value pairs for the
+// startup banner.
+func capabilitySummary(caps map[string]any) string {
+	names := make([]string, 0, len(caps))
+	for name := range caps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = fmt.Sprintf("%s=%v", name, caps[name])
+	}
+	return strings.Join(pairs, " ")
+}
+
+// buildVersion is the module version and VCS revision the binary was
+// built from, as far as the build recorded them.
+func buildVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	version := info.Main.Version
+	for _, setting := range info.Settings {
+		if setting.Key == "vcs.revision" && len(setting.Value) >= 12 {
+			version += " " + setting.Value[:12]
+		}
+	}
+	return version
+}
+
+// handleVersion serves GET /version.
+func (s *ShellServer) handleVersion(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r, http.MethodGet)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"version":      buildVersion(),
+		"go":           runtime.Version(),
+		"capabilities": s.capabilities(),
+	})
+}
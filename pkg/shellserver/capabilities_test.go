@@ -0,0 +1,103 @@
+package shellserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"shellserver/internal/testshell"
+)
+
+func TestCapabilitiesFollowFlags(t *testing.T) {
+	oldWidgets, oldConfirm, oldMin, oldInject := *flagWidgets, *flagConfirmWidgetCmds, *flagAnnotateMin, *flagAnnotateInject
+	t.Cleanup(func() {
+		*flagWidgets, *flagConfirmWidgetCmds, *flagAnnotateMin, *flagAnnotateInject = oldWidgets, oldConfirm, oldMin, oldInject
+	})
+
+	tests := []struct {
+		name    string
+		widgets bool
+		confirm bool
+		min     time.Duration
+		inject  bool
+		want    map[string]any
+	}{
+		{"defaults", true, true, 0, false, map[string]any{
+			"widgets": true, "widget-patches": true, "widget-freshness": true,
+			"confirm-commands": true, "annotations": "off", "widget-rate": "20/10s",
+		}},
+		{"-widgets=false", false, true, 0, false, map[string]any{
+			"widgets": false, "widget-patches": false, "widget-freshness": false,
+		}},
+		{"-confirm-widget-commands=false -annotate-inject", true, false, time.Second, true, map[string]any{
+			"confirm-commands": false, "annotations": "inject",
+		}},
+		{"annotation events only", true, true, time.Second, false, map[string]any{
+			"annotations": "events",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			*flagWidgets, *flagConfirmWidgetCmds, *flagAnnotateMin, *flagAnnotateInject = tt.widgets, tt.confirm, tt.min, tt.inject
+			s, _ := startFakeShellServer(t)
+			for name, want := range tt.want {
+				if got := s.capabilities()[name]; got != want {
+					t.Errorf("%s = %v, want %v", name, got, want)
+				}
+			}
+			if s.capabilities()["widget-store"] != "memory" || s.capabilities()["sessions"] != 1 || s.capabilities()["auth"] != "none" {
+				t.Errorf("fixed capabilities wrong: %v", s.capabilities())
+			}
+		})
+	}
+}
+
+func TestCapabilitiesAdvertised(t *testing.T) {
+	s, ts := startFakeShellServer(t)
+
+	// ints arrive from JSON as float64
+	want := make(map[string]any)
+	data, _ := json.Marshal(s.capabilities())
+	json.Unmarshal(data, &want)
+
+	c := testshell.Dial(t, ts.URL, "")
+	if got := c.Ready["capabilities"]; !reflect.DeepEqual(got, want) {
+		t.Errorf("ready capabilities = %v, want %v", got, want)
+	}
+
+	resp, err := http.Get(ts.URL + "/version")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var version struct {
+		Version      string
+		Go           string
+		Capabilities map[string]any
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&version); err != nil {
+		t.Fatal(err)
+	}
+	if version.Version == "" || version.Go == "" || !reflect.DeepEqual(version.Capabilities, want) {
+		t.Errorf("/version = %+v, want capabilities %v", version, want)
+	}
+}
+
+func TestWidgetsDisabledLeavesHTMLInStream(t *testing.T) {
+	old := *flagWidgets
+	*flagWidgets = false
+	t.Cleanup(func() { *flagWidgets = old })
+
+	s, ts := startFakeShellServer(t)
+	c := testshell.Dial(t, ts.URL, "")
+	c.Send("mark html-start")
+	c.ExpectOutput(testshell.Markers["html-start"], testshell.DefaultTimeout)
+	c.Send("echo <b>inline</b>")
+	c.Send("mark html-end")
+	c.ExpectOutput(testshell.Markers["html-end"], testshell.DefaultTimeout)
+	if ids := s.widgetIDs(); len(ids) != 0 {
+		t.Errorf("widgets %v stored with -widgets=false", ids)
+	}
+}
@@ -0,0 +1,200 @@
+package shellserver
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+var (
+	flagResumeGrace = Flags.Duration("resume-grace", 30*time.Second, "how long a disconnected client's resume token stays valid")
+	flagMaxClients  = Flags.Int("max-clients", 32, "most websocket clients connected at once, observers included (0 for no limit)")
+)
+
+// clientCounts is the "clients" object in /status.
+type clientCounts struct {
+	Connected int `json:"connected"`
+	Max       int `json:"max"` // 0 for no limit
+}
+
+// client is one logical websocket client. A reconnect that presents the
+// resume token from the previous ready message keeps the same client
+// (ID and role) instead of starting a fresh one.
+type client struct {
+	id          string
+	conn        *websocket.Conn
+	out         *writePump  // conn's writes; guarded by clientsMu like conn
+	readOnly    bool        // observers can watch but not type
+	resumeToken string      // token handed out in the latest ready message
+	trace       atomic.Bool // time this client's input for /debug/latency
+	offsets     atomic.Bool // send an offset message after each output
+	size        termSize    // the terminal size it last asked for; guarded by clientsMu
+	info        connInfo    // its current connection; guarded by clientsMu
+
+	macro *macroRecorder // input being recorded; used only by the client's read loop
+}
+
+// connInfo describes the connection a client is on.
+type connInfo struct {
+	Remote    string    `json:"remote"` // the peer's IP address
+	UserAgent string    `json:"user_agent"`
+	Connected time.Time `json:"connected"`
+}
+
+// newConnInfo describes the connection r is upgrading, made at now.
+func newConnInfo(r *http.Request, now time.Time) connInfo {
+	remote, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		remote = r.RemoteAddr
+	}
+	return connInfo{Remote: remote, UserAgent: r.UserAgent(), Connected: now}
+}
+
+// detachedClient is a disconnected client waiting to be resumed.
+type detachedClient struct {
+	client *client
+	timer  *time.Timer
+}
+
+func newResumeToken() string {
+	var raw [16]byte
+	rand.Read(raw[:])
+	return hex.EncodeToString(raw[:])
+}
+
+// registerClient adds conn, described by info and written through out,
+// to the client set. If
+// resumeToken names a client detached within the grace period, that
+// client is taken over; otherwise a new client is created with the
+// requested role. It returns nil, registering nothing, if -max-clients
+// are connected already.
+func (s *ShellServer) registerClient(conn *websocket.Conn, out *writePump, info connInfo, resumeToken string, readOnly bool) *client {
+	s.clientsMu.Lock()
+	defer s.clientsMu.Unlock()
+	if s.maxClients > 0 && len(s.clients) >= s.maxClients {
+		return nil
+	}
+
+	var c *client
+	if d, ok := s.detached[resumeToken]; ok && resumeToken != "" {
+		d.timer.Stop()
+		delete(s.detached, resumeToken)
+		c = d.client
+	} else {
+		s.clientCounter++
+		c = &client{
+			id:       fmt.Sprintf("client-%d", s.clientCounter),
+			readOnly: readOnly,
+		}
+	}
+
+	c.conn, c.out, c.info = conn, out, info
+	c.resumeToken = newResumeToken()
+	s.clients[conn] = c
+	return c
+}
+
+// detachClient removes conn from the client set and keeps its logical
+// client resumable for the grace period. It returns conn's write pump
+// and what connection it was, or nil if conn wasn't registered.
+func (s *ShellServer) detachClient(conn *websocket.Conn) (*writePump, connInfo) {
+	s.clientsMu.Lock()
+	defer s.clientsMu.Unlock()
+
+	c, ok := s.clients[conn]
+	if !ok {
+		return nil, connInfo{}
+	}
+	delete(s.clients, conn)
+	out := c.out
+	c.conn, c.out = nil, nil
+
+	token := c.resumeToken
+	s.detached[token] = &detachedClient{
+		client: c,
+		timer: time.AfterFunc(s.resumeGrace, func() {
+			s.clientsMu.Lock()
+			d, ok := s.detached[token]
+			if ok && d.client == c {
+				delete(s.detached, token)
+			}
+			s.clientsMu.Unlock()
+			if ok {
+				s.releaseClient(c)
+			}
+		}),
+	}
+	return out, c.info
+}
+
+// releaseClient is called once a client is gone for good: disconnected
+// and not resumed within the grace period.
+func (s *ShellServer) releaseClient(c *client) {
+	log.Printf("client %s released", c.id)
+}
+
+// clientCounts reports how many clients are connected, and the limit.
+func (s *ShellServer) clientCounts() clientCounts {
+	s.clientsMu.RLock()
+	defer s.clientsMu.RUnlock()
+	return clientCounts{Connected: len(s.clients), Max: s.maxClients}
+}
+
+// clientEntry is one connected client in GET /clients.
+type clientEntry struct {
+	ID string `json:"client_id"`
+	connInfo
+	ReadOnly bool `json:"read_only"`
+}
+
+// clientList returns the connected clients, in the order they connected.
+func (s *ShellServer) clientList() []clientEntry {
+	s.clientsMu.RLock()
+	list := make([]clientEntry, 0, len(s.clients))
+	for _, c := range s.clients {
+		list = append(list, clientEntry{ID: c.id, connInfo: c.info, ReadOnly: c.readOnly})
+	}
+	s.clientsMu.RUnlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].Connected.Before(list[j].Connected) })
+	return list
+}
+
+// handleClients serves GET /clients, the connected clients as a JSON
+// array of clientEntry.
+func (s *ShellServer) handleClients(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r, http.MethodGet)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.clientList())
+}
+
+// broadcastPresence tells every client but c that c joined or left, with
+// how many clients are connected now. It must be called without
+// clientsMu held.
+func (s *ShellServer) broadcastPresence(event string, c *client, info connInfo) {
+	s.clientsMu.RLock()
+	count := len(s.clients)
+	s.clientsMu.RUnlock()
+	data, _ := json.Marshal(map[string]any{"kind": "clients", "event": event, "count": count, "client_id": c.id, "remote": info.Remote})
+	for _, out := range s.clientPumps(func(other *client) bool { return other != c }) {
+		out.send(outbound{msgType: websocket.TextMessage, data: data})
+	}
+}
+
+// clientFor returns the client registered for conn, or nil.
+func (s *ShellServer) clientFor(conn *websocket.Conn) *client {
+	s.clientsMu.RLock()
+	defer s.clientsMu.RUnlock()
+	return s.clients[conn]
+}
@@ -0,0 +1,232 @@
+package shellserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"shellserver/internal/testshell"
+)
+
+type readyMessage struct {
+	Kind        string `json:"kind"`
+	ClientID    string `json:"client_id"`
+	Role        string `json:"role"`
+	ResumeToken string `json:"resume_token"`
+}
+
+// dialShell connects to the server's websocket with the given query string
+// and returns the connection and its ready message.
+func dialShell(t *testing.T, ts *httptest.Server, query string) (*websocket.Conn, readyMessage) {
+	t.Helper()
+	url := "ws://" + strings.TrimPrefix(ts.URL, "http://") + "/ws/shell" + query
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		msgType, data, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("waiting for ready: %v", err)
+		}
+		if msgType != websocket.TextMessage {
+			continue
+		}
+		var ready readyMessage
+		if json.Unmarshal(data, &ready) == nil && ready.Kind == "ready" {
+			conn.SetReadDeadline(time.Time{})
+			return conn, ready
+		}
+	}
+}
+
+// waitDetached waits until the server has noticed n clients disconnecting.
+func waitDetached(t *testing.T, s *ShellServer, n int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		s.clientsMu.RLock()
+		got := len(s.detached)
+		s.clientsMu.RUnlock()
+		if got == n {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("detached clients never reached %d", n)
+}
+
+func newResumeTestServer(t *testing.T, grace time.Duration) (*ShellServer, *httptest.Server, <-chan string) {
+	t.Helper()
+	s, lines := newPipeServer(t)
+	s.resumeGrace = grace
+	ts := httptest.NewServer(http.HandlerFunc(s.handleWebSocket))
+	t.Cleanup(ts.Close)
+	return s, ts, lines
+}
+
+func TestResumeWithinGrace(t *testing.T) {
+	s, ts, _ := newResumeTestServer(t, time.Minute)
+
+	conn, first := dialShell(t, ts, "?role=observer")
+	if first.ClientID == "" || first.ResumeToken == "" || first.Role != "observer" {
+		t.Fatalf("ready = %+v", first)
+	}
+	conn.Close()
+	waitDetached(t, s, 1)
+
+	conn, second := dialShell(t, ts, "?resume="+first.ResumeToken)
+	defer conn.Close()
+	if second.ClientID != first.ClientID {
+		t.Errorf("client id = %q, want resumed %q", second.ClientID, first.ClientID)
+	}
+	if second.Role != "observer" {
+		t.Errorf("role = %q, want observer kept across resume", second.Role)
+	}
+	if second.ResumeToken == first.ResumeToken {
+		t.Error("resume token was not rotated")
+	}
+	waitDetached(t, s, 0)
+
+	// The old token is single-use.
+	other, third := dialShell(t, ts, "?resume="+first.ResumeToken)
+	defer other.Close()
+	if third.ClientID == first.ClientID {
+		t.Error("stale resume token took over the client a second time")
+	}
+}
+
+func TestResumeAfterGrace(t *testing.T) {
+	s, ts, _ := newResumeTestServer(t, 100*time.Millisecond)
+
+	conn, first := dialShell(t, ts, "")
+	conn.Close()
+	waitDetached(t, s, 1)
+	waitDetached(t, s, 0) // released when the grace period ends
+
+	conn, second := dialShell(t, ts, "?resume="+first.ResumeToken)
+	defer conn.Close()
+	if second.ClientID == first.ClientID {
+		t.Errorf("client %q resumed after the grace period", first.ClientID)
+	}
+	if second.Role != "writer" {
+		t.Errorf("role = %q, want writer for a fresh client", second.Role)
+	}
+}
+
+func TestObserverInputIgnored(t *testing.T) {
+	_, ts, lines := newResumeTestServer(t, time.Minute)
+
+	observer, _ := dialShell(t, ts, "?role=observer")
+	defer observer.Close()
+	observer.WriteMessage(websocket.TextMessage, []byte("echo observer\n"))
+
+	writer, _ := dialShell(t, ts, "")
+	defer writer.Close()
+	writer.WriteMessage(websocket.TextMessage, []byte("echo writer\n"))
+
+	if line, ok := readPTYLine(t, lines, time.Second); !ok || line != "echo writer\n" {
+		t.Errorf("shell received %q, want only the writer's input", line)
+	}
+}
+
+func TestMaxClients(t *testing.T) {
+	s, ts := startFakeShellServer(t)
+	s.maxClients = 3
+	url := "ws" + strings.TrimPrefix(ts.URL, "http") + "/ws/shell"
+
+	// Dialed at once, only the limit get in
+	results := make(chan bool, 6)
+	var conns []*websocket.Conn
+	var mu sync.Mutex
+	for i := 0; i < cap(results); i++ {
+		go func() {
+			conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+			if err != nil {
+				results <- false
+				return
+			}
+			mu.Lock()
+			conns = append(conns, conn)
+			mu.Unlock()
+			conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			for {
+				_, data, err := conn.ReadMessage()
+				if err != nil {
+					if !websocket.IsCloseError(err, websocket.CloseTryAgainLater) {
+						t.Errorf("refused client closed with %v, want %d", err, websocket.CloseTryAgainLater)
+					}
+					results <- false
+					return
+				}
+				var msg map[string]any
+				json.Unmarshal(data, &msg)
+				switch msg["kind"] {
+				case "ready":
+					results <- true
+					return
+				case "error":
+					if msg["reason"] != "too many clients" {
+						t.Errorf("error message = %v", msg)
+					}
+				}
+			}
+		}()
+	}
+	admitted := 0
+	for i := 0; i < cap(results); i++ {
+		if <-results {
+			admitted++
+		}
+	}
+	t.Cleanup(func() {
+		for _, conn := range conns {
+			conn.Close()
+		}
+	})
+	if admitted != s.maxClients {
+		t.Errorf("%d clients admitted, want %d", admitted, s.maxClients)
+	}
+	if st := getStatus(t, ts.URL); st.Clients != (clientCounts{Connected: 3, Max: 3}) {
+		t.Errorf("/status clients = %+v", st.Clients)
+	}
+}
+
+func TestClientPresence(t *testing.T) {
+	_, ts := startFakeShellServer(t)
+	c := testshell.Dial(t, ts.URL, "")
+
+	conn, ready := dialShell(t, ts, "?role=observer")
+	ev := c.ExpectEvent("clients", testshell.DefaultTimeout)
+	if ev["event"] != "joined" || ev["count"] != float64(2) || ev["remote"] != "127.0.0.1" || ev["client_id"] != ready.ClientID {
+		t.Errorf("join event = %v", ev)
+	}
+
+	resp, err := http.Get(ts.URL + "/clients")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var list []clientEntry
+	json.NewDecoder(resp.Body).Decode(&list)
+	resp.Body.Close()
+	if len(list) != 2 || list[0].ID != c.Ready["client_id"] || list[1].ID != ready.ClientID {
+		t.Fatalf("/clients = %+v, want the writer then the observer", list)
+	}
+	if list[0].ReadOnly || !list[1].ReadOnly || list[1].Remote != "127.0.0.1" || list[1].Connected.IsZero() {
+		t.Errorf("/clients = %+v", list)
+	}
+
+	conn.Close()
+	ev = c.ExpectEvent("clients", testshell.DefaultTimeout)
+	if ev["event"] != "left" || ev["count"] != float64(1) || ev["client_id"] != ready.ClientID {
+		t.Errorf("leave event = %v", ev)
+	}
+}
@@ -0,0 +1,143 @@
+package shellserver
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"log"
+
+	"github.com/gorilla/websocket"
+)
+
+var flagClipboardLimit = byteSizeFlag(1 << 20)
+
+func init() {
+	Flags.Var(&flagClipboardLimit, "clipboard-limit", "largest clipboard copy, such as 64K, a program may send clients with OSC 52; bigger ones are dropped (0 leaves OSC 52 in the output untouched)")
+}
+
+// osc52Prefix starts an OSC 52 clipboard sequence:
+// ESC ] 52 ; <selection> ; <base64> BEL (or ST).
+var osc52Prefix = []byte("\x1b]52;")
+
+// clipboardScanner takes OSC 52 clipboard writes out of PTY output, so
+// clients get them as clipboard messages and not as terminal bytes. A
+// sequence split across reads is held back until its end arrives.
+type clipboardScanner struct {
+	limit    int    // largest decoded copy; 0 disables the scanner
+	pending  []byte // an incomplete sequence from the end of the last read
+	skipping bool   // inside a sequence over the limit, dropped as it arrives
+}
+
+// scan returns data, after what was pending, without its OSC 52
+// sequences, and the text each one copied. Queries ("?") and copies
+// over the limit are dropped without a copy.
+func (c *clipboardScanner) scan(data []byte) (out []byte, copies []string) {
+	if c.limit <= 0 || len(c.pending) == 0 && !c.skipping && bytes.IndexByte(data, 0x1b) < 0 {
+		return data, nil
+	}
+	if len(c.pending) > 0 {
+		data = append(c.pending, data...)
+		c.pending = nil
+	}
+	maxSeq := base64.StdEncoding.EncodedLen(c.limit) + 16 // room for the selection
+	for {
+		if c.skipping {
+			end, n, _ := oscEnd(data)
+			if end < 0 {
+				return out, copies
+			}
+			c.skipping = false
+			data = data[end+n:]
+			continue
+		}
+		i := bytes.Index(data, osc52Prefix)
+		if i < 0 {
+			// The prefix itself may be cut off at the end
+			keep := partialPrefix(data, osc52Prefix)
+			c.pending = append(c.pending, data[len(data)-keep:]...)
+			return append(out, data[:len(data)-keep]...), copies
+		}
+		out = append(out, data[:i]...)
+		body := data[i+len(osc52Prefix):]
+		end, n, ok := oscEnd(body)
+		if end < 0 {
+			if len(body) > maxSeq {
+				log.Printf("clipboard: dropping an OSC 52 copy over -clipboard-limit %v", &flagClipboardLimit)
+				c.skipping = true
+				return out, copies
+			}
+			c.pending = append(c.pending, data[i:]...)
+			return out, copies
+		}
+		if ok && end <= maxSeq {
+			if text, valid := decodeOSC52(body[:end], c.limit); valid {
+				copies = append(copies, text)
+			}
+		}
+		data = body[end+n:]
+	}
+}
+
+// reset forgets any partial sequence, returning its bytes.
+func (c *clipboardScanner) reset() []byte {
+	pending := c.pending
+	c.pending, c.skipping = nil, false
+	return pending
+}
+
+// oscEnd finds the end of an OSC sequence's body: the offset of its BEL
+// or ST terminator and the terminator's length, or -1 if it hasn't
+// arrived. An ESC that doesn't start ST aborts the sequence, which ends
+// there without a terminator and reports false.
+func oscEnd(body []byte) (end, n int, ok bool) {
+	for i, b := range body {
+		switch b {
+		case 0x07:
+			return i, 1, true
+		case 0x1b:
+			if i+1 == len(body) {
+				return -1, 0, false
+			}
+			if body[i+1] == '\\' {
+				return i, 2, true
+			}
+			return i, 0, false
+		}
+	}
+	return -1, 0, false
+}
+
+// partialPrefix returns the length of the longest proper prefix of
+// prefix that data ends with. Holding back even a lone ESC costs
+// nothing: a terminal can't act on it before the next byte either.
+func partialPrefix(data, prefix []byte) int {
+	for n := min(len(prefix)-1, len(data)); n >= 1; n-- {
+		if bytes.HasSuffix(data, prefix[:n]) {
+			return n
+		}
+	}
+	return 0
+}
+
+// decodeOSC52 returns the text an OSC 52 body, <selection>;<base64>,
+// copies, if it is a copy of at most limit bytes.
+func decodeOSC52(body []byte, limit int) (string, bool) {
+	_, payload, found := bytes.Cut(body, []byte(";"))
+	if !found || string(payload) == "?" {
+		return "", false
+	}
+	text, err := base64.StdEncoding.DecodeString(string(payload))
+	if err != nil || len(text) > limit {
+		return "", false
+	}
+	return string(text), true
+}
+
+// broadcastClipboard sends each copy to every client as
+// {"kind":"clipboard","data":...}.
+func (s *ShellServer) broadcastClipboard(copies []string) {
+	for _, text := range copies {
+		data, _ := json.Marshal(map[string]string{"kind": "clipboard", "data": text})
+		s.broadcastMessage(websocket.TextMessage, data)
+	}
+}
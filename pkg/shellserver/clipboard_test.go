@@ -0,0 +1,63 @@
+package shellserver
+
+import (
+	"encoding/base64"
+	"strconv"
+	"strings"
+	"testing"
+
+	"shellserver/internal/testshell"
+)
+
+func osc52(text, end string) string {
+	return "\x1b]52;c;" + base64.StdEncoding.EncodeToString([]byte(text)) + end
+}
+
+func TestClipboardScanner(t *testing.T) {
+	big := strings.Repeat("x", 100)
+	for _, tc := range []struct {
+		name, in, out string
+		copies        []string
+	}{
+		{"bel", "a" + osc52("hello", "\x07") + "b", "ab", []string{"hello"}},
+		{"st", osc52("héllo", "\x1b\\") + "\x1b[1m", "\x1b[1m", []string{"héllo"}},
+		{"two", osc52("1", "\x07") + osc52("2", "\x07"), "", []string{"1", "2"}},
+		{"query", "\x1b]52;c;?\x07x", "x", nil},
+		{"bad base64", "\x1b]52;c;!!\x07x", "x", nil},
+		{"over the limit", osc52(big, "\x07") + "x", "x", nil},
+		{"aborted", "\x1b]52;c;aGk=\x1b[0mx", "\x1b[0mx", nil},
+		{"other osc", "\x1b]0;title\x07", "\x1b]0;title\x07", nil},
+	} {
+		// Split the input at every point, as reads from the PTY may
+		for cut := 0; cut <= len(tc.in); cut++ {
+			c := clipboardScanner{limit: 64}
+			out1, copies1 := c.scan([]byte(tc.in[:cut]))
+			out := string(out1)
+			out2, copies2 := c.scan([]byte(tc.in[cut:]))
+			out += string(out2)
+			copies := append(copies1, copies2...)
+			if out != tc.out || strings.Join(copies, "|") != strings.Join(tc.copies, "|") {
+				t.Errorf("%s, cut at %d: out %q, copies %q; want %q, %q", tc.name, cut, out, copies, tc.out, tc.copies)
+			}
+		}
+	}
+}
+
+func TestClipboardScannerOff(t *testing.T) {
+	c := clipboardScanner{}
+	in := osc52("hello", "\x07")
+	if out, copies := c.scan([]byte(in)); string(out) != in || copies != nil {
+		t.Errorf("with no limit: out %q, copies %q; want the sequence untouched", out, copies)
+	}
+}
+
+func TestClipboardForwarded(t *testing.T) {
+	_, ts := startFakeShellServer(t)
+	c := testshell.Dial(t, ts.URL, "")
+
+	c.Send("raw " + strconv.Quote("before"+osc52("copied text", "\x07")+"after\n"))
+	if ev := c.ExpectEvent("clipboard", testshell.DefaultTimeout); ev["data"] != "copied text" {
+		t.Errorf("clipboard event = %v", ev)
+	}
+	c.ExpectOutput("\r\nbeforeafter\r\n", testshell.DefaultTimeout)
+}
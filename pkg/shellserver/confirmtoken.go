@@ -0,0 +1,60 @@
+package shellserver
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var errConfirmExpired = errors.New("confirmation expired")
+
+// confirmSigner issues and checks the tokens that name held
+// confirmations in /confirm/{token}: "<id>.<expiry>.<mac>", where the
+// MAC is an HMAC-SHA256 of the ID and expiry (Unix milliseconds) under a key
+// made when the server starts. A token can't be forged, or its expiry
+// extended, and none survive a server restart. Tokens are single use
+// because the confirmation they name is forgotten once answered.
+type confirmSigner struct {
+	key []byte
+}
+
+func newConfirmSigner() *confirmSigner {
+	key := make([]byte, 32)
+	rand.Read(key)
+	return &confirmSigner{key: key}
+}
+
+func (cs *confirmSigner) mac(id string, expiry int64) string {
+	h := hmac.New(sha256.New, cs.key)
+	h.Write([]byte(id + "." + strconv.FormatInt(expiry, 10)))
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil))
+}
+
+// issue returns a token for the confirmation id, valid until expires.
+func (cs *confirmSigner) issue(id string, expires time.Time) string {
+	expiry := expires.UnixMilli()
+	return id + "." + strconv.FormatInt(expiry, 10) + "." + cs.mac(id, expiry)
+}
+
+// verify returns the confirmation ID token names. It fails with
+// errUnknownConfirm for a token this signer didn't issue and
+// errConfirmExpired for one issued for a time before now.
+func (cs *confirmSigner) verify(token string, now time.Time) (string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", errUnknownConfirm
+	}
+	expiry, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || !hmac.Equal([]byte(parts[2]), []byte(cs.mac(parts[0], expiry))) {
+		return "", errUnknownConfirm
+	}
+	if now.UnixMilli() > expiry {
+		return "", errConfirmExpired
+	}
+	return parts[0], nil
+}
@@ -0,0 +1,39 @@
+package shellserver
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConfirmToken(t *testing.T) {
+	cs := newConfirmSigner()
+	now := time.Now()
+	token := cs.issue("abc123", now.Add(time.Minute))
+
+	if id, err := cs.verify(token, now); err != nil || id != "abc123" {
+		t.Fatalf("verify = %q, %v; want abc123", id, err)
+	}
+	if _, err := cs.verify(token, now.Add(2*time.Minute)); err != errConfirmExpired {
+		t.Errorf("after expiry: %v, want %v", err, errConfirmExpired)
+	}
+
+	parts := strings.Split(token, ".")
+	later := cs.issue("abc123", now.Add(time.Hour))
+	for name, forged := range map[string]string{
+		"other ID":       "abc124." + parts[1] + "." + parts[2],
+		"later expiry":   parts[0] + "." + strings.Split(later, ".")[1] + "." + parts[2],
+		"bad MAC":        parts[0] + "." + parts[1] + ".AAAA",
+		"other signer":   newConfirmSigner().issue("abc123", now.Add(time.Minute)),
+		"no MAC":         parts[0] + "." + parts[1],
+		"extra part":     token + ".x",
+		"non-numeric":    parts[0] + ".soon." + parts[2],
+		"empty":          "",
+		"bare ID":        "abc123",
+		"expired forged": parts[0] + ".0." + parts[2],
+	} {
+		if _, err := cs.verify(forged, now); err != errUnknownConfirm {
+			t.Errorf("%s: verify(%q) = %v, want %v", name, forged, err, errUnknownConfirm)
+		}
+	}
+}
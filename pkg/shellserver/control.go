@@ -0,0 +1,134 @@
+package shellserver
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// controlMessage is a JSON text frame sent by a client on /ws/shell.
+type controlMessage struct {
+	Kind    string `json:"kind"`
+	ID      string `json:"id,omitempty"`
+	Approve bool   `json:"approve,omitempty"`
+	Enabled bool   `json:"enabled,omitempty"`
+	Rows    int    `json:"rows,omitempty"`
+	Cols    int    `json:"cols,omitempty"`
+	Data    string `json:"data,omitempty"` // text to paste
+
+	// Macros, and the signal to send
+	Name    string  `json:"name,omitempty"`
+	Speed   float64 `json:"speed,omitempty"`
+	Instant bool    `json:"instant,omitempty"`
+}
+
+// parseControlFrame decodes a websocket frame as a control message. Control
+// messages are text frames holding a JSON object with a "kind"; anything
+// else is terminal input.
+func parseControlFrame(msgType int, data []byte) (controlMessage, bool) {
+	var msg controlMessage
+	if msgType != websocket.TextMessage || len(data) == 0 || data[0] != '{' {
+		return msg, false
+	}
+	if err := json.Unmarshal(data, &msg); err != nil || msg.Kind == "" {
+		return msg, false
+	}
+	return msg, true
+}
+
+// handleControlMessage applies a control message received from conn.
+func (s *ShellServer) handleControlMessage(conn *websocket.Conn, msg controlMessage) {
+	switch msg.Kind {
+	case "confirm-reply":
+		if err := s.resolveConfirm(msg.ID, msg.Approve); err != nil {
+			log.Printf("confirm-reply %s: %v", msg.ID, err)
+		}
+	case "seen":
+		s.markSeen()
+	case "rawmode":
+		if c := s.clientFor(conn); c == nil || c.readOnly {
+			log.Printf("rawmode: ignored from an observer")
+			return
+		}
+		s.setRawMode(msg.Enabled)
+	case "take-control":
+		c := s.clientFor(conn)
+		if c == nil || c.readOnly {
+			log.Printf("take-control: ignored from an observer")
+			return
+		}
+		s.takeInput(c)
+	case "resize":
+		c := s.clientFor(conn)
+		if c == nil || c.readOnly {
+			log.Printf("resize: ignored from an observer")
+			return
+		}
+		size := termSize{msg.Rows, msg.Cols}
+		if !size.valid() {
+			log.Printf("resize: %dx%d out of range", msg.Rows, msg.Cols)
+			return
+		}
+		s.resizeSoon(c, size)
+	case "signal", "eof":
+		c := s.clientFor(conn)
+		if c == nil || c.readOnly {
+			log.Printf("%s: ignored from an observer", msg.Kind)
+			return
+		}
+		var err error
+		if msg.Kind == "signal" {
+			err = s.signalForeground(msg.Name)
+		} else {
+			err = s.sendEOF()
+		}
+		if err != nil {
+			log.Printf("%s: %v", msg.Kind, err)
+		}
+		s.sendStatus(c)
+	case "paste":
+		c := s.clientFor(conn)
+		if c == nil || c.readOnly || !s.claimInput(c) {
+			return
+		}
+		data, err := s.paste(msg.Data)
+		if err != nil {
+			log.Printf("paste: %v", err)
+			return
+		}
+		if c.macro != nil {
+			c.macro.add(data, time.Now())
+		}
+	case "trace":
+		if c := s.clientFor(conn); c != nil {
+			c.trace.Store(msg.Enabled)
+		}
+	case "macro-record", "macro-stop":
+		c := s.clientFor(conn)
+		if c == nil || c.readOnly {
+			log.Printf("%s: ignored from an observer", msg.Kind)
+			return
+		}
+		var err error
+		if msg.Kind == "macro-record" {
+			err = s.startMacroRecording(c, msg.Name)
+		} else {
+			err = s.stopMacroRecording(c)
+		}
+		if err != nil {
+			log.Printf("%s: %v", msg.Kind, err)
+		}
+	case "macro-play":
+		if c := s.clientFor(conn); c == nil || c.readOnly {
+			log.Printf("macro-play: ignored from an observer")
+			return
+		}
+		if err := s.playMacro(msg.Name, msg.Speed, msg.Instant); err != nil {
+			log.Printf("macro-play %s: %v", msg.Name, err)
+		}
+	default:
+		log.Printf("unknown control message kind %q", msg.Kind)
+	}
+}
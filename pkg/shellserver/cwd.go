@@ -0,0 +1,98 @@
+package shellserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+// cwdTracker caches the shell's working directory, as monitorStatus
+// last found it.
+type cwdTracker struct {
+	mu   sync.Mutex
+	path string // "" until it's known
+	osc  string // from the shell's last OSC 7, for when /proc can't say
+}
+
+// procCwd reads /proc/<pid>/cwd. The process can exit, or become a
+// zombie, between finding it and reading its entry, so a failure is
+// only a reason to try elsewhere.
+func procCwd(pid int) (string, bool) {
+	if pid <= 0 {
+		return "", false
+	}
+	path, err := os.Readlink("/proc/" + strconv.Itoa(pid) + "/cwd")
+	return path, err == nil
+}
+
+// noteOSCCwd records the directory the shell reported with OSC 7.
+func (s *ShellServer) noteOSCCwd(path string) {
+	s.cwd.mu.Lock()
+	s.cwd.osc = path
+	s.cwd.mu.Unlock()
+}
+
+// checkCwd works out the directory of the foreground process group
+// pgid, or else of the shell, or else the one the shell last reported,
+// and broadcasts it if it changed.
+func (s *ShellServer) checkCwd(pgid, shellPGID int) string {
+	path, ok := procCwd(pgid)
+	if !ok && pgid != shellPGID {
+		path, ok = procCwd(shellPGID)
+	}
+
+	s.cwd.mu.Lock()
+	if !ok {
+		path = s.cwd.osc
+	}
+	changed := path != "" && path != s.cwd.path
+	if changed {
+		s.cwd.path = path
+	} else {
+		path = s.cwd.path
+	}
+	s.cwd.mu.Unlock()
+
+	if changed {
+		data, _ := json.Marshal(map[string]string{"kind": "cwd", "path": path})
+		s.broadcastMessage(websocket.TextMessage, data)
+	}
+	return path
+}
+
+// currentCwd returns the shell's working directory as of now, or "" if
+// it can't be found.
+func (s *ShellServer) currentCwd() string {
+	s.ptyMu.Lock()
+	ptyFile, shellPGID := s.ptyFile, s.shellPGID
+	s.ptyMu.Unlock()
+	pgid := shellPGID
+	if ptyFile != nil {
+		if fg, err := getForegroundPGID(ptyFile); err == nil {
+			pgid = fg
+		}
+	}
+	return s.checkCwd(pgid, shellPGID)
+}
+
+// cachedCwd returns the working directory monitorStatus last found.
+func (s *ShellServer) cachedCwd() string {
+	s.cwd.mu.Lock()
+	defer s.cwd.mu.Unlock()
+	return s.cwd.path
+}
+
+// handleCwd serves GET /cwd, the shell's working directory as
+// {"path":...}; the path is "" if it isn't known.
+func (s *ShellServer) handleCwd(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r, http.MethodGet)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"path": s.currentCwd()})
+}
@@ -0,0 +1,64 @@
+package shellserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"shellserver/internal/testshell"
+)
+
+func getCwd(t *testing.T, url string) string {
+	t.Helper()
+	resp, err := http.Get(url + "/cwd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var body struct{ Path string }
+	if resp.StatusCode != http.StatusOK || json.NewDecoder(resp.Body).Decode(&body) != nil {
+		t.Fatalf("GET /cwd = %d", resp.StatusCode)
+	}
+	return body.Path
+}
+
+func TestCwdFollowsTheShell(t *testing.T) {
+	_, ts := startFakeShellServer(t)
+	c := testshell.Dial(t, ts.URL, "")
+	wd, _ := os.Getwd()
+	if got := getCwd(t, ts.URL); got != wd {
+		t.Errorf("GET /cwd = %q, want %q", got, wd)
+	}
+
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Send("cd " + dir + "\n")
+	// The first event may still be the directory the shell started in
+	for ev := c.ExpectEvent("cwd", testshell.DefaultTimeout); ev["path"] != dir; ev = c.ExpectEvent("cwd", testshell.DefaultTimeout) {
+		if ev["path"] != wd {
+			t.Fatalf("cwd event = %v, want %s", ev, dir)
+		}
+	}
+	if got := getCwd(t, ts.URL); got != dir {
+		t.Errorf("GET /cwd after cd = %q, want %q", got, dir)
+	}
+}
+
+func TestCwdFallsBackToOSC7(t *testing.T) {
+	s := newPumpTestServer()
+	// No such processes, as when the shell exits mid-read
+	if got := s.checkCwd(-1, -1); got != "" {
+		t.Errorf("cwd with nothing to go on = %q", got)
+	}
+	var cmds commandTracker
+	s.annotateCommands(&cmds, []byte("\x1b]7;file://host/home/me/src\x07"), time.Now())
+	s.noteOSCCwd(cmds.cwd)
+	if got := s.checkCwd(-1, -1); got != "/home/me/src" {
+		t.Errorf("cwd = %q, want the OSC 7 directory", got)
+	}
+}
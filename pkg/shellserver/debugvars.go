@@ -0,0 +1,35 @@
+package shellserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime"
+)
+
+// debugVars are the metrics GET /debug/vars reports, by name. They are
+// gathered here rather than published through package expvar, whose
+// import alone serves them, and os.Args with any -token, unauthenticated
+// on http.DefaultServeMux.
+func (s *ShellServer) debugVars() map[string]any {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	return map[string]any{
+		"pty_read_retries":  s.ptyReadRetries.Load(),
+		"session_usage":     s.sessionUsage(),
+		"tee_dropped_bytes": s.teeDropped(),
+		"heavy_requests":    s.gate.stats(),
+		"outbound_requests": s.outbound.Stats(),
+		"server_stats":      s.Stats(),
+		"memstats":          mem,
+	}
+}
+
+// handleDebugVars serves GET /debug/vars.
+func (s *ShellServer) handleDebugVars(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r, http.MethodGet)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.debugVars())
+}
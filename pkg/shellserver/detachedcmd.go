@@ -0,0 +1,180 @@
+package shellserver
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"shellserver/internal/styles"
+	"shellserver/pkg/protocol"
+)
+
+var flagDetachedTimeout = Flags.Duration("detached-timeout", 2*time.Minute, `kill widget commands run with "detached":true after this long`)
+
+// maxDetachedOutput caps how much output a detached command may produce;
+// the rest is discarded.
+const maxDetachedOutput = 16 << 20
+
+// detachedWaitDelay is how long to wait for the output pipes to close
+// after a detached command exits or is killed.
+const detachedWaitDelay = time.Second
+
+// detachedResult is the outcome of a detached widget command.
+type detachedResult struct {
+	ExitCode int // -1 when the command was killed or failed to start
+	TimedOut bool
+	Output   []byte // stdout and stderr, interleaved
+	Duration time.Duration
+}
+
+// cappedBuffer keeps the first limit bytes written to it and silently
+// drops the rest, so a chatty command can't exhaust memory.
+type cappedBuffer struct {
+	buf       bytes.Buffer
+	limit     int
+	truncated bool
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if room := b.limit - b.buf.Len(); len(p) > room {
+		b.buf.Write(p[:room])
+		b.truncated = true
+	} else {
+		b.buf.Write(p)
+	}
+	return len(p), nil
+}
+
+// runOutOfBand runs cmdline with /bin/sh in its own process group,
+// outside the PTY, with env in dir ("" for goshell's own). If it outlives
+// timeout the whole group is killed. err is set only if it couldn't be
+// started; the exit code is then -1, as it is when the command is killed.
+func runOutOfBand(cmdline string, env []string, dir string, timeout time.Duration, stdout, stderr io.Writer) (exitCode int, timedOut bool, duration time.Duration, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", cmdline)
+	cmd.Env = env
+	cmd.Dir = dir
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	cmd.WaitDelay = detachedWaitDelay
+
+	start := time.Now()
+	err = cmd.Run()
+	if cmd.ProcessState != nil {
+		err = nil
+	}
+	return cmd.ProcessState.ExitCode(), errors.Is(ctx.Err(), context.DeadlineExceeded), time.Since(start), err
+}
+
+// runDetachedCommand runs cmdline out of band, capturing its output.
+func runDetachedCommand(cmdline string, timeout time.Duration) detachedResult {
+	out := &cappedBuffer{limit: maxDetachedOutput}
+	goshellHome, _ := os.Getwd()
+	env := append(os.Environ(), "GOSHELL_HOME="+goshellHome)
+	var res detachedResult
+	var err error
+	res.ExitCode, res.TimedOut, res.Duration, err = runOutOfBand(cmdline, env, "", timeout, out, out)
+	if err != nil {
+		fmt.Fprintf(out, "goshell: %v\n", err)
+	}
+	if out.truncated {
+		fmt.Fprintf(&out.buf, "\ngoshell: output truncated at %d bytes\n", maxDetachedOutput)
+	}
+	res.Output = out.buf.Bytes()
+	return res
+}
+
+// startDetachedCommand runs a widget command in the background, away from
+// the shell, and returns the job ID its completion event will carry.
+func (s *ShellServer) startDetachedCommand(cmdline string) string {
+	var raw [8]byte
+	rand.Read(raw[:])
+	job := hex.EncodeToString(raw[:])
+
+	log.Printf("widget command (detached %s): %q", job, cmdline)
+	go func() {
+		res := runDetachedCommand(cmdline, s.detachedTimeout)
+		s.finishDetachedCommand(job, cmdline, res)
+	}()
+	return job
+}
+
+// finishDetachedCommand stores the HTML blocks in a detached command's
+// output as widgets, exactly as if it had run in the shell, adds a widget
+// reporting a timeout, and broadcasts the completion.
+func (s *ShellServer) finishDetachedCommand(job, cmdline string, res detachedResult) {
+	_, _, widgetIDs, updatedIDs := s.extractAndStoreHTML(res.Output)
+	if res.TimedOut {
+		log.Printf("widget command (detached %s) timed out after %v", job, s.detachedTimeout)
+		widgetIDs = append(widgetIDs, s.storeNewWidget(detachedTimeoutHTML(cmdline, s.detachedTimeout, stripHTMLMode(res.Output))))
+	}
+
+	s.flushWidgetEvents()
+	for _, id := range widgetIDs {
+		s.broadcastHTMLNotification(id)
+	}
+	for _, id := range updatedIDs {
+		s.broadcastHTMLUpdate(id)
+	}
+
+	if widgetIDs == nil {
+		widgetIDs = []int{}
+	}
+	msg := map[string]any{
+		"kind":        "detached-finished",
+		"job":         job,
+		"cmd":         cmdline,
+		"exit_code":   res.ExitCode,
+		"timed_out":   res.TimedOut,
+		"duration_ms": res.Duration.Milliseconds(),
+		"widget_ids":  widgetIDs,
+	}
+	data, _ := json.Marshal(msg)
+	s.broadcastMessage(websocket.TextMessage, data)
+}
+
+// storeNewWidget stores content as a new HTML widget and returns its ID.
+func (s *ShellServer) storeNewWidget(content []byte) int {
+	s.htmlWidgetsMu.Lock()
+	defer s.htmlWidgetsMu.Unlock()
+	s.htmlCounter++
+	id := s.htmlCounter
+	if err := s.putWidget(id, content); err != nil {
+		log.Printf("widget store: put %d: %v", id, err)
+	}
+	s.widgetEventLocked(protocol.WidgetCreated, id)
+	s.trimWidgets(time.Now())
+	return id
+}
+
+// detachedTimeoutHTML is the widget reporting a detached command that was
+// killed, with whatever plain output it produced first.
+func detachedTimeoutHTML(cmdline string, timeout time.Duration, output []byte) []byte {
+	var b bytes.Buffer
+	b.WriteString(`<style>` + styles.BaseCSS() + `</style>`)
+	fmt.Fprintf(&b, `<div class="shell-container"><div class="shell-header"><div class="shell-title">Timed out after %v</div></div>`, timeout)
+	fmt.Fprintf(&b, `<pre><code>%s</code></pre>`, styles.HTMLEscape(cmdline))
+	if output = bytes.TrimSpace(output); len(output) > 0 {
+		fmt.Fprintf(&b, `<pre>%s</pre>`, styles.HTMLEscape(string(output)))
+	}
+	b.WriteString(`</div>`)
+	return b.Bytes()
+}
@@ -0,0 +1,113 @@
+package shellserver
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"shellserver/internal/testshell"
+)
+
+func TestRunDetachedCommand(t *testing.T) {
+	tests := []struct {
+		cmd      string
+		exitCode int
+		output   string
+	}{
+		{"echo hello", 0, "hello\n"},
+		{"echo oops >&2; exit 3", 3, "oops\n"},
+	}
+	for _, tt := range tests {
+		res := runDetachedCommand(tt.cmd, 10*time.Second)
+		if res.ExitCode != tt.exitCode || string(res.Output) != tt.output || res.TimedOut {
+			t.Errorf("%q = %+v, want exit %d and output %q", tt.cmd, res, tt.exitCode, tt.output)
+		}
+	}
+}
+
+func TestRunDetachedCommandTimeoutKillsGroup(t *testing.T) {
+	start := time.Now()
+	res := runDetachedCommand("sleep 30 & echo $!; wait", 200*time.Millisecond)
+	if !res.TimedOut || res.ExitCode != -1 {
+		t.Fatalf("result = %+v, want a timeout with exit -1", res)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("took %v to return after the timeout", elapsed)
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(string(res.Output)))
+	if err != nil {
+		t.Fatalf("output %q, want the background pid", res.Output)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for processRunning(pid) {
+		if time.Now().After(deadline) {
+			t.Fatalf("background child %d survived the timeout", pid)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func postDetachedAction(t *testing.T, url, cmd string) {
+	t.Helper()
+	body := `{"type":"shell","detached":true,"cmd":` + strconv.Quote(cmd) + `}`
+	resp, err := http.Post(url+"/widget/test/action", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusAccepted)
+	}
+}
+
+func TestDetachedActionStoresWidgets(t *testing.T) {
+	s, ts := startFakeShellServer(t)
+	s.confirmWidgetCmds = false
+	allowWidgetCmds(s, ``)
+	c := testshell.Dial(t, ts.URL, "")
+
+	postDetachedAction(t, ts.URL, `printf 'text\033]9001;HTML_START\007<b>detached</b>\033]9001;HTML_END\007'; exit 4`)
+
+	ev := c.ExpectEvent("detached-finished", testshell.DefaultTimeout)
+	if ev["exit_code"] != float64(4) || ev["timed_out"] != false {
+		t.Errorf("detached-finished = %v, want exit 4 without timeout", ev)
+	}
+	ids, _ := ev["widget_ids"].([]any)
+	if len(ids) != 1 {
+		t.Fatalf("widget_ids = %v, want one widget", ev["widget_ids"])
+	}
+	if html, _ := s.widgetHTML(int(ids[0].(float64))); html != "<b>detached</b>" {
+		t.Errorf("stored widget = %q", html)
+	}
+	if ev := c.ExpectEvent("html", testshell.DefaultTimeout); ev["widget_id"] != ids[0] {
+		t.Errorf("html event = %v, want widget %v", ev, ids[0])
+	}
+}
+
+func TestDetachedActionTimeout(t *testing.T) {
+	s, ts := startFakeShellServer(t)
+	s.confirmWidgetCmds = false
+	allowWidgetCmds(s, ``)
+	s.detachedTimeout = 200 * time.Millisecond
+	c := testshell.Dial(t, ts.URL, "")
+
+	postDetachedAction(t, ts.URL, "echo partial; exec sleep 30")
+
+	ev := c.ExpectEvent("detached-finished", testshell.DefaultTimeout)
+	if ev["timed_out"] != true || ev["exit_code"] != float64(-1) {
+		t.Errorf("detached-finished = %v, want a timeout", ev)
+	}
+	ids, _ := ev["widget_ids"].([]any)
+	if len(ids) != 1 {
+		t.Fatalf("widget_ids = %v, want the timeout widget", ev["widget_ids"])
+	}
+	html, _ := s.widgetHTML(int(ids[0].(float64)))
+	for _, want := range []string{"Timed out after 200ms", "exec sleep 30", "partial"} {
+		if !strings.Contains(html, want) {
+			t.Errorf("timeout widget missing %q:\n%s", want, html)
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package shellserver
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/fs"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"shellserver/pkg/protocol"
+)
+
+var flagAllowAnyPath = Flags.Bool("allow-any-path", false, "let GET /download fetch any path the server can read, not just ones under the shell's home and working directory")
+
+// downloadRoots are the directories /download serves from without
+// -allow-any-path: the shell's home and its working directory, symlinks
+// resolved.
+func (s *ShellServer) downloadRoots() []string {
+	s.ptyMu.Lock()
+	home := envValue(s.launchEnv, "HOME")
+	s.ptyMu.Unlock()
+	if home == "" {
+		home, _ = os.UserHomeDir()
+	}
+	var roots []string
+	for _, dir := range []string{home, s.currentCwd()} {
+		if dir == "" {
+			continue
+		}
+		if real, err := filepath.EvalSymlinks(dir); err == nil {
+			roots = append(roots, real)
+		}
+	}
+	return roots
+}
+
+// envValue returns name's value in env, a list of name=value, or "".
+// The last setting wins, as it does for exec.
+func envValue(env []string, name string) string {
+	value := ""
+	for _, kv := range env {
+		if k, v, ok := strings.Cut(kv, "="); ok && k == name {
+			value = v
+		}
+	}
+	return value
+}
+
+// within reports whether p is dir or below it; both must be clean.
+func within(p, dir string) bool {
+	rel, err := filepath.Rel(dir, p)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// handleDownload serves GET /download?path=/abs/path: a file as an
+// attachment, or a directory as a tar.gz made on the fly. The path, with
+// symlinks resolved, must be under the shell's home or working directory
+// unless -allow-any-path is set.
+func (s *ShellServer) handleDownload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		methodNotAllowed(w, r, http.MethodGet, http.MethodHead)
+		return
+	}
+	p := r.URL.Query().Get("path")
+	if !filepath.IsAbs(p) {
+		respondError(w, r, http.StatusBadRequest, protocol.ErrInvalidRequest, "path must be an absolute path")
+		return
+	}
+	real, err := filepath.EvalSymlinks(p)
+	if err != nil {
+		downloadError(w, r, p, err)
+		return
+	}
+	if !*flagAllowAnyPath {
+		allowed := false
+		for _, root := range s.downloadRoots() {
+			allowed = allowed || within(real, root)
+		}
+		if !allowed {
+			respondError(w, r, http.StatusForbidden, protocol.ErrPathNotAllowed, p+" is outside the shell's home and working directory")
+			return
+		}
+	}
+	f, err := os.Open(real)
+	if err != nil {
+		downloadError(w, r, p, err)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		downloadError(w, r, p, err)
+		return
+	}
+
+	name := filepath.Base(real)
+	clearDeadlines(w)
+	if info.IsDir() {
+		w.Header().Set("Content-Type", "application/gzip")
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name + ".tar.gz"}))
+		if r.Method == http.MethodHead {
+			return
+		}
+		if err := writeTarGz(w, real, name); err != nil {
+			// Too late for an error response; the truncated archive
+			// won't unpack cleanly.
+			log.Printf("download %s: %v", p, err)
+		}
+		return
+	}
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name}))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	http.ServeContent(w, r, name, info.ModTime(), f)
+}
+
+// copyEntry copies the file at p, open as f, into an archive entry of
+// size bytes, its size when the walk found it. A file that grows while
+// it's read is cut at that size, and one that shrinks is padded to it
+// with zeros and a logged warning: its header is written already, and the
+// rest of the archive depends on the entry being as long as it says.
+func copyEntry(w io.Writer, f io.Reader, size int64, p string) error {
+	n, err := io.CopyN(w, f, size)
+	if errors.Is(err, io.EOF) {
+		log.Printf("download: %s shrank while it was archived; padded it with %d zero bytes", p, size-n)
+		_, err = io.CopyN(w, zeros{}, size-n)
+	}
+	return err
+}
+
+// zeros reads as an endless run of zero bytes.
+type zeros struct{}
+
+func (zeros) Read(b []byte) (int, error) {
+	clear(b)
+	return len(b), nil
+}
+
+// downloadError answers a path that can't be read: 404 if it doesn't
+// exist, 403 if the server may not read it.
+func downloadError(w http.ResponseWriter, r *http.Request, p string, err error) {
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		respondError(w, r, http.StatusNotFound, protocol.ErrNotFound, "no such file "+p)
+	case errors.Is(err, fs.ErrPermission):
+		respondError(w, r, http.StatusForbidden, protocol.ErrPathNotAllowed, "permission denied: "+p)
+	default:
+		respondError(w, r, http.StatusInternalServerError, protocol.ErrInternal, err.Error())
+	}
+}
+
+// writeTarGz writes dir to w as a gzipped tar whose entries sit under
+// name/. Symlinks are archived as links, not followed; entries that
+// can't be read are left out.
+func writeTarGz(w io.Writer, dir, name string) error {
+	zw := gzip.NewWriter(w)
+	tw := tar.NewWriter(zw)
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if d != nil && d.IsDir() && p != dir {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		link := ""
+		if info.Mode()&fs.ModeSymlink != 0 {
+			if link, err = os.Readlink(p); err != nil {
+				return nil
+			}
+		}
+		hdr, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			// sockets and the like
+			return nil
+		}
+		rel, _ := filepath.Rel(dir, p)
+		hdr.Name = filepath.ToSlash(filepath.Join(name, rel))
+		if d.IsDir() {
+			hdr.Name += "/"
+		}
+		if !info.Mode().IsRegular() {
+			return tw.WriteHeader(hdr)
+		}
+		f, err := os.Open(p)
+		if err != nil {
+			return nil
+		}
+		defer f.Close()
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		return copyEntry(tw, f, hdr.Size, p)
+	})
+	if err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return zw.Close()
+}
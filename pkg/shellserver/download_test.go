@@ -0,0 +1,128 @@
+package shellserver
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"shellserver/internal/testshell"
+)
+
+func download(t *testing.T, base, path string) *http.Response {
+	t.Helper()
+	resp, err := http.Get(base + "/download?path=" + url.QueryEscape(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func TestDownload(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	s, ts := startFakeShellServer(t)
+	c := testshell.Dial(t, ts.URL, "")
+	dir := shareDir(t)
+	c.Send("cd " + dir)
+	c.ExpectOutput("$ ", testshell.DefaultTimeout)
+	waitFor(t, "the shell's cwd", func() bool { return s.currentCwd() == dir })
+
+	resp := download(t, ts.URL, filepath.Join(dir, "app.js"))
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || string(body) != "console.log(1)" {
+		t.Fatalf("app.js: %d %q", resp.StatusCode, body)
+	}
+	if got := resp.Header.Get("Content-Disposition"); got != `attachment; filename=app.js` {
+		t.Errorf("Content-Disposition = %q", got)
+	}
+	if got := resp.Header.Get("Content-Type"); !strings.HasPrefix(got, "text/javascript") {
+		t.Errorf("Content-Type = %q", got)
+	}
+
+	resp = download(t, ts.URL, dir)
+	if got := resp.Header.Get("Content-Disposition"); got != `attachment; filename=dist.tar.gz` {
+		t.Errorf("directory Content-Disposition = %q", got)
+	}
+	zr, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	tr := tar.NewReader(zr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, hdr.Name)
+		if hdr.Name == "dist/assets/logo.svg" {
+			if data, _ := io.ReadAll(tr); string(data) != "<svg/>" {
+				t.Errorf("logo.svg = %q", data)
+			}
+		}
+		if hdr.Name == "dist/escape.txt" && hdr.Typeflag != tar.TypeSymlink {
+			t.Errorf("escape.txt archived as type %c, not followed as a symlink", hdr.Typeflag)
+		}
+	}
+	sort.Strings(names)
+	want := []string{"dist/", "dist/a&b.txt", "dist/app.js", "dist/assets/", "dist/assets/logo.svg", "dist/escape.txt", "dist/inside.js", "dist/up"}
+	if strings.Join(names, " ") != strings.Join(want, " ") {
+		t.Errorf("archive has %q, want %q", names, want)
+	}
+
+	for _, tc := range []struct {
+		path   string
+		status int
+	}{
+		{filepath.Join(dir, "..", "secret.txt"), http.StatusForbidden},
+		{filepath.Join(dir, "escape.txt"), http.StatusForbidden}, // a symlink out
+		{filepath.Join(dir, "missing.txt"), http.StatusNotFound},
+		{"app.js", http.StatusBadRequest},
+	} {
+		if resp := download(t, ts.URL, tc.path); resp.StatusCode != tc.status {
+			t.Errorf("%s: status %d, want %d", tc.path, resp.StatusCode, tc.status)
+		}
+	}
+
+	defer func(old bool) { *flagAllowAnyPath = old }(*flagAllowAnyPath)
+	*flagAllowAnyPath = true
+	resp = download(t, ts.URL, filepath.Join(dir, "..", "secret.txt"))
+	if body, _ := io.ReadAll(resp.Body); resp.StatusCode != http.StatusOK || string(body) != "do not serve" {
+		t.Errorf("secret.txt with -allow-any-path: %d %q", resp.StatusCode, body)
+	}
+}
+
+func TestCopyEntryPadsShrunkFile(t *testing.T) {
+	var b strings.Builder
+	tw := tar.NewWriter(&b)
+	tw.WriteHeader(&tar.Header{Name: "shrunk", Mode: 0o644, Size: 8})
+	if err := copyEntry(tw, strings.NewReader("abc"), 8, "shrunk"); err != nil {
+		t.Fatalf("copyEntry of a file that shrank: %v", err)
+	}
+	tw.WriteHeader(&tar.Header{Name: "grown", Mode: 0o644, Size: 2})
+	if err := copyEntry(tw, strings.NewReader("abcdef"), 2, "grown"); err != nil {
+		t.Fatalf("copyEntry of a file that grew: %v", err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	tr := tar.NewReader(strings.NewReader(b.String()))
+	for _, want := range []string{"abc\x00\x00\x00\x00\x00", "ab"} {
+		if _, err := tr.Next(); err != nil {
+			t.Fatal(err)
+		}
+		if got, _ := io.ReadAll(tr); string(got) != want {
+			t.Errorf("entry = %q, want %q", got, want)
+		}
+	}
+}
@@ -0,0 +1,275 @@
+package shellserver
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"shellserver/internal/testshell"
+)
+
+func TestMain(m *testing.M) {
+	testshell.MaybeRun()
+	os.Exit(m.Run())
+}
+
+// startFakeShellServer starts a full ShellServer whose PTY runs the
+// scripted test shell, serving the API routes from an httptest server.
+func startFakeShellServer(t *testing.T) (*ShellServer, *httptest.Server) {
+	t.Helper()
+	s, err := newShellServerWithShell(testshell.Command())
+	if err != nil {
+		t.Fatalf("newShellServerWithShell: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s, serveShellServer(t, s)
+}
+
+// serveShellServer serves s's routes until the test ends.
+func serveShellServer(t *testing.T, s *ShellServer) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	s.registerRoutes(mux)
+	ts := httptest.NewServer(mux)
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func TestPTYToClient(t *testing.T) {
+	s, ts := startFakeShellServer(t)
+	c := testshell.Dial(t, ts.URL, "")
+
+	// write a command directly to the PTY
+	if err := s.writeToPTY([]byte("echo test-hello-from-server\n")); err != nil {
+		t.Fatalf("writeToPTY: %v", err)
+	}
+
+	// expect the terminal's echo of the input, then the shell's output
+	c.ExpectOutput("echo test-hello-from-server", testshell.DefaultTimeout)
+	c.ExpectOutput("\r\ntest-hello-from-server\r\n", testshell.DefaultTimeout)
+}
+
+func TestClientToPTY(t *testing.T) {
+	_, ts := startFakeShellServer(t)
+	c := testshell.Dial(t, ts.URL, "")
+
+	// send a command from the client which the server should write into PTY
+	c.Send("echo message-from-client")
+	c.ExpectOutput("\r\nmessage-from-client\r\n", testshell.DefaultTimeout)
+}
+
+func TestOutputReplayedToLateClient(t *testing.T) {
+	_, ts := startFakeShellServer(t)
+	first := testshell.Dial(t, ts.URL, "")
+	first.Send("echo before-second-client")
+	first.ExpectOutput("\r\nbefore-second-client\r\n", testshell.DefaultTimeout)
+
+	second := testshell.Dial(t, ts.URL, "")
+	second.ExpectOutput("\r\nbefore-second-client\r\n", testshell.DefaultTimeout)
+}
+
+func TestStatusFollowsForegroundJob(t *testing.T) {
+	_, ts := startFakeShellServer(t)
+	c := testshell.Dial(t, ts.URL, "")
+
+	c.Send("fg 500ms")
+	ev := c.ExpectEvent("status", testshell.DefaultTimeout)
+	if ev["state"] != "running" {
+		t.Fatalf("status = %v, want running while the child holds the terminal", ev["state"])
+	}
+	// the child is another copy of the test binary; comm is cut to 15 bytes
+	want := filepath.Base(os.Args[0])
+	if len(want) > 15 {
+		want = want[:15]
+	}
+	if ev["process"] != want {
+		t.Errorf("process = %v, want %q", ev["process"], want)
+	}
+	if cmd, _ := ev["command"].(string); !strings.HasSuffix(cmd, " child 500ms") {
+		t.Errorf("command = %v, want the child's command line", ev["command"])
+	}
+	if pid, _ := ev["pid"].(float64); pid <= 0 {
+		t.Errorf("pid = %v, want the foreground group's", ev["pid"])
+	}
+	if ev := c.ExpectEvent("status", testshell.DefaultTimeout); ev["state"] != "waiting" {
+		t.Fatalf("status = %v, want waiting once the shell takes the terminal back", ev["state"])
+	}
+}
+
+func TestRestartDoesNotLeakGoroutines(t *testing.T) {
+	s, _ := startFakeShellServer(t)
+	if err := s.restart(); err != nil {
+		t.Fatal(err)
+	}
+	before := runtime.NumGoroutine()
+	for i := 0; i < 20; i++ {
+		if err := s.restart(); err != nil {
+			t.Fatalf("restart %d: %v", i, err)
+		}
+	}
+	// The old shells take a moment to hang up and be reaped
+	const slack = 5
+	deadline := time.Now().Add(testshell.DefaultTimeout)
+	for runtime.NumGoroutine() > before+slack {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d goroutines after 20 restarts, %d before", runtime.NumGoroutine(), before)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRestartNotifiesClients(t *testing.T) {
+	s, ts := startFakeShellServer(t)
+	c := testshell.Dial(t, ts.URL, "")
+	c.SendJSON(map[string]any{"kind": "resize", "rows": 50, "cols": 180})
+	c.ExpectEvent("resize", testshell.DefaultTimeout)
+
+	// The old shell leaves a widget block half written
+	c.Send(`raw "\x1b]9001;HTML_START\x07<b>half"`)
+	waitFor(t, "the partial block", func() bool {
+		s.htmlBufMu.Lock()
+		defer s.htmlBufMu.Unlock()
+		return len(s.htmlBuffer) > 0
+	})
+
+	resp, err := http.Post(ts.URL+"/restart", "application/json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if ev := c.ExpectEvent("restarted", testshell.DefaultTimeout); ev["cleared"] != true {
+		t.Errorf("restarted event = %v, want cleared", ev)
+	}
+	c.ExpectOutput(clearTerminal, testshell.DefaultTimeout)
+	c.ExpectOutput("$ ", testshell.DefaultTimeout)
+
+	if got := ptyGetsize(t, s); got != (termSize{50, 180}) {
+		t.Errorf("restarted shell's size = %v, want 50x180", got)
+	}
+	// The new shell's output isn't taken for the rest of the old block
+	c.Send(`raw "end\x1b]9001;HTML_END\x07\n"`)
+	c.ExpectOutput("\r\nend", testshell.DefaultTimeout)
+	if ids := s.widgetIDs(); len(ids) != 0 {
+		t.Errorf("widgets %v stored from the old shell's partial block", ids)
+	}
+}
+
+func TestHTMLExtraction(t *testing.T) {
+	_, ts := startFakeShellServer(t)
+	c := testshell.Dial(t, ts.URL, "")
+
+	// The terminal's echo of this line shows the escapes quoted, so only
+	// the shell's output carries real markers.
+	c.Send(`raw "before\x1b]9001;HTML_START\x07<b>widget</b>\x1b]9001;HTML_END\x07after\n"`)
+
+	ev := c.ExpectEvent("html", testshell.DefaultTimeout)
+	id, ok := ev["widget_id"].(float64)
+	if !ok {
+		t.Fatalf("html event = %v, want a widget_id", ev)
+	}
+	link := fmt.Sprintf("before\x1b]8;;htmlwidget:%d\x07", int(id))
+	c.ExpectOutput(link, testshell.DefaultTimeout)
+	if out := c.ExpectOutput("after", testshell.DefaultTimeout); out == "" {
+		t.Fatal("output after the widget missing")
+	}
+
+	resp, err := http.Get(fmt.Sprintf("%s/htmlwidget/%d?fragment=1", ts.URL, int(id)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != "<b>widget</b>" {
+		t.Errorf("widget content = %q, want the HTML between the markers", body)
+	}
+}
+
+func TestSplitHTMLBlockAcrossWrites(t *testing.T) {
+	_, ts := startFakeShellServer(t)
+	c := testshell.Dial(t, ts.URL, "")
+
+	c.Send(`raw "\x1b]9001;HTML_START\x07<i>split"`)
+	c.Send("sleep 100ms")
+	c.Send(`raw "</i>\x1b]9001;HTML_END\x07"`)
+
+	c.ExpectEvent("html", testshell.DefaultTimeout)
+	c.ExpectOutput("htmlwidget:", testshell.DefaultTimeout)
+}
+
+// TestRestartDuringTraffic restarts the shell while status is polled,
+// the terminal is resized and output is broadcast; run it with -race to
+// check the PTY generation's fields are only read under ptyMu or from the
+// generation's own goroutines, and that resizing doesn't touch a PTY being
+// closed or read outside the runtime poller.
+func TestRestartDuringTraffic(t *testing.T) {
+	s, ts := startFakeShellServer(t)
+	writer := testshell.Dial(t, ts.URL, "")
+	testshell.Dial(t, ts.URL, "?role=observer")
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for _, path := range []string{"/status", "/healthz", "/cwd"} {
+		wg.Add(1)
+		go func(path string) {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				if resp, err := http.Get(ts.URL + path); err == nil {
+					resp.Body.Close()
+				}
+			}
+		}(path)
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-done:
+				return
+			case <-time.After(5 * time.Millisecond):
+			}
+			writer.SendJSON(map[string]any{"kind": "resize", "rows": 24 + i%10, "cols": 80})
+		}
+	}()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			if err := s.applySize(termSize{Rows: 30 + i%10, Cols: 100}); err != nil && !errors.Is(err, errNoShell) {
+				t.Errorf("resize during restart: %v", err)
+				return
+			}
+		}
+	}()
+
+	for i := 0; i < 5; i++ {
+		if err := s.restart(); err != nil {
+			t.Fatalf("restart %d: %v", i, err)
+		}
+		s.broadcastMessage(websocket.TextMessage, []byte(`{"kind":"test"}`))
+		time.Sleep(20 * time.Millisecond)
+	}
+	close(done)
+	wg.Wait()
+}
@@ -0,0 +1,139 @@
+package shellserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"shellserver/pkg/protocol"
+)
+
+// envSnapshot is the shell's environment as GET /envsnapshot reports it.
+type envSnapshot struct {
+	Taken  time.Time         `json:"taken"`
+	Cached bool              `json:"cached"` // the shell was busy, so this is the last one taken
+	Env    map[string]string `json:"env"`
+}
+
+// envDiff is GET /envsnapshot?diff=1: how the shell's environment differs
+// from the one it was started with.
+type envDiff struct {
+	Taken   time.Time            `json:"taken"`
+	Cached  bool                 `json:"cached"`
+	Added   map[string]string    `json:"added"`
+	Changed map[string]envChange `json:"changed"`
+	Removed map[string]string    `json:"removed"` // with the values it started with
+}
+
+type envChange struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// envSnapshots caches the last environment snapshot, for when the shell
+// is too busy to be asked.
+type envSnapshots struct {
+	mu   sync.Mutex
+	last *envSnapshot
+}
+
+func (c *envSnapshots) store(snap envSnapshot) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.last = &snap
+}
+
+func (c *envSnapshots) cached() (envSnapshot, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.last == nil {
+		return envSnapshot{}, false
+	}
+	snap := *c.last
+	snap.Cached = true
+	return snap, true
+}
+
+func (c *envSnapshots) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.last = nil
+}
+
+// parseEnv parses env -0 output, or a list of KEY=value entries joined by
+// NULs. Later entries override earlier ones, as they do for exec.
+func parseEnv(entries [][]byte) map[string]string {
+	env := make(map[string]string, len(entries))
+	for _, kv := range entries {
+		if k, v, ok := bytes.Cut(kv, []byte("=")); ok && len(k) > 0 {
+			env[string(k)] = string(v)
+		}
+	}
+	return env
+}
+
+// diffEnv compares the environment now with the one the shell started
+// with.
+func diffEnv(base, now map[string]string) (added map[string]string, changed map[string]envChange, removed map[string]string) {
+	added, changed, removed = map[string]string{}, map[string]envChange{}, map[string]string{}
+	for k, v := range now {
+		if was, ok := base[k]; !ok {
+			added[k] = v
+		} else if was != v {
+			changed[k] = envChange{From: was, To: v}
+		}
+	}
+	for k, v := range base {
+		if _, ok := now[k]; !ok {
+			removed[k] = v
+		}
+	}
+	return added, changed, removed
+}
+
+// handleEnvSnapshot serves GET /envsnapshot: the shell's current
+// environment, asked for with a hidden env -0 when the shell is waiting at
+// a prompt, or the last snapshot when it is busy. ?diff=1 compares it
+// with the environment the shell was started with.
+func (s *ShellServer) handleEnvSnapshot(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r, http.MethodGet)
+		return
+	}
+
+	out, err := s.queryShell(r.Context(), "env -0")
+	var snap envSnapshot
+	switch {
+	case err == nil:
+		snap = envSnapshot{Taken: time.Now(), Env: parseEnv(bytes.Split(out, []byte{0}))}
+		s.envSnapshots.store(snap)
+	case errors.Is(err, errShellBusy):
+		var ok bool
+		if snap, ok = s.envSnapshots.cached(); !ok {
+			respondError(w, r, http.StatusConflict, protocol.ErrShellBusy, err.Error()+", and no snapshot has been taken yet")
+			return
+		}
+	default:
+		respondError(w, r, http.StatusGatewayTimeout, protocol.ErrShellQueryFailed, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if r.URL.Query().Get("diff") != "1" {
+		json.NewEncoder(w).Encode(snap)
+		return
+	}
+	s.ptyMu.Lock()
+	launchEnv := s.launchEnv
+	s.ptyMu.Unlock()
+	base := make([][]byte, len(launchEnv))
+	for i, kv := range launchEnv {
+		base[i] = []byte(kv)
+	}
+	d := envDiff{Taken: snap.Taken, Cached: snap.Cached}
+	d.Added, d.Changed, d.Removed = diffEnv(parseEnv(base), snap.Env)
+	json.NewEncoder(w).Encode(d)
+}
@@ -0,0 +1,79 @@
+package shellserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"shellserver/internal/testshell"
+	"shellserver/pkg/protocol"
+)
+
+func TestEnvSnapshot(t *testing.T) {
+	s, ts := startFakeShellServer(t)
+	s.shellQueries.setLine(testshell.Wrap)
+	c := testshell.Dial(t, ts.URL, "")
+	c.ExpectOutput(testshell.Prompt, testshell.DefaultTimeout)
+
+	get := func(query string, v any) int {
+		t.Helper()
+		resp, err := http.Get(ts.URL + "/envsnapshot" + query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode
+	}
+
+	// Busy before any snapshot: nothing to fall back on
+	c.Send("fg 1s")
+	waitFor(t, "foreground command", func() bool { return !s.shellWaiting() })
+	var errResp protocol.ErrorResponse
+	if status := get("", &errResp); status != http.StatusConflict || errResp.Error.Code != protocol.ErrShellBusy {
+		t.Errorf("busy without a snapshot: %d %q", status, errResp.Error.Code)
+	}
+	waitFor(t, "prompt", s.shellWaiting)
+
+	var snap envSnapshot
+	if status := get("", &snap); status != http.StatusOK {
+		t.Fatalf("status %d", status)
+	}
+	if snap.Cached || snap.Env["TERM"] != "xterm-256color" || !strings.HasPrefix(snap.Env["GOSHELL_URL"], "http") {
+		t.Errorf("snapshot = cached %v, TERM %q, GOSHELL_URL %q", snap.Cached, snap.Env["TERM"], snap.Env["GOSHELL_URL"])
+	}
+
+	// Pretend the shell started with a different environment
+	s.ptyMu.Lock()
+	var launch []string
+	for _, kv := range s.launchEnv {
+		if !strings.HasPrefix(kv, "GOSHELL_URL=") {
+			launch = append(launch, kv)
+		}
+	}
+	s.launchEnv = append(launch, "TERM=dumb", "GONE=1")
+	s.ptyMu.Unlock()
+
+	var d envDiff
+	get("?diff=1", &d)
+	if d.Added["GOSHELL_URL"] == "" || len(d.Added) != 1 {
+		t.Errorf("added = %v, want GOSHELL_URL", d.Added)
+	}
+	if d.Changed["TERM"] != (envChange{From: "dumb", To: "xterm-256color"}) || len(d.Changed) != 1 {
+		t.Errorf("changed = %v, want TERM", d.Changed)
+	}
+	if d.Removed["GONE"] != "1" || len(d.Removed) != 1 {
+		t.Errorf("removed = %v, want GONE", d.Removed)
+	}
+
+	// Busy after a snapshot: the cached one
+	c.Send("fg 1s")
+	waitFor(t, "foreground command", func() bool { return !s.shellWaiting() })
+	snap = envSnapshot{}
+	if status := get("", &snap); status != http.StatusOK || !snap.Cached || snap.Env["TERM"] != "xterm-256color" {
+		t.Errorf("busy with a snapshot: %d cached %v", status, snap.Cached)
+	}
+}
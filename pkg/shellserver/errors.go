@@ -0,0 +1,157 @@
+package shellserver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"shellserver/internal/styles"
+	"shellserver/pkg/protocol"
+)
+
+// respondError writes an error in the form r asks for: a styled HTML page
+// when the client prefers text/html (a browser navigating to the URL),
+// otherwise the JSON envelope every API error uses,
+//
+//	{"error":{"code":"widget_not_found","message":"no widget 12"}}
+//
+// Codes are defined, and documented, in pkg/protocol.
+func respondError(w http.ResponseWriter, r *http.Request, status int, code protocol.ErrorCode, message string) {
+	h := w.Header()
+	h.Del("Content-Length")
+	h.Set("X-Content-Type-Options", "nosniff")
+	if wantsHTML(r) {
+		h.Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(status)
+		fmt.Fprint(w, errorPage(status, code, message))
+		return
+	}
+	h.Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(protocol.ErrorResponse{
+		Error: protocol.ErrorBody{Code: code, Message: message},
+	})
+}
+
+// methodNotAllowed rejects a request whose method isn't one of allowed.
+func methodNotAllowed(w http.ResponseWriter, r *http.Request, allowed ...string) {
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	respondError(w, r, http.StatusMethodNotAllowed, protocol.ErrMethodNotAllowed,
+		r.Method+" not allowed; use "+strings.Join(allowed, " or "))
+}
+
+// invalidJSON rejects a request body that didn't decode, or was cut off
+// at its size limit.
+func invalidJSON(w http.ResponseWriter, r *http.Request, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		respondError(w, r, http.StatusRequestEntityTooLarge, protocol.ErrBodyTooLarge, fmt.Sprintf("request body over %d bytes", tooLarge.Limit))
+		return
+	}
+	respondError(w, r, http.StatusBadRequest, protocol.ErrInvalidJSON, "invalid JSON payload: "+err.Error())
+}
+
+// shellNotRunning answers a request for the shell while there is none,
+// which a restart can fix.
+func shellNotRunning(w http.ResponseWriter, r *http.Request) {
+	respondError(w, r, http.StatusConflict, protocol.ErrShellNotRunning, "shell not running; POST /restart to start one")
+}
+
+// ptyWriteFailed answers a request whose input the shell couldn't be
+// given.
+func ptyWriteFailed(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, errNoShell) {
+		shellNotRunning(w, r)
+		return
+	}
+	respondError(w, r, http.StatusInternalServerError, protocol.ErrPTYWriteFailed, "failed to write to shell: "+err.Error())
+}
+
+// notFound answers a path no route handles.
+func notFound(w http.ResponseWriter, r *http.Request) {
+	respondError(w, r, http.StatusNotFound, protocol.ErrNotFound, r.URL.Path+" not found")
+}
+
+// widgetNotFound answers a request for an HTML widget that isn't stored:
+// 410 for one that was and has been evicted, so the user knows it
+// expired rather than never existed, and 404 otherwise.
+func (s *ShellServer) widgetNotFound(w http.ResponseWriter, r *http.Request, id int) {
+	s.htmlWidgetsMu.RLock()
+	evicted := id > 0 && id <= s.htmlCounter
+	s.htmlWidgetsMu.RUnlock()
+	if evicted {
+		respondError(w, r, http.StatusGone, protocol.ErrWidgetExpired,
+			fmt.Sprintf("widget %d has expired: only the most recent widgets are kept. Run the command that made it again to see it.", id))
+		return
+	}
+	respondError(w, r, http.StatusNotFound, protocol.ErrWidgetNotFound, fmt.Sprintf("no widget %d", id))
+}
+
+// wantsHTML reports whether r's Accept header ranks text/html above
+// JSON. Wildcards count for JSON only, so fetch() and curl, which send
+// */*, get the envelope.
+func wantsHTML(r *http.Request) bool {
+	var htmlQ, jsonQ float64
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		for _, p := range strings.Split(params, ";") {
+			if v, ok := strings.CutPrefix(strings.TrimSpace(p), "q="); ok {
+				if f, err := strconv.ParseFloat(v, 64); err == nil {
+					q = f
+				}
+			}
+		}
+		switch strings.ToLower(strings.TrimSpace(mediaType)) {
+		case "text/html":
+			htmlQ = max(htmlQ, q)
+		case "application/json", "application/*", "*/*":
+			jsonQ = max(jsonQ, q)
+		}
+	}
+	return htmlQ > 0 && htmlQ > jsonQ
+}
+
+// errorPage renders an error for a browser, styled like the widgets.
+func errorPage(status int, code protocol.ErrorCode, message string) string {
+	title := fmt.Sprintf("%d %s", status, http.StatusText(status))
+	return `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>` + styles.HTMLEscape(title) + ` · goshell</title>
+<style>
+body {
+	margin: 0;
+	padding: 24px;
+	background: ` + styles.Colors.BgDark + `;
+	font-family: monospace;
+}` + styles.BaseCSS() + `
+.error-message {
+	color: ` + styles.Colors.TextLight + `;
+	margin: 8px 0;
+}
+.error-code {
+	color: ` + styles.Colors.TextGray + `;
+	font-size: 11px;
+}
+.error-home {
+	color: ` + styles.Colors.Blue + `;
+	font-size: 12px;
+}
+</style>
+</head>
+<body>
+<main class="shell-container">
+<div class="shell-header"><h1 class="shell-title">` + styles.HTMLEscape(title) + `</h1></div>
+<p class="error-message">` + styles.HTMLEscape(message) + `</p>
+<p class="error-code">` + styles.HTMLEscape(string(code)) + `</p>
+<a class="error-home" href="/">Back to the terminal</a>
+</main>
+</body>
+</html>
+`
+}
@@ -0,0 +1,110 @@
+package shellserver
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"shellserver/pkg/protocol"
+)
+
+func TestWantsHTML(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   bool
+	}{
+		{"", false},
+		{"*/*", false},
+		{"application/json", false},
+		{"text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", true},
+		{"text/html;q=0.5, application/json", false},
+		{"application/json;q=0.1, TEXT/HTML", true},
+		{"text/html;q=0", false},
+	}
+	for _, tt := range tests {
+		r, _ := http.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Accept", tt.accept)
+		if got := wantsHTML(r); got != tt.want {
+			t.Errorf("wantsHTML(%q) = %v, want %v", tt.accept, got, tt.want)
+		}
+	}
+}
+
+func TestErrorEnvelope(t *testing.T) {
+	_, ts := startFakeShellServer(t)
+
+	tests := []struct {
+		method, path, body string
+		status             int
+		code               protocol.ErrorCode
+	}{
+		{http.MethodGet, "/htmlwidget/9999", "", http.StatusNotFound, protocol.ErrWidgetNotFound},
+		{http.MethodGet, "/htmlwidget/abc", "", http.StatusNotFound, protocol.ErrNotFound},
+		{http.MethodGet, "/htmlwidget/9999/fresh", "", http.StatusNotFound, protocol.ErrWidgetNotFound},
+		{http.MethodPost, "/htmlwidget/1", "", http.StatusMethodNotAllowed, protocol.ErrMethodNotAllowed},
+		{http.MethodGet, "/restart", "", http.StatusMethodNotAllowed, protocol.ErrMethodNotAllowed},
+		{http.MethodPost, "/resize", "{not json", http.StatusBadRequest, protocol.ErrInvalidJSON},
+		{http.MethodPost, "/restart", `{"profile":"nope"}`, http.StatusNotFound, protocol.ErrProfileNotFound},
+		{http.MethodPost, "/widget/x/action", `{"type":"shell"}`, http.StatusBadRequest, protocol.ErrInvalidRequest},
+		{http.MethodPost, "/widget/x/action", `{"type":"bogus"}`, http.StatusBadRequest, protocol.ErrUnsupportedWidgetType},
+		{http.MethodPost, "/widget/9999/error", `{"message":"x"}`, http.StatusNotFound, protocol.ErrWidgetNotFound},
+		{http.MethodPost, "/confirm/nope", `{"approve":true}`, http.StatusNotFound, protocol.ErrConfirmNotFound},
+		{http.MethodGet, "/integration?shell=tcsh", "", http.StatusBadRequest, protocol.ErrInvalidRequest},
+		{http.MethodGet, "/recordings", "", http.StatusNotFound, protocol.ErrRecordingsDisabled},
+		{http.MethodGet, "/ws/shell", "", http.StatusUpgradeRequired, protocol.ErrUpgradeRequired},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest(tt.method, ts.URL+tt.path, strings.NewReader(tt.body))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var env protocol.ErrorResponse
+		decodeErr := json.NewDecoder(resp.Body).Decode(&env)
+		resp.Body.Close()
+
+		name := tt.method + " " + tt.path
+		if resp.StatusCode != tt.status {
+			t.Errorf("%s: status %d, want %d", name, resp.StatusCode, tt.status)
+		}
+		if ct := resp.Header.Get("Content-Type"); ct != "application/json" {
+			t.Errorf("%s: Content-Type %q", name, ct)
+		}
+		if decodeErr != nil || env.Error.Code != tt.code || env.Error.Message == "" {
+			t.Errorf("%s: envelope %+v (%v), want code %s", name, env, decodeErr, tt.code)
+		}
+		if tt.status == http.StatusMethodNotAllowed && resp.Header.Get("Allow") == "" {
+			t.Errorf("%s: no Allow header", name)
+		}
+	}
+}
+
+func TestErrorPageForBrowsers(t *testing.T) {
+	_, ts := startFakeShellServer(t)
+
+	req, _ := http.NewRequest(http.MethodGet, ts.URL+"/htmlwidget/9999", nil)
+	req.Header.Set("Accept", "text/html,application/xhtml+xml,*/*;q=0.8")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNotFound || !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html") {
+		t.Fatalf("status %d, Content-Type %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	for _, want := range []string{
+		"<title>404 Not Found · goshell</title>",
+		`class="shell-container"`,
+		"no widget 9999",
+		string(protocol.ErrWidgetNotFound),
+		`href="/"`,
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("error page missing %q:\n%s", want, body)
+		}
+	}
+}
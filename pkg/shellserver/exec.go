@@ -0,0 +1,113 @@
+package shellserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"shellserver/pkg/protocol"
+)
+
+// maxExecOutput caps each of a POST /exec command's stdout and stderr;
+// the rest is discarded and the response says it was truncated.
+const maxExecOutput = 4 << 20
+
+// execRequest is the body of POST /exec.
+type execRequest struct {
+	Cmd        string  `json:"cmd"`
+	TimeoutSec float64 `json:"timeout_sec"` // 0 for -detached-timeout
+}
+
+// execResponse is what POST /exec answers once the command has finished.
+type execResponse struct {
+	Stdout     string `json:"stdout"` // with HTML blocks replaced by widget links
+	Stderr     string `json:"stderr"`
+	ExitCode   int    `json:"exit_code"`
+	DurationMS int64  `json:"duration_ms"`
+	WidgetIDs  []int  `json:"widget_ids"`
+	Truncated  bool   `json:"truncated,omitempty"` // stdout or stderr passed maxExecOutput
+}
+
+// shellContext returns the environment and working directory a command
+// run beside the shell should get: the shell's last environment snapshot,
+// or the one it was launched with, and the directory it's in now.
+func (s *ShellServer) shellContext() (env []string, dir string) {
+	s.ptyMu.Lock()
+	env = append([]string(nil), s.launchEnv...)
+	s.ptyMu.Unlock()
+	if snap, ok := s.envSnapshots.cached(); ok {
+		env = env[:0]
+		for k, v := range snap.Env {
+			env = append(env, k+"="+v)
+		}
+	}
+	return env, s.currentCwd()
+}
+
+// handleExec serves POST /exec: it runs a command outside the PTY, with
+// the shell's environment and working directory, and answers with its
+// output once it finishes. HTML blocks in its stdout become widgets, as
+// they would in the terminal. A command that outlives its timeout has
+// its process group killed and gets 408.
+func (s *ShellServer) handleExec(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, r, http.MethodPost)
+		return
+	}
+	var req execRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		invalidJSON(w, r, err)
+		return
+	}
+	if req.Cmd == "" {
+		respondError(w, r, http.StatusBadRequest, protocol.ErrInvalidRequest, `"cmd" is required`)
+		return
+	}
+	if req.TimeoutSec < 0 {
+		respondError(w, r, http.StatusBadRequest, protocol.ErrInvalidRequest, `"timeout_sec" can't be negative`)
+		return
+	}
+	timeout := s.detachedTimeout
+	if req.TimeoutSec > 0 {
+		timeout = time.Duration(req.TimeoutSec * float64(time.Second))
+	}
+
+	env, dir := s.shellContext()
+	stdout := &cappedBuffer{limit: maxExecOutput}
+	stderr := &cappedBuffer{limit: maxExecOutput}
+	log.Printf("exec: %q", req.Cmd)
+	clearDeadlines(w)
+	exitCode, timedOut, duration, err := runOutOfBand(req.Cmd, env, dir, timeout, stdout, stderr)
+	if err != nil {
+		respondError(w, r, http.StatusInternalServerError, protocol.ErrInternal, "can't run the command: "+err.Error())
+		return
+	}
+	if timedOut {
+		respondError(w, r, http.StatusRequestTimeout, protocol.ErrExecTimeout, fmt.Sprintf("the command was killed after %v", timeout))
+		return
+	}
+
+	processed, _, widgetIDs, updatedIDs := s.extractAndStoreHTML(stdout.buf.Bytes())
+	s.flushWidgetEvents()
+	for _, id := range widgetIDs {
+		s.broadcastHTMLNotification(id)
+	}
+	for _, id := range updatedIDs {
+		s.broadcastHTMLUpdate(id)
+	}
+	if widgetIDs == nil {
+		widgetIDs = []int{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(execResponse{
+		Stdout:     string(processed),
+		Stderr:     stderr.buf.String(),
+		ExitCode:   exitCode,
+		DurationMS: duration.Milliseconds(),
+		WidgetIDs:  widgetIDs,
+		Truncated:  stdout.truncated || stderr.truncated,
+	})
+}
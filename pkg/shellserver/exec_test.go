@@ -0,0 +1,69 @@
+package shellserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"shellserver/internal/testshell"
+	"shellserver/pkg/protocol"
+)
+
+func postExec(t *testing.T, url, body string) (int, execResponse, protocol.ErrorResponse) {
+	t.Helper()
+	resp, err := http.Post(url+"/exec", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var res execResponse
+	var e protocol.ErrorResponse
+	if resp.StatusCode == http.StatusOK {
+		json.NewDecoder(resp.Body).Decode(&res)
+	} else {
+		json.NewDecoder(resp.Body).Decode(&e)
+	}
+	return resp.StatusCode, res, e
+}
+
+func TestExecCapturesOutput(t *testing.T) {
+	_, ts := startFakeShellServer(t)
+	observer := testshell.Dial(t, ts.URL, "?role=observer")
+
+	code, res, _ := postExec(t, ts.URL, `{"cmd":"printf 'before\\033]9001;HTML_START\\007<b>hi</b>\\033]9001;HTML_END\\007after\\n'; echo oops >&2; echo $GOSHELL_URL; pwd; exit 4"}`)
+	if code != http.StatusOK {
+		t.Fatalf("POST /exec = %d", code)
+	}
+	if res.ExitCode != 4 || res.Stderr != "oops\n" || len(res.WidgetIDs) != 1 {
+		t.Errorf("result = %+v, want exit 4, stderr and one widget", res)
+	}
+	if !strings.HasPrefix(res.Stdout, "before\x1b]8;;htmlwidget:") || !strings.Contains(res.Stdout, "after\n") {
+		t.Errorf("stdout = %q, want the HTML block replaced by its link", res.Stdout)
+	}
+	wd, _ := os.Getwd()
+	if !strings.Contains(res.Stdout, "\nhttp://") || !strings.HasSuffix(res.Stdout, wd+"\n") {
+		t.Errorf("stdout = %q, want the shell's environment and directory", res.Stdout)
+	}
+	if ev := observer.ExpectEvent("html", testshell.DefaultTimeout); int(ev["widget_id"].(float64)) != res.WidgetIDs[0] {
+		t.Errorf("html event = %v, want widget %d", ev, res.WidgetIDs[0])
+	}
+}
+
+func TestExecTimeout(t *testing.T) {
+	_, ts := startFakeShellServer(t)
+	start := time.Now()
+	code, _, e := postExec(t, ts.URL, `{"cmd":"sleep 30","timeout_sec":0.2}`)
+	if code != http.StatusRequestTimeout || e.Error.Code != protocol.ErrExecTimeout {
+		t.Errorf("timed out command = %d %+v, want 408 exec_timeout", code, e)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("took %v to answer", elapsed)
+	}
+
+	if code, _, e := postExec(t, ts.URL, `{"timeout_sec":1}`); code != http.StatusBadRequest || e.Error.Code != protocol.ErrInvalidRequest {
+		t.Errorf("without cmd = %d %+v", code, e)
+	}
+}
@@ -0,0 +1,207 @@
+package shellserver
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"shellserver/web"
+)
+
+var flagWebroot = Flags.String("webroot", "", "serve the web UI from this directory instead of the copy built into the binary, to try changes without rebuilding")
+
+// hashedAsset matches a file whose name carries a hash of its content,
+// like main.3f2a9c1b.js, which can be cached for good.
+var hashedAsset = regexp.MustCompile(`\.[0-9a-f]{8,}\.[a-z]+$`)
+
+// webUI returns the UI's files: -webroot, or the built-in copy.
+func webUI() (fsys fs.FS, where string) {
+	if *flagWebroot != "" {
+		return os.DirFS(*flagWebroot), *flagWebroot
+	}
+	return web.Files, "the binary"
+}
+
+// registerUI serves the web UI in fsys at /, or the fallback page when
+// fsys has no index.html, as when -webroot names the wrong directory.
+func (s *ShellServer) registerUI(mux *http.ServeMux, fsys fs.FS, where string) {
+	if _, err := fs.Stat(fsys, "index.html"); err != nil {
+		log.Printf("web UI not found in %s (%v); serving the fallback terminal at /", where, err)
+		mux.HandleFunc("/", s.handleFallbackIndex)
+		return
+	}
+	ui := &uiFiles{fsys: fsys, etags: make(map[string]string)}
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			notFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Security-Policy", uiCSP(r.Host))
+		ui.serve(w, r, "index.html")
+	})
+	assets := func(w http.ResponseWriter, r *http.Request) {
+		ui.serve(w, r, strings.TrimPrefix(r.URL.Path, "/"))
+	}
+	mux.HandleFunc("/js/", assets)
+	mux.HandleFunc("/css/", assets)
+}
+
+// uiFiles serves the UI's files with validators, so a browser told not
+// to cache them can still ask whether they changed.
+type uiFiles struct {
+	fsys fs.FS
+
+	mu    sync.Mutex
+	etags map[string]string // name -> ETag, for files without a modification time
+}
+
+// serve sends the file name in fsys. Hashed assets may be cached for
+// good; everything else, index.html included, is revalidated each time,
+// so a new server version's UI is picked up at once.
+func (ui *uiFiles) serve(w http.ResponseWriter, r *http.Request, name string) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		methodNotAllowed(w, r, http.MethodGet, http.MethodHead)
+		return
+	}
+	if !fs.ValidPath(name) {
+		notFound(w, r)
+		return
+	}
+	data, err := fs.ReadFile(ui.fsys, name)
+	if err != nil {
+		notFound(w, r)
+		return
+	}
+	var modTime time.Time
+	if info, err := fs.Stat(ui.fsys, name); err == nil {
+		modTime = info.ModTime()
+	}
+	if modTime.IsZero() {
+		// Embedded files have no modification time to revalidate with
+		w.Header().Set("ETag", ui.etag(name, data))
+	}
+	if hashedAsset.MatchString(name) {
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	} else {
+		w.Header().Set("Cache-Control", "no-cache")
+	}
+	http.ServeContent(w, r, name, modTime, bytes.NewReader(data))
+}
+
+// etag returns the ETag for the content of name, worked out once.
+func (ui *uiFiles) etag(name string, data []byte) string {
+	ui.mu.Lock()
+	defer ui.mu.Unlock()
+	tag, ok := ui.etags[name]
+	if !ok {
+		sum := sha256.Sum256(data)
+		tag = `"` + hex.EncodeToString(sum[:8]) + `"`
+		ui.etags[name] = tag
+	}
+	return tag
+}
+
+// handleFallbackIndex serves fallbackPage at /.
+func (s *ShellServer) handleFallbackIndex(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		notFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(fallbackPage))
+}
+
+// fallbackPage is a bare-bones terminal for when the web UI's files
+// aren't available: output in a <pre> with escape sequences stripped,
+// input typed into a textarea and sent a line at a time. It connects to
+// /ws/shell beside the page, so it works behind a proxy that mounts
+// goshell under a path, with the token from the URL's #token= fragment.
+const fallbackPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>goshell (fallback terminal)</title>
+<style>
+body { margin: 0; padding: 12px; background: #1e1e1e; color: #abb2bf; font-family: monospace; font-size: 13px; }
+#banner { background: #3a3020; color: #e5c07b; border: 1px solid #e5c07b; padding: 8px; margin-bottom: 8px; }
+#status { color: #888; margin-bottom: 4px; }
+#out { height: 65vh; overflow-y: auto; white-space: pre-wrap; word-break: break-all; background: #111; padding: 8px; margin: 0; }
+#in { width: 100%; box-sizing: border-box; height: 3.5em; margin-top: 8px; background: #111; color: #abb2bf; border: 1px solid #404040; font: inherit; }
+button { font: inherit; margin-top: 4px; }
+</style>
+</head>
+<body>
+<div id="banner" role="note">This is goshell's fallback terminal: the full web UI wasn't found, because goshell serves it from the <code>web/</code> directory it is started in. Run goshell from the root of a checkout (or copy <code>web/</code> next to where you start it) to get the full UI. Escape sequences are stripped here, so full-screen programs won't display properly.</div>
+<div id="status">connecting…</div>
+<pre id="out" aria-live="polite"></pre>
+<textarea id="in" placeholder="Type a command and press Enter (Shift+Enter for a newline)" aria-label="Shell input"></textarea>
+<button type="button" id="ctrl-c">Send Ctrl-C</button>
+<button type="button" id="ctrl-d">Send Ctrl-D</button>
+<script>
+(function () {
+	var out = document.getElementById('out');
+	var input = document.getElementById('in');
+	var status = document.getElementById('status');
+	var scheme = location.protocol === 'https:' ? 'wss:' : 'ws:';
+	var base = location.pathname.replace(/\/[^\/]*$/, '');
+	var token = new URLSearchParams(location.hash.slice(1)).get('token') || sessionStorage.getItem('goshell-token');
+	var url = scheme + '//' + location.host + base + '/ws/shell';
+	if (token) {
+		sessionStorage.setItem('goshell-token', token);
+		history.replaceState(null, '', location.pathname + location.search);
+		url += '?token=' + encodeURIComponent(token);
+	}
+	var ws = new WebSocket(url);
+	ws.binaryType = 'arraybuffer';
+	var decoder = new TextDecoder();
+	var encoder = new TextEncoder();
+	// CSI, OSC and other escapes, then lone carriage returns and bells
+	var escapes = /\x1b\[[0-9;?]*[ -\/]*[@-~]|\x1b\][^\x07\x1b]*(\x07|\x1b\\)|\x1b[()][0-9A-Za-z]|\x1b[=>78DEHMc]/g;
+
+	function write(text) {
+		var atBottom = out.scrollTop + out.clientHeight >= out.scrollHeight - 4;
+		out.textContent += text.replace(escapes, '').replace(/\r\n/g, '\n').replace(/[\r\x07]/g, '');
+		if (out.textContent.length > 200000) {
+			out.textContent = out.textContent.slice(-150000);
+		}
+		if (atBottom) {
+			out.scrollTop = out.scrollHeight;
+		}
+	}
+	function send(text) {
+		if (ws.readyState === WebSocket.OPEN) {
+			// Binary frames are always input, never control messages
+			ws.send(encoder.encode(text));
+		}
+	}
+
+	ws.onopen = function () { status.textContent = 'connected'; };
+	ws.onclose = function () { status.textContent = 'disconnected; reload to reconnect, or open the #token= URL goshell logged'; };
+	ws.onmessage = function (ev) {
+		if (typeof ev.data !== 'string') {
+			write(decoder.decode(ev.data, {stream: true}));
+		}
+	};
+	input.addEventListener('keydown', function (ev) {
+		if (ev.key === 'Enter' && !ev.shiftKey) {
+			ev.preventDefault();
+			send(input.value + '\n');
+			input.value = '';
+		}
+	});
+	document.getElementById('ctrl-c').onclick = function () { send('\x03'); input.focus(); };
+	document.getElementById('ctrl-d').onclick = function () { send('\x04'); input.focus(); };
+	input.focus();
+})();
+</script>
+</body>
+</html>
+`
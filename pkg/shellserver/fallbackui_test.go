@@ -0,0 +1,131 @@
+package shellserver
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"shellserver/web"
+)
+
+func TestFallbackUI(t *testing.T) {
+	get := func(t *testing.T, mux *http.ServeMux, path string) (int, string) {
+		t.Helper()
+		ts := httptest.NewServer(mux)
+		defer ts.Close()
+		resp, err := http.Get(ts.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+	s := &ShellServer{}
+
+	t.Run("no assets", func(t *testing.T) {
+		mux := http.NewServeMux()
+		s.registerUI(mux, os.DirFS(filepath.Join(t.TempDir(), "web")), "nowhere")
+		status, page := get(t, mux, "/")
+		if status != http.StatusOK {
+			t.Fatalf("GET /: %d %s", status, page)
+		}
+		for _, want := range []string{`id="banner"`, "fallback terminal", "+ '/ws/shell'", "<textarea", "<pre"} {
+			if !strings.Contains(page, want) {
+				t.Errorf("fallback page missing %q", want)
+			}
+		}
+		if status, _ := get(t, mux, "/nope"); status != http.StatusNotFound {
+			t.Errorf("GET /nope: %d, want 404", status)
+		}
+	})
+
+	t.Run("assets", func(t *testing.T) {
+		dir := t.TempDir()
+		os.MkdirAll(filepath.Join(dir, "js"), 0o755)
+		os.WriteFile(filepath.Join(dir, "index.html"), []byte("<h1>full UI</h1>"), 0o644)
+		os.WriteFile(filepath.Join(dir, "js", "main.js"), []byte("// main"), 0o644)
+		mux := http.NewServeMux()
+		s.registerUI(mux, os.DirFS(dir), dir)
+		if _, page := get(t, mux, "/"); page != "<h1>full UI</h1>" {
+			t.Errorf("GET /: %q, want index.html", page)
+		}
+		if _, js := get(t, mux, "/js/main.js"); js != "// main" {
+			t.Errorf("GET /js/main.js: %q", js)
+		}
+	})
+}
+
+func TestEmbeddedUI(t *testing.T) {
+	mux := http.NewServeMux()
+	(&ShellServer{}).registerUI(mux, web.Files, "the binary")
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html") {
+		t.Fatalf("GET /: %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	if cc := resp.Header.Get("Cache-Control"); cc != "no-cache" {
+		t.Errorf("index.html Cache-Control = %q, want no-cache", cc)
+	}
+	etag := resp.Header.Get("ETag")
+	if etag == "" {
+		t.Fatal("no ETag on an embedded file")
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, ts.URL+"/", nil)
+	req.Header.Set("If-None-Match", etag)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotModified {
+		t.Errorf("revalidating with the ETag: %d, want 304", resp.StatusCode)
+	}
+
+	resp, err = http.Get(ts.URL + "/js/main.js")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); resp.StatusCode != http.StatusOK || !strings.HasPrefix(ct, "text/javascript") {
+		t.Errorf("GET /js/main.js: %d %s", resp.StatusCode, ct)
+	}
+	for _, path := range []string{"/js/", "/js/nope.js"} {
+		if resp, err := http.Get(ts.URL + path); err != nil {
+			t.Fatal(err)
+		} else if resp.Body.Close(); resp.StatusCode != http.StatusNotFound {
+			t.Errorf("GET %s: %d, want 404", path, resp.StatusCode)
+		}
+	}
+}
+
+func TestHashedAssetsCachedForGood(t *testing.T) {
+	fsys := fstest.MapFS{
+		"index.html":            {Data: []byte("<h1>ui</h1>")},
+		"js/main.3f2a9c1b77.js": {Data: []byte("// main")},
+	}
+	mux := http.NewServeMux()
+	(&ShellServer{}).registerUI(mux, fsys, "test")
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+	resp, err := http.Get(ts.URL + "/js/main.3f2a9c1b77.js")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if cc := resp.Header.Get("Cache-Control"); !strings.Contains(cc, "immutable") {
+		t.Errorf("hashed asset Cache-Control = %q, want immutable", cc)
+	}
+}
@@ -0,0 +1,404 @@
+package shellserver
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"shellserver/internal/styles"
+	"shellserver/pkg/protocol"
+)
+
+var (
+	flagFilesTTL    = Flags.Duration("files-ttl", time.Hour, "how long a directory mounted at /files/<token>/ by serveh stays served, unless it asks for less")
+	flagFilesMaxTTL = Flags.Duration("files-max-ttl", 24*time.Hour, "the longest a serveh mount may ask to be served")
+)
+
+// fileMount is a directory served read-only at /files/<token>/ until it
+// expires or is stopped.
+type fileMount struct {
+	Token   string    `json:"token"`
+	Dir     string    `json:"dir"` // symlinks resolved
+	Created time.Time `json:"created"`
+	Expires time.Time `json:"expires"`
+}
+
+// fileShares are the server's mounts. A nil *fileShares has none and
+// refuses new ones.
+type fileShares struct {
+	defaultTTL, maxTTL time.Duration
+
+	mu     sync.Mutex
+	mounts map[string]*fileMount // by token
+}
+
+func newFileShares(defaultTTL, maxTTL time.Duration) *fileShares {
+	return &fileShares{defaultTTL: defaultTTL, maxTTL: maxTTL, mounts: make(map[string]*fileMount)}
+}
+
+// mount starts serving dir, which must be a directory, for ttl (0 for the
+// default), capped at the maximum.
+func (f *fileShares) mount(dir string, ttl time.Duration, now time.Time) (*fileMount, error) {
+	if f == nil {
+		return nil, errors.New("file sharing is off")
+	}
+	real, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return nil, err
+	}
+	if real, err = filepath.Abs(real); err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(real)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", dir)
+	}
+	if ttl <= 0 {
+		ttl = f.defaultTTL
+	}
+	ttl = min(ttl, f.maxTTL)
+
+	var raw [16]byte
+	rand.Read(raw[:])
+	m := &fileMount{Token: hex.EncodeToString(raw[:]), Dir: real, Created: now, Expires: now.Add(ttl)}
+	f.mu.Lock()
+	f.mounts[m.Token] = m
+	f.mu.Unlock()
+	return m, nil
+}
+
+// lookup returns the unexpired mount token names, forgetting it once it
+// has expired.
+func (f *fileShares) lookup(token string, now time.Time) *fileMount {
+	if f == nil {
+		return nil
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	m := f.mounts[token]
+	if m != nil && !now.Before(m.Expires) {
+		delete(f.mounts, token)
+		return nil
+	}
+	return m
+}
+
+// unmount stops serving token, reporting whether it was mounted.
+func (f *fileShares) unmount(token string, now time.Time) bool {
+	if f.lookup(token, now) == nil {
+		return false
+	}
+	f.mu.Lock()
+	delete(f.mounts, token)
+	f.mu.Unlock()
+	return true
+}
+
+// list returns the unexpired mounts, oldest first, dropping expired ones.
+func (f *fileShares) list(now time.Time) []fileMount {
+	if f == nil {
+		return nil
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var mounts []fileMount
+	for token, m := range f.mounts {
+		if !now.Before(m.Expires) {
+			delete(f.mounts, token)
+			continue
+		}
+		mounts = append(mounts, *m)
+	}
+	sort.Slice(mounts, func(i, j int) bool { return mounts[i].Created.Before(mounts[j].Created) })
+	return mounts
+}
+
+// errOutsideMount is a request path that leaves its mount, through ".."
+// or a symlink.
+var errOutsideMount = errors.New("outside the mounted directory")
+
+// resolve maps rel, a slash-separated path below m, to a file inside m's
+// directory with symlinks resolved.
+func (m *fileMount) resolve(rel string) (string, error) {
+	for _, part := range strings.Split(rel, "/") {
+		if part == ".." {
+			return "", errOutsideMount
+		}
+	}
+	p, err := filepath.EvalSymlinks(filepath.Join(m.Dir, filepath.FromSlash(path.Clean("/"+rel))))
+	if err != nil {
+		return "", err
+	}
+	if inside, err := filepath.Rel(m.Dir, p); err != nil || inside == ".." || strings.HasPrefix(inside, ".."+string(filepath.Separator)) {
+		return "", errOutsideMount
+	}
+	return p, nil
+}
+
+// fileShareRequest is the body of POST /files.
+type fileShareRequest struct {
+	Dir string `json:"dir"`
+	TTL string `json:"ttl,omitempty"` // a Go duration; "" for -files-ttl
+}
+
+// fileShareResponse is what POST /files returns.
+type fileShareResponse struct {
+	fileMount
+	URL string `json:"url"`
+}
+
+// localURL is the URL tools in the shell reach the server at, listening
+// on addr: an unspecified host, which listens everywhere, is reached on
+// loopback.
+func localURL(addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return serverScheme() + "://" + addr
+	}
+	if ip := net.ParseIP(host); host == "" || ip != nil && ip.IsUnspecified() {
+		host = "127.0.0.1"
+	}
+	return serverScheme() + "://" + net.JoinHostPort(host, port)
+}
+
+// shareURL is the base of URLs handed out for other machines. Tools reach
+// the server as host, usually on loopback; when the server listens on
+// every interface (addr), the machine's hostname is used instead.
+func shareURL(host, addr string) string {
+	h, port, err := net.SplitHostPort(host)
+	if err != nil {
+		return serverScheme() + "://" + host
+	}
+	listen, _, _ := net.SplitHostPort(addr)
+	ip := net.ParseIP(h)
+	if (h == "localhost" || ip != nil && ip.IsLoopback()) && (listen == "" || net.ParseIP(listen) != nil && net.ParseIP(listen).IsUnspecified()) {
+		if name, err := os.Hostname(); err == nil {
+			h = name
+		}
+	}
+	return serverScheme() + "://" + net.JoinHostPort(h, port)
+}
+
+// isLoopback reports whether r came from this machine. Mounting and
+// unmounting is only for the shell's own tools; fetching is open to
+// whoever can reach the server.
+func isLoopback(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// authedUnmount wraps h to require the session token for DELETE, which
+// stops a mount, and not for fetching the mount's files.
+func (s *ShellServer) authedUnmount(h http.HandlerFunc) http.HandlerFunc {
+	authed := s.authed(h)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodDelete {
+			authed(w, r)
+			return
+		}
+		h(w, r)
+	}
+}
+
+// handleFiles serves POST /files, which mounts a directory, DELETE
+// /files/<token>, which unmounts it, and GET /files/<token>/<path>, the
+// mounted files and directory indexes.
+func (s *ShellServer) handleFiles(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	rest := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/files"), "/")
+	if rest == "" {
+		if r.Method != http.MethodPost {
+			methodNotAllowed(w, r, http.MethodPost)
+			return
+		}
+		if !isLoopback(r) {
+			respondError(w, r, http.StatusForbidden, protocol.ErrForbidden, "directories can only be mounted from the server's machine")
+			return
+		}
+		var req fileShareRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			invalidJSON(w, r, err)
+			return
+		}
+		var ttl time.Duration
+		if req.TTL != "" {
+			d, err := time.ParseDuration(req.TTL)
+			if err != nil {
+				respondError(w, r, http.StatusBadRequest, protocol.ErrInvalidRequest, "invalid ttl: "+err.Error())
+				return
+			}
+			ttl = d
+		}
+		if !filepath.IsAbs(req.Dir) {
+			respondError(w, r, http.StatusBadRequest, protocol.ErrInvalidRequest, "dir must be an absolute path")
+			return
+		}
+		m, err := s.fileShares.mount(req.Dir, ttl, now)
+		if err != nil {
+			respondError(w, r, http.StatusBadRequest, protocol.ErrInvalidRequest, err.Error())
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(fileShareResponse{
+			fileMount: *m,
+			URL:       shareURL(r.Host, s.options.Addr) + "/files/" + m.Token + "/",
+		})
+		return
+	}
+
+	token, rel, hasSlash := strings.Cut(rest, "/")
+	switch r.Method {
+	case http.MethodDelete:
+		if !isLoopback(r) {
+			respondError(w, r, http.StatusForbidden, protocol.ErrForbidden, "mounts can only be stopped from the server's machine")
+			return
+		}
+		if rel != "" || !s.fileShares.unmount(token, now) {
+			respondError(w, r, http.StatusNotFound, protocol.ErrMountNotFound, "no mount "+token)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	case http.MethodGet, http.MethodHead:
+	default:
+		methodNotAllowed(w, r, http.MethodGet, http.MethodHead, http.MethodDelete)
+		return
+	}
+
+	// Shared files are served on goshell's origin: a shared .html or
+	// .svg mustn't run script there, with the UI's storage in reach
+	w.Header().Set("Content-Security-Policy", "sandbox")
+	m := s.fileShares.lookup(token, now)
+	if m == nil {
+		respondError(w, r, http.StatusNotFound, protocol.ErrMountNotFound, "no mount "+token+", or it expired")
+		return
+	}
+	if !hasSlash {
+		http.Redirect(w, r, "/files/"+token+"/", http.StatusMovedPermanently)
+		return
+	}
+	p, err := m.resolve(rel)
+	if err != nil {
+		notFound(w, r)
+		return
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		notFound(w, r)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		notFound(w, r)
+		return
+	}
+	if info.IsDir() {
+		if rel != "" && !strings.HasSuffix(rel, "/") {
+			http.Redirect(w, r, path.Base(rel)+"/", http.StatusMovedPermanently)
+			return
+		}
+		entries, err := f.ReadDir(-1)
+		if err != nil {
+			respondError(w, r, http.StatusInternalServerError, protocol.ErrInternal, err.Error())
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprint(w, fileIndexHTML(m, rel, entries))
+		return
+	}
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	clearDeadlines(w)
+	http.ServeContent(w, r, info.Name(), info.ModTime(), f)
+}
+
+// fileIndexHTML renders a mounted directory as a page styled like lsh's
+// listings, each name linking to the entry.
+func fileIndexHTML(m *fileMount, rel string, entries []os.DirEntry) string {
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].IsDir() != entries[j].IsDir() {
+			return entries[i].IsDir()
+		}
+		return entries[i].Name() < entries[j].Name()
+	})
+	var nodes []*styles.TreeNode
+	if rel != "" {
+		nodes = append(nodes, &styles.TreeNode{Icon: "📁", IsDir: true, Cells: []string{`<a href="../">..</a>`, "", ""}})
+	}
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		name, icon, size := e.Name(), "📄", styles.FormatSize(info.Size())
+		href := url.PathEscape(name)
+		if e.IsDir() {
+			name, icon, size, href = name+"/", "📁", "", href+"/"
+		}
+		nodes = append(nodes, &styles.TreeNode{
+			Icon:  icon,
+			IsDir: e.IsDir(),
+			Cells: []string{
+				`<a href="` + styles.HTMLEscape(href) + `">` + styles.HTMLEscape(name) + `</a>`,
+				styles.HTMLEscape(info.ModTime().Format("Jan _2 15:04")),
+				size,
+			},
+		})
+	}
+	title := path.Join(filepath.Base(m.Dir), rel) + "/"
+	table := styles.RenderTreeTable(nodes, styles.TreeTableConfig{
+		Columns:      []styles.Column{{Class: "name", FlexGrow: true}, {Class: "date"}, {Class: "size", Align: styles.AlignRight}},
+		BarAfterCell: -1,
+		TogglePrefix: "files",
+		Label:        "Contents of " + title,
+	})
+	return `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>` + styles.HTMLEscape(title) + ` · goshell</title>
+<style>
+body {
+	margin: 0;
+	padding: 24px;
+	background: ` + styles.Colors.BgDark + `;
+	font-family: monospace;
+}` + styles.BaseCSS() + styles.TreeTableCSS() + `
+.tree-table a {
+	color: inherit;
+	text-decoration: none;
+}
+.tree-table a:hover {
+	text-decoration: underline;
+}
+</style>
+</head>
+<body>
+<main class="shell-container">
+<div class="shell-header"><h1 class="shell-title">` + styles.HTMLEscape(title) + `</h1>
+<div class="shell-meta">read-only until ` + styles.HTMLEscape(m.Expires.Format("Jan _2 15:04")) + `</div></div>
+` + table + `
+</main>
+</body>
+</html>
+`
+}
@@ -0,0 +1,261 @@
+package shellserver
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"shellserver/pkg/protocol"
+)
+
+// shareDir builds a directory to mount, with a secret beside it and
+// symlinks pointing at it, and returns the directory.
+func shareDir(t *testing.T) string {
+	t.Helper()
+	root := t.TempDir()
+	dir := filepath.Join(root, "dist")
+	for name, content := range map[string]string{
+		"dist/app.js":          "console.log(1)",
+		"dist/assets/logo.svg": "<svg/>",
+		"dist/a&b.txt":         "amp",
+		"secret.txt":           "do not serve",
+	} {
+		path := filepath.Join(root, name)
+		os.MkdirAll(filepath.Dir(path), 0o755)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	os.Symlink(filepath.Join(root, "secret.txt"), filepath.Join(dir, "escape.txt"))
+	os.Symlink(root, filepath.Join(dir, "up"))
+	os.Symlink("app.js", filepath.Join(dir, "inside.js"))
+	return dir
+}
+
+func mountFiles(t *testing.T, url, dir, ttl string) fileShareResponse {
+	t.Helper()
+	body, _ := json.Marshal(fileShareRequest{Dir: dir, TTL: ttl})
+	resp, err := http.Post(url+"/files", "application/json", strings.NewReader(string(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("mount %s: status %d", dir, resp.StatusCode)
+	}
+	var m fileShareResponse
+	if err := json.NewDecoder(resp.Body).Decode(&m); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func fetch(t *testing.T, url string) (int, string) {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(body)
+}
+
+func TestFileShare(t *testing.T) {
+	_, ts := startFakeShellServer(t)
+	dir := shareDir(t)
+	m := mountFiles(t, ts.URL, dir, "10m")
+	if !strings.HasSuffix(m.URL, "/files/"+m.Token+"/") || m.Dir != dir {
+		t.Errorf("mount = %+v", m)
+	}
+	if ttl := m.Expires.Sub(m.Created); ttl != 10*time.Minute {
+		t.Errorf("ttl = %v, want 10m", ttl)
+	}
+	base := ts.URL + "/files/" + m.Token
+
+	if code, body := fetch(t, base+"/app.js"); code != http.StatusOK || body != "console.log(1)" {
+		t.Errorf("app.js: %d %q", code, body)
+	}
+	if code, body := fetch(t, base+"/inside.js"); code != http.StatusOK || body != "console.log(1)" {
+		t.Errorf("symlink inside the mount: %d %q", code, body)
+	}
+	for _, path := range []string{"/assets/logo.svg", "/"} {
+		resp, err := http.Get(base + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if csp := resp.Header.Get("Content-Security-Policy"); csp != "sandbox" {
+			t.Errorf("%s: Content-Security-Policy %q, want sandbox", path, csp)
+		}
+	}
+
+	code, index := fetch(t, base+"/")
+	if code != http.StatusOK {
+		t.Fatalf("index: status %d", code)
+	}
+	for _, want := range []string{`<ul class="tree-table" role="tree"`, `href="assets/">assets/</a>`, `href="app.js">app.js</a>`, `href="a&amp;b.txt">a&amp;b.txt</a>`} {
+		if !strings.Contains(index, want) {
+			t.Errorf("index missing %q:\n%s", want, index)
+		}
+	}
+	if strings.Contains(index, `href="../"`) {
+		t.Error("mount root links to its parent")
+	}
+	if code, sub := fetch(t, base+"/assets/"); code != http.StatusOK || !strings.Contains(sub, `href="logo.svg"`) || !strings.Contains(sub, `href="../"`) {
+		t.Errorf("subdirectory index: %d\n%s", code, sub)
+	}
+
+	if st := getStatus(t, ts.URL); len(st.Mounts) != 1 || st.Mounts[0].Token != m.Token {
+		t.Errorf("status mounts = %+v", st.Mounts)
+	}
+}
+
+func TestFileShareEscapes(t *testing.T) {
+	_, ts := startFakeShellServer(t)
+	m := mountFiles(t, ts.URL, shareDir(t), "")
+	base := ts.URL + "/files/" + m.Token
+
+	for _, path := range []string{
+		"/escape.txt",
+		"/up/secret.txt",
+		"/%2e%2e/secret.txt",
+		"/assets/%2e%2e/%2e%2e/secret.txt",
+		"/..%2fsecret.txt",
+	} {
+		if code, body := fetch(t, base+path); code == http.StatusOK || strings.Contains(body, "do not serve") {
+			t.Errorf("%s: status %d, body %q", path, code, body)
+		}
+	}
+}
+
+func TestFileShareStopAndExpiry(t *testing.T) {
+	s, ts := startFakeShellServer(t)
+	dir := shareDir(t)
+	m := mountFiles(t, ts.URL, dir, "")
+
+	req, _ := http.NewRequest(http.MethodDelete, ts.URL+"/files/"+m.Token, nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("stop: status %d", resp.StatusCode)
+	}
+	if code, _ := fetch(t, ts.URL+"/files/"+m.Token+"/app.js"); code != http.StatusNotFound {
+		t.Errorf("fetch after stop: status %d", code)
+	}
+	resp, _ = http.DefaultClient.Do(req)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("second stop: status %d", resp.StatusCode)
+	}
+
+	now := time.Now()
+	expiring, err := s.fileShares.mount(dir, time.Minute, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.fileShares.lookup(expiring.Token, now.Add(59*time.Second)) == nil {
+		t.Error("mount gone before its ttl")
+	}
+	if len(s.fileShares.list(now.Add(time.Minute))) != 0 {
+		t.Error("expired mount still listed")
+	}
+	if s.fileShares.lookup(expiring.Token, now) != nil {
+		t.Error("expired mount served")
+	}
+
+	long, _ := s.fileShares.mount(dir, 1000*time.Hour, now)
+	if got := long.Expires.Sub(now); got != *flagFilesMaxTTL {
+		t.Errorf("ttl %v not capped at %v", got, *flagFilesMaxTTL)
+	}
+}
+
+func TestFileShareMountsOnlyFromLoopback(t *testing.T) {
+	s := &ShellServer{fileShares: newFileShares(time.Hour, time.Hour)}
+	req := httptest.NewRequest(http.MethodPost, "/files", strings.NewReader(`{"dir":"/"}`))
+	req.RemoteAddr = "192.0.2.7:4000"
+	w := httptest.NewRecorder()
+	s.handleFiles(w, req)
+
+	var env protocol.ErrorResponse
+	json.NewDecoder(w.Body).Decode(&env)
+	if w.Code != http.StatusForbidden || env.Error.Code != protocol.ErrForbidden {
+		t.Errorf("remote mount: %d %+v", w.Code, env)
+	}
+	if len(s.fileShares.list(time.Now())) != 0 {
+		t.Error("remote request mounted a directory")
+	}
+}
+
+func TestShareURL(t *testing.T) {
+	host, _ := os.Hostname()
+	tests := []struct{ host, addr, want string }{
+		{"127.0.0.1:7777", "127.0.0.1:7777", "http://127.0.0.1:7777"},
+		{"127.0.0.1:7777", "0.0.0.0:7777", "http://" + host + ":7777"},
+		{"127.0.0.1:7777", ":7777", "http://" + host + ":7777"},
+		{"build.lan:7777", "0.0.0.0:7777", "http://build.lan:7777"},
+	}
+	for _, tt := range tests {
+		if got := shareURL(tt.host, tt.addr); got != tt.want {
+			t.Errorf("shareURL(%q, %q) = %q, want %q", tt.host, tt.addr, got, tt.want)
+		}
+	}
+	if got := localURL("0.0.0.0:7777"); got != "http://127.0.0.1:7777" {
+		t.Errorf("localURL = %q", got)
+	}
+}
+
+func TestFileShareMountNeedsSessionToken(t *testing.T) {
+	s, ts := startFakeShellServer(t)
+	s.authToken = "s3cret"
+	dir := shareDir(t)
+
+	do := func(method, path, body, token string) *http.Response {
+		t.Helper()
+		req, _ := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+	req, _ := json.Marshal(fileShareRequest{Dir: dir})
+	if resp := do(http.MethodPost, "/files", string(req), ""); resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("mount without the token: %d, want 401", resp.StatusCode)
+	}
+	if len(s.fileShares.list(time.Now())) != 0 {
+		t.Fatal("unauthenticated request mounted a directory")
+	}
+	if resp := do(http.MethodPost, "/files", string(req), "s3cret"); resp.StatusCode != http.StatusOK {
+		t.Fatalf("mount with the token: %d", resp.StatusCode)
+	}
+	mounts := s.fileShares.list(time.Now())
+	if len(mounts) != 1 {
+		t.Fatalf("mounts = %+v, want one", mounts)
+	}
+	base := "/files/" + mounts[0].Token
+
+	// The mount's own token is enough to fetch from it
+	if code, body := fetch(t, ts.URL+base+"/app.js"); code != http.StatusOK || body != "console.log(1)" {
+		t.Errorf("fetch without the session token: %d %q", code, body)
+	}
+	if resp := do(http.MethodDelete, base, "", ""); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("stop without the token: %d, want 401", resp.StatusCode)
+	}
+	if resp := do(http.MethodDelete, base, "", "s3cret"); resp.StatusCode != http.StatusNoContent {
+		t.Errorf("stop with the token: %d, want 204", resp.StatusCode)
+	}
+}
@@ -0,0 +1,59 @@
+package shellserver
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Flags holds goshell's command-line flags, which cmd/goshell parses
+// before Main fills Options from them. A program embedding the server
+// gives NewServer its Settings in Options instead.
+var Flags = flag.NewFlagSet("goshell", flag.ExitOnError)
+
+// stringListFlag is a repeatable string flag.
+type stringListFlag []string
+
+func (f *stringListFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *stringListFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+// byteSizeFlag is a size in bytes, given as a number with an optional K,
+// M or G suffix for KiB, MiB or GiB, such as 256K or 4M.
+type byteSizeFlag int
+
+// sizeUnits are byteSizeFlag's suffixes, each 1024 times the last.
+const sizeUnits = "KMG"
+
+func (f *byteSizeFlag) String() string {
+	n := int(*f)
+	for i := len(sizeUnits) - 1; i >= 0; i-- {
+		if size := 1 << (10 * (i + 1)); n != 0 && n%size == 0 {
+			return strconv.Itoa(n/size) + sizeUnits[i:i+1]
+		}
+	}
+	return strconv.Itoa(n)
+}
+
+func (f *byteSizeFlag) Set(value string) error {
+	s := strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(value)), "B")
+	shift := 0
+	if s != "" {
+		if i := strings.IndexByte(sizeUnits, s[len(s)-1]); i >= 0 {
+			shift = 10 * (i + 1)
+			s = s[:len(s)-1]
+		}
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 || n > (1<<31-1)>>shift {
+		return fmt.Errorf("invalid size %q: want a number of bytes with an optional K, M or G suffix", value)
+	}
+	*f = byteSizeFlag(n << shift)
+	return nil
+}
@@ -0,0 +1,50 @@
+package shellserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"shellserver/internal/freshness"
+	"shellserver/pkg/protocol"
+)
+
+// widgetHandler holds the server-side hooks for one kind of widget,
+// named by the hook in the widget's freshness marker.
+type widgetHandler struct {
+	// fresh reports whether the marker's attributes still describe the
+	// widget's source.
+	fresh func(attrs map[string]string) bool
+}
+
+// widgetHandlers is the widget handler registry.
+var widgetHandlers = map[string]widgetHandler{
+	"fs": {fresh: freshness.DirFresh}, // listings of a directory: lsh, duh
+}
+
+// handleWidgetFresh serves GET /htmlwidget/{id}/fresh, answering
+// {"state":"fresh"} or {"state":"stale"} for widgets carrying a freshness
+// marker whose hook is registered, and 404 for everything else.
+func (s *ShellServer) handleWidgetFresh(w http.ResponseWriter, r *http.Request, widgetID int) {
+	s.htmlWidgetsMu.RLock()
+	content, ok := s.widgetHTML(widgetID)
+	s.htmlWidgetsMu.RUnlock()
+	if !ok {
+		s.widgetNotFound(w, r, widgetID)
+		return
+	}
+	hook, attrs, ok := freshness.Find(content)
+	handler, registered := widgetHandlers[hook]
+	if !ok || !registered || handler.fresh == nil {
+		respondError(w, r, http.StatusNotFound, protocol.ErrFreshnessNotTracked,
+			fmt.Sprintf("widget %d has no freshness marker goshell can check", widgetID))
+		return
+	}
+
+	state := "stale"
+	if handler.fresh(attrs) {
+		state = "fresh"
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"state": state})
+}
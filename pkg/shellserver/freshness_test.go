@@ -0,0 +1,58 @@
+package shellserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"shellserver/internal/freshness"
+)
+
+func TestWidgetFresh(t *testing.T) {
+	s := newDiffTestServer(0)
+	dir := t.TempDir()
+	marker, err := freshness.DirMarker(dir, "lsh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listing := replaceWidget(s, marker+"<div>listing</div>")
+	s.extractAndStoreHTML([]byte(string(htmlStartMarker) + "<div>plain</div>" + string(htmlEndMarker)))
+	plain := listing + 1
+	unknownHook := replaceWidgetKeyed(s, "other", freshness.Marker("nosuchhook", nil))
+
+	get := func(id int) (int, string) {
+		rec := httptest.NewRecorder()
+		s.handleHTMLWidget(rec, httptest.NewRequest(http.MethodGet, fmt.Sprintf("/htmlwidget/%d/fresh", id), nil))
+		var body struct{ State string }
+		json.NewDecoder(rec.Body).Decode(&body)
+		return rec.Code, body.State
+	}
+
+	if code, state := get(listing); code != http.StatusOK || state != "fresh" {
+		t.Errorf("untouched directory: %d %q, want fresh", code, state)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "new"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if code, state := get(listing); code != http.StatusOK || state != "stale" {
+		t.Errorf("after adding a file: %d %q, want stale", code, state)
+	}
+
+	for name, id := range map[string]int{"no marker": plain, "unknown hook": unknownHook, "missing widget": 99} {
+		if code, _ := get(id); code != http.StatusNotFound {
+			t.Errorf("%s: %d, want 404", name, code)
+		}
+	}
+}
+
+// replaceWidgetKeyed writes content through the replaces-widget protocol
+// under key.
+func replaceWidgetKeyed(s *ShellServer, key, content string) int {
+	block := "\x1b]9001;HTML_START;key=" + key + "\x07" + content + string(htmlEndMarker)
+	_, _, ids, updated := s.extractAndStoreHTML([]byte(block))
+	return append(ids, updated...)[0]
+}
@@ -0,0 +1,168 @@
+package shellserver
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"shellserver/pkg/protocol"
+)
+
+var (
+	flagHeavyConcurrency  = Flags.Int("heavy-concurrency", 4, "weight of expensive requests (widget HTML and search, recordings, /files and /download downloads) served at once; the rest queue (0 for no limit)")
+	flagHeavyQueueTimeout = Flags.Duration("heavy-queue-timeout", 5*time.Second, "how long an expensive request queues for -heavy-concurrency before a 503")
+)
+
+// gatedRoutes are the routes expensive enough to share -heavy-concurrency,
+// and how much of it one request takes. Everything else, the websocket and
+// keystroke-level endpoints in particular, is never gated.
+var gatedRoutes = map[string]int{
+	"/htmlwidget/": 1, // multi-megabyte widgets, search and diffs
+	"/recordings/": 2, // streams and searches whole casts
+	"/files/":      2, // downloads of whatever serveh mounted
+	"/download":    2, // files and directories archived on the fly
+}
+
+// requestGate is a weighted semaphore for expensive requests. Requests
+// queue in arrival order; one that waits longer than timeout is rejected.
+// A nil gate admits everything.
+type requestGate struct {
+	capacity int
+	timeout  time.Duration
+
+	mu       sync.Mutex
+	inUse    int
+	waiters  []*gateWaiter    // oldest first
+	rejected map[string]int64 // by route
+}
+
+type gateWaiter struct {
+	weight int
+	ready  chan struct{} // closed once admitted
+}
+
+// newRequestGate returns a gate admitting capacity at once, or nil for no
+// limit.
+func newRequestGate(capacity int, timeout time.Duration) *requestGate {
+	if capacity <= 0 {
+		return nil
+	}
+	return &requestGate{capacity: capacity, timeout: timeout, rejected: make(map[string]int64)}
+}
+
+// acquire waits until weight fits, ctx ends or the queue timeout passes.
+// A weight over capacity takes the whole gate.
+func (g *requestGate) acquire(ctx context.Context, weight int) error {
+	weight = min(weight, g.capacity)
+	g.mu.Lock()
+	if len(g.waiters) == 0 && g.inUse+weight <= g.capacity {
+		g.inUse += weight
+		g.mu.Unlock()
+		return nil
+	}
+	wt := &gateWaiter{weight: weight, ready: make(chan struct{})}
+	g.waiters = append(g.waiters, wt)
+	g.mu.Unlock()
+
+	timer := time.NewTimer(g.timeout)
+	defer timer.Stop()
+	var err error
+	select {
+	case <-wt.ready:
+		return nil
+	case <-timer.C:
+		err = fmt.Errorf("server busy: queued %s for an expensive request slot", g.timeout)
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	select {
+	case <-wt.ready:
+		// Admitted as we gave up; take it rather than hand it back
+		return nil
+	default:
+	}
+	for i, other := range g.waiters {
+		if other == wt {
+			g.waiters = append(g.waiters[:i], g.waiters[i+1:]...)
+			break
+		}
+	}
+	// Leaving the head of the queue may let those behind in
+	g.admitLocked()
+	return err
+}
+
+// release returns weight taken by acquire.
+func (g *requestGate) release(weight int) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.inUse -= min(weight, g.capacity)
+	g.admitLocked()
+}
+
+// admitLocked admits waiters in order while they fit.
+func (g *requestGate) admitLocked() {
+	for len(g.waiters) > 0 && g.inUse+g.waiters[0].weight <= g.capacity {
+		wt := g.waiters[0]
+		g.waiters = g.waiters[1:]
+		g.inUse += wt.weight
+		close(wt.ready)
+	}
+}
+
+// gateStats is the gate's state, published as the heavy_requests metric.
+type gateStats struct {
+	Capacity int              `json:"capacity"`
+	InUse    int              `json:"in_use"`
+	Queued   int              `json:"queued"`
+	Rejected map[string]int64 `json:"rejected"` // by route
+}
+
+func (g *requestGate) stats() gateStats {
+	if g == nil {
+		return gateStats{Rejected: map[string]int64{}}
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	rejected := make(map[string]int64, len(g.rejected))
+	for route, n := range g.rejected {
+		rejected[route] = n
+	}
+	return gateStats{Capacity: g.capacity, InUse: g.inUse, Queued: len(g.waiters), Rejected: rejected}
+}
+
+// gated wraps the handler for route in s.gate when route is one of
+// gatedRoutes.
+func (s *ShellServer) gated(route string, h http.HandlerFunc) http.HandlerFunc {
+	weight, ok := gatedRoutes[route]
+	if !ok {
+		return h
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		g := s.gate
+		if g == nil {
+			h(w, r)
+			return
+		}
+		if err := g.acquire(r.Context(), weight); err != nil {
+			if r.Context().Err() != nil {
+				return
+			}
+			g.mu.Lock()
+			g.rejected[route]++
+			g.mu.Unlock()
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(g.timeout.Seconds()))))
+			respondError(w, r, http.StatusServiceUnavailable, protocol.ErrServerBusy, err.Error())
+			return
+		}
+		defer g.release(weight)
+		h(w, r)
+	}
+}
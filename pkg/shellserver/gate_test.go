@@ -0,0 +1,131 @@
+package shellserver
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"shellserver/pkg/protocol"
+)
+
+func TestRequestGateOrder(t *testing.T) {
+	g := newRequestGate(2, time.Second)
+	ctx := context.Background()
+	if err := g.acquire(ctx, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	// A heavy waiter at the head holds back a light one behind it
+	admitted := make(chan int, 2)
+	for i, weight := range []int{2, 1} {
+		go func(i, weight int) {
+			if err := g.acquire(ctx, weight); err == nil {
+				admitted <- i
+			}
+		}(i, weight)
+		waitFor(t, "waiter queued", func() bool { return g.stats().Queued == i+1 })
+	}
+	g.release(2)
+	if first := <-admitted; first != 0 {
+		t.Errorf("waiter %d admitted first, want the oldest", first)
+	}
+	select {
+	case i := <-admitted:
+		t.Errorf("waiter %d admitted past capacity", i)
+	case <-time.After(50 * time.Millisecond):
+	}
+	g.release(2)
+	<-admitted
+	if st := g.stats(); st.InUse != 1 || st.Queued != 0 {
+		t.Errorf("stats = %+v", st)
+	}
+
+	// Weights over capacity take the whole gate rather than never fitting
+	g.release(1)
+	if err := g.acquire(ctx, 10); err != nil {
+		t.Errorf("acquire over capacity: %v", err)
+	}
+}
+
+func TestGatedRoutes(t *testing.T) {
+	defer func(c int, d time.Duration) { *flagHeavyConcurrency, *flagHeavyQueueTimeout = c, d }(*flagHeavyConcurrency, *flagHeavyQueueTimeout)
+	*flagHeavyConcurrency, *flagHeavyQueueTimeout = 2, 300*time.Millisecond
+	s, ts := startFakeShellServer(t)
+
+	// Saturate the gate as a long download would
+	if err := s.gate.acquire(context.Background(), 2); err != nil {
+		t.Fatal(err)
+	}
+	released := false
+	defer func() {
+		if !released {
+			s.gate.release(2)
+		}
+	}()
+
+	type result struct {
+		resp *http.Response
+		err  error
+	}
+	done := make(chan result, 1)
+	start := time.Now()
+	go func() {
+		resp, err := http.Get(ts.URL + "/htmlwidget/")
+		done <- result{resp, err}
+	}()
+	waitFor(t, "request queued", func() bool { return s.gate.stats().Queued == 1 })
+
+	// Ungated routes answer while the gated one waits
+	resp, err := http.Get(ts.URL + "/status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("/status while saturated: %d", resp.StatusCode)
+	}
+	select {
+	case <-done:
+		t.Fatal("gated request answered while the gate was full")
+	default:
+	}
+
+	res := <-done
+	if res.err != nil {
+		t.Fatal(res.err)
+	}
+	defer res.resp.Body.Close()
+	if waited := time.Since(start); waited < 300*time.Millisecond {
+		t.Errorf("rejected after %s, before the queue timeout", waited)
+	}
+	var body protocol.ErrorResponse
+	json.NewDecoder(res.resp.Body).Decode(&body)
+	if res.resp.StatusCode != http.StatusServiceUnavailable || body.Error.Code != protocol.ErrServerBusy {
+		t.Errorf("timed out request: %d %q", res.resp.StatusCode, body.Error.Code)
+	}
+	if got := res.resp.Header.Get("Retry-After"); got != "1" {
+		t.Errorf("Retry-After = %q, want 1", got)
+	}
+	if st := s.gate.stats(); st.Queued != 0 || st.Rejected["/htmlwidget/"] != 1 {
+		t.Errorf("stats after rejection = %+v", st)
+	}
+
+	// A queued request goes through once the gate frees up
+	go func() {
+		resp, err := http.Get(ts.URL + "/htmlwidget/")
+		done <- result{resp, err}
+	}()
+	waitFor(t, "request queued", func() bool { return s.gate.stats().Queued == 1 })
+	s.gate.release(2)
+	released = true
+	res = <-done
+	if res.err != nil {
+		t.Fatal(res.err)
+	}
+	res.resp.Body.Close()
+	if res.resp.StatusCode != http.StatusOK {
+		t.Errorf("queued request: %d, want 200", res.resp.StatusCode)
+	}
+}
@@ -0,0 +1,104 @@
+package shellserver
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"syscall"
+	"time"
+
+	"shellserver/internal/procstats"
+	"shellserver/pkg/protocol"
+)
+
+// shellGoneGrace is how long monitorStatus lets the PTY reader run on
+// after the shell has gone. An exit normally ends the read by itself; one
+// that doesn't means a process the shell left behind, such as a
+// background job, holds the terminal open. Those are hung up, and killed
+// if they're still there after another shellGoneGrace, so the exit is
+// handled, and the shell restarted, like any other.
+const shellGoneGrace = time.Second
+
+// leftoverReaper ends what a gone shell left holding its terminal.
+type leftoverReaper struct {
+	gone    time.Time // when the shell was first seen gone; zero while it runs
+	signals int       // signals sent since
+}
+
+// check is called on every monitorStatus tick while the PTY is read.
+func (lr *leftoverReaper) check(shellPGID int, now time.Time) {
+	if processRunning(shellPGID) {
+		*lr = leftoverReaper{}
+		return
+	}
+	if lr.gone.IsZero() {
+		lr.gone = now
+		return
+	}
+	switch gone := now.Sub(lr.gone); {
+	case lr.signals == 0 && gone >= shellGoneGrace:
+		log.Printf("shell %d is gone but its terminal is still open; hanging up what it left running", shellPGID)
+		hangUpSession(shellPGID, syscall.SIGHUP)
+		lr.signals++
+	case lr.signals == 1 && gone >= 2*shellGoneGrace:
+		hangUpSession(shellPGID, syscall.SIGKILL)
+		lr.signals++
+	}
+}
+
+// hangUpSession sends sig to every process left in the shell's session,
+// the session being named by its leader, the shell.
+func hangUpSession(shellPGID int, sig syscall.Signal) {
+	procs, err := procstats.Host.Processes()
+	if err != nil {
+		log.Printf("hang up session %d: %v", shellPGID, err)
+		return
+	}
+	for _, p := range procs {
+		if p.Session == shellPGID && p.PID != shellPGID {
+			syscall.Kill(p.PID, sig)
+		}
+	}
+}
+
+// health reports why the session can't serve a shell, or "" if it can:
+// there must be a PTY, its shell must be running, and this generation's
+// streamPTY must still be reading it. It only takes ptyMu long enough to
+// copy what it checks, so a supervisor may ask as often as it likes.
+func (s *ShellServer) health() string {
+	s.ptyMu.Lock()
+	ptyFile, shellPGID, streaming := s.ptyFile, s.shellPGID, s.ptyStreaming
+	s.ptyMu.Unlock()
+	if s.hibernated.Load() {
+		// A client connecting starts the shell again
+		return ""
+	}
+	if ptyFile == nil {
+		return "no pty"
+	}
+	select {
+	case <-streaming:
+		return "pty reader stopped"
+	default:
+	}
+	if !processRunning(shellPGID) {
+		return "shell not running"
+	}
+	return ""
+}
+
+// handleHealthz serves GET /healthz: 200 {"ok":true} while the session
+// is healthy, and 503 with the reason while it isn't, such as between a
+// shell exiting and its automatic restart.
+func (s *ShellServer) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r, http.MethodGet)
+		return
+	}
+	if reason := s.health(); reason != "" {
+		respondError(w, r, http.StatusServiceUnavailable, protocol.ErrUnhealthy, reason)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]bool{"ok": true})
+}
@@ -0,0 +1,72 @@
+package shellserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"shellserver/internal/testshell"
+	"shellserver/pkg/protocol"
+)
+
+// getHealth fetches /healthz, returning its status and, for a 503, the
+// reason.
+func getHealth(t *testing.T, url string) (int, string) {
+	t.Helper()
+	resp, err := http.Get(url + "/healthz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		return resp.StatusCode, ""
+	}
+	var body protocol.ErrorResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Error.Code != protocol.ErrUnhealthy {
+		t.Errorf("code = %q, want %q", body.Error.Code, protocol.ErrUnhealthy)
+	}
+	return resp.StatusCode, body.Error.Message
+}
+
+func TestHealthzAfterShellExit(t *testing.T) {
+	old := *flagNoAutorestart
+	*flagNoAutorestart = true
+	t.Cleanup(func() { *flagNoAutorestart = old })
+
+	_, ts := startFakeShellServer(t)
+	c := testshell.Dial(t, ts.URL, "")
+	if status, reason := getHealth(t, ts.URL); status != http.StatusOK {
+		t.Fatalf("healthz = %d %q for a running shell", status, reason)
+	}
+
+	c.Send("exit 0")
+	c.ExpectEvent("status", testshell.DefaultTimeout)
+	status, reason := getHealth(t, ts.URL)
+	if status != http.StatusServiceUnavailable || reason == "" {
+		t.Errorf("healthz = %d %q after the shell exited, want 503 with a reason", status, reason)
+	}
+}
+
+func TestHealthzRecoversShellHeldOpen(t *testing.T) {
+	_, ts := startFakeShellServer(t)
+	c := testshell.Dial(t, ts.URL, "")
+
+	// The child keeps the terminal open, so the shell's exit doesn't
+	// end the PTY reads; the watchdog has to notice.
+	c.Send("bg 5s")
+	c.ExpectOutput("bg 5s\r\n$ ", testshell.DefaultTimeout)
+	c.Send("exit 0")
+	// The child has the terminal to itself for a moment, so it shows as running
+	for ev := c.ExpectEvent("status", 3*shellGoneGrace+testshell.DefaultTimeout); ev["state"] != "exited"; {
+		ev = c.ExpectEvent("status", 3*shellGoneGrace+testshell.DefaultTimeout)
+	}
+	waitFor(t, "a healthy restarted shell", func() bool {
+		status, _ := getHealth(t, ts.URL)
+		return status == http.StatusOK
+	})
+	c.Send("echo after")
+	c.ExpectOutput("\r\nafter\r\n", testshell.DefaultTimeout)
+}
@@ -0,0 +1,231 @@
+package shellserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"shellserver/pkg/protocol"
+)
+
+var flagHistorySize = Flags.Int("history-size", 1000, "commands kept for GET /history, from the shell integration's markers (0 keeps none)")
+
+// historyEntry is a command the shell integration reported running.
+type historyEntry struct {
+	N          int       `json:"n"` // counts up from 1 for the server's life
+	Command    string    `json:"command"`
+	ExitCode   int       `json:"exit_code"`
+	Started    time.Time `json:"started"`
+	Finished   time.Time `json:"finished"`
+	DurationMs int64     `json:"duration_ms"`
+}
+
+// commandHistory is the last limit commands the shell ran, oldest first.
+type commandHistory struct {
+	mu      sync.Mutex
+	limit   int
+	last    int // N of the newest entry
+	entries []historyEntry
+}
+
+// add records f, unless the shell didn't say what the command was. A
+// nil history records nothing.
+func (h *commandHistory) add(f finishedCommand) {
+	if h == nil || h.limit <= 0 || strings.TrimSpace(f.Command) == "" {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.last++
+	h.entries = append(h.entries, historyEntry{
+		N:          h.last,
+		Command:    f.Command,
+		ExitCode:   f.ExitCode,
+		Started:    f.FinishedAt.Add(-f.Duration),
+		Finished:   f.FinishedAt,
+		DurationMs: f.Duration.Milliseconds(),
+	})
+	if over := len(h.entries) - h.limit; over > 0 {
+		h.entries = append(h.entries[:0:0], h.entries[over:]...)
+	}
+}
+
+// list returns the entries whose command contains q, ignoring case,
+// oldest first; all of them for an empty q.
+func (h *commandHistory) list(q string) []historyEntry {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	q = strings.ToLower(q)
+	list := []historyEntry{}
+	for _, e := range h.entries {
+		if strings.Contains(strings.ToLower(e.Command), q) {
+			list = append(list, e)
+		}
+	}
+	return list
+}
+
+// get returns entry n, if it is still kept.
+func (h *commandHistory) get(n int) (historyEntry, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, e := range h.entries {
+		if e.N == n {
+			return e, true
+		}
+	}
+	return historyEntry{}, false
+}
+
+// handleHistory serves GET /history, the commands the shell has run,
+// with ?q= keeping those containing a substring, and
+// POST /history/{n}/run, which runs entry n again as a widget's shell
+// action would be: subject to -widget-cmd-allow and confirmation, and
+// refused or, with ?queue=1, queued while a command is running. It
+// needs no widget to vouch for it under -widget-cmd-signed, as the
+// shell's own history is where it came from.
+func (s *ShellServer) handleHistory(w http.ResponseWriter, r *http.Request) {
+	rest := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/history"), "/")
+	if rest == "" {
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w, r, http.MethodGet)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(s.history.list(r.URL.Query().Get("q")))
+		return
+	}
+
+	num, action, _ := strings.Cut(rest, "/")
+	n, err := strconv.Atoi(num)
+	if err != nil || action != "run" {
+		notFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, r, http.MethodPost)
+		return
+	}
+	entry, ok := s.history.get(n)
+	if !ok {
+		respondError(w, r, http.StatusNotFound, protocol.ErrHistoryNotFound, fmt.Sprintf("no history entry %d; it may have aged out of -history-size", n))
+		return
+	}
+	if !s.isAllowedCmd(entry.Command) {
+		s.rejectWidgetCmd(w, r, entry.Command, errors.New("not matched by -widget-cmd-allow"))
+		return
+	}
+	s.runShellAction(w, r, entry.Command, false)
+}
+
+// integrationMarkers start the integration sequences taken out of the
+// output once the command tracker has read them: the command line and
+// the OSC 133 prompt and command marks. OSC 7, which terminals use for
+// the working directory, is left in.
+var integrationMarkers = [][]byte{[]byte("\x1b]9001;CMD;"), []byte("\x1b]133;")}
+
+// markerStripper removes integration markers from PTY output, holding
+// back a marker split across reads until the rest of it arrives.
+type markerStripper struct {
+	pending []byte
+}
+
+// integrationMark is a marker strip took out: its payload, as
+// parseShellEvent takes it, and where it was in the output strip returned.
+type integrationMark struct {
+	Pos     int
+	Payload string
+}
+
+// strip returns data, after what was held back, without integration
+// markers, and the markers it took out. A marker still unterminated after
+// maxTrackedSequence bytes is let through.
+func (m *markerStripper) strip(data []byte) ([]byte, []integrationMark) {
+	if len(m.pending) > 0 {
+		data = append(m.pending, data...)
+		m.pending = nil
+	}
+	var out []byte
+	var marks []integrationMark
+	last := 0
+	for i := 0; i < len(data); i++ {
+		if data[i] != 0x1b {
+			continue
+		}
+		rest := data[i:]
+		marker, partial := matchMarker(rest)
+		if partial {
+			m.pending = bytes.Clone(rest)
+			data = data[:i]
+			break
+		}
+		if marker == 0 {
+			continue
+		}
+		end := markerEnd(rest, marker)
+		if end < 0 {
+			if len(rest) < maxTrackedSequence {
+				m.pending = bytes.Clone(rest)
+				data = data[:i]
+				break
+			}
+			continue
+		}
+		if out == nil {
+			out = make([]byte, 0, len(data))
+		}
+		out = append(out, data[last:i]...)
+		payload := rest[2:end]
+		if payload[len(payload)-1] == 0x07 {
+			payload = payload[:len(payload)-1]
+		} else {
+			payload = payload[:len(payload)-2]
+		}
+		marks = append(marks, integrationMark{Pos: len(out), Payload: string(payload)})
+		last = i + end
+		i = last - 1
+	}
+	if out == nil {
+		return data, nil
+	}
+	return append(out, data[last:]...), marks
+}
+
+// matchMarker reports the length of the integration marker prefix rest
+// starts with, or partial if rest is cut short inside one.
+func matchMarker(rest []byte) (n int, partial bool) {
+	for _, prefix := range integrationMarkers {
+		if bytes.HasPrefix(rest, prefix) {
+			return len(prefix), false
+		}
+		if len(rest) < len(prefix) && bytes.HasPrefix(prefix, rest) {
+			return 0, true
+		}
+	}
+	return 0, false
+}
+
+// markerEnd returns the offset just past the BEL or ST ending the
+// sequence in rest, searching from from, or -1 if it hasn't ended.
+func markerEnd(rest []byte, from int) int {
+	for j := from; j < len(rest); j++ {
+		switch rest[j] {
+		case 0x07:
+			return j + 1
+		case 0x1b:
+			if j+1 == len(rest) {
+				return -1
+			}
+			if rest[j+1] == '\\' {
+				return j + 2
+			}
+		}
+	}
+	return -1
+}
@@ -0,0 +1,133 @@
+package shellserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"shellserver/internal/testshell"
+	"shellserver/pkg/protocol"
+)
+
+func TestMarkerStripper(t *testing.T) {
+	tests := []struct {
+		name  string
+		reads []string
+		want  string
+		marks []string // payload@offset in the output
+	}{
+		{"command and marks", []string{"a\x1b]9001;CMD;bHM=\x07\x1b]133;C\x07b\x1b]133;D;0\x07\x1b]133;A\x07$ "}, "ab$ ",
+			[]string{"9001;CMD;bHM=@1", "133;C@1", "133;D;0@2", "133;A@2"}},
+		{"split in the prefix", []string{"a\x1b]13", "3;A\x07b"}, "ab", []string{"133;A@1"}},
+		{"split in the body", []string{"a\x1b]9001;CMD;bH", "M=\x07b"}, "ab", []string{"9001;CMD;bHM=@1"}},
+		{"string terminator", []string{"a\x1b]133;A\x1b\\b"}, "ab", []string{"133;A@1"}},
+		{"other sequences kept", []string{"\x1b]0;title\x07\x1b]7;file://h/tmp\x07\x1b[1mx"}, "\x1b]0;title\x07\x1b]7;file://h/tmp\x07\x1b[1mx", nil},
+		{"OSC 8 link kept", []string{"\x1b]8;;htmlwidget:1\x07link\x1b]8;;\x07"}, "\x1b]8;;htmlwidget:1\x07link\x1b]8;;\x07", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var m markerStripper
+			var got []byte
+			var marks []string
+			for _, read := range tt.reads {
+				out, found := m.strip([]byte(read))
+				for _, mark := range found {
+					marks = append(marks, fmt.Sprintf("%s@%d", mark.Payload, len(got)+mark.Pos))
+				}
+				got = append(got, out...)
+			}
+			if string(got) != tt.want {
+				t.Errorf("stripped %q, want %q", got, tt.want)
+			}
+			if fmt.Sprint(marks) != fmt.Sprint(tt.marks) {
+				t.Errorf("marks %q, want %q", marks, tt.marks)
+			}
+		})
+	}
+}
+
+func getHistory(t *testing.T, url string) []historyEntry {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var list []historyEntry
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		t.Fatal(err)
+	}
+	return list
+}
+
+func TestHistory(t *testing.T) {
+	s, ts := startFakeShellServer(t)
+	c := testshell.Dial(t, ts.URL, "")
+	for _, cmd := range []string{"echo rerun-me", "make all"} {
+		c.Send("mark command " + cmd)
+		c.Send("mark exec")
+		c.Send("mark finished 0")
+	}
+	c.Send(`raw "all-done\x21\n"`)
+	out := c.ExpectOutput("all-done!", testshell.DefaultTimeout)
+	if strings.Contains(out, "\x1b]133;") || strings.Contains(out, "9001;CMD") {
+		t.Errorf("integration markers reached the client: %q", out)
+	}
+
+	var list []historyEntry
+	waitFor(t, "both commands in the history", func() bool {
+		list = getHistory(t, ts.URL+"/history")
+		return len(list) == 2
+	})
+	if list[0].N != 1 || list[0].Command != "echo rerun-me" || list[1].Command != "make all" || list[0].Finished.IsZero() {
+		t.Errorf("history = %+v", list)
+	}
+	if list := getHistory(t, ts.URL+"/history?q=MAKE"); len(list) != 1 || list[0].N != 2 {
+		t.Errorf("?q=MAKE = %+v", list)
+	}
+
+	// Re-running goes through the widget command checks
+	allowWidgetCmds(s, `^ls$`)
+	resp, err := http.Post(ts.URL+"/history/1/run", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusForbidden || errorCode(t, resp) != protocol.ErrCommandNotAllowed {
+		t.Errorf("disallowed re-run: status %d", resp.StatusCode)
+	}
+	resp.Body.Close()
+
+	allowWidgetCmds(s, `^echo `)
+	resp, err = http.Post(ts.URL+"/history/1/run", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var held map[string]string
+	json.NewDecoder(resp.Body).Decode(&held)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted || held["confirm_id"] == "" {
+		t.Errorf("re-run under confirmation: status %d %v, want it held", resp.StatusCode, held)
+	}
+
+	s.confirmWidgetCmds = false
+	resp, err = http.Post(ts.URL+"/history/1/run", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("re-run: status %d", resp.StatusCode)
+	}
+	c.ExpectOutput("\r\nrerun-me\r\n", testshell.DefaultTimeout)
+
+	resp, err = http.Post(ts.URL+"/history/99/run", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusNotFound || errorCode(t, resp) != protocol.ErrHistoryNotFound {
+		t.Errorf("unknown entry: status %d", resp.StatusCode)
+	}
+	resp.Body.Close()
+}
@@ -0,0 +1,47 @@
+package shellserver
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"time"
+)
+
+var (
+	flagReadHeaderTimeout = Flags.Duration("http-read-header-timeout", 10*time.Second, "how long a client may take to send a request's headers before it is disconnected")
+	flagReadTimeout       = Flags.Duration("http-read-timeout", 30*time.Second, "how long a client may take to send a whole request, body included (0 for no limit)")
+	flagWriteTimeout      = Flags.Duration("http-write-timeout", time.Minute, "how long a response may take, from the end of the request's headers, before the connection is closed (0 for no limit); the websocket, downloads and /exec aren't held to it")
+	flagHTTPIdleTimeout   = Flags.Duration("http-idle-timeout", 2*time.Minute, "how long an idle keep-alive connection is kept open")
+	flagMaxHeaderBytes    = byteSizeFlag(64 << 10)
+)
+
+func init() {
+	Flags.Var(&flagMaxHeaderBytes, "max-header-bytes", "largest request header block, such as 64K, the server reads")
+}
+
+// newHTTPServer returns the server for h, with the timeouts and header
+// limit from the flags, so a client that trickles its request in (a
+// slowloris) can't hold a connection open. Requests' contexts derive
+// from ctx, so they are cancelled when the server starts shutting down.
+func newHTTPServer(ctx context.Context, h http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              *flagAddr,
+		Handler:           h,
+		ReadHeaderTimeout: *flagReadHeaderTimeout,
+		ReadTimeout:       *flagReadTimeout,
+		WriteTimeout:      *flagWriteTimeout,
+		IdleTimeout:       *flagHTTPIdleTimeout,
+		MaxHeaderBytes:    int(flagMaxHeaderBytes),
+		BaseContext:       func(net.Listener) context.Context { return ctx },
+	}
+}
+
+// clearDeadlines frees a handler that legitimately runs long, such as a
+// download or the websocket, from -http-read-timeout and
+// -http-write-timeout. Where the writer doesn't support deadlines it
+// does nothing.
+func clearDeadlines(w http.ResponseWriter) {
+	rc := http.NewResponseController(w)
+	rc.SetReadDeadline(time.Time{})
+	rc.SetWriteDeadline(time.Time{})
+}
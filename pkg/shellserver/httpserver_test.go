@@ -0,0 +1,72 @@
+package shellserver
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"shellserver/internal/testshell"
+)
+
+// startTimeoutServer serves s through newHTTPServer's settings, with
+// the timeouts overridden to be short.
+func startTimeoutServer(t *testing.T, s *ShellServer, timeout time.Duration) *httptest.Server {
+	t.Helper()
+	defer func(old time.Duration) { *flagReadHeaderTimeout = old }(*flagReadHeaderTimeout)
+	defer func(old time.Duration) { *flagReadTimeout = old }(*flagReadTimeout)
+	defer func(old time.Duration) { *flagWriteTimeout = old }(*flagWriteTimeout)
+	*flagReadHeaderTimeout, *flagReadTimeout, *flagWriteTimeout = timeout, timeout, timeout
+
+	mux := http.NewServeMux()
+	s.registerRoutes(mux)
+	ts := httptest.NewUnstartedServer(nil)
+	ts.Config = newHTTPServer(context.Background(), withMiddleware(mux))
+	ts.Start()
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func TestHTTPServerDropsStalledHeaders(t *testing.T) {
+	s, err := newShellServerWithShell(testshell.Command())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	ts := startTimeoutServer(t, s, 200*time.Millisecond)
+
+	conn, err := net.Dial("tcp", ts.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := io.WriteString(conn, "GET /status HTTP/1.1\r\nHost: x\r\n"); err != nil {
+		t.Fatal(err)
+	}
+	// The rest of the header never comes
+	conn.SetReadDeadline(time.Now().Add(testshell.DefaultTimeout))
+	start := time.Now()
+	_, err = io.ReadAll(conn)
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		t.Fatalf("still connected after %v", time.Since(start))
+	}
+}
+
+func TestHTTPServerKeepsWebSocket(t *testing.T) {
+	s, err := newShellServerWithShell(testshell.Command())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	ts := startTimeoutServer(t, s, 200*time.Millisecond)
+
+	c := testshell.Dial(t, ts.URL, "")
+	c.Send("echo before")
+	c.ExpectOutput("\r\nbefore\r\n", testshell.DefaultTimeout)
+	time.Sleep(600 * time.Millisecond)
+	c.Send("echo after")
+	c.ExpectOutput("\r\nafter\r\n", testshell.DefaultTimeout)
+}
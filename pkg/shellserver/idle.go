@@ -0,0 +1,147 @@
+package shellserver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"syscall"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+var (
+	flagIdleTimeout = Flags.Duration("idle-timeout", 0, "after this long with no input and no client connecting, take -idle-action (0 never does)")
+	flagIdleAction  = Flags.String("idle-action", "hibernate", `what -idle-timeout does: "hibernate" ends the shell, starting a new one when a client next connects, and "exit" shuts the server down`)
+)
+
+// idleWarning is how long before -idle-timeout acts that clients are
+// warned, and idleCountdownStep how often the warning counts down. The
+// clock is checked every idleCheckInterval.
+const (
+	idleWarning       = time.Minute
+	idleCountdownStep = 10 * time.Second
+	idleCheckInterval = time.Second
+)
+
+// hibernatedNote marks in the scrollback where an idle shell was ended.
+const hibernatedNote = "\r\n\x1b[33m--- idle for %v; shell hibernated ---\x1b[0m\r\n"
+
+// checkIdleFlags validates -idle-timeout and -idle-action.
+func checkIdleFlags() error {
+	if *flagIdleTimeout < 0 {
+		return fmt.Errorf("-idle-timeout must not be negative, not %v", *flagIdleTimeout)
+	}
+	if *flagIdleAction != "hibernate" && *flagIdleAction != "exit" {
+		return fmt.Errorf("-idle-action must be hibernate or exit, not %q", *flagIdleAction)
+	}
+	return nil
+}
+
+// touchActive records activity that keeps the session from going idle:
+// input written to the shell, or a client connecting or staying
+// connected. Output, status broadcasts and the monitor's polling don't
+// count.
+func (s *ShellServer) touchActive(now time.Time) {
+	s.lastActive.Store(now.UnixNano())
+}
+
+// idleFor returns how long the session has gone without activity.
+func (s *ShellServer) idleFor(now time.Time) time.Duration {
+	return now.Sub(time.Unix(0, s.lastActive.Load()))
+}
+
+// watchIdle calls onIdle once the session has been idle for timeout,
+// warning clients for the last warn of it with a countdown,
+// {"kind":"idle-warning","seconds":N,"action":...}, every
+// idleCountdownStep. A tick with a client connected counts as activity,
+// so a tab left watching a long build keeps its shell. While the shell is
+// hibernated there is nothing to watch; a client connecting wakes it and
+// starts the clock again.
+func (s *ShellServer) watchIdle(ctx context.Context, timeout, warn time.Duration, action string, onIdle func()) {
+	if s.lastActive.Load() == 0 {
+		s.touchActive(time.Now())
+	}
+	ticker := time.NewTicker(min(idleCheckInterval, timeout/10))
+	defer ticker.Stop()
+	warned := 0 // countdown steps left when clients were last warned; 0 for none
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if s.hibernated.Load() {
+			continue
+		}
+		now := time.Now()
+		if s.clientCounts().Connected > 0 {
+			s.touchActive(now)
+		}
+		left := timeout - s.idleFor(now)
+		switch {
+		case left <= 0:
+			warned = 0
+			log.Printf("idle for %v: %s", timeout, action)
+			onIdle()
+		case left <= warn:
+			step := int((left + idleCountdownStep - 1) / idleCountdownStep)
+			if step != warned {
+				warned = step
+				s.broadcastIdleWarning(left, action)
+			}
+		default:
+			warned = 0
+		}
+	}
+}
+
+// broadcastIdleWarning tells clients the idle action comes in left.
+func (s *ShellServer) broadcastIdleWarning(left time.Duration, action string) {
+	data, _ := json.Marshal(map[string]any{"kind": "idle-warning", "seconds": int(left.Round(time.Second).Seconds()), "action": action})
+	s.broadcastMessage(websocket.TextMessage, data)
+}
+
+// hibernate ends an idle shell the way a restart ends the old one, but
+// starts none: the session keeps its scrollback and clients, reports
+// state "hibernated", and gets a fresh shell when a client next connects
+// or on POST /restart.
+func (s *ShellServer) hibernate() {
+	if !s.hibernated.CompareAndSwap(false, true) {
+		return
+	}
+	s.ptyMu.Lock()
+	if s.ptyCancel != nil {
+		s.ptyCancel()
+	}
+	if s.ptyFile != nil {
+		s.ptyFile.Close()
+	}
+	shell, pgid := s.shell, s.shellPGID
+	s.ptyFile, s.shell, s.shellPGID = nil, nil, 0
+	s.ptyMu.Unlock()
+	s.fgPGID.Store(0)
+
+	if pgid > 0 {
+		syscall.Kill(-pgid, syscall.SIGHUP)
+	}
+	if _, ok := shell.wait(shellReapTimeout); !ok && pgid > 0 {
+		log.Printf("hibernate: shell still running %v after hangup; killing it", shellReapTimeout)
+		syscall.Kill(-pgid, syscall.SIGKILL)
+		shell.wait(shellReapTimeout)
+	}
+	s.output([]byte(fmt.Sprintf(hibernatedNote, s.idleFor(time.Now()).Round(time.Second))), false, nil)
+	s.broadcastStatus("hibernated", foregroundJob{})
+}
+
+// wake starts a fresh shell if the session is hibernated.
+func (s *ShellServer) wake() {
+	if !s.hibernated.CompareAndSwap(true, false) {
+		return
+	}
+	log.Printf("waking the hibernated session")
+	if err := s.relaunch(false); err != nil {
+		log.Printf("wake: %v", err)
+	}
+}
@@ -0,0 +1,85 @@
+package shellserver
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"shellserver/internal/testshell"
+)
+
+func TestIdleHibernate(t *testing.T) {
+	s, ts := startFakeShellServer(t)
+	c := testshell.Dial(t, ts.URL, "")
+	s.ptyMu.Lock()
+	oldPGID := s.shellPGID
+	s.ptyMu.Unlock()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	hibernated := make(chan struct{}, 1)
+	go s.watchIdle(ctx, 300*time.Millisecond, 0, "hibernate", func() {
+		s.hibernate()
+		hibernated <- struct{}{}
+	})
+
+	// A client that stays connected keeps the shell, typing or not
+	select {
+	case <-hibernated:
+		t.Fatal("hibernated with a client connected")
+	case <-time.After(900 * time.Millisecond):
+	}
+	c.Send("echo still-here")
+	c.ExpectOutput("\r\nstill-here\r\n", testshell.DefaultTimeout)
+
+	c.Close()
+	select {
+	case <-hibernated:
+	case <-time.After(testshell.DefaultTimeout):
+		t.Fatal("never hibernated once the client left")
+	}
+	if processRunning(oldPGID) {
+		t.Errorf("shell %d still running after hibernating", oldPGID)
+	}
+	if st := getStatus(t, ts.URL); st.Shell.State != "hibernated" {
+		t.Errorf("/status shell state %q, want hibernated", st.Shell.State)
+	}
+	if reason := s.health(); reason != "" {
+		t.Errorf("hibernated session unhealthy: %s", reason)
+	}
+
+	// The next client to connect wakes it with a new shell
+	cancel()
+	c2 := testshell.Dial(t, ts.URL, "")
+	c2.ExpectOutput("shell hibernated", testshell.DefaultTimeout)
+	c2.Send("echo awake")
+	c2.ExpectOutput("\r\nawake\r\n", testshell.DefaultTimeout)
+	if s.hibernated.Load() {
+		t.Error("still hibernated after a client connected")
+	}
+}
+
+func TestIdleWarning(t *testing.T) {
+	s, ts := startFakeShellServer(t)
+	c := testshell.Dial(t, ts.URL, "")
+
+	s.broadcastIdleWarning(42*time.Second, "exit")
+	warning := c.ExpectEvent("idle-warning", testshell.DefaultTimeout)
+	if warning["action"] != "exit" || warning["seconds"] != float64(42) {
+		t.Errorf("warning = %v", warning)
+	}
+}
+
+func TestIdleExit(t *testing.T) {
+	s, _ := startFakeShellServer(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	exited := make(chan struct{})
+	go s.watchIdle(ctx, 200*time.Millisecond, 0, "exit", func() { close(exited) })
+	select {
+	case <-exited:
+	case <-time.After(testshell.DefaultTimeout):
+		t.Fatal("idle exit never came, though status polling went on")
+	}
+}
@@ -0,0 +1,136 @@
+package shellserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/websocket"
+
+	"shellserver/pkg/protocol"
+)
+
+var flagNoInputLock = Flags.Bool("no-input-lock", false, "let every writer type into the shell at once, instead of only the one holding input control")
+
+// claimInput reports whether c may write to the shell. With the input
+// lock, the first writer to type takes control and keeps it until it
+// disconnects or another writer takes it over; anyone else is told who
+// holds it with {"kind":"input-denied"}.
+func (s *ShellServer) claimInput(c *client) bool {
+	if *flagNoInputLock {
+		return true
+	}
+	s.clientsMu.Lock()
+	owner := s.inputOwner
+	if owner == nil {
+		s.inputOwner = c
+	}
+	out := c.out
+	s.clientsMu.Unlock()
+	if owner == nil {
+		s.broadcastInputOwner(c.id, "")
+		return true
+	}
+	if owner == c {
+		return true
+	}
+	if out != nil {
+		data, _ := json.Marshal(map[string]string{"kind": "input-denied", "owner": owner.id})
+		out.send(outbound{msgType: websocket.TextMessage, data: data})
+	}
+	return false
+}
+
+// takeInput gives c input control, taking it from whoever holds it.
+func (s *ShellServer) takeInput(c *client) {
+	s.clientsMu.Lock()
+	previous := s.inputOwner
+	s.inputOwner = c
+	s.clientsMu.Unlock()
+	if previous == c {
+		return
+	}
+	previousID := ""
+	if previous != nil {
+		previousID = previous.id
+	}
+	log.Printf("input control: %s takes over from %q", c.id, previousID)
+	s.broadcastInputOwner(c.id, previousID)
+}
+
+// releaseInput frees input control if c holds it, so the next writer to
+// type takes it.
+func (s *ShellServer) releaseInput(c *client) {
+	s.clientsMu.Lock()
+	released := s.inputOwner == c
+	if released {
+		s.inputOwner = nil
+	}
+	s.clientsMu.Unlock()
+	if released {
+		s.broadcastInputOwner("", c.id)
+	}
+}
+
+// currentInputOwner returns the ID of the client holding input control,
+// or "".
+func (s *ShellServer) currentInputOwner() string {
+	s.clientsMu.RLock()
+	defer s.clientsMu.RUnlock()
+	if s.inputOwner == nil {
+		return ""
+	}
+	return s.inputOwner.id
+}
+
+// broadcastInputOwner tells every client who holds input control now
+// and, if it was taken from someone, who had it.
+func (s *ShellServer) broadcastInputOwner(owner, previous string) {
+	msg := map[string]string{"kind": "input-owner", "owner": owner}
+	if previous != "" {
+		msg["previous"] = previous
+	}
+	data, _ := json.Marshal(msg)
+	s.broadcastMessage(websocket.TextMessage, data)
+}
+
+// connectedClient returns the connected client with the given ID, or nil.
+func (s *ShellServer) connectedClient(id string) *client {
+	s.clientsMu.RLock()
+	defer s.clientsMu.RUnlock()
+	for _, c := range s.clients {
+		if c.id == id {
+			return c
+		}
+	}
+	return nil
+}
+
+// handleControlTake serves POST /control/take {"client_id":"client-3"},
+// which gives a connected writer input control, and answers {"owner"}.
+func (s *ShellServer) handleControlTake(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, r, http.MethodPost)
+		return
+	}
+	var req struct {
+		ClientID string `json:"client_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		invalidJSON(w, r, err)
+		return
+	}
+	c := s.connectedClient(req.ClientID)
+	if c == nil {
+		respondError(w, r, http.StatusNotFound, protocol.ErrClientNotFound, fmt.Sprintf("no connected client %q", req.ClientID))
+		return
+	}
+	if c.readOnly {
+		respondError(w, r, http.StatusConflict, protocol.ErrReadOnlyClient, c.id+" is an observer")
+		return
+	}
+	s.takeInput(c)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"owner": c.id})
+}
@@ -0,0 +1,88 @@
+package shellserver
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+
+	"shellserver/internal/testshell"
+	"shellserver/pkg/protocol"
+)
+
+func postControlTake(t *testing.T, url, clientID string) int {
+	t.Helper()
+	resp, err := http.Post(url+"/control/take", "application/json", strings.NewReader(`{"client_id":"`+clientID+`"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestInputLock(t *testing.T) {
+	_, ts := startFakeShellServer(t)
+	a := testshell.Dial(t, ts.URL, "")
+	b := testshell.Dial(t, ts.URL, "")
+
+	a.Send("echo from-a")
+	a.ExpectOutput("\r\nfrom-a\r\n", testshell.DefaultTimeout)
+	if ev := b.ExpectEvent("input-owner", testshell.DefaultTimeout); ev["owner"] != a.Ready["client_id"] {
+		t.Errorf("input-owner = %v, want the first writer", ev)
+	}
+	b.Send("echo from-b")
+	if ev := b.ExpectEvent("input-denied", testshell.DefaultTimeout); ev["owner"] != a.Ready["client_id"] {
+		t.Errorf("input-denied = %v, want it to name the owner", ev)
+	}
+
+	if code := postControlTake(t, ts.URL, b.Ready["client_id"].(string)); code != http.StatusOK {
+		t.Fatalf("POST /control/take = %d", code)
+	}
+	a.ExpectEvent("input-owner", testshell.DefaultTimeout) // its own claim
+	if ev := a.ExpectEvent("input-owner", testshell.DefaultTimeout); ev["owner"] != b.Ready["client_id"] || ev["previous"] != a.Ready["client_id"] {
+		t.Errorf("takeover event = %v", ev)
+	}
+	b.Send("echo from-b")
+	a.ExpectOutput("\r\nfrom-b\r\n", testshell.DefaultTimeout)
+	a.Send("echo denied")
+	a.ExpectEvent("input-denied", testshell.DefaultTimeout)
+
+	// Control is freed when its holder disconnects
+	url := "ws" + strings.TrimPrefix(ts.URL, "http") + "/ws/shell"
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.WriteJSON(map[string]string{"kind": "take-control"})
+	a.ExpectEvent("input-owner", testshell.DefaultTimeout)
+	conn.Close()
+	if ev := a.ExpectEvent("input-owner", testshell.DefaultTimeout); ev["owner"] != "" {
+		t.Errorf("after the owner left: %v, want no owner", ev)
+	}
+	a.Send("echo again")
+	a.ExpectOutput("\r\nagain\r\n", testshell.DefaultTimeout)
+}
+
+func TestControlTakeErrors(t *testing.T) {
+	_, ts := startFakeShellServer(t)
+	observer := testshell.Dial(t, ts.URL, "?role=observer")
+	if code := postControlTake(t, ts.URL, observer.Ready["client_id"].(string)); code != http.StatusConflict {
+		t.Errorf("observer taking control = %d, want 409 %s", code, protocol.ErrReadOnlyClient)
+	}
+	if code := postControlTake(t, ts.URL, "client-999"); code != http.StatusNotFound {
+		t.Errorf("unknown client taking control = %d, want 404 %s", code, protocol.ErrClientNotFound)
+	}
+}
+
+func TestNoInputLock(t *testing.T) {
+	defer func(old bool) { *flagNoInputLock = old }(*flagNoInputLock)
+	*flagNoInputLock = true
+	_, ts := startFakeShellServer(t)
+	a := testshell.Dial(t, ts.URL, "")
+	b := testshell.Dial(t, ts.URL, "")
+	a.Send("echo from-a")
+	a.ExpectOutput("\r\nfrom-a\r\n", testshell.DefaultTimeout)
+	b.Send("echo from-b")
+	a.ExpectOutput("\r\nfrom-b\r\n", testshell.DefaultTimeout)
+}
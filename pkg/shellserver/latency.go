@@ -0,0 +1,220 @@
+package shellserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+var flagTrace = Flags.Bool("trace", false, "time every input frame's round trip through the PTY, for GET /debug/latency (clients can opt in alone with {\"kind\":\"trace\",\"enabled\":true})")
+
+// Bounds on what the tracer keeps.
+const (
+	latencySampleCap  = 256 // completed round trips kept for /debug/latency
+	latencyPendingMax = 64  // traced inputs awaiting output; older ones are dropped
+)
+
+// latencySample is the round trip of one traced input frame: read from a
+// websocket, written to the PTY, answered by the next PTY output and that
+// output written to each client.
+type latencySample struct {
+	Seq      uint64    `json:"seq"`
+	Received time.Time `json:"received"`
+
+	written time.Time
+	read    time.Time
+
+	Input     time.Duration   `json:"input_ns"`     // websocket read to PTY write
+	Shell     time.Duration   `json:"shell_ns"`     // PTY write, shell and PTY read
+	Process   time.Duration   `json:"process_ns"`   // PTY read to broadcast start
+	Broadcast []time.Duration `json:"broadcast_ns"` // broadcast start to each client's websocket write, queueing included
+	Total     time.Duration   `json:"total_ns"`     // websocket read to the last client write
+}
+
+// latencyTracer correlates traced input frames with the output that
+// follows them. Each traced input gets a sequence number and waits in
+// pending; the next PTY read answers every input waiting. A nil tracer
+// traces nothing.
+type latencyTracer struct {
+	mu      sync.Mutex
+	seq     uint64
+	pending []*latencySample
+	samples []latencySample // ring of completed round trips
+	next    int             // where the ring's next sample goes
+}
+
+// input records a traced frame read from a websocket at received that is
+// about to be written to the PTY.
+func (t *latencyTracer) input(received, written time.Time) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.seq++
+	t.pending = append(t.pending, &latencySample{
+		Seq:      t.seq,
+		Received: received,
+		written:  written,
+		Input:    written.Sub(received),
+	})
+	if len(t.pending) > latencyPendingMax {
+		t.pending = t.pending[len(t.pending)-latencyPendingMax:]
+	}
+}
+
+// outputRead takes the inputs the PTY output read at read answers, or
+// nil when none are waiting.
+func (t *latencyTracer) outputRead(read time.Time) []*latencySample {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.pending) == 0 {
+		return nil
+	}
+	traced := t.pending
+	t.pending = nil
+	for _, sample := range traced {
+		sample.read = read
+		sample.Shell = read.Sub(sample.written)
+	}
+	return traced
+}
+
+// finish completes traced samples with a broadcast that started at start
+// and took writes, one per client.
+func (t *latencyTracer) finish(traced []*latencySample, start time.Time, writes []time.Duration) {
+	var slowest time.Duration
+	for _, d := range writes {
+		slowest = max(slowest, d)
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, sample := range traced {
+		sample.Process = start.Sub(sample.read)
+		sample.Broadcast = writes
+		sample.Total = start.Add(slowest).Sub(sample.Received)
+		if len(t.samples) < latencySampleCap {
+			t.samples = append(t.samples, *sample)
+		} else {
+			t.samples[t.next] = *sample
+		}
+		t.next = (t.next + 1) % latencySampleCap
+	}
+}
+
+// recent returns the completed samples, oldest first.
+func (t *latencyTracer) recent() []latencySample {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.samples) < latencySampleCap {
+		return append([]latencySample(nil), t.samples...)
+	}
+	return append(append([]latencySample(nil), t.samples[t.next:]...), t.samples[:t.next]...)
+}
+
+// latencyPercentiles summarizes one stage over the samples, in
+// milliseconds.
+type latencyPercentiles struct {
+	P50 float64 `json:"p50_ms"`
+	P90 float64 `json:"p90_ms"`
+	P99 float64 `json:"p99_ms"`
+	Max float64 `json:"max_ms"`
+}
+
+func percentiles(ds []time.Duration) latencyPercentiles {
+	if len(ds) == 0 {
+		return latencyPercentiles{}
+	}
+	sorted := append([]time.Duration(nil), ds...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	at := func(p float64) float64 {
+		i := int(p * float64(len(sorted)-1))
+		return float64(sorted[i]) / float64(time.Millisecond)
+	}
+	return latencyPercentiles{P50: at(0.50), P90: at(0.90), P99: at(0.99), Max: at(1)}
+}
+
+// latencyReport is GET /debug/latency.
+type latencyReport struct {
+	Tracing bool                          `json:"tracing"` // -trace: every input is traced
+	Stages  map[string]latencyPercentiles `json:"stages"`
+	Samples []latencySample               `json:"samples"`
+}
+
+func newLatencyReport(samples []latencySample, tracing bool) latencyReport {
+	stages := map[string][]time.Duration{}
+	for _, sample := range samples {
+		stages["input"] = append(stages["input"], sample.Input)
+		stages["shell"] = append(stages["shell"], sample.Shell)
+		stages["process"] = append(stages["process"], sample.Process)
+		stages["broadcast"] = append(stages["broadcast"], sample.Broadcast...)
+		stages["total"] = append(stages["total"], sample.Total)
+	}
+	report := latencyReport{Tracing: tracing, Stages: map[string]latencyPercentiles{}, Samples: samples}
+	for _, name := range []string{"input", "shell", "process", "broadcast", "total"} {
+		report.Stages[name] = percentiles(stages[name])
+	}
+	if report.Samples == nil {
+		report.Samples = []latencySample{}
+	}
+	return report
+}
+
+// traceInput reports whether input from c is traced.
+func (s *ShellServer) traceInput(c *client) bool {
+	return s.traceAll || c.trace.Load()
+}
+
+// broadcastTraced broadcasts PTY output, timing each client's write when
+// the output answers traced input. The sample is finished once every
+// client's pump has written it or given up.
+func (s *ShellServer) broadcastTraced(data []byte, traced []*latencySample) {
+	if traced == nil {
+		s.broadcast(data)
+		return
+	}
+	start := time.Now()
+	pumps := s.clientPumps(nil)
+	if len(pumps) == 0 {
+		s.latency.finish(traced, start, nil)
+		return
+	}
+	data = bytes.Clone(data)
+	var mu sync.Mutex
+	writes, waiting := make([]time.Duration, 0, len(pumps)), len(pumps)
+	written := func(d time.Duration, ok bool) {
+		mu.Lock()
+		defer mu.Unlock()
+		if ok {
+			writes = append(writes, d)
+		}
+		if waiting--; waiting == 0 {
+			s.latency.finish(traced, start, writes)
+		}
+	}
+	for _, out := range pumps {
+		out.send(outbound{msgType: websocket.BinaryMessage, data: data, written: written})
+	}
+}
+
+// handleLatency serves GET /debug/latency: percentiles per stage over the
+// recent traced round trips, and the samples themselves.
+func (s *ShellServer) handleLatency(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r, http.MethodGet)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newLatencyReport(s.latency.recent(), s.traceAll))
+}
@@ -0,0 +1,101 @@
+package shellserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"shellserver/internal/testshell"
+)
+
+func getLatency(t *testing.T, url string) latencyReport {
+	t.Helper()
+	resp, err := http.Get(url + "/debug/latency")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET /debug/latency: %s", resp.Status)
+	}
+	var report latencyReport
+	if err := json.NewDecoder(resp.Body).Decode(&report); err != nil {
+		t.Fatal(err)
+	}
+	return report
+}
+
+func TestLatencyTracing(t *testing.T) {
+	_, ts := startFakeShellServer(t)
+	c := testshell.Dial(t, ts.URL, "")
+
+	// Untraced input leaves no samples
+	c.Send("echo untraced")
+	c.ExpectOutput("untraced\r\n"+testshell.Prompt, testshell.DefaultTimeout)
+	if report := getLatency(t, ts.URL); len(report.Samples) != 0 {
+		t.Fatalf("untraced input sampled: %+v", report.Samples)
+	}
+
+	c.SendJSON(map[string]any{"kind": "trace", "enabled": true})
+	c.Send("echo traced")
+	c.ExpectOutput("traced\r\n"+testshell.Prompt, testshell.DefaultTimeout)
+
+	var report latencyReport
+	waitFor(t, "a traced sample", func() bool {
+		report = getLatency(t, ts.URL)
+		return len(report.Samples) > 0
+	})
+	sample := report.Samples[0]
+	if sample.Seq != 1 {
+		t.Errorf("first sample seq = %d, want 1", sample.Seq)
+	}
+	if sample.Input <= 0 || sample.Shell <= 0 || sample.Process < 0 || sample.Total <= 0 {
+		t.Errorf("implausible stage timings: %+v", sample)
+	}
+	if len(sample.Broadcast) != 1 || sample.Broadcast[0] <= 0 {
+		t.Errorf("broadcast writes = %v, want one per client", sample.Broadcast)
+	}
+	if sum := sample.Input + sample.Shell + sample.Process; sum > sample.Total {
+		t.Errorf("stages add up to %v, more than the total %v", sum, sample.Total)
+	}
+	if sample.Total > 10*time.Second {
+		t.Errorf("total %v is not plausible for an echo", sample.Total)
+	}
+	if st := report.Stages["total"]; st.Max <= 0 || st.P50 > st.Max {
+		t.Errorf("total percentiles = %+v", st)
+	}
+}
+
+func TestLatencyTracerBounded(t *testing.T) {
+	var tr latencyTracer
+	now := time.Now()
+	for i := 0; i < 2*latencyPendingMax; i++ {
+		tr.input(now, now.Add(time.Millisecond))
+	}
+	traced := tr.outputRead(now.Add(2 * time.Millisecond))
+	if len(traced) != latencyPendingMax {
+		t.Fatalf("pending = %d, want capped at %d", len(traced), latencyPendingMax)
+	}
+	if traced[0].Seq != latencyPendingMax+1 {
+		t.Errorf("oldest pending seq = %d, want the oldest dropped", traced[0].Seq)
+	}
+	if tr.outputRead(now) != nil {
+		t.Error("output answered inputs twice")
+	}
+
+	for i := 0; i < 10; i++ {
+		tr.finish(traced, now.Add(3*time.Millisecond), []time.Duration{time.Millisecond})
+	}
+	samples := tr.recent()
+	if len(samples) != latencySampleCap {
+		t.Fatalf("kept %d samples, want capped at %d", len(samples), latencySampleCap)
+	}
+	last := samples[len(samples)-1]
+	if last.Seq != traced[len(traced)-1].Seq || last.Total != 4*time.Millisecond {
+		t.Errorf("newest sample = %+v", last)
+	}
+	if samples[0].Seq != traced[(10*latencyPendingMax-latencySampleCap)%latencyPendingMax].Seq {
+		t.Errorf("oldest sample seq = %d, ring out of order", samples[0].Seq)
+	}
+}
@@ -0,0 +1,310 @@
+package shellserver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"shellserver/internal/persist"
+	"shellserver/pkg/protocol"
+)
+
+var flagStateDir = Flags.String("state-dir", defaultStateDir(), "directory for state kept across restarts, such as keystroke macros")
+
+// defaultStateDir is $XDG_STATE_HOME/goshell, or ~/.local/state/goshell.
+func defaultStateDir() string {
+	if dir := os.Getenv("XDG_STATE_HOME"); dir != "" {
+		return filepath.Join(dir, "goshell")
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".local", "state", "goshell")
+}
+
+// macroNameRe is what a macro may be called; names are file names.
+var macroNameRe = regexp.MustCompile(`^[A-Za-z0-9_-][A-Za-z0-9._-]{0,63}$`)
+
+var (
+	errMacroNotFound = errors.New("no such macro")
+	errMacroPlaying  = errors.New("another macro is playing")
+)
+
+// macro is a recorded sequence of terminal input, stored as JSON in
+// <state-dir>/macros/<name>.json.
+type macro struct {
+	Name    string       `json:"name"`
+	Created time.Time    `json:"created"`
+	Events  []macroEvent `json:"events"`
+}
+
+// macroEvent is one input frame and how long after the previous one (or
+// the start of recording) it came.
+type macroEvent struct {
+	Delay time.Duration `json:"delay_ns"`
+	Data  []byte        `json:"data"`
+}
+
+func (m *macro) duration() time.Duration {
+	var d time.Duration
+	for _, ev := range m.Events {
+		d += ev.Delay
+	}
+	return d
+}
+
+// macroInfo is a macro as GET /macros lists it.
+type macroInfo struct {
+	Name       string    `json:"name"`
+	Created    time.Time `json:"created"`
+	DurationMS int64     `json:"duration_ms"`
+	Events     int       `json:"events"`
+	Bytes      int       `json:"bytes"`
+}
+
+func (m *macro) info() macroInfo {
+	info := macroInfo{Name: m.Name, Created: m.Created, DurationMS: m.duration().Milliseconds(), Events: len(m.Events)}
+	for _, ev := range m.Events {
+		info.Bytes += len(ev.Data)
+	}
+	return info
+}
+
+// macroRecorder records one client's input into a macro.
+type macroRecorder struct {
+	macro
+	last time.Time
+}
+
+func (r *macroRecorder) add(data []byte, at time.Time) {
+	r.Events = append(r.Events, macroEvent{Delay: at.Sub(r.last), Data: append([]byte(nil), data...)})
+	r.last = at
+}
+
+// macroStore keeps macros as files in dir. A nil store, for a server
+// without -state-dir, has none and can't save any.
+type macroStore struct {
+	dir     string
+	playing atomic.Bool // one macro plays at a time
+}
+
+func newMacroStore(stateDir string) *macroStore {
+	if stateDir == "" {
+		return nil
+	}
+	return &macroStore{dir: filepath.Join(stateDir, "macros")}
+}
+
+func (ms *macroStore) path(name string) (string, error) {
+	if !macroNameRe.MatchString(name) {
+		return "", fmt.Errorf("invalid macro name %q: use letters, digits, '.', '_' and '-'", name)
+	}
+	if ms == nil {
+		return "", errors.New("macros need -state-dir")
+	}
+	return filepath.Join(ms.dir, name+".json"), nil
+}
+
+func (ms *macroStore) save(m *macro) error {
+	path, err := ms.path(m.Name)
+	if err != nil {
+		return err
+	}
+	return persist.Save(path, m)
+}
+
+func (ms *macroStore) load(name string) (*macro, error) {
+	path, err := ms.path(name)
+	if err != nil {
+		return nil, err
+	}
+	var m macro
+	if err := persist.Load(path, &m); os.IsNotExist(err) {
+		return nil, errMacroNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("macro %s: %w", name, err)
+	}
+	return &m, nil
+}
+
+func (ms *macroStore) remove(name string) error {
+	path, err := ms.path(name)
+	if err != nil {
+		return err
+	}
+	if err := persist.Remove(path); os.IsNotExist(err) {
+		return errMacroNotFound
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
+
+// list returns the stored macros by name. Files that don't parse are
+// skipped.
+func (ms *macroStore) list() ([]macroInfo, error) {
+	infos := []macroInfo{}
+	if ms == nil {
+		return infos, nil
+	}
+	entries, err := os.ReadDir(ms.dir)
+	if os.IsNotExist(err) {
+		return infos, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		name, ok := strings.CutSuffix(e.Name(), ".json")
+		if !ok || e.IsDir() {
+			continue
+		}
+		if m, err := ms.load(name); err == nil {
+			infos = append(infos, m.info())
+		}
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	return infos, nil
+}
+
+// startMacroRecording makes c record its input into a macro called name,
+// replacing any recording it had going.
+func (s *ShellServer) startMacroRecording(c *client, name string) error {
+	if _, err := s.macros.path(name); err != nil {
+		return err
+	}
+	c.macro = &macroRecorder{macro: macro{Name: name, Created: time.Now()}, last: time.Now()}
+	s.broadcastMacroEvent("recording", name)
+	return nil
+}
+
+// stopMacroRecording saves c's recording.
+func (s *ShellServer) stopMacroRecording(c *client) error {
+	rec := c.macro
+	if rec == nil {
+		return errors.New("not recording")
+	}
+	c.macro = nil
+	if err := s.macros.save(&rec.macro); err != nil {
+		return err
+	}
+	s.broadcastMacroEvent("saved", rec.Name)
+	return nil
+}
+
+// playMacro starts writing the macro called name into the PTY, with its
+// delays divided by speed or, if instant, none at all. It refuses while
+// another macro plays.
+func (s *ShellServer) playMacro(name string, speed float64, instant bool) error {
+	m, err := s.macros.load(name)
+	if err != nil {
+		return err
+	}
+	if !s.macros.playing.CompareAndSwap(false, true) {
+		return errMacroPlaying
+	}
+	if speed <= 0 {
+		speed = 1
+	}
+	s.broadcastMacroEvent("playing", name)
+	go func() {
+		defer s.macros.playing.Store(false)
+		for _, ev := range m.Events {
+			if !instant {
+				time.Sleep(time.Duration(float64(ev.Delay) / speed))
+			}
+			if err := s.writeToPTY(ev.Data); err != nil {
+				log.Printf("macro %s: %v", name, err)
+				break
+			}
+		}
+		s.broadcastMacroEvent("played", name)
+	}()
+	return nil
+}
+
+// broadcastMacroEvent tells clients a macro started or stopped recording
+// or playing.
+func (s *ShellServer) broadcastMacroEvent(action, name string) {
+	data, _ := json.Marshal(map[string]string{"kind": "macro", "action": action, "name": name})
+	s.broadcastMessage(websocket.TextMessage, data)
+}
+
+// MacroPlayRequest models POST /macros/{name}/play payloads, all optional.
+type MacroPlayRequest struct {
+	Speed   float64 `json:"speed"`   // delays are divided by this; default 1
+	Instant bool    `json:"instant"` // no delays at all
+}
+
+// handleMacros serves GET /macros, DELETE /macros/{name} and POST
+// /macros/{name}/play.
+func (s *ShellServer) handleMacros(w http.ResponseWriter, r *http.Request) {
+	rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/macros"), "/")
+	if rest == "" {
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w, r, http.MethodGet)
+			return
+		}
+		infos, err := s.macros.list()
+		if err != nil {
+			respondError(w, r, http.StatusInternalServerError, protocol.ErrInternal, err.Error())
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(infos)
+		return
+	}
+
+	name, action, _ := strings.Cut(rest, "/")
+	var err error
+	switch {
+	case action == "" && r.Method == http.MethodDelete:
+		if err = s.macros.remove(name); err == nil {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+	case action == "":
+		methodNotAllowed(w, r, http.MethodDelete)
+		return
+	case action == "play" && r.Method == http.MethodPost:
+		var req MacroPlayRequest
+		if derr := json.NewDecoder(r.Body).Decode(&req); derr != nil && derr != io.EOF {
+			invalidJSON(w, r, derr)
+			return
+		}
+		if err = s.playMacro(name, req.Speed, req.Instant); err == nil {
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+	case action == "play":
+		methodNotAllowed(w, r, http.MethodPost)
+		return
+	default:
+		notFound(w, r)
+		return
+	}
+
+	switch {
+	case errors.Is(err, errMacroNotFound):
+		respondError(w, r, http.StatusNotFound, protocol.ErrMacroNotFound, "no macro named "+name)
+	case errors.Is(err, errMacroPlaying):
+		respondError(w, r, http.StatusConflict, protocol.ErrMacroPlaying, err.Error())
+	case !macroNameRe.MatchString(name):
+		respondError(w, r, http.StatusBadRequest, protocol.ErrInvalidRequest, err.Error())
+	default:
+		respondError(w, r, http.StatusInternalServerError, protocol.ErrInternal, err.Error())
+	}
+}
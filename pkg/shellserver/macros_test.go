@@ -0,0 +1,148 @@
+package shellserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"shellserver/internal/testshell"
+)
+
+func TestMacroPlayback(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	defer w.Close()
+	s := &ShellServer{ptyFile: w, macros: newMacroStore(t.TempDir())}
+
+	start := time.Now()
+	rec := &macroRecorder{macro: macro{Name: "keys"}, last: start}
+	frames := []string{"ssh prod\r", "\x1b[A", "\x03", "tmux attach\r"}
+	for i, f := range frames {
+		rec.add([]byte(f), start.Add(time.Duration(i+1)*10*time.Millisecond))
+	}
+	if err := s.macros.save(&rec.macro); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.playMacro("keys", 0, true); err != nil {
+		t.Fatal(err)
+	}
+	want := strings.Join(frames, "")
+	got := make([]byte, len(want))
+	if _, err := io.ReadFull(r, got); err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != want {
+		t.Errorf("PTY received %q, want %q", got, want)
+	}
+	waitFor(t, "playback to finish", func() bool { return !s.macros.playing.Load() })
+
+	// A second macro is refused while a timed one is still playing
+	if err := s.playMacro("keys", 0.5, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.playMacro("keys", 1, true); !errors.Is(err, errMacroPlaying) {
+		t.Errorf("second play: %v, want errMacroPlaying", err)
+	}
+	io.ReadFull(r, got)
+
+	if err := s.playMacro("missing", 1, true); !errors.Is(err, errMacroNotFound) {
+		t.Errorf("play missing: %v", err)
+	}
+	if err := s.playMacro("../keys", 1, true); err == nil {
+		t.Error("played a macro outside the macro dir")
+	}
+}
+
+func TestMacroRecordOverWebsocket(t *testing.T) {
+	defer func(old string) { *flagStateDir = old }(*flagStateDir)
+	*flagStateDir = t.TempDir()
+	s, ts := startFakeShellServer(t)
+	a := testshell.Dial(t, ts.URL, "")
+	b := testshell.Dial(t, ts.URL, "")
+
+	a.SendJSON(map[string]string{"kind": "macro-record", "name": "greet"})
+	if ev := a.ExpectEvent("macro", testshell.DefaultTimeout); ev["action"] != "recording" {
+		t.Fatalf("macro event = %v", ev)
+	}
+	a.Send("echo one")
+	a.ExpectOutput("one\r\n", testshell.DefaultTimeout)
+	b.SendJSON(map[string]string{"kind": "take-control"})
+	b.Send("echo other") // another client's input stays out of the macro
+	a.ExpectOutput("other\r\n", testshell.DefaultTimeout)
+	a.SendJSON(map[string]string{"kind": "take-control"})
+	a.Send("echo two")
+	a.ExpectOutput("two\r\n", testshell.DefaultTimeout)
+	a.SendJSON(map[string]string{"kind": "macro-stop"})
+	if ev := a.ExpectEvent("macro", testshell.DefaultTimeout); ev["action"] != "saved" {
+		t.Fatalf("macro event = %v", ev)
+	}
+
+	m, err := s.macros.load("greet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var recorded []byte
+	for _, ev := range m.Events {
+		recorded = append(recorded, ev.Data...)
+	}
+	if string(recorded) != "echo one\necho two\n" {
+		t.Errorf("recorded %q", recorded)
+	}
+
+	resp, err := http.Get(ts.URL + "/macros")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var infos []macroInfo
+	json.NewDecoder(resp.Body).Decode(&infos)
+	resp.Body.Close()
+	if len(infos) != 1 || infos[0].Name != "greet" || infos[0].Events != 2 || infos[0].Bytes != len(recorded) {
+		t.Errorf("GET /macros = %+v", infos)
+	}
+
+	resp, err = http.Post(ts.URL+"/macros/greet/play", "application/json", strings.NewReader(`{"instant":true}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		t.Errorf("play: %d", resp.StatusCode)
+	}
+	a.ExpectOutput("one\r\n", testshell.DefaultTimeout)
+	a.ExpectOutput("two\r\n", testshell.DefaultTimeout)
+
+	del := func() int {
+		req, _ := http.NewRequest(http.MethodDelete, ts.URL+"/macros/greet", nil)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	waitFor(t, "playback to finish", func() bool { return !s.macros.playing.Load() })
+	if got := del(); got != http.StatusNoContent {
+		t.Errorf("delete: %d", got)
+	}
+	if got := del(); got != http.StatusNotFound {
+		t.Errorf("delete again: %d", got)
+	}
+	resp, err = http.Post(ts.URL+"/macros/greet/play", "application/json", bytes.NewReader(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("play deleted macro: %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,1805 @@
+package shellserver
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/creack/pty"
+	"github.com/gorilla/websocket"
+
+	"shellserver/internal/httpclient"
+	"shellserver/internal/procstats"
+	"shellserver/internal/store"
+	"shellserver/internal/vt"
+	"shellserver/pkg/protocol"
+)
+
+var flagAddr = Flags.String("addr", "127.0.0.1:7777", "address to listen on (host:port)")
+
+var (
+	wsUpgrader = websocket.Upgrader{CheckOrigin: originAllowed}
+
+	// HTML widget markers for PTY output parsing
+	htmlStartMarker = []byte("\x1b]9001;HTML_START\x07")
+	htmlStartPrefix = []byte("\x1b]9001;HTML_START")
+	htmlEndMarker   = []byte("\x1b]9001;HTML_END\x07")
+)
+
+// Default PTY size
+const (
+	defaultPTYRows = 24
+	defaultPTYCols = 80
+)
+
+// Widget represents a tracked widget session.
+type Widget struct {
+	ID    string
+	State json.RawMessage
+}
+
+// Refresh triggers widget-specific refresh logic.
+func (w *Widget) Refresh() {
+	RefreshWidget(w.ID)
+}
+
+// WidgetActionRequest models /widget/{id}/action payloads.
+type WidgetActionRequest struct {
+	Action   string          `json:"action"`
+	Type     string          `json:"type"`
+	Cmd      string          `json:"cmd"`
+	Detached bool            `json:"detached"` // run a shell action outside the PTY
+	State    json.RawMessage `json:"state"`
+}
+
+// ShellServer manages the single PTY-backed shell and HTTP handlers.
+type ShellServer struct {
+	options        Settings      // what it was started with
+	shellArgv      []string      // command run on the PTY, unless the profile has its own
+	startupCommand string        // -c, typed into each new shell once it's idle; "" for none
+	profile        *shellProfile // the shell's profile, guarded by ptyMu; nil for none
+	ptyFile        *os.File      // the current generation's PTY, replaced by relaunch; guarded by ptyMu
+	ptyMu          sync.Mutex
+
+	clients       map[*websocket.Conn]*client
+	clientsMu     sync.RWMutex
+	clientCounter int
+	detached      map[string]*detachedClient // Disconnected clients by resume token
+	inputOwner    *client                    // the writer with input control; nil for none
+	resumeGrace   time.Duration
+	maxClients    int // -max-clients; 0 for no limit
+
+	widgets   map[string]*Widget
+	widgetsMu sync.RWMutex
+
+	noWidgets     bool         // -widgets=false: HTML blocks stay in the stream
+	unsafeWidgets bool         // -unsafe-widgets: serve widgets' HTML raw
+	csrfCheck     bool         // -csrf-check: refuse state changes from other origins
+	store         store.Store  // HTML widget content, by widgetKey
+	widgetLimit   int          // widgets kept before eviction; 0 keeps all
+	htmlWidgetsMu sync.RWMutex // guards htmlCounter, htmlKeys and widget writes
+	htmlCounter   int
+	htmlKeys      map[string]int // replaces-widget key -> widget ID
+	staleWidgets  map[int]bool   // widgets restored from before a server restart, guarded by htmlWidgetsMu
+
+	// Further widget limits: total HTML kept (0 for none), and how long a
+	// widget lasts (0 for ever), with its sweep's stop
+	widgetMaxBytes  int
+	widgetTTL       time.Duration
+	widgetSweepStop chan struct{}
+
+	// -persist-session: where the session is saved ("" when off), when
+	// to stop saving, and the checksum of each widget file written,
+	// guarded by saveMu
+	persistDir   string
+	persistStop  chan struct{}
+	saveMu       sync.Mutex
+	savedWidgets map[int]string
+
+	// Revisions of widgets replaced in place, guarded by htmlWidgetsMu
+	widgetVersions  map[int]int          // widget ID -> version, once replaced
+	widgetPatches   map[int]*widgetPatch // widget ID -> patch from the previous version
+	widgetDiffRatio float64              // largest patch/content size ratio sent as a patch
+	widgetIndex     map[int]*widgetText  // widget ID -> searchable text
+
+	widgetErrors   map[int]*widgetErrorLog // Client-reported errors by HTML widget ID
+	widgetErrorsMu sync.Mutex
+
+	buffer            []byte
+	bufferMu          sync.Mutex
+	bufferLines       int                          // line breaks in buffer, or more where HTML mode was stripped since the last trim; guarded by bufferMu
+	scrollbackBytes   int                          // -scrollback; 0 for no limit
+	scrollbackLines   int                          // -scrollback-lines; 0 for no limit
+	scrollbackDropped struct{ bytes, lines int64 } // trimmed from the buffer's front; guarded by bufferMu
+	outputEnd         int64                        // bytes of output ever appended, the buffer's last being at outputEnd-1; guarded by bufferMu
+	outputEpoch       string                       // names this process's output offsets, which count from its start
+	screen            *vt.Terminal                 // the terminal the output draws, for replay; guarded by bufferMu
+	blocks            blockLog                     // command blocks in the buffer; guarded by bufferMu
+	altScreenStart    int64                        // offset where the alternate screen showing was entered; guarded by bufferMu
+	altScreenSent     atomic.Bool                  // whether clients were last told the alternate screen is showing
+
+	htmlBuffer []byte           // Accumulates incomplete HTML blocks across PTY reads
+	clipboard  clipboardScanner // OSC 52 copies taken out of the output; guarded by htmlBufMu
+	htmlBufMu  sync.Mutex
+
+	shellPGID    int                       // the shell's process group ID (idle state); guarded by ptyMu
+	ptyCancel    context.CancelFunc        // stops the current PTY's goroutines; guarded by ptyMu
+	ptyStreaming <-chan struct{}           // closed when the current PTY's streamPTY returns; guarded by ptyMu
+	winsize      atomic.Uint32             // the size last applied to the PTY, rows<<16 | cols; 0 for the default
+	resizeMu     sync.Mutex                // serializes choosing a size and applying it
+	resizePolicy string                    // -resize-policy; "" is last-writer
+	resizes      resizeDebounce            // websocket resizes waiting for resizeInterval
+	fgPGID       atomic.Int64              // the foreground process group as monitorStatus last read it
+	lastExit     atomic.Pointer[shellExit] // how the last shell to exit on its own ended; nil until one has
+	title        atomic.Pointer[string]    // the terminal title from OSC 0, 1 or 2; nil until one is set
+	shell        *shellProcess             // the shell, for its exit status once reaped; guarded by ptyMu
+
+	autoRestart *restartBackoff // paces restarts after the shell exits; nil with -no-autorestart
+	stopping    atomic.Bool     // Shutdown or Close has begun, so an exit isn't restarted
+
+	ptyReadRetries atomic.Int64 // transient PTY read errors retried
+
+	stats serverStats // counters for Stats, and OnEvent handlers
+
+	rawMode atomic.Bool // pass PTY output through uninterpreted; see setRawMode
+
+	ptyMode   ptyMode // terminal settings as monitorStatus last read them
+	ptyModeMu sync.Mutex
+
+	// Widget shell commands awaiting client confirmation
+	confirmWidgetCmds bool
+	confirmTimeout    time.Duration
+	confirms          map[string]*pendingConfirm // by confirmation ID
+	confirmsMu        sync.Mutex
+	confirmSigner     *confirmSigner
+
+	cmdQueue cmdQueue // widget commands waiting for the prompt
+
+	lastActive atomic.Int64 // Unix nanoseconds of the last input or client connecting
+	hibernated atomic.Bool  // the idle shell was ended; the next client starts one
+
+	// Widget shell commands must be written into the widget asking
+	signedWidgetCmds bool
+	widgetCmdKey     []byte           // signs the commands in stored widgets
+	widgetCmdMACs    map[int][]string // widget ID -> its commands' MACs; guarded by htmlWidgetsMu
+
+	// -sanitize-widgets, and each widget's HTML as printed where
+	// sanitizing changed it, guarded by htmlWidgetsMu
+	sanitizeWidgets bool
+	widgetOriginals map[int][]byte
+
+	detachedTimeout time.Duration // kill detached widget commands after this long
+
+	// Slow-command annotations
+	annotateMin    time.Duration // annotate commands at least this slow; 0 disables
+	annotateInject bool          // write annotations into the stream, not just events
+	notifyMin      time.Duration // send "finished" for commands at least this slow; 0 disables
+	recentRuns     recentRuns    // the last few commands that finished, for /status
+	hooked         atomic.Bool   // the shell's integration has marked a command since it started
+
+	// Unread activity since a client last sent {"kind":"seen"}
+	bellCount     int
+	activityCount int
+	lastOutput    time.Time
+	lastBell      time.Time // when the last bell event was sent
+	activityMu    sync.Mutex
+
+	sessionTmp *sessionTmp    // the shell's GOSHELL_TMPDIR
+	cgroup     *sessionCgroup // the shell's cgroup; nil if it runs in ours
+
+	recordDir string // asciinema recordings served at /recordings; "" disables
+
+	teeSinks []*teeSink // -tee-file and -tee-cmd mirrors of raw PTY output
+	recorder recorder   // -record and POST /record/start
+	cwd      cwdTracker // the shell's working directory, for GET /cwd
+
+	fileShares *fileShares // directories serveh mounted at /files/<token>/
+
+	widgetJournal widgetJournal // widget store mutations, for events and ?since catch-up
+
+	traceAll bool           // -trace: time every input frame, not just opted-in clients'
+	latency  *latencyTracer // traced round trips for /debug/latency
+
+	gate *requestGate // -heavy-concurrency for gatedRoutes; nil admits everything
+
+	launchEnv    []string     // the environment the shell was started with; guarded by ptyMu
+	shellQueries shellQueries // hidden commands typed into the shell
+	envSnapshots envSnapshots // the last GET /envsnapshot
+
+	macros  *macroStore     // keystroke macros; nil without -state-dir
+	history *commandHistory // commands the shell integration reported
+	tour    *tourStore      // onboarding tour preference; nil without -state-dir
+
+	authToken string // what clients must present; "" admits everything
+	webFS     fs.FS  // the UI from Options; nil for -webroot or the built-in one
+
+	outbound *httpclient.Client // for requests to other servers
+
+	widgetQuota *widgetQuota // -widget-rate; nil admits everything
+
+	// -config and what it overrides, swapped whole by a reload; see settings
+	live     atomic.Pointer[liveSettings]
+	reloadMu sync.Mutex
+	caps     atomic.Pointer[map[string]any] // optional features enabled, from the registry
+
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// getForegroundPGID gets the current foreground process group ID of the
+// PTY. Once f is closed it fails with an error wrapping os.ErrClosed.
+func getForegroundPGID(f *os.File) (int, error) {
+	var pgid int
+	if err := ptyIoctl(f, syscall.TIOCGPGRP, unsafe.Pointer(&pgid)); err != nil {
+		return 0, err
+	}
+	return pgid, nil
+}
+
+// ptyIoctl runs the ioctl req on f through the runtime poller, so it
+// can't race a Close. Once f is closed it fails with an error wrapping
+// os.ErrClosed.
+func ptyIoctl(f *os.File, req uintptr, arg unsafe.Pointer) error {
+	rc, err := f.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var errno syscall.Errno
+	err = rc.Control(func(fd uintptr) {
+		_, _, errno = syscall.Syscall(syscall.SYS_IOCTL, fd, req, uintptr(arg))
+	})
+	if err != nil {
+		// Control only fails once f is closing
+		return &os.PathError{Op: "ioctl", Path: f.Name(), Err: os.ErrClosed}
+	}
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// pollable returns f with its descriptor back in non-blocking mode and
+// registered with the runtime poller, and closes f. pty.StartWithSize
+// calls Fd, which leaves the descriptor blocking, so Close would not
+// interrupt a Read in progress and the ioctls would race with it.
+func pollable(f *os.File) (*os.File, error) {
+	fd, err := syscall.Dup(int(f.Fd()))
+	f.Close()
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.SetNonblock(fd, true); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+	return os.NewFile(uintptr(fd), f.Name()), nil
+}
+
+// setWinsize sets the terminal size of the PTY. Once f is closed it fails
+// with an error wrapping os.ErrClosed.
+func setWinsize(f *os.File, rows, cols int) error {
+	ws := pty.Winsize{Rows: uint16(rows), Cols: uint16(cols)}
+	return ptyIoctl(f, syscall.TIOCSWINSZ, unsafe.Pointer(&ws))
+}
+
+// shellCommand returns the command running argv in dir ("" for ours)
+// with the standard environment plus env.
+func shellCommand(argv, env []string, dir string) *exec.Cmd {
+	cmd := exec.Command(argv[0], argv[1:]...)
+	cmd.Dir = dir
+	goshellHome, _ := os.Getwd()
+	cmd.Env = append(os.Environ(), "TERM=xterm-256color", "GOSHELL_HOME="+goshellHome)
+	cmd.Env = append(cmd.Env, env...)
+	return cmd
+}
+
+// goshellEnv tells the shell how to reach the server: GOSHELL_URL, and
+// GOSHELL_TOKEN if clients need one.
+func goshellEnv(set Settings) []string {
+	env := []string{"GOSHELL_URL=" + localURL(set.Addr)}
+	if set.Token != "" {
+		env = append(env, "GOSHELL_TOKEN="+set.Token)
+	}
+	return env
+}
+
+// startPTY creates a new PTY running the shell command argv in dir with
+// the standard environment plus env, in the session cgroup cg if there is
+// one, on a terminal of rows by cols. Returns the pty file and the
+// shell's process group ID.
+func startPTY(argv, env []string, dir string, cg *sessionCgroup, rows, cols int) (*os.File, int, *shellProcess, error) {
+	size := &pty.Winsize{
+		Rows: uint16(rows),
+		Cols: uint16(cols),
+	}
+	cmd := shellCommand(argv, env, dir)
+	release, placed := cg.place(cmd)
+	ptyFile, err := pty.StartWithSize(cmd, size)
+	release()
+	if err != nil && placed {
+		// Starting into a cgroup needs Linux 5.7; move the shell in after
+		cmd = shellCommand(argv, env, dir)
+		if ptyFile, err = pty.StartWithSize(cmd, size); err == nil {
+			cg.add(cmd.Process.Pid)
+		}
+	}
+	if err != nil {
+		return nil, 0, nil, fmt.Errorf("start %s pty: %w", filepath.Base(argv[0]), err)
+	}
+	shell := reapShell(cmd)
+	if ptyFile, err = pollable(ptyFile); err != nil {
+		return nil, 0, nil, fmt.Errorf("start %s pty: %w", filepath.Base(argv[0]), err)
+	}
+
+	// Wait a bit for shell to start, then capture its PGID
+	time.Sleep(100 * time.Millisecond)
+	shellPGID, err := getForegroundPGID(ptyFile)
+	if err != nil {
+		ptyFile.Close()
+		return nil, 0, nil, fmt.Errorf("get shell PGID: %w", err)
+	}
+
+	return ptyFile, shellPGID, shell, nil
+}
+
+// newShellServer starts a server on the configured shell, see
+// configuredShell, with the Options the flags give.
+func newShellServer() (*ShellServer, error) {
+	argv, err := configuredShell()
+	if err != nil {
+		return nil, err
+	}
+	args, err := passthroughArgs(os.Args, Flags.Args())
+	if err != nil {
+		return nil, err
+	}
+	return newShellServerWithShell(append(argv, args...))
+}
+
+// newShellServerWithShell starts a server whose PTY runs argv, set up by
+// the flags.
+func newShellServerWithShell(argv []string) (*ShellServer, error) {
+	set := flagSettings()
+	return newServer(Options{Shell: argv, Settings: &set})
+}
+
+// newServer starts a server whose PTY runs opts.Shell, which is also
+// what a restart relaunches, unless opts.Settings names a profile with a
+// shell of its own.
+func newServer(opts Options) (*ShellServer, error) {
+	argv, set := opts.Shell, *opts.Settings
+	scrollback := set.Scrollback
+	if opts.BufferSize > 0 {
+		scrollback = opts.BufferSize
+	}
+	cfg, err := loadConfig(set.Config)
+	if err != nil {
+		return nil, err
+	}
+	live, err := newLiveSettings(cfg, set)
+	if err != nil {
+		return nil, err
+	}
+	outbound, err := newOutboundClient(set)
+	if err != nil {
+		return nil, err
+	}
+	var profile *shellProfile
+	if set.Profile != "" {
+		if profile = cfg.profile(set.Profile); profile == nil {
+			return nil, fmt.Errorf("no profile %q in the config", set.Profile)
+		}
+	}
+	if err := checkSizeSettings(set); err != nil {
+		return nil, err
+	}
+	if set.SlowClient != "drop" && set.SlowClient != "disconnect" {
+		return nil, fmt.Errorf("-slow-client must be drop or disconnect, not %q", set.SlowClient)
+	}
+	if set.PersistSession && set.StateDir == "" {
+		return nil, errors.New("-persist-session needs a -state-dir")
+	}
+	if set.PersistSession && set.PersistInterval <= 0 {
+		return nil, fmt.Errorf("-persist-interval must be positive, not %v", set.PersistInterval)
+	}
+
+	st, err := store.Open(set.Store)
+	if err != nil {
+		return nil, err
+	}
+	lastID, err := lastWidgetID(st)
+	if err != nil {
+		st.Close()
+		return nil, fmt.Errorf("read widget store: %w", err)
+	}
+
+	tmp, err := newSessionTmp(defaultSessionID, set.TmpdirQuota, set.KeepTmpdir)
+	if err != nil {
+		st.Close()
+		return nil, fmt.Errorf("create session tmpdir: %w", err)
+	}
+
+	cg, err := openSessionCgroup(defaultSessionID, set)
+	if err != nil {
+		tmp.remove()
+		st.Close()
+		return nil, err
+	}
+
+	sinks, err := openTeeSinks(set)
+	if err != nil {
+		cg.remove()
+		tmp.remove()
+		st.Close()
+		return nil, err
+	}
+
+	shellArgv, env, dir := profile.launch(argv)
+	env = append(append(goshellEnv(set), env...), tmp.env()...)
+	ptyFile, shellPGID, shell, err := startPTY(shellArgv, env, dir, cg, set.Rows, set.Cols)
+	if err != nil {
+		closeTeeSinks(sinks)
+		cg.remove()
+		tmp.remove()
+		st.Close()
+		return nil, err
+	}
+
+	server := &ShellServer{
+		shellArgv:         argv,
+		options:           set,
+		profile:           profile,
+		ptyFile:           ptyFile,
+		clients:           make(map[*websocket.Conn]*client),
+		detached:          make(map[string]*detachedClient),
+		resumeGrace:       set.ResumeGrace,
+		maxClients:        set.MaxClients,
+		resizePolicy:      set.ResizePolicy,
+		clipboard:         clipboardScanner{limit: set.ClipboardLimit},
+		startupCommand:    set.StartupCommand,
+		widgets:           make(map[string]*Widget),
+		noWidgets:         !set.Widgets,
+		store:             st,
+		widgetLimit:       set.WidgetLimit,
+		widgetMaxBytes:    set.WidgetMaxBytes,
+		widgetTTL:         set.WidgetTTL,
+		scrollbackBytes:   scrollback,
+		scrollbackLines:   set.ScrollbackLines,
+		outputEpoch:       newOutputEpoch(),
+		screen:            vt.New(set.Rows, set.Cols, set.ScrollbackLines),
+		htmlCounter:       lastID,
+		htmlKeys:          make(map[string]int),
+		staleWidgets:      make(map[int]bool),
+		savedWidgets:      make(map[int]string),
+		widgetVersions:    make(map[int]int),
+		widgetPatches:     make(map[int]*widgetPatch),
+		widgetDiffRatio:   set.WidgetDiffRatio,
+		widgetIndex:       make(map[int]*widgetText),
+		widgetErrors:      make(map[int]*widgetErrorLog),
+		shellPGID:         shellPGID,
+		shell:             shell,
+		confirmWidgetCmds: set.ConfirmWidgetCmds,
+		confirmTimeout:    defaultConfirmTimeout,
+		confirms:          make(map[string]*pendingConfirm),
+		confirmSigner:     newConfirmSigner(),
+		signedWidgetCmds:  set.WidgetCmdSigned,
+		widgetCmdKey:      newWidgetCmdKey(),
+		detachedTimeout:   set.DetachedTimeout,
+		annotateMin:       set.AnnotateMin,
+		annotateInject:    set.AnnotateInject,
+		notifyMin:         set.NotifyMin,
+		unsafeWidgets:     set.UnsafeWidgets,
+		csrfCheck:         set.CSRFCheck,
+		sanitizeWidgets:   set.SanitizeWidgets,
+		sessionTmp:        tmp,
+		cgroup:            cg,
+		recordDir:         set.RecordDir,
+		teeSinks:          sinks,
+		fileShares:        newFileShares(set.FilesTTL, set.FilesMaxTTL),
+		traceAll:          set.Trace,
+		latency:           &latencyTracer{},
+		gate:              newRequestGate(set.HeavyConcurrency, set.HeavyQueueTimeout),
+		launchEnv:         shellCommand(shellArgv, env, dir).Env,
+		macros:            newMacroStore(set.StateDir),
+		history:           &commandHistory{limit: set.HistorySize},
+		tour:              newTourStore(set.StateDir),
+		authToken:         set.Token,
+		webFS:             opts.WebFS,
+		outbound:          outbound,
+	}
+	server.stats.started = time.Now()
+	server.setPTYSize(set.Rows, set.Cols)
+	if !set.NoAutorestart {
+		server.autoRestart = &restartBackoff{start: time.Now()}
+	}
+	server.widgetQuota = newWidgetQuota(live.widgetRate, live.widgetRateWindow)
+	server.applySettings(live)
+	if mode, err := readPTYMode(ptyFile, true); err == nil {
+		server.ptyMode = mode
+	}
+
+	if set.Record != "" {
+		if _, err := server.startRecording(); err != nil {
+			server.Close()
+			return nil, fmt.Errorf("record: %w", err)
+		}
+	}
+
+	if set.PersistSession {
+		server.persistDir = sessionDir(set.StateDir)
+		server.persistStop = make(chan struct{})
+		server.loadSession(server.persistDir)
+		go server.persistSession(server.persistDir, set.PersistInterval, server.persistStop)
+	}
+
+	if server.widgetTTL > 0 {
+		server.widgetSweepStop = make(chan struct{})
+		go server.sweepWidgets(widgetSweepInterval(server.widgetTTL), server.widgetSweepStop)
+	}
+
+	server.servePTY(ptyFile, shellPGID, shell)
+	return server, nil
+}
+
+// containsAltScreenExit checks if data contains escape sequences that exit alternate screen buffer.
+// Only the raw replay buffer needs it; the screen model tracks the alternate screen itself.
+func containsAltScreenExit(data []byte) bool {
+	// Common sequences for exiting alternate screen:
+	// ESC [ ? 1049 l  (xterm)
+	// ESC [ ? 47 l    (older xterm)
+	// ESC [ ? 1047 l  (another variant)
+	patterns := [][]byte{
+		[]byte("\x1b[?1049l"),
+		[]byte("\x1b[?47l"),
+		[]byte("\x1b[?1047l"),
+	}
+	for _, pattern := range patterns {
+		if bytes.Contains(data, pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+// findHTMLStart locates the first HTML_START marker in data. It returns
+// the marker's offset (-1 if there is none) and length, and the
+// replaces-widget key from an HTML_START;key=<key> marker. The length is
+// 0 while the marker itself is still incomplete.
+func findHTMLStart(data []byte) (idx, n int, key string) {
+	idx = bytes.Index(data, htmlStartPrefix)
+	if idx == -1 {
+		return -1, 0, ""
+	}
+	rest := data[idx+len(htmlStartPrefix):]
+	end := bytes.IndexByte(rest, '\x07')
+	if end == -1 {
+		return idx, 0, ""
+	}
+	params := string(rest[:end])
+	key, _ = strings.CutPrefix(params, ";key=")
+	return idx, len(htmlStartPrefix) + end + 1, key
+}
+
+// extractAndStoreHTML extracts HTML content from accumulated PTY data and stores it
+// Returns: (processedData, remainingBuffer, widgetIDs, updatedIDs)
+// - processedData: data with HTML blocks replaced by links
+// - remainingBuffer: incomplete HTML block data to keep for next read
+// - widgetIDs: IDs of extracted widgets
+// - updatedIDs: IDs of existing widgets whose content a keyed block replaced
+//
+// A block opened with HTML_START;key=<key> replaces the widget stored under
+// the same key: the content is swapped in place and no new link is written,
+// so a program can refresh one widget repeatedly without flooding the terminal.
+func (s *ShellServer) extractAndStoreHTML(data []byte) ([]byte, []byte, []int, []int) {
+	result := data
+	var widgetIDs, updatedIDs []int
+
+	for {
+		startIdx, startLen, key := findHTMLStart(result)
+		if startIdx == -1 {
+			// No HTML_START found, return all data as processed
+			return result, nil, widgetIDs, updatedIDs
+		}
+		if startLen == 0 {
+			// HTML_START itself is split across reads
+			return result[:startIdx], result[startIdx:], widgetIDs, updatedIDs
+		}
+
+		endIdx := bytes.Index(result[startIdx:], htmlEndMarker)
+		if endIdx == -1 {
+			// Found HTML_START but no HTML_END - keep this for next read
+			return result[:startIdx], result[startIdx:], widgetIDs, updatedIDs
+		}
+
+		// Extract the HTML content
+		htmlContentStart := startIdx + startLen
+		htmlContentEnd := startIdx + endIdx
+		htmlContent := result[htmlContentStart:htmlContentEnd]
+
+		// Store the HTML content, replacing the keyed widget if there is one
+		s.htmlWidgetsMu.Lock()
+		var previous string
+		widgetID, replacing := s.htmlKeys[key]
+		if replacing {
+			previous, replacing = s.widgetHTML(widgetID)
+		}
+		if !replacing && !s.widgetQuota.allow(time.Now()) {
+			// Over -widget-rate: drop the block without a trace until the
+			// window ends and the quota reports it
+			s.htmlWidgetsMu.Unlock()
+			endIdx += startIdx + len(htmlEndMarker)
+			result = append(result[:startIdx], result[endIdx:]...)
+			continue
+		}
+		if !replacing {
+			s.htmlCounter++
+			widgetID = s.htmlCounter
+			if key != "" {
+				s.htmlKeys[key] = widgetID
+			}
+		}
+		htmlContent = s.sanitizeWidget(widgetID, htmlContent)
+		if err := s.putWidget(widgetID, htmlContent); err != nil {
+			log.Printf("widget store: put %d: %v", widgetID, err)
+		}
+		if !replacing {
+			s.widgetEventLocked(protocol.WidgetCreated, widgetID)
+			s.trimWidgets(time.Now())
+		}
+		s.htmlWidgetsMu.Unlock()
+
+		var replacement []byte
+		if replacing {
+			s.recordWidgetRevision(widgetID, []byte(previous), htmlContent, time.Now())
+			s.widgetEvent(protocol.WidgetReplaced, widgetID)
+			updatedIDs = append(updatedIDs, widgetID)
+		} else {
+			widgetIDs = append(widgetIDs, widgetID)
+
+			// Create a clickable link using OSC 8 hyperlinks
+			linkText := fmt.Sprintf("View HTML Output #%d", widgetID)
+			replacement = []byte(fmt.Sprintf("\x1b]8;;htmlwidget:%d\x07\x1b[34;4m%s\x1b[0m\x1b]8;;\x07",
+				widgetID, linkText))
+		}
+
+		// Replace from HTML_START to HTML_END with the link
+		endIdx += startIdx + len(htmlEndMarker)
+		result = append(result[:startIdx], append(replacement, result[endIdx:]...)...)
+	}
+}
+
+// stripHTMLMode removes HTML mode sequences from buffer (used for cleaning up buffer)
+func stripHTMLMode(data []byte) []byte {
+	result := data
+
+	for {
+		startIdx, _, _ := findHTMLStart(result)
+		if startIdx == -1 {
+			break
+		}
+
+		endIdx := bytes.Index(result[startIdx:], htmlEndMarker)
+		if endIdx == -1 {
+			// No matching end, strip from start to end of buffer
+			result = result[:startIdx]
+			break
+		}
+
+		// Just remove the HTML block entirely
+		endIdx += startIdx + len(htmlEndMarker)
+		result = append(result[:startIdx], result[endIdx:]...)
+	}
+
+	return result
+}
+
+func (s *ShellServer) restart() error {
+	return s.relaunch(true)
+}
+
+// relaunch replaces the shell with a new one from its profile, clearing
+// the replay buffer if clearBuffer.
+func (s *ShellServer) relaunch(clearBuffer bool) error {
+	s.ptyMu.Lock()
+	if s.ptyCancel != nil {
+		s.ptyCancel()
+	}
+	if s.ptyFile != nil {
+		s.ptyFile.Close()
+	}
+	profile := s.profile
+	oldShell, oldPGID := s.shell, s.shellPGID
+	s.ptyMu.Unlock()
+
+	// The old shell is hung up and reaped before the new one starts, so a
+	// restart never leaves it behind. Like hibernate and Shutdown, this
+	// doesn't count on the closed PTY alone to hang it up.
+	if oldPGID > 0 {
+		syscall.Kill(-oldPGID, syscall.SIGHUP)
+	}
+	if _, ok := oldShell.wait(shellReapTimeout); !ok && oldPGID > 0 {
+		log.Printf("restart: old shell still running %v after hangup; killing it", shellReapTimeout)
+		syscall.Kill(-oldPGID, syscall.SIGKILL)
+		if _, ok := oldShell.wait(shellReapTimeout); !ok {
+			log.Printf("restart: old shell (pgid %d) not reaped", oldPGID)
+		}
+	}
+
+	if err := s.sessionTmp.reset(); err != nil {
+		log.Printf("session tmpdir: reset: %v", err)
+	}
+
+	if err := s.cgroup.reset(); err != nil {
+		log.Printf("session cgroup: reset: %v", err)
+	}
+
+	argv, env, dir := profile.launch(s.shellArgv)
+	env = append(append(goshellEnv(s.options), env...), s.sessionTmp.env()...)
+	rows, cols := s.ptySize()
+	ptyFile, shellPGID, shell, err := startPTY(argv, env, dir, s.cgroup, rows, cols)
+	if err != nil {
+		return err
+	}
+	s.autoRestart.started(time.Now())
+	s.stats.restarts.Add(1)
+
+	s.ptyMu.Lock()
+	s.ptyFile = ptyFile
+	s.shellPGID = shellPGID
+	s.shell = shell
+	s.launchEnv = shellCommand(argv, env, dir).Env
+	s.ptyMu.Unlock()
+	s.fgPGID.Store(0)
+	s.hooked.Store(false)
+	s.hibernated.Store(false)
+	s.envSnapshots.reset()
+	s.setStatus("waiting", "", 0)
+
+	// The new PTY starts at the size the last one had
+	s.bufferMu.Lock()
+	s.blocks.abandon(s.outputEnd, time.Now())
+	if clearBuffer {
+		s.buffer, s.bufferLines = nil, 0
+		s.screen = vt.New(rows, cols, s.scrollbackLines)
+		s.blocks.trim(s.outputEnd)
+	}
+	s.bufferMu.Unlock()
+	if path := s.recorder.active(); path != "" && clearBuffer {
+		if err := s.recorder.open(path, rows, cols); err != nil {
+			log.Printf("record %s: %v; recording stopped", path, err)
+		}
+	}
+
+	// Raw mode is for debugging one shell; a fresh one starts interpreted
+	s.htmlBufMu.Lock()
+	s.rawMode.Store(false)
+	s.htmlBuffer = nil
+	s.clipboard.reset()
+	s.htmlBufMu.Unlock()
+	s.shellQueries.reset()
+	s.widgetQuota.reset()
+	s.setTitle("")
+
+	s.broadcastRestarted(clearBuffer)
+	s.servePTY(ptyFile, shellPGID, shell)
+	return nil
+}
+
+// clearTerminal homes the cursor and erases the screen and scrollback.
+const clearTerminal = "\x1b[H\x1b[2J\x1b[3J"
+
+// broadcastRestarted tells clients the shell was replaced with
+// {"kind":"restarted","cleared":bool}, so they can reset their terminal
+// state. When the scrollback was cleared, their screens are too.
+func (s *ShellServer) broadcastRestarted(cleared bool) {
+	data, _ := json.Marshal(map[string]any{"kind": "restarted", "cleared": cleared})
+	s.broadcastMessage(websocket.TextMessage, data)
+	if cleared {
+		s.broadcastMessage(websocket.BinaryMessage, []byte(clearTerminal))
+	}
+}
+
+// servePTY starts streamPTY and monitorStatus on a newly started PTY.
+// They stop when its context is cancelled, by the next relaunch or by
+// Close, so no generation outlives its PTY.
+func (s *ShellServer) servePTY(ptyFile *os.File, shellPGID int, shell *shellProcess) {
+	ctx, cancel := context.WithCancel(context.Background())
+	streaming := make(chan struct{})
+	s.ptyMu.Lock()
+	s.ptyCancel = cancel
+	s.ptyStreaming = streaming
+	s.ptyMu.Unlock()
+	go s.streamPTY(ctx, ptyFile, shellPGID, shell, streaming)
+	go s.monitorStatus(ctx, ptyFile, shellPGID, shell.started, streaming)
+}
+
+// Close ends the session: it closes the PTY, which hangs up the shell,
+// flushes and closes the tee sinks, removes the session temp dir and
+// cgroup and closes the widget store. Only the first call does anything.
+func (s *ShellServer) Close() error {
+	s.stopping.Store(true)
+	s.closeOnce.Do(func() { s.closeErr = s.close() })
+	return s.closeErr
+}
+
+func (s *ShellServer) close() error {
+	s.ptyMu.Lock()
+	if s.ptyCancel != nil {
+		s.ptyCancel()
+	}
+	if s.ptyFile != nil {
+		s.ptyFile.Close()
+	}
+	s.ptyMu.Unlock()
+
+	closeTeeSinks(s.teeSinks)
+	s.recorder.close()
+	if s.widgetSweepStop != nil {
+		close(s.widgetSweepStop)
+	}
+	if s.persistDir != "" {
+		close(s.persistStop)
+		if err := s.saveSession(s.persistDir); err != nil {
+			log.Printf("session state: save: %v", err)
+		}
+	}
+
+	err := s.sessionTmp.remove()
+	if cerr := s.cgroup.remove(); err == nil {
+		err = cerr
+	}
+	if cerr := s.store.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// streamPTY relays ptyFile's output to clients until the shell exits or
+// ctx is cancelled. Cancelling closes ptyFile, which ends the read; it
+// is only ever this generation's file, never one a restart replaced it
+// with. streaming is closed once reading stops. Reading that stops any
+// other way leaves the session unhealthy, so the shell is hung up and
+// handled as an exit, restarting it.
+func (s *ShellServer) streamPTY(ctx context.Context, ptyFile *os.File, shellPGID int, shell *shellProcess, streaming chan<- struct{}) {
+	stop := context.AfterFunc(ctx, func() { ptyFile.Close() })
+	defer stop()
+	exited := s.pumpPTY(ptyFile, func() bool { return !processRunning(shellPGID) })
+	close(streaming)
+	if ctx.Err() != nil {
+		return
+	}
+	if !exited {
+		log.Printf("pty reader stopped; hanging up the shell")
+		ptyFile.Close()
+	}
+	s.shellExited(ctx, shell)
+}
+
+// pumpPTY reads PTY output from r and processes it, retrying transient
+// read errors with backoff. It returns on EOF, reporting that the shell
+// exited, on r being closed, or on a fatal error; shellExited tells an EIO
+// from a finished shell apart from a transient one.
+func (s *ShellServer) pumpPTY(r io.Reader, shellExited func() bool) (exited bool) {
+	buf := make([]byte, 4096)
+	var bells bellScanner
+	var cmds commandTracker
+	var markers markerStripper
+	wasRaw := false
+	retries, backoff := 0, ptyRetryMinBackoff
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			retries, backoff = 0, ptyRetryMinBackoff
+			s.stats.bytesOut.Add(int64(n))
+
+			data := buf[:n]
+			traced := s.latency.outputRead(time.Now())
+			s.tee(data)
+			if data = s.shellQueries.filter(data); len(data) == 0 {
+				continue
+			}
+			s.sessionTmp.checkSoon(time.Now())
+
+			s.htmlBufMu.Lock()
+			if s.rawMode.Load() {
+				// Raw mode: no interpretation at all
+				s.htmlBufMu.Unlock()
+				wasRaw = true
+				s.recordOutput(0, time.Now())
+				s.output(data, true, traced)
+				continue
+			}
+			if wasRaw {
+				// Escapes seen in raw mode were never scanned
+				wasRaw = false
+				bells, cmds, markers = bellScanner{}, commandTracker{}, markerStripper{}
+			}
+			s.recordOutput(bells.Scan(data), time.Now())
+
+			processedData, copies := s.clipboard.scan(data)
+			var widgetIDs, updatedIDs []int
+			if !s.noWidgets {
+				// Append to HTML buffer to handle HTML content split across reads
+				s.htmlBuffer = append(s.htmlBuffer, processedData...)
+
+				// Try to extract complete HTML blocks from the accumulated buffer
+				var remainingBuf []byte
+				processedData, remainingBuf, widgetIDs, updatedIDs = s.extractAndStoreHTML(s.htmlBuffer)
+
+				// Keep any incomplete HTML block for next read
+				s.htmlBuffer = remainingBuf
+			}
+			s.htmlBufMu.Unlock()
+
+			processedData, finished := s.annotateCommands(&cmds, processedData, time.Now())
+			if cmds.running || len(finished) > 0 {
+				s.hooked.Store(true)
+			}
+			processedData, marks := markers.strip(processedData)
+			if cmds.cwd != "" {
+				s.noteOSCCwd(cmds.cwd)
+			}
+			if title, ok := cmds.takeTitle(); ok {
+				s.setTitle(title)
+			}
+
+			if len(widgetIDs) > 0 {
+				log.Printf("DEBUG: Extracted %d HTML widgets, processed data length: %d bytes", len(widgetIDs), len(processedData))
+				previewLen := 200
+				if len(processedData) < previewLen {
+					previewLen = len(processedData)
+				}
+				log.Printf("DEBUG: First %d bytes of processed data: %q", previewLen, string(processedData[:previewLen]))
+			}
+
+			// If we're exiting alternate screen buffer, clear the raw
+			// history since that content is no longer visible
+			if containsAltScreenExit(data) {
+				s.bufferMu.Lock()
+				s.buffer, s.bufferLines = nil, 0
+				s.blocks.trim(s.outputEnd)
+				s.bufferMu.Unlock()
+			}
+			// Add processed data (with links instead of HTML) to the buffer
+			// and broadcast it to all clients
+			s.outputMarked(processedData, marks, s.noWidgets, traced)
+
+			// Notify live clients about new HTML widgets so they auto-display
+			s.flushWidgetEvents()
+			for _, widgetID := range widgetIDs {
+				s.broadcastHTMLNotification(widgetID)
+			}
+			for _, widgetID := range updatedIDs {
+				s.broadcastHTMLUpdate(widgetID)
+			}
+			for _, f := range finished {
+				if s.slowCommand(f) {
+					s.broadcastCommandDuration(f)
+				}
+				s.finishRun(f.Command, f.Duration, f.FinishedAt)
+			}
+			s.broadcastClipboard(copies)
+			if n := s.widgetQuota.due(time.Now()); n > 0 {
+				s.reportSuppressedWidgets(n)
+			}
+		}
+		if err == nil {
+			continue
+		}
+
+		switch class := classifyPTYReadError(err, shellExited); class {
+		case ptyReadRetry:
+			if retries < maxPTYReadRetries {
+				retries++
+				s.ptyReadRetries.Add(1)
+				log.Printf("pty read error (transient, retry %d in %v): %v", retries, backoff, err)
+				time.Sleep(backoff)
+				backoff = min(2*backoff, ptyRetryMaxBackoff)
+				continue
+			}
+			log.Printf("pty read error: giving up after %d retries: %v", retries, err)
+		case ptyReadEOF:
+			log.Printf("shell exited (pty read: %v)", err)
+			return true
+		case ptyReadClosed:
+			// a restart or shutdown closed the PTY on purpose
+		default:
+			log.Printf("pty read error (%v): %v", class, err)
+		}
+		return false
+	}
+}
+
+// output adds terminal output to the replay buffer and sends it to every
+// client, followed by its offset for the clients that asked for them.
+func (s *ShellServer) output(data []byte, raw bool, traced []*latencySample) {
+	s.outputMarked(data, nil, raw, traced)
+}
+
+// outputMarked is output for data the integration marks were stripped
+// from, telling clients about the command blocks they start and end.
+func (s *ShellServer) outputMarked(data []byte, marks []integrationMark, raw bool, traced []*latencySample) {
+	end, blocks := s.appendToBuffer(data, marks, raw)
+	s.recorder.output(data)
+	s.broadcastTraced(data, traced)
+	s.broadcastOffset(end)
+	s.broadcastAltScreen()
+	s.broadcastBlockEvents(blocks)
+}
+
+// appendToBuffer adds output to the replay buffer, keeping the whole
+// lines that fit in -scrollback and -scrollback-lines, give or take
+// trimSlack, so a client
+// replaying it starts at the beginning of a line. Unless raw, HTML mode
+// sequences left in the buffer are stripped. The screen model draws it
+// too, and marks, where integration markers were in data, start and end
+// command blocks. It returns the output's offset: how many bytes have
+// been appended, ever, and the blocks that started or ended.
+func (s *ShellServer) appendToBuffer(data []byte, marks []integrationMark, raw bool) (int64, []blockEvent) {
+	s.bufferMu.Lock()
+	defer s.bufferMu.Unlock()
+	s.outputEnd += int64(len(data))
+	if s.screen != nil {
+		was := s.screen.AltScreen()
+		s.screen.Write(data)
+		s.noteAltScreen(data, s.outputEnd-int64(len(data)), was)
+	}
+	s.buffer = append(s.buffer, data...)
+	if !raw {
+		s.buffer = stripHTMLMode(s.buffer)
+	}
+	events := s.appendBlockMarks(data, marks)
+	s.bufferLines += bytes.Count(data, []byte("\n"))
+	s.trimScrollback()
+	return s.outputEnd, events
+}
+
+// broadcastMessage queues a message for every connected client. data is
+// copied, so the caller may reuse it, as pumpPTY does its read buffer.
+func (s *ShellServer) broadcastMessage(msgType int, data []byte) {
+	data = bytes.Clone(data)
+	for _, out := range s.clientPumps(nil) {
+		out.send(outbound{msgType: msgType, data: data})
+	}
+}
+
+// clientPumps returns the write pumps of the connected clients that
+// match, or of all of them if match is nil.
+func (s *ShellServer) clientPumps(match func(c *client) bool) []*writePump {
+	s.clientsMu.RLock()
+	defer s.clientsMu.RUnlock()
+	pumps := make([]*writePump, 0, len(s.clients))
+	for _, c := range s.clients {
+		if match == nil || match(c) {
+			pumps = append(pumps, c.out)
+		}
+	}
+	return pumps
+}
+
+func (s *ShellServer) broadcast(data []byte) {
+	s.broadcastMessage(websocket.BinaryMessage, data)
+}
+
+// broadcastOffset tells the clients that connected with ?offsets=1 how
+// much output they have been sent, for resuming with ?since=.
+func (s *ShellServer) broadcastOffset(end int64) {
+	pumps := s.clientPumps(func(c *client) bool { return c.offsets.Load() })
+	if len(pumps) == 0 {
+		return
+	}
+	data, _ := json.Marshal(map[string]any{"kind": "offset", "offset": end})
+	for _, out := range pumps {
+		out.send(outbound{msgType: websocket.TextMessage, data: data})
+	}
+}
+
+// broadcastStatus reports the shell's state and, while a job is running,
+// the name of its foreground process.
+func (s *ShellServer) broadcastStatus(state string, job foregroundJob) {
+	s.setStatus(state, job.Process, 0)
+	msg := map[string]any{"kind": "status", "state": state}
+	if job.Process != "" {
+		msg["process"] = job.Process
+	}
+	if job.Command != "" {
+		msg["command"] = job.Command
+	}
+	if job.PID > 0 {
+		msg["pid"] = job.PID
+	}
+	data, _ := json.Marshal(msg)
+	s.broadcastMessage(websocket.TextMessage, data)
+}
+
+func (s *ShellServer) broadcastHTMLNotification(widgetID int) {
+	msg := map[string]any{"kind": "html", "widget_id": widgetID}
+	data, _ := json.Marshal(msg)
+	s.broadcastMessage(websocket.TextMessage, data)
+}
+
+// broadcastWidget announces a widget the server generated itself, with
+// its content inline so clients needn't fetch it.
+func (s *ShellServer) broadcastWidget(widgetID int, content []byte) {
+	msg := map[string]any{"kind": "html", "widget_id": widgetID, "version": 1, "content": string(content)}
+	data, _ := json.Marshal(msg)
+	s.broadcastMessage(websocket.TextMessage, data)
+}
+
+// broadcastHTMLUpdate tells clients that a widget's content was replaced,
+// so a panel showing it can patch or reload it in place.
+func (s *ShellServer) broadcastHTMLUpdate(widgetID int) {
+	data, _ := json.Marshal(s.htmlUpdateMessage(widgetID))
+	s.broadcastMessage(websocket.TextMessage, data)
+}
+
+// monitorStatus polls the PTY's foreground process group and broadcasts
+// status changes. It stops when the PTY it started on is closed or
+// replaced by a restart, which starts a new monitor.
+// foregroundJob is the process group holding the terminal, as status
+// updates describe it.
+type foregroundJob struct {
+	PID     int    // the group leader's PID, which is the PGID
+	Process string // executable name, as in /proc/<pid>/stat
+	Command string // the leader's command line, or Process if it has none
+}
+
+// lookupForegroundJob describes process group pgid. The leader can exit
+// between finding the group and reading /proc, leaving only the PID.
+func lookupForegroundJob(pgid int) foregroundJob {
+	job := foregroundJob{PID: pgid}
+	p, err := procstats.Host.Process(pgid)
+	if err != nil {
+		return job
+	}
+	job.Process, job.Command = p.Comm, strings.Join(p.Cmdline, " ")
+	if job.Command == "" {
+		job.Command = p.Comm
+	}
+	return job
+}
+
+func (s *ShellServer) monitorStatus(ctx context.Context, ptyFile *os.File, shellPGID int, started time.Time, streaming <-chan struct{}) {
+	lastState, lastJob := "waiting", foregroundJob{}
+	// The startup command waits for the shell to be idle at its prompt,
+	// after its rc files have run whatever they run.
+	startup := s.startupCommand != ""
+	// Commands are timed once the shell has first settled at its prompt,
+	// so what its rc files run in the foreground doesn't count
+	settled := false
+	var run runTimer
+	// The foreground group is looked up once, not on every tick
+	var job foregroundJob
+	var leftovers leftoverReaper
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-streaming:
+			return
+		case <-ticker.C:
+		}
+
+		leftovers.check(shellPGID, time.Now())
+
+		pgid, err := getForegroundPGID(ptyFile)
+		if errors.Is(err, os.ErrClosed) {
+			return
+		}
+		if err != nil {
+			continue
+		}
+		s.fgPGID.Store(int64(pgid))
+
+		newState := "waiting"
+		if pgid == shellPGID {
+			job = foregroundJob{}
+		} else {
+			newState = "running"
+			if job.PID != pgid {
+				job = lookupForegroundJob(pgid)
+			}
+		}
+		if mode, err := readPTYMode(ptyFile, pgid == shellPGID); err == nil {
+			s.setPTYMode(mode)
+		}
+		s.checkCwd(pgid, shellPGID)
+
+		// A new foreground group between ticks is a new command, though
+		// the shell had the terminal back too briefly to see
+		now := time.Now()
+		if s.hooked.Load() {
+			// The integration's marks time its commands
+			run = runTimer{}
+		}
+		if !run.started.IsZero() && run.pgid != pgid {
+			s.finishRun(run.command, now.Sub(run.started), now)
+			run = runTimer{}
+		}
+		if newState == "running" && run.started.IsZero() && settled && !s.hooked.Load() {
+			run = runTimer{started: now, pgid: pgid, command: job.Command}
+		}
+
+		if newState != lastState || job != lastJob {
+			s.broadcastStatus(newState, job)
+			lastState, lastJob = newState, job
+		}
+		if newState == "waiting" {
+			s.runQueuedCommand()
+		}
+		if newState == "waiting" && !settled {
+			settled = s.shellSettled(started, now)
+		}
+		if startup && settled {
+			startup = false
+			s.typeStartupCommand()
+		}
+	}
+}
+
+// errNoShell is the PTY's error while there is no shell on it, as after
+// a restart that failed to start one.
+var errNoShell = errors.New("shell not running")
+
+func (s *ShellServer) writeToPTY(data []byte) error {
+	s.ptyMu.Lock()
+	defer s.ptyMu.Unlock()
+	if s.ptyFile == nil {
+		return errNoShell
+	}
+	s.touchActive(time.Now())
+	n, err := s.ptyFile.Write(data)
+	s.stats.bytesIn.Add(int64(n))
+	s.recorder.input(data[:n])
+	if errors.Is(err, os.ErrClosed) {
+		return errNoShell
+	}
+	return err
+}
+
+// addClient registers conn and sends it the session so far: the output
+// after since if the buffer still holds it and epoch says since is this
+// process's offset, or else the screen as a snapshot, or with rawReplay
+// the whole raw output buffer. since is -1 for a client with nothing to
+// resume. With offsets, the client is told
+// the offset after each output. If -max-clients are connected already,
+// conn is sent an error and closed, and addClient returns nil.
+func (s *ShellServer) addClient(conn *websocket.Conn, info connInfo, resumeToken string, readOnly, rawReplay, offsets bool, epoch string, since int64) *client {
+	out := newWritePump(conn, s.options.ClientQueue, s.options.SlowClient == "disconnect")
+	c := s.registerClient(conn, out, info, resumeToken, readOnly)
+	if c == nil {
+		log.Printf("websocket from %v refused: %d clients connected", conn.RemoteAddr(), s.maxClients)
+		data, _ := json.Marshal(map[string]string{"kind": "error", "reason": "too many clients"})
+		out.send(outbound{msgType: websocket.TextMessage, data: data})
+		out.send(outbound{msgType: websocket.CloseMessage, data: websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "too many clients")})
+		out.close()
+		return nil
+	}
+
+	c.offsets.Store(offsets)
+	buffered, offset, redraw := s.replay(rawReplay, epoch, since)
+
+	if len(buffered) > 0 {
+		out.send(outbound{msgType: websocket.BinaryMessage, data: buffered})
+	}
+	// Signal that server is ready and all buffered content has been sent
+	rows, cols := s.ptySize()
+	role := "writer"
+	if c.readOnly {
+		role = "observer"
+	}
+	ready, _ := json.Marshal(map[string]any{
+		"kind":         "ready",
+		"client_id":    c.id,
+		"role":         role,
+		"resume_token": c.resumeToken,
+		"offset":       offset,
+		"epoch":        s.outputEpoch,
+		"capabilities": s.capabilities(),
+		"pty_mode":     s.currentPTYMode(),
+		"cwd":          s.cachedCwd(),
+		"title":        s.currentTitle(),
+		"input_owner":  s.currentInputOwner(),
+		"profile":      s.profileName(),
+		"rows":         rows,
+		"cols":         cols,
+		"alt_screen":   s.altScreenActive(),
+	})
+	out.send(outbound{msgType: websocket.TextMessage, data: ready})
+	// Only once the replay is queued and no lock is held
+	s.broadcastPresence("joined", c, info)
+	if redraw {
+		s.requestRedraw()
+	}
+	return c
+}
+
+// unregisterClient detaches conn's client and closes conn once its
+// queued messages are written.
+func (s *ShellServer) unregisterClient(conn *websocket.Conn) {
+	c := s.clientFor(conn)
+	if c != nil {
+		s.releaseInput(c)
+	}
+	out, info := s.detachClient(conn)
+	s.refitSize()
+	if out != nil {
+		s.broadcastPresence("left", c, info)
+		out.close()
+		return
+	}
+	conn.Close()
+}
+
+func (s *ShellServer) handleRestart(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, r, http.MethodPost)
+		return
+	}
+
+	req, err := decodeRestartRequest(r)
+	if err != nil {
+		invalidJSON(w, r, err)
+		return
+	}
+	if req.Profile != "" {
+		profile := s.settings().config.profile(req.Profile)
+		if profile == nil {
+			respondError(w, r, http.StatusNotFound, protocol.ErrProfileNotFound, fmt.Sprintf("no profile %q", req.Profile))
+			return
+		}
+		s.ptyMu.Lock()
+		s.profile = profile
+		s.ptyMu.Unlock()
+	}
+
+	if err := s.restart(); err != nil {
+		log.Printf("restart error: %v", err)
+		respondError(w, r, http.StatusInternalServerError, protocol.ErrRestartFailed, "failed to restart shell: "+err.Error())
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *ShellServer) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	if !websocket.IsWebSocketUpgrade(r) {
+		respondError(w, r, http.StatusUpgradeRequired, protocol.ErrUpgradeRequired, "/ws/shell needs a websocket connection")
+		return
+	}
+	if !originAllowed(r) {
+		log.Printf("websocket from origin %q refused", r.Header.Get("Origin"))
+		respondError(w, r, http.StatusForbidden, protocol.ErrOriginNotAllowed, "websocket connections from "+r.Header.Get("Origin")+" aren't allowed; see -allow-origin")
+		return
+	}
+	// The connection lives as long as the client stays
+	clearDeadlines(w)
+	conn, err := wsUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("upgrade error: %v", err)
+		return
+	}
+	query := r.URL.Query()
+	since, err := strconv.ParseInt(query.Get("since"), 10, 64)
+	if err != nil {
+		since = -1
+	}
+	s.touchActive(time.Now())
+	s.wake()
+	c := s.addClient(conn, newConnInfo(r, time.Now()), query.Get("resume"), query.Get("role") == "observer", query.Get("replay") == "raw", query.Get("offsets") == "1", query.Get("epoch"), since)
+	if c == nil {
+		return
+	}
+	defer s.unregisterClient(conn)
+	if !c.readOnly && s.tour.takeAutoOpen() {
+		s.showTour()
+	}
+
+	for {
+		msgType, data, err := conn.ReadMessage()
+		received := time.Now()
+		if err != nil {
+			log.Printf("websocket read error: %v", err)
+			return
+		}
+		if msgType != websocket.TextMessage && msgType != websocket.BinaryMessage {
+			continue
+		}
+		if msg, ok := parseControlFrame(msgType, data); ok {
+			s.handleControlMessage(conn, msg)
+			continue
+		}
+		if c.readOnly || !s.claimInput(c) {
+			continue
+		}
+		if c.macro != nil {
+			c.macro.add(data, received)
+		}
+		if s.traceInput(c) {
+			// Before the write, as the echo may be read before it returns
+			s.latency.input(received, time.Now())
+		}
+		if err := s.writeToPTY(data); err != nil {
+			log.Printf("pty write error: %v", err)
+			return
+		}
+	}
+}
+
+func (s *ShellServer) handleWidgetAction(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, r, http.MethodPost)
+		return
+	}
+
+	id, err := widgetIDFromPath(r.URL.Path)
+	if err != nil {
+		notFound(w, r)
+		return
+	}
+
+	defer r.Body.Close()
+	var payload WidgetActionRequest
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		invalidJSON(w, r, err)
+		return
+	}
+
+	switch payload.Type {
+	case "shell":
+		if payload.Cmd == "" {
+			respondError(w, r, http.StatusBadRequest, protocol.ErrInvalidRequest, "cmd required for shell action")
+			return
+		}
+		if err := s.checkWidgetCmd(id, payload.Cmd); err != nil {
+			s.rejectWidgetCmd(w, r, payload.Cmd, err)
+			return
+		}
+		s.runShellAction(w, r, payload.Cmd, payload.Detached)
+		return
+	case "internal":
+		if id == tourWidgetName && payload.Action == tourDismissAction {
+			if err := s.dismissTour(); err != nil {
+				respondError(w, r, http.StatusBadRequest, protocol.ErrInvalidRequest, err.Error())
+				return
+			}
+			break
+		}
+		widget := s.updateWidgetState(id, payload.State)
+		widget.Refresh()
+	default:
+		respondError(w, r, http.StatusBadRequest, protocol.ErrUnsupportedWidgetType,
+			fmt.Sprintf("unsupported widget type %q; want shell or internal", payload.Type))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// runShellAction runs cmd, which its caller has checked may run at all,
+// and answers the request: 202 with a confirm_id when it is held for
+// confirmation, with a job when detached, or with a queued ID when the
+// shell is busy and ?queue=1 asks for that, 409 when it is busy
+// otherwise, and 204 once it is typed into the shell.
+func (s *ShellServer) runShellAction(w http.ResponseWriter, r *http.Request, cmd string, detached bool) {
+	if s.confirmWidgetCmds && !s.isTrustedCmd(cmd) {
+		id := s.holdForConfirm(cmd, detached)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(map[string]string{"confirm_id": id})
+		return
+	}
+	if detached {
+		job := s.startDetachedCommand(cmd)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(map[string]string{"job": job})
+		return
+	}
+	if s.shellBusy() {
+		// Typed now, it would land in the running command's stdin
+		if r.URL.Query().Get("queue") != "1" {
+			respondError(w, r, http.StatusConflict, protocol.ErrShellBusy, "a command is running; retry at the prompt, or pass ?queue=1 to run it then")
+			return
+		}
+		queued := s.queueWidgetCommand(cmd)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(map[string]string{"queued": queued.ID})
+		return
+	}
+	if err := s.runWidgetCommand(cmd); err != nil {
+		ptyWriteFailed(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *ShellServer) handleHTMLWidget(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r, http.MethodGet)
+		return
+	}
+
+	switch strings.TrimPrefix(r.URL.Path, "/htmlwidget/") {
+	case "":
+		s.handleHTMLWidgetList(w, r)
+		return
+	case "search":
+		s.handleWidgetSearch(w, r)
+		return
+	}
+	widgetID, sub, ok := htmlWidgetFromPath(r.URL.Path)
+	switch {
+	case !ok:
+		notFound(w, r)
+		return
+	case sub == "fresh":
+		s.handleWidgetFresh(w, r, widgetID)
+		return
+	case sub != "":
+		notFound(w, r)
+		return
+	}
+
+	// A client holding an older version asks for the patch from it
+	if base, err := strconv.Atoi(r.URL.Query().Get("base")); err == nil {
+		if p, ok := s.widgetPatchFrom(widgetID, base, time.Now()); ok {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(p)
+			return
+		}
+	}
+
+	s.htmlWidgetsMu.RLock()
+	htmlContent, ok := s.widgetHTML(widgetID)
+	version := s.widgetVersion(widgetID)
+	title := s.widgetPrintTitle(widgetID)
+	stale := s.staleWidgets[widgetID]
+	s.htmlWidgetsMu.RUnlock()
+
+	if !ok {
+		s.widgetNotFound(w, r, widgetID)
+		return
+	}
+
+	w.Header().Set("X-Widget-Version", strconv.Itoa(version))
+	if stale {
+		w.Header().Set("X-Widget-Stale", "1")
+	}
+	s.writeWidget(w, r, widgetID, title, htmlContent)
+}
+
+// parseWidgetID parses an HTML widget ID from a path segment: a positive
+// number written as the server writes it, without a sign or leading
+// zeros, so each widget has the one URL.
+func parseWidgetID(seg string) (int, bool) {
+	id, err := strconv.Atoi(seg)
+	if err != nil || id <= 0 || strconv.Itoa(id) != seg {
+		return 0, false
+	}
+	return id, true
+}
+
+// htmlWidgetFromPath parses /htmlwidget/{id}, and /htmlwidget/{id}/{sub}
+// for the routes under a widget, returning sub or "" for none.
+func htmlWidgetFromPath(path string) (id int, sub string, ok bool) {
+	rest, ok := strings.CutPrefix(path, "/htmlwidget/")
+	if !ok {
+		return 0, "", false
+	}
+	seg, sub, hasSub := strings.Cut(rest, "/")
+	if id, ok = parseWidgetID(seg); !ok || hasSub && (sub == "" || strings.Contains(sub, "/")) {
+		return 0, "", false
+	}
+	return id, sub, true
+}
+
+func widgetIDFromPath(path string) (string, error) {
+	const prefix = "/widget/"
+	if !strings.HasPrefix(path, prefix) {
+		return "", errors.New("invalid path")
+	}
+	rest := strings.TrimPrefix(path, prefix)
+	parts := strings.Split(rest, "/")
+	if len(parts) != 2 || parts[1] != "action" || parts[0] == "" {
+		return "", errors.New("invalid widget path")
+	}
+	return parts[0], nil
+}
+
+func (s *ShellServer) updateWidgetState(id string, state json.RawMessage) *Widget {
+	s.widgetsMu.Lock()
+	defer s.widgetsMu.Unlock()
+	widget, ok := s.widgets[id]
+	if !ok {
+		widget = &Widget{ID: id}
+		s.widgets[id] = widget
+	}
+	if len(state) > 0 {
+		copied := make(json.RawMessage, len(state))
+		copy(copied, state)
+		widget.State = copied
+	}
+	return widget
+}
+
+// RefreshWidget describes where real DOM patch logic will live later.
+func RefreshWidget(id string) {
+	log.Printf("widget %s refreshed", id)
+}
+
+// registerRoutes adds the websocket and API endpoints to mux; the static
+// web UI is served separately.
+func (s *ShellServer) registerRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("/ws/shell", s.authed(s.handleWebSocket))
+	mux.HandleFunc("/restart", s.authed(s.handleRestart))
+	mux.HandleFunc("/exec", s.authed(s.handleExec))
+	mux.HandleFunc("/cwd", s.authed(s.handleCwd))
+	mux.HandleFunc("/download", s.authed(s.gated("/download", s.handleDownload)))
+	mux.HandleFunc("/control/take", s.authed(s.handleControlTake))
+	mux.HandleFunc("/clients", s.authed(s.handleClients))
+	mux.HandleFunc("/queue", s.authed(s.handleQueue))
+	mux.HandleFunc("/queue/", s.authed(s.handleQueue))
+	mux.HandleFunc("/profiles", s.authed(s.handleProfiles))
+	mux.HandleFunc("/resize", s.authed(s.handleResize))
+	mux.HandleFunc("/signal", s.authed(s.handleSignal))
+	mux.HandleFunc("/paste", s.authed(s.handlePaste))
+	mux.HandleFunc("/widget/", s.authed(s.handleWidget))
+	mux.HandleFunc("/htmlwidget/", s.authed(s.gated("/htmlwidget/", s.handleHTMLWidget)))
+	mux.HandleFunc("/integration", s.authed(s.handleIntegration))
+	mux.HandleFunc("/history", s.authed(s.handleHistory))
+	mux.HandleFunc("/history/", s.authed(s.handleHistory))
+	mux.HandleFunc("/block/", s.authed(s.handleBlock))
+	mux.HandleFunc("/confirm/", s.authed(s.handleConfirm))
+	mux.HandleFunc("/sessions", s.authed(s.handleSessions))
+	mux.HandleFunc("/status", s.authed(s.handleStatus))
+	// Unauthenticated, for supervisors; it tells no more than up or down
+	mux.HandleFunc("/healthz", s.handleHealthz)
+	mux.HandleFunc("/buffer", s.authed(s.handleBuffer))
+	mux.HandleFunc("/rawmode", s.authed(s.handleRawMode))
+	mux.HandleFunc("/version", s.authed(s.handleVersion))
+	mux.HandleFunc("/info", s.authed(s.handleInfo))
+	mux.HandleFunc("/recordings", s.authed(s.handleRecordings))
+	mux.HandleFunc("/recordings/", s.authed(s.gated("/recordings/", s.handleRecordings)))
+	mux.HandleFunc("/record/", s.authed(s.handleRecord))
+	// A mount's token is what grants access to its files, so only
+	// mounting and unmounting need the session token
+	mux.HandleFunc("/files", s.authed(s.handleFiles))
+	mux.HandleFunc("/files/", s.gated("/files/", s.authedUnmount(s.handleFiles)))
+	mux.HandleFunc("/debug/latency", s.authed(s.handleLatency))
+	mux.HandleFunc("/debug/vars", s.authed(s.handleDebugVars))
+	mux.HandleFunc("/debug/widget/", s.authed(s.handleWidgetOriginal))
+	mux.HandleFunc("/envsnapshot", s.authed(s.handleEnvSnapshot))
+	mux.HandleFunc("/macros", s.authed(s.handleMacros))
+	mux.HandleFunc("/macros/", s.authed(s.handleMacros))
+	mux.HandleFunc("/reload", s.authed(s.handleReload))
+	mux.HandleFunc("/tour", s.authed(s.handleTour))
+}
+
+// RunSubcommand runs the install, doctor or tour subcommand args start
+// with, and reports whether there was one.
+func RunSubcommand(args []string) bool {
+	if len(args) == 0 {
+		return false
+	}
+	switch args[0] {
+	case "install":
+		if err := runInstall(args[1:]); err != nil {
+			log.Fatalf("install: %v", err)
+		}
+	case "doctor":
+		if err := runDoctor(args[1:], os.Stdout); err != nil {
+			log.Fatalf("doctor: %v", err)
+		}
+	case "tour":
+		if err := runTour(args[1:]); err != nil {
+			log.Fatalf("tour: %v", err)
+		}
+	default:
+		return false
+	}
+	return true
+}
+
+// Main is the goshell command: it serves the session Flags describe,
+// once the caller has parsed them, until SIGINT or SIGTERM.
+func Main() {
+	if err := checkStartup(); err != nil {
+		log.Fatal(err)
+	}
+	if err := checkIdleFlags(); err != nil {
+		log.Fatal(err)
+	}
+	generatedToken := setupAuthToken()
+
+	// Listen first, so that with -addr :0 everything naming the server's
+	// address, the shell's GOSHELL_URL included, has the port picked
+	ln, err := net.Listen("tcp", *flagAddr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	*flagAddr = ln.Addr().String()
+
+	server, err := newShellServer()
+	if err != nil {
+		log.Fatalf("create shell server: %v", err)
+	}
+
+	server.tour.arm()
+	mux := http.NewServeMux()
+	server.mount(mux)
+
+	// SIGINT and SIGTERM shut down cleanly, so the session is closed
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			server.reloadAndLog()
+		}
+	}()
+	// An idle session with -idle-action=exit shuts down as SIGTERM would
+	ctx, idleExit := context.WithCancel(ctx)
+	defer idleExit()
+	if *flagIdleTimeout > 0 {
+		onIdle := server.hibernate
+		if *flagIdleAction == "exit" {
+			onIdle = idleExit
+		}
+		go server.watchIdle(ctx, *flagIdleTimeout, idleWarning, *flagIdleAction, onIdle)
+	}
+	httpServer := newHTTPServer(ctx, withMiddleware(mux))
+	shutdownDone := make(chan struct{})
+	go func() {
+		defer close(shutdownDone)
+		<-ctx.Done()
+		log.Printf("shutting down")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), *flagShutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			log.Printf("close session: %v", err)
+		}
+		if err := httpServer.Shutdown(shutdownCtx); err != nil {
+			httpServer.Close()
+		}
+	}()
+
+	log.Printf("goshell %s: %s", buildVersion(), capabilitySummary(server.capabilities()))
+	log.Printf("server listening on %s://%s", serverScheme(), *flagAddr)
+	logAuth(generatedToken)
+	if *flagOpen {
+		openBrowser(sessionURL(*flagAddr))
+	}
+	serve := func() error { return httpServer.Serve(ln) }
+	if *flagTLSCert != "" {
+		serve = func() error { return httpServer.ServeTLS(ln, *flagTLSCert, *flagTLSKey) }
+	}
+	if err := serve(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		server.Close()
+		log.Fatalf("http server stopped: %v", err)
+	}
+	<-shutdownDone
+}
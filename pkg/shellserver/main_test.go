@@ -0,0 +1,332 @@
+package shellserver
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"shellserver/internal/store"
+)
+
+func TestContainsAltScreenExit(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    []byte
+		expected bool
+	}{
+		{"empty", []byte{}, false},
+		{"no escape", []byte("hello world"), false},
+		{"xterm 1049", []byte("prefix\x1b[?1049lsuffix"), true},
+		{"older xterm 47", []byte("\x1b[?47l"), true},
+		{"variant 1047", []byte("data\x1b[?1047lmore"), true},
+		{"enter alt screen (not exit)", []byte("\x1b[?1049h"), false},
+		{"partial sequence", []byte("\x1b[?1049"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := containsAltScreenExit(tt.input)
+			if got != tt.expected {
+				t.Errorf("containsAltScreenExit(%q) = %v, want %v", tt.input, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestStripHTMLMode(t *testing.T) {
+	start := string(htmlStartMarker)
+	end := string(htmlEndMarker)
+
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"empty", "", ""},
+		{"no markers", "hello world", "hello world"},
+		{"single block", "before" + start + "html content" + end + "after", "beforeafter"},
+		{"multiple blocks", "a" + start + "x" + end + "b" + start + "y" + end + "c", "abc"},
+		{"incomplete start only", "before" + start + "partial", "before"},
+		{"nested content", start + "<div>test</div>" + end, ""},
+		{"markers in sequence", "pre" + start + end + "post", "prepost"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := stripHTMLMode([]byte(tt.input))
+			if string(got) != tt.expected {
+				t.Errorf("stripHTMLMode(%q) = %q, want %q", tt.input, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestWidgetIDFromPath(t *testing.T) {
+	tests := []struct {
+		name      string
+		path      string
+		wantID    string
+		wantError bool
+	}{
+		{"valid", "/widget/abc123/action", "abc123", false},
+		{"valid with dashes", "/widget/my-widget-1/action", "my-widget-1", false},
+		{"missing prefix", "/other/abc/action", "", true},
+		{"missing action", "/widget/abc", "", true},
+		{"wrong suffix", "/widget/abc/other", "", true},
+		{"empty id", "/widget//action", "", true},
+		{"extra segments", "/widget/abc/action/extra", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := widgetIDFromPath(tt.path)
+			if tt.wantError {
+				if err == nil {
+					t.Errorf("widgetIDFromPath(%q) expected error, got %q", tt.path, got)
+				}
+			} else {
+				if err != nil {
+					t.Errorf("widgetIDFromPath(%q) unexpected error: %v", tt.path, err)
+				}
+				if got != tt.wantID {
+					t.Errorf("widgetIDFromPath(%q) = %q, want %q", tt.path, got, tt.wantID)
+				}
+			}
+		})
+	}
+}
+
+func TestHTMLWidgetRouting(t *testing.T) {
+	s := newPumpTestServer()
+	for i := 1; i <= 7; i++ {
+		s.extractAndStoreHTML([]byte(fmt.Sprintf("%s<p>widget %d</p>%s", htmlStartMarker, i, htmlEndMarker)))
+	}
+
+	tests := []struct {
+		path   string
+		status int
+		body   string
+	}{
+		{"/htmlwidget/", http.StatusOK, `"id":7`},
+		{"/htmlwidget/7", http.StatusOK, "<p>widget 7</p>"},
+		{"/htmlwidget/7/fresh", http.StatusNotFound, "freshness_not_tracked"},
+		{"/htmlwidget/abc", http.StatusNotFound, "not found"},
+		{"/htmlwidget/7abc", http.StatusNotFound, "not found"},
+		{"/htmlwidget/7/extra", http.StatusNotFound, "not found"},
+		{"/htmlwidget/7/fresh/extra", http.StatusNotFound, "not found"},
+		{"/htmlwidget/7/", http.StatusNotFound, "not found"},
+		{"/htmlwidget/007", http.StatusNotFound, "not found"},
+		{"/htmlwidget/+7", http.StatusNotFound, "not found"},
+		{"/htmlwidget/0", http.StatusNotFound, "not found"},
+		{"/htmlwidget/-7", http.StatusNotFound, "not found"},
+		{"/htmlwidget/8", http.StatusNotFound, "no widget 8"},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		s.handleHTMLWidget(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if rec.Code != tt.status || !strings.Contains(rec.Body.String(), tt.body) {
+			t.Errorf("GET %s: %d %q, want %d with %q", tt.path, rec.Code, rec.Body.String(), tt.status, tt.body)
+		}
+	}
+}
+
+func TestExtractAndStoreHTML(t *testing.T) {
+	start := string(htmlStartMarker)
+	end := string(htmlEndMarker)
+
+	// Create a minimal server just for the HTML storage
+	s := &ShellServer{
+		store:          store.NewMemory(),
+		htmlKeys:       make(map[string]int),
+		widgetVersions: make(map[int]int),
+		widgetPatches:  make(map[int]*widgetPatch),
+		widgetIndex:    make(map[int]*widgetText),
+	}
+
+	tests := []struct {
+		name              string
+		input             string
+		wantProcessedLen  int // just check length since replacement includes dynamic ID
+		wantRemainingNil  bool
+		wantWidgetCount   int
+		wantStoredContent string // content of first widget if any
+	}{
+		{
+			name:             "no html",
+			input:            "plain text",
+			wantProcessedLen: 10,
+			wantRemainingNil: true,
+			wantWidgetCount:  0,
+		},
+		{
+			name:              "single complete block",
+			input:             "before" + start + "<div>hello</div>" + end + "after",
+			wantProcessedLen:  -1, // variable due to link text
+			wantRemainingNil:  true,
+			wantWidgetCount:   1,
+			wantStoredContent: "<div>hello</div>",
+		},
+		{
+			name:             "incomplete block",
+			input:            "before" + start + "partial content",
+			wantProcessedLen: 6, // just "before"
+			wantRemainingNil: false,
+			wantWidgetCount:  0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Reset state
+			s.store = store.NewMemory()
+			s.htmlCounter = 0
+
+			processed, remaining, widgetIDs, _ := s.extractAndStoreHTML([]byte(tt.input))
+
+			if tt.wantProcessedLen >= 0 && len(processed) != tt.wantProcessedLen {
+				t.Errorf("processed length = %d, want %d", len(processed), tt.wantProcessedLen)
+			}
+
+			if tt.wantRemainingNil && remaining != nil {
+				t.Errorf("remaining = %q, want nil", remaining)
+			}
+			if !tt.wantRemainingNil && remaining == nil {
+				t.Errorf("remaining = nil, want non-nil")
+			}
+
+			if len(widgetIDs) != tt.wantWidgetCount {
+				t.Errorf("widget count = %d, want %d", len(widgetIDs), tt.wantWidgetCount)
+			}
+
+			if tt.wantStoredContent != "" && len(widgetIDs) > 0 {
+				stored, _ := s.widgetHTML(widgetIDs[0])
+				if stored != tt.wantStoredContent {
+					t.Errorf("stored content = %q, want %q", stored, tt.wantStoredContent)
+				}
+			}
+
+			// Verify the processed output contains a link for each widget
+			for _, id := range widgetIDs {
+				if !bytes.Contains(processed, []byte("htmlwidget:")) {
+					t.Errorf("processed output missing htmlwidget link for id %d", id)
+				}
+			}
+		})
+	}
+}
+
+func TestExtractAndStoreHTMLReplacesKeyedWidget(t *testing.T) {
+	s := &ShellServer{
+		store:          store.NewMemory(),
+		htmlKeys:       make(map[string]int),
+		widgetVersions: make(map[int]int),
+		widgetPatches:  make(map[int]*widgetPatch),
+		widgetIndex:    make(map[int]*widgetText),
+	}
+	keyed := "\x1b]9001;HTML_START;key=watch-1\x07"
+	end := string(htmlEndMarker)
+
+	processed, _, ids, updated := s.extractAndStoreHTML([]byte("a" + keyed + "v1" + end + "b"))
+	if len(ids) != 1 || len(updated) != 0 || !bytes.Contains(processed, []byte("htmlwidget:")) {
+		t.Fatalf("first keyed block: ids=%v updated=%v processed=%q", ids, updated, processed)
+	}
+	id := ids[0]
+
+	// A split marker is held back until it completes.
+	processed, remaining, _, _ := s.extractAndStoreHTML([]byte("c\x1b]9001;HTML_START;key=wat"))
+	if string(processed) != "c" || len(remaining) == 0 {
+		t.Fatalf("split marker: processed=%q remaining=%q", processed, remaining)
+	}
+
+	processed, _, ids, updated = s.extractAndStoreHTML([]byte(keyed + "v2" + end + "d"))
+	if len(ids) != 0 || len(updated) != 1 || updated[0] != id {
+		t.Fatalf("second keyed block: ids=%v updated=%v, want update of %d", ids, updated, id)
+	}
+	if string(processed) != "d" {
+		t.Errorf("replacing block left %q in the terminal, want only the surrounding output", processed)
+	}
+	if got, _ := s.widgetHTML(id); got != "v2" {
+		t.Errorf("widget %d content = %q, want v2", id, got)
+	}
+
+	// Unkeyed blocks still get a widget each.
+	_, _, ids, _ = s.extractAndStoreHTML([]byte(string(htmlStartMarker) + "x" + end))
+	if len(ids) != 1 || ids[0] == id {
+		t.Errorf("unkeyed block ids = %v, want a new widget", ids)
+	}
+
+	if got := string(stripHTMLMode([]byte("e" + keyed + "v3" + end + "f"))); got != "ef" {
+		t.Errorf("stripHTMLMode with keyed marker = %q, want %q", got, "ef")
+	}
+}
+
+// newPipeServer returns a ShellServer whose "PTY" is the write end of a pipe,
+// plus a channel of the lines the server writes to the shell.
+func newPipeServer(t *testing.T) (*ShellServer, <-chan string) {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		r.Close()
+		w.Close()
+	})
+
+	trusted, err := compileCmdPatterns(nil, defaultTrustedCmdPatterns())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &ShellServer{
+		ptyFile:           w,
+		clients:           make(map[*websocket.Conn]*client),
+		detached:          make(map[string]*detachedClient),
+		resumeGrace:       time.Second,
+		widgets:           make(map[string]*Widget),
+		store:             store.NewMemory(),
+		htmlKeys:          make(map[string]int),
+		widgetVersions:    make(map[int]int),
+		widgetPatches:     make(map[int]*widgetPatch),
+		widgetIndex:       make(map[int]*widgetText),
+		widgetErrors:      make(map[int]*widgetErrorLog),
+		confirmWidgetCmds: true,
+		confirmTimeout:    defaultConfirmTimeout,
+		confirms:          make(map[string]*pendingConfirm),
+		confirmSigner:     newConfirmSigner(),
+	}
+	// Any command is allowed, leaving confirmation to decide
+	s.live.Store(&liveSettings{config: &serverConfig{}, allowedCmds: []*regexp.Regexp{regexp.MustCompile(``)}, trustedCmds: trusted})
+
+	lines := make(chan string, 16)
+	go func() {
+		br := bufio.NewReader(r)
+		for {
+			line, err := br.ReadString('\n')
+			if err != nil {
+				return
+			}
+			lines <- line
+		}
+	}()
+	return s, lines
+}
+
+// readPTYLine waits up to timeout for a line written to the fake PTY.
+func readPTYLine(t *testing.T, lines <-chan string, timeout time.Duration) (string, bool) {
+	t.Helper()
+	select {
+	case line := <-lines:
+		return line, true
+	case <-time.After(timeout):
+		return "", false
+	}
+}
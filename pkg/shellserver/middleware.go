@@ -0,0 +1,139 @@
+package shellserver
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"runtime/debug"
+	"time"
+
+	"shellserver/pkg/protocol"
+)
+
+var (
+	flagMaxBody     = byteSizeFlag(1 << 20)
+	flagNoAccessLog = Flags.Bool("no-access-log", false, "don't log each HTTP request")
+)
+
+func init() {
+	Flags.Var(&flagMaxBody, "max-body", "largest request body, such as 64K, the API reads; bigger ones get 413")
+}
+
+// withMiddleware wraps every route the server serves: requests are
+// logged unless -no-access-log, a panic becomes a 500, and bodies are cut
+// off at -max-body.
+func withMiddleware(h http.Handler) http.Handler {
+	h = limitBodies(h, int64(flagMaxBody))
+	h = recoverPanics(h)
+	if !*flagNoAccessLog {
+		h = logRequests(h)
+	}
+	return h
+}
+
+// statusRecorder remembers the status a handler wrote. It passes
+// hijacking and flushing through, so the websocket and streamed
+// responses work behind it.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int // 0 until the header is written
+}
+
+func (rec *statusRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *statusRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	return rec.ResponseWriter.Write(b)
+}
+
+func (rec *statusRecorder) Flush() {
+	if f, ok := rec.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (rec *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := rec.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response can't be hijacked")
+	}
+	conn, rw, err := h.Hijack()
+	if err == nil && rec.status == 0 {
+		rec.status = http.StatusSwitchingProtocols
+	}
+	return conn, rw, err
+}
+
+// Unwrap lets http.ResponseController reach the connection's writer.
+func (rec *statusRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// logRequests logs each request once it is done: method, path, status,
+// duration and remote address. The query is left out, as it can hold
+// the session token. A websocket is logged when it closes.
+func logRequests(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec, ok := w.(*statusRecorder)
+		if !ok {
+			rec = &statusRecorder{ResponseWriter: w}
+		}
+		defer func() {
+			status := rec.status
+			if status == 0 {
+				status = http.StatusOK
+			}
+			log.Printf("%s %s %d %v %s", r.Method, r.URL.Path, status, time.Since(start).Round(time.Microsecond), r.RemoteAddr)
+		}()
+		h.ServeHTTP(rec, r)
+	})
+}
+
+// recoverPanics turns a panicking handler into a 500, logging the panic
+// and its stack, instead of dropping the connection without a word. A
+// handler that had already started its response can only be cut short.
+func recoverPanics(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec, ok := w.(*statusRecorder)
+		if !ok {
+			rec = &statusRecorder{ResponseWriter: w}
+		}
+		defer func() {
+			p := recover()
+			if p == nil {
+				return
+			}
+			if p == http.ErrAbortHandler {
+				panic(p)
+			}
+			log.Printf("panic serving %s %s: %v\n%s", r.Method, r.URL.Path, p, debug.Stack())
+			if rec.status == 0 {
+				respondError(rec, r, http.StatusInternalServerError, protocol.ErrInternal, fmt.Sprintf("internal error serving %s", r.URL.Path))
+			}
+		}()
+		h.ServeHTTP(rec, r)
+	})
+}
+
+// limitBodies caps every request body at limit bytes. The API's bodies
+// are all small JSON (or form) documents; a read past the limit fails,
+// and invalidJSON answers it with 413.
+func limitBodies(h http.Handler, limit int64) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if limit > 0 && r.Body != nil && r.Body != http.NoBody {
+			r.Body = http.MaxBytesReader(w, r.Body, limit)
+		}
+		h.ServeHTTP(w, r)
+	})
+}
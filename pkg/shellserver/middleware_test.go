@@ -0,0 +1,106 @@
+package shellserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"shellserver/internal/testshell"
+	"shellserver/pkg/protocol"
+)
+
+// captureLog collects what the standard logger writes until the test ends.
+func captureLog(t *testing.T) *bytes.Buffer {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	return &buf
+}
+
+func errorCode(t *testing.T, resp *http.Response) protocol.ErrorCode {
+	t.Helper()
+	var body protocol.ErrorResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("error body: %v", err)
+	}
+	return body.Error.Code
+}
+
+func TestMiddlewareRecoversPanics(t *testing.T) {
+	logs := captureLog(t)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/boom", func(w http.ResponseWriter, r *http.Request) { panic("kaboom") })
+	ts := httptest.NewServer(withMiddleware(mux))
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/boom?token=secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusInternalServerError || errorCode(t, resp) != protocol.ErrInternal {
+		t.Errorf("status %d, want 500 %s", resp.StatusCode, protocol.ErrInternal)
+	}
+	for _, want := range []string{"panic serving GET /boom: kaboom", "middleware_test.go", "GET /boom 500 "} {
+		if !strings.Contains(logs.String(), want) {
+			t.Errorf("log missing %q:\n%s", want, logs)
+		}
+	}
+	if strings.Contains(logs.String(), "secret") {
+		t.Errorf("the query, token and all, was logged:\n%s", logs)
+	}
+
+	// The server carries on
+	resp, err = http.Get(ts.URL + "/missing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if !strings.Contains(logs.String(), "GET /missing 404 ") {
+		t.Errorf("log missing the 404:\n%s", logs)
+	}
+}
+
+func TestMiddlewareLimitsBodies(t *testing.T) {
+	defer func(old byteSizeFlag) { flagMaxBody = old }(flagMaxBody)
+	flagMaxBody = 64
+	s, err := newShellServerWithShell(testshell.Command())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	mux := http.NewServeMux()
+	s.registerRoutes(mux)
+	ts := httptest.NewServer(withMiddleware(mux))
+	defer ts.Close()
+
+	big := `{"rows":40,"cols":120,"client_id":"` + strings.Repeat("x", 100) + `"}`
+	for _, path := range []string{"/resize", "/widget/x/action"} {
+		resp, err := http.Post(ts.URL+path, "application/json", strings.NewReader(big))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusRequestEntityTooLarge || errorCode(t, resp) != protocol.ErrBodyTooLarge {
+			t.Errorf("%s: status %d, want 413 %s", path, resp.StatusCode, protocol.ErrBodyTooLarge)
+		}
+		resp.Body.Close()
+	}
+	resp, err := http.Post(ts.URL+"/resize", "application/json", strings.NewReader(`{"rows":40,"cols":120}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		t.Errorf("small body: status %d", resp.StatusCode)
+	}
+
+	// The websocket still upgrades through the middleware
+	c := testshell.Dial(t, ts.URL, "")
+	c.Send("echo through-middleware")
+	c.ExpectOutput("\r\nthrough-middleware\r\n", testshell.DefaultTimeout)
+}
@@ -0,0 +1,68 @@
+package shellserver
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+var flagNotifyMin = Flags.Duration("notify-min-duration", 10*time.Second, "tell clients when a command that ran at least this long finishes, for a desktop notification (0 disables)")
+
+// recentRunsKept is how many finished commands /status lists.
+const recentRunsKept = 10
+
+// finishedRun is a command that ran in the foreground, timed by the shell
+// integration's marks or, for a shell without them, by monitorStatus from
+// its process group taking the terminal to the shell taking it back.
+type finishedRun struct {
+	Command    string    `json:"command"`
+	DurationMs int64     `json:"duration_ms"`
+	Finished   time.Time `json:"finished"`
+}
+
+// recentRuns is the last recentRunsKept finished commands, newest last.
+type recentRuns struct {
+	mu   sync.Mutex
+	runs []finishedRun
+}
+
+func (r *recentRuns) add(run finishedRun) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.runs = append(r.runs, run)
+	if over := len(r.runs) - recentRunsKept; over > 0 {
+		r.runs = append(r.runs[:0:0], r.runs[over:]...)
+	}
+}
+
+func (r *recentRuns) list() []finishedRun {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]finishedRun{}, r.runs...)
+}
+
+// runTimer times the command in the foreground for monitorStatus, in a
+// shell without the integration.
+type runTimer struct {
+	started time.Time // zero while the shell waits, or for a run not timed
+	pgid    int       // the command's process group
+	command string    // the process's command line
+}
+
+// finishRun records command as having run for d until now and, if that
+// is at least -notify-min-duration, tells clients with
+// {"kind":"finished","duration_ms":N,"command":"..."}.
+func (s *ShellServer) finishRun(command string, d time.Duration, now time.Time) {
+	s.recentRuns.add(finishedRun{Command: command, DurationMs: d.Milliseconds(), Finished: now})
+	if s.notifyMin <= 0 || d < s.notifyMin {
+		return
+	}
+	data, _ := json.Marshal(map[string]any{
+		"kind":        "finished",
+		"duration_ms": d.Milliseconds(),
+		"command":     command,
+	})
+	s.broadcastMessage(websocket.TextMessage, data)
+}
@@ -0,0 +1,67 @@
+package shellserver
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"shellserver/internal/testshell"
+)
+
+// withNotifyMin sets -notify-min-duration for servers the test starts.
+func withNotifyMin(t *testing.T, d time.Duration) {
+	t.Helper()
+	old := *flagNotifyMin
+	*flagNotifyMin = d
+	t.Cleanup(func() { *flagNotifyMin = old })
+}
+
+func TestFinishedNotification(t *testing.T) {
+	withNotifyMin(t, 500*time.Millisecond)
+	_, ts := startFakeShellServer(t)
+	c := testshell.Dial(t, ts.URL, "")
+
+	// The integration names and times each command, from its command
+	// line to its finished mark; a quick one is listed but not announced
+	c.Send("mark command true")
+	c.Send("mark finished 0")
+	c.Send("mark command make all")
+	c.Send("fg 800ms")
+	c.Send("mark finished 0")
+	ev := c.ExpectEvent("finished", testshell.DefaultTimeout)
+	if ev["command"] != "make all" {
+		t.Errorf("finished = %v, want the long command, named by the integration", ev)
+	}
+	if ms, _ := ev["duration_ms"].(float64); ms < 800 || ms > 5000 {
+		t.Errorf("duration_ms = %v", ev["duration_ms"])
+	}
+
+	// Both are listed once, the foreground process not timed again
+	runs := getStatus(t, ts.URL).Finished
+	if len(runs) != 2 {
+		t.Fatalf("finished_commands = %+v, want both commands", runs)
+	}
+	if runs[0].Command != "true" || runs[0].DurationMs >= 500 {
+		t.Errorf("short command = %+v", runs[0])
+	}
+	if runs[1].Command != "make all" || runs[1].DurationMs < 800 {
+		t.Errorf("long command = %+v", runs[1])
+	}
+}
+
+func TestFinishedNotificationWithoutHooks(t *testing.T) {
+	withNotifyMin(t, 500*time.Millisecond)
+	_, ts := startFakeShellServer(t)
+	c := testshell.Dial(t, ts.URL, "")
+	// Commands are timed once the shell has settled at its prompt
+	time.Sleep(3 * startupSettle)
+
+	c.Send("fg 800ms")
+	ev := c.ExpectEvent("finished", testshell.DefaultTimeout)
+	if command, _ := ev["command"].(string); !strings.Contains(command, "800ms") {
+		t.Errorf("finished = %v, want the command named by its process", ev)
+	}
+	if ms, _ := ev["duration_ms"].(float64); ms < 500 || ms > 5000 {
+		t.Errorf("duration_ms = %v", ev["duration_ms"])
+	}
+}
@@ -0,0 +1,64 @@
+package shellserver
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"os/exec"
+	"runtime"
+)
+
+var flagOpen = Flags.Bool("open", false, "open the session in the default browser once the server is listening")
+
+// browserCommand is the argv that opens url in the default browser on
+// goos.
+func browserCommand(goos, url string) []string {
+	switch goos {
+	case "darwin":
+		return []string{"open", url}
+	case "windows":
+		// start's first quoted argument is the window title
+		return []string{"cmd", "/c", "start", `""`, url}
+	default:
+		return []string{"xdg-open", url}
+	}
+}
+
+// openBrowser opens url in the default browser. Failing to is only
+// logged: the session is there for whoever opens the URL by hand.
+func openBrowser(url string) {
+	argv := browserCommand(runtime.GOOS, url)
+	cmd := exec.Command(argv[0], argv[1:]...)
+	if err := cmd.Start(); err != nil {
+		log.Printf("-open: %v", err)
+		return
+	}
+	go func() {
+		if err := cmd.Wait(); err != nil {
+			log.Printf("-open: %s: %v", argv[0], err)
+		}
+	}()
+}
+
+// sessionURL is the URL that opens the session in a browser on this
+// machine, with the token if there is one.
+func sessionURL(addr string) string {
+	url := localURL(addr) + "/"
+	if *flagToken != "" {
+		url += "#token=" + *flagToken
+	}
+	return url
+}
+
+// handleInfo serves GET /info, where the server is listening:
+// {"addr","url"}. The address is the one actually bound, so with -addr
+// :0 it names the port picked.
+func (s *ShellServer) handleInfo(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r, http.MethodGet)
+		return
+	}
+	addr := s.options.Addr
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"addr": addr, "url": localURL(addr)})
+}
@@ -0,0 +1,42 @@
+package shellserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestBrowserCommand(t *testing.T) {
+	url := "http://127.0.0.1:7777/"
+	for goos, want := range map[string][]string{
+		"linux":   {"xdg-open", url},
+		"freebsd": {"xdg-open", url},
+		"darwin":  {"open", url},
+		"windows": {"cmd", "/c", "start", `""`, url},
+	} {
+		if got := browserCommand(goos, url); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: %q, want %q", goos, got, want)
+		}
+	}
+}
+
+func TestInfoReportsBoundAddress(t *testing.T) {
+	old := *flagAddr
+	*flagAddr = "[::]:41234"
+	t.Cleanup(func() { *flagAddr = old })
+	_, ts := startFakeShellServer(t)
+
+	resp, err := http.Get(ts.URL + "/info")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var info map[string]string
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		t.Fatal(err)
+	}
+	if info["addr"] != "[::]:41234" || info["url"] != "http://127.0.0.1:41234" {
+		t.Errorf("/info = %v", info)
+	}
+}
@@ -0,0 +1,28 @@
+package shellserver
+
+import (
+	"fmt"
+	"time"
+
+	"shellserver/internal/httpclient"
+)
+
+var (
+	flagProxyURL        = Flags.String("proxy-url", "", "proxy for the server's outbound requests (default: HTTPS_PROXY, HTTP_PROXY and NO_PROXY)")
+	flagCABundle        = Flags.String("ca-bundle", "", "PEM file of CA certificates trusted for outbound requests, besides the system's")
+	flagOutboundTimeout = Flags.Duration("outbound-timeout", 30*time.Second, "timeout for each outbound request (0 for none)")
+)
+
+// newOutboundClient is the client for every request the server makes to
+// other servers, configured by set.
+func newOutboundClient(set Settings) (*httpclient.Client, error) {
+	c, err := httpclient.New(httpclient.Config{
+		ProxyURL: set.ProxyURL,
+		CABundle: set.CABundle,
+		Timeout:  set.OutboundTimeout,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("outbound requests: %w", err)
+	}
+	return c, nil
+}
@@ -0,0 +1,63 @@
+package shellserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// Bracketed paste (DECSET 2004) marks where pasted text starts and ends,
+// so a shell inserts it whole instead of running each line as it arrives.
+const (
+	bracketedPasteMode  = 2004
+	bracketedPasteStart = "\x1b[200~"
+	bracketedPasteEnd   = "\x1b[201~"
+)
+
+// bracketedPaste reports whether the program in the terminal has turned
+// on bracketed paste, as last seen in the output.
+func (s *ShellServer) bracketedPaste() bool {
+	s.bufferMu.Lock()
+	defer s.bufferMu.Unlock()
+	return s.screen != nil && s.screen.Mode(bracketedPasteMode)
+}
+
+// pasteInput is what a terminal sends for pasting text: its line endings
+// as carriage returns, the Enter key's, and with bracketed, wrapped in
+// the paste brackets. An end bracket inside text is taken out, so the
+// paste can't end early and have the rest run.
+func pasteInput(text string, bracketed bool) []byte {
+	text = strings.NewReplacer("\r\n", "\r", "\n", "\r").Replace(text)
+	if !bracketed {
+		return []byte(text)
+	}
+	text = strings.ReplaceAll(text, bracketedPasteEnd, "")
+	return []byte(bracketedPasteStart + text + bracketedPasteEnd)
+}
+
+// paste writes text to the shell as pasted.
+func (s *ShellServer) paste(text string) ([]byte, error) {
+	data := pasteInput(text, s.bracketedPaste())
+	return data, s.writeToPTY(data)
+}
+
+// handlePaste serves POST /paste, {"data":"..."}: the websocket's
+// {"kind":"paste"} for clients without one.
+func (s *ShellServer) handlePaste(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, r, http.MethodPost)
+		return
+	}
+	var req struct {
+		Data string `json:"data"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		invalidJSON(w, r, err)
+		return
+	}
+	if _, err := s.paste(req.Data); err != nil {
+		ptyWriteFailed(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
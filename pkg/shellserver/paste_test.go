@@ -0,0 +1,61 @@
+package shellserver
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"shellserver/internal/testshell"
+)
+
+func TestPasteInput(t *testing.T) {
+	tests := []struct {
+		name      string
+		text      string
+		bracketed bool
+		want      string
+	}{
+		{"plain", "ls\n", false, "ls\r"},
+		{"CRLF", "a\r\nb\r\n", false, "a\rb\r"},
+		{"bracketed", "a\nb\n", true, "\x1b[200~a\rb\r\x1b[201~"},
+		{"end bracket taken out", "a\x1b[201~rm -rf x\n", true, "\x1b[200~arm -rf x\r\x1b[201~"},
+		{"unbracketed keeps escapes", "\x1b[201~", false, "\x1b[201~"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := string(pasteInput(tt.text, tt.bracketed)); got != tt.want {
+				t.Errorf("pasteInput(%q, %v) = %q, want %q", tt.text, tt.bracketed, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPaste(t *testing.T) {
+	s, ts := startFakeShellServer(t)
+	c := testshell.Dial(t, ts.URL, "")
+
+	// Without bracketed paste the lines run one by one
+	c.SendJSON(map[string]string{"kind": "paste", "data": "raw \"first-line\\x21\\n\"\nraw \"second-line\\x21\\n\"\n"})
+	c.ExpectOutput("first-line!", testshell.DefaultTimeout)
+	c.ExpectOutput("second-line!", testshell.DefaultTimeout)
+
+	c.Send(`raw "\x1b[?2004hbracketed-on\x21"`)
+	c.ExpectOutput("bracketed-on!", testshell.DefaultTimeout)
+	waitFor(t, "bracketed paste on", s.bracketedPaste)
+
+	// The fake shell reads the bracket as part of the directive's name
+	resp, err := http.Post(ts.URL+"/paste", "application/json", strings.NewReader(`{"data":"echo pasted\n"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("POST /paste: status %d", resp.StatusCode)
+	}
+	c.ExpectOutput(`unknown directive "\x1b[200~echo"`, testshell.DefaultTimeout)
+
+	c.Send("")
+	c.Send(`raw "\x1b[?2004lbracketed-off\x21"`)
+	c.ExpectOutput("bracketed-off!", testshell.DefaultTimeout)
+	waitFor(t, "bracketed paste off", func() bool { return !s.bracketedPaste() })
+}
@@ -0,0 +1,267 @@
+package shellserver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	flagConfig  = Flags.String("config", "", "JSON config file defining shell profiles and settings reloadable with SIGHUP")
+	flagProfile = Flags.String("profile", "", "profile from -config the session's shell starts with")
+)
+
+// serverConfig is the -config file:
+//
+//	{"profiles": [
+//	  {"name": "work", "shell": ["zsh", "-l"], "cwd": "~/src/api",
+//	   "env": {"KUBECONFIG": "~/.kube/work"}, "rc": "~/.goshell/work/.zshrc"}
+//	 ],
+//	 "trusted_commands": ["^make( [a-z]+)*$"],
+//	 "widget_rate": 50, "widget_rate_window": "10s"}
+//
+// Settings other than profiles override the flag of the same name. All of
+// it can be reloaded without a restart; see reload.
+type serverConfig struct {
+	Profiles         []*shellProfile `json:"profiles"`
+	TrustedCommands  []string        `json:"trusted_commands,omitempty"`   // -widget-cmd-trusted
+	WidgetRate       *int            `json:"widget_rate,omitempty"`        // -widget-rate
+	WidgetRateWindow string          `json:"widget_rate_window,omitempty"` // -widget-rate-window
+}
+
+// shellProfile is a named way to start the session's shell, for switching
+// between project contexts.
+type shellProfile struct {
+	Name  string            `json:"name"`
+	Shell []string          `json:"shell,omitempty"` // argv; empty for the server's shell
+	Cwd   string            `json:"cwd,omitempty"`
+	Env   map[string]string `json:"env,omitempty"`
+
+	// RC is a startup file the shell sources in place of the usual one:
+	// zsh through ZDOTDIR, so it must be named .zshrc; bash through
+	// --rcfile, which bash ignores as a login shell; fish through
+	// --init-command.
+	RC string `json:"rc,omitempty"`
+}
+
+// loadConfig reads and validates the config file at path; "" is an empty
+// config. Paths in profiles may start with ~/.
+func loadConfig(path string) (*serverConfig, error) {
+	cfg := &serverConfig{}
+	if path == "" {
+		return cfg, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("config: %w", err)
+	}
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("config %s: %w", path, err)
+	}
+	seen := make(map[string]bool)
+	for i, p := range cfg.Profiles {
+		if p == nil || p.Name == "" {
+			return nil, fmt.Errorf("config %s: profile %d has no name", path, i)
+		}
+		if seen[p.Name] {
+			return nil, fmt.Errorf("config %s: profile %q defined twice", path, p.Name)
+		}
+		seen[p.Name] = true
+		if err := p.validate(); err != nil {
+			return nil, fmt.Errorf("config %s: profile %q: %w", path, p.Name, err)
+		}
+	}
+	if cfg.WidgetRateWindow != "" {
+		if d, err := time.ParseDuration(cfg.WidgetRateWindow); err != nil || d <= 0 {
+			return nil, fmt.Errorf("config %s: widget_rate_window %q is not a positive duration", path, cfg.WidgetRateWindow)
+		}
+	}
+	return cfg, nil
+}
+
+// profiles returns c's profiles; a nil config has none.
+func (c *serverConfig) profiles() []*shellProfile {
+	if c == nil {
+		return nil
+	}
+	return c.Profiles
+}
+
+// profile returns the profile called name, or nil.
+func (c *serverConfig) profile(name string) *shellProfile {
+	for _, p := range c.profiles() {
+		if p.Name == name {
+			return p
+		}
+	}
+	return nil
+}
+
+// validate expands p's paths and checks that its cwd and rc file exist,
+// so a typo fails at startup instead of on a restart.
+func (p *shellProfile) validate() error {
+	var err error
+	if p.Cwd != "" {
+		if p.Cwd, err = expandHome(p.Cwd); err != nil {
+			return err
+		}
+		info, err := os.Stat(p.Cwd)
+		if err != nil {
+			return fmt.Errorf("cwd: %w", err)
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("cwd %s is not a directory", p.Cwd)
+		}
+	}
+	for k, v := range p.Env {
+		if k == "" || strings.ContainsAny(k, "=\x00") {
+			return fmt.Errorf("invalid env name %q", k)
+		}
+		if strings.HasPrefix(v, "~/") {
+			if p.Env[k], err = expandHome(v); err != nil {
+				return err
+			}
+		}
+	}
+	if p.RC == "" {
+		return nil
+	}
+	if p.RC, err = expandHome(p.RC); err != nil {
+		return err
+	}
+	info, err := os.Stat(p.RC)
+	if err != nil {
+		return fmt.Errorf("rc: %w", err)
+	}
+	if info.IsDir() {
+		return fmt.Errorf("rc %s is a directory", p.RC)
+	}
+	switch shell := filepath.Base(p.shellName()); shell {
+	case "zsh":
+		if filepath.Base(p.RC) != ".zshrc" {
+			return fmt.Errorf("zsh reads rc through ZDOTDIR, so %s must be named .zshrc", p.RC)
+		}
+	case "bash":
+		for _, arg := range p.Shell[1:] {
+			if arg == "-l" || arg == "--login" {
+				return errors.New("bash ignores --rcfile as a login shell; drop -l")
+			}
+		}
+	case "fish":
+	default:
+		return fmt.Errorf("rc files need zsh, bash or fish, not %s", shell)
+	}
+	return nil
+}
+
+// shellName is the program p runs.
+func (p *shellProfile) shellName() string {
+	if len(p.Shell) > 0 {
+		return p.Shell[0]
+	}
+	return defaultShell
+}
+
+// expandHome replaces a leading ~/ with the home directory.
+func expandHome(path string) (string, error) {
+	rest, ok := strings.CutPrefix(path, "~/")
+	if !ok {
+		return path, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, rest), nil
+}
+
+// launch returns the argv, extra environment and working directory that
+// start p's shell, where base is the server's own shell argv. A nil p
+// starts base as it is.
+func (p *shellProfile) launch(base []string) (argv, env []string, dir string) {
+	if p == nil {
+		return base, nil, ""
+	}
+	argv = base
+	if len(p.Shell) > 0 {
+		argv = p.Shell
+	}
+	env = []string{"GOSHELL_PROFILE=" + p.Name}
+	names := make([]string, 0, len(p.Env))
+	for k := range p.Env {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		env = append(env, k+"="+p.Env[k])
+	}
+	if p.RC != "" {
+		switch filepath.Base(argv[0]) {
+		case "zsh":
+			env = append(env, "ZDOTDIR="+filepath.Dir(p.RC))
+		case "bash":
+			argv = append([]string{argv[0], "--rcfile", p.RC}, argv[1:]...)
+		case "fish":
+			argv = append([]string{argv[0], "--init-command", "source " + shellQuote(p.RC)}, argv[1:]...)
+		}
+	}
+	return argv, env, p.Cwd
+}
+
+// shellQuote single-quotes s for a POSIX shell or fish.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// profileName is the name of the profile the shell runs, or "".
+func (s *ShellServer) profileName() string {
+	s.ptyMu.Lock()
+	defer s.ptyMu.Unlock()
+	if s.profile == nil {
+		return ""
+	}
+	return s.profile.Name
+}
+
+// profileInfo is one entry of GET /profiles.
+type profileInfo struct {
+	*shellProfile
+	Active bool `json:"active"`
+}
+
+// handleProfiles serves GET /profiles, the profiles in config order.
+func (s *ShellServer) handleProfiles(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r, http.MethodGet)
+		return
+	}
+	active := s.profileName()
+	profiles := s.settings().config.profiles()
+	list := make([]profileInfo, 0, len(profiles))
+	for _, p := range profiles {
+		list = append(list, profileInfo{shellProfile: p, Active: p.Name == active})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// restartRequest is the optional body of POST /restart.
+type restartRequest struct {
+	Profile string `json:"profile"` // switch to this profile; "" keeps the current one
+}
+
+// decodeRestartRequest reads r's body, which may be empty.
+func decodeRestartRequest(r *http.Request) (restartRequest, error) {
+	var req restartRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		return req, err
+	}
+	return req, nil
+}
@@ -0,0 +1,149 @@
+package shellserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"shellserver/internal/testshell"
+)
+
+// writeConfig writes cfg as a -config file and points the flags at it,
+// starting sessions with profile.
+func writeConfig(t *testing.T, cfg string, profile string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(cfg), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	oldConfig, oldProfile := *flagConfig, *flagProfile
+	*flagConfig, *flagProfile = path, profile
+	t.Cleanup(func() { *flagConfig, *flagProfile = oldConfig, oldProfile })
+}
+
+func TestProfiles(t *testing.T) {
+	goDir, pyDir := t.TempDir(), t.TempDir()
+	cfg, _ := json.Marshal(map[string]any{"profiles": []map[string]any{
+		{"name": "go", "cwd": goDir, "env": map[string]string{"PROFILE_MARKER": "gopher"}},
+		{"name": "py", "cwd": pyDir, "env": map[string]string{"PROFILE_MARKER": "venv"}},
+	}})
+	writeConfig(t, string(cfg), "go")
+	s, ts := startFakeShellServer(t)
+
+	c := testshell.Dial(t, ts.URL, "")
+	if c.Ready["profile"] != "go" {
+		t.Errorf("ready profile = %v, want go", c.Ready["profile"])
+	}
+	c.Send("pwd")
+	c.ExpectOutput(goDir+"\r\n"+testshell.Prompt, testshell.DefaultTimeout)
+	c.Send("env PROFILE_MARKER")
+	c.ExpectOutput("gopher\r\n"+testshell.Prompt, testshell.DefaultTimeout)
+	c.Send("env GOSHELL_PROFILE")
+	c.ExpectOutput("go\r\n"+testshell.Prompt, testshell.DefaultTimeout)
+
+	resp, err := http.Post(ts.URL+"/restart", "application/json", strings.NewReader(`{"profile":"py"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("restart with profile: status %d", resp.StatusCode)
+	}
+
+	c = testshell.Dial(t, ts.URL, "")
+	if c.Ready["profile"] != "py" {
+		t.Errorf("ready profile after restart = %v, want py", c.Ready["profile"])
+	}
+	// The first client still holds input control
+	c.SendJSON(map[string]string{"kind": "take-control"})
+	// The new shell may echo input ahead of its first prompt, so match
+	// each output up to the prompt that follows it rather than by the
+	// line before it.
+	c.Send("pwd")
+	c.ExpectOutput(pyDir+"\r\n"+testshell.Prompt, testshell.DefaultTimeout)
+	c.Send("env PROFILE_MARKER")
+	c.ExpectOutput("venv\r\n"+testshell.Prompt, testshell.DefaultTimeout)
+
+	if st := getStatus(t, ts.URL); st.Profile != "py" {
+		t.Errorf("status profile = %q, want py", st.Profile)
+	}
+	if s.capabilities()["profiles"] != 2 {
+		t.Errorf("profiles capability = %v, want 2", s.capabilities()["profiles"])
+	}
+
+	resp, err = http.Get(ts.URL + "/profiles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var list []struct {
+		Name   string `json:"name"`
+		Cwd    string `json:"cwd"`
+		Active bool   `json:"active"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 || list[0].Name != "go" || list[0].Active || list[1].Name != "py" || !list[1].Active || list[1].Cwd != pyDir {
+		t.Errorf("GET /profiles = %+v", list)
+	}
+}
+
+func TestLoadConfigValidation(t *testing.T) {
+	dir := t.TempDir()
+	rc := filepath.Join(dir, "work.rc")
+	zshrc := filepath.Join(dir, ".zshrc")
+	for _, f := range []string{rc, zshrc} {
+		if err := os.WriteFile(f, nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name, config, wantErr string
+	}{
+		{"valid", `{"profiles":[{"name":"a","cwd":"` + dir + `","rc":"` + zshrc + `"},{"name":"b","shell":["bash"],"rc":"` + rc + `"}]}`, ""},
+		{"missing cwd", `{"profiles":[{"name":"a","cwd":"` + dir + `/nope"}]}`, "cwd"},
+		{"cwd is a file", `{"profiles":[{"name":"a","cwd":"` + rc + `"}]}`, "not a directory"},
+		{"missing rc", `{"profiles":[{"name":"a","rc":"` + dir + `/nope/.zshrc"}]}`, "rc"},
+		{"zsh rc name", `{"profiles":[{"name":"a","rc":"` + rc + `"}]}`, ".zshrc"},
+		{"bash login rc", `{"profiles":[{"name":"a","shell":["bash","-l"],"rc":"` + rc + `"}]}`, "login"},
+		{"unnamed", `{"profiles":[{"cwd":"` + dir + `"}]}`, "no name"},
+		{"duplicate", `{"profiles":[{"name":"a"},{"name":"a"}]}`, "twice"},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(t.TempDir(), "config.json")
+		if err := os.WriteFile(path, []byte(tt.config), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		_, err := loadConfig(path)
+		switch {
+		case tt.wantErr == "" && err != nil:
+			t.Errorf("%s: %v", tt.name, err)
+		case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+			t.Errorf("%s: error %v, want one mentioning %q", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestProfileLaunch(t *testing.T) {
+	base := []string{"zsh", "-l"}
+	p := &shellProfile{Name: "k8s", Cwd: "/srv", Env: map[string]string{"B": "2", "A": "1"}, RC: "/cfg/k8s/.zshrc"}
+	argv, env, dir := p.launch(base)
+	if strings.Join(argv, " ") != "zsh -l" || dir != "/srv" ||
+		strings.Join(env, " ") != "GOSHELL_PROFILE=k8s A=1 B=2 ZDOTDIR=/cfg/k8s" {
+		t.Errorf("zsh launch = %q %q %q", argv, env, dir)
+	}
+
+	p = &shellProfile{Name: "py", Shell: []string{"/bin/bash", "-i"}, RC: "/cfg/py.rc"}
+	if argv, _, _ := p.launch(base); strings.Join(argv, " ") != "/bin/bash --rcfile /cfg/py.rc -i" {
+		t.Errorf("bash argv = %q", argv)
+	}
+
+	if argv, env, dir := (*shellProfile)(nil).launch(base); strings.Join(argv, " ") != "zsh -l" || env != nil || dir != "" {
+		t.Errorf("no profile = %q %q %q", argv, env, dir)
+	}
+}
@@ -0,0 +1,79 @@
+package shellserver
+
+import (
+	"encoding/json"
+	"os"
+	"syscall"
+	"unsafe"
+
+	"github.com/gorilla/websocket"
+)
+
+// ptyMode is the part of the PTY's terminal settings a client needs to
+// decide whether it may echo keystrokes itself, to hide the round trip
+// on a slow link. monitorStatus keeps it current and broadcasts a
+// ptymode event whenever it changes.
+type ptyMode struct {
+	Canonical bool `json:"canonical"` // ICANON: the kernel assembles input lines
+	Echo      bool `json:"echo"`      // ECHO: the kernel echoes input
+	Shell     bool `json:"shell"`     // the shell, not a job, has the terminal
+	Predict   bool `json:"predict"`   // printable keys may be echoed locally
+}
+
+// newPTYMode works out Predict: keys echo as typed when the kernel echoes
+// a line being typed in canonical mode, or when the shell's line editor,
+// which turns both off and echoes for itself, has the terminal. Echo off
+// in canonical mode is a password prompt, and any other program that
+// turns canonical mode off draws its own screen.
+func newPTYMode(canonical, echo, shell bool) ptyMode {
+	return ptyMode{
+		Canonical: canonical,
+		Echo:      echo,
+		Shell:     shell,
+		Predict:   canonical && echo || !canonical && shell,
+	}
+}
+
+// getTermios reads the terminal settings of the PTY. Once f is closed it
+// fails with an error wrapping os.ErrClosed.
+func getTermios(f *os.File) (syscall.Termios, error) {
+	var t syscall.Termios
+	err := ptyIoctl(f, ioctlGetTermios, unsafe.Pointer(&t))
+	return t, err
+}
+
+// readPTYMode reads f's mode; shell says whether the shell is in the
+// foreground.
+func readPTYMode(f *os.File, shell bool) (ptyMode, error) {
+	t, err := getTermios(f)
+	if err != nil {
+		return ptyMode{}, err
+	}
+	return newPTYMode(t.Lflag&syscall.ICANON != 0, t.Lflag&syscall.ECHO != 0, shell), nil
+}
+
+// currentPTYMode returns the PTY mode as last read.
+func (s *ShellServer) currentPTYMode() ptyMode {
+	s.ptyModeMu.Lock()
+	defer s.ptyModeMu.Unlock()
+	return s.ptyMode
+}
+
+// setPTYMode records mode and, if it changed, broadcasts it.
+func (s *ShellServer) setPTYMode(mode ptyMode) {
+	s.ptyModeMu.Lock()
+	changed := mode != s.ptyMode
+	s.ptyMode = mode
+	s.ptyModeMu.Unlock()
+	if !changed {
+		return
+	}
+	data, _ := json.Marshal(map[string]any{
+		"kind":      "ptymode",
+		"canonical": mode.Canonical,
+		"echo":      mode.Echo,
+		"shell":     mode.Shell,
+		"predict":   mode.Predict,
+	})
+	s.broadcastMessage(websocket.TextMessage, data)
+}
@@ -0,0 +1,6 @@
+package shellserver
+
+import "syscall"
+
+// ioctlGetTermios is tcgetattr's ioctl.
+const ioctlGetTermios = syscall.TCGETS
@@ -0,0 +1,8 @@
+//go:build !linux
+
+package shellserver
+
+import "syscall"
+
+// ioctlGetTermios is tcgetattr's ioctl on the BSDs and macOS.
+const ioctlGetTermios = syscall.TIOCGETA
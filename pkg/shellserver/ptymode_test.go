@@ -0,0 +1,53 @@
+package shellserver
+
+import (
+	"testing"
+
+	"shellserver/internal/testshell"
+)
+
+func TestPTYModePredict(t *testing.T) {
+	tests := []struct {
+		name                   string
+		canonical, echo, shell bool
+		want                   bool
+	}{
+		{"cooked line, kernel echo", true, true, true, true},
+		{"cat reading a line", true, true, false, true},
+		{"password prompt", true, false, false, false},
+		{"shell read -s", true, false, true, false},
+		{"shell line editor", false, false, true, true},
+		{"full-screen program", false, false, false, false},
+		{"raw program with echo", false, true, false, false},
+	}
+	for _, tt := range tests {
+		if got := newPTYMode(tt.canonical, tt.echo, tt.shell).Predict; got != tt.want {
+			t.Errorf("%s: Predict = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestPTYModeEvents(t *testing.T) {
+	_, ts := startFakeShellServer(t)
+	c := testshell.Dial(t, ts.URL, "")
+
+	mode, _ := c.Ready["pty_mode"].(map[string]any)
+	if mode["canonical"] != true || mode["echo"] != true || mode["predict"] != true {
+		t.Fatalf("ready pty_mode = %v, want canonical echo", c.Ready["pty_mode"])
+	}
+	if caps, _ := c.Ready["capabilities"].(map[string]any); caps["predictive-echo"] != true {
+		t.Errorf("predictive-echo not advertised: %v", caps)
+	}
+
+	c.Send("stty -echo")
+	ev := c.ExpectEvent("ptymode", testshell.DefaultTimeout)
+	if ev["echo"] != false || ev["canonical"] != true || ev["predict"] != false {
+		t.Errorf("after stty -echo: %v", ev)
+	}
+
+	c.Send("stty echo")
+	ev = c.ExpectEvent("ptymode", testshell.DefaultTimeout)
+	if ev["echo"] != true || ev["predict"] != true {
+		t.Errorf("after stty echo: %v", ev)
+	}
+}
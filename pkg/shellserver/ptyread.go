@@ -0,0 +1,80 @@
+package shellserver
+
+import (
+	"errors"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ptyReadError is how streamPTY reacts to an error reading the PTY.
+type ptyReadError int
+
+const (
+	ptyReadRetry  ptyReadError = iota // transient: read again after a pause
+	ptyReadEOF                        // the shell is gone
+	ptyReadClosed                     // the PTY was closed by a restart or shutdown
+	ptyReadFatal
+)
+
+func (c ptyReadError) String() string {
+	switch c {
+	case ptyReadRetry:
+		return "transient"
+	case ptyReadEOF:
+		return "eof"
+	case ptyReadClosed:
+		return "closed"
+	default:
+		return "fatal"
+	}
+}
+
+// Backoff between retries of transient PTY read errors. A successful read
+// resets it; after maxPTYReadRetries failures in a row streaming gives up.
+const (
+	ptyRetryMinBackoff = 10 * time.Millisecond
+	ptyRetryMaxBackoff = time.Second
+	maxPTYReadRetries  = 20
+)
+
+// classifyPTYReadError sorts a PTY read error. On Linux the master reads
+// EIO once every slave descriptor is closed, which is how a shell exit
+// usually shows up, so EIO only counts as EOF once shellExited agrees;
+// before that it is retried like EINTR and EAGAIN.
+func classifyPTYReadError(err error, shellExited func() bool) ptyReadError {
+	switch {
+	case errors.Is(err, io.EOF):
+		return ptyReadEOF
+	case errors.Is(err, os.ErrClosed):
+		return ptyReadClosed
+	case errors.Is(err, syscall.EINTR), errors.Is(err, syscall.EAGAIN):
+		return ptyReadRetry
+	case errors.Is(err, syscall.EIO):
+		if shellExited() {
+			return ptyReadEOF
+		}
+		return ptyReadRetry
+	}
+	return ptyReadFatal
+}
+
+// processRunning reports whether pid is alive. A zombie, exited but not
+// yet reaped, doesn't count.
+func processRunning(pid int) bool {
+	if syscall.Kill(pid, 0) == syscall.ESRCH {
+		return false
+	}
+	stat, err := os.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+	if err != nil {
+		// no procfs; the kill check is all there is
+		return true
+	}
+	// The state follows the parenthesised command name, which may itself
+	// contain spaces and parentheses.
+	fields := strings.Fields(string(stat[strings.LastIndexByte(string(stat), ')')+1:]))
+	return len(fields) > 0 && fields[0] != "Z"
+}
@@ -0,0 +1,132 @@
+package shellserver
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/gorilla/websocket"
+
+	"shellserver/internal/store"
+	"shellserver/internal/testshell"
+)
+
+func TestClassifyPTYReadError(t *testing.T) {
+	pathErr := func(err error) error { return &os.PathError{Op: "read", Path: "/dev/ptmx", Err: err} }
+	tests := []struct {
+		err    error
+		exited bool
+		want   ptyReadError
+	}{
+		{io.EOF, false, ptyReadEOF},
+		{pathErr(os.ErrClosed), false, ptyReadClosed},
+		{pathErr(syscall.EINTR), false, ptyReadRetry},
+		{pathErr(syscall.EAGAIN), false, ptyReadRetry},
+		{pathErr(syscall.EIO), false, ptyReadRetry},
+		{pathErr(syscall.EIO), true, ptyReadEOF},
+		{pathErr(syscall.EBADF), false, ptyReadFatal},
+		{errors.New("mystery"), true, ptyReadFatal},
+	}
+	for _, tt := range tests {
+		got := classifyPTYReadError(tt.err, func() bool { return tt.exited })
+		if got != tt.want {
+			t.Errorf("classify(%v, exited=%v) = %v, want %v", tt.err, tt.exited, got, tt.want)
+		}
+	}
+}
+
+func newPumpTestServer() *ShellServer {
+	return &ShellServer{
+		clients:         make(map[*websocket.Conn]*client),
+		store:           store.NewMemory(),
+		htmlKeys:        make(map[string]int),
+		widgetVersions:  make(map[int]int),
+		widgetPatches:   make(map[int]*widgetPatch),
+		widgetIndex:     make(map[int]*widgetText),
+		scrollbackBytes: 64 * 1024,
+	}
+}
+
+func TestPumpPTYRetriesTransientErrors(t *testing.T) {
+	s := newPumpTestServer()
+	exitChecks := 0
+	shellExited := func() bool {
+		exitChecks++
+		return exitChecks > 1
+	}
+	r := testshell.NewReader(
+		testshell.Read{Data: "hello", Err: syscall.EINTR},
+		testshell.Read{Data: " world", Err: syscall.EAGAIN},
+		testshell.Read{Err: syscall.EIO}, // shell still running
+		testshell.Read{Data: "!"},
+		testshell.Read{Err: syscall.EIO}, // shell gone: EOF
+		testshell.Read{Data: "never read"},
+	)
+
+	s.pumpPTY(r, shellExited)
+
+	if got := string(s.buffer); got != "hello world!" {
+		t.Errorf("buffered output = %q, want every chunk around the errors", got)
+	}
+	if got := s.ptyReadRetries.Load(); got != 3 {
+		t.Errorf("retries = %d, want 3", got)
+	}
+	if r.Remaining() != 1 {
+		t.Errorf("%d reads left, want pumping to stop at the EOF", r.Remaining())
+	}
+}
+
+func TestPumpPTYStops(t *testing.T) {
+	for _, err := range []error{io.EOF, os.ErrClosed, syscall.EBADF} {
+		t.Run(fmt.Sprint(err), func(t *testing.T) {
+			s := newPumpTestServer()
+			r := testshell.NewReader(
+				testshell.Read{Data: "last", Err: err},
+				testshell.Read{Data: "never read"},
+			)
+			s.pumpPTY(r, func() bool { return false })
+			if string(s.buffer) != "last" || r.Remaining() != 1 || s.ptyReadRetries.Load() != 0 {
+				t.Errorf("buffer %q, %d reads left, %d retries; want a stop after the first read",
+					s.buffer, r.Remaining(), s.ptyReadRetries.Load())
+			}
+		})
+	}
+}
+
+func TestShellExitReportedOverWebsocket(t *testing.T) {
+	_, ts := startFakeShellServer(t)
+	c := testshell.Dial(t, ts.URL, "")
+
+	c.Send("exit 0")
+	if ev := c.ExpectEvent("status", testshell.DefaultTimeout); ev["state"] != "exited" {
+		t.Errorf("status = %v, want exited once the shell is gone", ev["state"])
+	}
+}
+
+func TestAppendToBufferTrimsAtSequenceBoundary(t *testing.T) {
+	s := newPumpTestServer()
+	// Enough to take the buffer past the cap and its slack
+	slack := bytes.Repeat([]byte("p"), 64*1024/trimSlack)
+	s.appendToBuffer(slack, nil, true)
+	s.appendToBuffer([]byte("\x1b[38;5;208m"), nil, true)
+	s.appendToBuffer(bytes.Repeat([]byte("x"), 64*1024-5), nil, true)
+
+	// The cap falls inside the color sequence, which goes whole
+	if len(s.buffer) != 64*1024-5 || s.buffer[0] != 'x' {
+		t.Errorf("buffer starts %q (%d bytes), want the text after the sequence", s.buffer[:12], len(s.buffer))
+	}
+
+	// A line starting soon after the cap is cut at instead
+	s.buffer = nil
+	s.appendToBuffer(slack, nil, true)
+	s.appendToBuffer([]byte("line one\n"), nil, true)
+	s.appendToBuffer([]byte("line two\n"), nil, true)
+	s.appendToBuffer(bytes.Repeat([]byte("y"), 64*1024-12), nil, true)
+	if !bytes.HasPrefix(s.buffer, []byte("line two\ny")) {
+		t.Errorf("buffer starts %q, want the first whole line", s.buffer[:12])
+	}
+}
@@ -0,0 +1,142 @@
+package shellserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"shellserver/pkg/protocol"
+)
+
+// queuedCmd is a widget command waiting for the shell's prompt.
+type queuedCmd struct {
+	ID     string    `json:"id"`
+	Cmd    string    `json:"cmd"`
+	Queued time.Time `json:"queued"`
+}
+
+// cmdQueue holds widget commands that arrived while a command had the
+// terminal, so they aren't typed into its stdin. monitorStatus writes
+// them, one per tick, while the shell waits at its prompt.
+type cmdQueue struct {
+	mu    sync.Mutex
+	cmds  []queuedCmd // oldest first
+	count int         // commands ever queued, for IDs
+}
+
+func (q *cmdQueue) push(cmd string, now time.Time) queuedCmd {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.count++
+	c := queuedCmd{ID: fmt.Sprintf("cmd-%d", q.count), Cmd: cmd, Queued: now}
+	q.cmds = append(q.cmds, c)
+	return c
+}
+
+// pop takes the oldest command off the queue.
+func (q *cmdQueue) pop() (queuedCmd, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.cmds) == 0 {
+		return queuedCmd{}, false
+	}
+	c := q.cmds[0]
+	q.cmds = q.cmds[1:]
+	return c, true
+}
+
+// cancel removes the command with the given ID, reporting whether it was
+// still queued.
+func (q *cmdQueue) cancel(id string) (queuedCmd, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for i, c := range q.cmds {
+		if c.ID == id {
+			q.cmds = append(q.cmds[:i:i], q.cmds[i+1:]...)
+			return c, true
+		}
+	}
+	return queuedCmd{}, false
+}
+
+// list returns the queued commands, oldest first.
+func (q *cmdQueue) list() []queuedCmd {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return append([]queuedCmd{}, q.cmds...)
+}
+
+// shellBusy reports whether a command other than the shell holds the
+// terminal, as monitorStatus tells running from waiting. When that can't
+// be read, the shell isn't taken to be busy.
+func (s *ShellServer) shellBusy() bool {
+	s.ptyMu.Lock()
+	ptyFile, shellPGID := s.ptyFile, s.shellPGID
+	s.ptyMu.Unlock()
+	if ptyFile == nil {
+		return false
+	}
+	pgid, err := getForegroundPGID(ptyFile)
+	return err == nil && pgid != shellPGID
+}
+
+// queueWidgetCommand holds cmd until the shell is back at its prompt.
+func (s *ShellServer) queueWidgetCommand(cmd string) queuedCmd {
+	c := s.cmdQueue.push(cmd, time.Now())
+	log.Printf("widget command %s queued until the prompt: %q", c.ID, cmd)
+	s.broadcastQueue("queued", c)
+	return c
+}
+
+// runQueuedCommand writes the oldest queued command to the shell. The
+// caller has just seen the shell waiting at its prompt.
+func (s *ShellServer) runQueuedCommand() {
+	c, ok := s.cmdQueue.pop()
+	if !ok {
+		return
+	}
+	s.broadcastQueue("run", c)
+	if err := s.runWidgetCommand(c.Cmd); err != nil {
+		log.Printf("queued widget command %s: %v", c.ID, err)
+	}
+}
+
+// broadcastQueue tells clients what happened to a queued command:
+// {"kind":"queue","action":"queued|run|cancelled","id","cmd"}.
+func (s *ShellServer) broadcastQueue(action string, c queuedCmd) {
+	data, _ := json.Marshal(map[string]string{"kind": "queue", "action": action, "id": c.ID, "cmd": c.Cmd})
+	s.broadcastMessage(websocket.TextMessage, data)
+}
+
+// handleQueue serves GET /queue, the commands waiting for the prompt, and
+// DELETE /queue/{id}, which cancels one.
+func (s *ShellServer) handleQueue(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/queue"), "/")
+	if id == "" {
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w, r, http.MethodGet)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(s.cmdQueue.list())
+		return
+	}
+	if r.Method != http.MethodDelete {
+		methodNotAllowed(w, r, http.MethodDelete)
+		return
+	}
+	c, ok := s.cmdQueue.cancel(id)
+	if !ok {
+		respondError(w, r, http.StatusNotFound, protocol.ErrNotQueued, fmt.Sprintf("no queued command %q", id))
+		return
+	}
+	log.Printf("queued widget command %s cancelled", c.ID)
+	s.broadcastQueue("cancelled", c)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,77 @@
+package shellserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"shellserver/internal/testshell"
+	"shellserver/pkg/protocol"
+)
+
+func postWidgetCmd(t *testing.T, url, cmd string) (int, map[string]any) {
+	t.Helper()
+	body, _ := json.Marshal(WidgetActionRequest{Type: "shell", Cmd: cmd})
+	resp, err := http.Post(url, "application/json", strings.NewReader(string(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var got map[string]any
+	json.NewDecoder(resp.Body).Decode(&got)
+	return resp.StatusCode, got
+}
+
+func deleteQueued(t *testing.T, url string) int {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodDelete, url, nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestWidgetActionWhileRunning(t *testing.T) {
+	s, ts := startFakeShellServer(t)
+	s.confirmWidgetCmds = false
+	allowWidgetCmds(s, ``)
+	c := testshell.Dial(t, ts.URL, "")
+
+	c.Send("fg 5s")
+	waitFor(t, "foreground command", s.shellBusy)
+
+	action := ts.URL + "/widget/x/action"
+	code, body := postWidgetCmd(t, action, "echo too-soon")
+	if e, _ := body["error"].(map[string]any); code != http.StatusConflict || e["code"] != string(protocol.ErrShellBusy) {
+		t.Fatalf("action during a command: %d %v, want 409 %s", code, body, protocol.ErrShellBusy)
+	}
+
+	code, body = postWidgetCmd(t, action+"?queue=1", "echo queued-ran")
+	first, _ := body["queued"].(string)
+	if code != http.StatusAccepted || first == "" {
+		t.Fatalf("queued action: %d %v, want 202 with an ID", code, body)
+	}
+	c.ExpectEvent("queue", testshell.DefaultTimeout)
+	_, body = postWidgetCmd(t, action+"?queue=1", "echo cancelled")
+	second, _ := body["queued"].(string)
+
+	queued := getStatus(t, ts.URL).Queued
+	if len(queued) != 2 || queued[0].ID != first || queued[0].Cmd != "echo queued-ran" || queued[1].ID != second {
+		t.Fatalf("/status queued_commands = %+v", queued)
+	}
+	if code := deleteQueued(t, ts.URL+"/queue/"+second); code != http.StatusNoContent {
+		t.Fatalf("cancel: %d, want 204", code)
+	}
+	if code := deleteQueued(t, ts.URL+"/queue/"+second); code != http.StatusNotFound {
+		t.Errorf("second cancel: %d, want 404", code)
+	}
+
+	// The command finishes, and the queued one is typed at the prompt
+	c.ExpectOutput("\r\nqueued-ran\r\n", 2*testshell.DefaultTimeout)
+	if queued := getStatus(t, ts.URL).Queued; len(queued) != 0 {
+		t.Errorf("queued_commands after the prompt = %+v", queued)
+	}
+}
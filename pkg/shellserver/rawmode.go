@@ -0,0 +1,68 @@
+package shellserver
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/websocket"
+)
+
+// Notices written into the stream when raw mode is switched, so the
+// transcript shows where interpretation stopped and resumed.
+const (
+	rawModeOnNotice  = "\r\n\x1b[33m[goshell] raw mode on: PTY output is passed through uninterpreted\x1b[0m\r\n"
+	rawModeOffNotice = "\r\n\x1b[33m[goshell] raw mode off: widgets and annotations resume\x1b[0m\r\n"
+)
+
+// RawModeRequest models POST /rawmode payloads.
+type RawModeRequest struct {
+	Enabled bool `json:"enabled"`
+}
+
+// setRawMode switches raw mode, an escape hatch that turns off all stream
+// interpretation: while it is on, PTY output goes to the buffer and
+// clients byte for byte, with no widget extraction, OSC tracking or
+// annotation. It reports whether the mode changed.
+//
+// The switch happens under htmlBufMu, which pumpPTY holds while deciding
+// how to treat a read, so every read is handled entirely in one mode.
+// Switching on flushes a partially received widget block as the bytes it
+// came in as, after a notice; pumpPTY resets its scanners on the way back.
+func (s *ShellServer) setRawMode(enabled bool) bool {
+	s.htmlBufMu.Lock()
+	if s.rawMode.Load() == enabled {
+		s.htmlBufMu.Unlock()
+		return false
+	}
+	s.rawMode.Store(enabled)
+	pending := append(s.htmlBuffer, s.clipboard.reset()...)
+	s.htmlBuffer = nil
+	s.htmlBufMu.Unlock()
+
+	out := []byte(rawModeOffNotice)
+	if enabled {
+		out = append([]byte(rawModeOnNotice), pending...)
+	}
+	s.output(out, true, nil)
+
+	log.Printf("raw mode enabled=%v", enabled)
+	data, _ := json.Marshal(map[string]any{"kind": "rawmode", "enabled": enabled})
+	s.broadcastMessage(websocket.TextMessage, data)
+	return true
+}
+
+// handleRawMode serves POST /rawmode {"enabled":bool}.
+func (s *ShellServer) handleRawMode(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, r, http.MethodPost)
+		return
+	}
+	var req RawModeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		invalidJSON(w, r, err)
+		return
+	}
+	s.setRawMode(req.Enabled)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,118 @@
+package shellserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"shellserver/internal/testshell"
+)
+
+func postRawMode(t *testing.T, url string, enabled bool) {
+	t.Helper()
+	body, _ := json.Marshal(RawModeRequest{Enabled: enabled})
+	resp, err := http.Post(url+"/rawmode", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("POST /rawmode: %s", resp.Status)
+	}
+}
+
+func getStatus(t *testing.T, url string) sessionStatus {
+	t.Helper()
+	resp, err := http.Get(url + "/status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var st sessionStatus
+	if err := json.NewDecoder(resp.Body).Decode(&st); err != nil {
+		t.Fatal(err)
+	}
+	return st
+}
+
+func TestRawModeBypassesStreamProcessing(t *testing.T) {
+	s, ts := startFakeShellServer(t)
+	c := testshell.Dial(t, ts.URL, "")
+	start, end := testshell.Markers["html-start"], testshell.Markers["html-end"]
+
+	// A widget block is half received when raw mode goes on
+	c.Send("mark html-start")
+	c.Send("echo <b>partial</b>")
+	deadline := time.Now().Add(testshell.DefaultTimeout)
+	for {
+		s.htmlBufMu.Lock()
+		held := strings.Contains(string(s.htmlBuffer), "<b>partial</b>")
+		s.htmlBufMu.Unlock()
+		if held {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("partial widget block never buffered")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	postRawMode(t, ts.URL, true)
+	if ev := c.ExpectEvent("rawmode", testshell.DefaultTimeout); ev["enabled"] != true {
+		t.Errorf("rawmode event = %v, want enabled", ev)
+	}
+	// the held bytes are flushed as they came in, after the notice
+	c.ExpectOutput(rawModeOnNotice+start, testshell.DefaultTimeout)
+	c.ExpectOutput("<b>partial</b>", testshell.DefaultTimeout)
+	if !getStatus(t, ts.URL).RawMode {
+		t.Error("/status doesn't report raw mode")
+	}
+
+	// Markers now pass through untouched
+	c.Send("mark html-end")
+	c.ExpectOutput(end, testshell.DefaultTimeout)
+	c.Send("mark html-start")
+	c.ExpectOutput(start, testshell.DefaultTimeout)
+	c.Send("mark html-end")
+	c.ExpectOutput(end, testshell.DefaultTimeout)
+	if ids := s.widgetIDs(); len(ids) != 0 {
+		t.Errorf("widgets %v stored in raw mode", ids)
+	}
+
+	// and are interpreted again once it is off
+	c.SendJSON(map[string]any{"kind": "rawmode", "enabled": false})
+	c.ExpectOutput(rawModeOffNotice, testshell.DefaultTimeout)
+	c.Send("mark html-start")
+	c.Send("echo <b>whole</b>")
+	c.Send("mark html-end")
+	c.ExpectEvent("html", testshell.DefaultTimeout)
+	if getStatus(t, ts.URL).RawMode {
+		t.Error("/status still reports raw mode")
+	}
+
+	// A restarted shell starts interpreted
+	postRawMode(t, ts.URL, true)
+	if err := s.restart(); err != nil {
+		t.Fatal(err)
+	}
+	if s.rawMode.Load() {
+		t.Error("raw mode survived a restart")
+	}
+}
+
+func TestRawModeObserverIgnored(t *testing.T) {
+	s, ts := startFakeShellServer(t)
+	observer := testshell.Dial(t, ts.URL, "?role=observer")
+	observer.SendJSON(map[string]any{"kind": "rawmode", "enabled": true})
+
+	// control messages are handled in order, so once "seen" is answered
+	// the rawmode request has been too
+	observer.SendJSON(map[string]any{"kind": "seen"})
+	observer.ExpectEvent("activity", testshell.DefaultTimeout)
+	if s.rawMode.Load() {
+		t.Error("observer switched raw mode on")
+	}
+}
@@ -0,0 +1,192 @@
+package shellserver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"shellserver/internal/cast"
+	"shellserver/pkg/protocol"
+)
+
+var flagRecord = Flags.String("record", "", "record the session as an asciinema v2 cast file at this path, started afresh when the shell is restarted")
+
+// recorder writes the session to an asciinema cast file: output as "o"
+// events, input as "i" and resizes as "r". A write that fails stops the
+// recording, never the session.
+type recorder struct {
+	mu      sync.Mutex
+	path    string // the file being written; "" when not recording
+	f       *os.File
+	w       *cast.Writer
+	start   time.Time
+	pending []byte // the start of a UTF-8 sequence cut off by the last read
+}
+
+// open starts a fresh recording at path for a terminal of rows by cols.
+// A previous recording at path is kept as <name>.1.cast.
+func (rec *recorder) open(path string, rows, cols int) error {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	rec.closeLocked()
+	if _, err := os.Stat(path); err == nil {
+		os.Rename(path, strings.TrimSuffix(path, ".cast")+".1.cast")
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	start := time.Now()
+	w, err := cast.NewWriter(f, cast.Header{Width: cols, Height: rows, Timestamp: start.Unix(), Title: "goshell"})
+	if err == nil {
+		err = w.Flush()
+	}
+	if err != nil {
+		f.Close()
+		return err
+	}
+	rec.path, rec.f, rec.w, rec.start, rec.pending = path, f, w, start, nil
+	return nil
+}
+
+// active returns the file being recorded to, or "".
+func (rec *recorder) active() string {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	return rec.path
+}
+
+// output records PTY output. A read can end partway through a UTF-8
+// sequence, which a cast event, being a JSON string, can't hold; the
+// start of it waits for the next read.
+func (rec *recorder) output(data []byte) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if rec.w == nil {
+		return
+	}
+	data = append(rec.pending, data...)
+	cut := len(data)
+	for i := len(data) - 1; i >= 0 && i >= len(data)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(data[i]) {
+			if !utf8.FullRune(data[i:]) {
+				cut = i
+			}
+			break
+		}
+	}
+	rec.pending = append([]byte(nil), data[cut:]...)
+	if cut > 0 {
+		rec.writeLocked(cast.Output, string(data[:cut]))
+	}
+}
+
+// input records what was written to the shell.
+func (rec *recorder) input(data []byte) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if rec.w != nil {
+		rec.writeLocked(cast.Input, string(data))
+	}
+}
+
+// resize records the terminal's new size.
+func (rec *recorder) resize(rows, cols int) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if rec.w != nil {
+		rec.writeLocked(cast.Resize, fmt.Sprintf("%dx%d", cols, rows))
+	}
+}
+
+// writeLocked appends an event and flushes it, so the file is whole up to
+// the last event if the server dies. On an error it gives up the
+// recording. rec.mu must be held.
+func (rec *recorder) writeLocked(typ, data string) {
+	err := rec.w.WriteEvent(cast.Event{Time: time.Since(rec.start).Seconds(), Type: typ, Data: data})
+	if err == nil {
+		err = rec.w.Flush()
+	}
+	if err != nil {
+		log.Printf("record %s: %v; recording stopped", rec.path, err)
+		rec.closeLocked()
+	}
+}
+
+// close flushes and ends the recording, returning the file it was
+// written to, or "" if there was none.
+func (rec *recorder) close() string {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	path := rec.path
+	rec.closeLocked()
+	return path
+}
+
+func (rec *recorder) closeLocked() {
+	if rec.f == nil {
+		return
+	}
+	if err := rec.w.Flush(); err != nil {
+		log.Printf("record %s: %v", rec.path, err)
+	}
+	rec.f.Close()
+	rec.path, rec.f, rec.w, rec.pending = "", nil, nil, nil
+}
+
+// startRecording records the session to -record, or else to a new file
+// in -record-dir, returning the file.
+func (s *ShellServer) startRecording() (string, error) {
+	path := s.options.Record
+	if path == "" {
+		if s.recordDir == "" {
+			return "", errors.New("no -record path or -record-dir to record into")
+		}
+		path = filepath.Join(s.recordDir, "goshell-"+time.Now().Format("20060102-150405")+".cast")
+	}
+	rows, cols := s.ptySize()
+	if err := s.recorder.open(path, rows, cols); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// handleRecord serves POST /record/start and /record/stop, which turn
+// recording on and off and answer {"path":...,"recording":bool}.
+func (s *ShellServer) handleRecord(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, r, http.MethodPost)
+		return
+	}
+	var path string
+	switch strings.TrimPrefix(r.URL.Path, "/record/") {
+	case "start":
+		if current := s.recorder.active(); current != "" {
+			path = current
+			break
+		}
+		var err error
+		if path, err = s.startRecording(); err != nil {
+			respondError(w, r, http.StatusInternalServerError, protocol.ErrRecordFailed, "can't record: "+err.Error())
+			return
+		}
+	case "stop":
+		if path = s.recorder.close(); path == "" {
+			respondError(w, r, http.StatusConflict, protocol.ErrNotRecording, "the session isn't being recorded")
+			return
+		}
+	default:
+		notFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"path": path, "recording": s.recorder.active() != ""})
+}
@@ -0,0 +1,145 @@
+package shellserver
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"shellserver/internal/cast"
+	"shellserver/internal/testshell"
+)
+
+// readCast returns a cast file's header and events.
+func readCast(t *testing.T, path string) (cast.Header, []cast.Event) {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	cr, err := cast.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var events []cast.Event
+	for {
+		ev, err := cr.Next()
+		if err == io.EOF {
+			return cr.Header, events
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, ev)
+	}
+}
+
+func TestRecorderWritesCast(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "session.cast")
+	var rec recorder
+	if err := rec.open(path, 30, 100); err != nil {
+		t.Fatal(err)
+	}
+	rec.output([]byte("box \xe2\x94"))
+	rec.output([]byte("\x80 done"))
+	rec.input([]byte("ls\n"))
+	rec.resize(40, 120)
+	if got := rec.close(); got != path {
+		t.Errorf("close() = %q, want %q", got, path)
+	}
+	rec.output([]byte("after close"))
+
+	h, events := readCast(t, path)
+	if h.Width != 100 || h.Height != 30 || h.Timestamp == 0 {
+		t.Errorf("header = %+v, want 100x30 with a timestamp", h)
+	}
+	want := []cast.Event{
+		{Type: cast.Output, Data: "box "},
+		{Type: cast.Output, Data: "─ done"},
+		{Type: cast.Input, Data: "ls\n"},
+		{Type: cast.Resize, Data: "120x40"},
+	}
+	if len(events) != len(want) {
+		t.Fatalf("events = %+v, want %d", events, len(want))
+	}
+	for i, ev := range events {
+		if ev.Type != want[i].Type || ev.Data != want[i].Data {
+			t.Errorf("event %d = %+v, want %+v", i, ev, want[i])
+		}
+		if i > 0 && ev.Time < events[i-1].Time {
+			t.Errorf("event %d goes back in time", i)
+		}
+	}
+
+	// A fresh recording keeps the previous one
+	if err := rec.open(path, 24, 80); err != nil {
+		t.Fatal(err)
+	}
+	rec.close()
+	if _, events := readCast(t, strings.TrimSuffix(path, ".cast")+".1.cast"); len(events) != 4 {
+		t.Errorf("previous recording has %d events, want 4", len(events))
+	}
+}
+
+func TestRecorderStopsOnWriteError(t *testing.T) {
+	var rec recorder
+	if err := rec.open(filepath.Join(t.TempDir(), "s.cast"), 24, 80); err != nil {
+		t.Fatal(err)
+	}
+	rec.f.Close() // the file becomes unwritable
+	rec.output([]byte("lost"))
+	if rec.active() != "" {
+		t.Error("still recording after a failed write")
+	}
+	rec.output([]byte("ignored"))
+	rec.input([]byte("ignored"))
+}
+
+func TestRecordEndpoints(t *testing.T) {
+	dir, url := startRecordingServer(t)
+	post := func(path string) (int, map[string]any) {
+		t.Helper()
+		resp, err := http.Post(url+path, "application/json", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var body map[string]any
+		json.NewDecoder(resp.Body).Decode(&body)
+		return resp.StatusCode, body
+	}
+
+	code, started := post("/record/start")
+	path, _ := started["path"].(string)
+	if code != http.StatusOK || filepath.Dir(path) != dir || started["recording"] != true {
+		t.Fatalf("POST /record/start = %d %v, want a file in -record-dir", code, started)
+	}
+	c := testshell.Dial(t, url, "")
+	c.Send("echo recorded")
+	c.ExpectOutput("\r\nrecorded\r\n", testshell.DefaultTimeout)
+
+	// A restart starts the recording afresh, keeping what came before
+	if code, _ := post("/restart"); code != http.StatusOK {
+		t.Fatalf("POST /restart = %d", code)
+	}
+	if code, stopped := post("/record/stop"); code != http.StatusOK || stopped["path"] != path || stopped["recording"] != false {
+		t.Errorf("POST /record/stop = %d %v", code, stopped)
+	}
+	if code, _ := post("/record/stop"); code != http.StatusConflict {
+		t.Errorf("stopping twice = %d, want 409", code)
+	}
+
+	_, events := readCast(t, strings.TrimSuffix(path, ".cast")+".1.cast")
+	var in, out bool
+	for _, ev := range events {
+		in = in || ev.Type == cast.Input && ev.Data == "echo recorded\n"
+		out = out || ev.Type == cast.Output && strings.Contains(ev.Data, "recorded\r\n")
+	}
+	if !in || !out {
+		t.Errorf("recording has input %v, output %v; events %+v", in, out, events)
+	}
+}
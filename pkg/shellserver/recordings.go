@@ -0,0 +1,303 @@
+package shellserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"shellserver/internal/ansi"
+	"shellserver/internal/cast"
+	"shellserver/pkg/protocol"
+)
+
+var flagRecordDir = Flags.String("record-dir", "", "directory of asciinema recordings (*.cast) to list and search at /recordings")
+
+const (
+	defaultSearchLimit = 50
+	maxSearchLimit     = 500
+
+	// maxSearchLine bounds the text held for one output line; longer
+	// lines are searched in pieces of this size.
+	maxSearchLine = 4096
+)
+
+// recordingInfo describes a cast file in /recordings.
+type recordingInfo struct {
+	ID       string     `json:"id"`
+	Start    *time.Time `json:"start,omitempty"`
+	Duration float64    `json:"duration"` // seconds
+	Size     int64      `json:"size"`
+	Width    int        `json:"width"`
+	Height   int        `json:"height"`
+	Title    string     `json:"title,omitempty"`
+}
+
+// recordingMatch is one line of output that matched a search.
+type recordingMatch struct {
+	Time float64    `json:"time"` // seconds into the recording, for seeking the player
+	At   *time.Time `json:"at,omitempty"`
+	Line string     `json:"line"`
+}
+
+// recordingSearch is the response of /recordings/{id}/search.
+type recordingSearch struct {
+	Query     string           `json:"query"`
+	Matches   []recordingMatch `json:"matches"` // most recent first
+	Total     int              `json:"total"`
+	Truncated bool             `json:"truncated"` // earlier matches were dropped
+}
+
+// recordingPath maps a recording ID to its file in the record dir,
+// refusing IDs that would leave it.
+func (s *ShellServer) recordingPath(id string) (string, bool) {
+	if id == "" || strings.HasPrefix(id, ".") || strings.ContainsAny(id, `/\`) {
+		return "", false
+	}
+	return filepath.Join(s.recordDir, id+".cast"), true
+}
+
+// listRecordings returns the cast files in the record dir, newest first.
+// Files that aren't v2 casts are skipped.
+func (s *ShellServer) listRecordings() ([]recordingInfo, error) {
+	entries, err := os.ReadDir(s.recordDir)
+	if err != nil {
+		return nil, err
+	}
+	recs := []recordingInfo{}
+	for _, e := range entries {
+		id, ok := strings.CutSuffix(e.Name(), ".cast")
+		if !ok || e.IsDir() {
+			continue
+		}
+		info, err := cast.Stat(filepath.Join(s.recordDir, e.Name()))
+		if err != nil {
+			continue
+		}
+		rec := recordingInfo{
+			ID:       id,
+			Duration: info.Duration,
+			Size:     info.Size,
+			Width:    info.Header.Width,
+			Height:   info.Header.Height,
+			Title:    info.Header.Title,
+		}
+		if start := info.Header.Start(); !start.IsZero() {
+			rec.Start = &start
+		}
+		recs = append(recs, rec)
+	}
+	sort.SliceStable(recs, func(i, j int) bool {
+		ti, tj := recs[i].Start, recs[j].Start
+		if ti == nil || tj == nil {
+			return ti != nil
+		}
+		return ti.After(*tj)
+	})
+	return recs, nil
+}
+
+// searchRecording streams the output events of the cast at path, strips
+// their escape sequences and matches query case-insensitively against
+// each line of text. Only the last limit matches are kept.
+func searchRecording(path, query string, limit int) (recordingSearch, error) {
+	res := recordingSearch{Query: query, Matches: []recordingMatch{}}
+	f, err := os.Open(path)
+	if err != nil {
+		return res, err
+	}
+	defer f.Close()
+	cr, err := cast.NewReader(f)
+	if err != nil {
+		return res, err
+	}
+	start := cr.Header.Start()
+
+	// ring holds the last limit matches in order of appearance
+	ring := make([]recordingMatch, 0, limit)
+	next := 0
+	ls := lineSearcher{query: bytes.ToLower([]byte(query))}
+	ls.match = func(t float64, line string) {
+		m := recordingMatch{Time: t, Line: line}
+		if !start.IsZero() {
+			at := start.Add(time.Duration(t * float64(time.Second)))
+			m.At = &at
+		}
+		res.Total++
+		if len(ring) < limit {
+			ring = append(ring, m)
+			return
+		}
+		ring[next] = m
+		next = (next + 1) % limit
+	}
+
+	for {
+		e, err := cr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return res, err
+		}
+		if e.Type == cast.Output {
+			ls.write(e.Time, e.Data)
+		}
+	}
+	ls.flush()
+
+	for i := len(ring) - 1; i >= 0; i-- {
+		res.Matches = append(res.Matches, ring[(next+i)%len(ring)])
+	}
+	res.Truncated = res.Total > len(ring)
+	return res, nil
+}
+
+// lineSearcher reassembles output events into lines of plain text and
+// reports those containing query, timed by the event the match began in.
+type lineSearcher struct {
+	query []byte // lower case
+	match func(t float64, line string)
+
+	strip ansi.Stripper
+	line  []byte
+	times []lineTime // where each event's text begins in line
+	buf   []byte
+}
+
+type lineTime struct {
+	off int
+	t   float64
+}
+
+func (ls *lineSearcher) write(t float64, data string) {
+	ls.buf = ls.strip.Strip(ls.buf[:0], []byte(data))
+	text := ls.buf
+	for len(text) > 0 {
+		if len(ls.times) == 0 || ls.times[len(ls.times)-1].t != t {
+			ls.times = append(ls.times, lineTime{off: len(ls.line), t: t})
+		}
+		i := bytes.IndexByte(text, '\n')
+		if i < 0 {
+			ls.line = append(ls.line, text...)
+			if len(ls.line) >= maxSearchLine {
+				ls.flush()
+			}
+			return
+		}
+		ls.line = append(ls.line, text[:i]...)
+		ls.flush()
+		text = text[i+1:]
+	}
+}
+
+// flush searches the current line and starts a new one.
+func (ls *lineSearcher) flush() {
+	defer func() {
+		ls.line = ls.line[:0]
+		ls.times = ls.times[:0]
+	}()
+	i := bytes.Index(bytes.ToLower(ls.line), ls.query)
+	if i < 0 || len(ls.times) == 0 {
+		return
+	}
+	t := ls.times[0].t
+	for _, lt := range ls.times {
+		if lt.off > i {
+			break
+		}
+		t = lt.t
+	}
+	ls.match(t, strings.TrimSpace(string(ls.line)))
+}
+
+// handleRecordings serves GET /recordings (the list),
+// GET /recordings/{id} (the cast file itself, for the player) and
+// GET /recordings/{id}/search?q=...&limit=N.
+func (s *ShellServer) handleRecordings(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r, http.MethodGet)
+		return
+	}
+	if s.recordDir == "" {
+		respondError(w, r, http.StatusNotFound, protocol.ErrRecordingsDisabled, "recordings are off; start goshell with -record-dir")
+		return
+	}
+
+	rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/recordings"), "/")
+	if rest == "" {
+		recs, err := s.listRecordings()
+		if err != nil {
+			respondError(w, r, http.StatusInternalServerError, protocol.ErrInternal, err.Error())
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(recs)
+		return
+	}
+
+	id, action, _ := strings.Cut(rest, "/")
+	path, ok := s.recordingPath(id)
+	if !ok {
+		recordingNotFound(w, r, id)
+		return
+	}
+	switch action {
+	case "":
+		if _, err := os.Stat(path); err != nil {
+			recordingNotFound(w, r, id)
+			return
+		}
+		w.Header().Set("Content-Type", "application/x-asciicast")
+		clearDeadlines(w)
+		http.ServeFile(w, r, path)
+	case "search":
+		s.handleRecordingSearch(w, r, id, path)
+	default:
+		notFound(w, r)
+	}
+}
+
+func recordingNotFound(w http.ResponseWriter, r *http.Request, id string) {
+	respondError(w, r, http.StatusNotFound, protocol.ErrRecordingNotFound, fmt.Sprintf("no recording %q", id))
+}
+
+func (s *ShellServer) handleRecordingSearch(w http.ResponseWriter, r *http.Request, id, path string) {
+	query := r.URL.Query().Get("q")
+	if query == "" {
+		respondError(w, r, http.StatusBadRequest, protocol.ErrInvalidRequest, "missing q")
+		return
+	}
+	limit := defaultSearchLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			respondError(w, r, http.StatusBadRequest, protocol.ErrInvalidRequest, "limit must be a positive integer")
+			return
+		}
+		limit = min(n, maxSearchLimit)
+	}
+
+	res, err := searchRecording(path, query, limit)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		recordingNotFound(w, r, id)
+		return
+	case errors.Is(err, cast.ErrFormat):
+		respondError(w, r, http.StatusUnprocessableEntity, protocol.ErrInvalidRecording, err.Error())
+		return
+	case err != nil:
+		respondError(w, r, http.StatusInternalServerError, protocol.ErrInternal, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
@@ -0,0 +1,166 @@
+package shellserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"shellserver/internal/cast"
+)
+
+// writeRecording writes a cast file with the given output events.
+func writeRecording(t *testing.T, dir, id string, start int64, events []cast.Event) {
+	t.Helper()
+	f, err := os.Create(filepath.Join(dir, id+".cast"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	w, err := cast.NewWriter(f, cast.Header{Width: 80, Height: 24, Timestamp: start})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range events {
+		w.WriteEvent(e)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func getJSON(t *testing.T, url string, v any) int {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK && v != nil {
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return resp.StatusCode
+}
+
+func startRecordingServer(t *testing.T) (string, string) {
+	t.Helper()
+	dir := t.TempDir()
+	old := *flagRecordDir
+	*flagRecordDir = dir
+	t.Cleanup(func() { *flagRecordDir = old })
+	_, ts := startFakeShellServer(t)
+	return dir, ts.URL
+}
+
+func TestRecordingsList(t *testing.T) {
+	dir, url := startRecordingServer(t)
+	writeRecording(t, dir, "older", 1700000000, []cast.Event{{Time: 1, Type: cast.Output, Data: "a"}})
+	writeRecording(t, dir, "newer", 1700086400, []cast.Event{
+		{Time: 0.5, Type: cast.Output, Data: "a"},
+		{Time: 42.5, Type: cast.Output, Data: "b"},
+	})
+	os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("not a recording"), 0o644)
+	os.WriteFile(filepath.Join(dir, "broken.cast"), []byte("not json\n"), 0o644)
+
+	var recs []recordingInfo
+	if code := getJSON(t, url+"/recordings", &recs); code != http.StatusOK {
+		t.Fatalf("GET /recordings: %d", code)
+	}
+	if len(recs) != 2 || recs[0].ID != "newer" || recs[1].ID != "older" {
+		t.Fatalf("recordings = %+v, want newer then older", recs)
+	}
+	if recs[0].Duration != 42.5 || recs[0].Width != 80 || recs[0].Size == 0 ||
+		recs[0].Start == nil || recs[0].Start.Unix() != 1700086400 {
+		t.Errorf("metadata = %+v", recs[0])
+	}
+
+	resp, err := http.Get(url + "/recordings/newer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "application/x-asciicast" {
+		t.Errorf("GET /recordings/newer: %s %s", resp.Status, resp.Header.Get("Content-Type"))
+	}
+	for _, path := range []string{"/recordings/missing/search?q=x", "/recordings/..%2Fx/search?q=x", "/recordings/newer/other"} {
+		if code := getJSON(t, url+path, nil); code != http.StatusNotFound {
+			t.Errorf("GET %s: %d, want 404", path, code)
+		}
+	}
+}
+
+func TestRecordingSearch(t *testing.T) {
+	dir, url := startRecordingServer(t)
+	writeRecording(t, dir, "session", 1700000000, []cast.Event{
+		{Time: 0.1, Type: cast.Output, Data: "$ "},
+		{Time: 1.0, Type: cast.Input, Data: "go test\r"}, // input isn't searched
+		{Time: 1.2, Type: cast.Output, Data: "go test\r\n"},
+		// a match split across events, inside color codes: timed by the
+		// event it starts in
+		{Time: 2.5, Type: cast.Output, Data: "\x1b[31mpanic: Stack tr"},
+		{Time: 2.75, Type: cast.Output, Data: "ace\x1b[0m follows\r\n"},
+		{Time: 3.0, Type: cast.Output, Data: "goroutine 1 [running]:\r\nmain.main()\r\n"},
+		{Time: 9.0, Type: cast.Output, Data: "\x1b]0;title with stack trace\x07$ "},
+		{Time: 10.0, Type: cast.Output, Data: "echo \x1b[1mSTACK TRACE\x1b[0m again"},
+	})
+
+	var res recordingSearch
+	if code := getJSON(t, url+"/recordings/session/search?q=stack+trace", &res); code != http.StatusOK {
+		t.Fatalf("search: %d", code)
+	}
+	if res.Total != 2 || res.Truncated || len(res.Matches) != 2 {
+		t.Fatalf("search = %+v, want 2 matches", res)
+	}
+	// most recent first; the OSC title is not output text
+	want := []recordingMatch{
+		{Time: 10.0, Line: "$ echo STACK TRACE again"},
+		{Time: 2.5, Line: "panic: Stack trace follows"},
+	}
+	for i, m := range res.Matches {
+		if m.Time != want[i].Time || m.Line != want[i].Line {
+			t.Errorf("match %d = %+v, want %+v", i, m, want[i])
+		}
+		wantAt := time.Unix(1700000000, 0).Add(time.Duration(m.Time * float64(time.Second)))
+		if m.At == nil || !m.At.Equal(wantAt) {
+			t.Errorf("match %d at %v, want %v", i, m.At, wantAt)
+		}
+	}
+
+	if getJSON(t, url+"/recordings/session/search?q=go+test", &res); res.Total != 1 || res.Matches[0].Time != 1.2 {
+		t.Errorf("input event searched: %+v", res)
+	}
+	if code := getJSON(t, url+"/recordings/session/search", nil); code != http.StatusBadRequest {
+		t.Errorf("search without q: %d, want 400", code)
+	}
+}
+
+func TestRecordingSearchCapped(t *testing.T) {
+	dir, url := startRecordingServer(t)
+	var events []cast.Event
+	for i := 0; i < 2*maxSearchLimit; i++ {
+		events = append(events, cast.Event{Time: float64(i), Type: cast.Output, Data: fmt.Sprintf("hit %d\r\n", i)})
+	}
+	writeRecording(t, dir, "big", 0, events)
+
+	var res recordingSearch
+	getJSON(t, url+"/recordings/big/search?q=hit&limit=3", &res)
+	if res.Total != 2*maxSearchLimit || !res.Truncated || len(res.Matches) != 3 {
+		t.Fatalf("limit=3: total %d, truncated %v, %d matches", res.Total, res.Truncated, len(res.Matches))
+	}
+	if res.Matches[0].Line != fmt.Sprintf("hit %d", 2*maxSearchLimit-1) || res.Matches[2].Time != float64(2*maxSearchLimit-3) {
+		t.Errorf("kept %+v, want the last three newest first", res.Matches)
+	}
+	if res.Matches[0].At != nil {
+		t.Error("absolute time reported for a recording without a timestamp")
+	}
+
+	getJSON(t, url+"/recordings/big/search?q=hit&limit=100000", &res)
+	if len(res.Matches) != maxSearchLimit {
+		t.Errorf("limit not capped: %d matches", len(res.Matches))
+	}
+}
@@ -0,0 +1,173 @@
+package shellserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+
+	"shellserver/pkg/protocol"
+)
+
+// liveSettings is the part of the configuration a reload can change. Each
+// load builds a new one and it is never modified after, so a request that
+// takes s.settings() once sees one version throughout, even if a reload
+// lands halfway through it.
+type liveSettings struct {
+	config           *serverConfig
+	allowedCmds      []*regexp.Regexp // widget commands that may run at all
+	trustedCmds      []*regexp.Regexp // widget commands that skip confirmation
+	widgetRate       int              // -widget-rate, or the config's widget_rate
+	widgetRateWindow time.Duration
+}
+
+// newLiveSettings resolves cfg against the settings it overrides.
+func newLiveSettings(cfg *serverConfig, set Settings) (*liveSettings, error) {
+	patterns := cfg.TrustedCommands
+	if len(patterns) == 0 {
+		patterns = set.WidgetCmdTrusted
+	}
+	trusted, err := compileCmdPatterns(patterns, defaultTrustedCmdPatterns())
+	if err != nil {
+		return nil, err
+	}
+	allowed, err := compileCmdPatterns(set.WidgetCmdAllow, defaultAllowedCmdPatterns())
+	if err != nil {
+		return nil, err
+	}
+	ls := &liveSettings{
+		config:           cfg,
+		allowedCmds:      allowed,
+		trustedCmds:      trusted,
+		widgetRate:       set.WidgetRate,
+		widgetRateWindow: set.WidgetRateWindow,
+	}
+	if cfg.WidgetRate != nil {
+		ls.widgetRate = *cfg.WidgetRate
+	}
+	if cfg.WidgetRateWindow != "" {
+		// loadConfig has checked it parses
+		ls.widgetRateWindow, _ = time.ParseDuration(cfg.WidgetRateWindow)
+	}
+	return ls, nil
+}
+
+// settings returns the current live settings. A server built without any,
+// as in tests, has an empty config and allows and trusts nothing.
+func (s *ShellServer) settings() *liveSettings {
+	if ls := s.live.Load(); ls != nil {
+		return ls
+	}
+	return &liveSettings{config: &serverConfig{}}
+}
+
+// applySettings makes ls current.
+func (s *ShellServer) applySettings(ls *liveSettings) {
+	s.live.Store(ls)
+	s.widgetQuota.set(ls.widgetRate, ls.widgetRateWindow)
+	caps := s.collectCapabilities()
+	s.caps.Store(&caps)
+}
+
+// reloadResult is what a reload did with each config key.
+type reloadResult struct {
+	Config    string   `json:"config"`
+	Applied   []string `json:"applied"`          // changed and in effect
+	Restart   []string `json:"restart_required"` // changed, but the running shell keeps the old value until POST /restart
+	Unchanged []string `json:"unchanged"`
+}
+
+// reload re-reads -config and applies it. If the file doesn't load, nothing
+// changes. Settings that only flags control (-addr, -shell, TLS and the
+// rest) are never reloaded; they take a server restart.
+func (s *ShellServer) reload() (*reloadResult, error) {
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+
+	cfg, err := loadConfig(s.options.Config)
+	if err != nil {
+		return nil, err
+	}
+	ls, err := newLiveSettings(cfg, s.options)
+	if err != nil {
+		return nil, fmt.Errorf("config %s: %w", s.options.Config, err)
+	}
+	old := s.settings().config
+
+	res := &reloadResult{Config: s.options.Config, Applied: []string{}, Restart: []string{}, Unchanged: []string{}}
+	for _, key := range []struct {
+		name     string
+		old, new any
+	}{
+		{"profiles", old.Profiles, cfg.Profiles},
+		{"trusted_commands", old.TrustedCommands, cfg.TrustedCommands},
+		{"widget_rate", old.WidgetRate, cfg.WidgetRate},
+		{"widget_rate_window", old.WidgetRateWindow, cfg.WidgetRateWindow},
+	} {
+		if reflect.DeepEqual(key.old, key.new) {
+			res.Unchanged = append(res.Unchanged, key.name)
+		} else {
+			res.Applied = append(res.Applied, key.name)
+		}
+	}
+
+	// The running shell was started from its profile; a changed definition
+	// applies from the next restart
+	s.ptyMu.Lock()
+	if active := s.profile; active != nil {
+		switch p := cfg.profile(active.Name); {
+		case p == nil:
+			res.Restart = append(res.Restart, "profiles."+active.Name+" (removed)")
+		case !reflect.DeepEqual(p, active):
+			res.Restart = append(res.Restart, "profiles."+active.Name)
+			s.profile = p
+		default:
+			s.profile = p
+		}
+	}
+	s.ptyMu.Unlock()
+
+	s.applySettings(ls)
+	return res, nil
+}
+
+// summary is res as one log line.
+func (res *reloadResult) summary() string {
+	list := func(keys []string) string {
+		if len(keys) == 0 {
+			return "none"
+		}
+		return strings.Join(keys, ", ")
+	}
+	return fmt.Sprintf("applied: %s; on restart: %s; unchanged: %s", list(res.Applied), list(res.Restart), list(res.Unchanged))
+}
+
+// reloadAndLog reloads the config for SIGHUP, logging the outcome.
+func (s *ShellServer) reloadAndLog() {
+	res, err := s.reload()
+	if err != nil {
+		log.Printf("reload: %v; keeping the current config", err)
+		return
+	}
+	log.Printf("reload %s: %s", res.Config, res.summary())
+}
+
+// handleReload serves POST /reload.
+func (s *ShellServer) handleReload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, r, http.MethodPost)
+		return
+	}
+	res, err := s.reload()
+	if err != nil {
+		respondError(w, r, http.StatusUnprocessableEntity, protocol.ErrConfigInvalid, err.Error())
+		return
+	}
+	log.Printf("reload %s: %s", res.Config, res.summary())
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
@@ -0,0 +1,128 @@
+package shellserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"reflect"
+	"sync"
+	"testing"
+
+	"shellserver/pkg/protocol"
+)
+
+func TestReload(t *testing.T) {
+	dir := t.TempDir()
+	write := func(cfg map[string]any) {
+		t.Helper()
+		data, _ := json.Marshal(cfg)
+		if err := os.WriteFile(*flagConfig, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeConfig(t, "{}", "go")
+	write(map[string]any{
+		"profiles":         []map[string]any{{"name": "go", "cwd": dir}},
+		"trusted_commands": []string{"^ls$"},
+		"widget_rate":      5,
+	})
+	s, ts := startFakeShellServer(t)
+	before := s.settings()
+	if !s.isTrustedCmd("ls") || before.widgetRate != 5 {
+		t.Fatalf("startup settings: trusts ls %v, widget rate %d", s.isTrustedCmd("ls"), before.widgetRate)
+	}
+
+	write(map[string]any{
+		"profiles": []map[string]any{
+			{"name": "go", "cwd": dir, "env": map[string]string{"GOFLAGS": "-mod=mod"}},
+			{"name": "py", "cwd": dir},
+		},
+		"trusted_commands": []string{"^make$"},
+		"widget_rate":      7,
+	})
+	resp, err := http.Post(ts.URL+"/reload", "application/json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var res reloadResult
+	json.NewDecoder(resp.Body).Decode(&res)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("POST /reload: %d", resp.StatusCode)
+	}
+	want := reloadResult{
+		Config:    *flagConfig,
+		Applied:   []string{"profiles", "trusted_commands", "widget_rate"},
+		Restart:   []string{"profiles.go"},
+		Unchanged: []string{"widget_rate_window"},
+	}
+	if !reflect.DeepEqual(res, want) {
+		t.Errorf("reload result %+v, want %+v", res, want)
+	}
+	if s.isTrustedCmd("ls") || !s.isTrustedCmd("make") {
+		t.Error("trusted commands not reloaded")
+	}
+	if caps := s.capabilities(); caps["widget-rate"] != "7/10s" || caps["profiles"] != 2 {
+		t.Errorf("capabilities after reload: widget-rate %v, profiles %v", caps["widget-rate"], caps["profiles"])
+	}
+	// A snapshot taken before is untouched
+	if before.widgetRate != 5 || !before.trustedCmds[0].MatchString("ls") || len(before.config.Profiles) != 1 {
+		t.Error("reload modified an earlier snapshot")
+	}
+
+	// A bad config changes nothing
+	os.WriteFile(*flagConfig, []byte(`{"widget_rate_window": "soon"}`), 0o644)
+	resp, err = http.Post(ts.URL+"/reload", "application/json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var errBody protocol.ErrorResponse
+	json.NewDecoder(resp.Body).Decode(&errBody)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnprocessableEntity || errBody.Error.Code != protocol.ErrConfigInvalid {
+		t.Errorf("invalid config: %d %s", resp.StatusCode, errBody.Error.Code)
+	}
+	if !s.isTrustedCmd("make") || s.settings().widgetRate != 7 {
+		t.Error("invalid config was partly applied")
+	}
+}
+
+func TestReloadSnapshotsAreConsistent(t *testing.T) {
+	writeConfig(t, "{}", "")
+	s, _ := startFakeShellServer(t)
+
+	// Each config has as many trusted patterns as its widget rate, so a
+	// snapshot mixing two versions shows
+	configs := []string{
+		`{"trusted_commands": ["^a$"], "widget_rate": 1}`,
+		`{"trusted_commands": ["^a$", "^b$"], "widget_rate": 2}`,
+	}
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				ls := s.settings()
+				if ls.config.WidgetRate != nil && (len(ls.trustedCmds) != ls.widgetRate || len(ls.config.TrustedCommands) != ls.widgetRate) {
+					t.Errorf("inconsistent snapshot: %d patterns, widget rate %d", len(ls.trustedCmds), ls.widgetRate)
+					return
+				}
+			}
+		}()
+	}
+	for i := 0; i < 50; i++ {
+		os.WriteFile(*flagConfig, []byte(configs[i%2]), 0o644)
+		if _, err := s.reload(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(done)
+	wg.Wait()
+}
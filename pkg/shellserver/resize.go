@@ -0,0 +1,253 @@
+package shellserver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"shellserver/pkg/protocol"
+)
+
+var (
+	flagRows         = Flags.Int("rows", defaultPTYRows, "rows of the terminal the shell starts in, until a client resizes it")
+	flagCols         = Flags.Int("cols", defaultPTYCols, "columns of the terminal the shell starts in, until a client resizes it")
+	flagResizePolicy = Flags.String("resize-policy", "last-writer", `how the terminal sizes of several writers combine: "last-writer", the last one to resize wins, or "smallest", the fewest rows and columns any of them has, so the shell fits every screen`)
+)
+
+// termSize is a terminal size in character cells.
+type termSize struct {
+	Rows int `json:"rows"`
+	Cols int `json:"cols"`
+}
+
+// maxTermDim bounds a terminal's rows and columns. A PTY takes up to
+// 65535, but no screen is that big, and the screen model would allocate
+// all of it.
+const maxTermDim = 1000
+
+// valid reports whether the size is one to give the PTY.
+func (size termSize) valid() bool {
+	return size.Rows > 0 && size.Rows <= maxTermDim && size.Cols > 0 && size.Cols <= maxTermDim
+}
+
+// checkSizeSettings validates -rows, -cols and -resize-policy.
+func checkSizeSettings(set Settings) error {
+	if !(termSize{set.Rows, set.Cols}).valid() {
+		return fmt.Errorf("-rows and -cols must be between 1 and %d, not %dx%d", maxTermDim, set.Rows, set.Cols)
+	}
+	if set.ResizePolicy != "last-writer" && set.ResizePolicy != "smallest" {
+		return fmt.Errorf("-resize-policy must be last-writer or smallest, not %q", set.ResizePolicy)
+	}
+	return nil
+}
+
+// setPTYSize records the size just applied to the PTY.
+func (s *ShellServer) setPTYSize(rows, cols int) {
+	s.winsize.Store(uint32(rows)<<16 | uint32(cols))
+}
+
+// ptySize returns the size last applied to the PTY, without waiting on
+// ptyMu. A restarted shell starts at it too.
+func (s *ShellServer) ptySize() (rows, cols int) {
+	size := s.winsize.Load()
+	if size == 0 {
+		return defaultPTYRows, defaultPTYCols
+	}
+	return int(size >> 16), int(size & 0xffff)
+}
+
+// resizeInterval is the least time between two sizes applied from
+// websocket resize messages; dragging a window edge sends a storm of them.
+const resizeInterval = time.Second / 30
+
+// resizeDebounce holds back websocket resizes that come quicker than
+// resizeInterval. The latest size waiting is applied when the interval
+// is up, so the terminal always ends at the last size asked for.
+type resizeDebounce struct {
+	mu      sync.Mutex
+	last    time.Time // when a size was last applied
+	pending termSize
+	timer   *time.Timer // set while a size waits
+}
+
+// requestResize handles a writer's new terminal size, returning the size
+// the terminal takes: with -resize-policy=smallest that can be smaller
+// than the one asked for. A size from nobody in particular, c being nil,
+// is applied as it is.
+func (s *ShellServer) requestResize(c *client, size termSize) (termSize, error) {
+	if c == nil {
+		s.resizeMu.Lock()
+		defer s.resizeMu.Unlock()
+		return size, s.applySize(size)
+	}
+	s.noteClientSize(c, size)
+	return s.resizeForClients(size)
+}
+
+// resizeSoon is requestResize for a websocket resize message: at most one
+// size is applied every resizeInterval, and an error is only logged.
+func (s *ShellServer) resizeSoon(c *client, size termSize) {
+	s.noteClientSize(c, size)
+	d := &s.resizes
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.pending = size
+	if d.timer != nil {
+		return
+	}
+	wait := resizeInterval - time.Since(d.last)
+	if wait < 0 {
+		wait = 0
+	}
+	d.timer = time.AfterFunc(wait, s.flushResize)
+}
+
+// flushResize applies the size resizeSoon held back.
+func (s *ShellServer) flushResize() {
+	d := &s.resizes
+	d.mu.Lock()
+	size := d.pending
+	d.timer, d.last = nil, time.Now()
+	d.mu.Unlock()
+	if s.stopping.Load() {
+		return
+	}
+	if _, err := s.resizeForClients(size); err != nil {
+		log.Printf("resize error: %v", err)
+	}
+}
+
+// noteClientSize records the size c's screen has.
+func (s *ShellServer) noteClientSize(c *client, size termSize) {
+	s.clientsMu.Lock()
+	c.size = size
+	s.clientsMu.Unlock()
+}
+
+// resizeForClients applies size, which a writer just asked for, by
+// -resize-policy.
+func (s *ShellServer) resizeForClients(size termSize) (termSize, error) {
+	s.resizeMu.Lock()
+	defer s.resizeMu.Unlock()
+	if s.resizePolicy == "smallest" {
+		size, _ = s.smallestSize()
+	}
+	return size, s.applySize(size)
+}
+
+// refitSize lets the terminal grow, with -resize-policy=smallest, once a
+// writer with a smaller screen has gone.
+func (s *ShellServer) refitSize() {
+	if s.resizePolicy != "smallest" {
+		return
+	}
+	s.resizeMu.Lock()
+	defer s.resizeMu.Unlock()
+	size, ok := s.smallestSize()
+	if rows, cols := s.ptySize(); !ok || size == (termSize{rows, cols}) {
+		return
+	}
+	if err := s.applySize(size); err != nil {
+		log.Printf("resize error: %v", err)
+	}
+}
+
+// smallestSize returns the fewest rows and columns any connected writer
+// has asked for, or false if none has asked.
+func (s *ShellServer) smallestSize() (termSize, bool) {
+	s.clientsMu.RLock()
+	defer s.clientsMu.RUnlock()
+	var smallest termSize
+	for _, c := range s.clients {
+		if c.readOnly || c.size.Rows == 0 {
+			continue
+		}
+		if smallest.Rows == 0 {
+			smallest = c.size
+			continue
+		}
+		smallest.Rows = min(smallest.Rows, c.size.Rows)
+		smallest.Cols = min(smallest.Cols, c.size.Cols)
+	}
+	return smallest, smallest.Rows != 0
+}
+
+// applySize resizes the PTY, the screen model and any recording, then
+// tells every client the terminal's size with {"kind":"resize"} so they
+// can match it.
+func (s *ShellServer) applySize(size termSize) error {
+	s.ptyMu.Lock()
+	err := errNoShell
+	if s.ptyFile != nil {
+		err = setWinsize(s.ptyFile, size.Rows, size.Cols)
+	}
+	if errors.Is(err, os.ErrClosed) {
+		err = errNoShell
+	}
+	if err == nil {
+		s.setPTYSize(size.Rows, size.Cols)
+		s.resizeScreen(size.Rows, size.Cols)
+		s.recorder.resize(size.Rows, size.Cols)
+	}
+	s.ptyMu.Unlock()
+	if err != nil {
+		return err
+	}
+	data, _ := json.Marshal(map[string]any{"kind": "resize", "rows": size.Rows, "cols": size.Cols})
+	s.broadcastMessage(websocket.TextMessage, data)
+	return nil
+}
+
+// handleResize serves POST /resize {"rows":40,"cols":120}, optionally
+// with the "client_id" of the writer whose screen it is, so that
+// -resize-policy can weigh it against the others'. It answers with the
+// size the terminal took.
+func (s *ShellServer) handleResize(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, r, http.MethodPost)
+		return
+	}
+	var req struct {
+		termSize
+		ClientID string `json:"client_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		invalidJSON(w, r, err)
+		return
+	}
+	if !req.valid() {
+		respondError(w, r, http.StatusBadRequest, protocol.ErrInvalidRequest, fmt.Sprintf(`"rows" and "cols" must be between 1 and %d`, maxTermDim))
+		return
+	}
+	var c *client
+	if req.ClientID != "" {
+		if c = s.connectedClient(req.ClientID); c == nil {
+			respondError(w, r, http.StatusNotFound, protocol.ErrClientNotFound, fmt.Sprintf("no connected client %q", req.ClientID))
+			return
+		}
+		if c.readOnly {
+			respondError(w, r, http.StatusConflict, protocol.ErrReadOnlyClient, c.id+" is an observer")
+			return
+		}
+	}
+
+	size, err := s.requestResize(c, req.termSize)
+	if errors.Is(err, errNoShell) {
+		shellNotRunning(w, r)
+		return
+	}
+	if err != nil {
+		log.Printf("resize error: %v", err)
+		respondError(w, r, http.StatusInternalServerError, protocol.ErrResizeFailed, "failed to resize terminal: "+err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(size)
+}
@@ -0,0 +1,178 @@
+package shellserver
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/creack/pty"
+
+	"shellserver/internal/testshell"
+	"shellserver/pkg/protocol"
+)
+
+// ptyGetsize reads the size the kernel has for s's PTY.
+func ptyGetsize(t *testing.T, s *ShellServer) termSize {
+	t.Helper()
+	s.ptyMu.Lock()
+	defer s.ptyMu.Unlock()
+	rows, cols, err := pty.Getsize(s.ptyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return termSize{rows, cols}
+}
+
+// expectResize waits for c's next resize event and checks its size.
+func expectResize(t *testing.T, c *testshell.Client, want termSize) {
+	t.Helper()
+	ev := c.ExpectEvent("resize", testshell.DefaultTimeout)
+	if ev["rows"] != float64(want.Rows) || ev["cols"] != float64(want.Cols) {
+		t.Errorf("resize event = %v, want %dx%d", ev, want.Rows, want.Cols)
+	}
+}
+
+func TestInitialSizeAndRestart(t *testing.T) {
+	defer func(rows, cols int) { *flagRows, *flagCols = rows, cols }(*flagRows, *flagCols)
+	*flagRows, *flagCols = 50, 160
+
+	s, ts := startFakeShellServer(t)
+	if got := ptyGetsize(t, s); got != (termSize{50, 160}) {
+		t.Errorf("initial size = %v, want 50x160", got)
+	}
+	c := testshell.Dial(t, ts.URL, "")
+	if c.Ready["rows"] != float64(50) || c.Ready["cols"] != float64(160) {
+		t.Errorf("ready = %v, want rows 50 and cols 160", c.Ready)
+	}
+
+	c.SendJSON(map[string]any{"kind": "resize", "rows": 40, "cols": 120})
+	expectResize(t, c, termSize{40, 120})
+	resp, err := http.Post(ts.URL+"/restart", "application/json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if got := ptyGetsize(t, s); got != (termSize{40, 120}) {
+		t.Errorf("size after restart = %v, want 40x120 kept", got)
+	}
+}
+
+func TestResizeLastWriterWins(t *testing.T) {
+	_, ts := startFakeShellServer(t)
+	a := testshell.Dial(t, ts.URL, "")
+	b := testshell.Dial(t, ts.URL, "")
+
+	a.SendJSON(map[string]any{"kind": "resize", "rows": 50, "cols": 200})
+	expectResize(t, a, termSize{50, 200})
+	expectResize(t, b, termSize{50, 200})
+	b.SendJSON(map[string]any{"kind": "resize", "rows": 30, "cols": 100})
+	expectResize(t, a, termSize{30, 100})
+}
+
+func TestResizeSmallestPolicy(t *testing.T) {
+	defer func(old string) { *flagResizePolicy = old }(*flagResizePolicy)
+	*flagResizePolicy = "smallest"
+
+	s, ts := startFakeShellServer(t)
+	a := testshell.Dial(t, ts.URL, "")
+	observer := testshell.Dial(t, ts.URL, "?role=observer")
+	a.SendJSON(map[string]any{"kind": "resize", "rows": 50, "cols": 200})
+	expectResize(t, a, termSize{50, 200})
+
+	b, _ := dialShell(t, ts, "")
+	b.WriteJSON(map[string]any{"kind": "resize", "rows": 60, "cols": 100})
+	expectResize(t, a, termSize{50, 100})
+
+	// An observer's screen doesn't count
+	observer.SendJSON(map[string]any{"kind": "resize", "rows": 10, "cols": 10})
+	b.WriteJSON(map[string]any{"kind": "resize", "rows": 60, "cols": 150})
+	expectResize(t, a, termSize{50, 150})
+
+	// With the narrower writer gone, the terminal grows back
+	b.Close()
+	expectResize(t, a, termSize{50, 200})
+	if got := ptyGetsize(t, s); got != (termSize{50, 200}) {
+		t.Errorf("size = %v, want 50x200", got)
+	}
+}
+
+func TestResizeStormDebounced(t *testing.T) {
+	s, ts := startFakeShellServer(t)
+	c := testshell.Dial(t, ts.URL, "")
+
+	start := time.Now()
+	for i := 1; i <= 100; i++ {
+		c.SendJSON(map[string]any{"kind": "resize", "rows": 30, "cols": 100 + i})
+	}
+	events := 0
+	for {
+		ev := c.ExpectEvent("resize", testshell.DefaultTimeout)
+		events++
+		if ev["cols"] == float64(200) {
+			break
+		}
+	}
+	if limit := int(time.Since(start)/resizeInterval) + 2; events > limit {
+		t.Errorf("%d resizes applied in %v, want at most %d", events, time.Since(start), limit)
+	}
+	if got := ptyGetsize(t, s); got != (termSize{30, 200}) {
+		t.Errorf("size = %v, want the last one asked for, 30x200", got)
+	}
+}
+
+func TestResizeWithoutShell(t *testing.T) {
+	s := newPumpTestServer()
+	ts := serveShellServer(t, s)
+	post := func(path, body string) *http.Response {
+		t.Helper()
+		resp, err := http.Post(ts.URL+path, "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+
+	tests := []struct {
+		name   string
+		path   string
+		body   string
+		status int
+		code   protocol.ErrorCode
+	}{
+		{"no PTY", "/resize", `{"rows":40,"cols":120}`, http.StatusConflict, protocol.ErrShellNotRunning},
+		{"zero rows", "/resize", `{"rows":0,"cols":120}`, http.StatusBadRequest, protocol.ErrInvalidRequest},
+		{"too many columns", "/resize", `{"rows":40,"cols":1001}`, http.StatusBadRequest, protocol.ErrInvalidRequest},
+		{"paste", "/paste", `{"data":"ls\n"}`, http.StatusConflict, protocol.ErrShellNotRunning},
+		{"signal", "/signal", `{"name":"INT"}`, http.StatusConflict, protocol.ErrShellNotRunning},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := post(tt.path, tt.body)
+			if resp.StatusCode != tt.status || errorCode(t, resp) != tt.code {
+				t.Errorf("POST %s %s: status %d, want %d %s", tt.path, tt.body, resp.StatusCode, tt.status, tt.code)
+			}
+		})
+	}
+	if err := s.writeToPTY([]byte("ls\n")); !errors.Is(err, errNoShell) {
+		t.Errorf("writeToPTY = %v, want errNoShell", err)
+	}
+
+	// A PTY closed under the server, as by a restart that failed
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Close()
+	w.Close()
+	s.ptyFile = w
+	if resp := post("/resize", `{"rows":40,"cols":120}`); resp.StatusCode != http.StatusConflict {
+		t.Errorf("resize of a closed PTY: status %d, want 409", resp.StatusCode)
+	}
+	if err := s.writeToPTY([]byte("ls\n")); !errors.Is(err, errNoShell) {
+		t.Errorf("writeToPTY to a closed PTY = %v, want errNoShell", err)
+	}
+}
@@ -0,0 +1,172 @@
+package shellserver
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+
+	"shellserver/internal/ansi"
+	"shellserver/internal/vt"
+	"shellserver/pkg/protocol"
+)
+
+var (
+	flagScrollback      = byteSizeFlag(64 << 10)
+	flagScrollbackLines = Flags.Int("scrollback-lines", 5000, "most lines of output kept for replay to new clients (0 for no limit)")
+)
+
+func init() {
+	Flags.Var(&flagScrollback, "scrollback", "most output kept for replay to new clients, such as 256K or 4M (0 for no limit)")
+}
+
+// scrollbackUsage is the "scrollback" object in /status. Dropped counts
+// what was trimmed from the front to stay within the limits, so a client
+// can tell when history is being lost; a restart or a full-screen program
+// exiting clears the buffer without counting.
+type scrollbackUsage struct {
+	Bytes        int   `json:"bytes"`
+	Lines        int   `json:"lines"`
+	MaxBytes     int   `json:"max_bytes"` // 0 for no limit
+	MaxLines     int   `json:"max_lines"` // 0 for no limit
+	DroppedBytes int64 `json:"dropped_bytes"`
+	DroppedLines int64 `json:"dropped_lines"`
+}
+
+// trimSlack is how far the replay buffer may grow past its limits, as a
+// fraction of them, before it is trimmed. Trimming scans the buffer, so
+// doing it on every read would rescan a 4M scrollback for each 4K of
+// output; with the slack it scans it once per half megabyte.
+const trimSlack = 8 // 1/8
+
+// overLimit reports whether n has grown past limit by more than the slack.
+func overLimit(n, limit int) bool {
+	return limit > 0 && n > limit+limit/trimSlack
+}
+
+// trimScrollback cuts the replay buffer to the -scrollback limits at the
+// start of a line, or past a finished command block the cut would split,
+// counting what it drops, once it has grown past them by trimSlack.
+// bufferMu must be held.
+func (s *ShellServer) trimScrollback() {
+	if !overLimit(len(s.buffer), s.scrollbackBytes) && !overLimit(s.bufferLines, s.scrollbackLines) {
+		return
+	}
+	kept := ansi.TrimLines(s.buffer, s.scrollbackBytes, s.scrollbackLines)
+	cut := s.outputEnd - int64(len(kept))
+	if moved := s.blocks.trim(cut); moved > cut {
+		kept = kept[min(moved-cut, int64(len(kept))):]
+	}
+	if dropped := s.buffer[:len(s.buffer)-len(kept)]; len(dropped) > 0 {
+		s.scrollbackDropped.bytes += int64(len(dropped))
+		s.scrollbackDropped.lines += int64(bytes.Count(dropped, []byte("\n")))
+	}
+	s.buffer = kept
+	s.bufferLines = bytes.Count(kept, []byte("\n"))
+}
+
+// newOutputEpoch names a process's output offsets. A client resuming
+// presents it with ?epoch= alongside ?since=, so an offset from before
+// goshell restarted, which numbers different output, gets a snapshot.
+func newOutputEpoch() string {
+	var raw [8]byte
+	rand.Read(raw[:])
+	return hex.EncodeToString(raw[:])
+}
+
+// replay is what a new client is sent of the session so far, and the
+// offset it ends at. A client resuming from an offset the buffer still
+// holds gets just the output after it, if epoch says the offset is this
+// process's and not one from before goshell restarted. Otherwise, with
+// since before the buffer or past its end, it gets a snapshot of the screen, history
+// included, that draws it as it is however much output got it there, or
+// with raw the output buffer as the shell wrote it. A server without a
+// screen model always replays raw.
+//
+// Replaying raw while a full-screen program holds the alternate screen
+// would draw whatever part of its screen it last changed, so the buffer
+// goes only as far as the program taking the screen, and redraw says to
+// ask the program to draw the rest.
+func (s *ShellServer) replay(raw bool, epoch string, since int64) (data []byte, end int64, redraw bool) {
+	s.bufferMu.Lock()
+	defer s.bufferMu.Unlock()
+	end = s.outputEnd
+	start := end - int64(len(s.buffer))
+	if epoch == s.outputEpoch && since >= start && since <= end {
+		return bytes.Clone(s.buffer[since-start:]), end, false
+	}
+	if s.screen != nil && s.screen.AltScreen() && raw {
+		normal := s.buffer[:min(max(s.altScreenStart-start, 0), int64(len(s.buffer)))]
+		return append(bytes.Clone(normal), "\x1b[?1049h"...), end, true
+	}
+	if raw || s.screen == nil {
+		return bytes.Clone(s.buffer), end, false
+	}
+	return s.screen.Snapshot(), end, false
+}
+
+// resizeScreen follows a PTY resize in the screen model.
+func (s *ShellServer) resizeScreen(rows, cols int) {
+	s.bufferMu.Lock()
+	defer s.bufferMu.Unlock()
+	if s.screen != nil {
+		s.screen.Resize(rows, cols)
+	}
+}
+
+// handleBuffer serves GET /buffer, the scrollback for saving or copying:
+// ?format=text (the default) the screen model's history and screen as
+// plain text, ?format=html the same with colors, and ?format=raw the raw
+// replay buffer as the shell wrote it.
+func (s *ShellServer) handleBuffer(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r, http.MethodGet)
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "text"
+	}
+	var body []byte
+	var contentType, ext string
+	s.bufferMu.Lock()
+	screen := s.screen
+	if screen == nil {
+		rows, cols := s.ptySize()
+		screen = vt.New(rows, cols, 0)
+		screen.Write(s.buffer)
+	}
+	switch format {
+	case "raw":
+		body, contentType, ext = bytes.Clone(s.buffer), "application/octet-stream", "log"
+	case "text":
+		body, contentType, ext = []byte(screen.Text()), "text/plain; charset=utf-8", "txt"
+	case "html":
+		body = []byte("<!DOCTYPE html>\n<html><head><meta charset=\"utf-8\"><title>goshell scrollback</title></head>" +
+			"<body style=\"margin:0;background:" + vt.DefaultBackground + "\">" + screen.HTML() + "</body></html>\n")
+		contentType, ext = "text/html; charset=utf-8", "html"
+	default:
+		s.bufferMu.Unlock()
+		respondError(w, r, http.StatusBadRequest, protocol.ErrInvalidRequest, fmt.Sprintf("unknown format %q; use text, html or raw", format))
+		return
+	}
+	s.bufferMu.Unlock()
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`inline; filename="goshell-scrollback.%s"`, ext))
+	w.Write(body)
+}
+
+// scrollbackUsage reports the replay buffer's size against its limits.
+func (s *ShellServer) scrollbackUsage() scrollbackUsage {
+	s.bufferMu.Lock()
+	defer s.bufferMu.Unlock()
+	return scrollbackUsage{
+		Bytes:        len(s.buffer),
+		Lines:        bytes.Count(s.buffer, []byte("\n")),
+		MaxBytes:     s.scrollbackBytes,
+		MaxLines:     s.scrollbackLines,
+		DroppedBytes: s.scrollbackDropped.bytes,
+		DroppedLines: s.scrollbackDropped.lines,
+	}
+}
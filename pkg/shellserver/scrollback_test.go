@@ -0,0 +1,244 @@
+package shellserver
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+
+	"shellserver/internal/testshell"
+	"shellserver/internal/vt"
+)
+
+func TestByteSizeFlag(t *testing.T) {
+	for in, want := range map[string]int{
+		"0":     0,
+		"4096":  4096,
+		"256K":  256 << 10,
+		"256k":  256 << 10,
+		"4M":    4 << 20,
+		"4MB":   4 << 20,
+		"1G":    1 << 30,
+		" 64K ": 64 << 10,
+	} {
+		var f byteSizeFlag
+		if err := f.Set(in); err != nil || int(f) != want {
+			t.Errorf("Set(%q) = %d, %v; want %d", in, f, err, want)
+		}
+	}
+	for _, in := range []string{"", "K", "-1", "4T", "1.5M", "8G"} {
+		var f byteSizeFlag
+		if err := f.Set(in); err == nil {
+			t.Errorf("Set(%q) = %d, want an error", in, f)
+		}
+	}
+	for n, want := range map[byteSizeFlag]string{0: "0", 1000: "1000", 64 << 10: "64K", 4 << 20: "4M", 1536: "1536"} {
+		if got := n.String(); got != want {
+			t.Errorf("byteSizeFlag(%d).String() = %q, want %q", int(n), got, want)
+		}
+	}
+}
+
+func TestAppendToBufferKeepsWholeLines(t *testing.T) {
+	s := newPumpTestServer()
+	s.scrollbackBytes = 0
+	s.scrollbackLines = 3
+	for _, line := range []string{"one\r\n", "two\r\n", "three\r\n", "four\r\n", "$ "} {
+		s.appendToBuffer([]byte(line), nil, true)
+	}
+	if got := string(s.buffer); got != "two\r\nthree\r\nfour\r\n$ " {
+		t.Errorf("buffer = %q, want the last three lines and the prompt", got)
+	}
+
+	s.scrollbackBytes = 16
+	s.appendToBuffer([]byte("\r\n"), nil, true)
+	if got := string(s.buffer); got != "four\r\n$ \r\n" {
+		t.Errorf("buffer = %q, want only the lines that fit in 16 bytes", got)
+	}
+	u := s.scrollbackUsage()
+	if u.Bytes != len(s.buffer) || u.Lines != 2 || u.DroppedLines != 3 || u.DroppedBytes != int64(len("one\r\ntwo\r\nthree\r\n")) {
+		t.Errorf("usage = %+v", u)
+	}
+}
+
+func TestAppendToBufferTrimsPastSlack(t *testing.T) {
+	s := newPumpTestServer()
+	s.scrollbackBytes = 0
+	s.scrollbackLines = 80
+	line := []byte("line\r\n")
+	for i := 0; i < 80+80/trimSlack; i++ {
+		s.appendToBuffer(line, nil, true)
+	}
+	if u := s.scrollbackUsage(); u.Lines != 90 || u.DroppedLines != 0 {
+		t.Errorf("usage = %+v, want the buffer left to grow within the slack", u)
+	}
+	s.appendToBuffer(line, nil, true)
+	if u := s.scrollbackUsage(); u.Lines != 80 || u.DroppedLines != 11 {
+		t.Errorf("usage = %+v, want it cut back to the limit once past the slack", u)
+	}
+}
+
+func TestStatusReportsScrollback(t *testing.T) {
+	saved := flagScrollback
+	flagScrollback = 1 << 10
+	t.Cleanup(func() { flagScrollback = saved })
+	_, ts := startFakeShellServer(t)
+
+	c := testshell.Dial(t, ts.URL, "")
+	c.Send("echo " + strings.Repeat("x", 2000))
+	c.ExpectOutput("\r\n"+strings.Repeat("x", 2000)+"\r\n", testshell.DefaultTimeout)
+
+	resp, err := http.Get(ts.URL + "/status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var st sessionStatus
+	json.NewDecoder(resp.Body).Decode(&st)
+	resp.Body.Close()
+	sb := st.Scrollback
+	if sb.MaxBytes != 1<<10 || sb.MaxLines != *flagScrollbackLines || sb.Bytes > 1<<10 || sb.DroppedBytes < 2000 {
+		t.Errorf("/status scrollback = %+v, want at most 1024 bytes kept and the 2000-byte line dropped", sb)
+	}
+}
+
+func TestReplaySnapshotsTheScreen(t *testing.T) {
+	_, ts := startFakeShellServer(t)
+	first := testshell.Dial(t, ts.URL, "")
+	first.Send("echo kept-across-vim")
+	first.ExpectOutput("\r\nkept-across-vim\r\n", testshell.DefaultTimeout)
+	first.Send(`raw "\x1b[?1049h\x1b[Hediting\x1b[?1049l"`)
+	first.ExpectOutput("\x1b[?1049l", testshell.DefaultTimeout)
+
+	// The raw buffer was cleared when the full-screen program exited;
+	// the snapshot still has the line from before it.
+	second := testshell.Dial(t, ts.URL, "")
+	second.ExpectOutput("kept-across-vim\r\n", testshell.DefaultTimeout)
+
+	first.Send(`raw "\x1b[?1049h\x1b[2;1H\x1b[7mstill editing"`)
+	first.ExpectOutput("still editing", testshell.DefaultTimeout)
+	third := testshell.Dial(t, ts.URL, "")
+	third.ExpectOutput("\x1b[?1049h\x1b[2;1H\x1b[0;7mstill editing", testshell.DefaultTimeout)
+
+	// A raw replay leaves the program's screen for it to redraw
+	raw := testshell.Dial(t, ts.URL, "?replay=raw")
+	first.Send(`raw "redrawn\x21"`)
+	if out := raw.ExpectOutput("redrawn!", testshell.DefaultTimeout); strings.Contains(out, "\x1b[7mstill editing") || !strings.Contains(out, "\x1b[?1049h") {
+		t.Errorf("raw replay = %q, want it to switch to the alternate screen without the program's output", out)
+	}
+}
+
+func TestReplaySinceOffset(t *testing.T) {
+	s := newPumpTestServer()
+	s.scrollbackBytes = 0
+	s.scrollbackLines = 2
+	s.screen = vt.New(24, 80, 0)
+	var ends []int64
+	for _, line := range []string{"one\r\n", "two\r\n", "three\r\n", "$ "} {
+		end, _ := s.appendToBuffer([]byte(line), nil, false)
+		ends = append(ends, end)
+	}
+	if ends[3] != int64(len("one\r\ntwo\r\nthree\r\n$ ")) {
+		t.Fatalf("offsets = %v", ends)
+	}
+
+	s.outputEpoch = "this"
+
+	tests := []struct {
+		name  string
+		epoch string
+		since int64
+		want  string // "" for a full snapshot
+	}{
+		{"inside the buffer", "this", ends[1], "three\r\n$ "},
+		{"at the start of the buffer", "this", ends[0], "two\r\nthree\r\n$ "},
+		{"caught up", "this", ends[3], ""},
+		{"trimmed from the buffer", "this", ends[0] - 1, "snapshot"},
+		{"beyond the end", "this", ends[3] + 10, "snapshot"},
+		{"no offset", "this", -1, "snapshot"},
+		{"before a server restart", "earlier", ends[1], "snapshot"},
+		{"no epoch", "", ends[1], "snapshot"},
+	}
+	for _, tt := range tests {
+		got, end, _ := s.replay(false, tt.epoch, tt.since)
+		if end != ends[3] {
+			t.Errorf("%s: offset = %d, want %d", tt.name, end, ends[3])
+		}
+		if tt.want == "snapshot" {
+			if !strings.HasPrefix(string(got), "\x1bc") || !strings.Contains(string(got), "one\r\ntwo\r\nthree\r\n$ ") {
+				t.Errorf("%s: replay = %q, want a snapshot of the screen", tt.name, got)
+			}
+		} else if string(got) != tt.want {
+			t.Errorf("%s: replay = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestReconnectSinceOffset(t *testing.T) {
+	_, ts := startFakeShellServer(t)
+	first := testshell.Dial(t, ts.URL, "?offsets=1")
+	first.Send("echo one")
+	first.ExpectOutput("\r\none\r\n", testshell.DefaultTimeout)
+	if ev := first.ExpectEvent("offset", testshell.DefaultTimeout); ev["offset"].(float64) <= first.Ready["offset"].(float64) {
+		t.Errorf("offset event %v isn't past the ready offset %v", ev, first.Ready["offset"])
+	}
+
+	// A client that has seen everything so far, then drops
+	ready := testshell.Dial(t, ts.URL, "").Ready
+	mid := strconv.FormatInt(int64(ready["offset"].(float64)), 10)
+	epoch, _ := ready["epoch"].(string)
+	if epoch == "" {
+		t.Fatalf("ready = %v, want an epoch", ready)
+	}
+	first.Send("echo two")
+	first.ExpectOutput("\r\ntwo\r\n", testshell.DefaultTimeout)
+
+	back := testshell.Dial(t, ts.URL, "?offsets=1&epoch="+epoch+"&since="+mid)
+	got := back.ExpectOutput("\r\ntwo\r\n", testshell.DefaultTimeout)
+	if strings.Contains(got, "one") || strings.HasPrefix(got, "\x1bc") {
+		t.Errorf("resumed client got %q, want only the output after its offset", got)
+	}
+
+	// The same offset from another server process names other output
+	other := testshell.Dial(t, ts.URL, "?offsets=1&epoch=restarted&since="+mid)
+	if got := other.ExpectOutput("\r\ntwo\r\n", testshell.DefaultTimeout); !strings.HasPrefix(got, "\x1bc") || !strings.Contains(got, "one") {
+		t.Errorf("client from before a restart got %q, want a snapshot", got)
+	}
+}
+
+func TestBufferExport(t *testing.T) {
+	_, ts := startFakeShellServer(t)
+	c := testshell.Dial(t, ts.URL, "")
+	c.Send(`raw "\x1b[1;32mgreen\x1b[0m text\n"`)
+	c.ExpectOutput("green\x1b[0m text\r\n", testshell.DefaultTimeout)
+
+	get := func(query string) (*http.Response, string) {
+		t.Helper()
+		resp, err := http.Get(ts.URL + "/buffer" + query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp, string(body)
+	}
+
+	resp, text := get("")
+	if !strings.Contains(text, "\ngreen text\n") || strings.Contains(text, "\x1b") {
+		t.Errorf("text export = %q", text)
+	}
+	if got := resp.Header.Get("Content-Disposition"); got != `inline; filename="goshell-scrollback.txt"` {
+		t.Errorf("Content-Disposition = %q", got)
+	}
+	if _, raw := get("?format=raw"); !strings.Contains(raw, "\x1b[1;32mgreen\x1b[0m text") {
+		t.Errorf("raw export = %q", raw)
+	}
+	resp, html := get("?format=html")
+	if !strings.Contains(html, `<span style="color:#00cd00;font-weight:bold">green</span> text`) || resp.Header.Get("Content-Type") != "text/html; charset=utf-8" {
+		t.Errorf("html export = %q", html)
+	}
+	if resp, _ := get("?format=pdf"); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("unknown format = %d, want 400", resp.StatusCode)
+	}
+}
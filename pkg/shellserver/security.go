@@ -0,0 +1,294 @@
+package shellserver
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+var (
+	flagStrict       = Flags.Bool("strict", false, "refuse to start with insecure settings (default on when -addr is not loopback)")
+	flagTLSCert      = Flags.String("tls-cert", "", "serve HTTPS with this certificate file (needs -tls-key)")
+	flagTLSKey       = Flags.String("tls-key", "", "private key file for -tls-cert")
+	flagInsecureHTTP = Flags.Bool("insecure-http", false, "acknowledge serving plain HTTP on a non-loopback -addr under -strict")
+	flagAllowOrigin  stringListFlag
+	flagAnyOrigin    = Flags.Bool("allow-any-origin", false, "accept websocket connections from pages on any origin, for development")
+	flagCSRFCheck    = Flags.Bool("csrf-check", true, "refuse requests that change state, such as POST /exec, sent by a page on another origin than the server's own or an -allow-origin one")
+)
+
+func init() {
+	Flags.Var(&flagAllowOrigin, "allow-origin", "another origin, such as https://dash.example.com, whose pages may open the websocket (repeatable; the server's own origin always may)")
+}
+
+// securitySettings is what the strict-mode rules look at, gathered from
+// the flags so the rules can be tested without them.
+type securitySettings struct {
+	auth         bool
+	tls          bool
+	insecureHTTP bool
+	anyOrigin    bool
+	csrfCheck    bool
+
+	sanitizeWidgets bool
+}
+
+func currentSecuritySettings() securitySettings {
+	return securitySettings{
+		auth:         *flagAuth,
+		tls:          *flagTLSCert != "",
+		insecureHTTP: *flagInsecureHTTP,
+		anyOrigin:    *flagAnyOrigin,
+		csrfCheck:    *flagCSRFCheck,
+
+		sanitizeWidgets: *flagSanitizeWidgets,
+	}
+}
+
+// securityViolation is what a configuration gets wrong and the flags that
+// fix it.
+type securityViolation struct {
+	problem, fix string
+}
+
+// securityRule checks one setting, returning nil when it is safe.
+type securityRule func(securitySettings) *securityViolation
+
+// securityRules are checked in order by -strict and goshell doctor.
+var securityRules = []struct {
+	name  string
+	check securityRule
+}{
+	{"auth", ruleAuth},
+	{"origin", ruleOrigin},
+	{"csrf", ruleCSRF},
+	{"tls", ruleTransport},
+	{"widgets", ruleWidgets},
+}
+
+// ruleAuth requires that clients authenticate with the session token, so
+// reaching a strict server's address isn't enough to drive its shell.
+func ruleAuth(s securitySettings) *securityViolation {
+	if s.auth {
+		return nil
+	}
+	return &securityViolation{
+		problem: "authentication is off, so anyone who can reach -addr gets a shell",
+		fix:     "drop -auth=false, or -addr 127.0.0.1:7777",
+	}
+}
+
+// ruleOrigin requires that websocket upgrades check the Origin header;
+// with -allow-any-origin any page open in a browser on the network could
+// drive the shell.
+func ruleOrigin(s securitySettings) *securityViolation {
+	if !s.anyOrigin {
+		return nil
+	}
+	return &securityViolation{
+		problem: "websocket connections are accepted from any origin",
+		fix:     "drop -allow-any-origin, and list trusted pages with -allow-origin",
+	}
+}
+
+// ruleCSRF requires that requests which change state check their
+// Origin. A page elsewhere can't read the token, but it can post a form
+// to a URL carrying ?token= wherever that has leaked, such as into a
+// history or a log, or to any URL with -auth=false, and have it run.
+func ruleCSRF(s securitySettings) *securityViolation {
+	if s.csrfCheck {
+		return nil
+	}
+	return &securityViolation{
+		problem: "requests that change state are accepted from pages on any origin, so another site's form can post to /exec with a leaked ?token=",
+		fix:     "drop -csrf-check=false, and list trusted pages with -allow-origin",
+	}
+}
+
+// originAllowed reports whether a websocket upgrade may proceed: one
+// with no Origin header, which browsers always send, or from a page on
+// the server's own origin, by the Host it was reached at, or on an
+// -allow-origin origin.
+func originAllowed(r *http.Request) bool {
+	return *flagAnyOrigin || originListed(r)
+}
+
+// stateChanging reports whether r's method changes state on the server.
+func stateChanging(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return false
+	}
+	return true
+}
+
+// originListed reports whether r has no Origin header, as from a script
+// rather than a browser, or comes from a page on the server's own origin
+// or an -allow-origin one.
+func originListed(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	if strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	for _, allowed := range flagAllowOrigin {
+		if strings.EqualFold(strings.TrimSuffix(allowed, "/"), u.Scheme+"://"+u.Host) {
+			return true
+		}
+	}
+	return false
+}
+
+// ruleTransport requires TLS, or -insecure-http to acknowledge sending
+// keystrokes and output in the clear.
+func ruleTransport(s securitySettings) *securityViolation {
+	if s.tls || s.insecureHTTP {
+		return nil
+	}
+	return &securityViolation{
+		problem: "serving plain HTTP, so the session crosses the network unencrypted",
+		fix:     "-tls-cert and -tls-key, or -insecure-http to accept that",
+	}
+}
+
+// ruleWidgets requires that widget HTML be sanitized: the web UI puts it
+// in its own page, where a handler injected into a command's output would
+// run with the session's access.
+func ruleWidgets(s securitySettings) *securityViolation {
+	if s.sanitizeWidgets {
+		return nil
+	}
+	return &securityViolation{
+		problem: "widget HTML is stored unsanitized, so markup in a command's output can run script in the web UI",
+		fix:     "drop -sanitize-widgets=false",
+	}
+}
+
+// checkSecurity runs every rule against s, returning the violations
+// joined, or nil.
+func checkSecurity(s securitySettings) error {
+	var errs []error
+	for _, rule := range securityRules {
+		if v := rule.check(s); v != nil {
+			errs = append(errs, fmt.Errorf("%s: %s (fix: %s)", rule.name, v.problem, v.fix))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// serverScheme is the URL scheme goshell serves.
+func serverScheme() string {
+	if *flagTLSCert != "" {
+		return "https"
+	}
+	return "http"
+}
+
+// strictByDefault reports whether addr is reachable from other machines,
+// which makes -strict the default.
+func strictByDefault(addr string) bool {
+	return !loopbackAddr(addr)
+}
+
+// loopbackAddr reports whether the listen address addr only accepts
+// connections from this machine.
+func loopbackAddr(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// strictMode reports whether -strict applies: as given on the command
+// line, or by default for a non-loopback -addr.
+func strictMode() bool {
+	explicit := false
+	Flags.Visit(func(f *flag.Flag) {
+		if f.Name == "strict" {
+			explicit = true
+		}
+	})
+	if explicit {
+		return *flagStrict
+	}
+	return strictByDefault(*flagAddr)
+}
+
+// checkStartup validates the flags goshell was started with, enforcing
+// the security rules under -strict.
+func checkStartup() error {
+	if (*flagTLSCert == "") != (*flagTLSKey == "") {
+		return errors.New("-tls-cert and -tls-key must be given together")
+	}
+	for _, origin := range flagAllowOrigin {
+		if u, err := url.Parse(origin); err != nil || u.Scheme == "" || u.Host == "" || strings.TrimSuffix(u.Path, "/") != "" {
+			return fmt.Errorf("-allow-origin %q: want scheme://host[:port]", origin)
+		}
+	}
+	if !strictMode() {
+		return nil
+	}
+	if err := checkSecurity(currentSecuritySettings()); err != nil {
+		return fmt.Errorf("refusing to start with insecure settings (-strict=false to override):\n%s", indentErrors(err))
+	}
+	return nil
+}
+
+// indentErrors lists the errors joined in err, one per line.
+func indentErrors(err error) string {
+	return "  " + strings.ReplaceAll(err.Error(), "\n", "\n  ")
+}
+
+// runDoctor implements `goshell doctor`: it takes goshell's flags and
+// reports how each security rule judges them. It fails when -strict would
+// refuse to start.
+func runDoctor(args []string, w io.Writer) error {
+	if err := Flags.Parse(args); err != nil {
+		return err
+	}
+	strict := strictMode()
+	mode := "not strict"
+	if strict {
+		mode = "strict"
+	}
+	where := "loopback"
+	if !loopbackAddr(*flagAddr) {
+		where = "reachable from other machines"
+	}
+	fmt.Fprintf(w, "addr %s is %s; %s\n", *flagAddr, where, mode)
+
+	s := currentSecuritySettings()
+	failed := 0
+	for _, rule := range securityRules {
+		v := rule.check(s)
+		if v == nil {
+			fmt.Fprintf(w, "ok   %s\n", rule.name)
+			continue
+		}
+		failed++
+		fmt.Fprintf(w, "FAIL %s: %s\n     fix: %s\n", rule.name, v.problem, v.fix)
+	}
+	switch {
+	case failed == 0:
+		return nil
+	case strict:
+		return fmt.Errorf("%d of %d security rules fail; goshell would refuse to start", failed, len(securityRules))
+	}
+	fmt.Fprintf(w, "%d of %d security rules fail; tolerated without -strict\n", failed, len(securityRules))
+	return nil
+}
@@ -0,0 +1,231 @@
+package shellserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+
+	"shellserver/pkg/protocol"
+)
+
+func TestSecurityRules(t *testing.T) {
+	tests := []struct {
+		name string
+		rule securityRule
+		s    securitySettings
+		pass bool
+	}{
+		{"no auth", ruleAuth, securitySettings{}, false},
+		{"token", ruleAuth, securitySettings{auth: true}, true},
+		{"origin checked", ruleOrigin, securitySettings{}, true},
+		{"any origin", ruleOrigin, securitySettings{anyOrigin: true}, false},
+		{"plain http", ruleTransport, securitySettings{}, false},
+		{"tls", ruleTransport, securitySettings{tls: true}, true},
+		{"acknowledged http", ruleTransport, securitySettings{insecureHTTP: true}, true},
+		{"no csrf check", ruleCSRF, securitySettings{}, false},
+		{"csrf checked", ruleCSRF, securitySettings{csrfCheck: true}, true},
+		{"unsanitized widgets", ruleWidgets, securitySettings{}, false},
+		{"sanitized widgets", ruleWidgets, securitySettings{sanitizeWidgets: true}, true},
+	}
+	for _, tt := range tests {
+		v := tt.rule(tt.s)
+		if pass := v == nil; pass != tt.pass {
+			t.Errorf("%s: pass = %v, want %v (%+v)", tt.name, pass, tt.pass, v)
+		}
+		if v != nil && (v.problem == "" || !strings.Contains(v.fix, "-")) {
+			t.Errorf("%s: violation %+v should name the problem and a fixing flag", tt.name, v)
+		}
+	}
+}
+
+func TestCheckSecurityListsEveryViolation(t *testing.T) {
+	err := checkSecurity(securitySettings{anyOrigin: true})
+	if err == nil {
+		t.Fatal("insecure settings passed")
+	}
+	lines := strings.Split(err.Error(), "\n")
+	if len(lines) != len(securityRules) {
+		t.Fatalf("report %q has %d lines, want one per rule", err, len(lines))
+	}
+	for i, rule := range securityRules {
+		if !strings.HasPrefix(lines[i], rule.name+": ") || !strings.Contains(lines[i], "(fix: ") {
+			t.Errorf("line %d = %q, want %s's problem and fix", i, lines[i], rule.name)
+		}
+	}
+	if err := checkSecurity(securitySettings{anyOrigin: true, tls: true}); strings.Contains(err.Error(), "tls:") {
+		t.Errorf("report with TLS on still lists tls: %v", err)
+	}
+}
+
+func TestStrictByDefault(t *testing.T) {
+	for addr, want := range map[string]bool{
+		"127.0.0.1:7777":   false,
+		"127.0.0.2:7777":   false,
+		"localhost:7777":   false,
+		"[::1]:7777":       false,
+		":7777":            true,
+		"0.0.0.0:7777":     true,
+		"[::]:7777":        true,
+		"192.168.1.5:7777": true,
+		"myhost:7777":      true,
+		"garbage":          true,
+	} {
+		if got := strictByDefault(addr); got != want {
+			t.Errorf("strictByDefault(%q) = %v, want %v", addr, got, want)
+		}
+	}
+}
+
+func TestCheckStartup(t *testing.T) {
+	oldAddr, oldInsecure, oldCert, oldKey, oldAuth, oldAnyOrigin := *flagAddr, *flagInsecureHTTP, *flagTLSCert, *flagTLSKey, *flagAuth, *flagAnyOrigin
+	t.Cleanup(func() {
+		*flagAddr, *flagInsecureHTTP, *flagTLSCert, *flagTLSKey, *flagAuth, *flagAnyOrigin = oldAddr, oldInsecure, oldCert, oldKey, oldAuth, oldAnyOrigin
+	})
+	*flagAuth, *flagAnyOrigin = false, true
+
+	// Loopback is exempt from the rules
+	*flagAddr = "127.0.0.1:7777"
+	if err := checkStartup(); err != nil {
+		t.Errorf("loopback start refused: %v", err)
+	}
+
+	*flagAddr = "0.0.0.0:7777"
+	err := checkStartup()
+	if err == nil {
+		t.Fatal("insecure non-loopback start allowed")
+	}
+	for _, want := range []string{"-strict=false", "auth:", "origin:", "tls:", "-insecure-http"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("refusal %q missing %q", err, want)
+		}
+	}
+
+	*flagInsecureHTTP = true
+	if err := checkStartup(); err == nil || strings.Contains(err.Error(), "tls:") {
+		t.Errorf("with -insecure-http: %v, want only the other rules", err)
+	}
+
+	*flagAuth = true
+	if err := checkStartup(); err == nil || strings.Contains(err.Error(), "auth:") {
+		t.Errorf("with -auth: %v, want only the origin rule", err)
+	}
+
+	*flagAnyOrigin = false
+	if err := checkStartup(); err != nil {
+		t.Errorf("with every rule met: %v", err)
+	}
+
+	defer func(old stringListFlag) { flagAllowOrigin = old }(flagAllowOrigin)
+	flagAllowOrigin = stringListFlag{"dash.example.com"}
+	if err := checkStartup(); err == nil || !strings.Contains(err.Error(), "-allow-origin") {
+		t.Errorf("-allow-origin without a scheme: %v", err)
+	}
+	flagAllowOrigin = nil
+
+	*flagTLSCert = "cert.pem"
+	if err := checkStartup(); err == nil || !strings.Contains(err.Error(), "-tls-key") {
+		t.Errorf("-tls-cert without -tls-key: %v", err)
+	}
+}
+
+func TestOriginAllowed(t *testing.T) {
+	defer func(old stringListFlag) { flagAllowOrigin = old }(flagAllowOrigin)
+	defer func(old bool) { *flagAnyOrigin = old }(*flagAnyOrigin)
+	flagAllowOrigin = stringListFlag{"https://dash.example.com/"}
+
+	tests := []struct {
+		host, origin string
+		any, want    bool
+	}{
+		{"127.0.0.1:7777", "", false, true},
+		{"127.0.0.1:7777", "http://127.0.0.1:7777", false, true},
+		{"shell.example.com", "https://SHELL.example.com", false, true},
+		{"127.0.0.1:7777", "http://127.0.0.1:8080", false, false},
+		{"127.0.0.1:7777", "https://evil.example", false, false},
+		{"127.0.0.1:7777", "https://127.0.0.1:7777.evil.example", false, false},
+		{"127.0.0.1:7777", "null", false, false},
+		{"127.0.0.1:7777", "https://dash.example.com", false, true},
+		{"127.0.0.1:7777", "http://dash.example.com", false, false},
+		{"127.0.0.1:7777", "https://evil.example", true, true},
+	}
+	for _, tt := range tests {
+		*flagAnyOrigin = tt.any
+		r := httptest.NewRequest(http.MethodGet, "/ws/shell", nil)
+		r.Host = tt.host
+		if tt.origin != "" {
+			r.Header.Set("Origin", tt.origin)
+		}
+		if got := originAllowed(r); got != tt.want {
+			t.Errorf("Origin %q to %s (any %v) allowed = %v, want %v", tt.origin, tt.host, tt.any, got, tt.want)
+		}
+	}
+}
+
+func TestWebsocketFromOtherOriginRefused(t *testing.T) {
+	_, ts := startFakeShellServer(t)
+	header := http.Header{"Origin": {"https://evil.example"}}
+	_, resp, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+"/ws/shell", header)
+	if err == nil || resp == nil || resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("dial from another origin: %v, %+v, want 403", err, resp)
+	}
+	var body protocol.ErrorResponse
+	json.NewDecoder(resp.Body).Decode(&body)
+	if body.Error.Code != protocol.ErrOriginNotAllowed {
+		t.Errorf("error = %+v, want %s", body, protocol.ErrOriginNotAllowed)
+	}
+
+	header.Set("Origin", ts.URL)
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+"/ws/shell", header)
+	if err != nil {
+		t.Fatalf("dial from the server's own origin: %v", err)
+	}
+	conn.Close()
+}
+
+func TestCSRFCheck(t *testing.T) {
+	s, ts := startFakeShellServer(t)
+
+	post := func(origin string) *http.Response {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodPost, ts.URL+"/paste", strings.NewReader(`{"data":""}`))
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+	resp := post("https://evil.example")
+	if code := errorCode(t, resp); resp.StatusCode != http.StatusForbidden || code != protocol.ErrOriginNotAllowed {
+		t.Errorf("POST from another origin: %d %s, want 403 %s", resp.StatusCode, code, protocol.ErrOriginNotAllowed)
+	}
+	for _, origin := range []string{"", ts.URL} {
+		if resp := post(origin); resp.StatusCode != http.StatusNoContent {
+			t.Errorf("POST with Origin %q: %d, want 204", origin, resp.StatusCode)
+		}
+	}
+
+	// Reads are left to the token.
+	req, _ := http.NewRequest(http.MethodGet, ts.URL+"/status", nil)
+	req.Header.Set("Origin", "https://evil.example")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("GET from another origin: %d, want 200", resp.StatusCode)
+	}
+
+	s.csrfCheck = false
+	if resp := post("https://evil.example"); resp.StatusCode != http.StatusNoContent {
+		t.Errorf("POST from another origin with -csrf-check=false: %d, want 204", resp.StatusCode)
+	}
+}
@@ -0,0 +1,69 @@
+// Package shellserver is goshell's server: a shell on a PTY shared with
+// browser clients over a websocket, with the widget store and HTTP API
+// beside it. The goshell command is Main; another program can embed the
+// server with NewServer and mount its Handler on a mux of its own,
+// behind whatever authentication that program already has.
+package shellserver
+
+import (
+	"io/fs"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Options configures a server started by NewServer.
+type Options struct {
+	// Shell is the command the PTY runs, such as
+	// []string{"/bin/bash", "-l"}; empty for $GOSHELL_SHELL or $SHELL.
+	Shell []string
+	// BufferSize is how much output, in bytes, is kept for replay to
+	// new clients; 0 for Settings.Scrollback.
+	BufferSize int
+	// WebFS is the browser UI, index.html at its root with css/ and js/
+	// beside it; nil for -webroot or the UI built into goshell.
+	WebFS fs.FS
+	// Settings is the rest of the configuration; nil for
+	// DefaultSettings.
+	Settings *Settings
+}
+
+// NewServer starts a shell session as opts describe. The caller serves
+// it with Handler, and ends it with Shutdown or Close.
+func NewServer(opts Options) (*ShellServer, error) {
+	if len(opts.Shell) == 0 {
+		argv, err := resolveShell(shellChoices("", os.Getenv))
+		if err != nil {
+			return nil, err
+		}
+		opts.Shell = argv
+	}
+	if opts.Settings == nil {
+		set := DefaultSettings()
+		opts.Settings = &set
+	}
+	return newServer(opts)
+}
+
+// Handler returns the UI and every API route, with goshell's logging,
+// panic recovery and body limit, served under prefix, such as "/shell"
+// for mux.Handle("/shell/", s.Handler("/shell")). The UI is at prefix/.
+func (s *ShellServer) Handler(prefix string) http.Handler {
+	mux := http.NewServeMux()
+	s.mount(mux)
+	h := withMiddleware(mux)
+	if prefix = strings.TrimSuffix(prefix, "/"); prefix != "" {
+		h = http.StripPrefix(prefix, h)
+	}
+	return h
+}
+
+// mount registers the UI and the API routes on mux.
+func (s *ShellServer) mount(mux *http.ServeMux) {
+	ui, where := s.webFS, "Options.WebFS"
+	if ui == nil {
+		ui, where = webUI()
+	}
+	s.registerUI(mux, ui, where)
+	s.registerRoutes(mux)
+}
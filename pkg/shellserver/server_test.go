@@ -0,0 +1,60 @@
+package shellserver
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"shellserver/internal/testshell"
+)
+
+func TestHandlerUnderPrefix(t *testing.T) {
+	s, err := NewServer(Options{
+		Shell:      testshell.Command(),
+		BufferSize: 32 << 10,
+		WebFS:      fstest.MapFS{"index.html": {Data: []byte("<title>embedded</title>")}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	if s.scrollbackBytes != 32<<10 {
+		t.Errorf("scrollback %d bytes, want %d", s.scrollbackBytes, 32<<10)
+	}
+
+	// The embedding program's own routes and auth stay in front
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) { io.WriteString(w, "host app") })
+	mux.Handle("/shell/", s.Handler("/shell"))
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/shell/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(body), "embedded") {
+		t.Errorf("GET /shell/ = %q, want the Options.WebFS UI", body)
+	}
+	if st := getStatus(t, ts.URL+"/shell"); st.Shell.State == "" {
+		t.Errorf("/shell/status has no shell state: %+v", st)
+	}
+	resp, err = http.Get(ts.URL + "/status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "host app" {
+		t.Errorf("GET /status outside the prefix = %q", body)
+	}
+
+	c := testshell.Dial(t, ts.URL+"/shell", "")
+	c.Send("echo embedded-shell")
+	c.ExpectOutput("\r\nembedded-shell\r\n", testshell.DefaultTimeout)
+}
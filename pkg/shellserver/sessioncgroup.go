@@ -0,0 +1,194 @@
+package shellserver
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"shellserver/internal/procstats"
+)
+
+var (
+	flagSessionCgroup    = Flags.Bool("session-cgroup", true, "run the shell in a cgroup of its own, for usage accounting and limits, where cgroup v2 is delegated to goshell")
+	flagSessionMemoryMax = Flags.Int64("session-memory-max", 0, "bytes of memory the shell and everything it runs may use (0 for no limit; needs a session cgroup)")
+	flagSessionCPUMax    = Flags.Float64("session-cpu-max", 0, "CPU cores the shell and everything it runs may use, e.g. 1.5 (0 for no limit; needs a session cgroup)")
+)
+
+// sessionCgroup is the cgroup v2 group a session's shell runs in, so the
+// CPU and memory of everything the shell starts is accounted, and can be
+// limited, together. Restarted shells reuse the group.
+//
+// A nil *sessionCgroup is valid: the shell runs in goshell's own cgroup
+// and its usage is added up over its process tree instead.
+type sessionCgroup struct {
+	name      string
+	memoryMax int64   // bytes; 0 for no limit
+	cpuMax    float64 // cores; 0 for no limit
+
+	mu    sync.Mutex
+	group *procstats.Group
+}
+
+// sessionUsage is what a session's shell, and everything it runs, has
+// used.
+type sessionUsage struct {
+	Source      string  `json:"source"`      // "cgroup", or "procfs" when added up over the process tree
+	CPUSeconds  float64 `json:"cpu_seconds"` // cumulative
+	MemoryBytes int64   `json:"memory_bytes"`
+	MemoryMax   int64   `json:"memory_max,omitempty"`
+	CPUMax      float64 `json:"cpu_max,omitempty"`
+}
+
+// newSessionCgroup creates goshell-<session>-<pid> below goshell's own
+// cgroup, with the given limits.
+func newSessionCgroup(session string, memoryMax int64, cpuMax float64) (*sessionCgroup, error) {
+	c := &sessionCgroup{
+		name:      fmt.Sprintf("goshell-%s-%d", session, os.Getpid()),
+		memoryMax: memoryMax,
+		cpuMax:    cpuMax,
+	}
+	if err := c.open(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// openSessionCgroup creates the session cgroup set asks for. Without
+// one, usage is still reported but limits can't be enforced, so asking
+// for limits that can't be had is an error.
+func openSessionCgroup(session string, set Settings) (*sessionCgroup, error) {
+	limited := set.SessionMemoryMax > 0 || set.SessionCPUMax > 0
+	if !set.SessionCgroup {
+		if limited {
+			return nil, errors.New("-session-memory-max and -session-cpu-max need -session-cgroup")
+		}
+		return nil, nil
+	}
+	c, err := newSessionCgroup(session, set.SessionMemoryMax, set.SessionCPUMax)
+	if err != nil {
+		if limited {
+			return nil, fmt.Errorf("session cgroup for limits: %w", err)
+		}
+		log.Printf("session cgroup: %v; reporting usage from procfs", err)
+		return nil, nil
+	}
+	return c, nil
+}
+
+// open creates the group, or finds it, and writes its limits.
+func (c *sessionCgroup) open() error {
+	g, err := procstats.Host.NewGroup(c.name, fmt.Sprintf("goshell-server-%d", os.Getpid()))
+	if err != nil {
+		return err
+	}
+	if c.memoryMax > 0 || c.cpuMax > 0 {
+		if err := g.SetLimits(c.memoryMax, c.cpuMax); err != nil {
+			return fmt.Errorf("set limits: %w", err)
+		}
+	}
+	c.mu.Lock()
+	c.group = g
+	c.mu.Unlock()
+	return nil
+}
+
+// dir returns the group's directory in cgroupfs.
+func (c *sessionCgroup) dir() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.group.Dir
+}
+
+// reset readies the group for a restarted shell: it is reused, or
+// recreated if something removed it.
+func (c *sessionCgroup) reset() error {
+	if c == nil {
+		return nil
+	}
+	if _, err := os.Stat(c.dir()); err == nil {
+		return nil
+	}
+	return c.open()
+}
+
+// add moves pid into the group, for a shell that couldn't be started in
+// it.
+func (c *sessionCgroup) add(pid int) {
+	c.mu.Lock()
+	g := c.group
+	c.mu.Unlock()
+	if err := g.Add(pid); err != nil {
+		log.Printf("session cgroup: add shell: %v", err)
+	}
+}
+
+// usage reads the group's usage.
+func (c *sessionCgroup) usage() (sessionUsage, error) {
+	c.mu.Lock()
+	g := c.group
+	c.mu.Unlock()
+	u, err := g.Usage()
+	if err != nil {
+		return sessionUsage{}, err
+	}
+	return sessionUsage{
+		Source:      "cgroup",
+		CPUSeconds:  u.CPU.Seconds(),
+		MemoryBytes: u.Memory,
+		MemoryMax:   c.memoryMax,
+		CPUMax:      c.cpuMax,
+	}, nil
+}
+
+// source names where usage comes from, for the capabilities.
+func (c *sessionCgroup) source() string {
+	if c == nil {
+		return "procfs"
+	}
+	return "cgroup"
+}
+
+// remove deletes the group when the session ends. The hung-up shell may
+// take a moment to exit, and the kernel refuses to remove a group with
+// processes in it, so remove waits up to a second for them.
+func (c *sessionCgroup) remove() error {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	g := c.group
+	c.mu.Unlock()
+	var err error
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(50 * time.Millisecond) {
+		err = g.Remove()
+		if err == nil || errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("remove session cgroup: %w", err)
+		}
+	}
+}
+
+// sessionUsage reports what the shell has used, from its cgroup or,
+// failing that, from procfs.
+func (s *ShellServer) sessionUsage() sessionUsage {
+	if s.cgroup != nil {
+		u, err := s.cgroup.usage()
+		if err == nil {
+			return u
+		}
+		log.Printf("session cgroup: %v", err)
+	}
+	s.ptyMu.Lock()
+	shellPID := s.shellPGID // the shell leads its process group
+	s.ptyMu.Unlock()
+	u, err := procstats.Host.TreeUsage(shellPID)
+	if err != nil {
+		log.Printf("session usage: %v", err)
+	}
+	return sessionUsage{Source: "procfs", CPUSeconds: u.CPU.Seconds(), MemoryBytes: u.Memory}
+}
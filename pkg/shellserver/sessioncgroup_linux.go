@@ -0,0 +1,29 @@
+package shellserver
+
+import (
+	"log"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// place arranges for cmd to start inside the group, with clone3's
+// CLONE_INTO_CGROUP, so nothing the shell forks early escapes it. release
+// closes the group's descriptor once cmd has started; ok is false if cmd
+// will start outside the group.
+func (c *sessionCgroup) place(cmd *exec.Cmd) (release func(), ok bool) {
+	if c == nil {
+		return func() {}, false
+	}
+	f, err := os.Open(c.dir())
+	if err != nil {
+		log.Printf("session cgroup: %v", err)
+		return func() {}, false
+	}
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.UseCgroupFD = true
+	cmd.SysProcAttr.CgroupFD = int(f.Fd())
+	return func() { f.Close() }, true
+}
@@ -0,0 +1,91 @@
+package shellserver
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func setSessionCgroupFlags(t *testing.T, enabled bool, memoryMax int64, cpuMax float64) {
+	t.Helper()
+	oldEnabled, oldMemory, oldCPU := *flagSessionCgroup, *flagSessionMemoryMax, *flagSessionCPUMax
+	*flagSessionCgroup, *flagSessionMemoryMax, *flagSessionCPUMax = enabled, memoryMax, cpuMax
+	t.Cleanup(func() {
+		*flagSessionCgroup, *flagSessionMemoryMax, *flagSessionCPUMax = oldEnabled, oldMemory, oldCPU
+	})
+}
+
+func TestSessionUsageFromProcfs(t *testing.T) {
+	setSessionCgroupFlags(t, false, 0, 0)
+	s, ts := startFakeShellServer(t)
+	if s.cgroup != nil || s.capabilities()["session-usage"] != "procfs" {
+		t.Fatalf("cgroup %v, capability %v with -session-cgroup=false", s.cgroup, s.capabilities()["session-usage"])
+	}
+
+	st := getStatus(t, ts.URL)
+	if st.Usage.Source != "procfs" || st.Usage.MemoryBytes <= 0 || st.Usage.CPUSeconds < 0 {
+		t.Errorf("usage = %+v, want the shell's from procfs", st.Usage)
+	}
+
+	if err := s.restart(); err != nil {
+		t.Fatal(err)
+	}
+	if st := getStatus(t, ts.URL); st.Usage.Source != "procfs" || st.Usage.MemoryBytes <= 0 {
+		t.Errorf("usage after restart = %+v", st.Usage)
+	}
+}
+
+func TestSessionLimitsNeedCgroup(t *testing.T) {
+	setSessionCgroupFlags(t, false, 1<<30, 0)
+	if _, err := openSessionCgroup("test", flagSettings()); err == nil {
+		t.Error("limits accepted without a session cgroup")
+	}
+}
+
+// TestSessionCgroup needs a delegated cgroup v2 hierarchy, so it only
+// runs with GOSHELL_TEST_CGROUP=1.
+func TestSessionCgroup(t *testing.T) {
+	if os.Getenv("GOSHELL_TEST_CGROUP") != "1" {
+		t.Skip("set GOSHELL_TEST_CGROUP=1 to test in a delegated cgroup v2 hierarchy")
+	}
+	setSessionCgroupFlags(t, true, 256<<20, 0.5)
+	s, ts := startFakeShellServer(t)
+	if s.cgroup == nil {
+		t.Fatal("no session cgroup")
+	}
+	dir := s.cgroup.dir()
+
+	inGroup := func() bool {
+		s.ptyMu.Lock()
+		pid := s.shellPGID
+		s.ptyMu.Unlock()
+		data, _ := os.ReadFile("/proc/" + strconv.Itoa(pid) + "/cgroup")
+		return strings.HasSuffix(strings.TrimSpace(string(data)), "/"+filepath.Base(dir))
+	}
+	if !inGroup() {
+		t.Error("shell not in the session cgroup")
+	}
+	st := getStatus(t, ts.URL)
+	if st.Usage.Source != "cgroup" || st.Usage.MemoryMax != 256<<20 || st.Usage.CPUMax != 0.5 {
+		t.Errorf("usage = %+v", st.Usage)
+	}
+	if max, _ := os.ReadFile(filepath.Join(dir, "cpu.max")); strings.TrimSpace(string(max)) != "50000 100000" {
+		t.Errorf("cpu.max = %q", max)
+	}
+
+	if err := s.restart(); err != nil {
+		t.Fatal(err)
+	}
+	if !inGroup() {
+		t.Error("restarted shell not in the session cgroup")
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("session cgroup still there after Close: %v", err)
+	}
+}
@@ -0,0 +1,189 @@
+package shellserver
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"shellserver/internal/persist"
+)
+
+var (
+	flagPersistSession  = Flags.Bool("persist-session", false, "keep the scrollback and HTML widgets in -state-dir, so they survive the server restarting")
+	flagPersistInterval = Flags.Duration("persist-interval", 30*time.Second, "how often -persist-session saves, besides on shutdown")
+)
+
+// restoredNote marks in the scrollback where output from before a server
+// restart ends.
+const restoredNote = "\r\n\x1b[33m--- goshell restarted; output above is from the previous shell ---\x1b[0m\r\n"
+
+// sessionState is the manifest -persist-session writes to
+// <state-dir>/session/manifest.json. Each widget's HTML is in its own
+// file beside it, widgets/<id>.html, and the scrollback in
+// scrollback.json.
+type sessionState struct {
+	Saved       time.Time     `json:"saved"`
+	HTMLCounter int           `json:"html_counter"`
+	Widgets     []savedWidget `json:"widgets"`
+}
+
+// savedWidget is a widget in the manifest. Stale widgets were made by a
+// shell from before the server last restarted, so whatever generated
+// them is gone.
+type savedWidget struct {
+	ID     int    `json:"id"`
+	File   string `json:"file"`
+	SHA256 string `json:"sha256"`
+	Stale  bool   `json:"stale"`
+}
+
+// savedScrollback is scrollback.json: the raw replay buffer.
+type savedScrollback struct {
+	Output []byte `json:"output"`
+}
+
+// sessionDir is where -persist-session keeps the session.
+func sessionDir(stateDir string) string {
+	return filepath.Join(stateDir, "session")
+}
+
+// loadSession restores the scrollback and widgets a previous server
+// saved in dir, marking the widgets stale. It runs before the PTY is
+// served. Damaged files are skipped with a warning: losing history
+// mustn't keep the session from starting.
+func (s *ShellServer) loadSession(dir string) {
+	var state sessionState
+	if err := persist.Load(filepath.Join(dir, "manifest.json"), &state); err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			log.Printf("session state: %v; starting without it", err)
+		}
+		return
+	}
+
+	s.htmlWidgetsMu.Lock()
+	restored := 0
+	for _, w := range state.Widgets {
+		content, err := readSavedWidget(dir, w)
+		if err != nil {
+			log.Printf("session state: skipping widget %d: %v", w.ID, err)
+			continue
+		}
+		// A persistent -store may have kept it already
+		if _, ok := s.widgetHTML(w.ID); !ok {
+			if err := s.putWidget(w.ID, content); err != nil {
+				log.Printf("session state: restore widget %d: %v", w.ID, err)
+				continue
+			}
+		}
+		s.staleWidgets[w.ID] = true
+		s.savedWidgets[w.ID] = w.SHA256
+		restored++
+	}
+	s.htmlCounter = max(s.htmlCounter, state.HTMLCounter)
+	s.htmlWidgetsMu.Unlock()
+
+	var scrollback savedScrollback
+	if err := persist.Load(filepath.Join(dir, "scrollback.json"), &scrollback); err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			log.Printf("session state: skipping the scrollback: %v", err)
+		}
+	} else if len(scrollback.Output) > 0 {
+		s.appendToBuffer(scrollback.Output, nil, true)
+		s.appendToBuffer([]byte(restoredNote), nil, true)
+	}
+	log.Printf("session state: restored %d widgets and %d bytes of scrollback saved %s", restored, len(scrollback.Output), state.Saved.Format(time.RFC3339))
+}
+
+// readSavedWidget reads w's HTML, checking it against its checksum.
+func readSavedWidget(dir string, w savedWidget) ([]byte, error) {
+	if w.ID <= 0 || w.File != filepath.Base(w.File) {
+		return nil, fmt.Errorf("bad manifest entry %+v", w)
+	}
+	content, err := os.ReadFile(filepath.Join(dir, "widgets", w.File))
+	if err != nil {
+		return nil, err
+	}
+	if sum := sha256.Sum256(content); hex.EncodeToString(sum[:]) != w.SHA256 {
+		return nil, fmt.Errorf("%s: %w: checksum mismatch", w.File, persist.ErrCorrupt)
+	}
+	return content, nil
+}
+
+// saveSession writes the scrollback and widgets to dir. Widget files are
+// written when their content changes and removed once the widget is
+// evicted; the manifest goes last, so it only names files in place.
+func (s *ShellServer) saveSession(dir string) error {
+	s.saveMu.Lock()
+	defer s.saveMu.Unlock()
+	widgetDir := filepath.Join(dir, "widgets")
+	if err := os.MkdirAll(widgetDir, 0o700); err != nil {
+		return err
+	}
+
+	state := sessionState{Saved: time.Now()}
+	s.htmlWidgetsMu.RLock()
+	state.HTMLCounter = s.htmlCounter
+	for _, id := range s.widgetIDs() {
+		content, ok := s.widgetHTML(id)
+		if !ok {
+			continue
+		}
+		sum := sha256.Sum256([]byte(content))
+		w := savedWidget{ID: id, File: strconv.Itoa(id) + ".html", SHA256: hex.EncodeToString(sum[:]), Stale: s.staleWidgets[id]}
+		state.Widgets = append(state.Widgets, w)
+		if s.savedWidgets[id] == w.SHA256 {
+			continue
+		}
+		if err := persist.WriteFile(filepath.Join(widgetDir, w.File), []byte(content), 0o600); err != nil {
+			s.htmlWidgetsMu.RUnlock()
+			return err
+		}
+		s.savedWidgets[id] = w.SHA256
+	}
+	var gone []int
+	for id := range s.savedWidgets {
+		if _, ok := s.widgetHTML(id); !ok {
+			gone = append(gone, id)
+		}
+	}
+	s.htmlWidgetsMu.RUnlock()
+
+	s.bufferMu.Lock()
+	scrollback := savedScrollback{Output: append([]byte(nil), s.buffer...)}
+	s.bufferMu.Unlock()
+	if err := persist.Save(filepath.Join(dir, "scrollback.json"), scrollback); err != nil {
+		return err
+	}
+	if err := persist.Save(filepath.Join(dir, "manifest.json"), state); err != nil {
+		return err
+	}
+
+	for _, id := range gone {
+		os.Remove(filepath.Join(widgetDir, strconv.Itoa(id)+".html"))
+		delete(s.savedWidgets, id)
+	}
+	return nil
+}
+
+// persistSession saves the session every interval until stop is closed.
+func (s *ShellServer) persistSession(dir string, interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		if err := s.saveSession(dir); err != nil {
+			log.Printf("session state: save: %v", err)
+		}
+	}
+}
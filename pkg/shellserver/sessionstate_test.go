@@ -0,0 +1,129 @@
+package shellserver
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"shellserver/internal/testshell"
+)
+
+// persistTo turns -persist-session on with its state in dir for the
+// rest of the test.
+func persistTo(t *testing.T, dir string) {
+	t.Helper()
+	old, oldDir := *flagPersistSession, *flagStateDir
+	*flagPersistSession, *flagStateDir = true, dir
+	t.Cleanup(func() { *flagPersistSession, *flagStateDir = old, oldDir })
+}
+
+func TestSessionSurvivesRestart(t *testing.T) {
+	persistTo(t, t.TempDir())
+
+	s, ts := startFakeShellServer(t)
+	c := testshell.Dial(t, ts.URL, "")
+	c.Send("echo before-restart")
+	c.ExpectOutput("\r\nbefore-restart\r\n", testshell.DefaultTimeout)
+	_, _, ids, _ := s.extractAndStoreHTML([]byte(string(htmlStartMarker) + "<div>kept widget</div>" + string(htmlEndMarker)))
+	if len(ids) != 1 {
+		t.Fatalf("stored widgets %v", ids)
+	}
+	old := ids[0]
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	ts.Close()
+
+	s2, ts2 := startFakeShellServer(t)
+	resp, err := http.Get(ts2.URL + "/htmlwidget/" + strconv.Itoa(old))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("X-Widget-Stale") != "1" {
+		t.Errorf("restored widget %d: status %d, X-Widget-Stale %q", old, resp.StatusCode, resp.Header.Get("X-Widget-Stale"))
+	}
+	if list := s2.listHTMLWidgets(); len(list) != 1 || !list[0].Stale {
+		t.Errorf("widget list %+v, want the one stale widget", list)
+	}
+	_, _, ids, _ = s2.extractAndStoreHTML([]byte(string(htmlStartMarker) + "<div>new</div>" + string(htmlEndMarker)))
+	if len(ids) != 1 || ids[0] <= old {
+		t.Errorf("new widget got ID %v, want one after %d", ids, old)
+	}
+
+	c2 := testshell.Dial(t, ts2.URL, "?replay=raw")
+	c2.ExpectOutput("before-restart", testshell.DefaultTimeout)
+	c2.ExpectOutput("goshell restarted", testshell.DefaultTimeout)
+}
+
+func TestSessionSkipsDamagedState(t *testing.T) {
+	dir := t.TempDir()
+	persistTo(t, dir)
+
+	s, _ := startFakeShellServer(t)
+	for _, html := range []string{"<p>good</p>", "<p>damaged</p>"} {
+		s.extractAndStoreHTML([]byte(string(htmlStartMarker) + html + string(htmlEndMarker)))
+	}
+	s.Close()
+
+	session := sessionDir(dir)
+	if err := os.WriteFile(filepath.Join(session, "widgets", "2.html"), []byte("<p>tampered</p>"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"scrollback.json", "scrollback.json.bak"} {
+		os.WriteFile(filepath.Join(session, name), []byte("goshell-state 1 3 00\n{}}"), 0o600)
+	}
+	logs := captureLog(t)
+
+	s2, _ := startFakeShellServer(t)
+	if _, ok := s2.widgetHTML(1); !ok {
+		t.Error("the intact widget wasn't restored")
+	}
+	if _, ok := s2.widgetHTML(2); ok {
+		t.Error("the damaged widget was restored")
+	}
+	for _, want := range []string{"skipping widget 2", "skipping the scrollback"} {
+		if !strings.Contains(logs.String(), want) {
+			t.Errorf("log missing %q:\n%s", want, logs)
+		}
+	}
+
+	// A manifest beyond repair starts the session without it
+	s2.Close()
+	for _, name := range []string{"manifest.json", "manifest.json.bak"} {
+		os.WriteFile(filepath.Join(session, name), []byte("not json"), 0o600)
+	}
+	s3, _ := startFakeShellServer(t)
+	if ids := s3.widgetIDs(); len(ids) != 0 {
+		t.Errorf("widgets %v restored from a broken manifest", ids)
+	}
+}
+
+func TestSessionSavesPeriodically(t *testing.T) {
+	dir := t.TempDir()
+	persistTo(t, dir)
+	defer func(old time.Duration) { *flagPersistInterval = old }(*flagPersistInterval)
+	*flagPersistInterval = 50 * time.Millisecond
+
+	s, _ := startFakeShellServer(t)
+	s.extractAndStoreHTML([]byte(string(htmlStartMarker) + "<p>saved</p>" + string(htmlEndMarker)))
+	waitFor(t, "the widget saved", func() bool {
+		_, err := os.Stat(filepath.Join(sessionDir(dir), "widgets", "1.html"))
+		return err == nil
+	})
+
+	// An evicted widget's file goes with the next save
+	s.extractAndStoreHTML([]byte(string(htmlStartMarker) + "<p>newer</p>" + string(htmlEndMarker)))
+	s.htmlWidgetsMu.Lock()
+	s.widgetLimit = 1
+	s.trimWidgets(time.Now())
+	s.htmlWidgetsMu.Unlock()
+	waitFor(t, "the evicted widget's file removed", func() bool {
+		_, err := os.Stat(filepath.Join(sessionDir(dir), "widgets", "1.html"))
+		return os.IsNotExist(err)
+	})
+}
@@ -0,0 +1,140 @@
+package shellserver
+
+import (
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	flagTmpdirQuota = Flags.Int64("tmpdir-quota", 1<<30, "bytes the session temp dir ($GOSHELL_TMPDIR) may hold before its oldest files are removed (0 for no limit)")
+	flagKeepTmpdir  = Flags.Bool("keep-tmpdir", false, "keep the session temp dir when the shell restarts or the server exits")
+)
+
+// sessionTmpCheckInterval is the least time between quota checks driven
+// by shell output.
+const sessionTmpCheckInterval = 10 * time.Second
+
+// sessionTmp is a session's scratch directory, exported to the shell as
+// GOSHELL_TMPDIR for tools that need somewhere to put extracted or
+// intermediate files. Its size is only measured lazily, when /status asks
+// or shell output arrives after a quiet spell; a check that finds it over
+// quota removes the least recently modified files until it fits.
+//
+// A nil *sessionTmp is valid and does nothing.
+type sessionTmp struct {
+	dir   string
+	quota int64 // bytes; 0 for no limit
+	keep  bool  // leave the directory behind on restart and close
+
+	mu        sync.Mutex
+	size      int64 // as of the last check
+	lastCheck time.Time
+}
+
+// newSessionTmp creates os.TempDir()/goshell-<session>-<random>.
+func newSessionTmp(session string, quota int64, keep bool) (*sessionTmp, error) {
+	dir, err := os.MkdirTemp("", "goshell-"+session+"-")
+	if err != nil {
+		return nil, err
+	}
+	return &sessionTmp{dir: dir, quota: quota, keep: keep}, nil
+}
+
+// env returns the shell environment entries for t.
+func (t *sessionTmp) env() []string {
+	if t == nil {
+		return nil
+	}
+	return []string{"GOSHELL_TMPDIR=" + t.dir}
+}
+
+// check measures the directory, enforcing the quota, and returns its size.
+func (t *sessionTmp) check(now time.Time) int64 {
+	if t == nil {
+		return 0
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.lastCheck = now
+
+	type file struct {
+		path    string
+		size    int64
+		modTime time.Time
+	}
+	var files []file
+	var total int64
+	filepath.WalkDir(t.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		files = append(files, file{path, info.Size(), info.ModTime()})
+		total += info.Size()
+		return nil
+	})
+
+	if t.quota > 0 && total > t.quota {
+		sort.Slice(files, func(i, j int) bool { return files[i].modTime.Before(files[j].modTime) })
+		for _, f := range files {
+			if total <= t.quota {
+				break
+			}
+			if err := os.Remove(f.path); err != nil {
+				log.Printf("session tmpdir: evict %s: %v", f.path, err)
+				continue
+			}
+			log.Printf("session tmpdir: over %d byte quota, removed %s", t.quota, f.path)
+			total -= f.size
+		}
+	}
+	t.size = total
+	return total
+}
+
+// checkSoon starts a check in the background unless one ran within
+// sessionTmpCheckInterval.
+func (t *sessionTmp) checkSoon(now time.Time) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	due := now.Sub(t.lastCheck) >= sessionTmpCheckInterval
+	if due {
+		t.lastCheck = now // claim this check before releasing the lock
+	}
+	t.mu.Unlock()
+	if due {
+		go t.check(now)
+	}
+}
+
+// reset empties the directory for a restarted shell, unless it is kept.
+func (t *sessionTmp) reset() error {
+	if t == nil || t.keep {
+		return nil
+	}
+	if err := os.RemoveAll(t.dir); err != nil {
+		return err
+	}
+	t.mu.Lock()
+	t.size = 0
+	t.mu.Unlock()
+	return os.Mkdir(t.dir, 0o700)
+}
+
+// remove deletes the directory when the session ends, unless it is kept.
+func (t *sessionTmp) remove() error {
+	if t == nil || t.keep {
+		return nil
+	}
+	return os.RemoveAll(t.dir)
+}
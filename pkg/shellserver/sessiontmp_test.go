@@ -0,0 +1,109 @@
+package shellserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"shellserver/internal/testshell"
+)
+
+func writeTmpFile(t *testing.T, dir, name string, size int, modTime time.Time) {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, make([]byte, size), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSessionTmpdirLifecycle(t *testing.T) {
+	s, ts := startFakeShellServer(t)
+	dir := s.sessionTmp.dir
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		t.Fatalf("session tmpdir %q not created: %v", dir, err)
+	}
+
+	c := testshell.Dial(t, ts.URL, "")
+	c.Send("env GOSHELL_TMPDIR")
+	c.ExpectOutput("\r\n"+dir+"\r\n", testshell.DefaultTimeout)
+
+	writeTmpFile(t, dir, "extracted/data.bin", 1500, time.Now())
+	resp, err := http.Get(ts.URL + "/status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var st sessionStatus
+	json.NewDecoder(resp.Body).Decode(&st)
+	resp.Body.Close()
+	if st.Tmpdir != dir || st.TmpdirSize != 1500 || st.TmpdirQuota != *flagTmpdirQuota {
+		t.Errorf("/status = %+v, want %s holding 1500 bytes", st, dir)
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("session tmpdir still there after Close: %v", err)
+	}
+}
+
+func TestSessionTmpQuota(t *testing.T) {
+	tmp, err := newSessionTmp("test", 1000, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tmp.remove()
+
+	now := time.Now()
+	writeTmpFile(t, tmp.dir, "oldest", 600, now.Add(-time.Hour))
+	writeTmpFile(t, tmp.dir, "sub/older", 300, now.Add(-time.Minute))
+	writeTmpFile(t, tmp.dir, "newest", 400, now)
+
+	if got := tmp.check(now); got != 700 {
+		t.Errorf("size after check = %d, want 700 once the oldest file is gone", got)
+	}
+	if _, err := os.Stat(filepath.Join(tmp.dir, "oldest")); !os.IsNotExist(err) {
+		t.Error("oldest file survived going over quota")
+	}
+
+	tmp.quota = 0
+	writeTmpFile(t, tmp.dir, "big", 5000, now)
+	if got := tmp.check(now); got != 5700 {
+		t.Errorf("size without a quota = %d, want 5700", got)
+	}
+}
+
+func TestSessionTmpResetAndKeep(t *testing.T) {
+	for _, keep := range []bool{false, true} {
+		tmp, err := newSessionTmp("test", 0, keep)
+		if err != nil {
+			t.Fatal(err)
+		}
+		writeTmpFile(t, tmp.dir, "f", 10, time.Now())
+
+		if err := tmp.reset(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat(filepath.Join(tmp.dir, "f")); os.IsNotExist(err) == keep {
+			t.Errorf("keep=%v: file present after restart = %v", keep, !os.IsNotExist(err))
+		}
+		if _, err := os.Stat(tmp.dir); err != nil {
+			t.Errorf("keep=%v: tmpdir gone after restart: %v", keep, err)
+		}
+
+		tmp.remove()
+		if _, err := os.Stat(tmp.dir); os.IsNotExist(err) == keep {
+			t.Errorf("keep=%v: tmpdir present after close = %v", keep, !os.IsNotExist(err))
+		}
+		os.RemoveAll(tmp.dir)
+	}
+}
@@ -0,0 +1,152 @@
+package shellserver
+
+import "time"
+
+// Settings is the server's configuration beyond Options' own fields: what
+// goshell takes from its flags, each field named after its flag. Main
+// fills it from the parsed flags; DefaultSettings has their defaults.
+type Settings struct {
+	Addr  string // -addr, the server's address, for the shell's GOSHELL_URL and share links
+	Token string // -token clients present; "" leaves authentication to the program
+
+	Config  string // -config file of profiles and reloadable settings
+	Profile string // -profile from Config the shell starts with
+
+	Rows, Cols      int    // -rows and -cols of the terminal the shell starts in
+	ResizePolicy    string // -resize-policy: "last-writer" or "smallest"
+	Scrollback      int    // -scrollback bytes kept for replay (0 for no limit); Options.BufferSize overrides it
+	ScrollbackLines int    // -scrollback-lines kept for replay (0 for no limit)
+
+	MaxClients  int           // -max-clients (0 for no limit)
+	ResumeGrace time.Duration // -resume-grace
+	ClientQueue int           // -client-queue
+	SlowClient  string        // -slow-client: "drop" or "disconnect"
+
+	StartupCommand string // -c, typed into the shell once it settles
+	NoAutorestart  bool   // -no-autorestart
+
+	StateDir        string        // -state-dir
+	PersistSession  bool          // -persist-session into StateDir
+	PersistInterval time.Duration // -persist-interval
+	TmpdirQuota     int64         // -tmpdir-quota (0 for no limit)
+	KeepTmpdir      bool          // -keep-tmpdir
+
+	SessionCgroup    bool    // -session-cgroup
+	SessionMemoryMax int64   // -session-memory-max (0 for no limit)
+	SessionCPUMax    float64 // -session-cpu-max (0 for no limit)
+
+	TeeFile        string // -tee-file
+	TeeFileMaxSize int64  // -tee-file-max-size (0 never rotates)
+	TeeFileKeep    int    // -tee-file-keep
+	TeeCmd         string // -tee-cmd
+
+	Record    string // -record path
+	RecordDir string // -record-dir
+
+	ProxyURL        string        // -proxy-url
+	CABundle        string        // -ca-bundle
+	OutboundTimeout time.Duration // -outbound-timeout
+
+	Widgets          bool          // -widgets
+	Store            string        // -store: "memory" or "bolt:<path>"
+	WidgetLimit      int           // -widget-limit (0 for no limit)
+	WidgetMaxBytes   int           // -widget-max-bytes (0 for no limit)
+	WidgetTTL        time.Duration // -widget-ttl (0 for none)
+	WidgetDiffRatio  float64       // -widget-diff-ratio
+	WidgetRate       int           // -widget-rate (0 for no limit)
+	WidgetRateWindow time.Duration // -widget-rate-window
+	SanitizeWidgets  bool          // -sanitize-widgets
+	UnsafeWidgets    bool          // -unsafe-widgets
+
+	ConfirmWidgetCmds bool          // -confirm-widget-commands
+	WidgetCmdTrusted  []string      // -widget-cmd-trusted; empty for the installed lsh and duh
+	WidgetCmdAllow    []string      // -widget-cmd-allow; empty for the installed lsh, duh and serveh
+	WidgetCmdSigned   bool          // -widget-cmd-signed
+	DetachedTimeout   time.Duration // -detached-timeout
+
+	ClipboardLimit int           // -clipboard-limit (0 leaves OSC 52 alone)
+	AnnotateMin    time.Duration // -annotate-min-duration (0 disables)
+	AnnotateInject bool          // -annotate-inject
+	NotifyMin      time.Duration // -notify-min-duration (0 disables)
+	HistorySize    int           // -history-size
+
+	CSRFCheck         bool          // -csrf-check
+	FilesTTL          time.Duration // -files-ttl
+	FilesMaxTTL       time.Duration // -files-max-ttl
+	Trace             bool          // -trace
+	HeavyConcurrency  int           // -heavy-concurrency (0 for no limit)
+	HeavyQueueTimeout time.Duration // -heavy-queue-timeout
+}
+
+// defaultSettings is taken before anything can parse Flags.
+var defaultSettings = flagSettings()
+
+// DefaultSettings returns goshell's defaults, which NewServer uses when
+// Options.Settings is nil.
+func DefaultSettings() Settings {
+	return defaultSettings
+}
+
+// flagSettings returns the settings the flags hold.
+func flagSettings() Settings {
+	return Settings{
+		Addr:              *flagAddr,
+		Token:             *flagToken,
+		Config:            *flagConfig,
+		Profile:           *flagProfile,
+		Rows:              *flagRows,
+		Cols:              *flagCols,
+		ResizePolicy:      *flagResizePolicy,
+		Scrollback:        int(flagScrollback),
+		ScrollbackLines:   *flagScrollbackLines,
+		MaxClients:        *flagMaxClients,
+		ResumeGrace:       *flagResumeGrace,
+		ClientQueue:       *flagClientQueue,
+		SlowClient:        *flagSlowClient,
+		StartupCommand:    *flagStartupCommand,
+		NoAutorestart:     *flagNoAutorestart,
+		StateDir:          *flagStateDir,
+		PersistSession:    *flagPersistSession,
+		PersistInterval:   *flagPersistInterval,
+		TmpdirQuota:       *flagTmpdirQuota,
+		KeepTmpdir:        *flagKeepTmpdir,
+		SessionCgroup:     *flagSessionCgroup,
+		SessionMemoryMax:  *flagSessionMemoryMax,
+		SessionCPUMax:     *flagSessionCPUMax,
+		TeeFile:           *flagTeeFile,
+		TeeFileMaxSize:    *flagTeeFileMaxSize,
+		TeeFileKeep:       *flagTeeFileKeep,
+		TeeCmd:            *flagTeeCmd,
+		Record:            *flagRecord,
+		RecordDir:         *flagRecordDir,
+		ProxyURL:          *flagProxyURL,
+		CABundle:          *flagCABundle,
+		OutboundTimeout:   *flagOutboundTimeout,
+		Widgets:           *flagWidgets,
+		Store:             *flagStore,
+		WidgetLimit:       *flagWidgetLimit,
+		WidgetMaxBytes:    int(flagWidgetMaxBytes),
+		WidgetTTL:         *flagWidgetTTL,
+		WidgetDiffRatio:   *flagWidgetDiffRatio,
+		WidgetRate:        *flagWidgetRate,
+		WidgetRateWindow:  *flagWidgetRateWindow,
+		SanitizeWidgets:   *flagSanitizeWidgets,
+		UnsafeWidgets:     *flagUnsafeWidgets,
+		ConfirmWidgetCmds: *flagConfirmWidgetCmds,
+		WidgetCmdTrusted:  append([]string(nil), flagWidgetCmdTrusted...),
+		WidgetCmdAllow:    append([]string(nil), flagWidgetCmdAllow...),
+		WidgetCmdSigned:   *flagWidgetCmdSigned,
+		DetachedTimeout:   *flagDetachedTimeout,
+		ClipboardLimit:    int(flagClipboardLimit),
+		AnnotateMin:       *flagAnnotateMin,
+		AnnotateInject:    *flagAnnotateInject,
+		NotifyMin:         *flagNotifyMin,
+		HistorySize:       *flagHistorySize,
+		CSRFCheck:         *flagCSRFCheck,
+		FilesTTL:          *flagFilesTTL,
+		FilesMaxTTL:       *flagFilesMaxTTL,
+		Trace:             *flagTrace,
+		HeavyConcurrency:  *flagHeavyConcurrency,
+		HeavyQueueTimeout: *flagHeavyQueueTimeout,
+	}
+}
@@ -0,0 +1,53 @@
+package shellserver
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+var flagShell = Flags.String("shell", "", "shell the session runs, with any arguments, e.g. \"bash -l\" (default $GOSHELL_SHELL, else $SHELL -l, else zsh -l)")
+
+// shellChoice is a shell command to try and where it came from.
+type shellChoice struct {
+	argv   []string
+	source string // e.g. "-shell", "$SHELL"
+}
+
+// shellChoices returns the shells to try, in order. A shell configured
+// with -shell or GOSHELL_SHELL is the only choice: falling back from it
+// would start a shell nobody asked for.
+func shellChoices(flagValue string, getenv func(string) string) []shellChoice {
+	if argv := strings.Fields(flagValue); len(argv) > 0 {
+		return []shellChoice{{argv, "-shell"}}
+	}
+	if argv := strings.Fields(getenv("GOSHELL_SHELL")); len(argv) > 0 {
+		return []shellChoice{{argv, "$GOSHELL_SHELL"}}
+	}
+	var choices []shellChoice
+	if sh := getenv("SHELL"); sh != "" {
+		choices = append(choices, shellChoice{[]string{sh, "-l"}, "$SHELL"})
+	}
+	return append(choices, shellChoice{[]string{defaultShell, "-l"}, "default"})
+}
+
+// resolveShell returns the first of choices whose program exists, or an
+// error listing everything tried.
+func resolveShell(choices []shellChoice) ([]string, error) {
+	var tried []string
+	for _, c := range choices {
+		if _, err := exec.LookPath(c.argv[0]); err != nil {
+			tried = append(tried, fmt.Sprintf("%s (%s): %v", c.argv[0], c.source, err))
+			continue
+		}
+		return c.argv, nil
+	}
+	return nil, fmt.Errorf("no shell to run; tried %s", strings.Join(tried, "; "))
+}
+
+// configuredShell is the shell the server runs, from -shell, GOSHELL_SHELL
+// or SHELL.
+func configuredShell() ([]string, error) {
+	return resolveShell(shellChoices(*flagShell, os.Getenv))
+}
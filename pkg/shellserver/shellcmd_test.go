@@ -0,0 +1,84 @@
+package shellserver
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"shellserver/internal/testshell"
+)
+
+func TestShellChoices(t *testing.T) {
+	env := func(vars map[string]string) func(string) string {
+		return func(k string) string { return vars[k] }
+	}
+	tests := []struct {
+		name string
+		flag string
+		env  map[string]string
+		want [][]string
+	}{
+		{"flag with args", "bash --norc -i", map[string]string{"GOSHELL_SHELL": "fish", "SHELL": "/bin/zsh"}, [][]string{{"bash", "--norc", "-i"}}},
+		{"GOSHELL_SHELL", "", map[string]string{"GOSHELL_SHELL": "/bin/sh", "SHELL": "/bin/zsh"}, [][]string{{"/bin/sh"}}},
+		{"SHELL then zsh", "", map[string]string{"SHELL": "/usr/bin/fish"}, [][]string{{"/usr/bin/fish", "-l"}, {"zsh", "-l"}}},
+		{"nothing set", "  ", nil, [][]string{{"zsh", "-l"}}},
+	}
+	for _, tt := range tests {
+		var got [][]string
+		for _, c := range shellChoices(tt.flag, env(tt.env)) {
+			got = append(got, c.argv)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: choices = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestResolveShell(t *testing.T) {
+	argv, err := resolveShell([]shellChoice{
+		{[]string{"/nonexistent/zsh", "-l"}, "$SHELL"},
+		{[]string{"/bin/sh", "-i"}, "default"},
+	})
+	if err != nil || !reflect.DeepEqual(argv, []string{"/bin/sh", "-i"}) {
+		t.Errorf("resolveShell = %q, %v; want the first that exists", argv, err)
+	}
+
+	_, err = resolveShell([]shellChoice{
+		{[]string{"/nonexistent/zsh", "-l"}, "$SHELL"},
+		{[]string{"no-such-shell-xyz"}, "default"},
+	})
+	if err == nil {
+		t.Fatal("resolveShell found a missing shell")
+	}
+	for _, want := range []string{"/nonexistent/zsh ($SHELL)", "no-such-shell-xyz (default)"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q doesn't mention %q", err, want)
+		}
+	}
+}
+
+// TestBinShSession runs the PTY path on /bin/sh, which every CI machine has.
+func TestBinShSession(t *testing.T) {
+	defer func(old string) { *flagShell = old }(*flagShell)
+	*flagShell = "/bin/sh"
+	s, err := newShellServer()
+	if err != nil {
+		t.Fatalf("newShellServer: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	if !reflect.DeepEqual(s.shellArgv, []string{"/bin/sh"}) {
+		t.Errorf("shellArgv = %q", s.shellArgv)
+	}
+
+	ts := serveShellServer(t, s)
+	c := testshell.Dial(t, ts.URL, "")
+	c.Send("echo from-$((40+2))")
+	c.ExpectOutput("from-42", testshell.DefaultTimeout)
+
+	// A restart runs the same shell again
+	if err := s.restart(); err != nil {
+		t.Fatal(err)
+	}
+	c.Send("echo again-$((1+1))")
+	c.ExpectOutput("again-2", testshell.DefaultTimeout)
+}
@@ -0,0 +1,312 @@
+package shellserver
+
+import (
+	"bytes"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"shellserver/internal/persist"
+	"shellserver/pkg/protocol"
+)
+
+// defaultShell is the shell run when neither -shell, GOSHELL_SHELL nor
+// SHELL names one, and the one hooks are given for by default.
+const defaultShell = "zsh"
+
+// Guard lines wrapped around the integration snippet in rc files so that
+// `goshell install` can find and replace an earlier installation.
+const (
+	integrationBeginGuard = "# >>> goshell integration >>>"
+	integrationEndGuard   = "# <<< goshell integration <<<"
+)
+
+// Every shell's hooks emit the same sequences:
+//
+//	ESC]9001;CMD;<base64 command line>BEL  before a command runs
+//	ESC]133;C BEL                          before a command runs
+//	ESC]133;D;<exit code>BEL               after a command finishes
+//	ESC]7;file://<host><cwd>BEL            before each prompt
+//	ESC]133;A BEL                          before each prompt
+//
+// so scanShellEvents doesn't need to know which shell produced them.
+
+const zshIntegration = `# goshell shell integration (zsh)
+if [[ -n "$GOSHELL_HOME" && -z "$__goshell_integrated" ]]; then
+  __goshell_integrated=1
+  __goshell_ran=
+  __goshell_osc() { builtin printf '\033]%s\007' "$1"; }
+  __goshell_preexec() {
+    __goshell_ran=1
+    __goshell_osc "9001;CMD;$(builtin printf '%s' "$1" | command base64 | command tr -d '\n')"
+    __goshell_osc "133;C"
+  }
+  __goshell_precmd() {
+    local ec=$?
+    [[ -n "$__goshell_ran" ]] && __goshell_osc "133;D;$ec"
+    __goshell_ran=
+    __goshell_osc "7;file://$HOST$PWD"
+    __goshell_osc "133;A"
+  }
+  autoload -Uz add-zsh-hook
+  add-zsh-hook preexec __goshell_preexec
+  add-zsh-hook precmd __goshell_precmd
+fi
+`
+
+const bashIntegration = `# goshell shell integration (bash)
+if [[ -n "$GOSHELL_HOME" && -z "$__goshell_integrated" ]]; then
+  __goshell_integrated=1
+  __goshell_ran=
+  __goshell_armed=
+  __goshell_osc() { builtin printf '\033]%s\007' "$1"; }
+  __goshell_preexec() {
+    [[ -z "$__goshell_armed" || -n "$COMP_LINE" ]] && return
+    __goshell_armed=
+    __goshell_ran=1
+    local cmd
+    cmd=$(HISTTIMEFORMAT= builtin history 1 2>/dev/null | command sed 's/^ *[0-9]* *//')
+    [[ -z "$cmd" ]] && cmd=$BASH_COMMAND
+    __goshell_osc "9001;CMD;$(builtin printf '%s' "$cmd" | command base64 | command tr -d '\n')"
+    __goshell_osc "133;C"
+  }
+  __goshell_precmd() {
+    local ec=$?
+    [[ -n "$__goshell_ran" ]] && __goshell_osc "133;D;$ec"
+    __goshell_ran=
+    __goshell_osc "7;file://$HOSTNAME$PWD"
+    __goshell_osc "133;A"
+  }
+  __goshell_arm() { __goshell_armed=1; }
+  trap '__goshell_preexec' DEBUG
+  PROMPT_COMMAND="__goshell_precmd;${PROMPT_COMMAND:+$PROMPT_COMMAND;}__goshell_arm"
+fi
+`
+
+const fishIntegration = `# goshell shell integration (fish)
+if set -q GOSHELL_HOME; and not set -q __goshell_integrated
+    set -g __goshell_integrated 1
+    function __goshell_osc
+        printf '\033]%s\007' $argv[1]
+    end
+    function __goshell_preexec --on-event fish_preexec
+        __goshell_osc "9001;CMD;"(printf '%s' $argv[1] | command base64 | command tr -d '\n')
+        __goshell_osc "133;C"
+    end
+    function __goshell_postexec --on-event fish_postexec
+        __goshell_osc "133;D;$status"
+    end
+    function __goshell_prompt --on-event fish_prompt
+        __goshell_osc "7;file://$hostname$PWD"
+        __goshell_osc "133;A"
+    end
+end
+`
+
+// integrationSnippets maps a shell name to its hook snippet.
+var integrationSnippets = map[string]string{
+	"zsh":  zshIntegration,
+	"bash": bashIntegration,
+	"fish": fishIntegration,
+}
+
+// integrationSnippet returns the hook snippet for the named shell. The name
+// may be a bare shell name or a path to the shell binary.
+func integrationSnippet(shell string) (string, error) {
+	name := filepath.Base(shell)
+	snippet, ok := integrationSnippets[name]
+	if !ok {
+		return "", fmt.Errorf("unsupported shell %q (want zsh, bash, or fish)", shell)
+	}
+	return snippet, nil
+}
+
+// integrationRCFile returns the rc file `goshell install` writes for shell.
+func integrationRCFile(shell, home string) (string, error) {
+	switch filepath.Base(shell) {
+	case "zsh":
+		return filepath.Join(home, ".zshrc"), nil
+	case "bash":
+		return filepath.Join(home, ".bashrc"), nil
+	case "fish":
+		return filepath.Join(home, ".config", "fish", "config.fish"), nil
+	}
+	return "", fmt.Errorf("unsupported shell %q (want zsh, bash, or fish)", shell)
+}
+
+// installIntegrationBlock returns rc with the guarded snippet appended, or
+// with an existing guarded block replaced in place.
+func installIntegrationBlock(rc []byte, snippet string) []byte {
+	block := integrationBeginGuard + "\n" + snippet + integrationEndGuard + "\n"
+
+	start := bytes.Index(rc, []byte(integrationBeginGuard))
+	if start != -1 {
+		end := bytes.Index(rc[start:], []byte(integrationEndGuard))
+		if end != -1 {
+			end += start + len(integrationEndGuard)
+			if end < len(rc) && rc[end] == '\n' {
+				end++
+			}
+			out := append([]byte{}, rc[:start]...)
+			out = append(out, block...)
+			return append(out, rc[end:]...)
+		}
+	}
+
+	out := append([]byte{}, rc...)
+	if len(out) > 0 && out[len(out)-1] != '\n' {
+		out = append(out, '\n')
+	}
+	return append(out, block...)
+}
+
+// installShell is the shell `goshell install` gives hooks to by default:
+// the one the session would run, from GOSHELL_SHELL or SHELL, if hooks
+// exist for it, or else defaultShell.
+func installShell(getenv func(string) string) string {
+	for _, c := range shellChoices("", getenv) {
+		if _, err := integrationSnippet(c.argv[0]); err == nil {
+			return filepath.Base(c.argv[0])
+		}
+	}
+	return defaultShell
+}
+
+// runInstall implements `goshell install`: it writes the integration
+// snippet for the chosen shell into that shell's rc file. The file is
+// replaced through a temporary copy, so a crash can't leave it cut short.
+func runInstall(args []string) error {
+	fs := flag.NewFlagSet("install", flag.ContinueOnError)
+	shell := fs.String("shell", installShell(os.Getenv), "shell to install hooks for (zsh, bash, fish; default from $GOSHELL_SHELL or $SHELL)")
+	rcPath := fs.String("rc", "", "rc file to modify (default depends on -shell)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	snippet, err := integrationSnippet(*shell)
+	if err != nil {
+		return err
+	}
+
+	path := *rcPath
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return fmt.Errorf("find home directory: %w", err)
+		}
+		if path, err = integrationRCFile(*shell, home); err != nil {
+			return err
+		}
+	}
+
+	// An rc file linked from a dotfiles repository is written where it
+	// lives, keeping the link and the file's mode
+	if target, err := filepath.EvalSymlinks(path); err == nil {
+		path = target
+	}
+	perm := os.FileMode(0o644)
+	if info, err := os.Stat(path); err == nil {
+		perm = info.Mode().Perm()
+	}
+	rc, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("read %s: %w", path, err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("create %s: %w", filepath.Dir(path), err)
+	}
+	if err := persist.WriteFile(path, installIntegrationBlock(rc, snippet), perm); err != nil {
+		return fmt.Errorf("write %s: %w", path, err)
+	}
+
+	fmt.Printf("goshell integration for %s installed in %s\n", filepath.Base(*shell), path)
+	return nil
+}
+
+func (s *ShellServer) handleIntegration(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r, http.MethodGet)
+		return
+	}
+
+	shell := r.URL.Query().Get("shell")
+	if shell == "" {
+		shell = defaultShell
+		if len(s.shellArgv) > 0 {
+			if _, err := integrationSnippet(s.shellArgv[0]); err == nil {
+				shell = s.shellArgv[0]
+			}
+		}
+	}
+
+	snippet, err := integrationSnippet(shell)
+	if err != nil {
+		respondError(w, r, http.StatusBadRequest, protocol.ErrInvalidRequest, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte(snippet))
+}
+
+// shellEvent is one integration marker found in PTY output.
+type shellEvent struct {
+	Kind     string // "prompt", "command", "finished", or "cwd"
+	Command  string // command line, for "command"
+	ExitCode int    // exit status, for "finished"
+	Path     string // directory, for "cwd"
+}
+
+// scanShellEvents returns the integration events in data, in order.
+// Incomplete sequences at the end of data are ignored.
+func scanShellEvents(data []byte) []shellEvent {
+	var events []shellEvent
+	for {
+		start := bytes.Index(data, []byte("\x1b]"))
+		if start == -1 {
+			return events
+		}
+		end := bytes.IndexByte(data[start:], '\x07')
+		if end == -1 {
+			return events
+		}
+		payload := string(data[start+2 : start+end])
+		data = data[start+end+1:]
+
+		if ev, ok := parseShellEvent(payload); ok {
+			events = append(events, ev)
+		}
+	}
+}
+
+// parseShellEvent decodes the payload of one OSC sequence (between
+// "ESC]" and BEL) into an integration event.
+func parseShellEvent(payload string) (shellEvent, bool) {
+	switch {
+	case payload == "133;A":
+		return shellEvent{Kind: "prompt"}, true
+	case strings.HasPrefix(payload, "133;D;"):
+		code, err := strconv.Atoi(strings.TrimPrefix(payload, "133;D;"))
+		if err != nil {
+			return shellEvent{}, false
+		}
+		return shellEvent{Kind: "finished", ExitCode: code}, true
+	case strings.HasPrefix(payload, "9001;CMD;"):
+		cmd, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(payload, "9001;CMD;"))
+		if err != nil {
+			return shellEvent{}, false
+		}
+		return shellEvent{Kind: "command", Command: string(cmd)}, true
+	case strings.HasPrefix(payload, "7;file://"):
+		rest := strings.TrimPrefix(payload, "7;file://")
+		if slash := strings.IndexByte(rest, '/'); slash != -1 {
+			return shellEvent{Kind: "cwd", Path: rest[slash:]}, true
+		}
+	}
+	return shellEvent{}, false
+}
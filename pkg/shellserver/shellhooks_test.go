@@ -0,0 +1,283 @@
+package shellserver
+
+import (
+	"bytes"
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/creack/pty"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite golden files in testdata")
+
+func TestIntegrationSnippetGolden(t *testing.T) {
+	for _, shell := range []string{"zsh", "bash", "fish"} {
+		t.Run(shell, func(t *testing.T) {
+			got, err := integrationSnippet(shell)
+			if err != nil {
+				t.Fatalf("integrationSnippet(%q): %v", shell, err)
+			}
+
+			golden := filepath.Join("testdata", "integration."+shell+".golden")
+			if *updateGolden {
+				if err := os.WriteFile(golden, []byte(got), 0o644); err != nil {
+					t.Fatalf("update golden: %v", err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("read golden: %v", err)
+			}
+			if got != string(want) {
+				t.Errorf("snippet for %s differs from %s (run with -update to regenerate)", shell, golden)
+			}
+		})
+	}
+}
+
+func TestIntegrationSnippetShellPath(t *testing.T) {
+	if _, err := integrationSnippet("/usr/local/bin/fish"); err != nil {
+		t.Errorf("path to fish: unexpected error: %v", err)
+	}
+	if _, err := integrationSnippet("tcsh"); err == nil {
+		t.Errorf("tcsh: expected error")
+	}
+}
+
+func TestHandleIntegration(t *testing.T) {
+	s := &ShellServer{}
+
+	tests := []struct {
+		name       string
+		query      string
+		wantStatus int
+		wantSubstr string
+	}{
+		{"default shell", "", http.StatusOK, "(" + defaultShell + ")"},
+		{"bash", "?shell=bash", http.StatusOK, "PROMPT_COMMAND"},
+		{"fish", "?shell=fish", http.StatusOK, "fish_preexec"},
+		{"unknown", "?shell=csh", http.StatusBadRequest, "unsupported shell"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			s.handleIntegration(rec, httptest.NewRequest(http.MethodGet, "/integration"+tt.query, nil))
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if !strings.Contains(rec.Body.String(), tt.wantSubstr) {
+				t.Errorf("body missing %q:\n%s", tt.wantSubstr, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestInstallIntegrationBlock(t *testing.T) {
+	snippet := "echo hooks\n"
+	block := integrationBeginGuard + "\n" + snippet + integrationEndGuard + "\n"
+
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"empty file", "", block},
+		{"appends", "export A=1\n", "export A=1\n" + block},
+		{"adds missing newline", "export A=1", "export A=1\n" + block},
+		{
+			"replaces in place",
+			"before\n" + integrationBeginGuard + "\nold\n" + integrationEndGuard + "\nafter\n",
+			"before\n" + block + "after\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := string(installIntegrationBlock([]byte(tt.input), snippet))
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+			// Installing twice must not change anything.
+			again := string(installIntegrationBlock([]byte(got), snippet))
+			if again != got {
+				t.Errorf("second install changed file: %q", again)
+			}
+		})
+	}
+}
+
+func TestRunInstall(t *testing.T) {
+	rc := filepath.Join(t.TempDir(), ".bashrc")
+	if err := os.WriteFile(rc, []byte("alias ll='ls -l'\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := runInstall([]string{"-shell", "bash", "-rc", rc}); err != nil {
+			t.Fatalf("runInstall: %v", err)
+		}
+	}
+
+	data, err := os.ReadFile(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(data), integrationBeginGuard); n != 1 {
+		t.Errorf("guard block present %d times, want 1", n)
+	}
+	if !strings.HasPrefix(string(data), "alias ll='ls -l'\n") {
+		t.Errorf("existing rc content not preserved:\n%s", data)
+	}
+}
+
+func TestRunInstallKeepsLinkAndMode(t *testing.T) {
+	dir := t.TempDir()
+	target := filepath.Join(dir, "dotfiles", "bashrc")
+	os.Mkdir(filepath.Dir(target), 0o755)
+	if err := os.WriteFile(target, []byte("alias ll='ls -l'\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	rc := filepath.Join(dir, ".bashrc")
+	if err := os.Symlink(target, rc); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := runInstall([]string{"-shell", "bash", "-rc", rc}); err != nil {
+		t.Fatalf("runInstall: %v", err)
+	}
+	if info, err := os.Lstat(rc); err != nil || info.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("rc file is no longer a link: %v, %v", info, err)
+	}
+	data, err := os.ReadFile(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), integrationBeginGuard) {
+		t.Errorf("link target not updated:\n%s", data)
+	}
+	if info, _ := os.Stat(target); info.Mode().Perm() != 0o600 {
+		t.Errorf("mode = %v, want 0600 kept", info.Mode())
+	}
+	entries, _ := os.ReadDir(filepath.Dir(target))
+	if len(entries) != 1 {
+		t.Errorf("%d files beside the rc file, want none", len(entries)-1)
+	}
+}
+
+func TestInstallShell(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		want string
+	}{
+		{"login shell", map[string]string{"SHELL": "/bin/bash"}, "bash"},
+		{"configured shell", map[string]string{"GOSHELL_SHELL": "/usr/bin/fish -l", "SHELL": "/bin/bash"}, "fish"},
+		{"no hooks for it", map[string]string{"SHELL": "/bin/tcsh"}, defaultShell},
+		{"nothing set", nil, defaultShell},
+	}
+	for _, tt := range tests {
+		if got := installShell(func(k string) string { return tt.env[k] }); got != tt.want {
+			t.Errorf("%s: installShell = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestScanShellEvents(t *testing.T) {
+	data := []byte("out\x1b]9001;CMD;bHMgLWw=\x07\x1b]133;C\x07listing\r\n" +
+		"\x1b]133;D;2\x07\x1b]7;file://host/tmp/a b\x07\x1b]133;A\x07$ " +
+		"\x1b]8;;htmlwidget:1\x07link\x1b]8;;\x07\x1b]133;D;")
+
+	want := []shellEvent{
+		{Kind: "command", Command: "ls -l"},
+		{Kind: "finished", ExitCode: 2},
+		{Kind: "cwd", Path: "/tmp/a b"},
+		{Kind: "prompt"},
+	}
+	if got := scanShellEvents(data); !reflect.DeepEqual(got, want) {
+		t.Errorf("scanShellEvents = %+v, want %+v", got, want)
+	}
+}
+
+// TestBashIntegrationEvents runs an interactive bash with the hooks loaded
+// on a PTY and checks the markers it emits.
+func TestBashIntegrationEvents(t *testing.T) {
+	bash, err := exec.LookPath("bash")
+	if err != nil {
+		t.Skip("bash not installed")
+	}
+
+	dir := t.TempDir()
+	hooks := filepath.Join(dir, "hooks.bash")
+	if err := os.WriteFile(hooks, []byte(bashIntegration), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command(bash, "--norc", "--noprofile", "-i")
+	cmd.Env = append(os.Environ(), "GOSHELL_HOME="+dir, "PS1=$ ", "HISTFILE=/dev/null")
+	ptmx, err := pty.Start(cmd)
+	if err != nil {
+		t.Fatalf("start bash: %v", err)
+	}
+	defer ptmx.Close()
+
+	script := "source " + hooks + "\n" +
+		"cd " + dir + "\n" +
+		"(exit 3)\n" +
+		"exit\n"
+	if _, err := ptmx.Write([]byte(script)); err != nil {
+		t.Fatalf("write script: %v", err)
+	}
+
+	var out bytes.Buffer
+	done := make(chan struct{})
+	go func() {
+		buf := make([]byte, 4096)
+		for {
+			n, err := ptmx.Read(buf)
+			out.Write(buf[:n])
+			if err != nil {
+				close(done)
+				return
+			}
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("bash did not exit; output so far: %q", out.String())
+	}
+	cmd.Wait()
+
+	var got []shellEvent
+	for _, ev := range scanShellEvents(out.Bytes()) {
+		if ev.Kind != "prompt" {
+			got = append(got, ev)
+		}
+	}
+	want := []shellEvent{
+		{Kind: "command", Command: "cd " + dir},
+		{Kind: "finished", ExitCode: 0},
+		{Kind: "cwd", Path: dir},
+		{Kind: "command", Command: "(exit 3)"},
+		{Kind: "finished", ExitCode: 3},
+		{Kind: "cwd", Path: dir},
+		{Kind: "command", Command: "exit"},
+	}
+	// The source line itself runs before the hooks exist, so the first
+	// prompt after it only reports the cwd.
+	if len(got) > 0 && got[0].Kind == "cwd" {
+		got = got[1:]
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("events = %+v\nwant %+v\noutput: %q", got, want, out.String())
+	}
+}
@@ -0,0 +1,181 @@
+package shellserver
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Private markers around the output of a hidden shell query, each with the
+// query's token so the command's own echo can't be mistaken for them.
+const (
+	queryStartPrefix = "\x1b]9001;QUERY_START;"
+	queryEndPrefix   = "\x1b]9001;QUERY_END;"
+)
+
+// shellQueryTimeout is how long a hidden query waits for its end marker.
+const shellQueryTimeout = 2 * time.Second
+
+// errShellBusy means the shell isn't waiting at a prompt, so a hidden
+// query would be typed into whatever is running.
+var errShellBusy = errors.New("the shell is busy; queries run only at a waiting prompt")
+
+// posixQueryLine is the input that runs cmd between the markers in
+// a POSIX shell. The markers are written as printf escapes, so the
+// terminal's echo of the line doesn't contain them. The leading space
+// keeps the line out of history where the shell ignores such lines.
+func posixQueryLine(start, end, cmd string) string {
+	esc := strings.NewReplacer("\x1b", `\033`, "\x07", `\007`)
+	return fmt.Sprintf(" printf '%s'; %s; printf '%s'", esc.Replace(start), cmd, esc.Replace(end))
+}
+
+// shellQueries runs commands in the shell without showing them: the line
+// is typed into the PTY, and everything the PTY prints from then until the
+// query's end marker is held back from clients, the command's output
+// between the markers being the result. One query runs at a time.
+type shellQueries struct {
+	run sync.Mutex // held for the whole of a query
+
+	mu       sync.Mutex
+	line     func(start, end, cmd string) string // nil for posixQueryLine
+	active   *shellQuery
+	released []byte // held output of an abandoned query, for the next read
+}
+
+type shellQuery struct {
+	start, end []byte
+	held       []byte      // PTY output since the line was typed
+	done       chan []byte // the output between the markers
+}
+
+// setLine replaces how a query's input line is built, for shells that
+// aren't POSIX.
+func (q *shellQueries) setLine(line func(start, end, cmd string) string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.line = line
+}
+
+// begin registers a query for cmd and returns it with the line to type,
+// newline included.
+func (q *shellQueries) begin(cmd string) (*shellQuery, string) {
+	b := make([]byte, 8)
+	rand.Read(b)
+	token := hex.EncodeToString(b)
+	query := &shellQuery{
+		start: []byte(queryStartPrefix + token + "\x07"),
+		end:   []byte(queryEndPrefix + token + "\x07"),
+		done:  make(chan []byte, 1),
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.active = query
+	line := q.line
+	if line == nil {
+		line = posixQueryLine
+	}
+	return query, line(string(query.start), string(query.end), cmd) + "\n"
+}
+
+// abandon stops holding back output for query, which timed out. What was
+// held is passed on with the next PTY read.
+func (q *shellQueries) abandon(query *shellQuery) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.active == query {
+		q.active = nil
+		q.released = append(q.released, query.held...)
+	}
+}
+
+// reset forgets the old shell's query exchange when the shell is
+// replaced. A query still waiting times out.
+func (q *shellQueries) reset() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.active = nil
+	q.released = nil
+}
+
+// filter returns the part of PTY output data that clients should see,
+// holding back a running query's exchange and answering the query once
+// its end marker arrives. It is called from pumpPTY for every read.
+func (q *shellQueries) filter(data []byte) []byte {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.released) > 0 {
+		data = append(q.released, data...)
+		q.released = nil
+	}
+	query := q.active
+	if query == nil {
+		return data
+	}
+	query.held = append(query.held, data...)
+	end := bytes.Index(query.held, query.end)
+	if end == -1 {
+		return nil
+	}
+	q.active = nil
+
+	var result []byte
+	if start := bytes.Index(query.held[:end], query.start); start != -1 {
+		result = append(result, query.held[start+len(query.start):end]...)
+	}
+	query.done <- result
+
+	// The prompt the query was typed at is still on screen; clear its line
+	// so the shell's next prompt replaces it
+	rest := query.held[end+len(query.end):]
+	return append([]byte("\r\x1b[2K"), rest...)
+}
+
+// queryShell runs cmd in the shell without clients seeing it and returns
+// what it printed. The shell must be waiting at a prompt.
+func (s *ShellServer) queryShell(ctx context.Context, cmd string) ([]byte, error) {
+	s.shellQueries.run.Lock()
+	defer s.shellQueries.run.Unlock()
+
+	if !s.shellWaiting() {
+		return nil, errShellBusy
+	}
+	query, line := s.shellQueries.begin(cmd)
+	if err := s.writeToPTY([]byte(line)); err != nil {
+		s.shellQueries.abandon(query)
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, shellQueryTimeout)
+	defer cancel()
+	select {
+	case out := <-query.done:
+		// Terminals turn \n into \r\n on the way out
+		return bytes.ReplaceAll(out, []byte("\r\n"), []byte("\n")), nil
+	case <-ctx.Done():
+		s.shellQueries.abandon(query)
+		return nil, fmt.Errorf("shell query: no answer within %s", shellQueryTimeout)
+	}
+}
+
+// shellWaiting reports whether the shell itself, not a command it runs,
+// has the terminal, and the PTY output is interpreted.
+func (s *ShellServer) shellWaiting() bool {
+	if s.rawMode.Load() {
+		return false
+	}
+	s.ptyMu.Lock()
+	ptyFile, shellPGID := s.ptyFile, s.shellPGID
+	s.ptyMu.Unlock()
+	if ptyFile == nil {
+		return false
+	}
+	pgid, err := getForegroundPGID(ptyFile)
+	return err == nil && pgid == shellPGID
+}
@@ -0,0 +1,77 @@
+package shellserver
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"shellserver/internal/testshell"
+)
+
+func TestShellQueryFilter(t *testing.T) {
+	var q shellQueries
+	if got := q.filter([]byte("before")); string(got) != "before" {
+		t.Errorf("no query: filter = %q", got)
+	}
+
+	query, line := q.begin("env -0")
+	if strings.Contains(line, "\x1b") || strings.Contains(line, "\x07") {
+		t.Errorf("input line %q carries the raw markers, so its echo would match", line)
+	}
+
+	// The echo, the markers and the output arrive split across reads
+	reads := []string{
+		" printf ...; env -0\r\n",
+		string(query.start[:5]),
+		string(query.start[5:]) + "A=1\x00B=2",
+		"\x00" + string(query.end[:3]),
+		string(query.end[3:]) + "$ ",
+	}
+	var shown string
+	for _, r := range reads {
+		shown += string(q.filter([]byte(r)))
+	}
+	if got := string(<-query.done); got != "A=1\x00B=2\x00" {
+		t.Errorf("result = %q", got)
+	}
+	if shown != "\r\x1b[2K$ " {
+		t.Errorf("clients saw %q, want only the next prompt", shown)
+	}
+
+	// An abandoned query's held output is passed on with the next read
+	query, _ = q.begin("env -0")
+	q.filter([]byte("held"))
+	q.abandon(query)
+	if got := q.filter([]byte(" next")); string(got) != "held next" {
+		t.Errorf("after abandoning: filter = %q", got)
+	}
+}
+
+func TestShellQueryHidden(t *testing.T) {
+	s, ts := startFakeShellServer(t)
+	s.shellQueries.setLine(testshell.Wrap)
+	c := testshell.Dial(t, ts.URL, "")
+	c.ExpectOutput(testshell.Prompt, testshell.DefaultTimeout)
+
+	out, err := s.queryShell(context.Background(), "echo hidden")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != "hidden\n" {
+		t.Errorf("query output = %q", out)
+	}
+
+	c.Send("echo visible")
+	if got := c.ExpectOutput("visible\r\n", testshell.DefaultTimeout); strings.Contains(got, "hidden") || strings.Contains(got, "QUERY") {
+		t.Errorf("the query showed in the terminal: %q", got)
+	}
+
+	// Nothing is typed into a running command
+	c.ExpectOutput(testshell.Prompt, testshell.DefaultTimeout)
+	c.Send("fg 2s")
+	waitFor(t, "foreground command", func() bool { return !s.shellWaiting() })
+	if _, err := s.queryShell(context.Background(), "echo hidden"); !errors.Is(err, errShellBusy) {
+		t.Errorf("query while busy: %v, want errShellBusy", err)
+	}
+}
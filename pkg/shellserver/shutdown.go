@@ -0,0 +1,68 @@
+package shellserver
+
+import (
+	"context"
+	"log"
+	"syscall"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+var flagShutdownTimeout = Flags.Duration("shutdown-timeout", 5*time.Second, "how long SIGINT or SIGTERM waits for the shell to exit after hanging it up, before killing it")
+
+// Shutdown ends the session for a server that is stopping: clients are
+// told and disconnected with a close frame, the shell's process group is
+// hung up and waited for until ctx ends, when it is killed, and the
+// session is closed.
+func (s *ShellServer) Shutdown(ctx context.Context) error {
+	s.stopping.Store(true)
+	s.broadcastStatus("shutdown", foregroundJob{})
+	s.closeClients(websocket.CloseGoingAway, "server shutting down")
+
+	s.ptyMu.Lock()
+	shellPGID, shell := s.shellPGID, s.shell
+	s.ptyMu.Unlock()
+	if shellPGID > 0 {
+		syscall.Kill(-shellPGID, syscall.SIGHUP)
+		if !waitForExit(ctx, shellPGID) {
+			log.Printf("shutdown: shell still running after SIGHUP; killing it")
+			syscall.Kill(-shellPGID, syscall.SIGKILL)
+		}
+		if _, ok := shell.wait(shellReapTimeout); !ok {
+			log.Printf("shutdown: shell not reaped within %v", shellReapTimeout)
+		}
+	}
+	return s.Close()
+}
+
+// waitForExit polls until pid has exited, reporting false if ctx ends
+// first.
+func waitForExit(ctx context.Context, pid int) bool {
+	ticker := time.NewTicker(20 * time.Millisecond)
+	defer ticker.Stop()
+	for processRunning(pid) {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-ticker.C:
+		}
+	}
+	return true
+}
+
+// closeClients sends every client a close frame with code and reason and
+// disconnects it.
+func (s *ShellServer) closeClients(code int, reason string) {
+	s.clientsMu.RLock()
+	conns := make([]*websocket.Conn, 0, len(s.clients))
+	for conn, c := range s.clients {
+		c.out.send(outbound{msgType: websocket.CloseMessage, data: websocket.FormatCloseMessage(code, reason)})
+		conns = append(conns, conn)
+	}
+	s.clientsMu.RUnlock()
+
+	for _, conn := range conns {
+		s.unregisterClient(conn)
+	}
+}
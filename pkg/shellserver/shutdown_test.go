@@ -0,0 +1,78 @@
+package shellserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"shellserver/internal/testshell"
+)
+
+func TestShutdown(t *testing.T) {
+	s, ts := startFakeShellServer(t)
+	conn, _, err := websocket.DefaultDialer.Dial("ws://"+strings.TrimPrefix(ts.URL, "http://")+"/ws/shell", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	waitFor(t, "the client to register", func() bool {
+		s.clientsMu.RLock()
+		defer s.clientsMu.RUnlock()
+		return len(s.clients) == 1
+	})
+
+	s.ptyMu.Lock()
+	shellPGID := s.shellPGID
+	s.ptyMu.Unlock()
+	if err := s.Shutdown(context.Background()); err != nil {
+		t.Errorf("Shutdown: %v", err)
+	}
+	if processRunning(shellPGID) {
+		t.Error("shell still running after Shutdown")
+	}
+
+	// The client hears why before the close frame
+	sawShutdown := false
+	conn.SetReadDeadline(time.Now().Add(testshell.DefaultTimeout))
+	for {
+		msgType, data, err := conn.ReadMessage()
+		if err != nil {
+			var closeErr *websocket.CloseError
+			if !errors.As(err, &closeErr) || closeErr.Code != websocket.CloseGoingAway {
+				t.Errorf("connection ended with %v, want a going-away close frame", err)
+			}
+			break
+		}
+		var ev map[string]string
+		if msgType == websocket.TextMessage && json.Unmarshal(data, &ev) == nil && ev["kind"] == "status" && ev["state"] == "shutdown" {
+			sawShutdown = true
+		}
+	}
+	if !sawShutdown {
+		t.Error("no shutdown status before the close frame")
+	}
+}
+
+func TestShutdownKillsStubbornShell(t *testing.T) {
+	s, ts := startFakeShellServer(t)
+	c := testshell.Dial(t, ts.URL, "")
+	c.Send("nohup")
+	c.ExpectOutput("nohup\r\n$ ", testshell.DefaultTimeout)
+
+	s.ptyMu.Lock()
+	shellPGID := s.shellPGID
+	s.ptyMu.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	s.Shutdown(ctx)
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Errorf("Shutdown returned after %v, before the grace period", elapsed)
+	}
+	waitFor(t, "the shell to be killed", func() bool { return !processRunning(shellPGID) })
+}
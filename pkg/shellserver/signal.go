@@ -0,0 +1,130 @@
+package shellserver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"syscall"
+
+	"github.com/gorilla/websocket"
+
+	"shellserver/pkg/protocol"
+)
+
+// foregroundSignals are the signals a client may send the foreground
+// job, by the names kill(1) takes.
+var foregroundSignals = map[string]syscall.Signal{
+	"INT":  syscall.SIGINT,
+	"QUIT": syscall.SIGQUIT,
+	"TSTP": syscall.SIGTSTP,
+	"CONT": syscall.SIGCONT,
+	"TERM": syscall.SIGTERM,
+	"HUP":  syscall.SIGHUP,
+	"KILL": syscall.SIGKILL,
+	"USR1": syscall.SIGUSR1,
+	"USR2": syscall.SIGUSR2,
+}
+
+// errUnknownSignal is returned for a signal not in foregroundSignals.
+var errUnknownSignal = errors.New("unknown signal")
+
+// parseSignalName looks up name, with or without its SIG prefix and in
+// any case.
+func parseSignalName(name string) (syscall.Signal, error) {
+	sig, ok := foregroundSignals[strings.TrimPrefix(strings.ToUpper(name), "SIG")]
+	if !ok {
+		return 0, fmt.Errorf("%w %q", errUnknownSignal, name)
+	}
+	return sig, nil
+}
+
+// signalForeground sends the named signal to the PTY's foreground process
+// group, as the terminal would for its control characters; at a prompt,
+// that is the shell's.
+func (s *ShellServer) signalForeground(name string) error {
+	sig, err := parseSignalName(name)
+	if err != nil {
+		return err
+	}
+	s.ptyMu.Lock()
+	f, shellPGID := s.ptyFile, s.shellPGID
+	s.ptyMu.Unlock()
+	if f == nil {
+		return errNoShell
+	}
+	pgid, err := getForegroundPGID(f)
+	if errors.Is(err, os.ErrClosed) {
+		return errNoShell
+	}
+	if err != nil {
+		return err
+	}
+	if pgid <= 0 {
+		pgid = shellPGID
+	}
+	return syscall.Kill(-pgid, sig)
+}
+
+// sendEOF types the PTY's end-of-file character, whatever stty has it
+// set to, so a program reading the terminal sees end of input.
+func (s *ShellServer) sendEOF() error {
+	s.ptyMu.Lock()
+	f := s.ptyFile
+	s.ptyMu.Unlock()
+	if f == nil {
+		return errNoShell
+	}
+	t, err := getTermios(f)
+	if err != nil {
+		return err
+	}
+	veof := t.Cc[syscall.VEOF]
+	if veof == 0 {
+		return errors.New("the terminal has no EOF character")
+	}
+	return s.writeToPTY([]byte{veof})
+}
+
+// sendStatus sends c the session's state, as the answer to a control
+// message that may have changed it. monitorStatus broadcasts the change
+// itself once it sees it.
+func (s *ShellServer) sendStatus(c *client) {
+	snap := s.Stats()
+	msg := map[string]any{"kind": "status", "state": snap.State}
+	if snap.Process != "" {
+		msg["process"] = snap.Process
+	}
+	data, _ := json.Marshal(msg)
+	for _, out := range s.clientPumps(func(o *client) bool { return o == c }) {
+		out.send(outbound{msgType: websocket.TextMessage, data: data})
+	}
+}
+
+// handleSignal serves POST /signal, {"name":"INT"}: the websocket's
+// {"kind":"signal"} for clients without one.
+func (s *ShellServer) handleSignal(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, r, http.MethodPost)
+		return
+	}
+	var req struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		invalidJSON(w, r, err)
+		return
+	}
+	switch err := s.signalForeground(req.Name); {
+	case errors.Is(err, errUnknownSignal):
+		respondError(w, r, http.StatusBadRequest, protocol.ErrInvalidRequest, err.Error())
+	case errors.Is(err, errNoShell):
+		shellNotRunning(w, r)
+	case err != nil:
+		respondError(w, r, http.StatusInternalServerError, protocol.ErrSignalFailed, "failed to signal the foreground job: "+err.Error())
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
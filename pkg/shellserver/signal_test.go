@@ -0,0 +1,75 @@
+package shellserver
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"shellserver/internal/testshell"
+	"shellserver/pkg/protocol"
+)
+
+func TestSignalControlInterruptsForegroundJob(t *testing.T) {
+	s, ts := startFakeShellServer(t)
+	c := testshell.Dial(t, ts.URL, "")
+
+	if err := s.writeToPTY([]byte("fg 100s\n")); err != nil {
+		t.Fatal(err)
+	}
+	if ev := c.ExpectEvent("status", testshell.DefaultTimeout); ev["state"] != "running" {
+		t.Fatalf("status = %v, want running", ev)
+	}
+	c.SendJSON(map[string]string{"kind": "signal", "name": "INT"})
+	// The acknowledgement, then the shell getting the terminal back
+	c.ExpectEvent("status", testshell.DefaultTimeout)
+	if ev := c.ExpectEvent("status", testshell.DefaultTimeout); ev["state"] != "waiting" {
+		t.Fatalf("status = %v, want waiting once the job is interrupted", ev)
+	}
+	c.Send(`raw "still-here\x21\n"`)
+	c.ExpectOutput("still-here!", testshell.DefaultTimeout)
+}
+
+func TestEOFControlUsesTerminalEOFChar(t *testing.T) {
+	_, ts := startFakeShellServer(t)
+	c := testshell.Dial(t, ts.URL, "")
+
+	// With ^A as EOF, a hardcoded ^D would be read as input
+	c.Send("stty eof=1")
+	c.ExpectOutput("stty eof=1\r\n$ ", testshell.DefaultTimeout)
+	c.SendJSON(map[string]string{"kind": "eof"})
+	for ev := c.ExpectEvent("status", testshell.DefaultTimeout); ev["state"] != "exited"; {
+		ev = c.ExpectEvent("status", testshell.DefaultTimeout)
+	}
+}
+
+func TestSignalEndpoint(t *testing.T) {
+	s, ts := startFakeShellServer(t)
+	c := testshell.Dial(t, ts.URL, "")
+
+	if err := s.writeToPTY([]byte("fg 100s\n")); err != nil {
+		t.Fatal(err)
+	}
+	if ev := c.ExpectEvent("status", testshell.DefaultTimeout); ev["state"] != "running" {
+		t.Fatalf("status = %v, want running", ev)
+	}
+	resp, err := http.Post(ts.URL+"/signal", "application/json", strings.NewReader(`{"name":"SIGKILL2"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusBadRequest || errorCode(t, resp) != protocol.ErrInvalidRequest {
+		t.Errorf("unknown signal: status %d", resp.StatusCode)
+	}
+	resp.Body.Close()
+
+	resp, err = http.Post(ts.URL+"/signal", "application/json", strings.NewReader(`{"name":"sigterm"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("POST /signal: status %d", resp.StatusCode)
+	}
+	if ev := c.ExpectEvent("status", testshell.DefaultTimeout); ev["state"] != "waiting" {
+		t.Errorf("status = %v, want waiting once the job is terminated", ev)
+	}
+}
@@ -0,0 +1,43 @@
+package shellserver
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+var flagStartupCommand = Flags.String("c", "", `command typed into the shell once it first sits idle at its prompt, and again after every restart, e.g. "cd ~/proj && source .envrc"`)
+
+// passthroughArgs returns rest, the arguments left on cmdline after the
+// flags, if they followed --; they are added to the shell's own argv.
+// Anything else left over is refused rather than quietly handed to the
+// shell.
+func passthroughArgs(cmdline, rest []string) ([]string, error) {
+	if len(rest) == 0 {
+		return nil, nil
+	}
+	if i := len(cmdline) - len(rest) - 1; i < 1 || cmdline[i] != "--" {
+		return nil, fmt.Errorf("unexpected argument %q; put arguments for the shell after --", rest[0])
+	}
+	return rest, nil
+}
+
+// startupSettle is how long a new shell's output must have been quiet,
+// after it printed something such as its prompt, before -c is typed.
+const startupSettle = 100 * time.Millisecond
+
+// shellSettled reports whether the shell has written output since
+// started and been quiet for startupSettle since.
+func (s *ShellServer) shellSettled(started, now time.Time) bool {
+	s.activityMu.Lock()
+	defer s.activityMu.Unlock()
+	return s.lastOutput.After(started) && now.Sub(s.lastOutput) >= startupSettle
+}
+
+// typeStartupCommand writes -c into the shell as if a client had typed
+// it, so the terminal echoes it into the scrollback like any command.
+func (s *ShellServer) typeStartupCommand() {
+	if err := s.writeToPTY([]byte(s.startupCommand + "\r")); err != nil {
+		log.Printf("startup command: %v", err)
+	}
+}
@@ -0,0 +1,50 @@
+package shellserver
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+
+	"shellserver/internal/testshell"
+)
+
+func TestPassthroughArgs(t *testing.T) {
+	for _, tc := range []struct {
+		cmdline, rest, want []string
+		err                 bool
+	}{
+		{[]string{"goshell", "-addr", ":1"}, nil, nil, false},
+		{[]string{"goshell", "--", "-i", "-c", "tmux attach"}, []string{"-i", "-c", "tmux attach"}, []string{"-i", "-c", "tmux attach"}, false},
+		{[]string{"goshell", "-c", "ls", "--", "-i"}, []string{"-i"}, []string{"-i"}, false},
+		{[]string{"goshell", "-addr", ":1", "stray"}, []string{"stray"}, nil, true},
+	} {
+		got, err := passthroughArgs(tc.cmdline, tc.rest)
+		if (err != nil) != tc.err || !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("passthroughArgs(%q) = %q, %v; want %q, error %v", tc.cmdline, got, err, tc.want, tc.err)
+		}
+	}
+}
+
+func TestStartupCommand(t *testing.T) {
+	defer func(old string) { *flagStartupCommand = old }(*flagStartupCommand)
+	*flagStartupCommand = "echo started-up"
+	_, ts := startFakeShellServer(t)
+
+	c := testshell.Dial(t, ts.URL, "")
+	// Echoed by the terminal as typed, then run
+	c.ExpectOutput("echo started-up\r\n", testshell.DefaultTimeout)
+	c.ExpectOutput("started-up\r\n$ ", testshell.DefaultTimeout)
+
+	resp, err := http.Post(ts.URL+"/restart", "application/json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	c.ExpectEvent("restarted", testshell.DefaultTimeout)
+	c.ExpectOutput("echo started-up\r\n", testshell.DefaultTimeout)
+	c.ExpectOutput("started-up\r\n$ ", testshell.DefaultTimeout)
+
+	// A client joining later sees it in context
+	late := testshell.Dial(t, ts.URL, "")
+	late.ExpectOutput("echo started-up\r\n", testshell.DefaultTimeout)
+}
@@ -0,0 +1,118 @@
+package shellserver
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"shellserver/pkg/protocol"
+)
+
+// Snapshot is the server's counters at one moment, the numbers /status,
+// /sessions and /debug/vars report, for code that runs a ShellServer in the
+// same process. There is one session; per-session figures will split
+// out of these once there are more.
+type Snapshot struct {
+	Clients        int           `json:"clients"`         // websocket clients connected
+	BytesIn        int64         `json:"bytes_in"`        // input written to the shell, from clients, widgets and macros
+	BytesOut       int64         `json:"bytes_out"`       // output read from the PTY
+	WidgetsStored  int64         `json:"widgets_stored"`  // HTML widgets created
+	WidgetsEvicted int64         `json:"widgets_evicted"` // widgets dropped by -widget-limit
+	Restarts       int64         `json:"restarts"`        // shells started after the first, by POST /restart or automatically
+	Bells          int64         `json:"bells"`           // BEL characters rung in the output, not counting those ending OSC sequences
+	State          string        `json:"state"`           // waiting, running, exited or shutdown
+	Process        string        `json:"process,omitempty"`
+	Uptime         time.Duration `json:"uptime_ns"`
+}
+
+// Event is a status change or widget store mutation, as OnEvent delivers
+// it: the websocket's status and widget messages.
+type Event struct {
+	Kind string // "status" or "widget"
+
+	// For status events
+	State   string
+	Process string
+	Code    int // the shell's exit status for state "exited"; -1 if unknown
+
+	// For widget events
+	Action   protocol.WidgetAction
+	WidgetID int
+	Title    string
+}
+
+// serverStats are the counters behind Stats, each updated where the thing
+// it counts happens.
+type serverStats struct {
+	started        time.Time
+	bytesIn        atomic.Int64
+	bytesOut       atomic.Int64
+	widgetsStored  atomic.Int64
+	widgetsEvicted atomic.Int64
+	restarts       atomic.Int64
+	bells          atomic.Int64
+
+	mu       sync.Mutex
+	state    string // "" until the first status change, meaning waiting
+	process  string
+	handlers []func(Event)
+}
+
+// Stats returns the server's counters as they stand.
+func (s *ShellServer) Stats() Snapshot {
+	s.clientsMu.RLock()
+	clients := len(s.clients)
+	s.clientsMu.RUnlock()
+
+	s.stats.mu.Lock()
+	state, process := s.stats.state, s.stats.process
+	s.stats.mu.Unlock()
+	if state == "" {
+		state = "waiting"
+	}
+
+	snap := Snapshot{
+		Clients:        clients,
+		BytesIn:        s.stats.bytesIn.Load(),
+		BytesOut:       s.stats.bytesOut.Load(),
+		WidgetsStored:  s.stats.widgetsStored.Load(),
+		WidgetsEvicted: s.stats.widgetsEvicted.Load(),
+		Restarts:       s.stats.restarts.Load(),
+		Bells:          s.stats.bells.Load(),
+		State:          state,
+		Process:        process,
+	}
+	if !s.stats.started.IsZero() {
+		snap.Uptime = time.Since(s.stats.started)
+	}
+	return snap
+}
+
+// OnEvent registers fn to be called with every status change and widget
+// store mutation, in the order clients see them. fn runs on the goroutine
+// that made the change, so it must return quickly and not call back into
+// the server other than for Stats.
+func (s *ShellServer) OnEvent(fn func(Event)) {
+	s.stats.mu.Lock()
+	defer s.stats.mu.Unlock()
+	s.stats.handlers = append(s.stats.handlers, fn)
+}
+
+// emit delivers ev to the OnEvent handlers.
+func (s *ShellServer) emit(ev Event) {
+	s.stats.mu.Lock()
+	handlers := s.stats.handlers
+	s.stats.mu.Unlock()
+	for _, fn := range handlers {
+		fn(ev)
+	}
+}
+
+// setStatus records the session's state for Stats and tells OnEvent
+// handlers. code is the exit status for "exited".
+func (s *ShellServer) setStatus(state, process string, code int) {
+	s.stats.mu.Lock()
+	s.stats.state, s.stats.process = state, process
+	s.stats.mu.Unlock()
+	s.emit(Event{Kind: "status", State: state, Process: process, Code: code})
+}
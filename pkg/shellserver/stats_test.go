@@ -0,0 +1,118 @@
+package shellserver
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"shellserver/internal/testshell"
+	"shellserver/pkg/protocol"
+)
+
+// eventLog collects what OnEvent delivers.
+type eventLog struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+func (l *eventLog) add(ev Event) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.events = append(l.events, ev)
+}
+
+func (l *eventLog) find(match func(Event) bool) (Event, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, ev := range l.events {
+		if match(ev) {
+			return ev, true
+		}
+	}
+	return Event{}, false
+}
+
+func TestStatsCountTraffic(t *testing.T) {
+	s, ts := startFakeShellServer(t)
+	var log eventLog
+	s.OnEvent(log.add)
+	before := s.Stats()
+	if before.Clients != 0 || before.State != "waiting" || before.Uptime <= 0 {
+		t.Errorf("initial stats = %+v", before)
+	}
+
+	c := testshell.Dial(t, ts.URL, "")
+	c.Send("echo counted")
+	c.ExpectOutput("\r\ncounted\r\n", testshell.DefaultTimeout)
+	c.Send(`raw "\x1b]9001;HTML_START\x07<b>stat</b>\x1b]9001;HTML_END\x07\n"`)
+	ev := c.ExpectEvent("html", testshell.DefaultTimeout)
+	id := int(ev["widget_id"].(float64))
+
+	after := s.Stats()
+	if after.Clients != 1 {
+		t.Errorf("Clients = %d, want 1", after.Clients)
+	}
+	if in := after.BytesIn - before.BytesIn; in < int64(len("echo counted\n")) {
+		t.Errorf("BytesIn grew by %d, want at least the line sent", in)
+	}
+	if out := after.BytesOut - before.BytesOut; out < int64(len("counted\r\n")) {
+		t.Errorf("BytesOut grew by %d, want at least the echo", out)
+	}
+	if n := after.WidgetsStored - before.WidgetsStored; n != 1 {
+		t.Errorf("WidgetsStored grew by %d, want 1", n)
+	}
+	if _, ok := log.find(func(ev Event) bool {
+		return ev.Kind == "widget" && ev.Action == protocol.WidgetCreated && ev.WidgetID == id
+	}); !ok {
+		t.Errorf("no widget created event for %d", id)
+	}
+}
+
+func TestStatsFollowStatusAndRestarts(t *testing.T) {
+	s, ts := startFakeShellServer(t)
+	var log eventLog
+	s.OnEvent(log.add)
+	c := testshell.Dial(t, ts.URL, "")
+
+	c.Send("fg 500ms")
+	c.ExpectEvent("status", testshell.DefaultTimeout)
+	if st := s.Stats(); st.State != "running" || st.Process == "" {
+		t.Errorf("while a job runs, stats = %+v", st)
+	}
+	if _, ok := log.find(func(ev Event) bool { return ev.Kind == "status" && ev.State == "running" }); !ok {
+		t.Error("OnEvent missed the change to running")
+	}
+
+	c.Send("exit 3")
+	waitFor(t, "the exit event", func() bool {
+		_, ok := log.find(func(ev Event) bool { return ev.State == "exited" && ev.Code == 3 })
+		return ok
+	})
+	waitFor(t, "the automatic restart", func() bool { return s.Stats().Restarts == 1 })
+	if st := s.Stats(); st.State != "waiting" {
+		t.Errorf("after the restart, State = %q, want waiting", st.State)
+	}
+}
+
+func TestStatsRaceFree(t *testing.T) {
+	s := newPumpTestServer()
+	s.stats.started = time.Now()
+	s.OnEvent(func(Event) { s.Stats() })
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				s.storeNewWidget([]byte("<b>w</b>"))
+				s.flushWidgetEvents()
+				s.setStatus("running", "make", 0)
+				s.Stats()
+			}
+		}()
+	}
+	wg.Wait()
+	if st := s.Stats(); st.WidgetsStored != 400 {
+		t.Errorf("WidgetsStored = %d, want 400", st.WidgetsStored)
+	}
+}
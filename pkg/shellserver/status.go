@@ -0,0 +1,115 @@
+package shellserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// sessionStatus is what /status reports.
+type sessionStatus struct {
+	Session     string `json:"session"`
+	Profile     string `json:"profile,omitempty"`
+	Tmpdir      string `json:"tmpdir,omitempty"`
+	TmpdirSize  int64  `json:"tmpdir_size"`
+	TmpdirQuota int64  `json:"tmpdir_quota"`
+	RawMode     bool   `json:"raw_mode"`
+	AltScreen   bool   `json:"alt_screen"` // a full-screen program holds the alternate screen
+
+	Scrollback scrollbackUsage `json:"scrollback"`
+
+	Usage sessionUsage `json:"usage"`
+
+	Mounts []fileMount `json:"mounts"` // directories served at /files/<token>/
+
+	Clients clientCounts `json:"clients"`
+
+	Shell   shellStatus `json:"shell"`
+	Queued  []queuedCmd `json:"queued_commands"` // widget commands waiting for the prompt
+	Title   string      `json:"title"`           // the terminal title the shell or its programs last set
+	Bells   int64       `json:"bells"`           // bells rung since the server started
+	Uptime  float64     `json:"uptime_sec"`
+	Widgets int         `json:"widgets"` // widgets in the store
+
+	WidgetStore widgetStoreUsage `json:"widget_store"`
+
+	Finished []finishedRun `json:"finished_commands"` // the last few commands run in the foreground, oldest first
+}
+
+// shellStatus is the "shell" object in /status. It's put together from
+// what monitorStatus and resizes last recorded, so /status never waits
+// on the PTY.
+type shellStatus struct {
+	PID            int    `json:"pid"`
+	PGID           int    `json:"pgid"`
+	ForegroundPGID int    `json:"foreground_pgid"`
+	State          string `json:"state"`
+	Process        string `json:"process,omitempty"`
+	Rows           int    `json:"rows"`
+	Cols           int    `json:"cols"`
+
+	LastExit *shellExit `json:"last_exit,omitempty"` // how the last shell to exit on its own ended
+}
+
+// shellStatus reports the shell's processes, its state as of snap, and
+// the terminal size.
+func (s *ShellServer) shellStatus(snap Snapshot) shellStatus {
+	s.ptyMu.Lock()
+	shellPGID := s.shellPGID
+	s.ptyMu.Unlock()
+	rows, cols := s.ptySize()
+	// The shell leads its own session, so its PID is its process group's.
+	st := shellStatus{
+		PID:            shellPGID,
+		PGID:           shellPGID,
+		ForegroundPGID: int(s.fgPGID.Load()),
+		State:          snap.State,
+		Process:        snap.Process,
+		Rows:           rows,
+		Cols:           cols,
+		LastExit:       s.lastExit.Load(),
+	}
+	if st.ForegroundPGID == 0 {
+		st.ForegroundPGID = shellPGID
+	}
+	return st
+}
+
+// handleStatus serves GET /status. The session temp dir and the shell's
+// CPU and memory use are measured, and the temp dir quota enforced, on
+// every request.
+func (s *ShellServer) handleStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r, http.MethodGet)
+		return
+	}
+	snap := s.Stats()
+	st := sessionStatus{
+		Session:     defaultSessionID,
+		Profile:     s.profileName(),
+		RawMode:     s.rawMode.Load(),
+		AltScreen:   s.altScreenActive(),
+		Scrollback:  s.scrollbackUsage(),
+		Usage:       s.sessionUsage(),
+		Mounts:      s.fileShares.list(time.Now()),
+		Clients:     s.clientCounts(),
+		Shell:       s.shellStatus(snap),
+		Queued:      s.cmdQueue.list(),
+		Title:       s.currentTitle(),
+		Bells:       snap.Bells,
+		Uptime:      snap.Uptime.Seconds(),
+		Widgets:     len(s.widgetIDs()),
+		WidgetStore: s.widgetStoreUsage(),
+		Finished:    s.recentRuns.list(),
+	}
+	if st.Mounts == nil {
+		st.Mounts = []fileMount{}
+	}
+	if s.sessionTmp != nil {
+		st.Tmpdir = s.sessionTmp.dir
+		st.TmpdirSize = s.sessionTmp.check(time.Now())
+		st.TmpdirQuota = s.sessionTmp.quota
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(st)
+}
@@ -0,0 +1,48 @@
+package shellserver
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"shellserver/internal/testshell"
+)
+
+func TestStatusReportsShell(t *testing.T) {
+	_, ts := startFakeShellServer(t)
+	c := testshell.Dial(t, ts.URL, "")
+
+	resp, err := http.Post(ts.URL+"/resize", "application/json", strings.NewReader(`{"rows":40,"cols":120}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	c.Send(`raw "\x1b]9001;HTML_START\x07<b>widget</b>\x1b]9001;HTML_END\x07\n"`)
+	c.ExpectEvent("html", testshell.DefaultTimeout)
+
+	st := getStatus(t, ts.URL)
+	if st.Shell.PID <= 0 || st.Shell.PGID != st.Shell.PID {
+		t.Errorf("shell pid %d, pgid %d; want the shell's own group", st.Shell.PID, st.Shell.PGID)
+	}
+	if st.Shell.ForegroundPGID <= 0 {
+		t.Errorf("foreground_pgid = %d", st.Shell.ForegroundPGID)
+	}
+	if st.Shell.Rows != 40 || st.Shell.Cols != 120 {
+		t.Errorf("size = %dx%d, want 40x120", st.Shell.Rows, st.Shell.Cols)
+	}
+	if st.Shell.State == "" {
+		t.Error("state missing")
+	}
+	if st.Uptime <= 0 {
+		t.Errorf("uptime_sec = %v", st.Uptime)
+	}
+	if st.Widgets != 1 {
+		t.Errorf("widgets = %d, want 1", st.Widgets)
+	}
+	if st.Clients.Connected != 1 {
+		t.Errorf("clients = %+v, want 1 connected", st.Clients)
+	}
+	if st.Scrollback.Bytes == 0 {
+		t.Error("scrollback bytes = 0 after output")
+	}
+}
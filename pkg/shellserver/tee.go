@@ -0,0 +1,293 @@
+package shellserver
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	flagTeeFile        = Flags.String("tee-file", "", "append everything the PTY emits to this file")
+	flagTeeFileMaxSize = Flags.Int64("tee-file-max-size", 0, "rotate -tee-file once it reaches this many bytes (0 never rotates)")
+	flagTeeFileKeep    = Flags.Int("tee-file-keep", 3, "rotated -tee-file copies kept, as <file>.1 (newest) to <file>.<n>")
+	flagTeeCmd         = Flags.String("tee-cmd", "", "pipe everything the PTY emits into this /bin/sh command, e.g. 'ts >> pty.log'")
+)
+
+// teeQueueLen is how many PTY reads a tee sink may fall behind before
+// further output is dropped for it.
+const teeQueueLen = 256
+
+// Backoff between restarts of a -tee-cmd command that died.
+const (
+	teeCmdMinBackoff = 100 * time.Millisecond
+	teeCmdMaxBackoff = 30 * time.Second
+)
+
+// teeCloseTimeout bounds how long closing a sink waits for its queue to
+// drain.
+const teeCloseTimeout = 5 * time.Second
+
+// errTeeCmdDown is a write to a -tee-cmd sink between restarts.
+var errTeeCmdDown = errors.New("tee command not running")
+
+// teeSink mirrors raw PTY output into w. Output is queued and written by
+// a goroutine of the sink's own, so a slow or stuck w can never hold up
+// the PTY: once the queue is full, offered output is dropped and counted.
+type teeSink struct {
+	name    string
+	w       io.WriteCloser
+	queue   chan []byte
+	done    chan struct{}
+	dropped atomic.Int64 // bytes not written: queue full, or w failed
+
+	mu     sync.Mutex // guards sending on queue against closing it
+	closed bool
+}
+
+// newTeeSink starts a sink writing to w, queueing up to n reads.
+func newTeeSink(name string, w io.WriteCloser, n int) *teeSink {
+	t := &teeSink{name: name, w: w, queue: make(chan []byte, n), done: make(chan struct{})}
+	go t.run()
+	return t
+}
+
+func (t *teeSink) run() {
+	defer close(t.done)
+	for data := range t.queue {
+		if _, err := t.w.Write(data); err != nil {
+			t.dropped.Add(int64(len(data)))
+			if !errors.Is(err, errTeeCmdDown) {
+				log.Printf("tee %s: %v", t.name, err)
+			}
+		}
+	}
+}
+
+// offer queues a copy of data without blocking, dropping it if the sink
+// is too far behind or closed.
+func (t *teeSink) offer(data []byte) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return
+	}
+	select {
+	case t.queue <- append([]byte(nil), data...):
+	default:
+		t.dropped.Add(int64(len(data)))
+	}
+}
+
+// close writes out what is queued and closes w. A sink whose writes are
+// stuck is abandoned after teeCloseTimeout.
+func (t *teeSink) close() error {
+	t.mu.Lock()
+	if t.closed {
+		t.mu.Unlock()
+		return nil
+	}
+	t.closed = true
+	close(t.queue)
+	t.mu.Unlock()
+
+	select {
+	case <-t.done:
+		return t.w.Close()
+	case <-time.After(teeCloseTimeout):
+		return fmt.Errorf("still writing after %v", teeCloseTimeout)
+	}
+}
+
+// openTeeSinks starts the sinks set asks for.
+func openTeeSinks(set Settings) ([]*teeSink, error) {
+	var sinks []*teeSink
+	if set.TeeFile != "" {
+		f, err := openRotatingFile(set.TeeFile, set.TeeFileMaxSize, set.TeeFileKeep)
+		if err != nil {
+			return nil, fmt.Errorf("tee file: %w", err)
+		}
+		sinks = append(sinks, newTeeSink("file", f, teeQueueLen))
+	}
+	if set.TeeCmd != "" {
+		c := &teeCmd{cmdline: set.TeeCmd, backoff: teeCmdMinBackoff}
+		if err := c.start(); err != nil {
+			closeTeeSinks(sinks)
+			return nil, fmt.Errorf("tee command: %w", err)
+		}
+		sinks = append(sinks, newTeeSink("cmd", c, teeQueueLen))
+	}
+	return sinks, nil
+}
+
+// closeTeeSinks flushes and closes sinks, logging failures.
+func closeTeeSinks(sinks []*teeSink) {
+	for _, t := range sinks {
+		if err := t.close(); err != nil {
+			log.Printf("tee %s: close: %v", t.name, err)
+		}
+	}
+}
+
+// tee offers raw PTY output to every sink.
+func (s *ShellServer) tee(data []byte) {
+	for _, t := range s.teeSinks {
+		t.offer(data)
+	}
+}
+
+// teeDropped reports the bytes each sink has dropped, for /debug/vars.
+func (s *ShellServer) teeDropped() map[string]int64 {
+	dropped := make(map[string]int64, len(s.teeSinks))
+	for _, t := range s.teeSinks {
+		dropped[t.name] = t.dropped.Load()
+	}
+	return dropped
+}
+
+// rotatingFile appends to a file, rotating it once it reaches maxSize:
+// path becomes path.1, path.1 becomes path.2, and so on up to keep.
+type rotatingFile struct {
+	path    string
+	maxSize int64 // 0 never rotates
+	keep    int
+
+	f    *os.File
+	size int64
+}
+
+func openRotatingFile(path string, maxSize int64, keep int) (*rotatingFile, error) {
+	r := &rotatingFile{path: path, maxSize: maxSize, keep: keep}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *rotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	r.f, r.size = f, info.Size()
+	return nil
+}
+
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	if r.maxSize > 0 && r.size > 0 && r.size+int64(len(p)) > r.maxSize {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.f.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// rotate shifts the rotated copies up, dropping the oldest, and starts a
+// new file.
+func (r *rotatingFile) rotate() error {
+	if err := r.f.Close(); err != nil {
+		return err
+	}
+	if r.keep > 0 {
+		for i := r.keep - 1; i > 0; i-- {
+			os.Rename(fmt.Sprintf("%s.%d", r.path, i), fmt.Sprintf("%s.%d", r.path, i+1))
+		}
+		if err := os.Rename(r.path, r.path+".1"); err != nil {
+			return err
+		}
+	} else if err := os.Remove(r.path); err != nil {
+		return err
+	}
+	return r.open()
+}
+
+func (r *rotatingFile) Close() error {
+	return r.f.Close()
+}
+
+// teeCmd writes to the stdin of a /bin/sh command. A command that dies is
+// restarted on a later write, after a backoff that doubles with each
+// failure; output written meanwhile is lost.
+type teeCmd struct {
+	cmdline string
+
+	mu        sync.Mutex
+	cmd       *exec.Cmd
+	stdin     io.WriteCloser
+	backoff   time.Duration
+	nextStart time.Time
+}
+
+// start runs the command. Its own output goes to goshell's stderr.
+func (c *teeCmd) start() error {
+	cmd := exec.Command("/bin/sh", "-c", c.cmdline)
+	cmd.Stdout, cmd.Stderr = os.Stderr, os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	c.cmd, c.stdin = cmd, stdin
+	return nil
+}
+
+// stop closes the command's stdin and waits for it to exit.
+func (c *teeCmd) stop() error {
+	c.stdin.Close()
+	err := c.cmd.Wait()
+	c.cmd, c.stdin = nil, nil
+	return err
+}
+
+func (c *teeCmd) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.cmd == nil {
+		if time.Now().Before(c.nextStart) {
+			return 0, errTeeCmdDown
+		}
+		if err := c.start(); err != nil {
+			c.fail()
+			return 0, err
+		}
+		log.Printf("tee command restarted")
+	}
+	n, err := c.stdin.Write(p)
+	if err != nil {
+		c.stop()
+		c.fail()
+		return n, err
+	}
+	c.backoff = teeCmdMinBackoff
+	return n, nil
+}
+
+// fail schedules the next start after the current backoff.
+func (c *teeCmd) fail() {
+	c.nextStart = time.Now().Add(c.backoff)
+	c.backoff = min(2*c.backoff, teeCmdMaxBackoff)
+}
+
+// Close ends the command's input and waits for it to finish.
+func (c *teeCmd) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.cmd == nil {
+		return nil
+	}
+	return c.stop()
+}
@@ -0,0 +1,162 @@
+package shellserver
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"shellserver/internal/testshell"
+)
+
+// slowSink is a tee destination whose writes block until released.
+type slowSink struct {
+	release chan struct{}
+
+	mu      sync.Mutex
+	written bytes.Buffer
+	closed  bool
+}
+
+func (w *slowSink) Write(p []byte) (int, error) {
+	<-w.release
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.written.Write(p)
+}
+
+func (w *slowSink) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.closed = true
+	return nil
+}
+
+func TestTeeSinkNeverBlocks(t *testing.T) {
+	w := &slowSink{release: make(chan struct{})}
+	sink := newTeeSink("slow", w, 2)
+
+	chunk := bytes.Repeat([]byte("x"), 100)
+	start := time.Now()
+	for i := 0; i < 10; i++ {
+		sink.offer(chunk)
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("offering to a stuck sink took %v", elapsed)
+	}
+	// one chunk may be in the stuck write and two in the queue
+	if d := sink.dropped.Load(); d < 700 || d > 800 {
+		t.Errorf("dropped %d bytes, want 700-800", d)
+	}
+
+	close(w.release)
+	if err := sink.close(); err != nil {
+		t.Fatal(err)
+	}
+	if !w.closed {
+		t.Error("sink not closed")
+	}
+	if got := int64(w.written.Len()) + sink.dropped.Load(); got != 1000 {
+		t.Errorf("written + dropped = %d bytes, want all 1000", got)
+	}
+	sink.offer(chunk) // after close: ignored, not a panic
+}
+
+func TestTeeFileRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pty.log")
+	f, err := openRotatingFile(path, 10, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"aaaaaa", "bbbbbb", "cccccc", "dddddd"} {
+		if _, err := f.Write([]byte(s)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	f.Close()
+
+	for name, want := range map[string]string{"pty.log": "dddddd", "pty.log.1": "cccccc", "pty.log.2": "bbbbbb"} {
+		got, err := os.ReadFile(filepath.Join(filepath.Dir(path), name))
+		if err != nil || string(got) != want {
+			t.Errorf("%s = %q (%v), want %q", name, got, err, want)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("%s.3 kept beyond -tee-file-keep: %v", path, err)
+	}
+}
+
+func TestTeeCmdRestarts(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "out")
+	c := &teeCmd{cmdline: "exec cat >> " + out, backoff: time.Millisecond}
+	if err := c.start(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Write([]byte("one\n")); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the command's output", func() bool {
+		got, _ := os.ReadFile(out)
+		return string(got) == "one\n"
+	})
+
+	c.mu.Lock()
+	c.cmd.Process.Kill()
+	c.mu.Unlock()
+
+	// writes fail once the pipe is gone, then succeed after the restart
+	waitFor(t, "a write to the killed command to fail", func() bool {
+		_, err := c.Write([]byte("lost\n"))
+		return err != nil
+	})
+	waitFor(t, "the command to restart", func() bool {
+		_, err := c.Write([]byte("two\n"))
+		return err == nil
+	})
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(out); string(got) != "one\ntwo\n" {
+		t.Errorf("command got %q", got)
+	}
+}
+
+// waitFor polls cond until it holds, failing the test after
+// testshell.DefaultTimeout.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(testshell.DefaultTimeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestTeeFileMirrorsPTY(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pty.log")
+	old := *flagTeeFile
+	*flagTeeFile = path
+	t.Cleanup(func() { *flagTeeFile = old })
+
+	s, ts := startFakeShellServer(t)
+	c := testshell.Dial(t, ts.URL, "")
+	c.Send("mark html-start")
+	c.Send("raw \"<b>teed</b>\"")
+	c.Send("mark html-end")
+	c.ExpectEvent("html", testshell.DefaultTimeout)
+
+	// the widget is extracted for clients but mirrored as the PTY sent it
+	waitFor(t, "the widget in the tee file", func() bool {
+		got, _ := os.ReadFile(path)
+		return strings.Contains(string(got), testshell.Markers["html-start"]) &&
+			strings.Contains(string(got), testshell.Markers["html-end"])
+	})
+	if d := s.teeDropped()["file"]; d != 0 {
+		t.Errorf("file sink dropped %d bytes", d)
+	}
+}
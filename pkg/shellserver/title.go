@@ -0,0 +1,50 @@
+package shellserver
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/gorilla/websocket"
+)
+
+// titlePayload returns the title an OSC 0, 1 or 2 payload sets. OSC 1 is
+// the icon name, which no browser tab has, so it counts as the title.
+func titlePayload(payload string) (string, bool) {
+	for _, prefix := range []string{"0;", "1;", "2;"} {
+		if title, ok := strings.CutPrefix(payload, prefix); ok {
+			return title, true
+		}
+	}
+	return "", false
+}
+
+// takeTitle returns the last title the output set since it was last
+// taken, if it set one.
+func (t *commandTracker) takeTitle() (string, bool) {
+	title, ok := t.title, t.titled
+	t.title, t.titled = "", false
+	return title, ok
+}
+
+// setTitle records the terminal's title and broadcasts
+// {"kind":"title","title":...} if it changed.
+func (s *ShellServer) setTitle(title string) {
+	previous := ""
+	if old := s.title.Swap(&title); old != nil {
+		previous = *old
+	}
+	if title == previous {
+		return
+	}
+	data, _ := json.Marshal(map[string]string{"kind": "title", "title": title})
+	s.broadcastMessage(websocket.TextMessage, data)
+}
+
+// currentTitle returns the title the shell or its programs last set, or
+// "".
+func (s *ShellServer) currentTitle() string {
+	if title := s.title.Load(); title != nil {
+		return *title
+	}
+	return ""
+}
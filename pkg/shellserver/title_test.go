@@ -0,0 +1,34 @@
+package shellserver
+
+import (
+	"strconv"
+	"testing"
+
+	"shellserver/internal/testshell"
+)
+
+func TestTitleTracked(t *testing.T) {
+	_, ts := startFakeShellServer(t)
+	c := testshell.Dial(t, ts.URL, "")
+
+	c.Send("raw " + strconv.Quote("\x1b]2;user@host: ~/src\x07"))
+	if ev := c.ExpectEvent("title", testshell.DefaultTimeout); ev["title"] != "user@host: ~/src" {
+		t.Errorf("title event = %v", ev)
+	}
+	// Still forwarded, for clients that set the title themselves
+	c.ExpectOutput("\x1b]2;user@host: ~/src\x07", testshell.DefaultTimeout)
+	if st := getStatus(t, ts.URL); st.Title != "user@host: ~/src" {
+		t.Errorf("/status title = %q", st.Title)
+	}
+
+	// Split across reads
+	c.Send(testshell.Wrap("\x1b]0;vim ma", "in.go\x07", "sleep 100ms"))
+	if ev := c.ExpectEvent("title", testshell.DefaultTimeout); ev["title"] != "vim main.go" {
+		t.Errorf("title event = %v, want vim main.go", ev)
+	}
+
+	late := testshell.Dial(t, ts.URL, "")
+	if late.Ready["title"] != "vim main.go" {
+		t.Errorf("ready title = %v", late.Ready["title"])
+	}
+}
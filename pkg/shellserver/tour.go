@@ -0,0 +1,263 @@
+package shellserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"shellserver/internal/persist"
+	"shellserver/internal/styles"
+	"shellserver/pkg/protocol"
+)
+
+// tourWidgetName is the widget name the tour's "don't show again" form
+// posts to, as /widget/tour/action.
+const tourWidgetName = "tour"
+
+// tourDismissAction is the internal widget action that turns off the tour
+// opening on its own.
+const tourDismissAction = "dismiss"
+
+// tourStep is one tab of the tour: a feature, explained, with commands
+// that demonstrate it at a click.
+type tourStep struct {
+	Title    string
+	Text     string // HTML
+	Commands []tourCommand
+}
+
+type tourCommand struct {
+	Label string
+	Cmd   string
+}
+
+var tourSteps = []tourStep{
+	{
+		Title: "Welcome",
+		Text: `<p>goshell keeps one shell running on the server. Close the tab and open it again, or connect from another browser, ` +
+			`and you're back in the same session with its recent output replayed.</p>` +
+			`<p>Programs that know about goshell draw interactive widgets in this panel. This tour shows a few; ` +
+			`the buttons run real commands in your shell.</p>`,
+	},
+	{
+		Title: "lsh",
+		Text: `<p><code>lsh</code> is <code>ls</code> as a widget: sortable columns, icons and sizes. ` +
+			`Its sort buttons re-run it with new flags, and <code>lsh -R -d 2</code> lists subdirectories as a tree.</p>`,
+		Commands: []tourCommand{{"Run lsh here", "lsh"}, {"Long listing, newest first", "lsh -l -t"}},
+	},
+	{
+		Title: "duh",
+		Text: `<p><code>duh</code> is <code>du</code> as a tree of what takes up space. ` +
+			`<code>-d</code> limits how deep it goes; a directory at the limit gets a button to look further.</p>`,
+		Commands: []tourCommand{{"Run duh -d 2", "duh -d 2"}},
+	},
+	{
+		Title: "Widget links",
+		Text: `<p>Each widget leaves a link in the terminal where it was printed; click it to bring the widget back. ` +
+			`Buttons in a widget type their command into the shell, and commands the server doesn't recognize as the bundled tools' ` +
+			`may ask you to approve them first.</p>`,
+	},
+	{
+		Title: "Status bar",
+		Text: `<p>The status bar above the terminal shows whether the shell is waiting for input or running something, ` +
+			`and which program has the terminal. Run a slow command and watch it change.</p>`,
+		Commands: []tourCommand{{"Show the status bar", "sleep 3"}},
+	},
+	{
+		Title: "Keyboard",
+		Text: `<p><kbd>Ctrl</kbd>+<kbd>.</kbd> moves the keyboard into the widget panel. There, the arrow keys move between rows, ` +
+			`<kbd>Space</kbd> selects, <kbd>Enter</kbd> types the selection into the terminal, <kbd>a</kbd> selects everything, ` +
+			`<kbd>c</kbd> copies and <kbd>Esc</kbd> goes back to the terminal.</p>`,
+	},
+}
+
+// tourPrefs is what <state-dir>/tour.json records. Its absence means
+// goshell hasn't run with this state dir before.
+type tourPrefs struct {
+	Dismissed bool `json:"dismissed"` // don't open the tour on its own
+}
+
+// tourStore keeps the tour preference. A nil store, for a server without
+// -state-dir, never opens the tour on its own and can't record one.
+type tourStore struct {
+	path     string
+	autoOpen atomic.Bool // open for the next client; see arm
+
+	mu       sync.Mutex
+	widgetID int // the last tour shown; 0 for none
+}
+
+func newTourStore(stateDir string) *tourStore {
+	if stateDir == "" {
+		return nil
+	}
+	return &tourStore{path: filepath.Join(stateDir, "tour.json")}
+}
+
+// load reads the preference; a missing file is the zero tourPrefs.
+func (ts *tourStore) load() (tourPrefs, error) {
+	var p tourPrefs
+	if err := persist.Load(ts.path, &p); err != nil && !os.IsNotExist(err) {
+		return p, err
+	}
+	return p, nil
+}
+
+// shouldOpen reports whether the tour opens on its own: on the first run
+// with the state dir and each run after until it's dismissed.
+func (ts *tourStore) shouldOpen() bool {
+	if ts == nil {
+		return false
+	}
+	p, err := ts.load()
+	return err == nil && !p.Dismissed
+}
+
+// arm has the tour open for the next writer to connect, if shouldOpen.
+func (ts *tourStore) arm() {
+	if ts.shouldOpen() {
+		ts.autoOpen.Store(true)
+	}
+}
+
+// takeAutoOpen reports whether the tour was armed, disarming it.
+func (ts *tourStore) takeAutoOpen() bool {
+	return ts != nil && ts.autoOpen.CompareAndSwap(true, false)
+}
+
+// dismiss records that the tour shouldn't open on its own again.
+func (ts *tourStore) dismiss() error {
+	if ts == nil {
+		return errors.New("goshell runs without -state-dir, so there is nowhere to record the preference")
+	}
+	ts.autoOpen.Store(false)
+	return persist.Save(ts.path, tourPrefs{Dismissed: true})
+}
+
+// tourWidgetHTML is the tour: a tab per step. With dismissable, it ends
+// with a "don't show again" form posting the dismiss action to
+// /widget/tour/action; once dismissed, with a note saying so instead.
+func tourWidgetHTML(dismissable, dismissed bool) []byte {
+	var b bytes.Buffer
+	b.WriteString(`<style>` + styles.BaseCSS() + styles.TabsCSS() + tourCSS + `</style>`)
+	b.WriteString(`<div class="shell-container shell-tour"><div class="shell-header"><div class="shell-title">Welcome to goshell</div>`)
+	b.WriteString(`<div class="shell-meta">Run <code>goshell tour</code> to open this again.</div></div>`)
+	tabs := make([]styles.Tab, len(tourSteps))
+	for i, step := range tourSteps {
+		body := step.Text
+		if len(step.Commands) > 0 {
+			body += `<div class="tour-commands">`
+			for _, c := range step.Commands {
+				body += styles.SortButton(styles.HTMLEscape(c.Label), "", c.Cmd, false)
+			}
+			body += `</div>`
+		}
+		tabs[i] = styles.Tab{Label: fmt.Sprintf("%d. %s", i+1, step.Title), Body: body}
+	}
+	b.WriteString(styles.Tabs("goshell-tour", tabs))
+	switch {
+	case dismissed:
+		b.WriteString(`<div class="tour-footer">The tour won't open on its own again.</div>`)
+	case dismissable:
+		fmt.Fprintf(&b, `<form class="widget-action-form tour-footer" method="post" action="widget/%s/action" data-action="%s">`, tourWidgetName, tourDismissAction)
+		b.WriteString(`<button class="shell-sort-btn">Don't show this again</button></form>`)
+	}
+	b.WriteString(`</div>`)
+	return b.Bytes()
+}
+
+const tourCSS = `
+.shell-tour p {
+	margin: 0 0 6px;
+	max-width: 70ch;
+}
+.tour-commands {
+	display: flex;
+	gap: 6px;
+	margin-top: 8px;
+}
+.tour-footer {
+	margin-top: 10px;
+	font-size: 11px;
+}
+`
+
+// showTour stores and broadcasts a new tour widget, returning its ID.
+func (s *ShellServer) showTour() int {
+	content := tourWidgetHTML(s.tour != nil, false)
+	id := s.storeNewWidget(content)
+	s.flushWidgetEvents()
+	s.broadcastWidget(id, content)
+	if s.tour != nil {
+		s.tour.mu.Lock()
+		s.tour.widgetID = id
+		s.tour.mu.Unlock()
+	}
+	return id
+}
+
+// dismissTour records the "don't show again" preference and replaces the
+// last tour shown with one saying so.
+func (s *ShellServer) dismissTour() error {
+	if err := s.tour.dismiss(); err != nil {
+		return err
+	}
+	s.tour.mu.Lock()
+	id := s.tour.widgetID
+	s.tour.mu.Unlock()
+	if id != 0 {
+		s.replaceWidget(id, tourWidgetHTML(true, true))
+	}
+	return nil
+}
+
+// handleTour serves POST /tour, which `goshell tour` sends: it opens the
+// tour and answers {"widget_id":N}.
+func (s *ShellServer) handleTour(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, r, http.MethodPost)
+		return
+	}
+	id := s.showTour()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"widget_id": id})
+}
+
+// runTour is `goshell tour`, run inside a session: it asks the server at
+// $GOSHELL_URL to open the tour.
+func runTour(args []string) error {
+	if len(args) > 0 {
+		return fmt.Errorf("unexpected arguments %q", args)
+	}
+	base := os.Getenv("GOSHELL_URL")
+	if base == "" {
+		return errors.New("GOSHELL_URL is not set; run goshell tour inside goshell")
+	}
+	req, err := http.NewRequest(http.MethodPost, base+"/tour", nil)
+	if err != nil {
+		return err
+	}
+	if token := os.Getenv("GOSHELL_TOKEN"); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var e protocol.ErrorResponse
+		if json.NewDecoder(resp.Body).Decode(&e) == nil && e.Error.Message != "" {
+			return errors.New(e.Error.Message)
+		}
+		return errors.New(strings.ToLower(resp.Status))
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package shellserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"shellserver/internal/testshell"
+)
+
+func TestTourFirstRunGate(t *testing.T) {
+	var none *tourStore
+	if none.shouldOpen() || none.takeAutoOpen() {
+		t.Error("a server without -state-dir opened the tour")
+	}
+
+	dir := t.TempDir()
+	ts := newTourStore(dir)
+	if !ts.shouldOpen() {
+		t.Fatal("the tour doesn't open on the first run")
+	}
+	ts.arm()
+	if !ts.takeAutoOpen() || ts.takeAutoOpen() {
+		t.Error("an armed tour should open exactly once")
+	}
+	if !newTourStore(dir).shouldOpen() {
+		t.Error("the tour stopped opening before it was dismissed")
+	}
+
+	if err := ts.dismiss(); err != nil {
+		t.Fatal(err)
+	}
+	again := newTourStore(dir)
+	again.arm()
+	if again.shouldOpen() || again.takeAutoOpen() {
+		t.Error("the tour opened after it was dismissed")
+	}
+}
+
+func TestTourWidgetSteps(t *testing.T) {
+	html := string(tourWidgetHTML(true, false))
+	for _, want := range []string{
+		`class="shell-tabs"`,
+		`<label class="shell-tab" for="goshell-tour-1">1. Welcome</label>`,
+		`runCommand(&quot;lsh&quot;)`,
+		`runCommand(&quot;duh -d 2&quot;)`,
+		`runCommand(&quot;sleep 3&quot;)`,
+		`<kbd>Ctrl</kbd>+<kbd>.</kbd>`,
+		`action="widget/tour/action" data-action="dismiss"`,
+	} {
+		if !strings.Contains(html, want) {
+			t.Errorf("tour widget missing %s", want)
+		}
+	}
+	if n := strings.Count(html, `<section class="shell-tab-panel">`); n != len(tourSteps) {
+		t.Errorf("tour has %d tabs, want one per step (%d)", n, len(tourSteps))
+	}
+
+	if html := string(tourWidgetHTML(false, false)); strings.Contains(html, "<form") {
+		t.Error("a tour that can't record the preference offers to")
+	}
+	if html := string(tourWidgetHTML(true, true)); strings.Contains(html, "<form") || !strings.Contains(html, "won't open on its own again") {
+		t.Error("a dismissed tour should say so instead of offering the form")
+	}
+}
+
+func TestTourOpensForFirstWriter(t *testing.T) {
+	defer func(old string) { *flagStateDir = old }(*flagStateDir)
+	*flagStateDir = t.TempDir()
+	s, ts := startFakeShellServer(t)
+	s.tour.arm()
+
+	observer := testshell.Dial(t, ts.URL, "?role=observer")
+	if !s.tour.autoOpen.Load() {
+		t.Fatal("an observer took the tour")
+	}
+	testshell.Dial(t, ts.URL, "")
+	ev := observer.ExpectEvent("html", testshell.DefaultTimeout)
+	if !strings.Contains(ev["content"].(string), "Welcome to goshell") {
+		t.Errorf("html event = %v, want the tour", ev)
+	}
+	if s.tour.takeAutoOpen() {
+		t.Error("the tour is still armed after opening")
+	}
+}
+
+func TestTourDismissRoundTrip(t *testing.T) {
+	defer func(old string) { *flagStateDir = old }(*flagStateDir)
+	*flagStateDir = t.TempDir()
+	s, ts := startFakeShellServer(t)
+
+	resp, err := http.Post(ts.URL+"/tour", "application/json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var shown struct {
+		WidgetID int `json:"widget_id"`
+	}
+	json.NewDecoder(resp.Body).Decode(&shown)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || shown.WidgetID == 0 {
+		t.Fatalf("POST /tour = %d, %+v", resp.StatusCode, shown)
+	}
+
+	resp, err = http.Post(ts.URL+"/widget/tour/action", "application/json", strings.NewReader(`{"type":"internal","action":"dismiss"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("dismiss = %d", resp.StatusCode)
+	}
+
+	if _, err := os.Stat(filepath.Join(*flagStateDir, "tour.json")); err != nil {
+		t.Errorf("preference not saved: %v", err)
+	}
+	if newTourStore(*flagStateDir).shouldOpen() {
+		t.Error("the saved preference doesn't stop the tour opening")
+	}
+	if html, _ := s.widgetHTML(shown.WidgetID); strings.Contains(html, "<form") {
+		t.Error("the tour shown still offers to be dismissed")
+	}
+}
+
+func TestTourDismissWithoutStateDir(t *testing.T) {
+	s := newPumpTestServer()
+	s.widgets = make(map[string]*Widget)
+	rec := httptest.NewRecorder()
+	s.handleWidgetAction(rec, httptest.NewRequest(http.MethodPost, "/widget/tour/action", strings.NewReader(`{"type":"internal","action":"dismiss"}`)))
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "-state-dir") {
+		t.Errorf("dismiss without -state-dir = %d %s", rec.Code, rec.Body)
+	}
+}
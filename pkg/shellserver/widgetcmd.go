@@ -0,0 +1,156 @@
+package shellserver
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/websocket"
+
+	"shellserver/internal/freshness"
+	"shellserver/pkg/protocol"
+)
+
+var (
+	flagWidgetCmdAllow  stringListFlag
+	flagWidgetCmdSigned = Flags.Bool("widget-cmd-signed", false, "run only widget commands written into the stored HTML of the widget that sends them")
+)
+
+func init() {
+	Flags.Var(&flagWidgetCmdAllow, "widget-cmd-allow", "regexp a widget command must match to run at all, confirmed or not (repeatable; default: invocations of the lsh, duh and serveh installed beside goshell)")
+}
+
+// isAllowedCmd reports whether cmd may run from a widget at all: it
+// matches -widget-cmd-allow, or is trusted to run without confirmation.
+func (s *ShellServer) isAllowedCmd(cmd string) bool {
+	for _, re := range s.settings().allowedCmds {
+		if re.MatchString(cmd) {
+			return true
+		}
+	}
+	return s.isTrustedCmd(cmd)
+}
+
+// checkWidgetCmd returns why widget, the ID in /widget/{id}/action, may
+// not run cmd, or nil if it may.
+func (s *ShellServer) checkWidgetCmd(widget, cmd string) error {
+	if !s.isAllowedCmd(cmd) {
+		return errors.New("not matched by -widget-cmd-allow")
+	}
+	if s.signedWidgetCmds && !s.widgetEmbedsCmd(widget, cmd) {
+		return fmt.Errorf("not written into widget %s", widget)
+	}
+	return nil
+}
+
+// rejectWidgetCmd refuses cmd with 403 and warns every client, so a
+// widget trying to run something it shouldn't doesn't go unnoticed.
+func (s *ShellServer) rejectWidgetCmd(w http.ResponseWriter, r *http.Request, cmd string, why error) {
+	log.Printf("widget command rejected (%v): %q", why, cmd)
+	data, _ := json.Marshal(map[string]string{"kind": "widget-cmd-rejected", "cmd": cmd, "reason": why.Error()})
+	s.broadcastMessage(websocket.TextMessage, data)
+	respondError(w, r, http.StatusForbidden, protocol.ErrCommandNotAllowed, "widget command "+why.Error())
+}
+
+// runCommandRE finds the string literal passed to runCommand in a
+// widget's HTML, once attribute entities are decoded.
+var runCommandRE = regexp.MustCompile(`runCommand\(\s*("(?:[^"\\]|\\.)*"|'(?:[^'\\]|\\.)*')`)
+
+// embeddedCmds returns the commands content can run: the literals its
+// runCommand calls pass and its freshness marker's refresh command. A
+// command put together by script when clicked isn't among them.
+func embeddedCmds(content []byte) []string {
+	text := html.UnescapeString(string(content))
+	var cmds []string
+	for _, m := range runCommandRE.FindAllStringSubmatch(text, -1) {
+		cmds = append(cmds, jsUnquote(m[1]))
+	}
+	if _, attrs, ok := freshness.Find(string(content)); ok && attrs["refresh"] != "" {
+		cmds = append(cmds, attrs["refresh"])
+	}
+	return cmds
+}
+
+// jsUnquote returns the value of a quoted JavaScript string literal. Only
+// the escapes a command is likely to hold are decoded; any other escaped
+// character stands for itself.
+func jsUnquote(lit string) string {
+	body := lit[1 : len(lit)-1]
+	if !strings.Contains(body, `\`) {
+		return body
+	}
+	var b strings.Builder
+	for i := 0; i < len(body); i++ {
+		c := body[i]
+		if c == '\\' && i+1 < len(body) {
+			i++
+			switch c = body[i]; c {
+			case 'n':
+				c = '\n'
+			case 't':
+				c = '\t'
+			}
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+// newWidgetCmdKey makes the key stored widgets' commands are signed with.
+func newWidgetCmdKey() []byte {
+	key := make([]byte, 32)
+	rand.Read(key)
+	return key
+}
+
+// cmdMAC signs cmd under the key made at startup.
+func (s *ShellServer) cmdMAC(cmd string) string {
+	h := hmac.New(sha256.New, s.widgetCmdKey)
+	h.Write([]byte(cmd))
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil))
+}
+
+// signWidgetCmds stores, alongside widget id, the MACs of the commands
+// content embeds. The caller holds htmlWidgetsMu.
+func (s *ShellServer) signWidgetCmds(id int, content []byte) {
+	if !s.signedWidgetCmds {
+		return
+	}
+	if s.widgetCmdMACs == nil {
+		s.widgetCmdMACs = make(map[int][]string)
+	}
+	cmds := embeddedCmds(content)
+	macs := make([]string, len(cmds))
+	for i, cmd := range cmds {
+		macs[i] = s.cmdMAC(cmd)
+	}
+	s.widgetCmdMACs[id] = macs
+}
+
+// widgetEmbedsCmd reports whether the HTML widget with the ID widget
+// held cmd when it was stored by this server.
+func (s *ShellServer) widgetEmbedsCmd(widget, cmd string) bool {
+	id, err := strconv.Atoi(widget)
+	if err != nil {
+		return false
+	}
+	mac := s.cmdMAC(cmd)
+	s.htmlWidgetsMu.RLock()
+	defer s.htmlWidgetsMu.RUnlock()
+	for _, m := range s.widgetCmdMACs[id] {
+		if hmac.Equal([]byte(m), []byte(mac)) {
+			return true
+		}
+	}
+	return false
+}
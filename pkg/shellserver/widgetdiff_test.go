@@ -0,0 +1,139 @@
+package shellserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"shellserver/internal/diff"
+	"shellserver/internal/store"
+)
+
+func newDiffTestServer(ratio float64) *ShellServer {
+	return &ShellServer{
+		store:           store.NewMemory(),
+		htmlKeys:        make(map[string]int),
+		widgetVersions:  make(map[int]int),
+		widgetPatches:   make(map[int]*widgetPatch),
+		widgetIndex:     make(map[int]*widgetText),
+		widgetDiffRatio: ratio,
+	}
+}
+
+// tableWidget renders rows as a widget with one table row per line.
+func tableWidget(rows []string) string {
+	var b strings.Builder
+	b.WriteString("<style>td { color: green; }</style>\n<table>\n")
+	for _, row := range rows {
+		fmt.Fprintf(&b, "<tr><td>%s</td></tr>\n", row)
+	}
+	b.WriteString("</table>\n")
+	return b.String()
+}
+
+func widgetRows(n int, label string) []string {
+	rows := make([]string, n)
+	for i := range rows {
+		rows[i] = fmt.Sprintf("%s row %d", label, i)
+	}
+	return rows
+}
+
+// replaceWidget writes content through the replaces-widget protocol.
+func replaceWidget(s *ShellServer, content string) int {
+	block := "\x1b]9001;HTML_START;key=k\x07" + content + string(htmlEndMarker)
+	_, _, ids, updated := s.extractAndStoreHTML([]byte(block))
+	return append(ids, updated...)[0]
+}
+
+func TestHTMLUpdatePatchOrReload(t *testing.T) {
+	base := widgetRows(200, "file")
+	oneRow := append([]string{}, base...)
+	oneRow[100] = "file row 100 (modified)"
+	tenth := append([]string{}, base...)
+	for i := 0; i < len(tenth); i += 10 {
+		tenth[i] += " (modified)"
+	}
+
+	tests := []struct {
+		name      string
+		ratio     float64
+		revision  []string
+		wantPatch bool
+	}{
+		{"one row changed", 0.5, oneRow, true},
+		{"every tenth row changed", 0.5, tenth, true},
+		{"every tenth row, strict ratio", 0.05, tenth, false},
+		{"everything changed", 0.5, widgetRows(200, "dir"), false},
+		{"diffing disabled", 0, oneRow, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newDiffTestServer(tt.ratio)
+			old := tableWidget(base)
+			id := replaceWidget(s, old)
+			new := tableWidget(tt.revision)
+			replaceWidget(s, new)
+
+			msg := s.htmlUpdateMessage(id)
+			if msg["version"] != 2 {
+				t.Errorf("version = %v, want 2", msg["version"])
+			}
+			patch, hasPatch := msg["patch"].([]diff.Edit)
+			if hasPatch != tt.wantPatch || (msg["reload"] == true) == tt.wantPatch {
+				t.Fatalf("message = %v, want patch %v", msg, tt.wantPatch)
+			}
+			if !hasPatch {
+				return
+			}
+			if msg["base"] != 1 {
+				t.Errorf("base = %v, want 1", msg["base"])
+			}
+			if got, err := diff.Apply(old, patch); err != nil || got != new {
+				t.Errorf("patch doesn't reproduce the new revision: %v", err)
+			}
+		})
+	}
+}
+
+func TestHTMLWidgetServesMissedPatch(t *testing.T) {
+	s := newDiffTestServer(0.5)
+	rows := widgetRows(50, "file")
+	id := replaceWidget(s, tableWidget(rows))
+	rows[7] = "changed"
+	replaceWidget(s, tableWidget(rows))
+
+	get := func(query string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		s.handleHTMLWidget(rec, httptest.NewRequest(http.MethodGet, fmt.Sprintf("/htmlwidget/%d%s", id, query), nil))
+		return rec
+	}
+
+	rec := get("?base=1")
+	var p widgetPatch
+	if rec.Header().Get("Content-Type") != "application/json" || json.NewDecoder(rec.Body).Decode(&p) != nil {
+		t.Fatalf("?base=1 answered %q, want a JSON patch", rec.Header().Get("Content-Type"))
+	}
+	if p.Base != 1 || p.Version != 2 || len(p.Patch) == 0 {
+		t.Errorf("patch = %+v, want 1 -> 2", p)
+	}
+
+	// Versions the server has no patch from get the whole widget
+	for _, query := range []string{"", "?base=0", "?base=2"} {
+		rec := get(query)
+		if !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/html") || rec.Header().Get("X-Widget-Version") != "2" {
+			t.Errorf("%q answered %q version %q, want full HTML at version 2",
+				query, rec.Header().Get("Content-Type"), rec.Header().Get("X-Widget-Version"))
+		}
+	}
+
+	// Once the patch expires, so does the shortcut
+	s.widgetPatches[id].expires = time.Now().Add(-time.Second)
+	if rec := get("?base=1"); rec.Header().Get("Content-Type") == "application/json" {
+		t.Error("expired patch still served")
+	}
+}
@@ -0,0 +1,216 @@
+package shellserver
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"shellserver/pkg/protocol"
+)
+
+// Limits for client-reported widget errors.
+const (
+	widgetErrorRingSize   = 20               // reports kept per widget
+	widgetErrorRateLimit  = 5                // reports accepted per window
+	widgetErrorRateWindow = 10 * time.Second // rate-limit window
+	maxWidgetErrorMessage = 1024
+	maxWidgetErrorStack   = 8 * 1024
+	maxWidgetErrorContext = 4 * 1024
+	maxWidgetErrorBody    = 64 * 1024
+)
+
+// WidgetError is one error a widget reported from the browser.
+type WidgetError struct {
+	Time    time.Time       `json:"time"`
+	Message string          `json:"message"`
+	Stack   string          `json:"stack,omitempty"`
+	Context json.RawMessage `json:"context,omitempty"`
+}
+
+// widgetErrorLog is the bounded error history of one HTML widget.
+type widgetErrorLog struct {
+	recent []WidgetError // oldest first, at most widgetErrorRingSize
+	total  int
+	window []time.Time // accepted report times inside the rate window
+}
+
+var errWidgetErrorRateLimited = errors.New("too many error reports")
+
+// truncateString cuts s to at most max bytes, marking the cut.
+func truncateString(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	const marker = "…[truncated]"
+	cut := max - len(marker)
+	for cut > 0 && !utf8.RuneStart(s[cut]) {
+		cut--
+	}
+	return s[:cut] + marker
+}
+
+// recordWidgetError stores a report for an HTML widget, enforcing the
+// per-widget rate limit.
+func (s *ShellServer) recordWidgetError(id int, report WidgetError, now time.Time) error {
+	report.Time = now
+	report.Message = truncateString(report.Message, maxWidgetErrorMessage)
+	report.Stack = truncateString(report.Stack, maxWidgetErrorStack)
+	if len(report.Context) > maxWidgetErrorContext {
+		report.Context = nil
+	}
+
+	s.widgetErrorsMu.Lock()
+	defer s.widgetErrorsMu.Unlock()
+
+	el, ok := s.widgetErrors[id]
+	if !ok {
+		el = &widgetErrorLog{}
+		s.widgetErrors[id] = el
+	}
+
+	kept := el.window[:0]
+	for _, t := range el.window {
+		if now.Sub(t) < widgetErrorRateWindow {
+			kept = append(kept, t)
+		}
+	}
+	el.window = kept
+	if len(el.window) >= widgetErrorRateLimit {
+		return errWidgetErrorRateLimited
+	}
+	el.window = append(el.window, now)
+
+	el.total++
+	el.recent = append(el.recent, report)
+	if len(el.recent) > widgetErrorRingSize {
+		el.recent = el.recent[len(el.recent)-widgetErrorRingSize:]
+	}
+
+	log.Printf("DEBUG: widget %d error: %s", id, report.Message)
+	return nil
+}
+
+// widgetErrorIDFromPath parses the HTML widget ID from /widget/{id}/error.
+func widgetErrorIDFromPath(path string) (int, error) {
+	rest, ok := strings.CutPrefix(path, "/widget/")
+	if !ok {
+		return 0, errors.New("invalid path")
+	}
+	idStr, ok := strings.CutSuffix(rest, "/error")
+	if !ok || strings.Contains(idStr, "/") {
+		return 0, errors.New("invalid widget error path")
+	}
+	id, ok := parseWidgetID(idStr)
+	if !ok {
+		return 0, errors.New("invalid widget id")
+	}
+	return id, nil
+}
+
+// handleWidget routes /widget/{id}/action and /widget/{id}/error.
+func (s *ShellServer) handleWidget(w http.ResponseWriter, r *http.Request) {
+	if strings.HasSuffix(r.URL.Path, "/error") {
+		s.handleWidgetError(w, r)
+		return
+	}
+	s.handleWidgetAction(w, r)
+}
+
+func (s *ShellServer) handleWidgetError(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, r, http.MethodPost)
+		return
+	}
+
+	id, err := widgetErrorIDFromPath(r.URL.Path)
+	if err != nil {
+		notFound(w, r)
+		return
+	}
+
+	if _, ok := s.widgetHTML(id); !ok {
+		s.widgetNotFound(w, r, id)
+		return
+	}
+
+	defer r.Body.Close()
+	var report WidgetError
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxWidgetErrorBody)).Decode(&report); err != nil {
+		invalidJSON(w, r, err)
+		return
+	}
+	if report.Message == "" {
+		respondError(w, r, http.StatusBadRequest, protocol.ErrInvalidRequest, "message required")
+		return
+	}
+
+	if err := s.recordWidgetError(id, report, time.Now()); err != nil {
+		w.Header().Set("Retry-After", strconv.Itoa(int(widgetErrorRateWindow.Seconds())))
+		respondError(w, r, http.StatusTooManyRequests, protocol.ErrRateLimited, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// htmlWidgetSummary describes a stored HTML widget in the list endpoint.
+type htmlWidgetSummary struct {
+	ID           int           `json:"id"`
+	Size         int           `json:"size"`
+	Stale        bool          `json:"stale,omitempty"` // made before the server restarted
+	ErrorCount   int           `json:"error_count"`
+	RecentErrors []WidgetError `json:"recent_errors,omitempty"`
+}
+
+// listHTMLWidgets returns a summary of every stored HTML widget, oldest first.
+func (s *ShellServer) listHTMLWidgets() []htmlWidgetSummary {
+	list := []htmlWidgetSummary{}
+	for _, id := range s.widgetIDs() {
+		if content, ok := s.widgetHTML(id); ok {
+			list = append(list, htmlWidgetSummary{ID: id, Size: len(content)})
+		}
+	}
+
+	s.htmlWidgetsMu.RLock()
+	for i := range list {
+		list[i].Stale = s.staleWidgets[list[i].ID]
+	}
+	s.htmlWidgetsMu.RUnlock()
+
+	s.widgetErrorsMu.Lock()
+	for i := range list {
+		if el, ok := s.widgetErrors[list[i].ID]; ok {
+			list[i].ErrorCount = el.total
+			list[i].RecentErrors = append([]WidgetError(nil), el.recent...)
+		}
+	}
+	s.widgetErrorsMu.Unlock()
+
+	return list
+}
+
+// handleHTMLWidgetList serves GET /htmlwidget/, the stored widgets with
+// the latest widget event's seq in X-Widget-Seq, or with ?since=<seq> the
+// widget events after seq.
+func (s *ShellServer) handleHTMLWidgetList(w http.ResponseWriter, r *http.Request) {
+	if v := r.URL.Query().Get("since"); v != "" {
+		since, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			respondError(w, r, http.StatusBadRequest, protocol.ErrInvalidRequest, "since must be an event seq")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(s.widgetJournal.since(since))
+		return
+	}
+	// Taken before listing, so catching up from it may repeat a mutation
+	// the list already shows but never misses one
+	seq := s.widgetJournal.latest()
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Widget-Seq", strconv.FormatInt(seq, 10))
+	json.NewEncoder(w).Encode(s.listHTMLWidgets())
+}
@@ -0,0 +1,116 @@
+package shellserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func postWidgetError(s *ShellServer, path, body string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	s.handleWidget(rec, httptest.NewRequest(http.MethodPost, path, strings.NewReader(body)))
+	return rec
+}
+
+func TestWidgetErrorStorage(t *testing.T) {
+	s, _ := newPipeServer(t)
+	s.store.Put(widgetNS, widgetKey(3), []byte("<div>widget</div>"))
+
+	body := `{"message":"TypeError: x is undefined","stack":"at onclick","context":{"cmd":"lsh"}}`
+	if rec := postWidgetError(s, "/widget/3/error", body); rec.Code != http.StatusNoContent {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusNoContent, rec.Body)
+	}
+
+	rec := httptest.NewRecorder()
+	s.handleHTMLWidget(rec, httptest.NewRequest(http.MethodGet, "/htmlwidget/", nil))
+	var list []htmlWidgetSummary
+	if err := json.NewDecoder(rec.Body).Decode(&list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].ID != 3 || list[0].ErrorCount != 1 {
+		t.Fatalf("list = %+v", list)
+	}
+	got := list[0].RecentErrors[0]
+	if got.Message != "TypeError: x is undefined" || got.Stack != "at onclick" || string(got.Context) != `{"cmd":"lsh"}` {
+		t.Errorf("stored error = %+v", got)
+	}
+}
+
+func TestWidgetErrorRejects(t *testing.T) {
+	s, _ := newPipeServer(t)
+	s.store.Put(widgetNS, widgetKey(1), []byte("x"))
+
+	tests := []struct {
+		name string
+		path string
+		body string
+		want int
+	}{
+		{"unknown widget", "/widget/9/error", `{"message":"m"}`, http.StatusNotFound},
+		{"non-numeric id", "/widget/abc/error", `{"message":"m"}`, http.StatusNotFound},
+		{"bad json", "/widget/1/error", `{`, http.StatusBadRequest},
+		{"missing message", "/widget/1/error", `{"stack":"s"}`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rec := postWidgetError(s, tt.path, tt.body); rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestWidgetErrorTruncation(t *testing.T) {
+	s, _ := newPipeServer(t)
+	s.store.Put(widgetNS, widgetKey(1), []byte("x"))
+
+	huge := strings.Repeat("é", maxWidgetErrorStack)
+	if err := s.recordWidgetError(1, WidgetError{Message: "boom", Stack: huge}, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	stack := s.widgetErrors[1].recent[0].Stack
+	if len(stack) > maxWidgetErrorStack {
+		t.Errorf("stack length = %d, want at most %d", len(stack), maxWidgetErrorStack)
+	}
+	if !strings.HasSuffix(stack, "[truncated]") || !strings.HasPrefix(stack, "éé") {
+		t.Errorf("stack not truncated cleanly: ...%q", stack[len(stack)-20:])
+	}
+}
+
+func TestWidgetErrorRateLimit(t *testing.T) {
+	s, _ := newPipeServer(t)
+	s.store.Put(widgetNS, widgetKey(1), []byte("x"))
+	s.store.Put(widgetNS, widgetKey(2), []byte("y"))
+	now := time.Now()
+
+	for i := 0; i < widgetErrorRateLimit; i++ {
+		if err := s.recordWidgetError(1, WidgetError{Message: "e"}, now); err != nil {
+			t.Fatalf("report %d rejected: %v", i, err)
+		}
+	}
+	if err := s.recordWidgetError(1, WidgetError{Message: "e"}, now); err != errWidgetErrorRateLimited {
+		t.Errorf("report over the limit: err = %v, want rate limited", err)
+	}
+	if rec := postWidgetError(s, "/widget/1/error", `{"message":"e"}`); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("HTTP status = %d, want %d", rec.Code, http.StatusTooManyRequests)
+	}
+
+	// Other widgets have their own budget, and the window slides.
+	if err := s.recordWidgetError(2, WidgetError{Message: "e"}, now); err != nil {
+		t.Errorf("widget 2 rejected: %v", err)
+	}
+	if err := s.recordWidgetError(1, WidgetError{Message: "e"}, now.Add(widgetErrorRateWindow)); err != nil {
+		t.Errorf("report after the window rejected: %v", err)
+	}
+
+	// The ring keeps only the newest reports.
+	for i := 0; i < widgetErrorRingSize*2; i++ {
+		s.recordWidgetError(2, WidgetError{Message: "e"}, now.Add(time.Duration(i)*widgetErrorRateWindow))
+	}
+	if n := len(s.widgetErrors[2].recent); n != widgetErrorRingSize {
+		t.Errorf("ring holds %d reports, want %d", n, widgetErrorRingSize)
+	}
+}
@@ -0,0 +1,124 @@
+package shellserver
+
+import (
+	"encoding/json"
+	"sync"
+
+	"github.com/gorilla/websocket"
+
+	"shellserver/pkg/protocol"
+)
+
+// widgetJournalLen is how many widget events are kept for ?since catch-up.
+const widgetJournalLen = 1000
+
+// widgetJournal numbers widget store mutations and keeps the latest for
+// clients catching up. Events are recorded while the store is locked, so
+// they are numbered in the order the mutations happened, and broadcast
+// once it is unlocked.
+type widgetJournal struct {
+	mu     sync.Mutex
+	seq    int64
+	events []protocol.WidgetEvent // the last widgetJournalLen, oldest first
+	sent   int64                  // events up to this seq have been broadcast
+}
+
+// record appends an event for a mutation of widget id.
+func (j *widgetJournal) record(action protocol.WidgetAction, id int, title string, version int) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.seq++
+	j.events = append(j.events, protocol.WidgetEvent{
+		Kind:     protocol.WidgetEventKind,
+		Seq:      j.seq,
+		Action:   action,
+		WidgetID: id,
+		Title:    title,
+		Version:  version,
+	})
+	if len(j.events) > widgetJournalLen {
+		j.events = append([]protocol.WidgetEvent(nil), j.events[len(j.events)-widgetJournalLen:]...)
+	}
+}
+
+// unsent returns the events not yet broadcast and marks them sent.
+func (j *widgetJournal) unsent() []protocol.WidgetEvent {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	var out []protocol.WidgetEvent
+	for _, ev := range j.events {
+		if ev.Seq > j.sent {
+			out = append(out, ev)
+		}
+	}
+	j.sent = j.seq
+	return out
+}
+
+// since returns the events after seq, or Truncated when some of them are
+// no longer kept.
+func (j *widgetJournal) since(seq int64) protocol.WidgetEvents {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	res := protocol.WidgetEvents{Seq: j.seq, Events: []protocol.WidgetEvent{}}
+	if seq > j.seq || seq < 0 {
+		res.Truncated = true
+		return res
+	}
+	if seq < j.seq && (len(j.events) == 0 || j.events[0].Seq > seq+1) {
+		res.Truncated = true
+		return res
+	}
+	for _, ev := range j.events {
+		if ev.Seq > seq {
+			res.Events = append(res.Events, ev)
+		}
+	}
+	return res
+}
+
+// latest is the seq of the last event.
+func (j *widgetJournal) latest() int64 {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.seq
+}
+
+// widgetEventLocked records a mutation of widget id with its current title
+// and version. The caller holds htmlWidgetsMu.
+func (s *ShellServer) widgetEventLocked(action protocol.WidgetAction, id int) {
+	var title string
+	if t := s.widgetIndex[id]; t != nil {
+		title = t.title
+	}
+	s.widgetJournal.record(action, id, title, s.widgetVersion(id))
+	switch action {
+	case protocol.WidgetCreated:
+		s.stats.widgetsStored.Add(1)
+	case protocol.WidgetEvicted:
+		s.stats.widgetsEvicted.Add(1)
+	}
+}
+
+// widgetEvent is widgetEventLocked for callers not holding htmlWidgetsMu.
+func (s *ShellServer) widgetEvent(action protocol.WidgetAction, id int) {
+	s.htmlWidgetsMu.RLock()
+	defer s.htmlWidgetsMu.RUnlock()
+	s.widgetEventLocked(action, id)
+}
+
+// flushWidgetEvents broadcasts the widget events recorded since the last
+// flush, and for each eviction {"kind":"widget-evicted","id":N}, so a
+// client can mark links to the widget stale without following the
+// journal. Call it without holding htmlWidgetsMu.
+func (s *ShellServer) flushWidgetEvents() {
+	for _, ev := range s.widgetJournal.unsent() {
+		data, _ := json.Marshal(ev)
+		s.broadcastMessage(websocket.TextMessage, data)
+		if ev.Action == protocol.WidgetEvicted {
+			data, _ = json.Marshal(map[string]any{"kind": "widget-evicted", "id": ev.WidgetID})
+			s.broadcastMessage(websocket.TextMessage, data)
+		}
+		s.emit(Event{Kind: "widget", Action: ev.Action, WidgetID: ev.WidgetID, Title: ev.Title})
+	}
+}
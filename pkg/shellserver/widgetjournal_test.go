@@ -0,0 +1,150 @@
+package shellserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"shellserver/internal/store"
+	"shellserver/internal/testshell"
+	"shellserver/pkg/protocol"
+)
+
+// eventSummary is an event as "action id v<version> title", for comparing
+// sequences.
+func eventSummary(ev protocol.WidgetEvent) string {
+	return fmt.Sprintf("%s %d v%d %s", ev.Action, ev.WidgetID, ev.Version, ev.Title)
+}
+
+func TestWidgetEventsForEveryMutation(t *testing.T) {
+	s := &ShellServer{
+		store:          store.NewMemory(),
+		widgetLimit:    2,
+		htmlKeys:       make(map[string]int),
+		widgetVersions: make(map[int]int),
+		widgetPatches:  make(map[int]*widgetPatch),
+		widgetIndex:    make(map[int]*widgetText),
+		widgetErrors:   make(map[int]*widgetErrorLog),
+	}
+	block := func(key, title string) []byte {
+		start := string(htmlStartMarker)
+		if key != "" {
+			start = "\x1b]9001;HTML_START;key=" + key + "\x07"
+		}
+		return []byte(start + "<title>" + title + "</title>" + string(htmlEndMarker))
+	}
+
+	s.extractAndStoreHTML(block("watch", "one"))        // created 1
+	s.extractAndStoreHTML(block("watch", "one v2"))     // replaced 1
+	s.extractAndStoreHTML(block("", "two"))             // created 2
+	s.replaceWidget(2, []byte("<title>two v2</title>")) // replaced 2
+	s.storeNewWidget([]byte("<title>three</title>"))    // created 3, evicts 1
+
+	want := []string{
+		"created 1 v1 one",
+		"replaced 1 v2 one v2",
+		"created 2 v1 two",
+		"replaced 2 v2 two v2",
+		"created 3 v1 three",
+		"evicted 1 v2 one v2",
+	}
+	res := s.widgetJournal.since(0)
+	var got []string
+	for i, ev := range res.Events {
+		if ev.Seq != int64(i+1) || ev.Kind != protocol.WidgetEventKind {
+			t.Errorf("event %d: seq %d kind %q", i, ev.Seq, ev.Kind)
+		}
+		got = append(got, eventSummary(ev))
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("events =\n%q\nwant\n%q", got, want)
+	}
+	if res.Seq != 6 || res.Truncated {
+		t.Errorf("since(0): seq %d truncated %v", res.Seq, res.Truncated)
+	}
+
+	// Catching up returns only what came after
+	if res := s.widgetJournal.since(4); len(res.Events) != 2 || res.Events[0].Seq != 5 {
+		t.Errorf("since(4) = %+v, want events 5 and 6", res.Events)
+	}
+	if res := s.widgetJournal.since(6); len(res.Events) != 0 || res.Truncated {
+		t.Errorf("since(latest) = %+v, want nothing", res)
+	}
+	// A seq from before a restart can't be caught up from
+	if res := s.widgetJournal.since(99); !res.Truncated {
+		t.Error("since beyond the latest seq not truncated")
+	}
+
+	// Broadcasting marks events sent
+	s.flushWidgetEvents()
+	if unsent := s.widgetJournal.unsent(); len(unsent) != 0 {
+		t.Errorf("events still unsent after flush: %v", unsent)
+	}
+}
+
+func TestWidgetJournalRetention(t *testing.T) {
+	var j widgetJournal
+	for i := 0; i < widgetJournalLen+10; i++ {
+		j.record(protocol.WidgetCreated, i+1, "", 1)
+	}
+	if len(j.events) != widgetJournalLen {
+		t.Fatalf("kept %d events, want %d", len(j.events), widgetJournalLen)
+	}
+	if res := j.since(5); !res.Truncated || len(res.Events) != 0 {
+		t.Errorf("since a dropped event: %+v, want truncated", res)
+	}
+	if res := j.since(10); res.Truncated || len(res.Events) != widgetJournalLen {
+		t.Errorf("since the last dropped event: truncated %v, %d events", res.Truncated, len(res.Events))
+	}
+}
+
+func TestWidgetEventsOverWebsocket(t *testing.T) {
+	s, ts := startFakeShellServer(t)
+	c := testshell.Dial(t, ts.URL, "")
+
+	c.Send("mark html-start")
+	c.Send("mark html-end")
+	ev := c.ExpectEvent(protocol.WidgetEventKind, testshell.DefaultTimeout)
+	if ev["action"] != "created" || ev["seq"] != float64(1) || ev["version"] != float64(1) {
+		t.Errorf("widget event = %v", ev)
+	}
+	id := int(ev["widget_id"].(float64))
+
+	s.replaceWidget(id, []byte("new"))
+	if ev := c.ExpectEvent(protocol.WidgetEventKind, testshell.DefaultTimeout); ev["action"] != "replaced" || ev["seq"] != float64(2) || ev["version"] != float64(2) {
+		t.Errorf("replace event = %v", ev)
+	}
+
+	resp, err := http.Get(ts.URL + "/htmlwidget/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if got := resp.Header.Get("X-Widget-Seq"); got != "2" {
+		t.Errorf("X-Widget-Seq = %q, want 2", got)
+	}
+
+	resp, err = http.Get(ts.URL + "/htmlwidget/?since=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var res protocol.WidgetEvents
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+	if res.Seq != 2 || res.Truncated || len(res.Events) != 1 || res.Events[0].Action != protocol.WidgetReplaced {
+		t.Errorf("?since=1 = %+v", res)
+	}
+
+	resp, err = http.Get(ts.URL + "/htmlwidget/?since=x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("?since=x: status %d, want 400", resp.StatusCode)
+	}
+}
@@ -0,0 +1,36 @@
+package shellserver
+
+import (
+	"fmt"
+
+	"shellserver/internal/styles"
+)
+
+// printWidgetDocument wraps a widget's HTML in a standalone document for
+// ?print=1: trees rendered expanded, since printing runs no toggle script,
+// and styles.PrintCSS loaded last so it overrides the widget's own
+// styles. The header's print button is left off the printed page.
+func printWidgetDocument(title, content string) string {
+	return `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>` + styles.HTMLEscape(title) + ` · goshell</title>
+</head>
+<body>
+<div class="shell-print-header"><span>` + styles.HTMLEscape(title) + `</span><button type="button" class="shell-print-button" onclick="window.print()">Print</button></div>
+` + styles.ExpandTreeTables(content) + `
+<style>` + styles.PrintCSS() + `</style>
+</body>
+</html>
+`
+}
+
+// widgetPrintTitle is widget id's title for its print document. The
+// caller holds htmlWidgetsMu.
+func (s *ShellServer) widgetPrintTitle(id int) string {
+	if t := s.widgetIndex[id]; t != nil && t.title != "" {
+		return t.title
+	}
+	return fmt.Sprintf("widget %d", id)
+}
@@ -0,0 +1,64 @@
+package shellserver
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"shellserver/internal/styles"
+)
+
+func TestWidgetPrintView(t *testing.T) {
+	s, ts := startFakeShellServer(t)
+
+	styles.ResetTreeNodeCounter()
+	tree := styles.RenderTreeTable([]*styles.TreeNode{{
+		Cells:      []string{"src"},
+		Expandable: true,
+		Children:   []*styles.TreeNode{{Cells: []string{"main.go"}}},
+	}}, styles.TreeTableConfig{TogglePrefix: "duh"})
+	id := s.storeNewWidget([]byte("<title>duh report</title><style>" + styles.TreeTableCSS() + "</style>" + tree))
+
+	get := func(query string) string {
+		t.Helper()
+		resp, err := http.Get(fmt.Sprintf("%s/htmlwidget/%d%s", ts.URL, id, query))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("GET %s: %d %s", query, resp.StatusCode, body)
+		}
+		return string(body)
+	}
+
+	page := get("?print=1")
+	for _, want := range []string{
+		"<!DOCTYPE html>",
+		"<title>duh report · goshell</title>",
+		`class="tree-children expanded"`,
+		`aria-expanded="true"`,
+		">main.go<",
+		`class="shell-print-button" onclick="window.print()"`,
+		styles.PrintCSS(),
+	} {
+		if !strings.Contains(page, want) {
+			t.Errorf("print view missing %q", want)
+		}
+	}
+	if strings.Contains(page, `class="tree-children"`) {
+		t.Error("print view left a subtree collapsed")
+	}
+	// The print stylesheet comes after the widget's own so it wins
+	if strings.Index(page, styles.PrintCSS()) < strings.Index(page, styles.TreeTableCSS()) {
+		t.Error("print CSS loaded before the widget's styles")
+	}
+
+	// The plain widget is untouched
+	if plain := get(""); strings.Contains(plain, "shell-print-header") || !strings.Contains(plain, `class="tree-children"`) {
+		t.Errorf("plain widget changed: %s", plain)
+	}
+}
@@ -0,0 +1,128 @@
+package shellserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+var (
+	flagWidgetRate       = Flags.Int("widget-rate", 20, "new widgets stored per -widget-rate-window; blocks beyond it are dropped (0 for no limit)")
+	flagWidgetRateWindow = Flags.Duration("widget-rate-window", 10*time.Second, "window -widget-rate counts over")
+)
+
+// widgetQuota limits how many widgets the shell's output may create per
+// window, so a script emitting HTML blocks in a loop can't flood the store
+// and every client. Blocks over the limit are dropped, and due reports
+// how many once the window ends. Keyed blocks replacing a widget create
+// nothing and are never limited. A limit of 0, or a nil quota, admits
+// everything.
+type widgetQuota struct {
+	mu         sync.Mutex
+	limit      int
+	window     time.Duration
+	start      time.Time // of the current window
+	created    int       // in the current window
+	suppressed int       // in the current window
+	ended      int       // suppressed in windows that have ended, not yet reported
+}
+
+// newWidgetQuota returns a quota of limit widgets per window.
+func newWidgetQuota(limit int, window time.Duration) *widgetQuota {
+	return &widgetQuota{limit: limit, window: window}
+}
+
+// set changes the limit, from the next window on.
+func (q *widgetQuota) set(limit int, window time.Duration) {
+	if q == nil {
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.limit, q.window = limit, window
+}
+
+// allow reports whether a widget may be created at now, counting it.
+func (q *widgetQuota) allow(now time.Time) bool {
+	if q == nil {
+		return true
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.limit <= 0 || q.window <= 0 {
+		return true
+	}
+	if q.start.IsZero() || now.Sub(q.start) >= q.window {
+		q.start, q.created = now, 0
+		q.ended, q.suppressed = q.ended+q.suppressed, 0
+	}
+	if q.created < q.limit {
+		q.created++
+		return true
+	}
+	q.suppressed++
+	return false
+}
+
+// due returns how many blocks were suppressed in the windows that have
+// ended by now and not been reported yet, and forgets them.
+func (q *widgetQuota) due(now time.Time) int {
+	if q == nil {
+		return 0
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if now.Sub(q.start) >= q.window {
+		q.ended, q.suppressed = q.ended+q.suppressed, 0
+	}
+	n := q.ended
+	q.ended = 0
+	return n
+}
+
+// reset forgets the window and what was suppressed in it, for a restarted
+// shell.
+func (q *widgetQuota) reset() {
+	if q == nil {
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.start, q.created, q.suppressed, q.ended = time.Time{}, 0, 0, 0
+}
+
+// widgetRate is the "widget-rate" capability: "20/10s", or "" for no
+// limit.
+func widgetRate(s *ShellServer) string {
+	ls := s.settings()
+	if ls.widgetRate <= 0 || ls.widgetRateWindow <= 0 {
+		return ""
+	}
+	return fmt.Sprintf("%d/%s", ls.widgetRate, ls.widgetRateWindow)
+}
+
+// reportSuppressedWidgets writes one line into the terminal in place of
+// the n HTML blocks the quota dropped, and tells clients with a single
+// event. The pump calls it between reads, so the line never splits the
+// shell's output; while a full-screen program has the screen there is
+// only the event, as a line would be drawn over its screen.
+func (s *ShellServer) reportSuppressedWidgets(n int) {
+	if !s.altScreenActive() {
+		line := []byte(fmt.Sprintf("\r\n\x1b[33m%d HTML outputs suppressed (rate limit)\x1b[0m\r\n", n))
+		s.output(line, false, nil)
+	}
+
+	s.widgetQuota.mu.Lock()
+	limit, window := s.widgetQuota.limit, s.widgetQuota.window
+	s.widgetQuota.mu.Unlock()
+	data, _ := json.Marshal(map[string]any{
+		"kind":      "widgets-suppressed",
+		"count":     n,
+		"limit":     limit,
+		"window_ms": window.Milliseconds(),
+	})
+	s.broadcastMessage(websocket.TextMessage, data)
+}
@@ -0,0 +1,100 @@
+package shellserver
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"shellserver/internal/testshell"
+)
+
+func TestWidgetQuota(t *testing.T) {
+	q := newWidgetQuota(2, 50*time.Millisecond)
+
+	now := time.Now()
+	var allowed int
+	for i := 0; i < 5; i++ {
+		if q.allow(now) {
+			allowed++
+		}
+	}
+	if allowed != 2 {
+		t.Errorf("allowed %d of 5 in one window, want 2", allowed)
+	}
+	if n := q.due(now.Add(49 * time.Millisecond)); n != 0 {
+		t.Errorf("due %d before the window ended, want 0", n)
+	}
+	if !q.allow(now.Add(50 * time.Millisecond)) {
+		t.Error("next window refused its first widget")
+	}
+	if n := q.due(now.Add(60 * time.Millisecond)); n != 3 {
+		t.Errorf("due %d once the window ended, want 3", n)
+	}
+	if n := q.due(now.Add(200 * time.Millisecond)); n != 0 {
+		t.Errorf("due %d again, want it reported once", n)
+	}
+
+	// A reset window admits the full limit again and reports nothing
+	q.allow(now)
+	q.allow(now)
+	q.allow(now)
+	q.reset()
+	if !q.allow(now) || !q.allow(now) {
+		t.Error("reset quota refused widgets")
+	}
+	if n := q.due(now.Add(time.Second)); n != 0 {
+		t.Errorf("reset quota still due %d", n)
+	}
+
+	if !newWidgetQuota(0, time.Second).allow(now) || !(*widgetQuota)(nil).allow(now) {
+		t.Error("-widget-rate=0 should admit everything")
+	}
+}
+
+func TestWidgetFloodSuppressed(t *testing.T) {
+	oldRate, oldWindow := *flagWidgetRate, *flagWidgetRateWindow
+	*flagWidgetRate, *flagWidgetRateWindow = 3, 300*time.Millisecond
+	t.Cleanup(func() { *flagWidgetRate, *flagWidgetRateWindow = oldRate, oldWindow })
+
+	s, ts := startFakeShellServer(t)
+	c := testshell.Dial(t, ts.URL, "")
+
+	block := func(i int) string {
+		return "\x1b]9001;HTML_START\x07<b>flood " + strconv.Itoa(i) + "</b>\x1b]9001;HTML_END\x07"
+	}
+	var burst strings.Builder
+	for i := 0; i < 10; i++ {
+		burst.WriteString(block(i))
+	}
+	c.Send("raw " + strconv.Quote(burst.String()+"done\n"))
+	for i := 0; i < 3; i++ {
+		c.ExpectEvent("html", testshell.DefaultTimeout)
+	}
+	c.ExpectOutput(`done\n"`, testshell.DefaultTimeout) // the echo
+	if out := c.ExpectOutput("done\r\n", testshell.DefaultTimeout); strings.Count(out, "View HTML Output") != 3 || strings.Contains(out, "flood") {
+		t.Errorf("output of the burst %q, want three links and no HTML", out)
+	}
+	// Reported at the first read once the window is over, between the
+	// shell's output and not in it
+	time.Sleep(300 * time.Millisecond)
+	c.Send("echo after")
+	if out := c.ExpectOutput("7 HTML outputs suppressed (rate limit)", testshell.DefaultTimeout); !strings.Contains(out, "after") {
+		t.Errorf("report came in %q, want it after the next output", out)
+	}
+	if ev := c.ExpectEvent("widgets-suppressed", testshell.DefaultTimeout); ev["count"] != float64(7) {
+		t.Errorf("suppression event %v, want count 7", ev)
+	}
+	s.htmlWidgetsMu.Lock()
+	stored := s.htmlCounter
+	s.htmlWidgetsMu.Unlock()
+	if stored != 3 {
+		t.Errorf("%d widgets stored, want 3", stored)
+	}
+
+	// The window has ended; blocks are stored again
+	c.Send("raw " + strconv.Quote(block(10)+"\n"))
+	if ev := c.ExpectEvent("html", testshell.DefaultTimeout); ev["widget_id"] != float64(4) {
+		t.Errorf("after the window: %v, want widget 4", ev)
+	}
+}
@@ -0,0 +1,185 @@
+package shellserver
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"shellserver/internal/styles"
+)
+
+var flagUnsafeWidgets = Flags.Bool("unsafe-widgets", false, "serve GET /htmlwidget/{id} as the widget's raw HTML, scripts and all, instead of in a page whose Content-Security-Policy runs only goshell's runCommand bridge; for trusted local use")
+
+// uiCSP is the policy for the web UI's page, which the panel inserts widget
+// fragments into: scripts only from goshell and the CDN xterm.js comes
+// from, so no script or inline handler in a widget runs with the page's
+// token, -unsafe-widgets or not. The panel carries out the handlers
+// goshell's tools write itself, as the bridge does in a widget's own
+// page. The websocket is named outright for browsers whose 'self'
+// doesn't cover ws: and wss:.
+func uiCSP(host string) string {
+	return "default-src 'self'; script-src 'self' https://cdn.jsdelivr.net; style-src 'self' 'unsafe-inline' https://cdn.jsdelivr.net; img-src 'self' data:; font-src 'self' data:; connect-src 'self' ws://" + host + " wss://" + host + "; object-src 'none'; base-uri 'none'; form-action 'self'"
+}
+
+// widgetFramePolicy lets only the web UI's own pages frame a widget.
+const widgetFramePolicy = "frame-ancestors 'self'"
+
+// widgetDocumentCSP is the policy for a widget served as a page: no script
+// but the bridge carrying nonce, inline styles as lsh and duh write them,
+// images from goshell or inline, and requests only back to goshell.
+func widgetDocumentCSP(nonce string) string {
+	return "default-src 'none'; script-src 'nonce-" + nonce + "'; style-src 'unsafe-inline'; img-src 'self' data:; font-src data:; connect-src 'self'; form-action 'none'; base-uri 'none'; " + widgetFramePolicy
+}
+
+// widgetFragmentCSP is the policy for a bare fragment, which the web UI
+// fetches to insert into its panel: opened as a page, it runs nothing.
+const widgetFragmentCSP = "sandbox; default-src 'none'; style-src 'unsafe-inline'; img-src 'self' data:; " + widgetFramePolicy
+
+// writeWidget writes widget id's HTML in the form r asks for: the bare
+// fragment with ?fragment=1, the print document with ?print=1, and
+// otherwise a document of its own with the base styles. Unless
+// -unsafe-widgets, a document's scripts are replaced by the bridge and a
+// fragment's by nothing; with it, the fragment is served as stored.
+func (s *ShellServer) writeWidget(w http.ResponseWriter, r *http.Request, id int, title, content string) {
+	h := w.Header()
+	h.Set("Content-Type", "text/html; charset=utf-8")
+	printView := r.URL.Query().Get("print") == "1"
+	if s.unsafeWidgets {
+		if printView {
+			content = printWidgetDocument(title, content)
+		}
+		fmt.Fprint(w, content)
+		return
+	}
+
+	h.Set("X-Content-Type-Options", "nosniff")
+	h.Set("X-Frame-Options", "SAMEORIGIN")
+	// The page's URL can carry ?token=
+	h.Set("Referrer-Policy", "no-referrer")
+	if r.URL.Query().Get("fragment") == "1" {
+		h.Set("Content-Security-Policy", widgetFragmentCSP)
+		fmt.Fprint(w, content)
+		return
+	}
+	var doc string
+	if printView {
+		doc = printWidgetDocument(title, content)
+	} else {
+		doc = widgetDocument(title, content)
+	}
+	nonce := widgetNonce()
+	h.Set("Content-Security-Policy", widgetDocumentCSP(nonce))
+	fmt.Fprint(w, withWidgetBridge(doc, id, nonce))
+}
+
+// widgetDocument wraps a widget's HTML in a page styled like the panel
+// that shows it.
+func widgetDocument(title, content string) string {
+	return `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>` + styles.HTMLEscape(title) + ` · goshell</title>
+<style>
+body {
+	margin: 0;
+	padding: 12px;
+	background: ` + styles.Colors.BgDark + `;
+	color: ` + styles.Colors.TextLight + `;
+	font-family: monospace;
+}` + styles.BaseCSS() + `</style>
+</head>
+<body>
+` + content + `
+</body>
+</html>
+`
+}
+
+// widgetNonce returns a fresh CSP nonce for one response.
+func widgetNonce() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return base64.StdEncoding.EncodeToString(b)
+}
+
+// withWidgetBridge puts widget id's bridge in doc's head, ahead of the
+// widget's HTML, so no markup the widget leaves open can swallow it.
+func withWidgetBridge(doc string, id int, nonce string) string {
+	i := strings.Index(doc, "</head>")
+	if i < 0 {
+		return doc
+	}
+	return doc[:i] + `<script nonce="` + nonce + `">` + fmt.Sprintf(widgetBridgeJS, id) + "</script>\n" + doc[i:]
+}
+
+// widgetBridgeJS stands in for the inline handlers goshell's tools write,
+// which the policy keeps from running: runCommand("...") with a string
+// literal posts to /widget/{id}/action, passing on the page's ?token=; a
+// tree toggle opens or closes its subtree; the print button prints. Any
+// other handler, and any script in the widget, stays dead.
+const widgetBridgeJS = `
+(function () {
+	'use strict';
+	var token = new URLSearchParams(location.search).get('token');
+	var call = /^\s*runCommand\(\s*("(?:[^"\\]|\\.)*")\s*\)\s*;?\s*$/;
+
+	function runCommand(cmd) {
+		var headers = { 'Content-Type': 'application/json' };
+		if (token) {
+			headers.Authorization = 'Bearer ' + token;
+		}
+		return fetch('../widget/%d/action?queue=1', {
+			method: 'POST',
+			headers: headers,
+			body: JSON.stringify({ type: 'shell', cmd: String(cmd) })
+		}).then(function (response) {
+			if (!response.ok) {
+				throw new Error('runCommand failed: HTTP ' + response.status);
+			}
+		});
+	}
+
+	function toggle(button) {
+		var i = button.id.lastIndexOf('-toggle-');
+		var children = i >= 0 && document.getElementById(button.id.slice(0, i) + '-children-' + button.id.slice(i + 8));
+		if (!children) {
+			return;
+		}
+		var open = !children.classList.contains('expanded');
+		children.classList.toggle('expanded', open);
+		button.textContent = open ? '▼' : '▶';
+		button.parentNode.setAttribute('aria-expanded', open);
+	}
+
+	document.addEventListener('click', function (event) {
+		var el = event.target.closest('[onclick]');
+		if (!el) {
+			return;
+		}
+		if (el.classList.contains('tree-toggle')) {
+			toggle(el);
+			return;
+		}
+		if (el.classList.contains('shell-print-button')) {
+			window.print();
+			return;
+		}
+		var m = call.exec(el.getAttribute('onclick'));
+		if (!m) {
+			return;
+		}
+		var cmd;
+		try {
+			cmd = JSON.parse(m[1]);
+		} catch (err) {
+			return;
+		}
+		runCommand(cmd).catch(function (err) {
+			console.error(err);
+		});
+	});
+})();
+`
@@ -0,0 +1,126 @@
+package shellserver
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"shellserver/internal/styles"
+	"shellserver/web"
+)
+
+func TestWidgetSandbox(t *testing.T) {
+	const widget = `<style>.x{color:red}</style><button onclick="runCommand(&quot;ls -la&quot;)" style="color:blue">ls</button><script>alert(1)</script>`
+	s := newPumpTestServer()
+	s.extractAndStoreHTML([]byte(fmt.Sprintf("%s%s%s", htmlStartMarker, widget, htmlEndMarker)))
+
+	get := func(query string) *httptest.ResponseRecorder {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		s.handleHTMLWidget(rec, httptest.NewRequest(http.MethodGet, "/htmlwidget/1"+query, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("GET %s: %d %s", query, rec.Code, rec.Body)
+		}
+		return rec
+	}
+	nonceRE := regexp.MustCompile(`<script nonce="([^"]+)">`)
+
+	for _, query := range []string{"", "?print=1"} {
+		rec := get(query)
+		csp := rec.Header().Get("Content-Security-Policy")
+		page := rec.Body.String()
+		scripts := nonceRE.FindAllStringSubmatch(page, -1)
+		if len(scripts) != 1 || !strings.Contains(csp, "script-src 'nonce-"+scripts[0][1]+"'") {
+			t.Errorf("GET %s: policy %q, bridge scripts %q, want one carrying the policy's nonce", query, csp, scripts)
+		}
+		for _, want := range []string{"default-src 'none'", "style-src 'unsafe-inline'", "frame-ancestors 'self'"} {
+			if !strings.Contains(csp, want) {
+				t.Errorf("GET %s: policy %q missing %q", query, csp, want)
+			}
+		}
+		if got := rec.Header().Get("X-Frame-Options"); got != "SAMEORIGIN" {
+			t.Errorf("GET %s: X-Frame-Options = %q", query, got)
+		}
+		if !strings.Contains(page, "<button onclick=") || !strings.Contains(page, `style="color:blue"`) {
+			t.Errorf("GET %s: widget's markup changed: %s", query, page)
+		}
+		if !strings.Contains(page, "'../widget/1/action?queue=1'") {
+			t.Errorf("GET %s: bridge doesn't post to the widget's action", query)
+		}
+		// The bridge comes before the widget, which can't close it off
+		if strings.Index(page, "<script nonce=") > strings.Index(page, widget[:20]) {
+			t.Errorf("GET %s: bridge after the widget's HTML", query)
+		}
+	}
+	if page := get("").Body.String(); !strings.Contains(page, styles.BaseCSS()) {
+		t.Error("widget page missing the base styles")
+	}
+	if a, b := get("").Header().Get("Content-Security-Policy"), get("").Header().Get("Content-Security-Policy"); a == b {
+		t.Errorf("two responses share the policy %q, want a nonce each", a)
+	}
+
+	// The panel's fragment is served as stored, under a policy running nothing
+	rec := get("?fragment=1")
+	if rec.Body.String() != widget {
+		t.Errorf("fragment = %q, want %q", rec.Body, widget)
+	}
+	if csp := rec.Header().Get("Content-Security-Policy"); !strings.HasPrefix(csp, "sandbox;") || strings.Contains(csp, "script-src") {
+		t.Errorf("fragment policy = %q, want a sandbox without scripts", csp)
+	}
+
+	s.unsafeWidgets = true
+	rec = get("")
+	if rec.Body.String() != widget || rec.Header().Get("Content-Security-Policy") != "" {
+		t.Errorf("-unsafe-widgets served %q with policy %q, want the raw widget", rec.Body, rec.Header().Get("Content-Security-Policy"))
+	}
+}
+
+func TestWidgetBridgeFormat(t *testing.T) {
+	// The bridge is a format string; a stray verb would garble it
+	if js := fmt.Sprintf(widgetBridgeJS, 42); strings.Contains(js, "%!") || !strings.Contains(js, "widget/42/action") {
+		t.Errorf("bridge = %s", js)
+	}
+}
+
+// The panel inserts a widget's fragment into the UI's page, so that
+// page's policy is what keeps the widget's script from running there.
+func TestUIPolicyStopsWidgetScript(t *testing.T) {
+	mux := http.NewServeMux()
+	(&ShellServer{}).registerUI(mux, web.Files, "the binary")
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	var scriptSrc []string
+	for _, directive := range strings.Split(rec.Header().Get("Content-Security-Policy"), ";") {
+		if f := strings.Fields(directive); len(f) > 0 && f[0] == "script-src" {
+			scriptSrc = f[1:]
+		}
+	}
+	if len(scriptSrc) == 0 {
+		t.Fatalf("GET /: policy %q has no script-src", rec.Header().Get("Content-Security-Policy"))
+	}
+	for _, src := range scriptSrc {
+		if src == "'unsafe-inline'" || src == "'unsafe-eval'" || src == "*" || strings.HasSuffix(src, ":") {
+			t.Errorf("script-src %q admits widget script", scriptSrc)
+		}
+	}
+
+	// The page itself must get by without inline script
+	page, err := fs.ReadFile(web.Files, "index.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tag := range regexp.MustCompile(`<script\b[^>]*>`).FindAll(page, -1) {
+		if !bytes.Contains(tag, []byte(" src=")) {
+			t.Errorf("index.html has inline script the policy would stop: %s", tag)
+		}
+	}
+	if m := regexp.MustCompile(`\son[a-z]+=`).Find(page); m != nil {
+		t.Errorf("index.html has an inline handler the policy would stop: %q", m)
+	}
+}
@@ -0,0 +1,70 @@
+package shellserver
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+
+	"shellserver/internal/htmlsanitize"
+)
+
+var flagSanitizeWidgets = Flags.Bool("sanitize-widgets", true, "strip HTML widgets from the shell's output down to allowlisted elements and attributes before storing them, so markup injected into a command's output, such as through a file name, can't run script (the original is kept for GET /debug/widget/{id})")
+
+// sanitizeWidget returns content as widget id is to store it: with
+// -sanitize-widgets, run through htmlsanitize, keeping the original for
+// /debug/widget/{id} when that changed anything. The caller holds
+// htmlWidgetsMu.
+func (s *ShellServer) sanitizeWidget(id int, content []byte) []byte {
+	if !s.sanitizeWidgets {
+		return content
+	}
+	clean := htmlsanitize.Sanitize(content)
+	if bytes.Equal(clean, content) {
+		delete(s.widgetOriginals, id)
+		return content
+	}
+	if s.widgetOriginals == nil {
+		s.widgetOriginals = make(map[int][]byte)
+	}
+	s.widgetOriginals[id] = bytes.Clone(content)
+	return clean
+}
+
+// handleWidgetOriginal serves GET /debug/widget/{id}: the widget's HTML as
+// the shell printed it, before sanitizing, for telling what was stripped.
+// It is served as text, so it can't run in the browser; X-Widget-Sanitized
+// says whether the stored widget differs.
+func (s *ShellServer) handleWidgetOriginal(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r, http.MethodGet)
+		return
+	}
+	seg, _ := strings.CutPrefix(r.URL.Path, "/debug/widget/")
+	id, ok := parseWidgetID(seg)
+	if !ok {
+		notFound(w, r)
+		return
+	}
+
+	s.htmlWidgetsMu.RLock()
+	content, ok := s.widgetHTML(id)
+	original, sanitized := s.widgetOriginals[id]
+	s.htmlWidgetsMu.RUnlock()
+	if !ok {
+		s.widgetNotFound(w, r, id)
+		return
+	}
+	if !sanitized {
+		original = []byte(content)
+	}
+
+	h := w.Header()
+	h.Set("Content-Type", "text/plain; charset=utf-8")
+	h.Set("X-Content-Type-Options", "nosniff")
+	if sanitized {
+		h.Set("X-Widget-Sanitized", "1")
+	} else {
+		h.Set("X-Widget-Sanitized", "0")
+	}
+	w.Write(original)
+}
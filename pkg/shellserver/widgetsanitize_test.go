@@ -0,0 +1,74 @@
+package shellserver
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"shellserver/pkg/protocol"
+)
+
+func TestSanitizeWidgets(t *testing.T) {
+	const (
+		dirty = `<ul><li>a.txt</li><li><img src=x onerror=alert(1)>.txt</li></ul>`
+		clean = `<ul><li>a.txt</li><li><img src="x">.txt</li></ul>`
+		plain = `<p>nothing to strip</p>`
+	)
+	s := newPumpTestServer()
+	s.sanitizeWidgets = true
+	for _, html := range []string{dirty, plain} {
+		s.extractAndStoreHTML([]byte(fmt.Sprintf("%s%s%s", htmlStartMarker, html, htmlEndMarker)))
+	}
+	s.sanitizeWidgets = false
+	s.extractAndStoreHTML([]byte(fmt.Sprintf("%s%s%s", htmlStartMarker, dirty, htmlEndMarker)))
+
+	for id, want := range map[int]string{1: clean, 2: plain, 3: dirty} {
+		if got, _ := s.widgetHTML(id); got != want {
+			t.Errorf("widget %d stored as %q, want %q", id, got, want)
+		}
+	}
+
+	tests := []struct {
+		path      string
+		status    int
+		body      string
+		sanitized string
+	}{
+		{"/debug/widget/1", http.StatusOK, dirty, "1"},
+		{"/debug/widget/2", http.StatusOK, plain, "0"},
+		{"/debug/widget/3", http.StatusOK, dirty, "0"},
+		{"/debug/widget/4", http.StatusNotFound, "", ""},
+		{"/debug/widget/x", http.StatusNotFound, "", ""},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		s.handleWidgetOriginal(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if rec.Code != tt.status {
+			t.Errorf("GET %s: status %d, want %d", tt.path, rec.Code, tt.status)
+			continue
+		}
+		if tt.status != http.StatusOK {
+			continue
+		}
+		if rec.Body.String() != tt.body || rec.Header().Get("X-Widget-Sanitized") != tt.sanitized {
+			t.Errorf("GET %s = %q, sanitized %q; want %q, %q", tt.path, rec.Body, rec.Header().Get("X-Widget-Sanitized"), tt.body, tt.sanitized)
+		}
+		if ct := rec.Header().Get("Content-Type"); ct != "text/plain; charset=utf-8" {
+			t.Errorf("GET %s: Content-Type %q, want plain text", tt.path, ct)
+		}
+	}
+
+	// The original goes with its widget
+	s.widgetLimit = 1
+	s.trimWidgets(time.Now())
+	rec := httptest.NewRecorder()
+	s.handleWidgetOriginal(rec, httptest.NewRequest(http.MethodGet, "/debug/widget/1", nil))
+	if rec.Code != http.StatusGone || errorCode(t, rec.Result()) != protocol.ErrWidgetExpired {
+		t.Errorf("evicted widget: status %d", rec.Code)
+	}
+	if len(s.widgetOriginals) != 0 {
+		t.Errorf("originals kept after eviction: %v", s.widgetOriginals)
+	}
+}
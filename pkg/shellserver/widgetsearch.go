@@ -0,0 +1,244 @@
+package shellserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"shellserver/internal/htmltext"
+	"shellserver/internal/styles"
+	"shellserver/pkg/protocol"
+)
+
+const (
+	defaultWidgetSearchLimit = 20
+	maxWidgetSearchLimit     = 100
+
+	// maxWidgetSearchQuery bounds the query, and so the cost of compiling
+	// it as a regexp. Go's regexps run in linear time, so matching needs
+	// no further guard.
+	maxWidgetSearchQuery = 256
+
+	// maxWidgetSearchHits is the most matches counted in one widget.
+	maxWidgetSearchHits = 100
+
+	// widgetSnippetContext is how much text, in bytes, a snippet shows
+	// on each side of the first match.
+	widgetSnippetContext = 40
+)
+
+// widgetText is the searchable text of a stored widget, extracted when it
+// is stored so searches needn't parse every widget again.
+type widgetText struct {
+	title  string
+	text   string
+	lower  string    // text in lower case, for substring search
+	stored time.Time // zero for widgets stored before this server started
+	size   int       // bytes of HTML, for -widget-max-bytes
+}
+
+func newWidgetText(content []byte, stored time.Time) *widgetText {
+	title, text := htmltext.Extract(string(content))
+	return &widgetText{title: title, text: text, lower: strings.ToLower(text), stored: stored, size: len(content)}
+}
+
+// putWidget stores a widget's content and indexes its text. The caller
+// holds htmlWidgetsMu.
+func (s *ShellServer) putWidget(id int, content []byte) error {
+	s.widgetIndex[id] = newWidgetText(content, time.Now())
+	s.signWidgetCmds(id, content)
+	return s.store.Put(widgetNS, widgetKey(id), content)
+}
+
+// widgetSearchHit is a widget that matched a search.
+type widgetSearchHit struct {
+	ID      int        `json:"id"`
+	Title   string     `json:"title,omitempty"`
+	Stored  *time.Time `json:"stored,omitempty"`
+	Snippet string     `json:"snippet"` // HTML: the text around the first match, matches in <mark>
+	Matches int        `json:"matches"` // in the text, up to 100
+	InTitle bool       `json:"in_title"`
+}
+
+// widgetSearch is the response of /htmlwidget/search.
+type widgetSearch struct {
+	Query     string            `json:"query"`
+	Regex     bool              `json:"regex"`
+	Results   []widgetSearchHit `json:"results"` // most recently stored first
+	Total     int               `json:"total"`
+	Truncated bool              `json:"truncated"`
+}
+
+// widgetMatcher finds up to n matches in s, as [start, end) pairs.
+type widgetMatcher func(s string, n int) [][]int
+
+// substringMatcher matches query case-insensitively; it is applied to
+// lower-case text.
+func substringMatcher(query string) widgetMatcher {
+	query = strings.ToLower(query)
+	return func(s string, n int) [][]int {
+		var locs [][]int
+		for off := 0; len(locs) < n; {
+			i := strings.Index(s[off:], query)
+			if i < 0 {
+				break
+			}
+			start := off + i
+			locs = append(locs, []int{start, start + len(query)})
+			off = start + len(query)
+		}
+		return locs
+	}
+}
+
+// searchWidgets returns up to limit widgets whose text or title matches,
+// most recently stored first. Widgets stored by an earlier server are
+// indexed on the way.
+func (s *ShellServer) searchWidgets(query string, re *regexp.Regexp, limit int) widgetSearch {
+	res := widgetSearch{Query: query, Regex: re != nil, Results: []widgetSearchHit{}}
+	match := substringMatcher(query)
+	if re != nil {
+		match = re.FindAllStringIndex
+	}
+
+	type entry struct {
+		id int
+		wt *widgetText
+	}
+	s.htmlWidgetsMu.Lock()
+	ids := s.widgetIDs()
+	entries := make([]entry, 0, len(ids))
+	for _, id := range ids {
+		if wt, ok := s.indexedWidget(id); ok {
+			entries = append(entries, entry{id, wt})
+		}
+	}
+	s.htmlWidgetsMu.Unlock()
+
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i].wt.stored, entries[j].wt.stored
+		if !a.Equal(b) {
+			return a.After(b)
+		}
+		return entries[i].id > entries[j].id
+	})
+
+	for _, e := range entries {
+		// Substrings are found in lower case, and cut from it too in the
+		// rare case lowering changed the length
+		text, haystack, title := e.wt.text, e.wt.text, e.wt.title
+		if re == nil {
+			haystack, title = e.wt.lower, strings.ToLower(title)
+			if len(haystack) != len(text) {
+				text = haystack
+			}
+		}
+		locs := match(haystack, maxWidgetSearchHits)
+		inTitle := len(match(title, 1)) > 0
+		if len(locs) == 0 && !inTitle {
+			continue
+		}
+		res.Total++
+		if len(res.Results) == limit {
+			res.Truncated = true
+			continue
+		}
+		hit := widgetSearchHit{
+			ID:      e.id,
+			Title:   e.wt.title,
+			Snippet: widgetSnippet(text, locs),
+			Matches: len(locs),
+			InTitle: inTitle,
+		}
+		if !e.wt.stored.IsZero() {
+			stored := e.wt.stored
+			hit.Stored = &stored
+		}
+		res.Results = append(res.Results, hit)
+	}
+	return res
+}
+
+// widgetSnippet cuts the text around the first of locs, escaped as HTML,
+// with the matches it shows wrapped in <mark>.
+func widgetSnippet(text string, locs [][]int) string {
+	if len(locs) == 0 {
+		end := min(len(text), 2*widgetSnippetContext)
+		for end < len(text) && !utf8.RuneStart(text[end]) {
+			end++
+		}
+		snippet := styles.HTMLEscape(text[:end])
+		if end < len(text) {
+			snippet += "…"
+		}
+		return snippet
+	}
+
+	start := max(0, locs[0][0]-widgetSnippetContext)
+	for start > 0 && !utf8.RuneStart(text[start]) {
+		start--
+	}
+	end := min(len(text), locs[0][1]+widgetSnippetContext)
+	for end < len(text) && !utf8.RuneStart(text[end]) {
+		end++
+	}
+
+	var b strings.Builder
+	if start > 0 {
+		b.WriteString("…")
+	}
+	pos := start
+	for _, loc := range locs {
+		if loc[0] < pos || loc[1] > end {
+			continue
+		}
+		b.WriteString(styles.HTMLEscape(text[pos:loc[0]]))
+		b.WriteString("<mark>" + styles.HTMLEscape(text[loc[0]:loc[1]]) + "</mark>")
+		pos = loc[1]
+	}
+	b.WriteString(styles.HTMLEscape(text[pos:end]))
+	if end < len(text) {
+		b.WriteString("…")
+	}
+	return b.String()
+}
+
+// handleWidgetSearch serves GET /htmlwidget/search?q=...&regex=1&limit=N.
+func (s *ShellServer) handleWidgetSearch(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	query := q.Get("q")
+	if query == "" {
+		respondError(w, r, http.StatusBadRequest, protocol.ErrInvalidRequest, "missing q")
+		return
+	}
+	if len(query) > maxWidgetSearchQuery {
+		respondError(w, r, http.StatusBadRequest, protocol.ErrInvalidRequest,
+			"q longer than "+strconv.Itoa(maxWidgetSearchQuery)+" bytes")
+		return
+	}
+	limit := defaultWidgetSearchLimit
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			respondError(w, r, http.StatusBadRequest, protocol.ErrInvalidRequest, "limit must be a positive integer")
+			return
+		}
+		limit = min(n, maxWidgetSearchLimit)
+	}
+	var re *regexp.Regexp
+	if isRegex, _ := strconv.ParseBool(q.Get("regex")); isRegex {
+		var err error
+		if re, err = regexp.Compile("(?i)" + query); err != nil {
+			respondError(w, r, http.StatusBadRequest, protocol.ErrInvalidRequest, "invalid regex: "+err.Error())
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.searchWidgets(query, re, limit))
+}
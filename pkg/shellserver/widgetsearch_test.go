@@ -0,0 +1,129 @@
+package shellserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func listingWidget(title string, names ...string) []byte {
+	var b strings.Builder
+	b.WriteString(`<style>.shell-name { color: blue; }</style><div class="shell-container"><div class="shell-header"><div class="shell-title">` + title + `</div></div><ul>`)
+	for _, name := range names {
+		b.WriteString(`<li><span class="shell-name">` + name + `</span></li>`)
+	}
+	b.WriteString(`</ul></div>`)
+	return []byte(b.String())
+}
+
+func searchWidgetsHTTP(t *testing.T, s *ShellServer, query string) (int, widgetSearch) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	s.handleHTMLWidget(rec, httptest.NewRequest(http.MethodGet, "/htmlwidget/search?"+query, nil))
+	var res widgetSearch
+	if rec.Code == http.StatusOK {
+		if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return rec.Code, res
+}
+
+func hitIDs(res widgetSearch) []int {
+	ids := []int{}
+	for _, h := range res.Results {
+		ids = append(ids, h.ID)
+	}
+	return ids
+}
+
+func TestWidgetSearch(t *testing.T) {
+	s, _ := newPipeServer(t)
+	// stored by an earlier server: found, but without a time
+	s.store.Put(widgetNS, widgetKey(1), listingWidget("/old", "foo.conf"))
+	s.htmlCounter = 1
+	etc := s.storeNewWidget(listingWidget("/etc", "hosts", "foo.conf", "passwd"))
+	s.storeNewWidget(listingWidget("/tmp", "scratch"))
+	home := s.storeNewWidget(listingWidget("/home/me", "FOO.CONF", "notes &lt;draft&gt;.txt"))
+
+	_, res := searchWidgetsHTTP(t, s, "q=foo.conf")
+	if got := hitIDs(res); len(got) != 3 || got[0] != home || got[1] != etc || got[2] != 1 || res.Total != 3 {
+		t.Fatalf("foo.conf: %v (total %d), want [%d %d 1]", got, res.Total, home, etc)
+	}
+	h := res.Results[0]
+	if h.Title != "/home/me" || h.Matches != 1 || h.InTitle || h.Stored == nil {
+		t.Errorf("hit = %+v", h)
+	}
+	if want := "/home/me <mark>FOO.CONF</mark> notes &lt;draft&gt;.txt"; h.Snippet != want {
+		t.Errorf("snippet = %q, want %q", h.Snippet, want)
+	}
+	if res.Results[2].Stored != nil {
+		t.Errorf("widget from an earlier server has a time: %v", res.Results[2].Stored)
+	}
+
+	// Replacing a widget makes it the most recent
+	s.replaceWidget(etc, listingWidget("/etc", "foo.conf", "foo.conf.bak"))
+	_, res = searchWidgetsHTTP(t, s, "q=FOO.conf&limit=1")
+	if got := hitIDs(res); len(got) != 1 || got[0] != etc || !res.Truncated || res.Total != 3 {
+		t.Errorf("after replace, limit=1: %v (total %d, truncated %v), want [%d]", got, res.Total, res.Truncated, etc)
+	}
+	if res.Results[0].Matches != 2 {
+		t.Errorf("matches = %d, want 2", res.Results[0].Matches)
+	}
+
+	// Titles match too; style contents don't
+	if _, res = searchWidgetsHTTP(t, s, "q=/tmp"); len(res.Results) != 1 || !res.Results[0].InTitle {
+		t.Errorf("title search: %+v", res)
+	}
+	if _, res = searchWidgetsHTTP(t, s, "q=color"); res.Total != 0 {
+		t.Errorf("CSS searched: %+v", res)
+	}
+
+	// Evicted widgets leave the index
+	s.htmlWidgetsMu.Lock()
+	s.widgetLimit = 1
+	s.trimWidgets(time.Now())
+	s.htmlWidgetsMu.Unlock()
+	if _, res = searchWidgetsHTTP(t, s, "q=foo.conf"); res.Total != 1 {
+		t.Errorf("after eviction: %v", hitIDs(res))
+	}
+	if len(s.widgetIndex) != 1 {
+		t.Errorf("index holds %d widgets after eviction, want 1", len(s.widgetIndex))
+	}
+}
+
+func TestWidgetSearchRegex(t *testing.T) {
+	s, _ := newPipeServer(t)
+	conf := s.storeNewWidget(listingWidget("/a", "app.CONF"))
+	ini := s.storeNewWidget(listingWidget("/b", "app.ini"))
+	s.storeNewWidget(listingWidget("/c", "app_conf"))
+
+	_, res := searchWidgetsHTTP(t, s, `regex=1&q=app\.(conf|ini)`)
+	if got := hitIDs(res); len(got) != 2 || got[0] != ini || got[1] != conf || !res.Regex {
+		t.Errorf("regex: %v, want [%d %d]", got, ini, conf)
+	}
+	if _, res = searchWidgetsHTTP(t, s, `q=app\.(conf|ini)`); res.Total != 0 {
+		t.Errorf("regex=0 matched as a regexp: %v", hitIDs(res))
+	}
+
+	for _, query := range []string{"regex=1&q=app(", "q=", "q=x&limit=0", "q=" + strings.Repeat("a", maxWidgetSearchQuery+1)} {
+		if code, _ := searchWidgetsHTTP(t, s, query); code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want 400", query, code)
+		}
+	}
+}
+
+func TestWidgetSnippet(t *testing.T) {
+	text := strings.Repeat("é", 30) + " needle " + strings.Repeat("ü", 30)
+	i := strings.Index(text, "needle")
+	got := widgetSnippet(text, [][]int{{i, i + len("needle")}})
+	if !strings.HasPrefix(got, "…") || !strings.HasSuffix(got, "…") || !strings.Contains(got, "<mark>needle</mark>") {
+		t.Errorf("snippet = %q", got)
+	}
+	if !strings.Contains(got, "é") || strings.ContainsRune(got, '�') {
+		t.Errorf("snippet cut inside a character: %q", got)
+	}
+}
@@ -0,0 +1,233 @@
+package shellserver
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"shellserver/internal/store"
+	"shellserver/pkg/protocol"
+)
+
+var (
+	flagStore          = Flags.String("store", "memory", `where widget HTML is kept: "memory" or "bolt:/path/to/file.db"`)
+	flagWidgetLimit    = Flags.Int("widget-limit", 1000, "HTML widgets kept before the oldest are evicted (0 for no limit)")
+	flagWidgetMaxBytes = byteSizeFlag(32 << 20)
+	flagWidgetTTL      = Flags.Duration("widget-ttl", 0, "evict HTML widgets this long after they were stored or last replaced (0 keeps them until a limit needs the room)")
+	flagWidgets        = Flags.Bool("widgets", true, "extract HTML widget blocks from shell output; false leaves them in the terminal stream")
+)
+
+func init() {
+	Flags.Var(&flagWidgetMaxBytes, "widget-max-bytes", "most HTML kept across all widgets, such as 32M, before the oldest are evicted; the newest is kept whatever its size (0 for no limit)")
+}
+
+// widgetNS is the store namespace holding HTML widget content.
+const widgetNS = "widgets"
+
+// widgetKey zero-pads id so the store lists widgets oldest first.
+func widgetKey(id int) string {
+	return fmt.Sprintf("%010d", id)
+}
+
+// lastWidgetID returns the highest widget ID in st, so IDs keep counting
+// up across restarts with a persistent store.
+func lastWidgetID(st store.Store) (int, error) {
+	keys, err := st.List(widgetNS)
+	if err != nil || len(keys) == 0 {
+		return 0, err
+	}
+	return strconv.Atoi(keys[len(keys)-1])
+}
+
+// widgetHTML returns the stored content of an HTML widget.
+func (s *ShellServer) widgetHTML(id int) (string, bool) {
+	html, err := s.store.Get(widgetNS, widgetKey(id))
+	if err != nil {
+		if !errors.Is(err, store.ErrNotFound) {
+			log.Printf("widget store: get %d: %v", id, err)
+		}
+		return "", false
+	}
+	return string(html), true
+}
+
+// replaceWidget swaps in new content for a widget the server generated
+// and broadcasts the update. A widget evicted meanwhile stays gone.
+func (s *ShellServer) replaceWidget(id int, content []byte) {
+	s.htmlWidgetsMu.Lock()
+	previous, ok := s.widgetHTML(id)
+	if ok {
+		if err := s.putWidget(id, content); err != nil {
+			log.Printf("widget store: put %d: %v", id, err)
+		}
+	}
+	s.htmlWidgetsMu.Unlock()
+	if !ok {
+		return
+	}
+	s.recordWidgetRevision(id, []byte(previous), content, time.Now())
+	s.widgetEvent(protocol.WidgetReplaced, id)
+	s.flushWidgetEvents()
+	s.broadcastHTMLUpdate(id)
+}
+
+// widgetIDs returns the IDs of all stored HTML widgets, oldest first.
+func (s *ShellServer) widgetIDs() []int {
+	keys, err := s.store.List(widgetNS)
+	if err != nil {
+		log.Printf("widget store: list: %v", err)
+		return nil
+	}
+	ids := make([]int, 0, len(keys))
+	for _, key := range keys {
+		if id, err := strconv.Atoi(key); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// indexedWidget returns widget id's searchable text, indexing widgets
+// loaded from an earlier server on the way. The caller holds
+// htmlWidgetsMu for writing.
+func (s *ShellServer) indexedWidget(id int) (*widgetText, bool) {
+	if wt, ok := s.widgetIndex[id]; ok {
+		return wt, true
+	}
+	content, ok := s.widgetHTML(id)
+	if !ok {
+		return nil, false
+	}
+	wt := newWidgetText([]byte(content), time.Time{})
+	s.widgetIndex[id] = wt
+	return wt, true
+}
+
+// trimWidgets evicts the HTML widgets stored, or last replaced, at least
+// -widget-ttl ago, then the oldest beyond -widget-limit and
+// -widget-max-bytes, always keeping the newest. Widgets loaded from an
+// earlier server count as stored when this one started. The caller
+// holds htmlWidgetsMu.
+func (s *ShellServer) trimWidgets(now time.Time) {
+	var evict, kept []int
+	for _, id := range s.widgetIDs() {
+		wt, ok := s.indexedWidget(id)
+		if !ok {
+			continue
+		}
+		stored := wt.stored
+		if stored.IsZero() {
+			stored = s.stats.started
+		}
+		if s.widgetTTL > 0 && now.Sub(stored) >= s.widgetTTL {
+			evict = append(evict, id)
+		} else {
+			kept = append(kept, id)
+		}
+	}
+	if s.widgetLimit > 0 && len(kept) > s.widgetLimit {
+		n := len(kept) - s.widgetLimit
+		evict, kept = append(evict, kept[:n]...), kept[n:]
+	}
+	if s.widgetMaxBytes > 0 {
+		total := 0
+		for _, id := range kept {
+			total += s.widgetIndex[id].size
+		}
+		for len(kept) > 1 && total > s.widgetMaxBytes {
+			total -= s.widgetIndex[kept[0]].size
+			evict, kept = append(evict, kept[0]), kept[1:]
+		}
+	}
+	s.evictWidgets(evict)
+}
+
+// evictWidgets deletes the HTML widgets ids, along with their error logs,
+// replaces-widget keys, revisions and search text, recording an event
+// for each. The caller holds htmlWidgetsMu.
+func (s *ShellServer) evictWidgets(ids []int) {
+	if len(ids) == 0 {
+		return
+	}
+	evicted := make(map[int]bool)
+	for _, id := range ids {
+		if err := s.store.Delete(widgetNS, widgetKey(id)); err != nil {
+			log.Printf("widget store: evict %d: %v", id, err)
+			continue
+		}
+		evicted[id] = true
+		s.widgetEventLocked(protocol.WidgetEvicted, id)
+		delete(s.widgetVersions, id)
+		delete(s.widgetPatches, id)
+		delete(s.widgetIndex, id)
+		delete(s.widgetCmdMACs, id)
+		delete(s.widgetOriginals, id)
+		delete(s.staleWidgets, id)
+	}
+
+	for key, id := range s.htmlKeys {
+		if evicted[id] {
+			delete(s.htmlKeys, key)
+		}
+	}
+	s.widgetErrorsMu.Lock()
+	for id := range evicted {
+		delete(s.widgetErrors, id)
+	}
+	s.widgetErrorsMu.Unlock()
+}
+
+// widgetSweepInterval is how often widgets are checked against a
+// -widget-ttl of ttl: often enough that none outlives it by much.
+func widgetSweepInterval(ttl time.Duration) time.Duration {
+	return min(max(ttl/10, time.Second), time.Minute)
+}
+
+// sweepWidgets applies the widget limits every interval until stop is
+// closed, so widgets expire under -widget-ttl without waiting for the
+// next one to be stored.
+func (s *ShellServer) sweepWidgets(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			s.htmlWidgetsMu.Lock()
+			s.trimWidgets(now)
+			s.htmlWidgetsMu.Unlock()
+			s.flushWidgetEvents()
+		}
+	}
+}
+
+// widgetStoreUsage is the "widget_store" object in /status.
+type widgetStoreUsage struct {
+	Widgets    int     `json:"widgets"`
+	Bytes      int     `json:"bytes"`
+	MaxWidgets int     `json:"max_widgets"` // 0 for no limit
+	MaxBytes   int     `json:"max_bytes"`   // 0 for no limit
+	TTL        float64 `json:"ttl_sec"`     // 0 for none
+	Evicted    int64   `json:"evicted"`     // widgets evicted since the server started
+}
+
+func (s *ShellServer) widgetStoreUsage() widgetStoreUsage {
+	u := widgetStoreUsage{
+		MaxWidgets: s.widgetLimit,
+		MaxBytes:   s.widgetMaxBytes,
+		TTL:        s.widgetTTL.Seconds(),
+		Evicted:    s.stats.widgetsEvicted.Load(),
+	}
+	s.htmlWidgetsMu.Lock()
+	defer s.htmlWidgetsMu.Unlock()
+	for _, id := range s.widgetIDs() {
+		if wt, ok := s.indexedWidget(id); ok {
+			u.Widgets++
+			u.Bytes += wt.size
+		}
+	}
+	return u
+}
@@ -0,0 +1,158 @@
+package shellserver
+
+import (
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"shellserver/internal/store"
+	"shellserver/internal/testshell"
+	"shellserver/pkg/protocol"
+)
+
+func TestWidgetEviction(t *testing.T) {
+	s := &ShellServer{
+		store:          store.NewMemory(),
+		widgetLimit:    2,
+		htmlKeys:       make(map[string]int),
+		widgetVersions: make(map[int]int),
+		widgetPatches:  make(map[int]*widgetPatch),
+		widgetIndex:    make(map[int]*widgetText),
+		widgetErrors:   make(map[int]*widgetErrorLog),
+	}
+	block := func(key, html string) []byte {
+		start := string(htmlStartMarker)
+		if key != "" {
+			start = "\x1b]9001;HTML_START;key=" + key + "\x07"
+		}
+		return []byte(start + html + string(htmlEndMarker))
+	}
+
+	s.extractAndStoreHTML(block("watch", "one"))
+	s.recordWidgetError(1, WidgetError{Message: "boom"}, time.Now())
+	s.extractAndStoreHTML(block("", "two"))
+	s.extractAndStoreHTML(block("", "three"))
+
+	if got := s.widgetIDs(); len(got) != 2 || got[0] != 2 || got[1] != 3 {
+		t.Fatalf("stored widgets = %v, want [2 3]", got)
+	}
+	if _, ok := s.htmlKeys["watch"]; ok {
+		t.Error("key of evicted widget still maps to it")
+	}
+	if _, ok := s.widgetErrors[1]; ok {
+		t.Error("error log of evicted widget kept")
+	}
+
+	// The key starts a new widget instead of replacing the evicted one.
+	_, _, ids, updated := s.extractAndStoreHTML(block("watch", "again"))
+	if len(ids) != 1 || ids[0] != 4 || len(updated) != 0 {
+		t.Errorf("keyed block after eviction: ids=%v updated=%v, want new widget 4", ids, updated)
+	}
+}
+
+func TestLastWidgetIDFromBoltStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "goshell.db")
+	st, err := store.Open("bolt:" + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []int{9, 10, 2} {
+		st.Put(widgetNS, widgetKey(id), []byte("x"))
+	}
+	st.Close()
+
+	st, err = store.Open("bolt:" + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	if id, err := lastWidgetID(st); err != nil || id != 10 {
+		t.Errorf("lastWidgetID = %d, %v; want 10", id, err)
+	}
+}
+
+func TestWidgetByteAndAgeLimits(t *testing.T) {
+	s := &ShellServer{
+		store:          store.NewMemory(),
+		widgetMaxBytes: 10,
+		htmlKeys:       make(map[string]int),
+		widgetVersions: make(map[int]int),
+		widgetPatches:  make(map[int]*widgetPatch),
+		widgetIndex:    make(map[int]*widgetText),
+		widgetErrors:   make(map[int]*widgetErrorLog),
+	}
+	put := func(html string) {
+		s.extractAndStoreHTML([]byte(string(htmlStartMarker) + html + string(htmlEndMarker)))
+	}
+
+	put("aaaaaa")
+	put("bbbb")
+	if got := s.widgetIDs(); len(got) != 2 {
+		t.Fatalf("10 bytes stored: widgets %v, want both", got)
+	}
+	put("cc")
+	if got := s.widgetIDs(); len(got) != 2 || got[0] != 2 {
+		t.Fatalf("over the byte limit: widgets %v, want [2 3]", got)
+	}
+	// The newest stays even alone over the limit
+	put("dddddddddddddddd")
+	if got := s.widgetIDs(); len(got) != 1 || got[0] != 4 {
+		t.Fatalf("widget over the byte limit: widgets %v, want [4]", got)
+	}
+
+	s.widgetMaxBytes = 0
+	s.widgetTTL = time.Minute
+	put("eeee")
+	s.htmlWidgetsMu.Lock()
+	s.widgetIndex[4].stored = time.Now().Add(-2 * time.Minute)
+	s.trimWidgets(time.Now())
+	s.htmlWidgetsMu.Unlock()
+	if got := s.widgetIDs(); len(got) != 1 || got[0] != 5 {
+		t.Errorf("after the TTL: widgets %v, want [5]", got)
+	}
+	s.htmlWidgetsMu.Lock()
+	s.trimWidgets(time.Now().Add(time.Minute))
+	s.htmlWidgetsMu.Unlock()
+	if got := s.widgetIDs(); len(got) != 0 {
+		t.Errorf("all expired: widgets %v, want none", got)
+	}
+}
+
+func TestEvictedWidgetGone(t *testing.T) {
+	s, ts := startFakeShellServer(t)
+	s.htmlWidgetsMu.Lock()
+	s.widgetLimit = 1
+	s.htmlWidgetsMu.Unlock()
+	c := testshell.Dial(t, ts.URL, "")
+
+	c.Send(`raw "\x1b]9001;HTML_START\x07<b>one</b>\x1b]9001;HTML_END\x07\n"`)
+	c.ExpectEvent("html", testshell.DefaultTimeout)
+	c.Send(`raw "\x1b]9001;HTML_START\x07<b>two</b>\x1b]9001;HTML_END\x07\n"`)
+	if ev := c.ExpectEvent("widget-evicted", testshell.DefaultTimeout); ev["id"] != float64(1) {
+		t.Errorf("eviction event = %v, want id 1", ev)
+	}
+
+	for _, tt := range []struct {
+		path   string
+		status int
+		code   protocol.ErrorCode
+	}{
+		{"/htmlwidget/1", http.StatusGone, protocol.ErrWidgetExpired},
+		{"/htmlwidget/1/fresh", http.StatusGone, protocol.ErrWidgetExpired},
+		{"/htmlwidget/3", http.StatusNotFound, protocol.ErrWidgetNotFound},
+	} {
+		resp, err := http.Get(ts.URL + tt.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code := errorCode(t, resp); resp.StatusCode != tt.status || code != tt.code {
+			t.Errorf("GET %s: %d %s, want %d %s", tt.path, resp.StatusCode, code, tt.status, tt.code)
+		}
+	}
+
+	st := getStatus(t, ts.URL).WidgetStore
+	if st.Widgets != 1 || st.Bytes != len("<b>two</b>") || st.MaxWidgets != 1 || st.Evicted != 1 {
+		t.Errorf("widget_store = %+v", st)
+	}
+}
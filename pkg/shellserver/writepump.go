@@ -0,0 +1,145 @@
+package shellserver
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+var (
+	flagClientQueue = Flags.Int("client-queue", 256, "messages a websocket client may fall behind before -slow-client applies")
+	flagSlowClient  = Flags.String("slow-client", "drop", `what happens to a client more than -client-queue messages behind: "drop" its oldest messages, telling it how many bytes it lost, or "disconnect" it`)
+)
+
+// clientWriteTimeout bounds each websocket write; a client that can't
+// take a message in that long is disconnected.
+const clientWriteTimeout = 10 * time.Second
+
+// clientCloseTimeout bounds how long disconnecting a client waits for
+// its queue, such as a close frame, to be written.
+const clientCloseTimeout = time.Second
+
+// outbound is a message waiting in a client's queue.
+type outbound struct {
+	msgType int
+	data    []byte
+	queued  time.Time
+	written func(d time.Duration, ok bool) // if set, told how long after queued the write finished
+}
+
+// writePump owns the writes to one websocket connection. Messages are
+// queued and written by a goroutine of the pump's own, so a slow client
+// holds up neither the PTY nor the other clients. A write that fails
+// closes the connection, which ends its read loop, which unregisters it.
+type writePump struct {
+	conn       *websocket.Conn
+	queue      chan outbound
+	done       chan struct{}
+	disconnect bool         // a full queue disconnects the client instead of dropping
+	dropped    atomic.Int64 // bytes dropped since the client was last told
+	overflowed atomic.Bool  // the client was disconnected for falling behind
+
+	mu     sync.Mutex // guards sending on queue against closing it
+	closed bool
+}
+
+// newWritePump starts a pump for conn queueing up to n messages.
+func newWritePump(conn *websocket.Conn, n int, disconnect bool) *writePump {
+	p := &writePump{conn: conn, queue: make(chan outbound, max(n, 1)), done: make(chan struct{}), disconnect: disconnect}
+	go p.run()
+	return p
+}
+
+func (p *writePump) run() {
+	defer close(p.done)
+	failed := false
+	for msg := range p.queue {
+		if !failed {
+			if n := p.dropped.Swap(0); n > 0 {
+				marker, _ := json.Marshal(map[string]any{"kind": "dropped", "bytes": n})
+				failed = p.write(websocket.TextMessage, marker) != nil
+			}
+		}
+		if !failed {
+			if err := p.write(msg.msgType, msg.data); err != nil {
+				log.Printf("websocket write error: %v", err)
+				p.conn.Close()
+				failed = true
+			}
+		}
+		// Messages after a failure are only drained, so their senders hear
+		if msg.written != nil {
+			msg.written(time.Since(msg.queued), !failed)
+		}
+	}
+}
+
+func (p *writePump) write(msgType int, data []byte) error {
+	deadline := time.Now().Add(clientWriteTimeout)
+	if msgType == websocket.CloseMessage {
+		return p.conn.WriteControl(msgType, data, deadline)
+	}
+	p.conn.SetWriteDeadline(deadline)
+	return p.conn.WriteMessage(msgType, data)
+}
+
+// send queues a message without blocking. When the queue is full the
+// oldest message is dropped, or with -slow-client=disconnect the client
+// is disconnected.
+func (p *writePump) send(msg outbound) {
+	msg.queued = time.Now()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		if msg.written != nil {
+			msg.written(0, false)
+		}
+		return
+	}
+	for {
+		select {
+		case p.queue <- msg:
+			return
+		default:
+		}
+		if p.disconnect {
+			if !p.overflowed.Swap(true) {
+				log.Printf("websocket client %v more than %d messages behind; disconnecting", p.conn.RemoteAddr(), cap(p.queue))
+				p.conn.Close()
+			}
+			if msg.written != nil {
+				msg.written(0, false)
+			}
+			return
+		}
+		select {
+		case old := <-p.queue:
+			p.dropped.Add(int64(len(old.data)))
+			if old.written != nil {
+				old.written(0, false)
+			}
+		default:
+		}
+	}
+}
+
+// close stops taking messages and closes the connection once what is
+// queued has been written, or after clientCloseTimeout.
+func (p *writePump) close() {
+	p.mu.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.queue)
+	}
+	p.mu.Unlock()
+
+	select {
+	case <-p.done:
+	case <-time.After(clientCloseTimeout):
+	}
+	p.conn.Close()
+}
@@ -0,0 +1,100 @@
+package shellserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// websocketPair returns the server and client ends of a websocket.
+func websocketPair(t *testing.T) (server, client *websocket.Conn) {
+	t.Helper()
+	conns := make(chan *websocket.Conn, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := wsUpgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		conns <- conn
+	}))
+	t.Cleanup(ts.Close)
+	client, _, err := websocket.DefaultDialer.Dial("ws://"+strings.TrimPrefix(ts.URL, "http://"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	server = <-conns
+	t.Cleanup(func() { server.Close() })
+	return server, client
+}
+
+func TestWritePumpDropsOldest(t *testing.T) {
+	server, client := websocketPair(t)
+	// Not running yet, so the queue fills
+	p := &writePump{conn: server, queue: make(chan outbound, 2), done: make(chan struct{})}
+	for _, msg := range []string{"one", "two", "three"} {
+		p.send(outbound{msgType: websocket.BinaryMessage, data: []byte(msg)})
+	}
+	go p.run()
+
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, data, err := client.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var marker map[string]any
+	if json.Unmarshal(data, &marker) != nil || marker["kind"] != "dropped" || marker["bytes"] != float64(len("one")) {
+		t.Errorf("first message = %s, want a dropped marker for 3 bytes", data)
+	}
+	for _, want := range []string{"two", "three"} {
+		if _, data, err := client.ReadMessage(); err != nil || string(data) != want {
+			t.Errorf("message = %q, %v, want %q", data, err, want)
+		}
+	}
+	p.close()
+}
+
+func TestWritePumpDisconnectsSlowClient(t *testing.T) {
+	server, client := websocketPair(t)
+	p := &writePump{conn: server, queue: make(chan outbound, 1), done: make(chan struct{}), disconnect: true}
+	p.send(outbound{msgType: websocket.BinaryMessage, data: []byte("one")})
+	p.send(outbound{msgType: websocket.BinaryMessage, data: []byte("two")})
+	go p.run()
+
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, _, err := client.ReadMessage(); err == nil || !p.overflowed.Load() {
+		t.Errorf("read = %v, overflowed %v, want the connection closed", err, p.overflowed.Load())
+	}
+	p.close()
+}
+
+func TestWritePumpReportsWrites(t *testing.T) {
+	server, client := websocketPair(t)
+	p := newWritePump(server, 4, false)
+	done := make(chan bool, 1)
+	p.send(outbound{msgType: websocket.BinaryMessage, data: []byte("x"), written: func(d time.Duration, ok bool) { done <- ok }})
+	if _, data, err := client.ReadMessage(); err != nil || string(data) != "x" {
+		t.Fatalf("message = %q, %v", data, err)
+	}
+	select {
+	case ok := <-done:
+		if !ok {
+			t.Error("written reported a failed write")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("written never called")
+	}
+
+	// After close, a send is refused at once
+	p.close()
+	p.send(outbound{msgType: websocket.BinaryMessage, data: []byte("y"), written: func(d time.Duration, ok bool) { done <- ok }})
+	if ok := <-done; ok {
+		t.Error("send after close reported written")
+	}
+}
@@ -0,0 +1,249 @@
+// Package widgetcli gives goshell's HTML-aware tools a common command
+// line: --describe prints a one-line JSON description for the command
+// palette, and --help renders an HTML help card when running inside
+// goshell, falling back to plain text everywhere else.
+package widgetcli
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+
+	"shellserver/internal/styles"
+)
+
+// Example is a sample invocation shown in help output. Args follow the
+// tool name; in goshell each example gets a button that runs it.
+type Example struct {
+	Args        []string
+	Description string
+}
+
+// Tool describes one command-line tool.
+type Tool struct {
+	Name     string
+	Summary  string // one line, no trailing period
+	Usage    string // arguments after the flags, e.g. "[directory]"
+	Examples []Example
+
+	// Flags defaults to flag.CommandLine.
+	Flags *flag.FlagSet
+}
+
+// Flag is one flag in a Description.
+type Flag struct {
+	Name    string `json:"name"`
+	Type    string `json:"type"` // "bool", "int", "duration", "string", ...
+	Default string `json:"default"`
+	Usage   string `json:"usage"`
+}
+
+// Description is what --describe prints.
+type Description struct {
+	Name    string `json:"name"`
+	Summary string `json:"summary"`
+	Flags   []Flag `json:"flags"`
+}
+
+func (t *Tool) flags() *flag.FlagSet {
+	if t.Flags == nil {
+		return flag.CommandLine
+	}
+	return t.Flags
+}
+
+// Describe lists the tool's flags in the order flag.VisitAll visits them,
+// which is sorted by name. The --describe flag itself is left out.
+func (t *Tool) Describe() Description {
+	d := Description{Name: t.Name, Summary: t.Summary, Flags: []Flag{}}
+	t.flags().VisitAll(func(f *flag.Flag) {
+		if f.Name == "describe" {
+			return
+		}
+		d.Flags = append(d.Flags, Flag{
+			Name:    f.Name,
+			Type:    flagType(f),
+			Default: f.DefValue,
+			Usage:   f.Usage,
+		})
+	})
+	return d
+}
+
+// flagType names the kind of value a flag takes.
+func flagType(f *flag.Flag) string {
+	if b, ok := f.Value.(interface{ IsBoolFlag() bool }); ok && b.IsBoolFlag() {
+		return "bool"
+	}
+	if g, ok := f.Value.(flag.Getter); ok {
+		switch g.Get().(type) {
+		case int, int64, uint, uint64:
+			return "int"
+		case float64:
+			return "float"
+		case fmt.Stringer: // time.Duration
+			return "duration"
+		}
+	}
+	return "string"
+}
+
+// Parse registers --describe, then parses args (normally os.Args[1:]).
+// It prints the description or help and exits for --describe and --help,
+// and reports other errors with plain usage on stderr, exiting 2.
+func (t *Tool) Parse(args []string) {
+	fs := t.flags()
+	describe := fs.Bool("describe", false, "print a one-line JSON description of this tool and exit")
+	fs.Init(t.Name, flag.ContinueOnError)
+	fs.Usage = func() {} // help is written below, once we know what was asked for
+	fs.SetOutput(os.Stderr)
+
+	err := fs.Parse(args)
+	switch {
+	case errors.Is(err, flag.ErrHelp):
+		if InGoshell() {
+			fmt.Print(styles.HTMLStart)
+			fmt.Print(t.HelpHTML())
+			fmt.Println(styles.HTMLEnd)
+		} else {
+			t.WriteHelp(os.Stdout)
+		}
+		os.Exit(0)
+	case err != nil:
+		t.WriteHelp(os.Stderr)
+		os.Exit(2)
+	case *describe:
+		json.NewEncoder(os.Stdout).Encode(t.Describe())
+		os.Exit(0)
+	}
+}
+
+// InGoshell reports whether the tool is running in a goshell session,
+// which can render HTML.
+func InGoshell() bool {
+	return os.Getenv("GOSHELL_HOME") != ""
+}
+
+// WriteHelp writes plain-text help: usage, flags and examples.
+func (t *Tool) WriteHelp(w io.Writer) {
+	fmt.Fprintf(w, "%s - %s\n\nUsage: %s [flags] %s\n\nFlags:\n", t.Name, t.Summary, t.Name, t.Usage)
+	fs := t.flags()
+	fs.SetOutput(w)
+	fs.PrintDefaults()
+	fs.SetOutput(os.Stderr)
+	if len(t.Examples) > 0 {
+		fmt.Fprintln(w, "\nExamples:")
+		for _, ex := range t.Examples {
+			fmt.Fprintf(w, "  %s\n      %s\n", t.displayCommand(ex), ex.Description)
+		}
+	}
+}
+
+// HelpHTML renders the help card: a flag table and examples with buttons
+// that run them.
+func (t *Tool) HelpHTML() string {
+	var b strings.Builder
+	b.WriteString(`<style>`)
+	b.WriteString(styles.BaseCSS())
+	b.WriteString(helpCSS)
+	b.WriteString(`</style>
+<div class="shell-container widgetcli-help">
+<div class="shell-header">
+<div class="shell-title">` + styles.HTMLEscape(t.Name) + `</div>
+<div class="shell-meta">` + styles.HTMLEscape(t.Summary) + `</div>
+</div>
+<div class="widgetcli-usage"><code>` + styles.HTMLEscape(t.Name+" [flags] "+t.Usage) + `</code></div>
+<table class="widgetcli-flags">
+<thead><tr><th scope="col">Flag</th><th scope="col">Default</th><th scope="col">Description</th></tr></thead>
+<tbody>
+`)
+	for _, f := range t.Describe().Flags {
+		name := "-" + f.Name
+		if f.Type != "bool" {
+			name += " " + f.Type
+		}
+		fmt.Fprintf(&b, "<tr><td><code>%s</code></td><td><code>%s</code></td><td>%s</td></tr>\n",
+			styles.HTMLEscape(name), styles.HTMLEscape(f.Default), styles.HTMLEscape(f.Usage))
+	}
+	b.WriteString("</tbody>\n</table>\n")
+
+	if len(t.Examples) > 0 {
+		b.WriteString(`<div class="widgetcli-examples">` + "\n")
+		for _, ex := range t.Examples {
+			fmt.Fprintf(&b, `<div class="widgetcli-example"><button type="button" class="shell-sort-btn" onclick="%s">Run</button> <code>%s</code> <span>%s</span></div>`+"\n",
+				runCommandJS(t.Command(ex)), styles.HTMLEscape(t.displayCommand(ex)), styles.HTMLEscape(ex.Description))
+		}
+		b.WriteString("</div>\n")
+	}
+	b.WriteString("</div>\n")
+	return b.String()
+}
+
+// plainFlag matches flags that need no quoting, in the form goshell's
+// default trusted-command pattern accepts.
+var plainFlag = regexp.MustCompile(`^-[A-Za-z]+(=false)?$`)
+
+// Command returns the shell command that runs ex: the tool's absolute
+// path and every argument other than bare flags single-quoted.
+func (t *Tool) Command(ex Example) string {
+	exePath, err := os.Executable()
+	if err != nil {
+		exePath = t.Name
+	}
+	cmd := styles.ShellQuote(exePath)
+	for _, arg := range ex.Args {
+		if plainFlag.MatchString(arg) {
+			cmd += " " + arg
+		} else {
+			cmd += " " + styles.ShellQuote(arg)
+		}
+	}
+	return cmd
+}
+
+// displayCommand is ex as a reader would type it.
+func (t *Tool) displayCommand(ex Example) string {
+	return strings.Join(append([]string{t.Name}, ex.Args...), " ")
+}
+
+// runCommandJS is an onclick attribute value calling runCommand(cmd).
+// The command is a JSON string literal so quotes and backslashes survive.
+func runCommandJS(cmd string) string {
+	lit, _ := json.Marshal(cmd)
+	return styles.HTMLEscape("runCommand(" + string(lit) + ")")
+}
+
+var helpCSS = `
+.widgetcli-usage {
+	margin: 8px 0;
+	font-size: 12px;
+}
+.widgetcli-flags {
+	border-collapse: collapse;
+	font-size: 12px;
+	margin: 8px 0;
+}
+.widgetcli-flags th,
+.widgetcli-flags td {
+	text-align: left;
+	padding: 2px 12px 2px 0;
+	vertical-align: top;
+}
+.widgetcli-flags th {
+	color: ` + styles.Colors.TextGray + `;
+	font-weight: 400;
+}
+.widgetcli-example {
+	margin: 4px 0;
+	font-size: 12px;
+}
+.widgetcli-example span {
+	color: ` + styles.Colors.TextGray + `;
+	margin-left: 8px;
+}
+`
@@ -0,0 +1,103 @@
+package widgetcli
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"html"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"shellserver/internal/styles"
+)
+
+func testTool() *Tool {
+	fs := flag.NewFlagSet("demo", flag.ContinueOnError)
+	fs.Bool("l", false, "long format")
+	fs.Int("d", -1, "max depth")
+	fs.Duration("every", time.Second, "refresh interval")
+	fs.String("key", "", "widget key")
+	fs.Bool("describe", false, "print a description") // as registered by Parse
+	return &Tool{
+		Name:    "demo",
+		Summary: "demonstrate things",
+		Usage:   "[directory]",
+		Flags:   fs,
+		Examples: []Example{
+			{Args: []string{"-l", "-d", "2"}, Description: "two levels"},
+			{Args: []string{"-si=false", `/tmp/it's a "dir" \ here`}, Description: "awkward path"},
+		},
+	}
+}
+
+func TestDescribeJSON(t *testing.T) {
+	data, err := json.Marshal(testTool().Describe())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]any
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]any{
+		"name":    "demo",
+		"summary": "demonstrate things",
+		"flags": []any{
+			map[string]any{"name": "d", "type": "int", "default": "-1", "usage": "max depth"},
+			map[string]any{"name": "every", "type": "duration", "default": "1s", "usage": "refresh interval"},
+			map[string]any{"name": "key", "type": "string", "default": "", "usage": "widget key"},
+			map[string]any{"name": "l", "type": "bool", "default": "false", "usage": "long format"},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("--describe = %s\nwant %v", data, want)
+	}
+	if bytes.Contains(data, []byte("\n")) {
+		t.Error("description spans more than one line")
+	}
+}
+
+func TestHelpHTMLExampleButtons(t *testing.T) {
+	tool := testTool()
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		styles.ShellQuote(exe) + ` -l -d '2'`,
+		styles.ShellQuote(exe) + ` -si=false '/tmp/it'"'"'s a "dir" \ here'`,
+	}
+
+	onclick := regexp.MustCompile(`onclick="([^"]*)"`)
+	var got []string
+	for _, m := range onclick.FindAllStringSubmatch(tool.HelpHTML(), -1) {
+		js := html.UnescapeString(m[1])
+		lit, ok := strings.CutPrefix(js, "runCommand(")
+		lit, ok2 := strings.CutSuffix(lit, ")")
+		var cmd string
+		if !ok || !ok2 || json.Unmarshal([]byte(lit), &cmd) != nil {
+			t.Fatalf("onclick %q is not runCommand(<string>)", js)
+		}
+		got = append(got, cmd)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("buttons run %q\nwant %q", got, want)
+	}
+}
+
+func TestWriteHelp(t *testing.T) {
+	var b bytes.Buffer
+	testTool().WriteHelp(&b)
+	for _, want := range []string{"Usage: demo [flags] [directory]", "-every duration", "demo -l -d 2\n      two levels"} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("help lacks %q:\n%s", want, b.String())
+		}
+	}
+	if strings.Contains(b.String(), "<") {
+		t.Error("plain help contains markup")
+	}
+}
@@ -1,8 +1,30 @@
 // REST API calls for shell control
 
+import { authFetch } from './auth.js';
+
+// Build an Error from a failed response. API errors carry a JSON envelope,
+// {"error":{"code":...,"message":...}}; the code is kept on the Error.
+export async function responseError(response) {
+    let code = 'http_' + response.status;
+    let message = `${response.status} ${response.statusText}`;
+    try {
+        const body = await response.json();
+        if (body && body.error) {
+            code = body.error.code;
+            message = body.error.message;
+        }
+    } catch (e) {
+        // not an envelope; keep the status line
+    }
+    const err = new Error(message);
+    err.code = code;
+    err.status = response.status;
+    return err;
+}
+
 export async function resize(rows, cols) {
     try {
-        await fetch('/resize', {
+        await authFetch('resize', {
             method: 'POST',
             headers: { 'Content-Type': 'application/json' },
             body: JSON.stringify({ rows, cols })
@@ -14,7 +36,7 @@ export async function resize(rows, cols) {
 
 export async function restart() {
     try {
-        const response = await fetch('/restart', { method: 'POST' });
+        const response = await authFetch('restart', { method: 'POST' });
         return response.ok;
     } catch (error) {
         console.error('Restart error:', error);
@@ -22,17 +44,59 @@ export async function restart() {
     }
 }
 
-export async function runCommand(cmd) {
+// With { detached: true } the server runs cmd outside the terminal, under a
+// timeout, and delivers its widgets when it finishes. The action is posted
+// for the widget on show, so a server with -widget-cmd-signed can check
+// the command is one that widget carries. A command clicked while another
+// runs is queued until the prompt rather than typed into its input
+export async function runCommand(cmd, { detached = false } = {}) {
+    const widget = (widgetErrorSource && widgetErrorSource()) || 'lsh-sort';
     try {
-        await fetch('/widget/lsh-sort/action', {
+        const response = await authFetch(`widget/${widget}/action?queue=1`, {
             method: 'POST',
             headers: { 'Content-Type': 'application/json' },
             body: JSON.stringify({
                 type: 'shell',
-                cmd: cmd
+                cmd: cmd,
+                detached: detached
             })
         });
+        if (!response.ok) {
+            const err = await responseError(response);
+            err.message = `runCommand failed: ${err.message}`;
+            throw err;
+        }
     } catch (err) {
         console.error('Failed to run command:', err);
+        if (widgetErrorSource) {
+            const id = widgetErrorSource();
+            if (id) {
+                reportWidgetError(id, err, { cmd });
+            }
+        }
+    }
+}
+
+let widgetErrorSource = null;
+
+// Set the function that names the widget runCommand failures are reported against
+export function setWidgetErrorSource(fn) {
+    widgetErrorSource = fn;
+}
+
+// Report an error raised by a widget so it's recorded server-side
+export async function reportWidgetError(widgetId, err, context = {}) {
+    try {
+        await authFetch(`widget/${widgetId}/error`, {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({
+                message: String(err && err.message ? err.message : err),
+                stack: err && err.stack ? String(err.stack) : '',
+                context
+            })
+        });
+    } catch (e) {
+        console.error('Failed to report widget error:', e);
     }
 }
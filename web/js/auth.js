@@ -0,0 +1,39 @@
+// Session token authentication. goshell prints a URL carrying the token in
+// its fragment, #token=..., which the browser never sends to the server.
+// The token is kept for the tab and the fragment removed from the address bar.
+
+const TOKEN_KEY = 'goshell-token';
+
+// Take the token from the URL fragment, if there is one
+export function init() {
+    const params = new URLSearchParams(location.hash.slice(1));
+    const token = params.get('token');
+    if (token) {
+        sessionStorage.setItem(TOKEN_KEY, token);
+        history.replaceState(null, '', location.pathname + location.search);
+    }
+}
+
+export function token() {
+    return sessionStorage.getItem(TOKEN_KEY);
+}
+
+// url with the token as ?token=, for websockets, which can't set headers
+export function withToken(url) {
+    const t = token();
+    if (!t) {
+        return url;
+    }
+    return url + (url.includes('?') ? '&' : '?') + 'token=' + encodeURIComponent(t);
+}
+
+// fetch with the token as an Authorization header
+export function authFetch(url, options = {}) {
+    const t = token();
+    if (!t) {
+        return fetch(url, options);
+    }
+    const headers = new Headers(options.headers || {});
+    headers.set('Authorization', 'Bearer ' + t);
+    return fetch(url, { ...options, headers });
+}
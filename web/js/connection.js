@@ -4,10 +4,40 @@ let ws = null;
 let binaryCallback = null;
 let statusCallback = null;
 let htmlCallback = null;
+let htmlUpdateCallback = null;
+let widgetEvictedCallback = null;
 let errorCallback = null;
 let closeCallback = null;
+let resizeCallback = null;
+let restartedCallback = null;
+let clipboardCallback = null;
+let titleCallback = null;
+let bellCallback = null;
+let finishedCallback = null;
+let idleWarningCallback = null;
+let wantedSize = null; // this page's terminal size, sent again on every (re)connect
+let serverCapabilities = {};  // from the ready message
+let ptyMode = { predict: false }; // terminal settings, from ready and ptymode events
+let outputOffset = null; // how much output this page's terminal has, from ready and offset events
+let outputEpoch = null; // the server process outputOffset counts in, from ready
+let connectURL = null; // what connect was last given, for reconnecting
+
+const RESUME_KEY = 'goshell-resume-token';
 
 export function connect(url) {
+    connectURL = url;
+    // Present the previous connection's resume token so a reload keeps
+    // this tab's client identity and role
+    const resumeToken = sessionStorage.getItem(RESUME_KEY);
+    if (resumeToken) {
+        url += (url.includes('?') ? '&' : '?') + 'resume=' + encodeURIComponent(resumeToken);
+    }
+    // Ask for output offsets, and on a reconnect only the output missed
+    url += (url.includes('?') ? '&' : '?') + 'offsets=1';
+    if (outputOffset !== null && outputEpoch !== null) {
+        url += '&epoch=' + encodeURIComponent(outputEpoch) + '&since=' + outputOffset;
+    }
+
     ws = new WebSocket(url);
     ws.binaryType = 'arraybuffer';
 
@@ -20,10 +50,53 @@ export function connect(url) {
             // Text message - status update or HTML notification
             try {
                 const msg = JSON.parse(event.data);
-                if (msg.kind === 'status' && statusCallback) {
-                    statusCallback(msg.state);
+                if (msg.kind === 'ready') {
+                    serverCapabilities = msg.capabilities || {};
+                    ptyMode = msg.pty_mode || ptyMode;
+                    if (msg.resume_token) {
+                        sessionStorage.setItem(RESUME_KEY, msg.resume_token);
+                    }
+                    outputOffset = msg.offset ?? null;
+                    outputEpoch = msg.epoch ?? null;
+                    if (titleCallback) {
+                        titleCallback(msg.title || '');
+                    }
+                    if (wantedSize) {
+                        send(JSON.stringify({ kind: 'resize', ...wantedSize }));
+                    }
+                } else if (msg.kind === 'offset') {
+                    outputOffset = msg.offset;
+                } else if (msg.kind === 'input-denied') {
+                    console.warn(`input ignored: ${msg.owner} has input control`);
+                } else if (msg.kind === 'widget-cmd-rejected') {
+                    console.warn(`server refused widget command ${JSON.stringify(msg.cmd)}: ${msg.reason}`);
+                } else if (msg.kind === 'dropped') {
+                    console.warn(`server dropped ${msg.bytes} bytes this client fell behind on; reconnecting`);
+                    resync();
+                } else if (msg.kind === 'idle-warning' && idleWarningCallback) {
+                    idleWarningCallback(msg.seconds, msg.action);
+                } else if (msg.kind === 'bell' && bellCallback) {
+                    bellCallback(msg.ts);
+                } else if (msg.kind === 'finished' && finishedCallback) {
+                    finishedCallback(msg.command, msg.duration_ms);
+                } else if (msg.kind === 'title' && titleCallback) {
+                    titleCallback(msg.title);
+                } else if (msg.kind === 'clipboard' && clipboardCallback) {
+                    clipboardCallback(msg.data);
+                } else if (msg.kind === 'restarted' && restartedCallback) {
+                    restartedCallback(msg.cleared);
+                } else if (msg.kind === 'resize' && resizeCallback) {
+                    resizeCallback(msg.rows, msg.cols);
+                } else if (msg.kind === 'ptymode') {
+                    ptyMode = msg;
+                } else if (msg.kind === 'status' && statusCallback) {
+                    statusCallback(msg.state, msg.command || msg.process);
                 } else if (msg.kind === 'html' && htmlCallback) {
-                    htmlCallback(msg.widget_id);
+                    htmlCallback(msg.widget_id, msg.content, msg.version);
+                } else if (msg.kind === 'html-update' && htmlUpdateCallback) {
+                    htmlUpdateCallback(msg.widget_id, msg);
+                } else if (msg.kind === 'widget-evicted' && widgetEvictedCallback) {
+                    widgetEvictedCallback(msg.id);
                 }
             } catch (e) {
                 console.error('Failed to parse message:', e);
@@ -54,12 +127,63 @@ export function connect(url) {
     return ws;
 }
 
+// Reconnect for a snapshot of the screen, which starts with a reset.
+// Output was lost, perhaps in the middle of an escape sequence or with a
+// full-screen program's exit, so what the terminal shows can't be trusted
+// and resuming from the offset would only add to it
+function resync() {
+    const old = ws;
+    outputOffset = null;
+    old.onmessage = null;
+    old.onclose = () => {
+        console.log('WebSocket reconnecting after dropped output');
+        connect(connectURL);
+    };
+    old.close();
+}
+
 export function send(data) {
     if (ws && ws.readyState === WebSocket.OPEN) {
         ws.send(data);
     }
 }
 
+// Tell the server this page's terminal size; it answers every client
+// with the size the shell's terminal takes
+export function resize(rows, cols) {
+    wantedSize = { rows, cols };
+    send(JSON.stringify({ kind: 'resize', rows, cols }));
+}
+
+export function onIdleWarning(callback) {
+    idleWarningCallback = callback;
+}
+
+export function onBell(callback) {
+    bellCallback = callback;
+}
+
+// A command that ran longer than -notify-min-duration finished
+export function onFinished(callback) {
+    finishedCallback = callback;
+}
+
+export function onTitle(callback) {
+    titleCallback = callback;
+}
+
+export function onClipboard(callback) {
+    clipboardCallback = callback;
+}
+
+export function onRestarted(callback) {
+    restartedCallback = callback;
+}
+
+export function onResize(callback) {
+    resizeCallback = callback;
+}
+
 export function onBinary(callback) {
     binaryCallback = callback;
 }
@@ -72,6 +196,14 @@ export function onHtml(callback) {
     htmlCallback = callback;
 }
 
+export function onHtmlUpdate(callback) {
+    htmlUpdateCallback = callback;
+}
+
+export function onWidgetEvicted(callback) {
+    widgetEvictedCallback = callback;
+}
+
 export function onError(callback) {
     errorCallback = callback;
 }
@@ -80,6 +212,18 @@ export function onClose(callback) {
     closeCallback = callback;
 }
 
+// Optional features the server has enabled, e.g. capabilities().widgets
+export function capabilities() {
+    return serverCapabilities;
+}
+
+// Whether printable keys may be echoed locally before the server's echo
+// arrives: the PTY is echoing a line as it's typed, not reading a
+// password or running a full-screen program
+export function canPredictEcho() {
+    return Boolean(serverCapabilities['predictive-echo'] && ptyMode.predict);
+}
+
 export function isOpen() {
     return ws && ws.readyState === WebSocket.OPEN;
 }
@@ -4,6 +4,11 @@ import * as splitter from './splitter.js';
 import { TokenGrid } from './token-grid.js';
 import { TreeTable } from './tree-table.js';
 import { StickySelectionManager } from './selection-manager.js';
+import { applyPatch } from './line-patch.js';
+import { runCommand, responseError } from './api.js';
+import { authFetch, withToken } from './auth.js';
+
+const FRESHNESS_INTERVAL = 10000; // ms between staleness checks
 
 let panelEl = null;
 let toggleBtnEl = null;
@@ -12,6 +17,10 @@ let exitCallback = null;      // Called when user exits panel focus
 let actionCallback = null;    // Called when user performs action (e.g., insert to terminal)
 let activeGrid = null;        // Current TokenGrid instance
 let activeTable = null;       // Current TreeTable instance
+let currentWidgetId = null;   // ID of the widget being displayed
+let currentHtml = null;       // Its content as served, for applying patches
+let currentVersion = null;    // Its version, from X-Widget-Version
+let freshnessTimer = null;    // Polls /htmlwidget/{id}/fresh for marked widgets
 
 export function init(panel, splitterEl, toggleBtn, options = {}) {
     panelEl = panel;
@@ -32,6 +41,14 @@ export function init(panel, splitterEl, toggleBtn, options = {}) {
 
     // Toggle button click handler
     toggleBtnEl.addEventListener('click', toggle);
+
+    // Confirmation widgets post their answer without leaving the page
+    panelEl.addEventListener('submit', submitConfirm);
+    panelEl.addEventListener('submit', submitWidgetAction);
+
+    // The page's policy keeps widgets' inline handlers from running
+    panelEl.addEventListener('click', runWidgetHandler);
+    panelEl.addEventListener('click', downloadFile);
 }
 
 export function show(html, animate = true) {
@@ -39,6 +56,7 @@ export function show(html, animate = true) {
         panelEl.innerHTML = html;
         // Initialize grid after content is set
         initializeGrid();
+        watchFreshness();
     }
 
     if (animate) {
@@ -90,16 +108,277 @@ export function isVisible() {
     return panelEl.classList.contains('visible');
 }
 
+// The panel inserts the widget's bare fragment; htmlwidget/{id} without
+// ?fragment=1 is a page of its own, for opening in a tab
 export async function loadWidget(widgetId) {
     try {
-        const response = await fetch(`/htmlwidget/${widgetId}`);
+        const response = await authFetch(`htmlwidget/${widgetId}?fragment=1`);
+        if (response.status === 410) {
+            const err = await responseError(response);
+            showWidget(widgetId, '', null);
+            showExpiredNote(err.message);
+            return;
+        }
+        if (!response.ok) {
+            throw await responseError(response);
+        }
         const html = await response.text();
-        show(html);
+        showWidget(widgetId, html, Number(response.headers.get('X-Widget-Version')));
+        if (response.headers.get('X-Widget-Stale')) {
+            showRestoredNote();
+        }
     } catch (err) {
         console.error('Failed to load HTML widget:', err);
     }
 }
 
+// Show a widget whose content the client already has
+export function showWidget(widgetId, html, version) {
+    currentWidgetId = widgetId;
+    currentHtml = html;
+    currentVersion = version;
+    show(html);
+}
+
+// Answer a confirmation widget. The server replaces the widget with the
+// outcome, which arrives as an ordinary update.
+async function submitConfirm(event) {
+    const form = event.target.closest('form.confirm-form');
+    if (!form) {
+        return;
+    }
+    event.preventDefault();
+    const approve = event.submitter ? event.submitter.value === 'true' : false;
+    form.querySelectorAll('button').forEach(b => { b.disabled = true; });
+    try {
+        const response = await authFetch(form.dataset.confirm, {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({ approve })
+        });
+        if (!response.ok) {
+            throw await responseError(response);
+        }
+    } catch (err) {
+        console.error('Failed to answer confirmation:', err);
+    }
+}
+
+// Send an internal widget action, such as the tour's "don't show again",
+// named by the form's data-action to the /widget/{name}/action it targets.
+async function submitWidgetAction(event) {
+    const form = event.target.closest('form.widget-action-form');
+    if (!form) {
+        return;
+    }
+    event.preventDefault();
+    form.querySelectorAll('button').forEach(b => { b.disabled = true; });
+    try {
+        const response = await authFetch(form.getAttribute('action'), {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({ type: 'internal', action: form.dataset.action })
+        });
+        if (!response.ok) {
+            throw await responseError(response);
+        }
+    } catch (err) {
+        console.error('Failed to send widget action:', err);
+    }
+}
+
+// A runCommand("...") handler with a string literal, as goshell's tools
+// write them, optionally with {detached: true}
+const RUN_COMMAND_CALL = /^\s*runCommand\(\s*("(?:[^"\\]|\\.)*")\s*(,\s*\{\s*detached\s*:\s*true\s*\}\s*)?\)\s*;?\s*$/;
+
+// Carry out the inline handlers goshell's tools write, which the page's
+// Content-Security-Policy stops along with any other: a tree toggle opens
+// or closes its subtree, and runCommand("...") runs the command. Any other
+// handler, and any script in a widget, stays dead.
+function runWidgetHandler(event) {
+    const el = event.target.closest('[onclick]');
+    if (!el || !panelEl.contains(el)) {
+        return;
+    }
+    if (el.classList.contains('tree-toggle')) {
+        toggleSubtree(el);
+        return;
+    }
+    const m = RUN_COMMAND_CALL.exec(el.getAttribute('onclick'));
+    if (!m) {
+        return;
+    }
+    let cmd;
+    try {
+        cmd = JSON.parse(m[1]);
+    } catch (err) {
+        return;
+    }
+    runCommand(cmd, { detached: Boolean(m[2]) });
+}
+
+// Follow a download link, such as lsh's, with the session token, which a
+// plain link can't carry in a header
+function downloadFile(event) {
+    const link = event.target.closest('a.shell-download');
+    if (!link || !panelEl.contains(link)) {
+        return;
+    }
+    event.preventDefault();
+    event.stopPropagation();
+    const a = document.createElement('a');
+    a.href = withToken(link.href);
+    a.download = '';
+    a.click();
+}
+
+// Open or close the subtree of a tree table's toggle button, as its
+// handler would
+function toggleSubtree(button) {
+    const i = button.id.lastIndexOf('-toggle-');
+    const children = i >= 0 && document.getElementById(button.id.slice(0, i) + '-children-' + button.id.slice(i + 8));
+    if (!children) {
+        return;
+    }
+    const open = !children.classList.contains('expanded');
+    children.classList.toggle('expanded', open);
+    button.textContent = open ? '▼' : '▶';
+    button.parentNode.setAttribute('aria-expanded', open);
+}
+
+// Bring the widget up to date if it is the one on display, without
+// animating the panel or stealing focus. The update's patch is applied
+// when it starts from the version shown; otherwise the server is asked
+// for a patch from that version and sends the whole widget if it has none.
+export async function refreshWidget(widgetId, update = {}) {
+    if (!isVisible() || String(currentWidgetId) !== String(widgetId)) {
+        return;
+    }
+    if (update.patch && update.base === currentVersion) {
+        const html = applyPatch(currentHtml, update.patch);
+        if (html !== null) {
+            currentHtml = html;
+            currentVersion = update.version;
+            show(html, false);
+            return;
+        }
+    }
+    try {
+        const response = await authFetch(`htmlwidget/${widgetId}?fragment=1&base=${currentVersion}`);
+        if (String(currentWidgetId) !== String(widgetId)) {
+            return;
+        }
+        if (!response.ok) {
+            throw await responseError(response);
+        }
+        let html;
+        let version;
+        if (response.headers.get('Content-Type') === 'application/json') {
+            const p = await response.json();
+            html = applyPatch(currentHtml, p.patch);
+            version = p.version;
+            if (html === null) {
+                currentVersion = null;
+                return refreshWidget(widgetId);
+            }
+        } else {
+            html = await response.text();
+            version = Number(response.headers.get('X-Widget-Version'));
+        }
+        currentHtml = html;
+        currentVersion = version;
+        show(html, false);
+    } catch (err) {
+        console.error('Failed to refresh HTML widget:', err);
+    }
+}
+
+// Widgets built from something that can change under them, such as a
+// directory listing, carry a freshness marker. While one is on display the
+// server is asked periodically whether it is still current; once it isn't,
+// a banner offers to re-run the command that made it, which replaces the
+// widget in place.
+function watchFreshness() {
+    clearInterval(freshnessTimer);
+    freshnessTimer = null;
+    const marker = panelEl.querySelector('meta[name="goshell-freshness"]');
+    if (!marker || currentWidgetId === null) {
+        return;
+    }
+    const widgetId = currentWidgetId;
+    freshnessTimer = setInterval(async () => {
+        if (!isVisible() || currentWidgetId !== widgetId) {
+            return;
+        }
+        try {
+            const response = await authFetch(`htmlwidget/${widgetId}/fresh`);
+            if (!response.ok) {
+                clearInterval(freshnessTimer);
+                return;
+            }
+            const { state } = await response.json();
+            if (state === 'stale' && currentWidgetId === widgetId) {
+                clearInterval(freshnessTimer);
+                showStaleBanner(marker.dataset.refresh);
+            }
+        } catch (err) {
+            console.error('Failed to check widget freshness:', err);
+        }
+    }, FRESHNESS_INTERVAL);
+}
+
+function showStaleBanner(refreshCmd) {
+    if (panelEl.querySelector('.widget-stale-banner')) {
+        return;
+    }
+    const banner = document.createElement('button');
+    banner.type = 'button';
+    banner.className = 'widget-stale-banner';
+    banner.textContent = 'Contents have changed — click to refresh';
+    banner.addEventListener('click', () => {
+        banner.disabled = true;
+        runCommand(refreshCmd).catch(err => {
+            console.error('Failed to refresh stale widget:', err);
+            banner.disabled = false;
+        });
+    });
+    panelEl.prepend(banner);
+}
+
+// A widget kept across a server restart: the shell that made it is gone,
+// so its buttons act on a different one
+function showRestoredNote() {
+    const note = document.createElement('div');
+    note.className = 'widget-restored-note';
+    note.textContent = 'Made by a shell from before goshell restarted';
+    panelEl.prepend(note);
+}
+
+// A widget the server has evicted under its widget limits: what's shown
+// stays, but it can't be refreshed and its actions may be out of date
+function showExpiredNote(message) {
+    if (panelEl.querySelector('.widget-expired-note')) {
+        return;
+    }
+    const note = document.createElement('div');
+    note.className = 'widget-expired-note';
+    note.textContent = message;
+    panelEl.prepend(note);
+}
+
+// Mark the panel when the widget it shows is evicted
+export function widgetEvicted(widgetId) {
+    if (String(currentWidgetId) !== String(widgetId)) {
+        return;
+    }
+    showExpiredNote('This widget has expired; run the command again to see it current');
+}
+
+// ID of the widget currently shown in the panel, or null
+export function currentWidget() {
+    return isVisible() ? currentWidgetId : null;
+}
+
 export function onResize(callback) {
     resizeCallback = callback;
 }
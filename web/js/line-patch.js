@@ -0,0 +1,29 @@
+// Apply line patches from html-update events (see internal/diff)
+
+function splitLines(text) {
+    const lines = text.split(/(?<=\n)/);
+    if (lines.length && lines[lines.length - 1] === '') {
+        lines.pop();
+    }
+    return lines;
+}
+
+// Returns the patched text, or null if the patch doesn't fit
+export function applyPatch(text, patch) {
+    const lines = splitLines(text);
+    const out = [];
+    let i = 0;
+    for (const edit of patch) {
+        if (edit.keep) {
+            if (i + edit.keep > lines.length) return null;
+            out.push(...lines.slice(i, i + edit.keep));
+            i += edit.keep;
+        } else if (edit.del) {
+            if (i + edit.del > lines.length) return null;
+            i += edit.del;
+        } else if (edit.ins) {
+            out.push(...edit.ins);
+        }
+    }
+    return i === lines.length ? out.join('') : null;
+}
@@ -4,6 +4,7 @@ import * as terminal from './terminal.js';
 import * as connection from './connection.js';
 import * as htmlPanel from './html-panel.js';
 import * as api from './api.js';
+import * as auth from './auth.js';
 
 // Fit terminal and send size to backend
 function fitAndResize() {
@@ -11,7 +12,7 @@ function fitAndResize() {
     setTimeout(() => {
         const size = terminal.getSize();
         if (size) {
-            api.resize(size.rows, size.cols);
+            connection.resize(size.rows, size.cols);
         }
     }, 0);
 }
@@ -35,6 +36,8 @@ function init() {
     const restartBtn = document.getElementById('restart-btn');
     const statusEl = document.getElementById('status');
 
+    auth.init();
+
     // Initialize terminal
     terminal.init(terminalEl, {
         onLinkActivate: handleLink
@@ -53,21 +56,107 @@ function init() {
     window.addEventListener('resize', fitAndResize);
 
     // Connect WebSocket
-    connection.connect('ws://127.0.0.1:7777/ws/shell');
+    // Relative to the page, so the UI works wherever the server is mounted
+    const wsURL = new URL('ws/shell', location.href);
+    wsURL.protocol = location.protocol === 'https:' ? 'wss:' : 'ws:';
+    wsURL.hash = '';
+    connection.connect(auth.withToken(wsURL.href));
 
     // Handle terminal output
     connection.onBinary((data) => {
         terminal.write(data);
     });
 
+    // A shell restarted with its scrollback cleared starts on a fresh
+    // terminal, without the old one's modes
+    connection.onRestarted((cleared) => {
+        if (cleared) {
+            terminal.reset();
+        }
+    });
+
+    // The tab is titled as the shell or the program running titles its terminal
+    const defaultTitle = document.title;
+    connection.onTitle((title) => {
+        document.title = title || defaultTitle;
+    });
+
+    // The bell flashes the terminal, or, in a tab out of sight, shows a
+    // notification if the page may
+    connection.onBell(() => {
+        if (document.hidden && window.Notification && Notification.permission === 'granted') {
+            new Notification(document.title, { body: 'Bell' });
+            return;
+        }
+        terminalEl.classList.remove('bell');
+        void terminalEl.offsetWidth; // restart the animation
+        terminalEl.classList.add('bell');
+    });
+    // So does a long command finishing while the tab is out of sight
+    connection.onFinished((command, durationMs) => {
+        if (document.hidden && window.Notification && Notification.permission === 'granted') {
+            const secs = Math.round(durationMs / 1000);
+            new Notification(document.title, { body: `${command || 'Command'} finished after ${secs}s` });
+        }
+    });
+    if (window.Notification && Notification.permission === 'default') {
+        document.addEventListener('click', () => Notification.requestPermission(), { once: true });
+    }
+
+    // Programs in the shell copy with OSC 52. The browser only lets a page
+    // write the clipboard with permission or during a user gesture, so a
+    // copy it refuses waits for the next click
+    let pendingCopy = null;
+    connection.onClipboard(async (text) => {
+        try {
+            await navigator.clipboard.writeText(text);
+            pendingCopy = null;
+        } catch (e) {
+            pendingCopy = text;
+            statusEl.textContent = 'click to copy from the shell';
+        }
+    });
+    document.addEventListener('click', () => {
+        if (pendingCopy !== null) {
+            navigator.clipboard.writeText(pendingCopy).catch((e) => console.warn('clipboard:', e));
+            pendingCopy = null;
+        }
+    }, true);
+
+    // Follow the size the server settles on for the shell's terminal
+    connection.onResize((rows, cols) => {
+        terminal.resize(rows, cols);
+    });
+
     // Handle status updates
-    connection.onStatus((state) => {
-        statusEl.textContent = state;
+    connection.onStatus((state, command) => {
+        statusEl.textContent = command ? `${state}: ${command}` : state;
     });
 
-    // Handle HTML notifications
-    connection.onHtml((widgetId) => {
-        htmlPanel.loadWidget(widgetId);
+    // The server is about to act on an idle session; typing puts it off
+    connection.onIdleWarning((seconds, action) => {
+        const what = action === 'exit' ? 'server stops' : 'shell hibernates';
+        statusEl.textContent = `idle: ${what} in ${seconds}s unless you type`;
+    });
+
+    // Handle HTML notifications; widgets the server makes itself, such as
+    // confirmations, arrive with their content
+    connection.onHtml((widgetId, content, version) => {
+        if (content !== undefined) {
+            htmlPanel.showWidget(widgetId, content, version);
+        } else {
+            htmlPanel.loadWidget(widgetId);
+        }
+    });
+
+    // Reload the panel in place when the widget it shows is replaced
+    connection.onHtmlUpdate((widgetId, update) => {
+        htmlPanel.refreshWidget(widgetId, update);
+    });
+
+    // Note in the panel when the widget it shows has been evicted
+    connection.onWidgetEvicted((widgetId) => {
+        htmlPanel.widgetEvicted(widgetId);
     });
 
     // Handle connection errors
@@ -79,6 +168,15 @@ function init() {
         terminal.write('\r\n\x1b[31mConnection closed\x1b[0m\r\n');
     });
 
+    // Clear the session's unread bell/activity counters whenever the tab is looked at
+    const markSeen = () => {
+        if (document.visibilityState === 'visible') {
+            connection.send(JSON.stringify({ kind: 'seen' }));
+        }
+    };
+    document.addEventListener('visibilitychange', markSeen);
+    window.addEventListener('focus', markSeen);
+
     // Send terminal input to WebSocket
     terminal.onData((data) => {
         connection.send(data);
@@ -88,7 +186,6 @@ function init() {
     restartBtn.addEventListener('click', async () => {
         const success = await api.restart();
         if (success) {
-            terminal.clear();
             fitAndResize();
             console.log('Shell restarted');
         } else {
@@ -96,8 +193,10 @@ function init() {
         }
     });
 
-    // Expose runCommand globally for HTML widgets
-    window.runCommand = api.runCommand;
+    // Report failed widget commands against the widget on show. Widgets'
+    // own script never runs here: the page's policy stops it, and the
+    // panel carries out the runCommand handlers goshell's tools write
+    api.setWidgetErrorSource(htmlPanel.currentWidget);
 
     // Set up HTML panel callbacks for TokenGrid integration
     htmlPanel.setExitCallback(() => {
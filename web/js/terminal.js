@@ -81,6 +81,13 @@ export function clear() {
     }
 }
 
+// Back to a fresh terminal: screen, scrollback and modes
+export function reset() {
+    if (term) {
+        term.reset();
+    }
+}
+
 export function focus() {
     if (term) {
         term.focus();
@@ -93,6 +100,13 @@ export function onData(callback) {
     }
 }
 
+// Match the shell's terminal when it is smaller than this page fits
+export function resize(rows, cols) {
+    if (term && (term.rows !== rows || term.cols !== cols)) {
+        term.resize(cols, rows);
+    }
+}
+
 export function getSize() {
     if (term && term.rows && term.cols) {
         return { rows: term.rows, cols: term.cols };
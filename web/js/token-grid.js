@@ -194,6 +194,7 @@ export class TokenGrid {
 
         this.items.forEach(item => {
             item.el.classList.toggle('selected', selected.has(item.id));
+            item.el.setAttribute('aria-selected', selected.has(item.id));
             item.el.classList.toggle('cursor', item.id === cursor && this.active);
         });
     }
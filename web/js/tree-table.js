@@ -227,6 +227,7 @@ export class TreeTable {
 
         // Expand
         row.childrenEl.classList.add('expanded');
+        row.el.setAttribute('aria-expanded', 'true');
         if (row.toggleEl) {
             row.toggleEl.textContent = '▼';
         }
@@ -240,6 +241,7 @@ export class TreeTable {
         // If expandable and expanded, collapse it
         if (row.expandable && row.childrenEl && row.childrenEl.classList.contains('expanded')) {
             row.childrenEl.classList.remove('expanded');
+            row.el.setAttribute('aria-expanded', 'false');
             if (row.toggleEl) {
                 row.toggleEl.textContent = '▶';
             }
@@ -280,6 +282,7 @@ export class TreeTable {
 
         this.rows.forEach(row => {
             row.el.classList.toggle('selected', selected.has(row.id));
+            row.el.setAttribute('aria-selected', selected.has(row.id));
             row.el.classList.toggle('cursor', row.id === cursor && this.active);
         });
     }
@@ -0,0 +1,10 @@
+// Package web holds goshell's browser UI, built into the server binary so
+// it can run from any directory.
+package web
+
+import "embed"
+
+// Files is the UI: index.html at the root, with css/ and js/ beside it.
+//
+//go:embed index.html css js
+var Files embed.FS